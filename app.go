@@ -51,6 +51,7 @@ import (
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/i18n"
 	"infini.sh/framework/core/keystore"
 	_ "infini.sh/framework/core/logging"
 	"infini.sh/framework/core/logging/logger"
@@ -59,6 +60,7 @@ import (
 	"infini.sh/framework/core/stats"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/bytebufferpool"
+	"infini.sh/framework/modules/pipeline/simulate"
 	_ "infini.sh/framework/modules/queue"
 )
 
@@ -162,7 +164,9 @@ func (app *App) Init(customFunc func()) {
 						}
 					}
 				}}
-			task.RegisterScheduleTask(task1)
+			if _, err := task.RegisterScheduleTask(task1); err != nil {
+				log.Errorf("failed to register task [%v]: %v", task1.Description, err)
+			}
 		}
 	}
 
@@ -228,6 +232,19 @@ func (app *App) initWithFlags() {
 	if len(os.Args) > 1 && os.Args[1] == "keystore" {
 		keystore.RunCmd(os.Args[2:])
 	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		//secrets in the config files are resolved from the keystore
+		if ksResolver, err := keystore.GetVariableResolver(); err == nil {
+			config.RegisterOption("keystore", ksResolver)
+		}
+		config.RunCmd(app.configFile, os.Args[2:])
+	}
+	if len(os.Args) > 1 && os.Args[1] == "pipeline" {
+		if ksResolver, err := keystore.GetVariableResolver(); err == nil {
+			config.RegisterOption("keystore", ksResolver)
+		}
+		simulate.RunCmd(app.configFile, os.Args[2:])
+	}
 
 	config.NotifyOnConfigChange(func(ev fsnotify.Event){
 		if ev.Op==fsnotify.Remove||ev.Op==fsnotify.Rename{
@@ -275,6 +292,8 @@ func (app *App) initEnvironment(customFunc func()) {
 	)
 	logger.SetLogging(&app.environment.SystemConfig.LoggingConfig, appName, baseDir)
 
+	i18n.Setup()
+
 	if customFunc != nil {
 		customFunc()
 	}
@@ -0,0 +1,93 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"fmt"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/notification"
+	"infini.sh/framework/core/util"
+	"strings"
+	"time"
+)
+
+// Notification is sent to the channels when an alert fires, repeats or resolves
+type Notification struct {
+	Status    string            `json:"status"` //firing or resolved
+	RuleID    string            `json:"rule_id"`
+	RuleName  string            `json:"rule_name"`
+	Severity  string            `json:"severity"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Message   string            `json:"message,omitempty"`
+	StartsAt  *time.Time        `json:"starts_at,omitempty"`
+	EndsAt    *time.Time        `json:"ends_at,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Title is a one line summary of the notification, eg: [FIRING][critical] queue lag
+func (n *Notification) Title() string {
+	return fmt.Sprintf("[%v][%v] %v", strings.ToUpper(n.Status), n.Severity, n.RuleName)
+}
+
+// Channel delivers notifications
+type Channel interface {
+	Send(n *Notification) error
+}
+
+// notificationChannel delivers the alerts by the channels of core/notification, eg: webhook, email, slack or pagerduty
+type notificationChannel struct {
+	channel *notification.Channel
+}
+
+func newChannel(name string, cfg *config.Config) (Channel, error) {
+	c, err := notification.NewChannel(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &notificationChannel{channel: c}, nil
+}
+
+func (c *notificationChannel) Send(n *Notification) error {
+	msg := &notification.Message{
+		Title:     n.Title(),
+		Content:   n.Message,
+		Severity:  n.Severity,
+		Status:    n.Status,
+		Source:    "alerting",
+		DedupKey:  n.RuleID,
+		Labels:    n.Labels,
+		Data:      util.MapStr{"rule_id": n.RuleID, "rule_name": n.RuleName},
+		Timestamp: n.Timestamp,
+	}
+	if n.StartsAt != nil {
+		//every firing of the rule is an incident
+		msg.DedupKey = fmt.Sprintf("%v-%v", n.RuleID, n.StartsAt.Unix())
+		msg.Data["starts_at"] = n.StartsAt
+	}
+	if n.EndsAt != nil {
+		msg.Data["ends_at"] = n.EndsAt
+	}
+	//delivered in place, so that the history records the result
+	return c.channel.Deliver(msg)
+}
@@ -0,0 +1,446 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"context"
+	log "github.com/cihub/seelog"
+	"github.com/valyala/fasttemplate"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/task"
+	"infini.sh/framework/core/util"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Engine evaluates the rules at their intervals, tracks the state of the alerts
+// and routes the notifications to the channels
+type Engine struct {
+	lock     sync.RWMutex
+	config   Config
+	rules    []*rule
+	channels map[string]Channel
+	silences []*Silence
+	history  []*HistoryEntry //oldest first
+	store    *store
+	taskIDs  []string
+}
+
+func NewEngine(cfg Config) (*Engine, error) {
+	if cfg.HistorySize <= 0 {
+		cfg.HistorySize = DefaultConfig().HistorySize
+	}
+	interval, err := parseDuration("interval", cfg.Interval, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{config: cfg, channels: map[string]Channel{}, store: newStore()}
+	for name, c := range cfg.Channels {
+		channel, err := newChannel(name, c)
+		if err != nil {
+			return nil, err
+		}
+		e.channels[name] = channel
+	}
+
+	ids := map[string]bool{}
+	for _, c := range cfg.Rules {
+		if c.Disabled {
+			continue
+		}
+		r, err := newRule(c, interval)
+		if err != nil {
+			return nil, err
+		}
+		if ids[r.ID] {
+			return nil, errors.Errorf("duplicated rule id: %v", r.ID)
+		}
+		ids[r.ID] = true
+		for _, name := range e.ruleChannels(r) {
+			if _, ok := e.channels[name]; !ok {
+				return nil, errors.Errorf("rule [%v]: channel %v not found", r.ID, name)
+			}
+		}
+		e.rules = append(e.rules, r)
+	}
+
+	e.store.load(historyBucket, historyKey, &e.history)
+	e.store.load(silenceBucket, silencesKey, &e.silences)
+	e.restoreAlerts()
+	return e, nil
+}
+
+// restoreAlerts restore the state of the alerts by rule id, so that the pending alerts keep their duration
+// and the firing ones are not notified again after restarts
+func (e *Engine) restoreAlerts() {
+	alerts := map[string]*Alert{}
+	e.store.load(alertBucket, alertsKey, &alerts)
+	for _, r := range e.rules {
+		saved, ok := alerts[r.ID]
+		if !ok || saved == nil {
+			continue
+		}
+		a := r.alert
+		a.State = saved.State
+		a.Message = saved.Message
+		a.Silenced = saved.Silenced
+		a.ActiveSince = saved.ActiveSince
+		a.FiredAt = saved.FiredAt
+		a.ResolvedAt = saved.ResolvedAt
+		a.LastNotified = saved.LastNotified
+		a.LastEvaluated = saved.LastEvaluated
+	}
+}
+
+func (e *Engine) saveAlerts() {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	alerts := map[string]*Alert{}
+	for _, r := range e.rules {
+		alerts[r.ID] = r.alert
+	}
+	e.store.save(alertBucket, alertsKey, alerts)
+}
+
+func (e *Engine) ruleChannels(r *rule) []string {
+	if len(r.Channels) > 0 {
+		return r.Channels
+	}
+	return e.config.DefaultChannels
+}
+
+// Start subscribe the events and schedule the rules
+func (e *Engine) Start() error {
+	for _, r := range e.rules {
+		if s, ok := r.source.(*eventsSource); ok {
+			if err := s.start(); err != nil {
+				return errors.Errorf("rule [%v]: %v", r.ID, err)
+			}
+		}
+		rule := r
+		id, err := task.RegisterScheduleTask(task.ScheduleTask{
+			ID:          "alerting_rule_" + rule.ID,
+			Description: "evaluate alerting rule " + rule.Name,
+			Type:        "interval",
+			Interval:    rule.interval.String(),
+			Singleton:   true,
+			Task: func(ctx context.Context) {
+				e.evaluate(rule, time.Now())
+			},
+		})
+		if err != nil {
+			return errors.Errorf("rule [%v]: %v", r.ID, err)
+		}
+		e.taskIDs = append(e.taskIDs, id)
+	}
+	return nil
+}
+
+func (e *Engine) Stop() {
+	for _, id := range e.taskIDs {
+		task.DeleteTask(id)
+	}
+	e.taskIDs = nil
+	for _, r := range e.rules {
+		if s, ok := r.source.(*eventsSource); ok {
+			s.stop()
+		}
+	}
+}
+
+func (e *Engine) getRule(id string) *rule {
+	for _, r := range e.rules {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// Evaluate evaluate the rule immediately
+func (e *Engine) Evaluate(ruleID string) error {
+	r := e.getRule(ruleID)
+	if r == nil {
+		return errors.Errorf("rule %v not found", ruleID)
+	}
+	e.evaluate(r, time.Now())
+	return nil
+}
+
+func (e *Engine) evaluate(r *rule, now time.Time) {
+	values, err := r.source.Values(now)
+	matched := false
+	if err == nil {
+		matched = r.condition.Check(values)
+	}
+
+	e.lock.Lock()
+	a := r.alert
+	a.LastEvaluated = &now
+	if err != nil {
+		//keep the state on errors, the source may be temporarily unavailable
+		a.Error = err.Error()
+		e.lock.Unlock()
+		log.Debugf("failed to evaluate alerting rule [%v]: %v", r.ID, err)
+		return
+	}
+	a.Error = ""
+	a.Silenced = e.silenced(a, now)
+	state := a.State
+
+	var entry *HistoryEntry
+	notify := false
+	if matched {
+		if a.State != StatePending && a.State != StateFiring {
+			a.State = StatePending
+			a.ActiveSince = &now
+			a.FiredAt = nil
+			a.ResolvedAt = nil
+			a.LastNotified = nil
+			a.Message = render(r, values, StatePending)
+			entry = e.record(a, now)
+		}
+		if a.State == StatePending && now.Sub(*a.ActiveSince) >= r.forDuration {
+			a.State = StateFiring
+			a.FiredAt = &now
+			a.Message = render(r, values, StateFiring)
+			entry = e.record(a, now)
+			notify = !a.Silenced
+		} else if a.State == StateFiring && !a.Silenced {
+			//notified once per firing, unless repeat interval is set, muted alerts are notified after the silence
+			if a.LastNotified == nil || (r.repeatInterval > 0 && now.Sub(*a.LastNotified) >= r.repeatInterval) {
+				a.Message = render(r, values, StateFiring)
+				entry = e.record(a, now)
+				notify = true
+			}
+		}
+	} else {
+		switch a.State {
+		case StatePending:
+			a.State = StateInactive
+			a.ActiveSince = nil
+		case StateFiring:
+			a.State = StateResolved
+			a.ResolvedAt = &now
+			a.Message = render(r, values, StateResolved)
+			entry = e.record(a, now)
+			notify = r.sendResolved && !a.Silenced && a.LastNotified != nil
+		}
+	}
+
+	var n *Notification
+	if notify {
+		status := StateFiring
+		if a.State == StateResolved {
+			status = StateResolved
+		}
+		n = &Notification{
+			Status:    status,
+			RuleID:    a.RuleID,
+			RuleName:  a.RuleName,
+			Severity:  a.Severity,
+			Labels:    a.Labels,
+			Message:   a.Message,
+			StartsAt:  a.FiredAt,
+			EndsAt:    a.ResolvedAt,
+			Timestamp: now,
+		}
+		a.LastNotified = &now
+	}
+	e.lock.Unlock()
+
+	if n != nil {
+		notified, errs := e.send(r, n)
+		e.lock.Lock()
+		entry.Notified = notified
+		entry.Errors = errs
+		e.lock.Unlock()
+	}
+	if entry != nil {
+		e.saveHistory()
+	}
+	if entry != nil || state != a.State {
+		e.saveAlerts()
+	}
+}
+
+func (e *Engine) send(r *rule, n *Notification) (notified []string, errs []string) {
+	for _, name := range e.ruleChannels(r) {
+		if err := e.channels[name].Send(n); err != nil {
+			log.Errorf("failed to send alert [%v] to channel [%v]: %v", r.ID, name, err)
+			errs = append(errs, name+": "+err.Error())
+			continue
+		}
+		notified = append(notified, name)
+	}
+	return notified, errs
+}
+
+// record append a history entry, must be called with the lock held
+func (e *Engine) record(a *Alert, now time.Time) *HistoryEntry {
+	entry := &HistoryEntry{
+		ID:        util.GetUUID(),
+		RuleID:    a.RuleID,
+		RuleName:  a.RuleName,
+		Severity:  a.Severity,
+		Labels:    a.Labels,
+		State:     a.State,
+		Message:   a.Message,
+		Silenced:  a.Silenced,
+		Timestamp: now,
+	}
+	e.history = append(e.history, entry)
+	if len(e.history) > e.config.HistorySize {
+		e.history = e.history[len(e.history)-e.config.HistorySize:]
+	}
+	return entry
+}
+
+func (e *Engine) saveHistory() {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	e.store.save(historyBucket, historyKey, e.history)
+}
+
+func render(r *rule, values conditions.ValuesMap, state string) string {
+	if r.Message == "" {
+		return r.Name + " is " + state
+	}
+	t, err := fasttemplate.NewTemplate(r.Message, "$[[", "]]")
+	if err != nil {
+		return r.Message
+	}
+	return t.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		if tag == "state" {
+			return w.Write([]byte(state))
+		}
+		v, err := values.GetValue(tag)
+		if err != nil {
+			return w.Write([]byte("$[[" + tag + "]]"))
+		}
+		return w.Write([]byte(util.ToString(v)))
+	})
+}
+
+// silenced check if the alert is muted, must be called with the lock held
+func (e *Engine) silenced(a *Alert, now time.Time) bool {
+	for _, s := range e.silences {
+		if s.Active(now) && s.Matches(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRules return the rules with the state of their alerts
+func (e *Engine) GetRules() []RuleStatus {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	out := make([]RuleStatus, 0, len(e.rules))
+	for _, r := range e.rules {
+		out = append(out, r.status())
+	}
+	return out
+}
+
+// GetAlerts return the pending and firing alerts
+func (e *Engine) GetAlerts() []Alert {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	out := []Alert{}
+	for _, r := range e.rules {
+		if r.alert.State == StatePending || r.alert.State == StateFiring {
+			out = append(out, *r.alert)
+		}
+	}
+	return out
+}
+
+// GetHistory return the latest history entries of the rule, or all rules if rule id is empty
+func (e *Engine) GetHistory(ruleID string, size int) []HistoryEntry {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	out := []HistoryEntry{}
+	for i := len(e.history) - 1; i >= 0; i-- {
+		if size > 0 && len(out) >= size {
+			break
+		}
+		if ruleID == "" || e.history[i].RuleID == ruleID {
+			out = append(out, *e.history[i])
+		}
+	}
+	return out
+}
+
+// AddSilence add a silence, the expired silences are removed
+func (e *Engine) AddSilence(s Silence) (*Silence, error) {
+	now := time.Now()
+	silence, err := newSilence(s, now)
+	if err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	silences := []*Silence{silence}
+	for _, v := range e.silences {
+		if v.ID != silence.ID && now.Before(v.EndsAt) {
+			silences = append(silences, v)
+		}
+	}
+	e.silences = silences
+	e.store.save(silenceBucket, silencesKey, e.silences)
+	e.lock.Unlock()
+	return silence, nil
+}
+
+// DeleteSilence expire the silence, return false if it is not found
+func (e *Engine) DeleteSilence(id string) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for i, v := range e.silences {
+		if v.ID == id {
+			e.silences = append(e.silences[:i], e.silences[i+1:]...)
+			e.store.save(silenceBucket, silencesKey, e.silences)
+			return true
+		}
+	}
+	return false
+}
+
+// GetSilences return the silences, sorted by end time
+func (e *Engine) GetSilences() []Silence {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	out := make([]Silence, 0, len(e.silences))
+	for _, v := range e.silences {
+		out = append(out, *v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].EndsAt.Before(out[j].EndsAt)
+	})
+	return out
+}
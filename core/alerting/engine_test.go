@@ -0,0 +1,308 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/kv/kvtest"
+	"infini.sh/framework/core/notification"
+	"infini.sh/framework/core/pubsub"
+	"infini.sh/framework/core/util"
+)
+
+type fakeSource struct {
+	values util.MapStr
+	err    error
+}
+
+func (s *fakeSource) Values(now time.Time) (conditions.ValuesMap, error) {
+	return s.values, s.err
+}
+
+// webhookStandIn collects the notifications posted to the webhook channel
+type webhookStandIn struct {
+	lock          sync.Mutex
+	notifications []notification.Message
+}
+
+func (s *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := notification.Message{}
+	body, _ := ioutil.ReadAll(r.Body)
+	util.MustFromJSONBytes(body, &n)
+	s.lock.Lock()
+	s.notifications = append(s.notifications, n)
+	s.lock.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *webhookStandIn) received() []notification.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]notification.Message{}, s.notifications...)
+}
+
+func newTestEngine(t *testing.T, url string, rule string) (*Engine, *fakeSource) {
+	cfg, err := config.NewConfigWithYAML([]byte(`
+channels:
+  hook:
+    type: webhook
+    url: `+url+`
+default_channels: [hook]
+rules:
+`+rule), "test")
+	assert.NoError(t, err)
+	c := DefaultConfig()
+	assert.NoError(t, cfg.Unpack(&c))
+
+	e, err := NewEngine(c)
+	assert.NoError(t, err)
+	source := &fakeSource{values: util.MapStr{}}
+	e.rules[0].source = source
+	return e, source
+}
+
+func TestEngineStateChanges(t *testing.T) {
+	hook := &webhookStandIn{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	e, source := newTestEngine(t, server.URL, `
+  - id: high_depth
+    for: 1m
+    severity: critical
+    message: "depth is $[[depth]], $[[state]]"
+    condition:
+      range:
+        depth.gte: 10
+`)
+	r := e.getRule("high_depth")
+	now := time.Now()
+
+	source.values["depth"] = 20
+	e.evaluate(r, now)
+	assert.Equal(t, StatePending, r.alert.State)
+	e.evaluate(r, now.Add(30*time.Second))
+	assert.Equal(t, StatePending, r.alert.State)
+	assert.Equal(t, 0, len(hook.received()))
+
+	e.evaluate(r, now.Add(61*time.Second))
+	assert.Equal(t, StateFiring, r.alert.State)
+	assert.Equal(t, 1, len(e.GetAlerts()))
+
+	//notified once without repeat interval
+	e.evaluate(r, now.Add(2*time.Minute))
+	received := hook.received()
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, StateFiring, received[0].Status)
+	assert.Equal(t, "critical", received[0].Severity)
+	assert.Equal(t, "depth is 20, firing", received[0].Content)
+
+	//errors keep the state
+	source.err = errors.New("unavailable")
+	e.evaluate(r, now.Add(3*time.Minute))
+	assert.Equal(t, StateFiring, r.alert.State)
+	assert.Equal(t, "unavailable", r.alert.Error)
+
+	source.err = nil
+	source.values["depth"] = 1
+	e.evaluate(r, now.Add(4*time.Minute))
+	assert.Equal(t, StateResolved, r.alert.State)
+	assert.Equal(t, "", r.alert.Error)
+	received = hook.received()
+	assert.Equal(t, 2, len(received))
+	assert.Equal(t, StateResolved, received[1].Status)
+	assert.Equal(t, "depth is 1, resolved", received[1].Content)
+	assert.Equal(t, 0, len(e.GetAlerts()))
+
+	//newest first
+	history := e.GetHistory("high_depth", 10)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, StateResolved, history[0].State)
+	assert.Equal(t, []string{"hook"}, history[0].Notified)
+	assert.Equal(t, StateFiring, history[1].State)
+	assert.Equal(t, StatePending, history[2].State)
+	assert.Equal(t, 0, len(history[2].Notified))
+	assert.Equal(t, 1, len(e.GetHistory("", 1)))
+}
+
+func TestEngineRepeatAndSilence(t *testing.T) {
+	hook := &webhookStandIn{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	e, source := newTestEngine(t, server.URL, `
+  - id: queue_lag
+    repeat_interval: 10m
+    labels: {team: ops}
+    condition:
+      equals:
+        lagging: true
+`)
+	r := e.getRule("queue_lag")
+	now := time.Now()
+
+	silence, err := e.AddSilence(Silence{RuleID: "queue_*", StartsAt: now.Add(-time.Minute), EndsAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(e.GetSilences()))
+
+	//fires without for, but muted
+	source.values["lagging"] = true
+	e.evaluate(r, now)
+	assert.Equal(t, StateFiring, r.alert.State)
+	assert.True(t, r.alert.Silenced)
+	assert.Equal(t, 0, len(hook.received()))
+
+	//notified once the silence is lifted
+	assert.True(t, e.DeleteSilence(silence.ID))
+	assert.False(t, e.DeleteSilence(silence.ID))
+	e.evaluate(r, now.Add(time.Minute))
+	assert.False(t, r.alert.Silenced)
+	assert.Equal(t, 1, len(hook.received()))
+
+	e.evaluate(r, now.Add(5*time.Minute))
+	assert.Equal(t, 1, len(hook.received()))
+	e.evaluate(r, now.Add(12*time.Minute))
+	received := hook.received()
+	assert.Equal(t, 2, len(received))
+	assert.Equal(t, "queue_lag is firing", received[1].Content)
+	assert.Equal(t, "ops", received[1].Labels["team"])
+
+	//labels must match
+	_, err = e.AddSilence(Silence{Labels: map[string]string{"team": "dev"}, StartsAt: now, EndsAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+	e.evaluate(r, now.Add(30*time.Minute))
+	assert.False(t, r.alert.Silenced)
+
+	_, err = e.AddSilence(Silence{RuleID: "queue_lag", EndsAt: now.Add(-time.Hour)})
+	assert.Error(t, err)
+}
+
+func TestNewEngineErrors(t *testing.T) {
+	c := DefaultConfig()
+	c.Rules = []RuleConfig{{ID: "a"}}
+	_, err := NewEngine(c)
+	assert.Error(t, err)
+
+	cfg, err := config.NewConfigWithYAML([]byte(`
+rules:
+  - id: a
+    channels: [missing]
+    condition:
+      equals: {a: 1}
+`), "test")
+	assert.NoError(t, err)
+	c = DefaultConfig()
+	assert.NoError(t, cfg.Unpack(&c))
+	_, err = NewEngine(c)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing")
+}
+
+func TestEventsSource(t *testing.T) {
+	s, err := newEventsSource(&EventsSourceConfig{Topics: []string{"alerting_test.*"}, Window: "1m"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.start())
+	defer s.stop()
+
+	pubsub.Publish("alerting_test.queue", "lagging", util.MapStr{"queue": "orders"})
+	pubsub.Publish("alerting_test.queue", "lagging", util.MapStr{"queue": "logs"})
+	pubsub.Publish("other", "lagging", nil)
+
+	var values conditions.ValuesMap
+	assert.Eventually(t, func() bool {
+		values, _ = s.Values(time.Now())
+		v, _ := values.GetValue("events.count")
+		return v == 2
+	}, time.Second, 10*time.Millisecond)
+
+	v, _ := values.GetValue("events.type.lagging")
+	assert.Equal(t, 2, v)
+	v, _ = values.GetValue("events.topic.alerting_test.queue")
+	assert.Equal(t, 2, v)
+	v, _ = values.GetValue("events.last.data.queue")
+	assert.Equal(t, "logs", v)
+
+	//out of the window
+	values, _ = s.Values(time.Now().Add(2 * time.Minute))
+	v, _ = values.GetValue("events.count")
+	assert.Equal(t, 0, v)
+}
+
+func TestEngineRestoreAlerts(t *testing.T) {
+	kvtest.Register("alerting_test")
+	clean := func() {
+		kv.DeleteKey(historyBucket, historyKey)
+		kv.DeleteKey(silenceBucket, silencesKey)
+		kv.DeleteKey(alertBucket, alertsKey)
+	}
+	clean()
+	defer clean()
+
+	hook := &webhookStandIn{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	rule := `
+  - id: high_depth
+    for: 1m
+    condition:
+      range:
+        depth.gte: 10
+`
+	e, source := newTestEngine(t, server.URL, rule)
+	now := time.Now()
+	source.values["depth"] = 20
+	e.evaluate(e.getRule("high_depth"), now)
+	assert.Equal(t, StatePending, e.getRule("high_depth").alert.State)
+
+	//the pending duration is kept after restarts
+	e, source = newTestEngine(t, server.URL, rule)
+	r := e.getRule("high_depth")
+	assert.Equal(t, StatePending, r.alert.State)
+	assert.Equal(t, now.Unix(), r.alert.ActiveSince.Unix())
+	source.values["depth"] = 20
+	e.evaluate(r, now.Add(61*time.Second))
+	assert.Equal(t, StateFiring, r.alert.State)
+	assert.Equal(t, 1, len(hook.received()))
+
+	//the firing alert is not notified again
+	e, source = newTestEngine(t, server.URL, rule)
+	r = e.getRule("high_depth")
+	assert.Equal(t, StateFiring, r.alert.State)
+	assert.NotNil(t, r.alert.LastNotified)
+	source.values["depth"] = 20
+	e.evaluate(r, now.Add(2*time.Minute))
+	assert.Equal(t, 1, len(hook.received()))
+	assert.Equal(t, 2, len(e.GetHistory("", 10)))
+}
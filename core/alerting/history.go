@@ -0,0 +1,90 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/util"
+	"time"
+)
+
+const (
+	historyBucket = "alerting_history"
+	silenceBucket = "alerting_silences"
+	alertBucket   = "alerting_alerts"
+)
+
+var (
+	historyKey  = []byte("history")
+	silencesKey = []byte("silences")
+	alertsKey   = []byte("alerts")
+)
+
+// HistoryEntry records a state change of an alert and the notifications sent
+type HistoryEntry struct {
+	ID        string            `json:"id"`
+	RuleID    string            `json:"rule_id"`
+	RuleName  string            `json:"rule_name"`
+	Severity  string            `json:"severity"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	State     string            `json:"state"`
+	Message   string            `json:"message,omitempty"`
+	Silenced  bool              `json:"silenced,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+	//channels notified successfully
+	Notified []string `json:"notified,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// store keeps the history, silences and the state of the alerts in kv when available, so that they survive restarts
+type store struct {
+	persist bool
+}
+
+func newStore() *store {
+	return &store{persist: kv.IsRegistered()}
+}
+
+func (s *store) load(bucket string, key []byte, v interface{}) {
+	if !s.persist {
+		return
+	}
+	b, err := kv.GetValue(bucket, key)
+	if err != nil || len(b) == 0 {
+		return
+	}
+	if err := util.FromJSONBytes(b, v); err != nil {
+		log.Errorf("failed to load %v: %v", bucket, err)
+	}
+}
+
+func (s *store) save(bucket string, key []byte, v interface{}) {
+	if !s.persist {
+		return
+	}
+	if err := kv.AddValue(bucket, key, util.MustToJSONBytes(v)); err != nil {
+		log.Errorf("failed to save %v: %v", bucket, err)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"fmt"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"time"
+)
+
+const (
+	StateInactive = "inactive"
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+const (
+	SourceStats  = "stats"
+	SourceQueue  = "queue"
+	SourceEvents = "events"
+)
+
+// Config is the settings of the alerting module
+type Config struct {
+	Enabled bool `config:"enabled"`
+	//default evaluation interval of the rules
+	Interval string `config:"interval"`
+	//max num of entries kept in the alert history
+	HistorySize int `config:"history_size"`
+	//channels used by the rules without channels
+	DefaultChannels []string `config:"default_channels"`
+	//notification channels by name, the type is set by `type`, eg: webhook, email, websocket,
+	//or the types of core/notification, eg: slack, teams or pagerduty
+	Channels map[string]*config.Config `config:"channels"`
+	Rules    []RuleConfig              `config:"rules"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:    "10s",
+		HistorySize: 1000,
+	}
+}
+
+// RuleConfig defines an alerting rule, the condition is checked against the values of the source
+type RuleConfig struct {
+	ID       string `config:"id" json:"id"`
+	Name     string `config:"name" json:"name,omitempty"`
+	Disabled bool   `config:"disabled" json:"disabled,omitempty"`
+	//stats, queue or events, default: stats
+	Source string              `config:"source" json:"source,omitempty"`
+	Events *EventsSourceConfig `config:"events" json:"events,omitempty"`
+
+	Condition *conditions.Config `config:"condition" json:"-"`
+
+	Interval string `config:"interval" json:"interval,omitempty"`
+	//how long the condition must hold before the alert fires
+	For string `config:"for" json:"for,omitempty"`
+	//re-send notifications of firing alerts, empty means notify only once
+	RepeatInterval string `config:"repeat_interval" json:"repeat_interval,omitempty"`
+	SendResolved   *bool  `config:"send_resolved" json:"send_resolved,omitempty"`
+
+	Severity string            `config:"severity" json:"severity,omitempty"`
+	Labels   map[string]string `config:"labels" json:"labels,omitempty"`
+	//values of the source could be referenced, eg: queue depth: $[[queue.logs.depth]]
+	Message  string   `config:"message" json:"message,omitempty"`
+	Channels []string `config:"channels" json:"channels,omitempty"`
+}
+
+// EventsSourceConfig counts the events published to core/pubsub within the window
+type EventsSourceConfig struct {
+	Topics []string `config:"topics" json:"topics"`
+	Window string   `config:"window" json:"window,omitempty"`
+	//max num of events kept in the window
+	BufferSize int `config:"buffer_size" json:"buffer_size,omitempty"`
+}
+
+type rule struct {
+	RuleConfig
+	condition      conditions.Condition
+	source         source
+	interval       time.Duration
+	forDuration    time.Duration
+	repeatInterval time.Duration
+	sendResolved   bool
+	alert          *Alert
+}
+
+func parseDuration(name, v string, def time.Duration) (time.Duration, error) {
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, errors.Errorf("invalid %v: %v", name, err)
+	}
+	return d, nil
+}
+
+func newRule(cfg RuleConfig, defaultInterval time.Duration) (*rule, error) {
+	if cfg.ID == "" {
+		return nil, errors.New("rule id is required")
+	}
+	if cfg.Condition == nil {
+		return nil, errors.Errorf("rule [%v]: condition is required", cfg.ID)
+	}
+	r := &rule{RuleConfig: cfg, sendResolved: true}
+	if r.Name == "" {
+		r.Name = r.ID
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	if r.SendResolved != nil {
+		r.sendResolved = *r.SendResolved
+	}
+
+	var err error
+	if r.condition, err = conditions.NewCondition(cfg.Condition); err != nil {
+		return nil, errors.Errorf("rule [%v]: %v", cfg.ID, err)
+	}
+	if r.interval, err = parseDuration("interval", cfg.Interval, defaultInterval); err != nil {
+		return nil, errors.Errorf("rule [%v]: %v", cfg.ID, err)
+	}
+	if r.forDuration, err = parseDuration("for", cfg.For, 0); err != nil {
+		return nil, errors.Errorf("rule [%v]: %v", cfg.ID, err)
+	}
+	if r.repeatInterval, err = parseDuration("repeat_interval", cfg.RepeatInterval, 0); err != nil {
+		return nil, errors.Errorf("rule [%v]: %v", cfg.ID, err)
+	}
+
+	switch r.Source {
+	case "", SourceStats:
+		r.Source = SourceStats
+		r.source = statsSource{}
+	case SourceQueue:
+		r.source = queueSource{}
+	case SourceEvents:
+		if r.Events == nil || len(r.Events.Topics) == 0 {
+			return nil, errors.Errorf("rule [%v]: events.topics is required", cfg.ID)
+		}
+		if r.source, err = newEventsSource(r.Events); err != nil {
+			return nil, errors.Errorf("rule [%v]: %v", cfg.ID, err)
+		}
+	default:
+		return nil, errors.Errorf("rule [%v]: unknown source %v", cfg.ID, r.Source)
+	}
+
+	r.alert = &Alert{
+		RuleID:   r.ID,
+		RuleName: r.Name,
+		Severity: r.Severity,
+		Labels:   r.Labels,
+		State:    StateInactive,
+	}
+	return r, nil
+}
+
+// Alert is the state of a rule
+type Alert struct {
+	RuleID   string            `json:"rule_id"`
+	RuleName string            `json:"rule_name"`
+	Severity string            `json:"severity"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	State    string            `json:"state"`
+	Message  string            `json:"message,omitempty"`
+	Silenced bool              `json:"silenced,omitempty"`
+	//the error of the last evaluation, the state is kept on errors
+	Error string `json:"error,omitempty"`
+
+	ActiveSince   *time.Time `json:"active_since,omitempty"`
+	FiredAt       *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt    *time.Time `json:"resolved_at,omitempty"`
+	LastNotified  *time.Time `json:"last_notified,omitempty"`
+	LastEvaluated *time.Time `json:"last_evaluated,omitempty"`
+}
+
+// RuleStatus is the config and state of a rule
+type RuleStatus struct {
+	RuleConfig
+	Condition string `json:"condition"`
+	Alert     Alert  `json:"alert"`
+}
+
+func (r *rule) status() RuleStatus {
+	return RuleStatus{RuleConfig: r.RuleConfig, Condition: fmt.Sprint(r.condition), Alert: *r.alert}
+}
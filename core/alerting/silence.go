@@ -0,0 +1,94 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+	"strings"
+	"time"
+)
+
+// Silence mutes the notifications of the matched alerts within the time range,
+// the state of the alerts is still tracked and recorded
+type Silence struct {
+	ID string `json:"id"`
+	//rule id, prefix ending with * supported, empty matches all rules
+	RuleID string `json:"rule_id,omitempty"`
+	//all the labels must be equal
+	Labels    map[string]string `json:"labels,omitempty"`
+	StartsAt  time.Time         `json:"starts_at"`
+	EndsAt    time.Time         `json:"ends_at"`
+	Comment   string            `json:"comment,omitempty"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func (s *Silence) validate() error {
+	if s.RuleID == "" && len(s.Labels) == 0 {
+		return errors.New("rule_id or labels is required")
+	}
+	if s.EndsAt.IsZero() || !s.EndsAt.After(s.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+// Active check if the silence is in effect
+func (s *Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// Matches check if the alert is muted by the silence
+func (s *Silence) Matches(a *Alert) bool {
+	if s.RuleID != "" {
+		if strings.HasSuffix(s.RuleID, "*") {
+			if !strings.HasPrefix(a.RuleID, strings.TrimSuffix(s.RuleID, "*")) {
+				return false
+			}
+		} else if s.RuleID != a.RuleID {
+			return false
+		}
+	}
+	for k, v := range s.Labels {
+		if a.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func newSilence(s Silence, now time.Time) (*Silence, error) {
+	if s.ID == "" {
+		s.ID = util.GetUUID()
+	}
+	if s.StartsAt.IsZero() {
+		s.StartsAt = now
+	}
+	s.CreatedAt = now
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
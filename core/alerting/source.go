@@ -0,0 +1,172 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package alerting
+
+import (
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/pubsub"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"sync"
+	"time"
+)
+
+// source provides the values the conditions are checked against
+type source interface {
+	Values(now time.Time) (conditions.ValuesMap, error)
+}
+
+// statsSource exposes stats.StatsMap(), eg: system.goroutines
+type statsSource struct{}
+
+func (statsSource) Values(now time.Time) (conditions.ValuesMap, error) {
+	return stats.StatsMap()
+}
+
+// queueSource exposes the depth and storage size of the queues, eg: queue.<name>.depth
+type queueSource struct{}
+
+func (queueSource) Values(now time.Time) (conditions.ValuesMap, error) {
+	values := util.MapStr{}
+	for _, cfg := range queue.GetAllConfigs() {
+		depth := queue.Depth(cfg)
+		size := queue.GetStorageSize(cfg.ID)
+		for _, k := range []string{cfg.Name, cfg.ID} {
+			if k == "" {
+				continue
+			}
+			values["queue."+k+".depth"] = depth
+			values["queue."+k+".storage_size"] = size
+		}
+	}
+	return values, nil
+}
+
+const defaultEventsBufferSize = 10000
+
+// eventsSource keeps the events of the topics published within the window, exposes
+// events.count, events.topic.<topic>, events.type.<type> and the last event as events.last.*
+type eventsSource struct {
+	topics     []string
+	window     time.Duration
+	bufferSize int
+
+	lock       sync.Mutex
+	subscriber *pubsub.Subscriber
+	events     []*pubsub.Event
+}
+
+func newEventsSource(cfg *EventsSourceConfig) (*eventsSource, error) {
+	window, err := parseDuration("events.window", cfg.Window, 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	s := &eventsSource{topics: cfg.Topics, window: window, bufferSize: cfg.BufferSize}
+	if s.bufferSize <= 0 {
+		s.bufferSize = defaultEventsBufferSize
+	}
+	return s, nil
+}
+
+func (s *eventsSource) start() error {
+	subscriber, err := pubsub.Subscribe(pubsub.SubscriberOptions{}, s.topics...)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.subscriber = subscriber
+	s.lock.Unlock()
+	go func() {
+		for {
+			select {
+			case e := <-subscriber.C():
+				s.add(e)
+			case <-subscriber.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *eventsSource) stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.subscriber != nil {
+		s.subscriber.Close()
+		s.subscriber = nil
+	}
+}
+
+func (s *eventsSource) add(e *pubsub.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, e)
+	if len(s.events) > s.bufferSize {
+		s.events = s.events[len(s.events)-s.bufferSize:]
+	}
+}
+
+func (s *eventsSource) Values(now time.Time) (conditions.ValuesMap, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	//drop the events out of the window
+	start := now.Add(-s.window)
+	i := 0
+	for i < len(s.events) && s.events[i].Timestamp.Before(start) {
+		i++
+	}
+	s.events = s.events[i:]
+
+	values := util.MapStr{"events.count": len(s.events)}
+	for _, e := range s.events {
+		incr(values, "events.topic."+e.Topic)
+		if e.Type != "" {
+			incr(values, "events.type."+e.Type)
+		}
+	}
+	if len(s.events) > 0 {
+		last := s.events[len(s.events)-1]
+		values["events.last.topic"] = last.Topic
+		values["events.last.type"] = last.Type
+		values["events.last.timestamp"] = last.Timestamp
+		switch data := last.Data.(type) {
+		case util.MapStr, map[string]interface{}:
+			for k, v := range util.Flatten(data, true) {
+				values["events.last.data."+k] = v
+			}
+		case nil:
+		default:
+			values["events.last.data"] = data
+		}
+	}
+	return values, nil
+}
+
+func incr(values util.MapStr, key string) {
+	v, _ := values[key].(int)
+	values[key] = v + 1
+}
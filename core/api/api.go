@@ -38,11 +38,14 @@ import (
 	"infini.sh/framework/core/api/filter"
 	"infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/api/websocket"
+	"infini.sh/framework/core/audit"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
 	_ "infini.sh/framework/core/logging"
 	"infini.sh/framework/core/logging/logger"
+	"infini.sh/framework/core/pubsub"
+	"infini.sh/framework/core/security"
 	"infini.sh/framework/core/util"
 	"net"
 	"net/http"
@@ -97,6 +100,8 @@ func initializeAPI() {
 		for _, f := range filters {
 			handler = f.FilterHttpHandlerFunc(pattern, handler)
 		}
+		//outside the filters, so that the errors of the filters are localized too
+		handler = withLocaleFunc(handler)
 
 		APIs[pattern+"*"] = util.KV{Key: "*", Value: pattern}
 
@@ -110,6 +115,7 @@ func initializeAPI() {
 			for _, f := range filters {
 				handler = f.FilterHttpRouter(pattern, handler)
 			}
+			handler = withLocale(handler)
 
 			APIs[pattern+m] = util.KV{Key: m, Value: pattern}
 
@@ -120,8 +126,8 @@ func initializeAPI() {
 	}
 }
 
-// HandleAPIMethod register api handler
-func HandleAPIMethod(method Method, pattern string, handler func(w http.ResponseWriter, req *http.Request, ps httprouter.Params)) {
+// HandleAPIMethod register api handler, options are used to describe the api, see APISpec
+func HandleAPIMethod(method Method, pattern string, handler func(w http.ResponseWriter, req *http.Request, ps httprouter.Params), options ...Option) {
 	l.Lock()
 	if registeredAPIMethodHandler == nil {
 		registeredAPIMethodHandler = map[string]map[string]func(w http.ResponseWriter, req *http.Request, ps httprouter.Params){}
@@ -133,6 +139,7 @@ func HandleAPIMethod(method Method, pattern string, handler func(w http.Response
 		registeredAPIMethodHandler[m] = map[string]func(w http.ResponseWriter, req *http.Request, ps httprouter.Params){}
 	}
 	registeredAPIMethodHandler[m][pattern] = handler
+	registeredAPISpecs[m+" "+pattern] = newAPISpec(m, pattern, options...)
 
 	l.Unlock()
 }
@@ -160,19 +167,27 @@ func SetNotFoundHandler(handler func(rw http.ResponseWriter, r *http.Request)) {
 
 var DefaultAPI = Handler{}
 
+// TopicLogTail is the pubsub topic of realtime logs
+const TopicLogTail = "log.tail"
+
+func init() {
+	pubsub.RegisterTopic(TopicLogTail, "realtime logs, requires log.realtime to be enabled", "log:read")
+}
+
 // StartAPI will start listen and act as the API server
-func StartAPI() {
+func StartAPI() error {
 
 	apiConfig = &global.Env().SystemConfig.APIConfig
 
 	if !apiConfig.Enabled {
-		return
+		return nil
 	}
 	if apiConfig.WebsocketConfig.Enabled {
 		websocket.InitWebSocket(apiConfig.WebsocketConfig)
 		HandleAPIFunc(apiConfig.WebsocketConfig.BasePath, websocket.ServeWs)
 		logger.RegisterWebsocketHandler(func(message string, level log.LogLevel, context log.LogContextInterface) {
 			websocket.BroadcastMessage(message)
+			pubsub.Publish(TopicLogTail, level.String(), message)
 		})
 		if registeredWebSocketCommandHandler != nil {
 			for k, v := range registeredWebSocketCommandHandler {
@@ -188,6 +203,17 @@ func StartAPI() {
 		AllowedMethods:   []string{"HEAD", "GET", "POST", "DELETE", "PUT", "OPTIONS"},
 	})
 
+	//subscriptions are authorized the same way as the api calls
+	security.SetAuthenticationEnabled(apiConfig.Security.Enabled)
+	pubsub.SetAuthorizer(security.HasPermission)
+
+	//per principal rate limits are checked inside auth, on the authenticated user
+	var rateLimitFilter *filter.RateLimitFilter
+	if apiConfig.RateLimit.Enabled {
+		rateLimitFilter = filter.NewRateLimitFilter(&apiConfig.RateLimit)
+		RegisterAPIFilter(rateLimitFilter.PrincipalFilter())
+	}
+
 	//init api handlers
 	if apiConfig.Security.Enabled {
 		apiBasicAuthFilter := BasicAuthFilter{
@@ -199,6 +225,19 @@ func StartAPI() {
 		RegisterAPIFilter(&apiBasicAuthFilter)
 	}
 
+	//audit filter wraps auth, so that rejected calls are recorded as well
+	if apiConfig.Audit.Enabled {
+		if err := audit.Setup(&apiConfig.Audit); err != nil {
+			return errors.Errorf("failed to open the audit log: %v", err)
+		}
+		RegisterAPIFilter(NewAuditFilter(&apiConfig.Audit))
+	}
+
+	//rate limit filter wraps the others, throttled requests are rejected before auth
+	if rateLimitFilter != nil {
+		RegisterAPIFilter(rateLimitFilter)
+	}
+
 	//TODO support filter out specify api
 	initializeAPI()
 
@@ -310,6 +349,6 @@ func StartAPI() {
 	}
 
 	log.Info("api server listen at: ", schema, listenAddress)
-
+	return nil
 }
 
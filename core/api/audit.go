@@ -0,0 +1,256 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"context"
+	"fmt"
+	log "github.com/cihub/seelog"
+	"github.com/segmentio/encoding/json"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/audit"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var defaultAuditMethods = []string{"POST", "PUT", "DELETE", "PATCH"}
+
+// max bytes of the error response kept to extract the failure reason
+const maxAuditErrorSize = 1024
+
+type auditContextKey struct{}
+
+// AuditFilter record the mutating api calls to the audit log,
+// request bodies are never recorded as they may carry secrets
+type AuditFilter struct {
+	methods     []string
+	actorHeader string
+}
+
+func NewAuditFilter(cfg *config.APIAuditConfig) *AuditFilter {
+	filter := &AuditFilter{methods: defaultAuditMethods, actorHeader: cfg.ActorHeader}
+	if len(cfg.Methods) > 0 {
+		filter.methods = []string{}
+		for _, m := range cfg.Methods {
+			filter.methods = append(filter.methods, strings.ToUpper(m))
+		}
+	}
+	return filter
+}
+
+func (filter *AuditFilter) FilterHttpRouter(pattern string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		//specs are looked up on request, the registry is locked while filters are applied
+		spec := GetAPISpec(Method(r.Method), pattern)
+		if !filter.audited(r.Method, spec) {
+			h(w, r, ps)
+			return
+		}
+		filter.serve(w, r, pattern, spec, ps, func(w http.ResponseWriter, r *http.Request) {
+			h(w, r, ps)
+		})
+	}
+}
+
+func (filter *AuditFilter) FilterHttpHandlerFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !filter.audited(r.Method, nil) {
+			handler(w, r)
+			return
+		}
+		filter.serve(w, r, pattern, nil, nil, handler)
+	}
+}
+
+func (filter *AuditFilter) audited(method string, spec *APISpec) bool {
+	if spec != nil && spec.Audit != nil {
+		return !spec.Audit.Disabled
+	}
+	return util.StringInArray(filter.methods, method)
+}
+
+func (filter *AuditFilter) serve(w http.ResponseWriter, r *http.Request, pattern string, spec *APISpec, ps httprouter.Params, handler func(http.ResponseWriter, *http.Request)) {
+	record := &audit.Record{
+		Timestamp: time.Now(),
+		SourceIP:  util.ClientIP(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Action:    fmt.Sprintf("%v %v", r.Method, pattern),
+		Target:    r.URL.Path,
+	}
+	if spec != nil {
+		if spec.Permission != "" {
+			record.Action = spec.Permission
+		}
+		if spec.Audit != nil {
+			if spec.Audit.Action != "" {
+				record.Action = spec.Audit.Action
+			}
+			if spec.Audit.Target != "" {
+				record.Target = auditTarget(spec.Audit.Target, ps)
+			}
+		}
+	}
+
+	//the claimed actor is only recorded as a detail, the actor is the user authenticated by the inner filters
+	if filter.actorHeader != "" {
+		if v := r.Header.Get(filter.actorHeader); v != "" {
+			record.Details = map[string]string{"claimed_actor": v}
+		}
+	}
+
+	rw := &auditResponseWriter{ResponseWriter: w}
+	r = security.TrackPrincipal(r)
+	r = r.WithContext(context.WithValue(r.Context(), auditContextKey{}, record))
+
+	defer func() {
+		if v := recover(); v != nil {
+			rw.status = http.StatusInternalServerError
+			record.Error = fmt.Sprint(v)
+			record.Actor = actor(r)
+			filter.write(record, rw, time.Since(record.Timestamp))
+			panic(v)
+		}
+	}()
+	handler(rw, r)
+	record.Actor = actor(r)
+	filter.write(record, rw, time.Since(record.Timestamp))
+}
+
+func (filter *AuditFilter) write(record *audit.Record, rw *auditResponseWriter, duration time.Duration) {
+	record.Status = rw.status
+	if record.Status == 0 {
+		record.Status = http.StatusOK
+	}
+	record.DurationInMs = duration.Milliseconds()
+	if record.Status < 400 {
+		record.Outcome = audit.OutcomeSuccess
+	} else {
+		record.Outcome = audit.OutcomeFailure
+		if record.Error == "" {
+			record.Error = errorReason(rw.body)
+		}
+	}
+	if err := audit.Write(record); err != nil {
+		log.Error("failed to write audit record: ", err)
+	}
+}
+
+func actor(r *http.Request) string {
+	if user := security.GetPrincipal(r); user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+func auditTarget(target string, ps httprouter.Params) string {
+	for _, p := range ps {
+		target = strings.Replace(target, "{"+p.Key+"}", p.Value, -1)
+	}
+	return target
+}
+
+// errorReason extract the reason from the error response written by WriteError
+func errorReason(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	obj := struct {
+		Error interface{} `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &obj); err == nil {
+		switch v := obj.Error.(type) {
+		case string:
+			return v
+		case map[string]interface{}:
+			if reason, ok := v["reason"].(string); ok {
+				return reason
+			}
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// SetAuditTarget set the target of the audit record of the request, for targets known only
+// by the handler, eg: the name in the request body
+func SetAuditTarget(r *http.Request, target string) {
+	if record, ok := r.Context().Value(auditContextKey{}).(*audit.Record); ok {
+		record.Target = target
+	}
+}
+
+// AddAuditDetail attach extra information to the audit record of the request
+func AddAuditDetail(r *http.Request, key, value string) {
+	if record, ok := r.Context().Value(auditContextKey{}).(*audit.Record); ok {
+		if record.Details == nil {
+			record.Details = map[string]string{}
+		}
+		record.Details[key] = value
+	}
+}
+
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 && len(w.body) < maxAuditErrorSize {
+		n := maxAuditErrorSize - len(w.body)
+		if n > len(b) {
+			n = len(b)
+		}
+		w.body = append(w.body, b[:n]...)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *auditResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -29,6 +29,7 @@ package api
 
 import (
 	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/security"
 	"net/http"
 )
 
@@ -45,7 +46,7 @@ func BasicAuth(h httprouter.Handle, requiredUser, requiredPassword string) httpr
 
 		if hasAuth && user == requiredUser && password == requiredPassword {
 			// Delegate request to the given handle
-			h(w, r, ps)
+			h(w, security.Authenticated(r, user), ps)
 		} else {
 			// Request Basic Authentication otherwise
 			w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
@@ -64,7 +65,7 @@ func (filter *BasicAuthFilter) FilterHttpHandlerFunc(pattern string, handler fun
 		user, password, hasAuth := request.BasicAuth()
 		if hasAuth && user == filter.Username && password == filter.Password {
 			// Delegate request to the given handle
-			handler(w, request)
+			handler(w, security.Authenticated(request, user))
 			return
 		}
 		// Request Basic Authentication otherwise
@@ -38,88 +38,602 @@ limitations under the License.
 */
 
 package filter
-//
-//import (
-//	"fmt"
-//	"infini.sh/framework/core/api/router"
-//	"io"
-//	"net/http"
-//	"sync"
-//	"time"
-//)
-//
-//type RateLimitFilter struct {
-//	Interval time.Duration
-//	MaxCount int
-//	Lock     sync.Mutex
-//	ReqCount int
-//}
-//
-//func NewRequestLimitService(interval time.Duration, maxCnt int) *RateLimitFilter {
-//	reqLimit := &RateLimitFilter{
-//		Interval: interval,
-//		MaxCount: maxCnt,
-//	}
-//
-//	go func() {
-//		ticker := time.NewTicker(interval)
-//		defer ticker.Stop()
-//		for {
-//			<-ticker.C
-//			reqLimit.Lock.Lock()
-//			reqLimit.ReqCount = 0
-//			reqLimit.Lock.Unlock()
-//		}
-//	}()
-//
-//	return reqLimit
-//}
-//
-//func (filter *RateLimitFilter) Increase() {
-//	filter.Lock.Lock()
-//	defer filter.Lock.Unlock()
-//
-//	filter.ReqCount += 1
-//}
-//
-//func (filter *RateLimitFilter) IsAvailable() bool {
-//	filter.Lock.Lock()
-//	defer filter.Lock.Unlock()
-//
-//	return filter.ReqCount < filter.MaxCount
-//}
-//
-//var RequestLimit = NewRequestLimitService(10*time.Second, 5)
-//
-//func (filter RateLimitFilter) FilterHttpRouter(pattern string, h httprouter.Handle) httprouter.Handle {
-//
-//	Counter.Increase()
-//
-//	if RequestLimit.IsAvailable() {
-//		RequestLimit.Increase()
-//		return h
-//
-//	} else {
-//		return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-//			fmt.Println("Reach request limiting!")
-//			io.WriteString(w, "Reach request limit!\n")
-//		}
-//	}
-//
-//}
-//
-//func (filter RateLimitFilter) FilterHttpHandlerFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
-//
-//	Counter.Increase()
-//
-//	return func(w http.ResponseWriter, r *http.Request) {
-//		if RequestLimit.IsAvailable() {
-//			RequestLimit.Increase()
-//			handler(w, r)
-//		} else {
-//			fmt.Println("Reach request limiting!")
-//			io.WriteString(w, "Reach request limit!\n")
-//		}
-//	}
-//
-//}
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	log "github.com/cihub/seelog"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/radix"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PerRoute     = "route"
+	PerIP        = "ip"
+	PerPrincipal = "principal"
+)
+
+// KVBucketRateLimit is the kv bucket used to share counters across nodes
+const KVBucketRateLimit = "api_rate_limit"
+
+// max idle buckets kept per rule before a sweep is triggered
+const maxBucketsPerRule = 10000
+
+// max retries of the compare and swap of a shared bucket
+const maxSharedRetries = 10
+
+// each node leases up to a tenth of the burst from a shared bucket at once, so that most of the requests
+// are served without the kv store
+const sharedLeaseRatio = 10
+
+// how often the shared buckets written by the node are checked, the refilled ones are removed from the kv store
+const sharedSweepInterval = time.Minute
+
+// RateLimitFilter applies token-bucket limits to the matched api routes, it wraps the authentication,
+// the rules limited per principal are checked by the inner filter, see PrincipalFilter
+type RateLimitFilter struct {
+	distributed    bool
+	trustedProxies []*net.IPNet
+	rules          []*rateLimitRule
+	principal      bool
+}
+
+type rateLimitRule struct {
+	name     string
+	pattern  *radix.Pattern
+	methods  map[string]struct{}
+	per      string
+	limit    int
+	burst    int
+	interval time.Duration
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+
+	//the tokens leased from the shared buckets and the shared buckets written by the node
+	leases     map[string]*sharedLease
+	sharedKeys map[string]time.Time
+	lastSweep  time.Time
+	sweeping   int32
+}
+
+// sharedLease is the tokens taken from a shared bucket in advance, or the denial of the bucket until it refills
+type sharedLease struct {
+	tokens      int
+	remaining   int
+	expires     time.Time
+	deniedUntil time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitResult is the outcome of taking one token from a rule
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	retryAfter time.Duration
+	reset      time.Duration
+}
+
+// reservation is the token taken from a rule, it is given back if another rule denies the request
+type reservation struct {
+	rule *rateLimitRule
+	key  string
+}
+
+type rateLimitStateKey struct{}
+
+// rateLimitState passes the reservations of the outer filter to the inner one
+type rateLimitState struct {
+	reserved []reservation
+	current  rateLimitResult
+}
+
+func NewRateLimitFilter(cfg *config.APIRateLimitConfig) *RateLimitFilter {
+	filter := &RateLimitFilter{
+		distributed: cfg.Distributed,
+	}
+
+	for _, v := range cfg.TrustedProxies {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			log.Warnf("invalid trusted proxy: %v, skipped", v)
+			continue
+		}
+		filter.trustedProxies = append(filter.trustedProxies, network)
+	}
+
+	for i, v := range cfg.Rules {
+		if v.Limit <= 0 || len(v.Patterns) == 0 {
+			log.Warnf("invalid rate limit rule: %v, skipped", v)
+			continue
+		}
+
+		rule := &rateLimitRule{
+			name:     v.Name,
+			pattern:  radix.Compile(v.Patterns...),
+			per:      strings.ToLower(strings.TrimSpace(v.Per)),
+			limit:    v.Limit,
+			burst:    v.Burst,
+			interval: util.GetDurationOrDefault(v.Interval, time.Second),
+			buckets:  map[string]*tokenBucket{},
+		}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule_%v", i)
+		}
+		if rule.per == "" {
+			rule.per = PerRoute
+		}
+		if rule.burst < rule.limit {
+			rule.burst = rule.limit
+		}
+		if len(v.Methods) > 0 {
+			rule.methods = map[string]struct{}{}
+			for _, m := range v.Methods {
+				rule.methods[strings.ToUpper(m)] = struct{}{}
+			}
+		}
+		filter.rules = append(filter.rules, rule)
+	}
+
+	return filter
+}
+
+// PrincipalFilter returns the filter checking the rules limited per principal, it must be wrapped by
+// the authentication, so that the requests are keyed on the authenticated user
+func (filter *RateLimitFilter) PrincipalFilter() *RateLimitFilter {
+	return &RateLimitFilter{
+		distributed:    filter.distributed,
+		trustedProxies: filter.trustedProxies,
+		rules:          filter.rules,
+		principal:      true,
+	}
+}
+
+func (filter *RateLimitFilter) matchRules(pattern string) []*rateLimitRule {
+	rules := []*rateLimitRule{}
+	for _, rule := range filter.rules {
+		if (rule.per == PerPrincipal) != filter.principal {
+			continue
+		}
+		if rule.pattern.Match(pattern) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (filter *RateLimitFilter) FilterHttpRouter(pattern string, h httprouter.Handle) httprouter.Handle {
+	rules := filter.matchRules(pattern)
+	if len(rules) == 0 {
+		return h
+	}
+
+	log.Debugf("rate limit applied to api: %v", pattern)
+
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if r, ok := filter.checkRequest(w, r, pattern, rules); ok {
+			h(w, r, ps)
+		}
+	}
+}
+
+func (filter *RateLimitFilter) FilterHttpHandlerFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	rules := filter.matchRules(pattern)
+	if len(rules) == 0 {
+		return handler
+	}
+
+	log.Debugf("rate limit applied to api: %v", pattern)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r, ok := filter.checkRequest(w, r, pattern, rules); ok {
+			handler(w, r)
+		}
+	}
+}
+
+// checkRequest reserves a token from every applicable rule, write the rate limit headers,
+// and reply 429 when any of them is exhausted, the reserved tokens are given back then
+func (filter *RateLimitFilter) checkRequest(w http.ResponseWriter, r *http.Request, pattern string, rules []*rateLimitRule) (*http.Request, bool) {
+	state, _ := r.Context().Value(rateLimitStateKey{}).(*rateLimitState)
+	var current *rateLimitResult
+	if state != nil {
+		current = &state.current
+	}
+	reserved := []reservation{}
+	now := time.Now()
+
+	for _, rule := range rules {
+		if rule.methods != nil {
+			if _, ok := rule.methods[r.Method]; !ok {
+				continue
+			}
+		}
+
+		key := pattern + "|" + filter.getClientKey(r, rule.per)
+		result := rule.take(key, now, filter.distributed)
+
+		//report the most restrictive rule
+		if current == nil || !result.allowed || result.remaining < current.remaining {
+			current = &result
+		}
+
+		if !result.allowed {
+			stats.Increment("api.rate_limit", rule.name)
+			break
+		}
+		reserved = append(reserved, reservation{rule: rule, key: key})
+	}
+
+	if current == nil {
+		return r, true
+	}
+
+	w.Header().Set("X-RateLimit-Limit", util.IntToString(current.limit))
+	w.Header().Set("X-RateLimit-Remaining", util.IntToString(current.remaining))
+	w.Header().Set("X-RateLimit-Reset", util.IntToString(durationToSeconds(current.reset)))
+
+	if current.allowed {
+		if !filter.principal {
+			r = r.WithContext(context.WithValue(r.Context(), rateLimitStateKey{}, &rateLimitState{reserved: reserved, current: *current}))
+		}
+		return r, true
+	}
+
+	if state != nil {
+		reserved = append(reserved, state.reserved...)
+	}
+	for _, v := range reserved {
+		v.rule.release(v.key, now, filter.distributed)
+	}
+
+	w.Header().Set("Retry-After", util.IntToString(durationToSeconds(current.retryAfter)))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(util.MustToJSONBytes(util.MapStr{
+		"status": http.StatusTooManyRequests,
+		"error": util.MapStr{
+			"reason": "too many requests, please retry later",
+		},
+	}))
+	return r, false
+}
+
+func (filter *RateLimitFilter) getClientKey(r *http.Request, per string) string {
+	switch per {
+	case PerIP:
+		return "ip:" + filter.clientIP(r)
+	case PerPrincipal:
+		if user := security.GetPrincipal(r); user != "" {
+			return "user:" + user
+		}
+		//anonymous requests are limited by client ip
+		return "ip:" + filter.clientIP(r)
+	}
+	return ""
+}
+
+func (filter *RateLimitFilter) isTrustedProxy(v string) bool {
+	ip := net.ParseIP(v)
+	if ip == nil {
+		return false
+	}
+	for _, network := range filter.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the remote address, the forwarded headers are only honored when set by trusted proxies,
+// the forwarded chain is walked from the nearest hop, the first address not trusted is the client
+func (filter *RateLimitFilter) clientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		remote = strings.TrimSpace(r.RemoteAddr)
+	}
+	if !filter.isTrustedProxy(remote) {
+		return remote
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip != "" && (i == 0 || !filter.isTrustedProxy(ip)) {
+			return ip
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-Ip")); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+// rate is the refill speed, tokens per nanosecond
+func (rule *rateLimitRule) rate() float64 {
+	return float64(rule.limit) / float64(rule.interval)
+}
+
+func (rule *rateLimitRule) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.last)
+	if elapsed > 0 {
+		bucket.tokens = math.Min(float64(rule.burst), bucket.tokens+float64(elapsed)*rule.rate())
+		bucket.last = now
+	}
+}
+
+// consume takes one token from the refilled bucket
+func (rule *rateLimitRule) consume(bucket *tokenBucket) rateLimitResult {
+	result := rateLimitResult{limit: rule.burst}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = time.Duration(math.Ceil((1 - bucket.tokens) / rule.rate()))
+	}
+	result.remaining = int(bucket.tokens)
+	result.reset = time.Duration((float64(rule.burst) - bucket.tokens) / rule.rate())
+	return result
+}
+
+// take consumes one token from the bucket of the key
+func (rule *rateLimitRule) take(key string, now time.Time, shared bool) rateLimitResult {
+	if shared {
+		return rule.takeShared(key, now)
+	}
+	var result rateLimitResult
+	rule.update(key, now, func(bucket *tokenBucket) {
+		result = rule.consume(bucket)
+	})
+	return result
+}
+
+// release gives back the token reserved from the bucket of the key
+func (rule *rateLimitRule) release(key string, now time.Time, shared bool) {
+	f := func(bucket *tokenBucket) {
+		bucket.tokens = math.Min(float64(rule.burst), bucket.tokens+1)
+	}
+	if !shared {
+		rule.update(key, now, f)
+		return
+	}
+
+	rule.lock.Lock()
+	if l := rule.leases[key]; l != nil && now.Before(l.expires) {
+		l.tokens++
+		l.deniedUntil = time.Time{}
+		rule.lock.Unlock()
+		return
+	}
+	rule.lock.Unlock()
+	rule.updateShared(key, now, f)
+}
+
+func (rule *rateLimitRule) leaseSize() int {
+	if n := rule.burst / sharedLeaseRatio; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// takeShared consumes one token leased from the shared bucket, the bucket in the kv store is only updated
+// once the lease is used up, the denials are cached until the bucket refills
+func (rule *rateLimitRule) takeShared(key string, now time.Time) rateLimitResult {
+	rule.lock.Lock()
+	if l := rule.leases[key]; l != nil {
+		if l.tokens > 0 && now.Before(l.expires) {
+			l.tokens--
+			remaining := l.remaining + l.tokens
+			rule.lock.Unlock()
+			return rateLimitResult{allowed: true, limit: rule.burst, remaining: remaining,
+				reset: time.Duration(float64(rule.burst-remaining) / rule.rate())}
+		}
+		if now.Before(l.deniedUntil) {
+			retryAfter := l.deniedUntil.Sub(now)
+			rule.lock.Unlock()
+			return rateLimitResult{limit: rule.burst, retryAfter: retryAfter,
+				reset: time.Duration(float64(rule.burst) / rule.rate())}
+		}
+	}
+	rule.lock.Unlock()
+
+	var result rateLimitResult
+	leased := 0
+	ok := rule.updateShared(key, now, func(bucket *tokenBucket) {
+		result = rule.consume(bucket)
+		leased = 0
+		if result.allowed {
+			leased = int(math.Min(float64(rule.leaseSize()-1), math.Floor(bucket.tokens)))
+			bucket.tokens -= float64(leased)
+			result.remaining = int(bucket.tokens)
+		}
+	})
+	if !ok {
+		//fail open, the api is not blocked by the kv store
+		stats.Increment("api.rate_limit", rule.name+".fail_open")
+		return rateLimitResult{allowed: true, limit: rule.burst, remaining: rule.burst}
+	}
+
+	rule.lock.Lock()
+	defer rule.lock.Unlock()
+	if rule.leases == nil {
+		rule.leases = map[string]*sharedLease{}
+		rule.sharedKeys = map[string]time.Time{}
+		rule.lastSweep = now
+	}
+	if len(rule.leases) >= maxBucketsPerRule {
+		for k, v := range rule.leases {
+			if !now.Before(v.expires) && !now.Before(v.deniedUntil) {
+				delete(rule.leases, k)
+			}
+		}
+	}
+	l := &sharedLease{tokens: leased, remaining: result.remaining, expires: now.Add(rule.interval)}
+	if !result.allowed {
+		l.deniedUntil = now.Add(result.retryAfter)
+	}
+	rule.leases[key] = l
+	rule.sharedKeys[key] = now
+	if now.Sub(rule.lastSweep) >= sharedSweepInterval {
+		rule.lastSweep = now
+		rule.sweepShared(now)
+	}
+	return result
+}
+
+// sweepShared removes the shared buckets which are refilled since they were written by the node,
+// they are equal to the missing ones, must be called with the lock held
+func (rule *rateLimitRule) sweepShared(now time.Time) {
+	if !atomic.CompareAndSwapInt32(&rule.sweeping, 0, 1) {
+		return
+	}
+	refill := time.Duration(float64(rule.burst) / rule.rate())
+	keys := []string{}
+	for k, v := range rule.sharedKeys {
+		if now.Sub(v) >= refill {
+			keys = append(keys, k)
+			delete(rule.sharedKeys, k)
+		}
+	}
+	go func() {
+		defer atomic.StoreInt32(&rule.sweeping, 0)
+		for _, k := range keys {
+			kvKey := []byte(rule.name + "|" + k)
+			v, err := kv.GetValue(KVBucketRateLimit, kvKey)
+			if err != nil {
+				log.Warnf("failed to sweep rate limit bucket [%v]: %v", string(kvKey), err)
+				return
+			}
+			bucket, ok := decodeBucket(v)
+			if !ok {
+				continue
+			}
+			rule.refill(bucket, now)
+			if bucket.tokens >= float64(rule.burst) {
+				kv.DeleteKey(KVBucketRateLimit, kvKey)
+			}
+		}
+	}()
+}
+
+// update applies the change to the refilled local bucket
+func (rule *rateLimitRule) update(key string, now time.Time, f func(bucket *tokenBucket)) {
+	rule.lock.Lock()
+	defer rule.lock.Unlock()
+
+	bucket, ok := rule.buckets[key]
+	if !ok {
+		if len(rule.buckets) >= maxBucketsPerRule {
+			rule.sweep(now)
+		}
+		bucket = &tokenBucket{tokens: float64(rule.burst), last: now}
+		rule.buckets[key] = bucket
+	}
+	rule.refill(bucket, now)
+	f(bucket)
+}
+
+// sweep removes the buckets which are already refilled, they are equal to new ones
+func (rule *rateLimitRule) sweep(now time.Time) {
+	for k, v := range rule.buckets {
+		if v.tokens+float64(now.Sub(v.last))*rule.rate() >= float64(rule.burst) {
+			delete(rule.buckets, k)
+		}
+	}
+}
+
+func encodeBucket(bucket *tokenBucket) []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(bucket.tokens))
+	binary.BigEndian.PutUint64(buf[8:], uint64(bucket.last.UnixNano()))
+	return buf
+}
+
+func decodeBucket(buf []byte) (*tokenBucket, bool) {
+	if len(buf) != 16 {
+		return nil, false
+	}
+	return &tokenBucket{
+		tokens: math.Float64frombits(binary.BigEndian.Uint64(buf)),
+		last:   time.Unix(0, int64(binary.BigEndian.Uint64(buf[8:]))),
+	}, true
+}
+
+// updateShared applies the change to the bucket stored in the kv store, so that all nodes sharing
+// the same store see the same buckets, the change is retried until the compare and swap succeeded,
+// stores without compare and swap are local to the node and guarded by the lock of the rule,
+// returns false if the bucket was not updated
+func (rule *rateLimitRule) updateShared(key string, now time.Time, f func(bucket *tokenBucket)) bool {
+	kvKey := []byte(rule.name + "|" + key)
+	atomic := kv.IsAtomic()
+	if !atomic {
+		rule.lock.Lock()
+		defer rule.lock.Unlock()
+	}
+
+	for i := 0; i < maxSharedRetries; i++ {
+		old, err := kv.GetValue(KVBucketRateLimit, kvKey)
+		if err != nil {
+			log.Error("failed to get rate limit bucket: ", err)
+			return false
+		}
+		bucket, ok := decodeBucket(old)
+		if !ok {
+			bucket = &tokenBucket{tokens: float64(rule.burst), last: now}
+		}
+		rule.refill(bucket, now)
+		f(bucket)
+
+		if !atomic {
+			if err = kv.AddValue(KVBucketRateLimit, kvKey, encodeBucket(bucket)); err != nil {
+				log.Error("failed to save rate limit bucket: ", err)
+				return false
+			}
+			return true
+		}
+		swapped, err := kv.CompareAndSwap(KVBucketRateLimit, kvKey, old, encodeBucket(bucket))
+		if err != nil {
+			log.Error("failed to save rate limit bucket: ", err)
+			return false
+		}
+		if swapped {
+			return true
+		}
+	}
+	log.Warnf("failed to update rate limit bucket [%v], too many conflicts", string(kvKey))
+	return false
+}
+
+func durationToSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,279 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package filter
+
+import (
+	"github.com/stretchr/testify/assert"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/kv/kvtest"
+	"infini.sh/framework/core/security"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTokenBucket(t *testing.T) {
+	rule := &rateLimitRule{limit: 2, burst: 2, interval: time.Second, buckets: map[string]*tokenBucket{}}
+	now := time.Now()
+
+	assert.True(t, rule.take("a", now, false).allowed)
+	r := rule.take("a", now, false)
+	assert.True(t, r.allowed)
+	assert.Equal(t, 0, r.remaining)
+
+	r = rule.take("a", now, false)
+	assert.False(t, r.allowed)
+	assert.Equal(t, 500*time.Millisecond, r.retryAfter)
+
+	//other keys have their own bucket
+	assert.True(t, rule.take("b", now, false).allowed)
+
+	//refilled after half interval
+	assert.True(t, rule.take("a", now.Add(500*time.Millisecond), false).allowed)
+}
+
+func TestRateLimitFilter(t *testing.T) {
+	f := NewRateLimitFilter(&config.APIRateLimitConfig{
+		Rules: []config.APIRateLimitRule{
+			{Patterns: []string{"/pipeline/tasks/*"}, Methods: []string{"POST"}, Per: "ip", Limit: 1, Interval: "1m"},
+		},
+	})
+
+	called := 0
+	h := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		called++
+	}
+
+	//not matched routes are not wrapped
+	assert.Equal(t, 0, len(f.matchRules("/queue/:id/_scroll")))
+
+	handler := f.FilterHttpRouter("/pipeline/tasks/_search", h)
+
+	req := httptest.NewRequest("POST", "/pipeline/tasks/_search", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	w := httptest.NewRecorder()
+	handler(w, req, nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", w.Header().Get("X-RateLimit-Remaining"))
+
+	w = httptest.NewRecorder()
+	handler(w, req, nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, 1, called)
+
+	//other methods are not limited
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/pipeline/tasks/_search", nil), nil)
+	assert.Equal(t, 200, w.Code)
+
+	//other clients have their own quota
+	req = httptest.NewRequest("POST", "/pipeline/tasks/_search", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	w = httptest.NewRecorder()
+	handler(w, req, nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 3, called)
+}
+
+func TestRateLimitReserveAllRules(t *testing.T) {
+	f := NewRateLimitFilter(&config.APIRateLimitConfig{
+		Rules: []config.APIRateLimitRule{
+			{Name: "route", Patterns: []string{"/a"}, Limit: 2, Interval: "1m"},
+			{Name: "ip", Patterns: []string{"/a"}, Per: "ip", Limit: 1, Interval: "1m"},
+		},
+	})
+	handler := f.FilterHttpRouter("/a", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {})
+
+	call := func(remote string) int {
+		req := httptest.NewRequest("GET", "/a", nil)
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler(w, req, nil)
+		return w.Code
+	}
+	assert.Equal(t, 200, call("10.0.0.1:1234"))
+	//denied by the ip rule, the token of the route rule is given back
+	assert.Equal(t, http.StatusTooManyRequests, call("10.0.0.1:1234"))
+	assert.Equal(t, 200, call("10.0.0.2:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, call("10.0.0.3:1234"))
+}
+
+func TestRateLimitPrincipal(t *testing.T) {
+	f := NewRateLimitFilter(&config.APIRateLimitConfig{
+		Rules: []config.APIRateLimitRule{
+			{Name: "route", Patterns: []string{"/a"}, Limit: 2, Interval: "1m"},
+			{Name: "user", Patterns: []string{"/a"}, Per: "principal", Limit: 1, Interval: "1m"},
+		},
+	})
+	h := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {}
+	//the outer filter skips the principal rules, the inner one only checks them
+	assert.Equal(t, 1, len(f.matchRules("/a")))
+	assert.Equal(t, 1, len(f.PrincipalFilter().matchRules("/a")))
+
+	inner := f.PrincipalFilter().FilterHttpRouter("/a", h)
+	auth := func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		//the unverified basic auth user is not trusted
+		if user := r.Header.Get("X-Test-User"); user != "" {
+			r = security.Authenticated(r, user)
+		}
+		inner(w, r, ps)
+	}
+	handler := f.FilterHttpRouter("/a", auth)
+
+	call := func(user, basicAuth string) int {
+		req := httptest.NewRequest("GET", "/a", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		if basicAuth != "" {
+			req.SetBasicAuth(basicAuth, "wrong")
+		}
+		w := httptest.NewRecorder()
+		handler(w, req, nil)
+		return w.Code
+	}
+	assert.Equal(t, 200, call("alice", ""))
+	//denied by the principal rule, the token of the route rule is given back
+	assert.Equal(t, http.StatusTooManyRequests, call("alice", ""))
+	assert.Equal(t, 200, call("bob", ""))
+	assert.Equal(t, http.StatusTooManyRequests, call("", "alice"))
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	f := NewRateLimitFilter(&config.APIRateLimitConfig{TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16"}})
+
+	req := httptest.NewRequest("GET", "/a", nil)
+	req.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2, 192.168.1.1")
+	req.RemoteAddr = "10.0.0.2:1234"
+	//not set by a trusted proxy
+	assert.Equal(t, "10.0.0.2", f.clientIP(req))
+
+	req.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "2.2.2.2", f.clientIP(req))
+
+	req.Header.Del("X-Forwarded-For")
+	req.Header.Set("X-Real-Ip", "3.3.3.3")
+	assert.Equal(t, "3.3.3.3", f.clientIP(req))
+}
+
+func TestRateLimitShared(t *testing.T) {
+	kvtest.Register("memory")
+	rule := &rateLimitRule{name: "shared", limit: 1, burst: 2, interval: time.Minute}
+	kv.DeleteKey(KVBucketRateLimit, []byte("shared|a"))
+	kv.DeleteKey(KVBucketRateLimit, []byte("shared|b"))
+	now := time.Now()
+
+	//burst is honored
+	assert.True(t, rule.take("a", now, true).allowed)
+	assert.True(t, rule.take("a", now, true).allowed)
+	assert.False(t, rule.take("a", now, true).allowed)
+
+	rule.release("a", now, true)
+	assert.True(t, rule.take("a", now, true).allowed)
+
+	//the take is atomic across concurrent callers
+	allowed := 0
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rule.take("b", now, true).allowed {
+				lock.Lock()
+				allowed++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, allowed)
+}
+
+func TestRateLimitSharedLease(t *testing.T) {
+	kvtest.Register("memory")
+	kv.DeleteKey(KVBucketRateLimit, []byte("lease|a"))
+	now := time.Now()
+
+	//two nodes lease the tokens of one shared bucket
+	nodes := []*rateLimitRule{
+		{name: "lease", limit: 1, burst: 20, interval: time.Minute},
+		{name: "lease", limit: 1, burst: 20, interval: time.Minute},
+	}
+	allowed := 0
+	for i := 0; i < 30; i++ {
+		if nodes[i%2].take("a", now, true).allowed {
+			allowed++
+		}
+	}
+	assert.Equal(t, 20, allowed)
+
+	//every token is taken from the shared bucket
+	bucket, ok := decodeBucket(mustGetValue(t, "lease|a"))
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), bucket.tokens)
+
+	//the denial is cached until the bucket refills
+	kv.DeleteKey(KVBucketRateLimit, []byte("lease|a"))
+	assert.False(t, nodes[0].take("a", now, true).allowed)
+	assert.True(t, nodes[0].take("a", now.Add(time.Minute), true).allowed)
+}
+
+func TestRateLimitSharedSweep(t *testing.T) {
+	kvtest.Register("memory")
+	rule := &rateLimitRule{name: "sweep", limit: 1, burst: 2, interval: time.Second}
+	now := time.Now()
+
+	assert.True(t, rule.take("a", now, true).allowed)
+	assert.NotNil(t, mustGetValue(t, "sweep|a"))
+
+	//the refilled bucket is removed by the next sweep
+	assert.True(t, rule.take("b", now.Add(sharedSweepInterval), true).allowed)
+	for atomic.LoadInt32(&rule.sweeping) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Nil(t, mustGetValue(t, "sweep|a"))
+	assert.NotNil(t, mustGetValue(t, "sweep|b"))
+	kv.DeleteKey(KVBucketRateLimit, []byte("sweep|b"))
+}
+
+func mustGetValue(t *testing.T, key string) []byte {
+	v, err := kv.GetValue(KVBucketRateLimit, []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
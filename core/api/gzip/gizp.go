@@ -271,6 +271,11 @@ func (w *GzipResponseWriter) Close() error {
 	return err
 }
 
+// Unwrap returns the underlying http.ResponseWriter.
+func (w *GzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // Flush flushes the underlying *gzip.Writer and then the underlying
 // http.ResponseWriter if it is an http.Flusher. This makes GzipResponseWriter
 // an http.Flusher.
@@ -31,6 +31,7 @@ import (
 	"github.com/jmoiron/jsonq"
 	"github.com/segmentio/encoding/json"
 	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/i18n"
 	"infini.sh/framework/core/util"
 	"io/ioutil"
 	"net/http"
@@ -149,7 +150,11 @@ func (handler Handler) WriteJSONListResult(w http.ResponseWriter, total int64, v
 	return handler.WriteJSON(w, result, statusCode)
 }
 
+// WriteError output the error, the message is localized to the locale of the request if it is a message key
 func (handler Handler) WriteError(w http.ResponseWriter, errMessage string, statusCode int) error {
+	if i18n.HasMessage(errMessage) {
+		errMessage = i18n.Text(GetResponseLocale(w), errMessage, nil)
+	}
 	err1 := util.MapStr{
 		"status": statusCode,
 		"error": util.MapStr{
@@ -318,7 +323,12 @@ func (handler Handler) Error500(w http.ResponseWriter, msg string) {
 
 // Error output custom error
 func (handler Handler) Error(w http.ResponseWriter, err error) {
-	handler.WriteError(w, err.Error(), http.StatusInternalServerError)
+	handler.WriteLocalizedError(w, err, http.StatusInternalServerError)
+}
+
+// WriteLocalizedError output the error, errors created by i18n.NewError are localized to the locale of the request
+func (handler Handler) WriteLocalizedError(w http.ResponseWriter, err error, statusCode int) error {
+	return handler.WriteError(w, i18n.Localize(GetResponseLocale(w), err), statusCode)
 }
 
 // Flush flush response message
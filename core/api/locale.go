@@ -0,0 +1,135 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/i18n"
+	"net"
+	"net/http"
+)
+
+// LocaleKey is the query parameter and session key of the preferred locale
+const LocaleKey = "locale"
+
+type localeContextKey struct{}
+
+// localeResponseWriter carries the locale of the request, so that errors written by the handlers could be localized
+type localeResponseWriter struct {
+	http.ResponseWriter
+	locale string
+}
+
+func (w *localeResponseWriter) Locale() string {
+	return w.locale
+}
+
+func (w *localeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *localeResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *localeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker interface is not supported")
+}
+
+// localize negotiate the locale once, it is kept in the context of the request and carried by the response writer
+func localize(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	locale := GetLocale(r)
+	r = r.WithContext(context.WithValue(r.Context(), localeContextKey{}, locale))
+	return &localeResponseWriter{ResponseWriter: w, locale: locale}, r
+}
+
+func withLocaleFunc(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, r = localize(w, r)
+		handler(w, r)
+	}
+}
+
+func withLocale(handler httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w, r = localize(w, r)
+		handler(w, r, ps)
+	}
+}
+
+// GetLocale negotiate the locale of the request, in the order of the locale parameter,
+// the locale saved in session and the Accept-Language header
+func GetLocale(r *http.Request) string {
+	if r == nil {
+		return i18n.GetDefaultLocale()
+	}
+	if locale, ok := r.Context().Value(localeContextKey{}).(string); ok {
+		return locale
+	}
+	var preferred []string
+	if v := r.URL.Query().Get(LocaleKey); v != "" {
+		preferred = append(preferred, v)
+	}
+	if _, err := r.Cookie(sessionName); err == nil {
+		if ok, v := GetSession(r, LocaleKey); ok {
+			if s, ok := v.(string); ok {
+				preferred = append(preferred, s)
+			}
+		}
+	}
+	return i18n.NegotiateLocale(r.Header.Get("Accept-Language"), preferred...)
+}
+
+// SetLocale save the preferred locale of the user to session
+func SetLocale(w http.ResponseWriter, r *http.Request, locale string) bool {
+	return SetSession(w, r, LocaleKey, i18n.NormalizeLocale(locale))
+}
+
+// GetResponseLocale return the locale of the request being served by the response writer,
+// the writers wrapped by the filters are unwrapped
+func GetResponseLocale(w http.ResponseWriter) string {
+	for w != nil {
+		if v, ok := w.(interface{ Locale() string }); ok {
+			return v.Locale()
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		w = u.Unwrap()
+	}
+	return i18n.GetDefaultLocale()
+}
@@ -0,0 +1,70 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/i18n"
+)
+
+func TestLocaleOfRequest(t *testing.T) {
+	i18n.AddMessages("de-DE", map[string]interface{}{"api_test": map[string]interface{}{"not_found": "nicht gefunden"}})
+
+	var locale string
+	handler := withLocale(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		//the writer is wrapped by the filters, eg: audit
+		w = &auditResponseWriter{ResponseWriter: w}
+		locale = GetLocale(r)
+		Handler{}.WriteError(w, "api_test.not_found", http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+	w := httptest.NewRecorder()
+	handler(w, req, nil)
+	assert.Equal(t, "de-DE", locale)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "nicht gefunden")
+
+	//the parameterized messages of the handlers
+	handler = withLocale(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		Handler{}.WriteLocalizedError(w, i18n.NewError("i18n.api.unsupported_locale", i18n.Params{"locale": "xx"}), http.StatusBadRequest)
+	})
+	req.Header.Set("Accept-Language", "zh-CN")
+	w = httptest.NewRecorder()
+	handler(w, req, nil)
+	assert.Contains(t, w.Body.String(), "不支持的语言：xx")
+
+	//the writers of other requests are not localized
+	assert.Equal(t, i18n.GetDefaultLocale(), GetResponseLocale(httptest.NewRecorder()))
+}
@@ -0,0 +1,219 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"fmt"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/core/util/jsonschema"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const openAPIVersion = "3.0.3"
+
+// GenerateOpenAPISpec generate the OpenAPI 3 document of all registered apis
+func GenerateOpenAPISpec() util.MapStr {
+	specs := GetAPISpecs()
+	sort.Slice(specs, func(i, j int) bool {
+		if specs[i].Pattern != specs[j].Pattern {
+			return specs[i].Pattern < specs[j].Pattern
+		}
+		return specs[i].Method < specs[j].Method
+	})
+
+	reflector := jsonschema.New("#/components/schemas/")
+	paths := util.MapStr{}
+	operationIDs := map[string]bool{}
+	for _, spec := range specs {
+		p := toOpenAPIPath(spec.Pattern)
+		item, ok := paths[p].(util.MapStr)
+		if !ok {
+			item = util.MapStr{}
+			paths[p] = item
+		}
+		op := spec.toOperation(reflector)
+		//the ids of patterns like /:id and /_id are the same, the later ones are suffixed
+		id := op["operationId"].(string)
+		for i := 2; operationIDs[id]; i++ {
+			id = fmt.Sprintf("%v_%v", op["operationId"], i)
+		}
+		operationIDs[id] = true
+		op["operationId"] = id
+		item[strings.ToLower(spec.Method)] = op
+	}
+
+	env := global.Env()
+	doc := util.MapStr{
+		"openapi": openAPIVersion,
+		"info": util.MapStr{
+			"title":       env.GetAppName(),
+			"description": env.GetAppDesc(),
+			"version":     env.GetVersion(),
+		},
+		"paths": paths,
+	}
+
+	components := util.MapStr{}
+	if len(reflector.Definitions) > 0 {
+		components["schemas"] = reflector.Definitions
+	}
+	if apiConfig != nil && apiConfig.Security.Enabled {
+		components["securitySchemes"] = util.MapStr{
+			"basic_auth": util.MapStr{"type": "http", "scheme": "basic"},
+		}
+		doc["security"] = []util.MapStr{{"basic_auth": []string{}}}
+	}
+	if len(components) > 0 {
+		doc["components"] = components
+	}
+	if apiConfig != nil {
+		doc["servers"] = []util.MapStr{{"url": apiConfig.GetEndpoint()}}
+	}
+	return doc
+}
+
+func (spec *APISpec) toOperation(reflector *jsonschema.Reflector) util.MapStr {
+	op := util.MapStr{
+		"operationId": operationID(spec.Method, spec.Pattern),
+	}
+	if spec.Summary != "" {
+		op["summary"] = spec.Summary
+	}
+	if spec.Description != "" {
+		op["description"] = spec.Description
+	}
+	if len(spec.Tags) > 0 {
+		op["tags"] = spec.Tags
+	} else if tag := defaultTag(spec.Pattern); tag != "" {
+		op["tags"] = []string{tag}
+	}
+	if spec.Deprecated {
+		op["deprecated"] = true
+	}
+	if spec.Permission != "" {
+		op["x-permission"] = spec.Permission
+	}
+
+	if len(spec.Parameters) > 0 {
+		params := []util.MapStr{}
+		for _, v := range spec.Parameters {
+			paramType := v.Type
+			if paramType == "" {
+				paramType = "string"
+			}
+			param := util.MapStr{
+				"name":     v.Name,
+				"in":       v.In,
+				"required": v.Required,
+				"schema":   util.MapStr{"type": paramType},
+			}
+			if v.Description != "" {
+				param["description"] = v.Description
+			}
+			params = append(params, param)
+		}
+		op["parameters"] = params
+	}
+
+	if spec.Request != nil {
+		op["requestBody"] = util.MapStr{
+			"required": true,
+			"content": util.MapStr{
+				"application/json": util.MapStr{"schema": reflector.Reflect(spec.Request)},
+			},
+		}
+	}
+
+	response := util.MapStr{"description": http.StatusText(http.StatusOK)}
+	if spec.Response != nil {
+		response["content"] = util.MapStr{
+			"application/json": util.MapStr{"schema": reflector.Reflect(spec.Response)},
+		}
+	}
+	op["responses"] = util.MapStr{
+		"200": response,
+		"default": util.MapStr{
+			"description": "error",
+			"content": util.MapStr{
+				"application/json": util.MapStr{"schema": errorResponseSchema},
+			},
+		},
+	}
+	return op
+}
+
+// errorResponseSchema is the schema of Handler.WriteError
+var errorResponseSchema = &jsonschema.Schema{
+	Type: "object",
+	Properties: map[string]*jsonschema.Schema{
+		"status": {Type: "integer"},
+		"error": {
+			Type: "object",
+			Properties: map[string]*jsonschema.Schema{
+				"reason": {Type: "string"},
+			},
+		},
+	},
+}
+
+// toOpenAPIPath convert /queue/:id/stats to /queue/{id}/stats
+func toOpenAPIPath(pattern string) string {
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		if len(seg) > 1 && (seg[0] == ':' || seg[0] == '*') {
+			segs[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+func operationID(method, pattern string) string {
+	id := strings.ToLower(method)
+	for _, seg := range strings.Split(pattern, "/") {
+		seg = strings.Trim(seg, ":*_")
+		if seg == "" {
+			continue
+		}
+		id += "_" + seg
+	}
+	return id
+}
+
+// defaultTag use the first segment of the path as tag, eg: pipeline
+func defaultTag(pattern string) string {
+	for _, seg := range strings.Split(pattern, "/") {
+		seg = strings.TrimPrefix(seg, "_")
+		if seg != "" && seg[0] != ':' && seg[0] != '*' {
+			return seg
+		}
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/util"
+)
+
+// openAPITestResponse references the AuditSpec of this package, the test declares another AuditSpec with the same name
+type openAPITestResponse struct {
+	Spec  *APISpec    `json:"spec"`
+	Audit []AuditSpec `json:"audit"`
+}
+
+func TestGenerateOpenAPISpec(t *testing.T) {
+	type AuditSpec struct {
+		Reason string `json:"reason"`
+	}
+	handler := func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {}
+	HandleAPIMethod(GET, "/openapi_test/:id", handler, WithSummary("get the spec"),
+		WithQueryParam("size", "integer", "num of the items", false), WithResponse(openAPITestResponse{}))
+	HandleAPIMethod(GET, "/openapi_test/_id", handler, WithResponse(AuditSpec{}))
+	HandleAPIMethod(POST, "/openapi_test/:id/_audit", handler, WithRequest(&AuditSpec{}))
+
+	doc := util.MapStr{}
+	util.MustFromJSONBytes(util.MustToJSONBytes(GenerateOpenAPISpec()), &doc)
+	paths := doc["paths"].(map[string]interface{})
+	assert.Contains(t, paths, "/openapi_test/{id}")
+	assert.Contains(t, paths, "/openapi_test/_id")
+	assert.Contains(t, paths, "/openapi_test/{id}/_audit")
+
+	get := paths["/openapi_test/{id}"].(map[string]interface{})["get"].(map[string]interface{})
+	assert.Equal(t, "get the spec", get["summary"])
+	assert.Equal(t, []interface{}{"openapi_test"}, get["tags"])
+	params := get["parameters"].([]interface{})
+	assert.Equal(t, 2, len(params))
+	assert.Equal(t, map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}}, params[0])
+	assert.Equal(t, "query", params[1].(map[string]interface{})["in"])
+	assert.Equal(t, "integer", params[1].(map[string]interface{})["schema"].(map[string]interface{})["type"])
+
+	//the operation ids are unique
+	ids := map[string]bool{}
+	for _, item := range paths {
+		for _, op := range item.(map[string]interface{}) {
+			id := op.(map[string]interface{})["operationId"].(string)
+			assert.False(t, ids[id], id)
+			ids[id] = true
+		}
+	}
+	assert.True(t, ids["get_openapi_test_id"])
+	assert.True(t, ids["get_openapi_test_id_2"])
+
+	//the references are resolved, the types with the same name are different schemas
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	refs := map[string]bool{}
+	collectRefs(doc, refs)
+	assert.True(t, refs["#/components/schemas/api.openAPITestResponse"])
+	for ref := range refs {
+		assert.True(t, strings.HasPrefix(ref, "#/components/schemas/"), ref)
+		assert.Contains(t, schemas, strings.TrimPrefix(ref, "#/components/schemas/"))
+	}
+	assert.Contains(t, schemas["api.AuditSpec"].(map[string]interface{})["properties"], "action")
+	assert.Contains(t, schemas["api.AuditSpec_2"].(map[string]interface{})["properties"], "reason")
+}
+
+func collectRefs(v interface{}, refs map[string]bool) {
+	switch obj := v.(type) {
+	case map[string]interface{}:
+		for k, v := range obj {
+			if ref, ok := v.(string); ok && k == "$ref" {
+				refs[ref] = true
+				continue
+			}
+			collectRefs(v, refs)
+		}
+	case util.MapStr:
+		collectRefs(map[string]interface{}(obj), refs)
+	case []interface{}:
+		for _, v := range obj {
+			collectRefs(v, refs)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"strings"
+)
+
+// APISpec describes a registered api, it is used to generate the OpenAPI document
+type APISpec struct {
+	Method      string         `json:"method"`
+	Pattern     string         `json:"pattern"`
+	Summary     string         `json:"summary,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	Parameters  []APIParameter `json:"parameters,omitempty"`
+	Permission  string         `json:"permission,omitempty"`
+	Deprecated  bool           `json:"deprecated,omitempty"`
+	Audit       *AuditSpec     `json:"audit,omitempty"`
+
+	//sample objects of the request and response body, eg: CreatePipelineRequest{}
+	Request  interface{} `json:"-"`
+	Response interface{} `json:"-"`
+}
+
+// APIParameter is a path or query parameter
+type APIParameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`   //path or query
+	Type        string `json:"type"` //string, integer, number or boolean
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required"`
+}
+
+// AuditSpec describes how the calls of the api are recorded in the audit log
+type AuditSpec struct {
+	Disabled bool   `json:"disabled,omitempty"`
+	Action   string `json:"action,omitempty"`
+	//path parameters could be referenced, eg: pipeline/{id}
+	Target string `json:"target,omitempty"`
+}
+
+// Option is used to attach metadata to the api on registration
+type Option func(spec *APISpec)
+
+func WithSummary(summary string) Option {
+	return func(spec *APISpec) {
+		spec.Summary = summary
+	}
+}
+
+func WithDescription(description string) Option {
+	return func(spec *APISpec) {
+		spec.Description = description
+	}
+}
+
+func WithTags(tags ...string) Option {
+	return func(spec *APISpec) {
+		spec.Tags = append(spec.Tags, tags...)
+	}
+}
+
+// WithRequest set the request body type with a sample object
+func WithRequest(obj interface{}) Option {
+	return func(spec *APISpec) {
+		spec.Request = obj
+	}
+}
+
+// WithResponse set the response body type with a sample object
+func WithResponse(obj interface{}) Option {
+	return func(spec *APISpec) {
+		spec.Response = obj
+	}
+}
+
+// WithPathParam describe the path parameter, path parameters are detected from the pattern automatically
+func WithPathParam(name, description string) Option {
+	return func(spec *APISpec) {
+		spec.setParameter(APIParameter{Name: name, In: "path", Type: "string", Description: description, Required: true})
+	}
+}
+
+func WithQueryParam(name, paramType, description string, required bool) Option {
+	return func(spec *APISpec) {
+		spec.setParameter(APIParameter{Name: name, In: "query", Type: paramType, Description: description, Required: required})
+	}
+}
+
+// RequirePermission record the permission required to access the api
+func RequirePermission(permission string) Option {
+	return func(spec *APISpec) {
+		spec.Permission = permission
+	}
+}
+
+// WithAudit record the calls of the api in the audit log with the action and target,
+// apis with methods not audited by default, eg: GET, are audited as well
+func WithAudit(action, target string) Option {
+	return func(spec *APISpec) {
+		spec.Audit = &AuditSpec{Action: action, Target: target}
+	}
+}
+
+// WithoutAudit exclude the api from the audit log, eg: searches through POST
+func WithoutAudit() Option {
+	return func(spec *APISpec) {
+		spec.Audit = &AuditSpec{Disabled: true}
+	}
+}
+
+func Deprecated() Option {
+	return func(spec *APISpec) {
+		spec.Deprecated = true
+	}
+}
+
+func (spec *APISpec) setParameter(param APIParameter) {
+	for i, v := range spec.Parameters {
+		if v.Name == param.Name && v.In == param.In {
+			spec.Parameters[i] = param
+			return
+		}
+	}
+	spec.Parameters = append(spec.Parameters, param)
+}
+
+// newAPISpec parse path parameters from pattern like /queue/:id/consumer/:consumer_id or /files/*path
+func newAPISpec(method, pattern string, options ...Option) *APISpec {
+	spec := &APISpec{Method: method, Pattern: pattern}
+	for _, seg := range strings.Split(pattern, "/") {
+		if len(seg) > 1 && (seg[0] == ':' || seg[0] == '*') {
+			spec.Parameters = append(spec.Parameters, APIParameter{Name: seg[1:], In: "path", Type: "string", Required: true})
+		}
+	}
+	for _, opt := range options {
+		opt(spec)
+	}
+	return spec
+}
+
+var registeredAPISpecs = map[string]*APISpec{}
+
+// GetAPISpecs return all registered api specs
+func GetAPISpecs() []*APISpec {
+	l.Lock()
+	defer l.Unlock()
+	specs := make([]*APISpec, 0, len(registeredAPISpecs))
+	for _, v := range registeredAPISpecs {
+		specs = append(specs, v)
+	}
+	return specs
+}
+
+// GetAPISpec return the spec of the registered api
+func GetAPISpec(method Method, pattern string) *APISpec {
+	l.Lock()
+	defer l.Unlock()
+	return registeredAPISpecs[string(method)+" "+pattern]
+}
@@ -0,0 +1,108 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/pubsub"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// interval of the keepalive comments, proxies tend to close idle connections
+const sseHeartbeatInterval = 15 * time.Second
+
+// EventStreamHandler serve the subscribed topics as server-sent events,
+// eg: GET /_events?topics=pipeline.state,queue.*
+func EventStreamHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	topics := strings.Split(DefaultAPI.GetParameter(req, "topics"), ",")
+	subscriber, err := pubsub.Subscribe(pubsub.SubscriberOptions{
+		Principal:      security.GetPrincipal(req),
+		BufferSize:     DefaultAPI.GetIntOrDefault(req, "buffer_size", 0),
+		OverflowPolicy: DefaultAPI.GetParameter(req, "overflow"),
+	}, topics...)
+	if err != nil {
+		DefaultAPI.WriteError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	defer subscriber.Close()
+
+	if len(subscriber.Topics()) == 0 {
+		DefaultAPI.WriteError(w, "api.sse.no_topics", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		DefaultAPI.WriteError(w, "api.sse.streaming_unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	//the stream is long-lived, disable the write timeout of the server
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", 3000)
+	flusher.Flush()
+
+	log.Debugf("event stream subscribed, id: %v, topics: %v", subscriber.ID(), subscriber.Topics())
+
+	ticker := time.NewTicker(sseHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-subscriber.C():
+			if !ok {
+				//disconnected by the hub, client should reconnect
+				return
+			}
+			_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Topic, util.MustToJSONBytes(e))
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -30,6 +30,8 @@ package websocket
 import (
 	log "github.com/cihub/seelog"
 	"github.com/gorilla/websocket"
+	"infini.sh/framework/core/pubsub"
+	"infini.sh/framework/core/security"
 	"infini.sh/framework/core/util"
 	"net/http"
 	"strings"
@@ -67,6 +69,12 @@ type WebsocketConnection struct {
 	signalChannel chan []byte
 
 	handlers map[string]WebsocketHandlerFunc
+
+	// Authenticated user of the connection, used to authorize subscriptions
+	principal string
+
+	subLock    sync.Mutex
+	subscriber *pubsub.Subscriber
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -135,6 +143,8 @@ const (
 	PublicMessage MsgType = "PUBLIC"
 	// ConfigMessage used to send configuration
 	ConfigMessage MsgType = "CONFIG"
+	// EventMessage used to send the json events of subscribed topics
+	EventMessage MsgType = "EVENT"
 )
 
 // WritePrivateMessage will send msg to channel
@@ -181,6 +191,7 @@ func ServeWs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	c := &WebsocketConnection{id:util.GetUUID(),signalChannel: make(chan []byte, 256), ws: ws, handlers: h.handlers}
+	c.principal = security.GetPrincipal(r)
 	h.register <- c
 	go c.writePump()
 	c.readPump()
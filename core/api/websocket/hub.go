@@ -82,6 +82,9 @@ var runningHub = false
 // Register command handlers
 func (h *Hub) registerHandlers() {
 	HandleWebSocketCommand("HELP", "type `help` for more commands", helpCommand)
+	HandleWebSocketCommand("SUBSCRIBE", "subscribe topics, eg: `subscribe pipeline.state queue.*`", subscribeCommand)
+	HandleWebSocketCommand("UNSUBSCRIBE", "unsubscribe topics, eg: `unsubscribe queue.*`", unsubscribeCommand)
+	HandleWebSocketCommand("TOPICS", "list available topics", topicsCommand)
 }
 
 // InitWebSocket start websocket
@@ -161,6 +164,7 @@ func (h *Hub) runHub() {
 				delete(h.connections, c)
 				delete(h.sessions, c.id)
 				close(c.signalChannel)
+				c.closeSubscriber()
 			}
 		case m := <-h.broadcast:
 			h.broadcastMessage(m)
@@ -0,0 +1,101 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package websocket
+
+import (
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/pubsub"
+	"infini.sh/framework/core/util"
+	"strings"
+)
+
+// Subscribe the topics, events are pushed to the connection as `EVENT {json}`
+func (c *WebsocketConnection) Subscribe(topics ...string) error {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+
+	if c.subscriber != nil {
+		return c.subscriber.Subscribe(topics...)
+	}
+
+	s, err := pubsub.Subscribe(pubsub.SubscriberOptions{Principal: c.principal}, topics...)
+	if err != nil {
+		return err
+	}
+	c.subscriber = s
+	go c.forwardEvents(s)
+	return nil
+}
+
+func (c *WebsocketConnection) Unsubscribe(topics ...string) {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+	if c.subscriber != nil {
+		c.subscriber.Unsubscribe(topics...)
+	}
+}
+
+func (c *WebsocketConnection) closeSubscriber() {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+	if c.subscriber != nil {
+		c.subscriber.Close()
+		c.subscriber = nil
+	}
+}
+
+func (c *WebsocketConnection) forwardEvents(s *pubsub.Subscriber) {
+	for e := range s.C() {
+		if err := c.WriteMessage(EventMessage, string(util.MustToJSONBytes(e))); err != nil {
+			log.Debugf("failed to push event to websocket [%v], %v", c.id, err)
+			c.closeSubscriber()
+			return
+		}
+	}
+}
+
+func subscribeCommand(c *WebsocketConnection, a []string) {
+	if len(a) < 2 {
+		c.WritePrivateMessage("no topics specified")
+		return
+	}
+	if err := c.Subscribe(a[1:]...); err != nil {
+		c.WritePrivateMessage(err.Error())
+		return
+	}
+	c.WritePrivateMessage("subscribed: " + strings.Join(a[1:], ", "))
+}
+
+func unsubscribeCommand(c *WebsocketConnection, a []string) {
+	c.Unsubscribe(a[1:]...)
+	c.WritePrivateMessage("unsubscribed: " + strings.Join(a[1:], ", "))
+}
+
+func topicsCommand(c *WebsocketConnection, a []string) {
+	c.WriteMessage(ConfigMessage, string(util.MustToJSONBytes(pubsub.GetTopics())))
+}
@@ -0,0 +1,142 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	lock      sync.RWMutex
+	fileLog   *FileLog
+	queueName string
+)
+
+// Setup open the audit log with the config of the api server
+func Setup(cfg *config.APIAuditConfig) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if fileLog != nil {
+		return nil
+	}
+
+	dir := cfg.Path
+	if dir == "" {
+		dir = filepath.Join(global.Env().GetDataDir(), "audit")
+	}
+	maxFileSize := int64(DefaultMaxFileSize)
+	if cfg.MaxFileSizeInMB > 0 {
+		maxFileSize = int64(cfg.MaxFileSizeInMB) * 1024 * 1024
+	}
+	l, err := Open(dir, cfg.Sync, maxFileSize)
+	if err != nil {
+		return err
+	}
+	fileLog = l
+	queueName = cfg.Queue
+	global.RegisterShutdownCallback(Close)
+	log.Debugf("audit log enabled, path: %v", dir)
+	return nil
+}
+
+// Close close the audit log
+func Close() {
+	lock.Lock()
+	defer lock.Unlock()
+	if fileLog != nil {
+		if err := fileLog.Close(); err != nil {
+			log.Error("failed to close audit log: ", err)
+		}
+		fileLog = nil
+	}
+}
+
+// IsEnabled check if the audit log is opened
+func IsEnabled() bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return fileLog != nil
+}
+
+var errDisabled = errors.New("audit log is not enabled")
+
+// Write append the record to the audit log, and publish it to the queue if configured
+func Write(r *Record) error {
+	lock.RLock()
+	defer lock.RUnlock()
+	if fileLog == nil {
+		return errDisabled
+	}
+	if r.Node == "" && global.Env().SystemConfig != nil {
+		r.Node = global.Env().SystemConfig.NodeConfig.ID
+	}
+	if err := fileLog.Append(r); err != nil {
+		return err
+	}
+
+	if queueName != "" {
+		err := event.Save(&event.Event{
+			QueueName: queueName,
+			Metadata: event.EventMetadata{
+				Category: "audit",
+				Name:     r.Action,
+				Datatype: "event",
+			},
+			Fields: util.MapStr{
+				"audit": r,
+			},
+		})
+		if err != nil {
+			log.Error("failed to publish audit record: ", err)
+		}
+	}
+	return nil
+}
+
+// Search find records in the audit log
+func Search(q Query) (*SearchResult, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if fileLog == nil {
+		return nil, errDisabled
+	}
+	return fileLog.Search(q)
+}
+
+// Verify check the integrity of the audit log
+func Verify() (*VerifyResult, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	if fileLog == nil {
+		return nil, errDisabled
+	}
+	return fileLog.Verify()
+}
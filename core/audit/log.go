@@ -0,0 +1,528 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+const fileName = "audit.log"
+
+// maxLineSize is the max size of a record in the log file
+const maxLineSize = 1024 * 1024
+
+// DefaultMaxFileSize is the size of the log file to rotate at
+const DefaultMaxFileSize = 100 * 1024 * 1024
+
+// FileLog is a hash-chained append only log file, one json record per line, the file is rotated
+// to audit.log.<generation> when it grows over the max size, the chain continues in the new file
+type FileLog struct {
+	lock        sync.Mutex
+	dir         string
+	file        string
+	f           *os.File
+	sync        bool
+	maxFileSize int64
+	//size of the current file, the readers only scan the records written before they started
+	size       int64
+	generation int
+	seq        int64
+	lastHash   string
+	torn       *TornRecord
+}
+
+// TornRecord is the incomplete last record found on open, eg: the process crashed while writing,
+// it is moved out of the log to the quarantine file
+type TornRecord struct {
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Quarantine string `json:"quarantine"`
+}
+
+// Open open or create the audit log in the folder, the chain is recovered from the existing records,
+// maxFileSize <= 0 disables the rotation
+func Open(dir string, sync bool, maxFileSize int64) (*FileLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &FileLog{dir: dir, file: filepath.Join(dir, fileName), sync: sync, maxFileSize: maxFileSize}
+	rotated, err := l.rotatedFiles()
+	if err != nil {
+		return nil, err
+	}
+	l.generation = len(rotated)
+
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+	//the chain continues from the previous file after rotated
+	for i := len(rotated) - 1; i >= 0 && l.seq == 0; i-- {
+		err := scanFile(rotated[i], -1, func(r *Record, line int, offset int64, err error) error {
+			if err == nil {
+				l.seq = r.Seq
+				l.lastHash = r.Hash
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l.f, err = os.OpenFile(l.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := l.f.Stat()
+	if err != nil {
+		l.f.Close()
+		return nil, err
+	}
+	l.size = stat.Size()
+	return l, nil
+}
+
+// recover reads the chain from the current file, the torn last line is moved to the quarantine file
+// and cut off, the invalid lines before it are kept for Verify to report
+func (l *FileLog) recover() error {
+	tornLine, tornOffset := 0, int64(-1)
+	err := scanFile(l.file, -1, func(r *Record, line int, offset int64, err error) error {
+		if err != nil {
+			tornLine, tornOffset = line, offset
+			return nil
+		}
+		tornLine, tornOffset = 0, -1
+		l.seq = r.Seq
+		l.lastHash = r.Hash
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(l.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if tornOffset < 0 {
+		//the newline of the last record is missing, the next record starts from a new line
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			f, err := os.OpenFile(l.file, os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = f.Write([]byte{'\n'})
+			f.Close()
+			return err
+		}
+		return nil
+	}
+	quarantine := filepath.Join(l.dir, fmt.Sprintf("audit.torn.%v", time.Now().UnixNano()))
+	if err := os.WriteFile(quarantine, b[tornOffset:], 0600); err != nil {
+		return err
+	}
+	if err := os.Truncate(l.file, tornOffset); err != nil {
+		return err
+	}
+	l.torn = &TornRecord{File: fileName, Line: tornLine, Quarantine: filepath.Base(quarantine)}
+	log.Warnf("torn audit record at line %v of %v, moved to %v", tornLine, l.file, quarantine)
+	return nil
+}
+
+// rotatedFiles returns the rotated files, the oldest first
+func (l *FileLog) rotatedFiles() ([]string, error) {
+	matches, err := filepath.Glob(l.file + ".*")
+	if err != nil {
+		return nil, err
+	}
+	generations := []int{}
+	for _, v := range matches {
+		if i, err := strconv.Atoi(strings.TrimPrefix(v, l.file+".")); err == nil {
+			generations = append(generations, i)
+		}
+	}
+	sort.Ints(generations)
+	files := make([]string, 0, len(generations))
+	for _, i := range generations {
+		files = append(files, l.rotatedFile(i))
+	}
+	return files, nil
+}
+
+func (l *FileLog) rotatedFile(generation int) string {
+	return fmt.Sprintf("%v.%06d", l.file, generation)
+}
+
+// Append chain the record to the log and write it to disk
+func (l *FileLog) Append(r *Record) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.f == nil {
+		return errors.New("audit log is closed")
+	}
+
+	r.Seq = l.seq + 1
+	r.PrevHash = l.lastHash
+	if r.ID == "" {
+		r.ID = util.GetUUID()
+	}
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+	r.Timestamp = r.Timestamp.UTC()
+
+	hash, err := r.computeHash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	n, err := l.f.Write(append(b, '\n'))
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if l.sync {
+		if err := l.f.Sync(); err != nil {
+			return err
+		}
+	}
+	l.seq = r.Seq
+	l.lastHash = r.Hash
+
+	if l.maxFileSize > 0 && l.size >= l.maxFileSize {
+		//the record is written already, keep appending to the current file if failed
+		if err := l.rotate(); err != nil {
+			log.Errorf("failed to rotate audit log %v: %v", l.file, err)
+		}
+	}
+	return nil
+}
+
+func (l *FileLog) rotate() error {
+	target := l.rotatedFile(l.generation + 1)
+	if _, err := os.Stat(target); err == nil {
+		return errors.Errorf("rotated file %v already exists", target)
+	}
+	if err := os.Rename(l.file, target); err != nil {
+		return err
+	}
+	l.f.Close()
+	l.generation++
+	l.size = 0
+	var err error
+	l.f, err = os.OpenFile(l.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+// Close close the log file
+func (l *FileLog) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// snapshot is the files and the size of the current file at a point, which are scanned without the lock
+type snapshot struct {
+	files []string
+	//the current file is opened with the lock held, in case it is rotated before the scan
+	current  *os.File
+	size     int64
+	lastHash string
+}
+
+func (l *FileLog) snapshot() (*snapshot, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	files, err := l.rotatedFiles()
+	if err != nil {
+		return nil, err
+	}
+	s := &snapshot{files: files, size: l.size, lastHash: l.lastHash}
+	s.current, err = os.Open(l.file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *snapshot) close() {
+	if s.current != nil {
+		s.current.Close()
+	}
+}
+
+// scan walk through the records of all the files in order, stops at the first error returned by f
+func (s *snapshot) scan(f func(r *Record, file string, line int, err error) error) error {
+	for _, v := range s.files {
+		name := filepath.Base(v)
+		err := scanFile(v, -1, func(r *Record, line int, offset int64, err error) error {
+			return f(r, name, line, err)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if s.current == nil {
+		return nil
+	}
+	return scanReader(io.LimitReader(s.current, s.size), func(r *Record, line int, offset int64, err error) error {
+		return f(r, fileName, line, err)
+	})
+}
+
+// scanFile walk through the records of the file in order, the unparseable lines are passed with the error,
+// offset is the position of the line in the file
+func scanFile(file string, limit int64, f func(r *Record, line int, offset int64, err error) error) error {
+	fd, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fd.Close()
+	var reader io.Reader = fd
+	if limit >= 0 {
+		reader = io.LimitReader(fd, limit)
+	}
+	return scanReader(reader, f)
+}
+
+func scanReader(reader io.Reader, f func(r *Record, line int, offset int64, err error) error) error {
+	br := bufio.NewReaderSize(reader, 64*1024)
+	line := 0
+	var offset int64
+	for {
+		b, readErr := br.ReadBytes('\n')
+		if len(b) > 0 {
+			line++
+			start := offset
+			offset += int64(len(b))
+			b = bytes.TrimRight(b, "\r\n")
+			if len(b) > 0 {
+				r := &Record{}
+				var err error
+				if len(b) > maxLineSize {
+					err = errors.Errorf("invalid audit record at line %v: record too large", line)
+				} else if err = json.Unmarshal(b, r); err != nil {
+					err = errors.Errorf("invalid audit record at line %v: %v", line, err)
+				}
+				if err != nil {
+					r = nil
+				}
+				if err := f(r, line, start, err); err != nil {
+					return err
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// VerifyResult is the result of the integrity check of the audit log
+type VerifyResult struct {
+	Valid bool  `json:"valid"`
+	Total int64 `json:"total"`
+	//file and line of the first record that breaks the chain
+	File     string `json:"file,omitempty"`
+	BrokenAt int    `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	//the torn record cut off on open, which doesn't break the chain
+	Torn *TornRecord `json:"torn,omitempty"`
+}
+
+var errBroken = errors.New("broken chain")
+
+// Verify recompute the hashes and check the chain of the records
+func (l *FileLog) Verify() (*VerifyResult, error) {
+	s, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	result := &VerifyResult{Valid: true}
+	l.lock.Lock()
+	result.Torn = l.torn
+	l.lock.Unlock()
+
+	var prevHash string
+	var prevSeq int64
+	lastFile, lastLine := "", 0
+	err = s.scan(func(r *Record, file string, line int, err error) error {
+		lastFile, lastLine = file, line
+		reason := ""
+		if err != nil {
+			//unparseable lines break the chain as well
+			reason = err.Error()
+		} else if hash, err := r.computeHash(); err != nil {
+			reason = err.Error()
+		} else if r.PrevHash != prevHash {
+			reason = "previous hash mismatch"
+		} else if r.Seq != prevSeq+1 {
+			reason = fmt.Sprintf("sequence gap, expected %v, got %v", prevSeq+1, r.Seq)
+		} else if r.Hash != hash {
+			reason = "record hash mismatch"
+		}
+		if reason != "" {
+			result.Valid = false
+			result.File = file
+			result.BrokenAt = line
+			result.Reason = reason
+			return errBroken
+		}
+		result.Total++
+		prevHash = r.Hash
+		prevSeq = r.Seq
+		return nil
+	})
+	if err == errBroken {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if prevHash != s.lastHash {
+		result.Valid = false
+		result.File = lastFile
+		result.BrokenAt = lastLine
+		result.Reason = "log truncated"
+	}
+	return result, nil
+}
+
+// Query is the conditions to search audit records, empty conditions match all
+type Query struct {
+	Actor string
+	//exact action or prefix ending with *, eg: pipeline.*
+	Action  string
+	Target  string
+	Outcome string
+	Start   time.Time
+	End     time.Time
+	From    int
+	Size    int
+}
+
+func (q *Query) match(r *Record) bool {
+	if q.Actor != "" && q.Actor != r.Actor {
+		return false
+	}
+	if q.Action != "" {
+		if strings.HasSuffix(q.Action, "*") {
+			if !strings.HasPrefix(r.Action, strings.TrimSuffix(q.Action, "*")) {
+				return false
+			}
+		} else if q.Action != r.Action {
+			return false
+		}
+	}
+	if q.Target != "" && !strings.Contains(r.Target, q.Target) {
+		return false
+	}
+	if q.Outcome != "" && q.Outcome != r.Outcome {
+		return false
+	}
+	if !q.Start.IsZero() && r.Timestamp.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && !r.Timestamp.Before(q.End) {
+		return false
+	}
+	return true
+}
+
+// SearchResult is the matched records, newest first
+type SearchResult struct {
+	Total   int       `json:"total"`
+	Records []*Record `json:"records"`
+}
+
+// Search find the records matched the query, newest first
+func (l *FileLog) Search(q Query) (*SearchResult, error) {
+	s, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	if q.Size <= 0 {
+		q.Size = 20
+	}
+	var matched []*Record
+	err = s.scan(func(r *Record, file string, line int, err error) error {
+		//the invalid records are reported by Verify
+		if err == nil && q.match(r) {
+			matched = append(matched, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Seq > matched[j].Seq
+	})
+	result := &SearchResult{Total: len(matched), Records: []*Record{}}
+	if q.From < len(matched) {
+		end := q.From + q.Size
+		if end > len(matched) {
+			end = len(matched)
+		}
+		result.Records = matched[q.From:end]
+	}
+	return result, nil
+}
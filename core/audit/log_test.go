@@ -0,0 +1,169 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, true, 0)
+	assert.NoError(t, err)
+	now := time.Now()
+	for i, action := range []string{"pipeline:create", "pipeline:delete", "queue:delete"} {
+		outcome := OutcomeSuccess
+		if i == 1 {
+			outcome = OutcomeFailure
+		}
+		assert.NoError(t, l.Append(&Record{Actor: "admin", Action: action, Target: "pipeline/p1", Outcome: outcome, Timestamp: now.Add(time.Duration(i) * time.Second)}))
+	}
+	assert.NoError(t, l.Close())
+
+	//the chain is continued after reopen
+	l, err = Open(dir, false, 0)
+	assert.NoError(t, err)
+	r := &Record{Actor: "bob", Action: "keystore:set", Target: "keystore/es_password", Outcome: OutcomeSuccess, Details: map[string]string{"k": "v"}}
+	assert.NoError(t, l.Append(r))
+	assert.Equal(t, int64(4), r.Seq)
+	assert.NotEmpty(t, r.PrevHash)
+
+	result, err := l.Verify()
+	assert.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, int64(4), result.Total)
+
+	res, err := l.Search(Query{Action: "pipeline:*"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, "pipeline:delete", res.Records[0].Action)
+
+	res, err = l.Search(Query{Actor: "admin", Outcome: OutcomeSuccess, Size: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, 1, len(res.Records))
+	assert.Equal(t, "queue:delete", res.Records[0].Action)
+
+	res, err = l.Search(Query{Start: now.Add(time.Second), End: now.Add(2 * time.Second)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Total)
+	assert.Equal(t, "pipeline:delete", res.Records[0].Action)
+	assert.NoError(t, l.Close())
+
+	//tamper the second record
+	file := filepath.Join(dir, fileName)
+	b, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	lines := strings.Split(string(b), "\n")
+	lines[1] = strings.Replace(lines[1], `"outcome":"failure"`, `"outcome":"success"`, 1)
+	assert.NoError(t, ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0600))
+
+	l, err = Open(dir, false, 0)
+	assert.NoError(t, err)
+	defer l.Close()
+	result, err = l.Verify()
+	assert.NoError(t, err)
+	assert.False(t, result.Valid)
+	assert.Equal(t, 2, result.BrokenAt)
+	assert.Equal(t, "record hash mismatch", result.Reason)
+}
+
+func TestTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, false, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Append(&Record{Actor: "admin", Action: "pipeline:create"}))
+	assert.NoError(t, l.Close())
+
+	//crashed while writing the second record
+	f, err := os.OpenFile(filepath.Join(dir, fileName), os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"seq":2,"actor":"adm`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	l, err = Open(dir, false, 0)
+	assert.NoError(t, err)
+	defer l.Close()
+	r := &Record{Actor: "admin", Action: "pipeline:delete"}
+	assert.NoError(t, l.Append(r))
+	assert.Equal(t, int64(2), r.Seq)
+
+	result, err := l.Verify()
+	assert.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, int64(2), result.Total)
+	if assert.NotNil(t, result.Torn) {
+		assert.Equal(t, 2, result.Torn.Line)
+		b, err := ioutil.ReadFile(filepath.Join(dir, result.Torn.Quarantine))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"seq":2,"actor":"adm`, string(b))
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := Open(dir, false, 1)
+	assert.NoError(t, err)
+	for _, action := range []string{"pipeline:create", "pipeline:delete", "queue:delete"} {
+		assert.NoError(t, l.Append(&Record{Actor: "admin", Action: action}))
+	}
+	assert.NoError(t, l.Close())
+	rotated, err := l.rotatedFiles()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(rotated))
+
+	//the chain continues across the files
+	l, err = Open(dir, false, 0)
+	assert.NoError(t, err)
+	defer l.Close()
+	r := &Record{Actor: "admin", Action: "keystore:set"}
+	assert.NoError(t, l.Append(r))
+	assert.Equal(t, int64(4), r.Seq)
+
+	result, err := l.Verify()
+	assert.NoError(t, err)
+	assert.True(t, result.Valid)
+	assert.Equal(t, int64(4), result.Total)
+
+	res, err := l.Search(Query{Action: "pipeline:*"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, "pipeline:delete", res.Records[0].Action)
+}
@@ -0,0 +1,74 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Record is an entry of the audit log, records are chained by hash, any modification
+// of a written record breaks the chain from there on
+type Record struct {
+	ID           string            `json:"id"`
+	Seq          int64             `json:"seq"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Node         string            `json:"node,omitempty"`
+	Actor        string            `json:"actor"`
+	SourceIP     string            `json:"source_ip,omitempty"`
+	Method       string            `json:"method,omitempty"`
+	Path         string            `json:"path,omitempty"`
+	Action       string            `json:"action"`
+	Target       string            `json:"target,omitempty"`
+	Outcome      string            `json:"outcome"`
+	Status       int               `json:"status,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	DurationInMs int64             `json:"duration_in_ms"`
+	Details      map[string]string `json:"details,omitempty"`
+
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// computeHash hash the record together with the hash of the previous record
+func (r *Record) computeHash() (string, error) {
+	v := *r
+	v.Hash = ""
+	b, err := json.Marshal(&v)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(r.PrevHash))
+	h.Write([]byte("\n"))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
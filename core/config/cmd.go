@@ -0,0 +1,161 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"infini.sh/framework/core/util"
+)
+
+// RunCmd handles the config subcommands, eg: config validate -strict app.yml
+func RunCmd(defaultConfigFile string, args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("usage : config <command> [<args>]\n")
+		fmt.Printf("These are common config commands used in various situations:\n")
+		fmt.Printf("validate\tValidate config file offline, eg: config validate -strict app.yml\n")
+		fmt.Printf("schema\tPrint the json schema of configs, eg: config schema elasticsearch\n")
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var err error
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "validate":
+		err = validateCmd(defaultConfigFile, args)
+	case "schema":
+		err = schemaCmd(args)
+	default:
+		err = fmt.Errorf("unrecognized command %q, command must be one of: validate, schema", cmd)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func validateCmd(defaultConfigFile string, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "reject unknown keys")
+	configDir := fs.String("configs", "", "the config folder, default to path.configs of the config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	file := defaultConfigFile
+	if fs.NArg() > 0 {
+		file = fs.Arg(0)
+	}
+	if !util.FileExists(file) {
+		return fmt.Errorf("config file [%v] not exists", file)
+	}
+
+	cfg, err := LoadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to load config file [%v]: %v", file, err)
+	}
+
+	dir := *configDir
+	if dir == "" {
+		pathCfg := struct {
+			Path PathConfig `config:"path"`
+		}{}
+		if err := cfg.Unpack(&pathCfg); err != nil {
+			return err
+		}
+		if pathCfg.Path.Config != "" {
+			dir = pathCfg.Path.Config
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(file), dir)
+			}
+		}
+	}
+	if dir != "" && util.FileExists(dir) {
+		child, err := LoadPath(dir)
+		if err != nil {
+			return fmt.Errorf("failed to load config folder [%v]: %v", dir, err)
+		}
+		if err := cfg.Merge(child); err != nil {
+			return err
+		}
+	}
+
+	SetStrictMode(*strict)
+	errs := []string{}
+	sysCfg := SystemConfig{}
+	if err := cfg.Unpack(&sysCfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+	errs = append(errs, validateSections(cfg)...)
+
+	if *strict {
+		skipped, err := ValidateStrictKnownSections(cfg)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		for _, v := range skipped {
+			fmt.Printf("skip section [%v], no schema registered\n", v)
+		}
+	}
+
+	if len(errs) > 0 {
+		for _, v := range errs {
+			for _, line := range strings.Split(v, "; ") {
+				fmt.Println(line)
+			}
+		}
+		return fmt.Errorf("config file [%v] is invalid", file)
+	}
+
+	fmt.Printf("config file [%v] is valid\n", file)
+	return nil
+}
+
+func schemaCmd(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(util.MustToJSON(GetSchema()))
+		return nil
+	}
+	for _, k := range args {
+		v, ok := GetSectionSchema(k)
+		if !ok {
+			return fmt.Errorf("no schema registered for section [%v]", k)
+		}
+		fmt.Println(util.MustToJSON(ReflectSchema(v)))
+	}
+	return nil
+}
@@ -28,6 +28,7 @@
 package config
 
 import (
+	"path/filepath"
 	"runtime"
 	"sync"
 	"time"
@@ -80,6 +81,49 @@ func EnableWatcher(path string) {
 	log.Debugf("enable watcher on path: %v", path)
 }
 
+// the digests of the config files written by the process itself, eg: by the config transaction,
+// the events of these files are skipped by the watcher while the content is unchanged
+var ownWrites = map[string]string{}
+var ownWritesLock = sync.Mutex{}
+
+const deletedFileDigest = "deleted"
+
+// MarkFileWritten tells the watcher that the file is written with the content by the process itself
+func MarkFileWritten(file string, content string) {
+	markOwnWrite(file, util.MD5digest(content))
+}
+
+// MarkFileDeleted tells the watcher that the file is deleted by the process itself
+func MarkFileDeleted(file string) {
+	markOwnWrite(file, deletedFileDigest)
+}
+
+func markOwnWrite(file, digest string) {
+	file, _ = filepath.Abs(file)
+	ownWritesLock.Lock()
+	ownWrites[file] = digest
+	ownWritesLock.Unlock()
+}
+
+// isOwnWrite checks whether the current content of the file is written by the process itself
+func isOwnWrite(file string) bool {
+	file, _ = filepath.Abs(file)
+	ownWritesLock.Lock()
+	digest, ok := ownWrites[file]
+	ownWritesLock.Unlock()
+	if !ok {
+		return false
+	}
+	if !util.FileExists(file) {
+		return digest == deletedFileDigest
+	}
+	content, err := util.FileGetContent(file)
+	if err != nil {
+		return false
+	}
+	return digest == util.MD5digestString(content)
+}
+
 var watcherLock = sync.Once{}
 var watcherIsRunning = false
 
@@ -153,6 +197,11 @@ func AddPathToWatch(path string, callback CallbackFunc) {
 				time.Sleep(2 * time.Second)
 				log.Trace("2 seconds out, on:", ev.String())
 
+				if isOwnWrite(ev.Name) {
+					log.Tracef("skip the change made by the process itself: %v", ev.String())
+					continue
+				}
+
 				// AddPathToWatch
 
 				for _, v := range watcher.callbacks {
@@ -172,19 +221,43 @@ func AddPathToWatch(path string, callback CallbackFunc) {
 					continue
 				}
 
-				for k, v := range sectionCallbacks {
+				for _, k := range getWatchedSections() {
 					if cfg.HasField(k) {
 						currentCfg, err := cfg.Child(k, -1)
 						if err != nil {
 							log.Error(err)
 							continue
 						}
+
+						//skip invalid section, keep running with the previous config
+						if err := ValidateSection(k, currentCfg); err != nil {
+							log.Errorf("skip reloading config file [%v]: %v", ev.Name, err)
+							continue
+						}
+
+						//already applied, eg: by config transaction
+						if isSectionApplied(k, currentCfg) {
+							log.Debugf("config section [%v] not changed, skip reloading", k)
+							latestConfig[k] = currentCfg
+							continue
+						}
+
 						// diff config
 						previousCfg, _ := latestConfig[k]
-						for _, f := range v {
-							f(previousCfg, currentCfg)
+						if err := ReloadSection(k, previousCfg, currentCfg); err != nil {
+							log.Error(err)
+							if previousCfg != nil {
+								log.Warnf("rolling back config section [%v]", k)
+								if err := ReloadSection(k, currentCfg, previousCfg); err != nil {
+									log.Error(err)
+								}
+							}
+							continue
 						}
 						latestConfig[k] = currentCfg
+
+						//the legacy callbacks only see the applied config
+						notifySectionCallbacks(k, previousCfg, currentCfg)
 					}
 				}
 			}
@@ -273,6 +346,65 @@ func NotifyOnConfigSectionChange(configKey string, f func(pCfg, cCfg *Config)) {
 	sectionCallbacks[configKey] = v
 }
 
+func notifySectionCallbacks(configKey string, previous, current *Config) {
+	cfgLocker.RLock()
+	callbacks := sectionCallbacks[configKey]
+	cfgLocker.RUnlock()
+
+	for _, f := range callbacks {
+		f(previous, current)
+	}
+}
+
+// NotifyConfigCommitted invokes the section callbacks of the sections changed by a committed config transaction,
+// the sections are marked as applied, so that the watcher doesn't notify them again
+func NotifyConfigCommitted(previous, current *Config) {
+	cfgLocker.RLock()
+	keys := make([]string, 0, len(sectionCallbacks))
+	for k := range sectionCallbacks {
+		keys = append(keys, k)
+	}
+	cfgLocker.RUnlock()
+
+	for _, k := range keys {
+		previousSection := getChildSection(previous, k)
+		currentSection := getChildSection(current, k)
+		if currentSection == nil || SectionDigest(previousSection) == SectionDigest(currentSection) {
+			continue
+		}
+		markSectionApplied(k, currentSection)
+		notifySectionCallbacks(k, previousSection, currentSection)
+	}
+}
+
+func getChildSection(cfg *Config, key string) *Config {
+	if cfg == nil || !cfg.HasField(key) {
+		return nil
+	}
+	child, err := cfg.Child(key, -1)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return child
+}
+
+func getWatchedSections() []string {
+	cfgLocker.RLock()
+	defer cfgLocker.RUnlock()
+
+	keys := make([]string, 0, len(sectionCallbacks))
+	for k := range sectionCallbacks {
+		keys = append(keys, k)
+	}
+	for _, k := range GetReloadableSections() {
+		if _, ok := sectionCallbacks[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
 // NotifyOnConfigChange will trigger callback when any configuration file change detected
 func NotifyOnConfigChange(f func(fsnotify.Event)) {
 	cfgLocker.Lock()
@@ -0,0 +1,54 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnWrite(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "own.yml")
+	assert.False(t, isOwnWrite(file))
+
+	MarkFileWritten(file, "a: 1")
+	assert.Nil(t, os.WriteFile(file, []byte("a: 1"), 0644))
+	assert.True(t, isOwnWrite(file))
+
+	//edited by others
+	assert.Nil(t, os.WriteFile(file, []byte("a: 2"), 0644))
+	assert.False(t, isOwnWrite(file))
+
+	MarkFileDeleted(file)
+	assert.False(t, isOwnWrite(file))
+	assert.Nil(t, os.Remove(file))
+	assert.True(t, isOwnWrite(file))
+}
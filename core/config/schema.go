@@ -0,0 +1,342 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/util/jsonschema"
+)
+
+var (
+	strictMode     bool
+	schemaLocker               = sync.RWMutex{}
+	sectionSchemas             = map[string]interface{}{}
+	systemDefaults interface{} = SystemConfig{}
+)
+
+// SetStrictMode enables the strict validation, unknown keys will be rejected
+func SetStrictMode(strict bool) {
+	strictMode = strict
+}
+
+func IsStrictMode() bool {
+	return strictMode
+}
+
+// SetDefaultSystemConfig sets the default values of the top level system config, used as schema defaults
+func SetDefaultSystemConfig(cfg SystemConfig) {
+	schemaLocker.Lock()
+	defer schemaLocker.Unlock()
+	systemDefaults = cfg
+}
+
+// GetSectionSchema returns the registered schema object of the section
+func GetSectionSchema(configKey string) (interface{}, bool) {
+	schemaLocker.RLock()
+	defer schemaLocker.RUnlock()
+	v, ok := sectionSchemas[configKey]
+	return v, ok
+}
+
+// GetSectionSchemas returns the json schema of the registered sections
+func GetSectionSchemas() map[string]*jsonschema.Schema {
+	schemaLocker.RLock()
+	defer schemaLocker.RUnlock()
+	result := map[string]*jsonschema.Schema{}
+	for k, v := range sectionSchemas {
+		result[k] = ReflectSchema(v)
+	}
+	return result
+}
+
+// GetSchema returns the json schema of the whole config file, which is made of the system config and
+// the registered sections, registered sections are allowed at the top level and in the modules and plugins
+func GetSchema() *jsonschema.Schema {
+	schemaLocker.RLock()
+	root := ReflectSchema(systemDefaults)
+	for k, v := range sectionSchemas {
+		root.Properties[k] = ReflectSchema(v)
+	}
+	schemaLocker.RUnlock()
+
+	//variables used by the templates
+	root.Properties["env"] = &jsonschema.Schema{Type: "object", Description: "variables used to render the config"}
+	if configs, ok := root.Properties["configs"]; ok && configs.Properties != nil {
+		configs.Properties["template"] = ReflectSchema([]ConfigTemplate{})
+	}
+	return root
+}
+
+var (
+	configType   = reflect.TypeOf(Config{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	mapStrType   = reflect.TypeOf(map[string]interface{}{})
+)
+
+// ReflectSchema generates the json schema of config struct by the `config` tags, structs are expanded,
+// unknown properties are not allowed, fields with `validate:"required"` are required, the non-zero field
+// values of the object and the `default_value` tags are used as defaults, and `enum:"a,b"` tags as enums
+func ReflectSchema(obj interface{}) *jsonschema.Schema {
+	r := jsonschema.New("")
+	r.ExpandStructs = true
+	r.DisallowAdditionalProperties = true
+	r.FieldMapper = configFieldMapper
+	r.TypeMapper = configTypeMapper
+	r.IsRequired = func(f reflect.StructField, field jsonschema.Field) bool {
+		v := f.Tag.Get("validate")
+		return hasTagOption(v, "required") || hasTagOption(v, "nonzero")
+	}
+	r.FieldHook = configFieldHook
+
+	schema := r.Reflect(obj)
+	if obj != nil {
+		applyDefaults(schema, reflect.ValueOf(obj))
+	}
+	return schema
+}
+
+func configFieldMapper(f reflect.StructField) jsonschema.Field {
+	field := jsonschema.TagFieldMapper("config")(f)
+	tag := f.Tag.Get("config")
+	if hasTagOption(tag, "ignore") {
+		field.Skip = true
+	}
+	if hasTagOption(tag, "squash") {
+		field.Inline = true
+	}
+	//same as go-ucfg
+	if strings.Split(tag, ",")[0] == "" {
+		field.Name = strings.ToLower(f.Name)
+	}
+	return field
+}
+
+func configTypeMapper(t reflect.Type) *jsonschema.Schema {
+	switch t {
+	case configType:
+		//free-form nested config
+		return &jsonschema.Schema{}
+	case durationType:
+		return &jsonschema.Schema{Type: "string", Format: "duration"}
+	case mapStrType:
+		return &jsonschema.Schema{Type: "object"}
+	}
+	return nil
+}
+
+func configFieldHook(f reflect.StructField, s *jsonschema.Schema) {
+	if v, ok := f.Tag.Lookup("enum"); ok && v != "" {
+		for _, x := range strings.Split(v, ",") {
+			s.Enum = append(s.Enum, strings.TrimSpace(x))
+		}
+	}
+	if v, ok := f.Tag.Lookup("default_value"); ok {
+		if s.Type == "boolean" {
+			s.Default = v == "true"
+		} else {
+			s.Default = v
+		}
+	}
+}
+
+func hasTagOption(tag, option string) bool {
+	for _, v := range strings.Split(tag, ",") {
+		if strings.TrimSpace(v) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// applyDefaults walks the object along with the schema, the non-zero values are recorded as defaults
+func applyDefaults(schema *jsonschema.Schema, v reflect.Value) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct || schema == nil || schema.Properties == nil {
+		return
+	}
+	if v.Type() == configType {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		field := configFieldMapper(f)
+		if field.Skip {
+			continue
+		}
+		fv := v.Field(i)
+		if field.Inline {
+			applyDefaults(schema, fv)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		prop, ok := schema.Properties[field.Name]
+		if !ok || fv.IsZero() {
+			continue
+		}
+
+		ft := fv
+		for ft.Kind() == reflect.Ptr && !ft.IsNil() {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Type() == durationType:
+			prop.Default = time.Duration(ft.Int()).String()
+		case ft.Kind() == reflect.Struct:
+			applyDefaults(prop, ft)
+		case ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Func || ft.Kind() == reflect.Chan:
+			continue
+		default:
+			if ft.CanInterface() {
+				prop.Default = ft.Interface()
+			}
+		}
+	}
+}
+
+// ValidateStrict checks the config against the schema, unknown keys are rejected
+func ValidateStrict(cfg *Config) error {
+	_, err := validateStrict(cfg, false)
+	return err
+}
+
+// ValidateStrictKnownSections is the same as ValidateStrict, but the top level sections without schema
+// are skipped and returned, the sections of the app and plugins may have no schema registered
+func ValidateStrictKnownSections(cfg *Config) ([]string, error) {
+	return validateStrict(cfg, true)
+}
+
+func validateStrict(cfg *Config, skipUnknownSections bool) ([]string, error) {
+	obj := map[string]interface{}{}
+	if err := cfg.Unpack(&obj); err != nil {
+		return nil, err
+	}
+
+	root := GetSchema()
+	skipped := []string{}
+	if skipUnknownSections {
+		for k := range obj {
+			if _, ok := root.Properties[k]; !ok {
+				skipped = append(skipped, k)
+			}
+		}
+		sort.Strings(skipped)
+		root.AdditionalProperties = true
+	}
+
+	errs := jsonschema.Validate(root, obj)
+
+	//registered sections can also be configured in modules and plugins
+	for _, key := range []string{"modules", "plugins"} {
+		items, ok := obj[key].([]interface{})
+		if !ok {
+			continue
+		}
+		for i, item := range items {
+			m, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := m["name"].(string)
+			if schema, ok := GetSectionSchema(name); ok {
+				s := ReflectSchema(schema)
+				if s.Properties != nil {
+					s.Properties["name"] = &jsonschema.Schema{Type: "string"}
+				}
+				for _, e := range jsonschema.Validate(s, m) {
+					e.Path = joinPath(fmt.Sprintf("%v[%d]", key, i), e.Path)
+					errs = append(errs, e)
+				}
+			}
+		}
+	}
+	return skipped, validationErrors(errs)
+}
+
+func validateSectionStrict(configKey string, schema interface{}, cfg *Config) error {
+	var obj interface{}
+	var err error
+	if cfg.IsArray() {
+		v := []interface{}{}
+		err = cfg.Unpack(&v)
+		obj = v
+	} else {
+		v := map[string]interface{}{}
+		err = cfg.Unpack(&v)
+		obj = v
+	}
+	if err != nil {
+		return err
+	}
+	errs := jsonschema.Validate(ReflectSchema(schema), obj)
+	for i := range errs {
+		errs[i].Path = joinPath(configKey, errs[i].Path)
+	}
+	return validationErrors(errs)
+}
+
+// ValidateWithSchema checks the config against the schema of the object, unknown keys are rejected
+func ValidateWithSchema(schema interface{}, cfg *Config) error {
+	return validateSectionStrict("", schema, cfg)
+}
+
+func validationErrors(errs []jsonschema.ValidationError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	sort.Strings(msgs)
+	return fmt.Errorf("%v", strings.Join(msgs, "; "))
+}
+
+func joinPath(prefix, path string) string {
+	if prefix == "" {
+		return path
+	}
+	if path == "" || strings.HasPrefix(path, "[") {
+		return prefix + path
+	}
+	return prefix + "." + path
+}
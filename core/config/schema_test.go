@@ -0,0 +1,94 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type schemaTestConfig struct {
+	Enabled       bool          `config:"enabled"`
+	Name          string        `config:"name" validate:"required"`
+	BatchSizeInKB int           `config:"batch_size_in_kb"`
+	Mode          string        `config:"mode" enum:"sync,async"`
+	Timeout       time.Duration `config:"timeout"`
+	Processors    []*Config     `config:"processor"`
+}
+
+func TestReflectSchema(t *testing.T) {
+	schema := ReflectSchema(schemaTestConfig{Enabled: true, BatchSizeInKB: 5120, Timeout: 10 * time.Second})
+	assert.Equal(t, []string{"name"}, schema.Required)
+	assert.Equal(t, false, schema.AdditionalProperties)
+	assert.Equal(t, true, schema.Properties["enabled"].Default)
+	assert.Equal(t, 5120, schema.Properties["batch_size_in_kb"].Default)
+	assert.Equal(t, "10s", schema.Properties["timeout"].Default)
+	assert.Equal(t, "string", schema.Properties["timeout"].Type)
+	assert.Equal(t, []interface{}{"sync", "async"}, schema.Properties["mode"].Enum)
+	assert.Nil(t, schema.Properties["name"].Default)
+	assert.Equal(t, "array", schema.Properties["processor"].Type)
+}
+
+func TestValidateStrict(t *testing.T) {
+	RegisterSectionSchema("strict_test", &schemaTestConfig{Enabled: true})
+
+	cfg, err := NewConfigWithYAML([]byte(`
+strict_test:
+  name: test
+  batch_size_in_kbs: 10
+  processor:
+    - any_processor:
+        any_key: value
+`), "test")
+	assert.NoError(t, err)
+
+	child, err := cfg.Child("strict_test", -1)
+	assert.NoError(t, err)
+	assert.NoError(t, validateSection("strict_test", child, false))
+
+	err = validateSection("strict_test", child, true)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "strict_test.batch_size_in_kbs: unknown property, did you mean [batch_size_in_kb]?")
+
+	cfg, err = NewConfigWithYAML([]byte("strict_test:\n  name: test\nunknown_section:\n  key: value\n"), "test")
+	assert.NoError(t, err)
+	err = ValidateStrict(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown_section: unknown property")
+
+	skipped, err := ValidateStrictKnownSections(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"unknown_section"}, skipped)
+
+	//unknown top level sections are only warned at runtime
+	SetStrictMode(true)
+	defer SetStrictMode(false)
+	assert.NoError(t, Validate(cfg))
+}
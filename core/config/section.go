@@ -0,0 +1,260 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/cihub/seelog"
+)
+
+// SectionValidator checks the candidate config of a section before it is applied
+type SectionValidator func(cfg *Config) error
+
+// SectionReloader applies the new config of a section, previous or current is nil if the section is absent,
+// a returned error or panic means the section can't be applied and the change should be rolled back
+type SectionReloader func(previous, current *Config) error
+
+var sectionValidators = map[string][]SectionValidator{}
+var sectionReloaders = map[string][]SectionReloader{}
+var appliedSections = map[string]string{}
+var sectionLocker = sync.RWMutex{}
+
+// RegisterSectionSchema registers the struct of a config section, candidate configs of this section
+// must be able to unpack into a new instance of the schema, validate tags and Validate() are honored,
+// the field values of the schema object are used as the defaults in the generated json schema
+func RegisterSectionSchema(configKey string, schema interface{}) {
+	v := reflect.ValueOf(schema)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v = reflect.New(v.Type().Elem()).Elem()
+			break
+		}
+		v = v.Elem()
+	}
+	schemaLocker.Lock()
+	defer schemaLocker.Unlock()
+	sectionSchemas[configKey] = v.Interface()
+}
+
+// RegisterSectionValidator registers a custom validator for the config section
+func RegisterSectionValidator(configKey string, f SectionValidator) {
+	sectionLocker.Lock()
+	defer sectionLocker.Unlock()
+	sectionValidators[configKey] = append(sectionValidators[configKey], f)
+}
+
+// RegisterSectionReloader registers the reloader of the config section, reloaders are invoked on
+// file changes and config transactions, and invoked again with swapped configs on rollback
+func RegisterSectionReloader(configKey string, f SectionReloader) {
+	sectionLocker.Lock()
+	defer sectionLocker.Unlock()
+	sectionReloaders[configKey] = append(sectionReloaders[configKey], f)
+}
+
+// GetReloadableSections returns the config keys which have reloaders registered
+func GetReloadableSections() []string {
+	sectionLocker.RLock()
+	defer sectionLocker.RUnlock()
+	keys := make([]string, 0, len(sectionReloaders))
+	for k := range sectionReloaders {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ValidateSection validates the config of the section with the registered schema and validators,
+// unknown keys are rejected in strict mode
+func ValidateSection(configKey string, cfg *Config) error {
+	return validateSection(configKey, cfg, IsStrictMode())
+}
+
+func validateSection(configKey string, cfg *Config, strict bool) error {
+	if schema, ok := GetSectionSchema(configKey); ok {
+		obj := reflect.New(reflect.TypeOf(schema))
+		if err := cfg.Unpack(obj.Interface()); err != nil {
+			return fmt.Errorf("invalid config section [%v]: %v", configKey, err)
+		}
+		if strict {
+			if err := validateSectionStrict(configKey, schema, cfg); err != nil {
+				return fmt.Errorf("invalid config section [%v]: %v", configKey, err)
+			}
+		}
+	}
+
+	sectionLocker.RLock()
+	validators := sectionValidators[configKey]
+	sectionLocker.RUnlock()
+
+	for _, f := range validators {
+		if err := f(cfg); err != nil {
+			return fmt.Errorf("invalid config section [%v]: %v", configKey, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates all the registered sections present in the config, and the whole config
+// is checked against the schema in strict mode, the top level sections without schema are only warned,
+// as they may belong to the app or plugins which parse their configs on their own
+func Validate(cfg *Config) error {
+	errs := validateSections(cfg)
+	if IsStrictMode() {
+		skipped, err := ValidateStrictKnownSections(cfg)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		for _, v := range skipped {
+			log.Warnf("unknown config section [%v], no schema registered", v)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func validateSections(cfg *Config) []string {
+	set := map[string]bool{}
+	schemaLocker.RLock()
+	for k := range sectionSchemas {
+		set[k] = true
+	}
+	schemaLocker.RUnlock()
+	sectionLocker.RLock()
+	for k := range sectionValidators {
+		set[k] = true
+	}
+	sectionLocker.RUnlock()
+
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	errs := []string{}
+	for _, k := range keys {
+		if !cfg.HasField(k) {
+			continue
+		}
+		child, err := cfg.Child(k, -1)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid config section [%v]: %v", k, err))
+			continue
+		}
+		if err := validateSection(k, child, false); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	return errs
+}
+
+// ReloadSection invokes the registered reloaders of the section, panics are recovered as errors
+func ReloadSection(configKey string, previous, current *Config) (err error) {
+	sectionLocker.RLock()
+	reloaders := sectionReloaders[configKey]
+	sectionLocker.RUnlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			var v string
+			switch r.(type) {
+			case error:
+				v = r.(error).Error()
+			case runtime.Error:
+				v = r.(runtime.Error).Error()
+			case string:
+				v = r.(string)
+			default:
+				v = fmt.Sprint(r)
+			}
+			err = fmt.Errorf("failed to reload config section [%v]: %v", configKey, v)
+		}
+	}()
+
+	for _, f := range reloaders {
+		if err = f(previous, current); err != nil {
+			return fmt.Errorf("failed to reload config section [%v]: %v", configKey, err)
+		}
+	}
+
+	markSectionApplied(configKey, current)
+	return nil
+}
+
+func markSectionApplied(configKey string, current *Config) {
+	sectionLocker.Lock()
+	appliedSections[configKey] = SectionDigest(current)
+	sectionLocker.Unlock()
+}
+
+// SectionDigest returns the digest of the config content, used to skip unchanged sections
+func SectionDigest(cfg *Config) string {
+	if cfg == nil {
+		return ""
+	}
+	var obj interface{}
+	var err error
+	if cfg.IsArray() {
+		v := []interface{}{}
+		err = cfg.Unpack(&v)
+		obj = v
+	} else {
+		v := map[string]interface{}{}
+		err = cfg.Unpack(&v)
+		obj = v
+	}
+	if err != nil {
+		log.Debugf("failed to unpack config for digest: %v", err)
+		return ""
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return ""
+	}
+	h := md5.Sum(data)
+	return hex.EncodeToString(h[:])
+}
+
+func isSectionApplied(configKey string, cfg *Config) bool {
+	sectionLocker.RLock()
+	v, ok := appliedSections[configKey]
+	sectionLocker.RUnlock()
+	return ok && v != "" && v == SectionDigest(cfg)
+}
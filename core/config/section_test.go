@@ -0,0 +1,115 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sectionTestConfig struct {
+	Name string `config:"name" validate:"required"`
+	Port int    `config:"port"`
+}
+
+func TestValidateSection(t *testing.T) {
+	RegisterSectionSchema("section_test", sectionTestConfig{})
+
+	cfg, err := NewConfigWithYAML([]byte("section_test:\n  name: a\n  port: 8080\n"), "test")
+	assert.NoError(t, err)
+	assert.NoError(t, Validate(cfg))
+
+	cfg, err = NewConfigWithYAML([]byte("section_test:\n  port: abc\n"), "test")
+	assert.NoError(t, err)
+	assert.Error(t, Validate(cfg))
+
+	//absent sections are skipped
+	cfg, err = NewConfigWithYAML([]byte("other:\n  port: abc\n"), "test")
+	assert.NoError(t, err)
+	assert.NoError(t, Validate(cfg))
+}
+
+func TestReloadSection(t *testing.T) {
+	applied := ""
+	RegisterSectionReloader("reload_test", func(previous, current *Config) error {
+		v := sectionTestConfig{}
+		if err := current.Unpack(&v); err != nil {
+			return err
+		}
+		if v.Name == "panic" {
+			panic("unexpected name")
+		}
+		if v.Name == "error" {
+			return errors.New("unexpected name")
+		}
+		applied = v.Name
+		return nil
+	})
+
+	cfg, err := NewConfigWithYAML([]byte("name: a\n"), "test")
+	assert.NoError(t, err)
+	assert.NoError(t, ReloadSection("reload_test", nil, cfg))
+	assert.Equal(t, "a", applied)
+	assert.True(t, isSectionApplied("reload_test", cfg))
+
+	cfg1, err := NewConfigWithYAML([]byte("name: error\n"), "test")
+	assert.NoError(t, err)
+	assert.Error(t, ReloadSection("reload_test", cfg, cfg1))
+	assert.False(t, isSectionApplied("reload_test", cfg1))
+
+	cfg2, err := NewConfigWithYAML([]byte("name: panic\n"), "test")
+	assert.NoError(t, err)
+	assert.Error(t, ReloadSection("reload_test", cfg, cfg2))
+	assert.Equal(t, "a", applied)
+}
+
+func TestNotifyConfigCommitted(t *testing.T) {
+	notified := []string{}
+	NotifyOnConfigSectionChange("callback_test", func(previous, current *Config) {
+		v := sectionTestConfig{}
+		assert.NoError(t, current.Unpack(&v))
+		notified = append(notified, v.Name)
+	})
+
+	previous, err := NewConfigWithYAML([]byte("callback_test:\n  name: a\n"), "test")
+	assert.NoError(t, err)
+	current, err := NewConfigWithYAML([]byte("callback_test:\n  name: b\n"), "test")
+	assert.NoError(t, err)
+
+	//unchanged sections are not notified
+	NotifyConfigCommitted(previous, previous)
+	assert.Empty(t, notified)
+
+	NotifyConfigCommitted(previous, current)
+	assert.Equal(t, []string{"b"}, notified)
+	section, err := current.Child("callback_test", -1)
+	assert.NoError(t, err)
+	assert.True(t, isSectionApplied("callback_test", section))
+}
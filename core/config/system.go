@@ -208,6 +208,8 @@ type ConfigsConfig struct {
 	SoftDelete                 bool      `config:"soft_delete"`                    //soft delete config
 	PanicOnConfigError         bool      `config:"panic_on_config_error"`          //panic on config error
 	MaxBackupFiles             int       `config:"max_backup_files"`               //keep max num of file backup
+	MaxVersions                int       `config:"max_versions"`                   //keep max num of config versions
+	Strict                     bool      `config:"strict"`                         //reject unknown config keys
 	ValidConfigsExtensions     []string  `config:"valid_config_extensions"`
 	TLSConfig                  TLSConfig `config:"tls"` //server or client's certs
 	ManagerConfig              struct {
@@ -236,6 +238,56 @@ type APISecurityConfig struct {
 	Password string `json:"password,omitempty" config:"password" elastic_mapping:"password:{type:keyword}"`
 }
 
+// APIRateLimitConfig controls request throttling of the API server
+type APIRateLimitConfig struct {
+	Enabled bool `config:"enabled"`
+
+	//share counters across nodes through the kv store
+	Distributed bool `config:"distributed"`
+
+	//proxies trusted to set X-Forwarded-For and X-Real-IP, ip or cidr, the remote address is used if empty
+	TrustedProxies []string `config:"trusted_proxies"`
+
+	Rules []APIRateLimitRule `config:"rules"`
+}
+
+type APIRateLimitRule struct {
+	Name     string   `config:"name"`
+	Patterns []string `config:"patterns"` //route patterns, wildcard supported, eg: /pipeline/tasks/*
+	Methods  []string `config:"methods"`  //empty means all methods
+
+	//limit by: route, ip or principal
+	Per string `config:"per" enum:"route,ip,principal"`
+
+	Limit    int    `config:"limit"`    //max requests within interval
+	Burst    int    `config:"burst"`    //bucket capacity, default to limit
+	Interval string `config:"interval"` //default to 1s
+}
+
+// APIAuditConfig controls the audit log of mutating api calls
+type APIAuditConfig struct {
+	Enabled bool `config:"enabled"`
+
+	//folder of the hash-chained audit log, default: audit under the data folder
+	Path string `config:"path"`
+
+	//fsync after every record
+	Sync bool `config:"sync"`
+
+	//rotate the log file when it grows over the size, the rotated files are kept, default: 100
+	MaxFileSizeInMB int `config:"max_file_size_in_mb"`
+
+	//publish the records to the queue through core/event as well
+	Queue string `config:"queue"`
+
+	//methods audited by default, apis could opt in or out by annotation, default: POST, PUT, DELETE, PATCH
+	Methods []string `config:"methods"`
+
+	//header of the actor claimed by the client, eg: X-On-Behalf-Of, recorded as the claimed_actor detail,
+	//the actor is always the authenticated user
+	ActorHeader string `config:"actor_header"`
+}
+
 type WebAppConfig struct {
 
 	//same with API Config
@@ -259,6 +311,9 @@ type WebAppConfig struct {
 }
 
 type S3Config struct {
+	//s3 or local, the local store keeps the objects in the path, default s3
+	Type               string `config:"type" json:"type,omitempty"`
+	Path               string `config:"path" json:"path,omitempty"`
 	Endpoint           string `config:"endpoint" json:"endpoint,omitempty"`
 	AccessKey          string `config:"access_key" json:"access_key,omitempty"`
 	AccessSecret       string `config:"access_secret" json:"access_secret,omitempty"`
@@ -298,6 +353,10 @@ type APIConfig struct {
 
 	Security APISecurityConfig `config:"security"`
 
+	RateLimit APIRateLimitConfig `config:"rate_limit"`
+
+	Audit APIAuditConfig `config:"audit"`
+
 	CrossDomain struct {
 		AllowedOrigins []string `config:"allowed_origins"`
 	} `config:"cors"`
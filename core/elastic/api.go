@@ -41,6 +41,7 @@ package elastic
 
 import (
 	"context"
+	"errors"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/fasthttp"
 	"net/url"
@@ -48,6 +49,7 @@ import (
 
 type API interface {
 	ScrollAPI
+	PointInTimeAPI
 	MappingAPI
 	TemplateAPI
 	ReplicationAPI
@@ -151,6 +153,17 @@ type MappingAPI interface {
 	UpdateMapping(indexName string, docType string, mappings []byte) ([]byte, error)
 }
 
+// PointInTimeAPI keeps a consistent view of the indices between the searches, eg: paging with search_after
+type PointInTimeAPI interface {
+	//OpenPointInTime returns the id of the point in time, ErrPointInTimeNotSupported if not supported by the cluster
+	OpenPointInTime(indexNames string, keepAlive string) (string, error)
+	ClosePointInTime(pitID string) error
+	//SearchWithPointInTime searches with the pit of the query dsl, the pit id of the response should be used in the next search
+	SearchWithPointInTime(ctx context.Context, queryDSL []byte) (*SearchResponse, error)
+}
+
+var ErrPointInTimeNotSupported = errors.New("point in time is not supported")
+
 type ScrollAPI interface {
 	NewScroll(indexNames string, scrollTime string, docBufferCount int, query *SearchRequest, slicedId, maxSlicedCount int) ([]byte, error)
 	NextScroll(ctx *APIContext, scrollTime string, scrollId string) ([]byte, error)
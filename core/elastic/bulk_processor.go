@@ -606,7 +606,7 @@ DO:
 						}
 
 						data := req.OverrideBodyEncode(bodyBytes, true)
-						queue.Push(queue.GetOrInitConfig(metadata.Config.ID+"_dead_letter_queue"), data)
+						queue.Push(queue.ScopeFromContext(ctx).GetOrInitConfig(metadata.Config.ID+"_dead_letter_queue"), data)
 						return true, statsRet, bulkResult, errors.Errorf("bulk partial failure, retried %v times, quit retry", retryTimes)
 					}
 					log.Infof("%v, bulk partial failure, #%v retry, %v items left, size: %v, stats:%v", tag, retryTimes, retryableItems.GetMessageCount(), retryableItems.GetMessageSize(), statsCodeStats)
@@ -625,7 +625,7 @@ DO:
 				if nonRetryableItems.GetMessageCount() > 0 {
 					////handle 400 error
 					if joint.Config.InvalidRequestsQueue != "" {
-						queue.Push(queue.GetOrInitConfig(joint.Config.InvalidRequestsQueue), data)
+						queue.Push(queue.ScopeFromContext(ctx).GetOrInitConfig(joint.Config.InvalidRequestsQueue), data)
 					}
 				}
 				return continueNext, statsRet, bulkResult, errors.Errorf("bulk response contains error, config: %v, non-retryable docs: %v, retryable docs:%v", metadata.Config.Name, nonRetryableItems.GetMessageCount(), retryableItems.GetMessageCount())
@@ -647,7 +647,7 @@ DO:
 		} else if resp.StatusCode() >= 400 && resp.StatusCode() < 500 {
 			////handle 400 error
 			if joint.Config.InvalidRequestsQueue != "" {
-				queue.Push(queue.GetOrInitConfig(joint.Config.InvalidRequestsQueue), data)
+				queue.Push(queue.ScopeFromContext(ctx).GetOrInitConfig(joint.Config.InvalidRequestsQueue), data)
 				return true, statsRet, bulkResult, nil
 			}
 			return false, statsRet, bulkResult, errors.Errorf("invalid requests, code: %v", resp.StatusCode())
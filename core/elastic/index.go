@@ -177,6 +177,11 @@ type IndexDocument struct {
 	Routing   string                   `json:"_routing,omitempty"`
 	Source    map[string]interface{}   `json:"_source,omitempty"`
 	Highlight map[string][]interface{} `json:"highlight,omitempty"`
+	//available if seq_no_primary_term is requested
+	SeqNo       int64 `json:"_seq_no,omitempty"`
+	PrimaryTerm int64 `json:"_primary_term,omitempty"`
+	//sort values of the hit, used as the search_after of the next page
+	Sort []interface{} `json:"sort,omitempty"`
 }
 
 type BucketBase map[string]interface{}
@@ -294,6 +299,7 @@ type SearchResponse struct {
 		Hits     []IndexDocument `json:"hits,omitempty"`
 	} `json:"hits"`
 	Aggregations map[string]AggregationResponse `json:"aggregations,omitempty"`
+	PitID        string                         `json:"pit_id,omitempty"`
 }
 
 func (response *SearchResponse) GetTotal() int64 {
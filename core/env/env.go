@@ -256,6 +256,10 @@ var moduleConfig map[string]*config.Config
 var pluginConfig map[string]*config.Config
 var startTime = time.Now().UTC()
 
+func init() {
+	config.SetDefaultSystemConfig(GetDefaultSystemConfig())
+}
+
 func GetDefaultSystemConfig() config.SystemConfig  {
 	return config.SystemConfig{
 		APIConfig: config.APIConfig{
@@ -323,6 +327,7 @@ func GetDefaultSystemConfig() config.SystemConfig  {
 			ConfigFileManagedByDefault: true,
 			PanicOnConfigError:         true,
 			MaxBackupFiles:             10,
+			MaxVersions:                50,
 			ValidConfigsExtensions:     []string{".tpl", ".json", ".yml", ".yaml"},
 		},
 		HTTPClientConfig: config.HTTPClientConfig{
@@ -408,6 +413,7 @@ func (env *Env) loadEnvFromConfigFile(filename string) error {
 		return err
 	}
 	env.SystemConfig=&tempCfg
+	config.SetStrictMode(tempCfg.Configs.Strict)
 	//initialize node config
 	env.findWorkingDir()
 
@@ -527,9 +533,19 @@ func LoadConfigContents(path string) (contents string, err error) {
 }
 
 func ParseConfig(configKey string, configInstance interface{}) (exist bool, err error) {
+	//the instance with default values is used as the schema of the section
+	config.RegisterSectionSchema(configKey, configInstance)
 	return ParseConfigSection(configObject, configKey, configInstance)
 }
 
+// ValidateConfig validates the effective config with the registered schemas, unknown keys are rejected in strict mode
+func ValidateConfig() error {
+	if configObject == nil {
+		return nil
+	}
+	return config.Validate(configObject)
+}
+
 func ParseConfigSection(cfg *config.Config, configKey string, configInstance interface{}) (exist bool, err error) {
 	if cfg != nil {
 		childConfig, err := cfg.Child(configKey, -1)
@@ -55,6 +55,8 @@ type EventMetadata struct {
 	Name     string      `json:"name,omitempty"`
 	Version  string      `json:"version,omitempty"`
 	Datatype string      `json:"datatype,omitempty"`
+	//version of the schema the event was validated with
+	SchemaVersion int `json:"schema_version,omitempty"`
 }
 
 func (e *Event) String() string {
@@ -0,0 +1,276 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package event
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"infini.sh/framework/core/errors"
+)
+
+// field types of the schemas, they are the same as the elasticsearch field types
+const (
+	TypeKeyword = "keyword"
+	TypeText    = "text"
+	TypeLong    = "long"
+	TypeInteger = "integer"
+	TypeDouble  = "double"
+	TypeFloat   = "float"
+	TypeBoolean = "boolean"
+	TypeDate    = "date"
+	TypeIP      = "ip"
+	//any sub fields are allowed under an object, the declared sub fields are still checked
+	TypeObject = "object"
+)
+
+var fieldTypes = map[string]bool{
+	TypeKeyword: true, TypeText: true, TypeLong: true, TypeInteger: true, TypeDouble: true,
+	TypeFloat: true, TypeBoolean: true, TypeDate: true, TypeIP: true, TypeObject: true,
+}
+
+// Field declares a field of the payload or the labels, nested fields are separated by dots, eg: host.cpu.used_percent
+type Field struct {
+	Name        string `config:"name" json:"name"`
+	Type        string `config:"type" json:"type"`
+	Required    bool   `config:"required" json:"required,omitempty"`
+	Description string `config:"description" json:"description,omitempty"`
+}
+
+// Schema declares the fields and types of the events with the same Metadata.Category and Metadata.Name,
+// a new version is registered when the fields change, the type of a field can't be changed across versions
+type Schema struct {
+	Category    string `config:"category" json:"category"`
+	Name        string `config:"name" json:"name"`
+	Version     int    `config:"version" json:"version"`
+	Description string `config:"description" json:"description,omitempty"`
+	//reject the payload fields not declared, the fields under an object field are always allowed
+	Strict bool    `config:"strict" json:"strict,omitempty"`
+	Labels []Field `config:"labels" json:"labels,omitempty"`
+	Fields []Field `config:"fields" json:"fields,omitempty"`
+}
+
+func (s *Schema) key() string {
+	return schemaKey(s.Category, s.Name)
+}
+
+func schemaKey(category, name string) string {
+	return category + "/" + name
+}
+
+func (s *Schema) validate() error {
+	if s.Category == "" || s.Name == "" {
+		return errors.New("category and name of the schema are required")
+	}
+	if s.Version < 0 {
+		return errors.Errorf("invalid version %v of schema %v", s.Version, s.key())
+	}
+	if err := validateFields(s.Labels); err != nil {
+		return errors.Errorf("invalid labels of schema %v: %v", s.key(), err)
+	}
+	if err := validateFields(s.Fields); err != nil {
+		return errors.Errorf("invalid fields of schema %v: %v", s.key(), err)
+	}
+	return nil
+}
+
+func validateFields(fields []Field) error {
+	types := map[string]string{}
+	for _, f := range fields {
+		if f.Name == "" {
+			return errors.New("field name is required")
+		}
+		if !fieldTypes[f.Type] {
+			return errors.Errorf("unknown type %v of field %v", f.Type, f.Name)
+		}
+		if _, ok := types[f.Name]; ok {
+			return errors.Errorf("duplicated field %v", f.Name)
+		}
+		types[f.Name] = f.Type
+	}
+	return checkNesting(types)
+}
+
+// checkNesting make sure the parents of the nested fields are objects
+func checkNesting(types map[string]string) error {
+	for name := range types {
+		parts := strings.Split(name, ".")
+		for i := 1; i < len(parts); i++ {
+			parent := strings.Join(parts[:i], ".")
+			if t, ok := types[parent]; ok && t != TypeObject {
+				return errors.Errorf("field %v is nested under %v field %v", name, t, parent)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	schemaLock sync.RWMutex
+	//category/name -> versions, sorted by version
+	schemas = map[string][]*Schema{}
+)
+
+// RegisterSchema register a version of the schema, the version starts from 1, registering the same
+// version again is ignored if nothing changed. All the events are stored in the same index, so a field
+// must keep its type in all the versions and all the schemas
+func RegisterSchema(s Schema) error {
+	if s.Version == 0 {
+		s.Version = 1
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	schemaLock.Lock()
+	defer schemaLock.Unlock()
+
+	versions := schemas[s.key()]
+	for _, v := range versions {
+		if v.Version == s.Version {
+			if reflect.DeepEqual(*v, s) {
+				return nil
+			}
+			return errors.Errorf("version %v of schema %v already registered", s.Version, s.key())
+		}
+	}
+
+	labels, fields := map[string]string{}, map[string]string{}
+	for _, versions := range schemas {
+		for _, v := range versions {
+			collectTypes(labels, v.Labels)
+			collectTypes(fields, v.Fields)
+		}
+	}
+	if err := checkCompatible(labels, s.Labels); err != nil {
+		return errors.Errorf("incompatible labels of schema %v version %v: %v", s.key(), s.Version, err)
+	}
+	if err := checkCompatible(fields, s.Fields); err != nil {
+		return errors.Errorf("incompatible fields of schema %v version %v: %v", s.key(), s.Version, err)
+	}
+
+	versions = append(versions, &s)
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+	schemas[s.key()] = versions
+	return nil
+}
+
+func MustRegisterSchema(s Schema) {
+	if err := RegisterSchema(s); err != nil {
+		panic(err)
+	}
+}
+
+func collectTypes(types map[string]string, fields []Field) {
+	for _, f := range fields {
+		if _, ok := types[f.Name]; !ok {
+			types[f.Name] = f.Type
+		}
+	}
+}
+
+func checkCompatible(types map[string]string, fields []Field) error {
+	merged := map[string]string{}
+	for k, v := range types {
+		merged[k] = v
+	}
+	for _, f := range fields {
+		if t, ok := types[f.Name]; ok && t != f.Type {
+			return errors.Errorf("field %v was registered as %v, can't be changed to %v", f.Name, t, f.Type)
+		}
+		merged[f.Name] = f.Type
+	}
+	return checkNesting(merged)
+}
+
+// GetSchema return the version of the schema, or the latest version if version is 0
+func GetSchema(category, name string, version int) (*Schema, bool) {
+	schemaLock.RLock()
+	defer schemaLock.RUnlock()
+	versions := schemas[schemaKey(category, name)]
+	if len(versions) == 0 {
+		return nil, false
+	}
+	if version <= 0 {
+		return versions[len(versions)-1], true
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// GetSchemaVersions return all the versions of the schema, oldest first
+func GetSchemaVersions(category, name string) []Schema {
+	schemaLock.RLock()
+	defer schemaLock.RUnlock()
+	out := []Schema{}
+	for _, v := range schemas[schemaKey(category, name)] {
+		out = append(out, *v)
+	}
+	return out
+}
+
+// GetSchemas return the latest version of all the schemas, sorted by category and name
+func GetSchemas() []Schema {
+	schemaLock.RLock()
+	defer schemaLock.RUnlock()
+	out := make([]Schema, 0, len(schemas))
+	for _, versions := range schemas {
+		out = append(out, *versions[len(versions)-1])
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].key() < out[j].key()
+	})
+	return out
+}
+
+// allSchemas return all the versions of all the schemas, must be called with the lock held
+func allSchemas() []*Schema {
+	keys := make([]string, 0, len(schemas))
+	for k := range schemas {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := []*Schema{}
+	for _, k := range keys {
+		out = append(out, schemas[k]...)
+	}
+	return out
+}
+
+func (s *Schema) String() string {
+	return fmt.Sprintf("%v v%v", s.key(), s.Version)
+}
@@ -0,0 +1,197 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package event
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/util"
+)
+
+func TestRegisterSchema(t *testing.T) {
+	v1 := Schema{
+		Category: "schema_test", Name: "queue",
+		Labels: []Field{{Name: "queue_id", Type: TypeKeyword, Required: true}},
+		Fields: []Field{{Name: "queue.depth", Type: TypeLong}},
+	}
+	assert.NoError(t, RegisterSchema(v1))
+	//registered again without changes
+	assert.NoError(t, RegisterSchema(v1))
+
+	v2 := v1
+	v2.Version = 2
+	v2.Fields = []Field{{Name: "queue.depth", Type: TypeLong}, {Name: "queue.lag", Type: TypeDouble}}
+	assert.NoError(t, RegisterSchema(v2))
+
+	s, ok := GetSchema("schema_test", "queue", 0)
+	assert.True(t, ok)
+	assert.Equal(t, 2, s.Version)
+	s, ok = GetSchema("schema_test", "queue", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(s.Fields))
+	assert.Equal(t, 2, len(GetSchemaVersions("schema_test", "queue")))
+
+	changed := v1
+	changed.Fields = nil
+	assert.Error(t, RegisterSchema(changed))
+
+	//types can't be changed across versions and schemas
+	v3 := v2
+	v3.Version = 3
+	v3.Fields = []Field{{Name: "queue.depth", Type: TypeKeyword}}
+	err := RegisterSchema(v3)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "queue.depth")
+	assert.Error(t, RegisterSchema(Schema{Category: "schema_test", Name: "other", Fields: []Field{{Name: "queue.depth.max", Type: TypeLong}}}))
+
+	assert.Error(t, RegisterSchema(Schema{Name: "missing_category"}))
+	assert.Error(t, RegisterSchema(Schema{Category: "schema_test", Name: "bad", Fields: []Field{{Name: "a", Type: "unknown"}}}))
+	assert.Error(t, RegisterSchema(Schema{Category: "schema_test", Name: "bad", Fields: []Field{{Name: "a", Type: TypeLong}, {Name: "a", Type: TypeLong}}}))
+}
+
+func TestValidateEvent(t *testing.T) {
+	MustRegisterSchema(Schema{
+		Category: "validate_test", Name: "host", Strict: true,
+		Labels: []Field{{Name: "ip", Type: TypeIP}},
+		Fields: []Field{
+			{Name: "host.name", Type: TypeKeyword, Required: true},
+			{Name: "host.cores", Type: TypeInteger},
+			{Name: "host.load", Type: TypeDouble},
+			{Name: "host.up", Type: TypeBoolean},
+			{Name: "host.boot_time", Type: TypeDate},
+			{Name: "host.tags", Type: TypeKeyword},
+			{Name: "host.raw", Type: TypeObject},
+		},
+	})
+
+	e := &Event{
+		Metadata: EventMetadata{Category: "validate_test", Name: "host", Labels: util.MapStr{"ip": "192.168.3.1"}},
+		Fields: util.MapStr{
+			"host": util.MapStr{
+				"name":      "node-1",
+				"cores":     uint8(8),
+				"load":      1,
+				"up":        true,
+				"boot_time": time.Now(),
+				"tags":      []string{"a", "b"},
+				"raw":       util.MapStr{"anything": util.MapStr{"goes": 1}},
+			},
+		},
+	}
+	assert.NoError(t, ValidateEvent(e))
+	assert.Equal(t, 1, e.Metadata.SchemaVersion)
+
+	e = &Event{
+		Metadata: EventMetadata{Category: "validate_test", Name: "host", Labels: util.MapStr{"ip": "localhost"}},
+		Fields: util.MapStr{
+			"host": util.MapStr{
+				"cores":     int64(1) << 40,
+				"load":      "high",
+				"boot_time": "yesterday",
+				"tags":      []interface{}{"a", 1},
+				"raw":       "text",
+				"unknown":   1,
+			},
+		},
+	}
+	err := ValidateEvent(e)
+	assert.Error(t, err)
+	problems := err.(*ValidationError).Problems
+	assert.Equal(t, 8, len(problems), problems)
+	assert.Contains(t, err.Error(), "payload.host.name: missing required field")
+	assert.Contains(t, err.Error(), "payload.host.unknown: unknown field")
+	assert.Contains(t, err.Error(), "labels.ip")
+
+	//numbers decoded from json are float64
+	e = &Event{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"metadata":{"category":"validate_test","name":"host"},
+		"payload":{"host":{"name":"node-1","cores":4,"boot_time":"2021-01-02T03:04:05Z"}}}`), e))
+	assert.NoError(t, ValidateEvent(e))
+	e.Fields.Put("host.cores", 4.5)
+	assert.Error(t, ValidateEvent(e))
+
+	//events without schema are valid, unknown versions are not
+	assert.NoError(t, ValidateEvent(&Event{Metadata: EventMetadata{Category: "validate_test", Name: "unknown"}}))
+	assert.Error(t, ValidateEvent(&Event{Metadata: EventMetadata{Category: "validate_test", Name: "host", SchemaVersion: 9}}))
+}
+
+func TestSaveRejectInvalidEvent(t *testing.T) {
+	MustRegisterSchema(Schema{
+		Category: "save_test", Name: "event",
+		Fields: []Field{{Name: "count", Type: TypeLong, Required: true}},
+	})
+	assert.NoError(t, SetSchemaValidation(""))
+	assert.Equal(t, SchemaValidationWarn, GetSchemaValidation())
+	assert.NoError(t, SetSchemaValidation(SchemaValidationReject))
+	defer SetSchemaValidation(SchemaValidationWarn)
+	assert.Error(t, SetSchemaValidation("unknown"))
+
+	err := Save(&Event{Metadata: EventMetadata{Category: "save_test", Name: "event"}, Fields: util.MapStr{"count": "a"}})
+	assert.Error(t, err)
+	_, ok := err.(*ValidationError)
+	assert.True(t, ok)
+}
+
+func TestGetIndexMappings(t *testing.T) {
+	MustRegisterSchema(Schema{
+		Category: "mapping_test", Name: "disk",
+		Labels: []Field{{Name: "mapping_test_id", Type: TypeKeyword}},
+		Fields: []Field{
+			{Name: "mapping_test.disk.used.bytes", Type: TypeLong},
+			{Name: "mapping_test.disk.stats", Type: TypeObject},
+			{Name: "mapping_test.disk.stats.read", Type: TypeLong},
+		},
+	})
+
+	mappings := util.MapStr{}
+	util.MustFromJSONBytes(util.MustToJSONBytes(GetIndexMappings()), &mappings)
+	v, err := mappings.GetValue("properties.payload.properties.mapping_test.properties.disk.properties.used.properties.bytes.type")
+	assert.NoError(t, err)
+	assert.Equal(t, "long", v)
+	v, err = mappings.GetValue("properties.payload.properties.mapping_test.properties.disk.properties.stats.type")
+	assert.NoError(t, err)
+	assert.Equal(t, "object", v)
+	v, err = mappings.GetValue("properties.payload.properties.mapping_test.properties.disk.properties.stats.properties.read.type")
+	assert.NoError(t, err)
+	assert.Equal(t, "long", v)
+	v, err = mappings.GetValue("properties.metadata.properties.labels.properties.mapping_test_id.type")
+	assert.NoError(t, err)
+	assert.Equal(t, "keyword", v)
+	v, err = mappings.GetValue("properties.timestamp.type")
+	assert.NoError(t, err)
+	assert.Equal(t, "date", v)
+
+	version := GetTemplateVersion()
+	MustRegisterSchema(Schema{Category: "mapping_test", Name: "disk", Version: 2})
+	template := GetIndexTemplate([]string{"metrics*"}, 1)
+	assert.Equal(t, version+1, template["version"])
+	assert.Equal(t, []string{"metrics*"}, template["index_patterns"])
+}
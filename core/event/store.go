@@ -60,6 +60,10 @@ func SaveWithTimestamp(event *Event, time2 time.Time) error {
 		panic("event can't be nil")
 	}
 
+	if err := validateSchema(event); err != nil {
+		return err
+	}
+
 	if global.Env().IsDebug {
 		log.Debugf("%v-%v: %v", event.Metadata.Category, event.Metadata.Name, string(util.MustToJSONBytes(event.Metadata)))
 	}
@@ -94,6 +98,10 @@ func SaveLog(event *Event) error {
 		panic("event can't be nil")
 	}
 
+	if err := validateSchema(event); err != nil {
+		return err
+	}
+
 	event.Timestamp = time.Now()
 	event.Agent = getMeta()
 
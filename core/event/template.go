@@ -0,0 +1,138 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package event
+
+import (
+	"strings"
+
+	"infini.sh/framework/core/util"
+)
+
+// fieldMapping return the elasticsearch mapping of the field type
+func fieldMapping(fieldType string) util.MapStr {
+	switch fieldType {
+	case TypeKeyword:
+		return util.MapStr{"type": TypeKeyword, "ignore_above": 256}
+	case TypeObject:
+		return util.MapStr{"type": TypeObject}
+	}
+	return util.MapStr{"type": fieldType}
+}
+
+// putProperty put the mapping of the dotted field into the nested properties
+func putProperty(properties util.MapStr, name string, fieldType string) {
+	parts := strings.Split(name, ".")
+	for _, part := range parts[:len(parts)-1] {
+		parent, ok := properties[part].(util.MapStr)
+		if !ok {
+			parent = util.MapStr{"type": TypeObject}
+			properties[part] = parent
+		}
+		children, ok := parent["properties"].(util.MapStr)
+		if !ok {
+			children = util.MapStr{}
+			parent["properties"] = children
+		}
+		properties = children
+	}
+	last := parts[len(parts)-1]
+	if existing, ok := properties[last].(util.MapStr); ok {
+		//keep the sub fields declared before the object field itself
+		existing["type"] = fieldType
+		return
+	}
+	properties[last] = fieldMapping(fieldType)
+}
+
+// GetIndexMappings generate the elasticsearch mappings of the events, the fields of all the versions
+// of the registered schemas are merged into metadata.labels and payload
+func GetIndexMappings() util.MapStr {
+	schemaLock.RLock()
+	defer schemaLock.RUnlock()
+
+	labels := util.MapStr{}
+	payload := util.MapStr{}
+	for _, s := range allSchemas() {
+		for _, f := range s.Labels {
+			putProperty(labels, f.Name, f.Type)
+		}
+		for _, f := range s.Fields {
+			putProperty(payload, f.Name, f.Type)
+		}
+	}
+
+	keyword := fieldMapping(TypeKeyword)
+	return util.MapStr{
+		"properties": util.MapStr{
+			"timestamp": fieldMapping(TypeDate),
+			"agent": util.MapStr{
+				"properties": util.MapStr{
+					"id":       keyword,
+					"host_id":  keyword,
+					"hostname": keyword,
+					"major_ip": keyword,
+					"ips":      keyword,
+					"tags":     keyword,
+					"labels":   fieldMapping(TypeObject),
+				},
+			},
+			"metadata": util.MapStr{
+				"properties": util.MapStr{
+					"category":       keyword,
+					"name":           keyword,
+					"version":        keyword,
+					"datatype":       keyword,
+					"schema_version": fieldMapping(TypeInteger),
+					"labels":         util.MapStr{"type": TypeObject, "properties": labels},
+				},
+			},
+			"payload": util.MapStr{"type": TypeObject, "properties": payload},
+		},
+	}
+}
+
+// GetTemplateVersion changes whenever a schema version is registered
+func GetTemplateVersion() int {
+	schemaLock.RLock()
+	defer schemaLock.RUnlock()
+	version := 0
+	for _, versions := range schemas {
+		version += versions[len(versions)-1].Version
+	}
+	return version
+}
+
+// GetIndexTemplate generate the elasticsearch index template of the events
+func GetIndexTemplate(indexPatterns []string, order int) util.MapStr {
+	return util.MapStr{
+		"index_patterns": indexPatterns,
+		"order":          order,
+		"version":        GetTemplateVersion(),
+		"mappings":       GetIndexMappings(),
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// how the events are validated on save, invalid events are logged and saved by default,
+// reject is opt-in, as the events of the producers not updated with the schemas are dropped
+const (
+	SchemaValidationOff = "off"
+	//log the invalid events and save them anyway
+	SchemaValidationWarn = "warn"
+	//return the error, the invalid events are not saved
+	SchemaValidationReject = "reject"
+)
+
+var (
+	validationLock   sync.RWMutex
+	schemaValidation = SchemaValidationWarn
+)
+
+func SetSchemaValidation(mode string) error {
+	switch mode {
+	case "":
+		mode = SchemaValidationWarn
+	case SchemaValidationOff, SchemaValidationWarn, SchemaValidationReject:
+	default:
+		return errors.Errorf("unknown schema validation mode: %v", mode)
+	}
+	validationLock.Lock()
+	schemaValidation = mode
+	validationLock.Unlock()
+	return nil
+}
+
+func GetSchemaValidation() string {
+	validationLock.RLock()
+	defer validationLock.RUnlock()
+	return schemaValidation
+}
+
+// ValidationError lists all the problems of an event
+type ValidationError struct {
+	Category string
+	Name     string
+	Version  int
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("event %v/%v doesn't match schema version %v: %v", e.Category, e.Name, e.Version, strings.Join(e.Problems, "; "))
+}
+
+// ValidateEvent validate the event with the version of its schema in Metadata.SchemaVersion, or the latest
+// version if not set, Metadata.SchemaVersion is set to the version used. Events without schema are valid
+func ValidateEvent(e *Event) error {
+	s, ok := GetSchema(e.Metadata.Category, e.Metadata.Name, e.Metadata.SchemaVersion)
+	if !ok {
+		if e.Metadata.SchemaVersion > 0 {
+			return errors.Errorf("schema %v version %v not found", schemaKey(e.Metadata.Category, e.Metadata.Name), e.Metadata.SchemaVersion)
+		}
+		return nil
+	}
+	e.Metadata.SchemaVersion = s.Version
+	return s.Validate(e)
+}
+
+// Validate check the labels and payload of the event
+func (s *Schema) Validate(e *Event) error {
+	var problems []string
+	problems = append(problems, checkFields("labels", e.Metadata.Labels, s.Labels, false)...)
+	problems = append(problems, checkFields("payload", e.Fields, s.Fields, s.Strict)...)
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return &ValidationError{Category: s.Category, Name: s.Name, Version: s.Version, Problems: problems}
+	}
+	return nil
+}
+
+// validateSchema apply the validation mode on save
+func validateSchema(event *Event) error {
+	mode := GetSchemaValidation()
+	if mode == SchemaValidationOff {
+		return nil
+	}
+	err := ValidateEvent(event)
+	if err == nil {
+		return nil
+	}
+	stats.Increment("metrics.invalid", event.Metadata.Category, event.Metadata.Name)
+	if mode == SchemaValidationWarn {
+		log.Warn(err)
+		return nil
+	}
+	stats.Increment("metrics.rejected", event.Metadata.Category, event.Metadata.Name)
+	return err
+}
+
+type fieldChecker struct {
+	scope    string
+	fields   map[string]*Field
+	strict   bool
+	found    map[string]bool
+	problems []string
+}
+
+func checkFields(scope string, values map[string]interface{}, fields []Field, strict bool) []string {
+	c := &fieldChecker{scope: scope, fields: map[string]*Field{}, strict: strict, found: map[string]bool{}}
+	for i := range fields {
+		c.fields[fields[i].Name] = &fields[i]
+	}
+	c.walk("", values, false)
+	for _, f := range fields {
+		if f.Required && !c.found[f.Name] {
+			c.problems = append(c.problems, fmt.Sprintf("%v.%v: missing required field", scope, f.Name))
+		}
+	}
+	return c.problems
+}
+
+// walk check the values recursively, open is true under an object field, the undeclared fields are allowed there
+func (c *fieldChecker) walk(prefix string, values map[string]interface{}, open bool) {
+	for k, v := range values {
+		if v == nil {
+			continue
+		}
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		f, declared := c.fields[path]
+		if declared {
+			c.found[path] = true
+			if err := checkType(f.Type, v); err != nil {
+				c.problems = append(c.problems, fmt.Sprintf("%v.%v: %v", c.scope, path, err))
+				continue
+			}
+			if f.Type != TypeObject {
+				continue
+			}
+		}
+		if m, ok := toMap(v); ok {
+			c.walk(path, m, open || declared)
+			continue
+		}
+		if !declared && c.strict && !open {
+			c.problems = append(c.problems, fmt.Sprintf("%v.%v: unknown field", c.scope, path))
+		}
+	}
+}
+
+func toMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case util.MapStr:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	m := make(map[string]interface{}, rv.Len())
+	for _, k := range rv.MapKeys() {
+		m[k.String()] = rv.MapIndex(k).Interface()
+	}
+	return m, true
+}
+
+// checkType check the value against the field type, arrays are checked element by element
+func checkType(fieldType string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+		v = rv.Interface()
+	}
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < rv.Len(); i++ {
+			if err := checkType(fieldType, rv.Index(i).Interface()); err != nil {
+				return errors.Errorf("element %v: %v", i, err)
+			}
+		}
+		return nil
+	}
+
+	ok := false
+	switch fieldType {
+	case TypeKeyword, TypeText:
+		ok = rv.Kind() == reflect.String
+	case TypeIP:
+		ok = rv.Kind() == reflect.String && net.ParseIP(rv.String()) != nil
+	case TypeLong, TypeInteger:
+		i, isInt := toInt(v, rv)
+		ok = isInt && (fieldType == TypeLong || (i >= math.MinInt32 && i <= math.MaxInt32))
+	case TypeDouble, TypeFloat:
+		_, ok = toFloat(v, rv)
+	case TypeBoolean:
+		ok = rv.Kind() == reflect.Bool
+	case TypeDate:
+		switch x := v.(type) {
+		case time.Time:
+			ok = true
+		case string:
+			_, err := time.Parse(time.RFC3339Nano, x)
+			ok = err == nil
+		default:
+			//epoch millis
+			_, ok = toInt(v, rv)
+		}
+	case TypeObject:
+		ok = rv.Kind() == reflect.Map || rv.Kind() == reflect.Struct
+	}
+	if !ok {
+		return errors.Errorf("expected %v but got %T(%v)", fieldType, v, v)
+	}
+	return nil
+}
+
+func toInt(v interface{}, rv reflect.Value) (int64, bool) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		//numbers decoded from json
+		f := rv.Float()
+		return int64(f), f == math.Trunc(f)
+	}
+	if n, ok := v.(json.Number); ok {
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}, rv reflect.Value) (float64, bool) {
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	}
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,403 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+import (
+	"fmt"
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Params are the named values interpolated into a message, eg: {name}
+type Params map[string]interface{}
+
+// message is either a plain text or a set of plural forms keyed by category
+type message struct {
+	text  string
+	forms map[string]string
+}
+
+var (
+	lock          sync.RWMutex
+	catalogs      = map[string]map[string]*message{}
+	defaultLocale = "en-US"
+)
+
+// SetDefaultLocale set the locale used when nothing better could be negotiated
+func SetDefaultLocale(locale string) {
+	lock.Lock()
+	defer lock.Unlock()
+	defaultLocale = NormalizeLocale(locale)
+}
+
+// GetDefaultLocale return the default locale
+func GetDefaultLocale() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	return defaultLocale
+}
+
+// GetLocales return all the locales which have a catalog loaded
+func GetLocales() []string {
+	lock.RLock()
+	defer lock.RUnlock()
+	locales := make([]string, 0, len(catalogs))
+	for k := range catalogs {
+		locales = append(locales, k)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// AddMessages merge messages into the catalog of the locale, nested maps are flattened with dot,
+// a map with plural categories (zero, one, two, few, many, other) as keys is treated as plural forms
+func AddMessages(locale string, messages map[string]interface{}) {
+	locale = NormalizeLocale(locale)
+	lock.Lock()
+	defer lock.Unlock()
+	catalog, ok := catalogs[locale]
+	if !ok {
+		catalog = map[string]*message{}
+		catalogs[locale] = catalog
+	}
+	addMessages(catalog, "", messages)
+}
+
+func addMessages(catalog map[string]*message, prefix string, messages map[string]interface{}) {
+	for k, v := range messages {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch x := v.(type) {
+		case string:
+			catalog[key] = &message{text: x}
+		case map[string]interface{}:
+			if forms, ok := toPluralForms(x); ok {
+				catalog[key] = &message{text: forms[PluralOther], forms: forms}
+			} else {
+				addMessages(catalog, key, x)
+			}
+		case map[string]string:
+			m := make(map[string]interface{}, len(x))
+			for k1, v1 := range x {
+				m[k1] = v1
+			}
+			addMessages(catalog, prefix, map[string]interface{}{k: m})
+		case nil:
+		default:
+			catalog[key] = &message{text: fmt.Sprint(x)}
+		}
+	}
+}
+
+func toPluralForms(m map[string]interface{}) (map[string]string, bool) {
+	if _, ok := m[PluralOther]; !ok {
+		return nil, false
+	}
+	forms := make(map[string]string, len(m))
+	for k, v := range m {
+		s, ok := v.(string)
+		if !ok || !isPluralCategory(k) {
+			return nil, false
+		}
+		forms[k] = s
+	}
+	return forms, true
+}
+
+// LoadFromFolder load catalogs from a folder, the locale is taken from the file name, eg: zh-CN.yml,
+// or from the sub folder name, eg: zh-CN/pipeline.yml, both json and yaml files are supported
+func LoadFromFolder(dir string) error {
+	if !util.FileExists(dir) {
+		return nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		name := filepath.Join(dir, f.Name())
+		if f.IsDir() {
+			subFiles, err := ioutil.ReadDir(name)
+			if err != nil {
+				return err
+			}
+			for _, sub := range subFiles {
+				if sub.IsDir() || !isCatalogFile(sub.Name()) {
+					continue
+				}
+				if err := loadFile(f.Name(), filepath.Join(name, sub.Name())); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !isCatalogFile(f.Name()) {
+			continue
+		}
+		if err := loadFile(localeOfFile(f.Name()), name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadFile(locale, file string) error {
+	b, err := util.FileGetContent(file)
+	if err != nil {
+		return err
+	}
+	return LoadMessages(locale, file, b)
+}
+
+// LoadFromFS load catalogs from a http.FileSystem, eg: the embedded files registered to core/vfs,
+// the folder is listed when the file system supports it, otherwise files named after the locales are tried
+func LoadFromFS(fs http.FileSystem, dir string, locales ...string) error {
+	var files []string
+	if f, err := fs.Open(dir); err == nil {
+		infos, _ := f.Readdir(-1)
+		f.Close()
+		for _, info := range infos {
+			if !info.IsDir() && isCatalogFile(info.Name()) {
+				files = append(files, path.Join(dir, info.Name()))
+			}
+		}
+	}
+	if len(files) == 0 {
+		for _, locale := range locales {
+			for _, ext := range catalogExtensions {
+				files = append(files, path.Join(dir, locale+ext))
+			}
+		}
+	}
+
+	for _, name := range files {
+		f, err := fs.Open(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		b, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if err := LoadMessages(localeOfFile(name), name, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var catalogExtensions = []string{".json", ".yml", ".yaml"}
+
+func isCatalogFile(name string) bool {
+	return util.StringInArray(catalogExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+func localeOfFile(name string) string {
+	base := filepath.Base(name)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// LoadMessages parse a json or yaml document and merge it into the catalog of the locale
+func LoadMessages(locale, source string, b []byte) error {
+	messages := map[string]interface{}{}
+	if strings.ToLower(filepath.Ext(source)) == ".json" {
+		if err := util.FromJSONBytes(b, &messages); err != nil {
+			return errors.Errorf("invalid message catalog %v: %v", source, err)
+		}
+	} else {
+		cfg, err := config.NewConfigWithYAML(b, source)
+		if err != nil {
+			return errors.Errorf("invalid message catalog %v: %v", source, err)
+		}
+		if err := cfg.Unpack(&messages); err != nil {
+			return errors.Errorf("invalid message catalog %v: %v", source, err)
+		}
+	}
+	log.Debugf("load %v messages of locale [%v] from %v", len(messages), locale, source)
+	AddMessages(locale, messages)
+	return nil
+}
+
+// lookup find the message by key, falls back to the base language, other regions of the
+// same language and then the default locale
+func lookup(locale, key string) *message {
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, l := range fallbackLocales(locale) {
+		if catalog, ok := catalogs[l]; ok {
+			if msg, ok := catalog[key]; ok {
+				return msg
+			}
+		}
+	}
+	return nil
+}
+
+func fallbackLocales(locale string) []string {
+	locale = NormalizeLocale(locale)
+	candidates := []string{}
+	for _, l := range []string{locale, defaultLocale, baseLocale} {
+		if l == "" {
+			continue
+		}
+		candidates = append(candidates, l)
+		lang := languageOf(l)
+		if lang != l {
+			candidates = append(candidates, lang)
+		}
+		var regions []string
+		for k := range catalogs {
+			if k != l && languageOf(k) == lang {
+				regions = append(regions, k)
+			}
+		}
+		sort.Strings(regions)
+		candidates = append(candidates, regions...)
+	}
+	return candidates
+}
+
+// HasMessage check if the key is defined in any catalog
+func HasMessage(key string) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	for _, catalog := range catalogs {
+		if _, ok := catalog[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMessages return all the messages of the locale, merged with its fallbacks,
+// plural messages are returned as a map of forms
+func GetMessages(locale string) map[string]interface{} {
+	lock.RLock()
+	defer lock.RUnlock()
+	out := map[string]interface{}{}
+	fallbacks := fallbackLocales(locale)
+	for i := len(fallbacks) - 1; i >= 0; i-- {
+		for k, msg := range catalogs[fallbacks[i]] {
+			if msg.forms != nil {
+				out[k] = msg.forms
+			} else {
+				out[k] = msg.text
+			}
+		}
+	}
+	return out
+}
+
+// Text return the localized message of the key, the key itself is returned when it is missing
+func Text(locale, key string, params Params) string {
+	msg := lookup(locale, key)
+	if msg == nil {
+		return Interpolate(key, locale, params)
+	}
+	return Interpolate(msg.text, locale, params)
+}
+
+// Plural return the localized message of the key with the plural form selected by count,
+// count is also available as the {count} parameter
+func Plural(locale, key string, count int, params Params) string {
+	p := Params{"count": count}
+	for k, v := range params {
+		p[k] = v
+	}
+	msg := lookup(locale, key)
+	if msg == nil {
+		return Interpolate(key, locale, p)
+	}
+	text := msg.text
+	if msg.forms != nil {
+		text = msg.forms[PluralOther]
+		if v, ok := msg.forms[PluralZero]; ok && count == 0 {
+			text = v
+		} else if v, ok := msg.forms[PluralCategory(locale, count)]; ok {
+			text = v
+		}
+	}
+	return Interpolate(text, locale, p)
+}
+
+// Interpolate replace {name} placeholders with the params, unknown placeholders are kept,
+// params of type *Error are localized as well
+func Interpolate(text, locale string, params Params) string {
+	if len(params) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+	var sb strings.Builder
+	for {
+		start := strings.Index(text, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(text[start:], "}")
+		if end < 0 {
+			break
+		}
+		end += start
+		name := text[start+1 : end]
+		v, ok := params[name]
+		if !ok {
+			sb.WriteString(text[:end+1])
+			text = text[end+1:]
+			continue
+		}
+		sb.WriteString(text[:start])
+		sb.WriteString(formatParam(locale, v))
+		text = text[end+1:]
+	}
+	sb.WriteString(text)
+	return sb.String()
+}
+
+func formatParam(locale string, v interface{}) string {
+	switch x := v.(type) {
+	case *Error:
+		return x.Localize(locale)
+	case error:
+		return Localize(locale, x)
+	case string:
+		return x
+	default:
+		return fmt.Sprint(x)
+	}
+}
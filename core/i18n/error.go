@@ -0,0 +1,73 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+import (
+	"errors"
+	"strings"
+)
+
+// Error is an error described by a message key, it is rendered in the base locale, so that the logs
+// are not affected by the locale settings, the responses are localized explicitly
+type Error struct {
+	Key    string
+	Params Params
+}
+
+// NewError create an error with message key and params
+func NewError(key string, params Params) *Error {
+	return &Error{Key: key, Params: params}
+}
+
+func (e *Error) Error() string {
+	return e.Localize(baseLocale)
+}
+
+// Localize render the error in the locale
+func (e *Error) Localize(locale string) string {
+	if count, ok := e.Params["count"].(int); ok {
+		return Plural(locale, e.Key, count, e.Params)
+	}
+	return Text(locale, e.Key, e.Params)
+}
+
+// Localize render the error in the locale if it is or wraps an *Error, the message of the
+// wrapped error is replaced within the message of the wrapper
+func Localize(locale string, err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	var e *Error
+	if errors.As(err, &e) {
+		base := e.Error()
+		if base == msg {
+			return e.Localize(locale)
+		}
+		if base != "" && strings.Contains(msg, base) {
+			return strings.Replace(msg, base, e.Localize(locale), 1)
+		}
+	}
+	return msg
+}
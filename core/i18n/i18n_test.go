@@ -0,0 +1,130 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTextAndInterpolate(t *testing.T) {
+	AddMessages("de-DE", map[string]interface{}{
+		"greeting": map[string]interface{}{
+			"hello": "Hallo {name}, {unknown}",
+		},
+	})
+	assert.Equal(t, "Hallo Medcl, {unknown}", Text("de-DE", "greeting.hello", Params{"name": "Medcl"}))
+	assert.Equal(t, "Hallo Medcl, {unknown}", Text("de", "greeting.hello", Params{"name": "Medcl"}))
+	//fallback to the default locale and then the key itself
+	assert.Equal(t, "missing x option", Text("de-DE", "pipeline.config.missing_option", Params{"field": "x"}))
+	assert.Equal(t, "not.a.key", Text("de-DE", "not.a.key", nil))
+}
+
+func TestPlural(t *testing.T) {
+	AddMessages("en", map[string]interface{}{
+		"test.files": map[string]interface{}{
+			"zero":  "no files",
+			"one":   "{count} file",
+			"other": "{count} files in {dir}",
+		},
+	})
+	AddMessages("ru", map[string]interface{}{
+		"test.files": map[string]interface{}{
+			"one":   "{count} файл",
+			"few":   "{count} файла",
+			"many":  "{count} файлов",
+			"other": "{count} файла",
+		},
+	})
+	assert.Equal(t, "no files", Plural("en-US", "test.files", 0, nil))
+	assert.Equal(t, "1 file", Plural("en-US", "test.files", 1, nil))
+	assert.Equal(t, "5 files in /tmp", Plural("en-US", "test.files", 5, Params{"dir": "/tmp"}))
+	assert.Equal(t, "21 файл", Plural("ru", "test.files", 21, nil))
+	assert.Equal(t, "3 файла", Plural("ru", "test.files", 3, nil))
+	assert.Equal(t, "11 файлов", Plural("ru", "test.files", 11, nil))
+
+	assert.Equal(t, PluralOther, PluralCategory("zh-CN", 1))
+	assert.Equal(t, PluralOne, PluralCategory("fr", 0))
+	assert.Equal(t, PluralFew, PluralCategory("pl", 22))
+	assert.Equal(t, PluralMany, PluralCategory("pl", 25))
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	assert.Equal(t, "zh-CN", NormalizeLocale("zh_cn"))
+	assert.Equal(t, "zh-Hans-CN", NormalizeLocale("ZH-hans-cn"))
+	assert.Equal(t, []string{"fr-CH", "fr", "en", "*"}, ParseAcceptLanguage("*;q=0.5, fr;q=0.9, fr-CH, en;q=0.8, de;q=0"))
+
+	assert.Equal(t, "zh-CN", NegotiateLocale("zh-TW,zh;q=0.9,en;q=0.8"))
+	assert.Equal(t, "en", NegotiateLocale("xx-YY, en-GB;q=0.5"))
+	assert.Equal(t, "zh-CN", NegotiateLocale("en", "", "zh"))
+	assert.Equal(t, GetDefaultLocale(), NegotiateLocale("xx"))
+}
+
+func TestError(t *testing.T) {
+	err := NewError("pipeline.config.invalid", Params{
+		"error": NewError("pipeline.config.missing_option", Params{"field": "queue"}),
+		"path":  "processor.0",
+	})
+	assert.Equal(t, "missing queue option in processor.0", err.Error())
+	assert.Equal(t, "processor.0 配置错误：缺少配置项 queue", Localize("zh-CN", err))
+	assert.Equal(t, "missing option, select one from [a b]",
+		NewError("pipeline.config.select_one", Params{"fields": []string{"a", "b"}}).Error())
+
+	//the default locale doesn't change the message of the error
+	defer SetDefaultLocale(GetDefaultLocale())
+	SetDefaultLocale("zh-CN")
+	assert.Equal(t, "missing queue option in processor.0", err.Error())
+
+	//the wrapped errors are localized within the message of the wrapper
+	wrapped := fmt.Errorf("failed to start: %w", NewError("pipeline.config.missing_option", Params{"field": "queue"}))
+	assert.Equal(t, "failed to start: 缺少配置项 queue", Localize("zh-CN", wrapped))
+	nested := NewError("pipeline.config.invalid", Params{"error": wrapped, "path": "processor.0"})
+	assert.Equal(t, "processor.0 配置错误：failed to start: 缺少配置项 queue", Localize("zh-CN", nested))
+}
+
+func TestLoadCatalogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "i18n")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "es.json"), []byte(`{"test":{"bye":"adiós {name}"}}`), 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "es-MX"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "es-MX", "test.json"), []byte(`{"test.bye":"bye {name}, carnal"}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte(`ignored`), 0644))
+	assert.NoError(t, LoadFromFolder(dir))
+	assert.Equal(t, "adiós Medcl", Text("es-ES", "test.bye", Params{"name": "Medcl"}))
+	assert.Equal(t, "bye Medcl, carnal", Text("es-MX", "test.bye", Params{"name": "Medcl"}))
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "embedded"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "embedded", "it.json"), []byte(`{"test.bye":"ciao"}`), 0644))
+	assert.NoError(t, LoadFromFS(http.Dir(dir), "/embedded"))
+	assert.Equal(t, "ciao", Text("it", "test.bye", nil))
+
+	assert.Error(t, LoadMessages("it", "broken.json", []byte(`{`)))
+}
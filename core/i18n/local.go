@@ -39,7 +39,8 @@ limitations under the License.
 
 package i18n
 
-// GetLocalizedText return localized text
+// GetLocalizedText return the text of the message key in the default locale,
+// the input is returned as it is when it is not a message key
 func GetLocalizedText(str string) string {
-	return str
+	return Text(GetDefaultLocale(), str, nil)
 }
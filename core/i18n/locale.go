@@ -0,0 +1,144 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NormalizeLocale return the canonical form of the locale tag, eg: zh_cn -> zh-CN
+func NormalizeLocale(locale string) string {
+	locale = strings.TrimSpace(strings.Replace(locale, "_", "-", -1))
+	if locale == "" || locale == "*" {
+		return locale
+	}
+	parts := strings.Split(locale, "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		switch len(parts[i]) {
+		case 2:
+			parts[i] = strings.ToUpper(parts[i])
+		case 4:
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		default:
+			parts[i] = strings.ToLower(parts[i])
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func languageOf(locale string) string {
+	if i := strings.Index(locale, "-"); i > 0 {
+		return locale[:i]
+	}
+	return locale
+}
+
+type weightedLocale struct {
+	locale string
+	q      float64
+}
+
+// ParseAcceptLanguage parse the Accept-Language header, locales are sorted by quality
+func ParseAcceptLanguage(header string) []string {
+	var items []weightedLocale
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		q := 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			for _, param := range strings.Split(part[i+1:], ";") {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = v
+					}
+				}
+			}
+			part = strings.TrimSpace(part[:i])
+		}
+		if q <= 0 {
+			continue
+		}
+		items = append(items, weightedLocale{locale: NormalizeLocale(part), q: q})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].q > items[j].q
+	})
+	locales := make([]string, 0, len(items))
+	for _, item := range items {
+		locales = append(locales, item.locale)
+	}
+	return locales
+}
+
+// MatchLocale return the best supported locale of the requested one, empty if not supported
+func MatchLocale(locale string) string {
+	locale = NormalizeLocale(locale)
+	if locale == "" {
+		return ""
+	}
+	supported := GetLocales()
+	if locale == "*" {
+		return GetDefaultLocale()
+	}
+	for _, l := range supported {
+		if l == locale {
+			return l
+		}
+	}
+	lang := languageOf(locale)
+	for _, l := range supported {
+		if l == lang {
+			return l
+		}
+	}
+	for _, l := range supported {
+		if languageOf(l) == lang {
+			return l
+		}
+	}
+	return ""
+}
+
+// NegotiateLocale pick the locale of a request, the explicit preferences, eg: the user setting or
+// session, are checked in order before the Accept-Language header, the default locale is returned
+// when nothing matches
+func NegotiateLocale(acceptLanguage string, preferred ...string) string {
+	for _, l := range preferred {
+		if v := MatchLocale(l); v != "" {
+			return v
+		}
+	}
+	for _, l := range ParseAcceptLanguage(acceptLanguage) {
+		if v := MatchLocale(l); v != "" {
+			return v
+		}
+	}
+	return GetDefaultLocale()
+}
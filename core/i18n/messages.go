@@ -0,0 +1,117 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+// baseLocale is the locale of the built-in messages, it is the last fallback of every lookup
+const baseLocale = "en"
+
+// built-in messages of the framework, apps could override them with their own catalogs
+var builtinMessages = map[string]map[string]interface{}{
+	baseLocale: {
+		"api": map[string]interface{}{
+			"invalid_parameter": "invalid {name}: {error}",
+			"sse": map[string]interface{}{
+				"no_topics":             "no topics specified",
+				"streaming_unsupported": "streaming not supported",
+			},
+		},
+		"audit":    map[string]interface{}{"api": map[string]interface{}{"not_enabled": "audit log is not enabled"}},
+		"config":   map[string]interface{}{"api": map[string]interface{}{"schema_not_found": "no schema registered for section: {section}"}},
+		"event":    map[string]interface{}{"api": map[string]interface{}{"schema_not_found": "schema not found"}},
+		"keystore": map[string]interface{}{"api": map[string]interface{}{"empty_key": "key cannot be empty"}},
+		"i18n": map[string]interface{}{
+			"api": map[string]interface{}{
+				"unsupported_locale": "unsupported locale: {locale}",
+				"save_failed":        "failed to save locale",
+			},
+		},
+		"queue": map[string]interface{}{
+			"api": map[string]interface{}{
+				"no_selector":    "no selector specified",
+				"count_required": "parameter count is required",
+			},
+		},
+		"stats": map[string]interface{}{"api": map[string]interface{}{"not_enabled": "stats is nil"}},
+		"pipeline": map[string]interface{}{
+			"api": map[string]interface{}{
+				"not_found":          "pipeline not found",
+				"running":            "pipeline is running, stop it before resetting the checkpoint",
+				"golden_unsupported": "golden files are not supported by the api, use expected_output instead",
+			},
+			"config": map[string]interface{}{
+				"invalid":            "{error} in {path}",
+				"missing_option":     "missing {field} option",
+				"unexpected_option":  "unexpected {field} option",
+				"mutually_exclusive": "field {field} and {other} are mutually exclusive",
+				"select_one":         "missing option, select one from {fields}",
+			},
+		},
+	},
+	"zh-CN": {
+		"api": map[string]interface{}{
+			"invalid_parameter": "参数 {name} 无效：{error}",
+			"sse": map[string]interface{}{
+				"no_topics":             "未指定订阅主题",
+				"streaming_unsupported": "不支持流式响应",
+			},
+		},
+		"audit":    map[string]interface{}{"api": map[string]interface{}{"not_enabled": "审计日志未启用"}},
+		"config":   map[string]interface{}{"api": map[string]interface{}{"schema_not_found": "配置段 {section} 未注册结构定义"}},
+		"event":    map[string]interface{}{"api": map[string]interface{}{"schema_not_found": "未找到事件结构定义"}},
+		"keystore": map[string]interface{}{"api": map[string]interface{}{"empty_key": "键不能为空"}},
+		"i18n": map[string]interface{}{
+			"api": map[string]interface{}{
+				"unsupported_locale": "不支持的语言：{locale}",
+				"save_failed":        "保存语言设置失败",
+			},
+		},
+		"queue": map[string]interface{}{
+			"api": map[string]interface{}{
+				"no_selector":    "未指定队列选择器",
+				"count_required": "缺少参数 count",
+			},
+		},
+		"stats": map[string]interface{}{"api": map[string]interface{}{"not_enabled": "统计模块未启用"}},
+		"pipeline": map[string]interface{}{
+			"api": map[string]interface{}{
+				"not_found":          "未找到管道",
+				"running":            "管道正在运行，请先停止后再重置检查点",
+				"golden_unsupported": "API 不支持 golden 文件，请使用 expected_output",
+			},
+			"config": map[string]interface{}{
+				"invalid":            "{path} 配置错误：{error}",
+				"missing_option":     "缺少配置项 {field}",
+				"unexpected_option":  "不支持的配置项 {field}",
+				"mutually_exclusive": "配置项 {field} 和 {other} 不能同时设置",
+				"select_one":         "缺少配置项，请从 {fields} 中选择一个",
+			},
+		},
+	},
+}
+
+func init() {
+	for locale, messages := range builtinMessages {
+		AddMessages(locale, messages)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+import "sync"
+
+// plural categories, see https://cldr.unicode.org/index/cldr-spec/plural-rules
+const (
+	PluralZero  = "zero"
+	PluralOne   = "one"
+	PluralTwo   = "two"
+	PluralFew   = "few"
+	PluralMany  = "many"
+	PluralOther = "other"
+)
+
+func isPluralCategory(k string) bool {
+	switch k {
+	case PluralZero, PluralOne, PluralTwo, PluralFew, PluralMany, PluralOther:
+		return true
+	}
+	return false
+}
+
+// PluralRule return the plural category of the count
+type PluralRule func(n int) string
+
+var (
+	pluralLock  sync.RWMutex
+	pluralRules = map[string]PluralRule{}
+)
+
+// RegisterPluralRule register the plural rule of a language, eg: fr
+func RegisterPluralRule(lang string, rule PluralRule) {
+	pluralLock.Lock()
+	defer pluralLock.Unlock()
+	pluralRules[languageOf(NormalizeLocale(lang))] = rule
+}
+
+// PluralCategory return the plural category of count in the locale
+func PluralCategory(locale string, n int) string {
+	pluralLock.RLock()
+	rule, ok := pluralRules[languageOf(NormalizeLocale(locale))]
+	pluralLock.RUnlock()
+	if !ok {
+		rule = oneOther
+	}
+	if n < 0 {
+		n = -n
+	}
+	return rule(n)
+}
+
+func oneOther(n int) string {
+	if n == 1 {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+func zeroOneOther(n int) string {
+	if n == 0 || n == 1 {
+		return PluralOne
+	}
+	return PluralOther
+}
+
+func otherOnly(n int) string {
+	return PluralOther
+}
+
+func slavic(n int) string {
+	switch {
+	case n%10 == 1 && n%100 != 11:
+		return PluralOne
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return PluralFew
+	default:
+		return PluralMany
+	}
+}
+
+func polish(n int) string {
+	switch {
+	case n == 1:
+		return PluralOne
+	case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+		return PluralFew
+	default:
+		return PluralMany
+	}
+}
+
+func czech(n int) string {
+	switch {
+	case n == 1:
+		return PluralOne
+	case n >= 2 && n <= 4:
+		return PluralFew
+	default:
+		return PluralOther
+	}
+}
+
+func arabic(n int) string {
+	switch {
+	case n == 0:
+		return PluralZero
+	case n == 1:
+		return PluralOne
+	case n == 2:
+		return PluralTwo
+	case n%100 >= 3 && n%100 <= 10:
+		return PluralFew
+	case n%100 >= 11:
+		return PluralMany
+	default:
+		return PluralOther
+	}
+}
+
+func init() {
+	for _, lang := range []string{"zh", "ja", "ko", "vi", "th", "id", "ms"} {
+		RegisterPluralRule(lang, otherOnly)
+	}
+	for _, lang := range []string{"fr", "hy", "kab"} {
+		RegisterPluralRule(lang, zeroOneOther)
+	}
+	for _, lang := range []string{"ru", "uk", "be"} {
+		RegisterPluralRule(lang, slavic)
+	}
+	RegisterPluralRule("pl", polish)
+	RegisterPluralRule("cs", czech)
+	RegisterPluralRule("sk", czech)
+	RegisterPluralRule("ar", arabic)
+}
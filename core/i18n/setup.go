@@ -0,0 +1,81 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package i18n
+
+import (
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/vfs"
+	"path/filepath"
+)
+
+// Config is the settings of the i18n section
+type Config struct {
+	DefaultLocale string `config:"default_locale"`
+	//folder of the catalog files, default: i18n under the config folder
+	Path string `config:"path"`
+	//folder of the catalog files in the embedded vfs
+	EmbeddedPath string `config:"embedded_path"`
+	//locales to look up in the embedded vfs, in case the folder could not be listed
+	Locales []string `config:"locales"`
+}
+
+func init() {
+	config.RegisterSectionSchema("i18n", Config{DefaultLocale: "en-US", EmbeddedPath: "/i18n"})
+}
+
+// Setup load the message catalogs, should be called after the environment is initialized
+func Setup() {
+	cfg := Config{
+		DefaultLocale: "en-US",
+		EmbeddedPath:  "/i18n",
+	}
+	if global.Env() != nil {
+		cfg.Path = filepath.Join(global.Env().GetConfigDir(), "i18n")
+	}
+
+	_, err := env.ParseConfig("i18n", &cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	SetDefaultLocale(cfg.DefaultLocale)
+
+	if cfg.EmbeddedPath != "" {
+		if err := LoadFromFS(vfs.VFS(), cfg.EmbeddedPath, cfg.Locales...); err != nil {
+			log.Error("failed to load embedded message catalogs: ", err)
+		}
+	}
+
+	//files on disk have higher priority than the embedded ones
+	if cfg.Path != "" {
+		if err := LoadFromFolder(cfg.Path); err != nil {
+			log.Error("failed to load message catalogs: ", err)
+		}
+	}
+
+	log.Debugf("i18n initialized, default locale: %v, locales: %v", GetDefaultLocale(), GetLocales())
+}
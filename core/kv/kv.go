@@ -52,6 +52,12 @@ type KVStore interface {
 	//DeleteBucket(bucket string) error
 }
 
+// AtomicKVStore is implemented by the stores which can compare and swap a value, a nil old value
+// means the key must not exist, returns false if the stored value was changed
+type AtomicKVStore interface {
+	CompareAndSwap(bucket string, key []byte, old []byte, value []byte) (bool, error)
+}
+
 var handler KVStore
 
 func getKVHandler() KVStore {
@@ -86,6 +92,20 @@ func DeleteKey(bucket string, key []byte) error {
 	return getKVHandler().DeleteKey(bucket, key)
 }
 
+// IsAtomic returns true if the registered store supports CompareAndSwap
+func IsAtomic() bool {
+	_, ok := getKVHandler().(AtomicKVStore)
+	return ok
+}
+
+func CompareAndSwap(bucket string, key []byte, old []byte, value []byte) (bool, error) {
+	h, ok := getKVHandler().(AtomicKVStore)
+	if !ok {
+		return false, errors.New("kv store doesn't support compare and swap")
+	}
+	return h.CompareAndSwap(bucket, key, old, value)
+}
+
 //func DeleteBucket(bucket string) error {
 //	return getKVHandler().DeleteBucket(bucket)
 //}
@@ -108,3 +128,8 @@ func Register(name string, h KVStore) {
 
 	log.Debug("register kv store: ", name)
 }
+
+// IsRegistered returns true if a kv store is registered
+func IsRegistered() bool {
+	return handler != nil
+}
@@ -0,0 +1,117 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+// Package kvtest provides the kv store kept in memory, for the isolated simulations and the tests
+package kvtest
+
+import (
+	"bytes"
+	"sync"
+
+	"infini.sh/framework/core/kv"
+)
+
+// MemoryStore is the kv store kept in memory, used by the isolated simulations and the tests
+type MemoryStore struct {
+	data sync.Map
+	//serializes the writes, so that compare and swap is atomic
+	lock sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Register registers a memory store as the kv store, unless a kv store is already registered
+func Register(name string) {
+	if kv.IsRegistered() {
+		return
+	}
+	kv.Register(name, NewMemoryStore())
+}
+
+func (store *MemoryStore) key(bucket string, key []byte) string {
+	return bucket + "/" + string(key)
+}
+
+func (store *MemoryStore) Open() error {
+	return nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+func (store *MemoryStore) GetValue(bucket string, key []byte) ([]byte, error) {
+	v, ok := store.data.Load(store.key(bucket, key))
+	if !ok {
+		return nil, nil
+	}
+	return v.([]byte), nil
+}
+
+func (store *MemoryStore) GetCompressedValue(bucket string, key []byte) ([]byte, error) {
+	return store.GetValue(bucket, key)
+}
+
+func (store *MemoryStore) AddValueCompress(bucket string, key []byte, value []byte) error {
+	return store.AddValue(bucket, key, value)
+}
+
+func (store *MemoryStore) AddValue(bucket string, key []byte, value []byte) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	v := make([]byte, len(value))
+	copy(v, value)
+	store.data.Store(store.key(bucket, key), v)
+	return nil
+}
+
+func (store *MemoryStore) CompareAndSwap(bucket string, key []byte, old []byte, value []byte) (bool, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	current, ok := store.data.Load(store.key(bucket, key))
+	if ok != (old != nil) || (ok && !bytes.Equal(current.([]byte), old)) {
+		return false, nil
+	}
+	v := make([]byte, len(value))
+	copy(v, value)
+	store.data.Store(store.key(bucket, key), v)
+	return true, nil
+}
+
+func (store *MemoryStore) ExistsKey(bucket string, key []byte) (bool, error) {
+	_, ok := store.data.Load(store.key(bucket, key))
+	return ok, nil
+}
+
+func (store *MemoryStore) DeleteKey(bucket string, key []byte) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.data.Delete(store.key(bucket, key))
+	return nil
+}
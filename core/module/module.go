@@ -41,6 +41,7 @@ package module
 
 import (
 	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"sort"
 )
@@ -143,6 +144,13 @@ func Start() {
 	}
 	log.Debug("all user plugin setup finished")
 
+	//unknown keys of the registered sections are rejected in strict mode, unknown sections are only warned
+	if config.IsStrictMode() {
+		if err := env.ValidateConfig(); err != nil {
+			panic(err)
+		}
+	}
+
 	log.Trace("start to start system modules")
 	for _, v := range m.system {
 
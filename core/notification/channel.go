@@ -0,0 +1,280 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package notification
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"golang.org/x/time/rate"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/stats"
+)
+
+// ChannelConfig is the common settings of the channels
+type ChannelConfig struct {
+	Type string `config:"type"`
+
+	//retry the retryable failures, eg: 429, 5xx and connection errors
+	MaxRetries int `config:"max_retries"`
+	//delay of the first retry, doubled for each retry
+	RetryDelay time.Duration `config:"retry_delay"`
+
+	//max num of messages per second, 0 means unlimited
+	RateLimit float64 `config:"rate_limit"`
+	RateBurst int     `config:"rate_burst"`
+	//how long to wait for the rate limiter before the message is dropped
+	RateLimitTimeout time.Duration `config:"rate_limit_timeout"`
+
+	//max num of messages waiting for the delivery in the background, see Channel.Send
+	QueueSize int `config:"queue_size"`
+
+	//templates of the title and the text, rendered with the variables of the message, eg: $[[title]]
+	Title            string `config:"title"`
+	Text             string `config:"text"`
+	VariableStartTag string `config:"variable_start_tag"`
+	VariableEndTag   string `config:"variable_end_tag"`
+}
+
+func defaultChannelConfig() ChannelConfig {
+	return ChannelConfig{
+		MaxRetries:       3,
+		RetryDelay:       time.Second,
+		RateLimitTimeout: 10 * time.Second,
+		QueueSize:        1000,
+		VariableStartTag: "$[[",
+		VariableEndTag:   "]]",
+	}
+}
+
+const (
+	DeliveryDelivered   = "delivered"
+	DeliveryFailed      = "failed"
+	DeliveryRateLimited = "rate_limited"
+	DeliveryDropped     = "dropped"
+)
+
+// DeliveryStatus is the record of a message delivered to a channel
+type DeliveryStatus struct {
+	MessageID string    `json:"message_id"`
+	Channel   string    `json:"channel"`
+	Type      string    `json:"type"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Took      int64     `json:"took"` //in ms
+}
+
+// Channel delivers the messages with retry and rate limiting, the deliveries are recorded
+type Channel struct {
+	name    string
+	config  *ChannelConfig
+	sender  Sender
+	limiter *rate.Limiter
+
+	lock      sync.RWMutex
+	queue     chan *Message
+	closed    bool
+	startOnce sync.Once
+
+	pendingLock sync.Mutex
+	pendingCond *sync.Cond
+	pending     int
+}
+
+func newChannel(name string, cfg *ChannelConfig, sender Sender) (*Channel, error) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultChannelConfig().QueueSize
+	}
+	c := &Channel{name: name, config: cfg, sender: sender, queue: make(chan *Message, cfg.QueueSize)}
+	c.pendingCond = sync.NewCond(&c.pendingLock)
+	if cfg.RateLimit > 0 {
+		burst := cfg.RateBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		c.limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), burst)
+	}
+	return c, nil
+}
+
+func (c *Channel) Name() string {
+	return c.name
+}
+
+func (c *Channel) Type() string {
+	return c.config.Type
+}
+
+// Send queues the message to be delivered in the background, the messages are delivered in order,
+// an error is returned only if the queue is full or the channel is closed, see Deliver
+func (c *Channel) Send(msg *Message) error {
+	msg.init()
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.closed {
+		return errors.Errorf("channel [%v] is closed", c.name)
+	}
+	c.startOnce.Do(func() {
+		go c.run()
+	})
+
+	c.pendingLock.Lock()
+	c.pending++
+	c.pendingLock.Unlock()
+	select {
+	case c.queue <- msg:
+		return nil
+	default:
+		c.done()
+		recordDelivery(DeliveryStatus{
+			MessageID: msg.ID,
+			Channel:   c.name,
+			Type:      c.config.Type,
+			Status:    DeliveryDropped,
+			Error:     "queue is full",
+			Timestamp: time.Now(),
+		})
+		stats.Increment("notification", c.name+"."+DeliveryDropped)
+		return errors.Errorf("channel [%v]: queue is full", c.name)
+	}
+}
+
+func (c *Channel) run() {
+	for msg := range c.queue {
+		if err := c.Deliver(msg); err != nil {
+			log.Errorf("failed to deliver message [%v] to channel [%v]: %v", msg.ID, c.name, err)
+		}
+		c.done()
+	}
+}
+
+func (c *Channel) done() {
+	c.pendingLock.Lock()
+	c.pending--
+	if c.pending == 0 {
+		c.pendingCond.Broadcast()
+	}
+	c.pendingLock.Unlock()
+}
+
+// Flush waits until the queued messages are delivered
+func (c *Channel) Flush() {
+	c.pendingLock.Lock()
+	for c.pending > 0 {
+		c.pendingCond.Wait()
+	}
+	c.pendingLock.Unlock()
+}
+
+// Close stops accepting the messages and waits until the queued ones are delivered
+func (c *Channel) Close() {
+	c.lock.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.lock.Unlock()
+	c.Flush()
+}
+
+// Deliver delivers the message in place, returns the error of the last attempt
+func (c *Channel) Deliver(msg *Message) error {
+	msg.init()
+	status := DeliveryStatus{
+		MessageID: msg.ID,
+		Channel:   c.name,
+		Type:      c.config.Type,
+		Timestamp: time.Now(),
+	}
+	defer func() {
+		status.Took = time.Since(status.Timestamp).Milliseconds()
+		recordDelivery(status)
+		stats.Increment("notification", c.name+"."+status.Status)
+	}()
+
+	if c.limiter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.RateLimitTimeout)
+		err := c.limiter.Wait(ctx)
+		cancel()
+		if err != nil {
+			status.Status = DeliveryRateLimited
+			status.Error = "rate limit exceeded"
+			return errors.Errorf("channel [%v]: rate limit exceeded", c.name)
+		}
+	}
+
+	var err error
+	delay := c.config.RetryDelay
+	for {
+		status.Attempts++
+		err = c.sender.Send(msg)
+		if err == nil {
+			status.Status = DeliveryDelivered
+			return nil
+		}
+		if status.Attempts > c.config.MaxRetries || !isRetryable(err) {
+			break
+		}
+		log.Debugf("failed to deliver message [%v] to channel [%v], retry in %v: %v", msg.ID, c.name, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	status.Status = DeliveryFailed
+	status.Error = err.Error()
+	return err
+}
+
+const maxDeliveries = 1000
+
+var (
+	deliveryLock sync.RWMutex
+	deliveries   []DeliveryStatus
+)
+
+func recordDelivery(status DeliveryStatus) {
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	deliveries = append(deliveries, status)
+	if len(deliveries) > maxDeliveries {
+		deliveries = deliveries[len(deliveries)-maxDeliveries:]
+	}
+}
+
+// GetDeliveries returns the latest deliveries first, filtered by the channel if not empty
+func GetDeliveries(channel string, size int) []DeliveryStatus {
+	deliveryLock.RLock()
+	defer deliveryLock.RUnlock()
+	result := []DeliveryStatus{}
+	for i := len(deliveries) - 1; i >= 0 && (size <= 0 || len(result) < size); i-- {
+		if channel == "" || deliveries[i].Channel == channel {
+			result = append(result, deliveries[i])
+		}
+	}
+	return result
+}
@@ -0,0 +1,200 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package notification
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// Message is delivered to the channels, the fields are available to the templates of the channels
+type Message struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Content  string `json:"content,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	//firing or resolved, resolved messages resolve the incidents of the event apis, eg: pagerduty
+	Status string `json:"status,omitempty"`
+	Source string `json:"source,omitempty"`
+	//messages with the same dedup key are the same incident
+	DedupKey  string            `json:"dedup_key,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Data      util.MapStr       `json:"data,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Variables returns the variables of the message for templates, eg: $[[title]], $[[labels.cluster]]
+func (m *Message) Variables() util.MapStr {
+	labels := util.MapStr{}
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	return util.MapStr{
+		"id":        m.ID,
+		"title":     m.Title,
+		"content":   m.Content,
+		"severity":  m.Severity,
+		"status":    m.Status,
+		"source":    m.Source,
+		"dedup_key": m.DedupKey,
+		"labels":    labels,
+		"data":      m.Data,
+		"timestamp": m.Timestamp.Format(time.RFC3339),
+	}
+}
+
+func (m *Message) init() {
+	if m.ID == "" {
+		m.ID = util.GetUUID()
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	if m.DedupKey == "" {
+		m.DedupKey = m.ID
+	}
+}
+
+// Sender delivers the message to the endpoint of the channel
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// SenderFactory creates the sender with the config of the channel, the templates of the channel are shared
+type SenderFactory func(cfg *config.Config, templates *Templates) (Sender, error)
+
+var (
+	factoryLock sync.RWMutex
+	factories   = map[string]SenderFactory{}
+)
+
+// RegisterChannelType registers a type of channel, eg: webhook, slack
+func RegisterChannelType(typeName string, factory SenderFactory) {
+	factoryLock.Lock()
+	defer factoryLock.Unlock()
+	factories[typeName] = factory
+}
+
+// IsChannelTypeRegistered returns true if the type of channel is registered
+func IsChannelTypeRegistered(typeName string) bool {
+	factoryLock.RLock()
+	defer factoryLock.RUnlock()
+	_, ok := factories[typeName]
+	return ok
+}
+
+// DeliveryError is returned by the senders if the endpoint rejected the message
+type DeliveryError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("endpoint responded %v: %v", e.StatusCode, e.Body)
+}
+
+// Retryable returns true if the endpoint is throttling or unavailable
+func (e *DeliveryError) Retryable() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
+
+// isRetryable returns true for the errors of the connections, the throttled or unavailable endpoints and the
+// temporary failures of the mail servers, the other errors fail the same way on retry, eg: invalid requests
+func isRetryable(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *DeliveryError:
+			return e.Retryable()
+		case *textproto.Error:
+			return e.Code >= 400 && e.Code < 500
+		case net.Error:
+			return true
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return true
+		}
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+	return false
+}
+
+func init() {
+	RegisterChannelType("webhook", newWebhookSender)
+	RegisterChannelType("slack", newSlackSender)
+	RegisterChannelType("teams", newTeamsSender)
+	RegisterChannelType("pagerduty", newPagerDutySender)
+	RegisterChannelType("email", newEmailSender)
+	RegisterChannelType("websocket", newWebsocketSender)
+}
+
+// NewChannel creates the channel by the type of the config, eg: `type: slack`
+func NewChannel(name string, cfg *config.Config) (*Channel, error) {
+	channelConfig := defaultChannelConfig()
+	if err := cfg.Unpack(&channelConfig); err != nil {
+		return nil, errors.Errorf("channel [%v]: %v", name, err)
+	}
+	if channelConfig.Type == "" {
+		return nil, errors.Errorf("channel [%v]: type is required", name)
+	}
+
+	factoryLock.RLock()
+	factory, ok := factories[strings.ToLower(channelConfig.Type)]
+	factoryLock.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("channel [%v]: unknown type %v", name, channelConfig.Type)
+	}
+
+	templates, err := newTemplates(&channelConfig)
+	if err != nil {
+		return nil, errors.Errorf("channel [%v]: %v", name, err)
+	}
+	sender, err := factory(cfg, templates)
+	if err != nil {
+		return nil, errors.Errorf("channel [%v]: %v", name, err)
+	}
+	return newChannel(name, &channelConfig, sender)
+}
@@ -0,0 +1,344 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package notification
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+// standIn records the requests posted to the channel and replies with the queued status codes
+type standIn struct {
+	lock     sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status = s.statuses[0]
+		s.statuses = s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (s *standIn) last(t *testing.T) util.MapStr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	assert.NotEmpty(t, s.bodies)
+	obj := util.MapStr{}
+	util.MustFromJSONBytes(s.bodies[len(s.bodies)-1], &obj)
+	return obj
+}
+
+func newTestChannel(t *testing.T, name string, cfg map[string]interface{}) (*Channel, *standIn, func()) {
+	stand := &standIn{}
+	server := httptest.NewServer(stand)
+	cfg["url"] = server.URL
+	c, err := config.NewConfigFrom(cfg)
+	assert.NoError(t, err)
+	channel, err := NewChannel(name, c)
+	assert.NoError(t, err)
+	return channel, stand, server.Close
+}
+
+func testMessage() *Message {
+	return &Message{
+		ID:       "msg-1",
+		Title:    "disk usage high",
+		Content:  "disk usage is 95%",
+		Severity: SeverityCritical,
+		Status:   StatusFiring,
+		Labels:   map[string]string{"cluster": "prod", "node": "node-1"},
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	channel, stand, closer := newTestChannel(t, "webhook_json", map[string]interface{}{"type": "webhook"})
+	defer closer()
+	assert.NoError(t, channel.Deliver(testMessage()))
+	obj := stand.last(t)
+	assert.Equal(t, "msg-1", obj["id"])
+	assert.Equal(t, "disk usage high", obj["title"])
+
+	channel, stand, closer = newTestChannel(t, "webhook_body", map[string]interface{}{
+		"type": "webhook",
+		"body": `{"text":"[$[[labels.cluster]]] $[[title]]: $[[missing]]"}`,
+	})
+	defer closer()
+	assert.NoError(t, channel.Deliver(testMessage()))
+	assert.Equal(t, "[prod] disk usage high: $[[missing]]", stand.last(t)["text"])
+
+	channel, stand, closer = newTestChannel(t, "webhook_form", map[string]interface{}{
+		"type":   "webhook",
+		"format": "form",
+		"fields": map[string]interface{}{"msg": "$[[severity]] on $[[labels.node]]"},
+	})
+	defer closer()
+	assert.NoError(t, channel.Deliver(testMessage()))
+	assert.Equal(t, "application/x-www-form-urlencoded", stand.requests[0].Header.Get("Content-Type"))
+	values, err := url.ParseQuery(string(stand.bodies[0]))
+	assert.NoError(t, err)
+	assert.Equal(t, "critical on node-1", values.Get("msg"))
+}
+
+func TestEmailChannel(t *testing.T) {
+	c, err := config.NewConfigFrom(map[string]interface{}{
+		"type":  "email",
+		"host":  "smtp.example.com",
+		"from":  "alerts@example.com",
+		"to":    []string{"ops@example.com"},
+		"title": "[$[[severity]]] $[[title]]",
+	})
+	assert.NoError(t, err)
+	channel, err := NewChannel("email", c)
+	assert.NoError(t, err)
+	assert.Equal(t, "email", channel.Type())
+
+	msg := testMessage()
+	msg.init()
+	body := string(channel.sender.(*emailSender).message(msg))
+	assert.Contains(t, body, "To: ops@example.com\r\n")
+	assert.Contains(t, body, "Subject: [critical] disk usage high\r\n")
+	assert.Contains(t, body, "disk usage is 95%\r\n")
+	assert.Contains(t, body, "cluster: prod\r\n")
+
+	//the title can't inject the headers
+	msg.Title = "磁盘\r\nBcc: other@example.com"
+	body = string(channel.sender.(*emailSender).message(msg))
+	assert.NotContains(t, body, "\r\nBcc:")
+	assert.Contains(t, body, "Subject: =?UTF-8?q?[critical]_=E7=A3=81=E7=9B=98_Bcc:_other@example.com?=\r\n")
+
+	c, _ = config.NewConfigFrom(map[string]interface{}{"type": "email", "host": "smtp.example.com"})
+	_, err = NewChannel("email_without_to", c)
+	assert.Error(t, err)
+}
+
+func TestSlackAndTeamsChannel(t *testing.T) {
+	channel, stand, closer := newTestChannel(t, "slack", map[string]interface{}{
+		"type":    "slack",
+		"channel": "#ops",
+		"title":   "[$[[severity]]] $[[title]]",
+	})
+	defer closer()
+	assert.NoError(t, channel.Deliver(testMessage()))
+	obj := stand.last(t)
+	assert.Equal(t, "*[critical] disk usage high*", obj["text"])
+	assert.Equal(t, "#ops", obj["channel"])
+	attachment := obj["attachments"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "#E01E5A", attachment["color"])
+	assert.Equal(t, "disk usage is 95%", attachment["text"])
+	assert.Equal(t, 2, len(attachment["fields"].([]interface{})))
+
+	channel, stand, closer = newTestChannel(t, "teams", map[string]interface{}{"type": "teams"})
+	defer closer()
+	msg := testMessage()
+	msg.Status = StatusResolved
+	assert.NoError(t, channel.Deliver(msg))
+	obj = stand.last(t)
+	assert.Equal(t, "MessageCard", obj["@type"])
+	assert.Equal(t, "disk usage high", obj["title"])
+	assert.Equal(t, "2EB67D", obj["themeColor"])
+	facts := obj["sections"].([]interface{})[0].(map[string]interface{})["facts"].([]interface{})
+	assert.Equal(t, "cluster", facts[0].(map[string]interface{})["name"])
+}
+
+func TestPagerDutyChannel(t *testing.T) {
+	_, err := NewChannel("pd", mustConfig(t, map[string]interface{}{"type": "pagerduty"}))
+	assert.Error(t, err)
+
+	channel, stand, closer := newTestChannel(t, "pagerduty", map[string]interface{}{
+		"type":        "pagerduty",
+		"routing_key": "key-1",
+		"source":      "gateway",
+	})
+	defer closer()
+	stand.statuses = []int{http.StatusAccepted, http.StatusAccepted}
+
+	msg := testMessage()
+	msg.DedupKey = "rule-1"
+	assert.NoError(t, channel.Deliver(msg))
+	obj := stand.last(t)
+	assert.Equal(t, "trigger", obj["event_action"])
+	assert.Equal(t, "key-1", obj["routing_key"])
+	assert.Equal(t, "rule-1", obj["dedup_key"])
+	payload := obj["payload"].(map[string]interface{})
+	assert.Equal(t, "critical", payload["severity"])
+	assert.Equal(t, "gateway", payload["source"])
+	assert.Equal(t, "prod", payload["custom_details"].(map[string]interface{})["cluster"])
+
+	msg.Status = StatusResolved
+	assert.NoError(t, channel.Deliver(msg))
+	obj = stand.last(t)
+	assert.Equal(t, "resolve", obj["event_action"])
+	assert.Equal(t, "rule-1", obj["dedup_key"])
+	assert.Nil(t, obj["payload"])
+}
+
+func TestChannelRetryAndDeliveries(t *testing.T) {
+	channel, stand, closer := newTestChannel(t, "retry", map[string]interface{}{
+		"type":        "webhook",
+		"max_retries": 2,
+		"retry_delay": "10ms",
+	})
+	defer closer()
+
+	stand.statuses = []int{http.StatusInternalServerError, http.StatusTooManyRequests}
+	assert.NoError(t, channel.Deliver(testMessage()))
+	assert.Equal(t, 3, len(stand.bodies))
+	deliveries := GetDeliveries("retry", 1)
+	assert.Equal(t, DeliveryDelivered, deliveries[0].Status)
+	assert.Equal(t, 3, deliveries[0].Attempts)
+
+	//client errors are not retried
+	stand.statuses = []int{http.StatusBadRequest}
+	err := channel.Deliver(testMessage())
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, err.(*DeliveryError).StatusCode)
+	assert.Equal(t, 4, len(stand.bodies))
+	deliveries = GetDeliveries("retry", 0)
+	assert.Equal(t, 2, len(deliveries))
+	assert.Equal(t, DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, 1, deliveries[0].Attempts)
+
+	//gives up after the max retries
+	stand.statuses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	assert.Error(t, channel.Deliver(testMessage()))
+	assert.Equal(t, 7, len(stand.bodies))
+	assert.Equal(t, 3, GetDeliveries("retry", 1)[0].Attempts)
+}
+
+func TestChannelRateLimit(t *testing.T) {
+	channel, stand, closer := newTestChannel(t, "limited", map[string]interface{}{
+		"type":               "webhook",
+		"rate_limit":         0.1,
+		"rate_burst":         1,
+		"rate_limit_timeout": "50ms",
+	})
+	defer closer()
+
+	start := time.Now()
+	assert.NoError(t, channel.Deliver(testMessage()))
+	assert.Error(t, channel.Deliver(testMessage()))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 1, len(stand.bodies))
+	deliveries := GetDeliveries("limited", 0)
+	assert.Equal(t, DeliveryRateLimited, deliveries[0].Status)
+	assert.Equal(t, 0, deliveries[0].Attempts)
+	assert.Equal(t, DeliveryDelivered, deliveries[1].Status)
+}
+
+// blockingSender delivers the messages once released
+type blockingSender struct {
+	release chan struct{}
+	lock    sync.Mutex
+	sent    []string
+}
+
+func (s *blockingSender) Send(msg *Message) error {
+	<-s.release
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sent = append(s.sent, msg.ID)
+	return nil
+}
+
+func TestChannelQueue(t *testing.T) {
+	cfg := defaultChannelConfig()
+	cfg.Type = "blocking"
+	cfg.QueueSize = 2
+	sender := &blockingSender{release: make(chan struct{})}
+	channel, err := newChannel("queue", &cfg, sender)
+	assert.NoError(t, err)
+
+	//one message is being delivered, two are queued
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, channel.Send(&Message{ID: fmt.Sprint(i)}))
+		if i == 0 {
+			assert.Eventually(t, func() bool { return len(channel.queue) == 0 }, time.Second, time.Millisecond)
+		}
+	}
+	assert.Error(t, channel.Send(&Message{ID: "3"}))
+	assert.Equal(t, DeliveryDropped, GetDeliveries("queue", 1)[0].Status)
+
+	close(sender.release)
+	channel.Flush()
+	assert.Equal(t, []string{"0", "1", "2"}, sender.sent)
+	assert.Equal(t, DeliveryDelivered, GetDeliveries("queue", 1)[0].Status)
+
+	channel.Close()
+	assert.Error(t, channel.Send(&Message{ID: "4"}))
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, isRetryable(&DeliveryError{StatusCode: http.StatusServiceUnavailable}))
+	assert.False(t, isRetryable(&DeliveryError{StatusCode: http.StatusNotFound}))
+	assert.True(t, isRetryable(&net.OpError{Op: "dial", Err: errors.New("connection refused")}))
+	assert.True(t, isRetryable(fmt.Errorf("post: %w", io.ErrUnexpectedEOF)))
+	assert.True(t, isRetryable(&textproto.Error{Code: 421, Msg: "try again later"}))
+	assert.False(t, isRetryable(&textproto.Error{Code: 550, Msg: "mailbox unavailable"}))
+	assert.False(t, isRetryable(errors.New("invalid template")))
+}
+
+func TestTemplate(t *testing.T) {
+	tpl, err := NewTemplate("<<title>> on <<labels.cluster>> <<data.value>> <<unknown>>", "<<", ">>")
+	assert.NoError(t, err)
+	msg := testMessage()
+	msg.Data = util.MapStr{"value": 95}
+	assert.Equal(t, "disk usage high on prod 95 <<unknown>>", tpl.Render(msg.Variables()))
+
+	tpl, err = NewTemplate("plain text", "<<", ">>")
+	assert.NoError(t, err)
+	assert.Equal(t, "plain text", tpl.Render(msg.Variables()))
+
+	_, err = NewChannel("unknown", mustConfig(t, map[string]interface{}{"type": "unknown"}))
+	assert.Error(t, err)
+}
+
+func mustConfig(t *testing.T, cfg map[string]interface{}) *config.Config {
+	c, err := config.NewConfigFrom(cfg)
+	assert.NoError(t, err)
+	return c
+}
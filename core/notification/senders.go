@@ -0,0 +1,485 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package notification
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"infini.sh/framework/core/api/websocket"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+)
+
+// HTTPConfig is the endpoint of the http based channels
+type HTTPConfig struct {
+	URL     string            `config:"url"`
+	Method  string            `config:"method"`
+	Headers map[string]string `config:"headers"`
+	Timeout time.Duration     `config:"timeout"`
+}
+
+type httpSender struct {
+	config HTTPConfig
+	client *http.Client
+}
+
+func newHTTPSender(cfg HTTPConfig) (*httpSender, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &httpSender{config: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+func (s *httpSender) post(contentType string, body []byte) error {
+	req, err := http.NewRequest(s.config.Method, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range s.config.Headers {
+		req.Header.Set(k, v)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return &DeliveryError{StatusCode: res.StatusCode, Body: string(data)}
+	}
+	return nil
+}
+
+func (s *httpSender) postJSON(obj interface{}) error {
+	return s.post("application/json", util.MustToJSONBytes(obj))
+}
+
+// WebhookConfig posts the message as json, or as form with the fields
+type WebhookConfig struct {
+	HTTPConfig `config:",inline"`
+	//json or form
+	Format string `config:"format"`
+	//template of the json body, the message is posted as json if not set
+	Body string `config:"body"`
+	//templates of the form fields
+	Fields map[string]string `config:"fields"`
+}
+
+type webhookSender struct {
+	*httpSender
+	format string
+	body   *Template
+	fields map[string]*Template
+}
+
+func newWebhookSender(c *config.Config, templates *Templates) (Sender, error) {
+	cfg := WebhookConfig{Format: "json"}
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, err
+	}
+	sender, err := newHTTPSender(cfg.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	s := &webhookSender{httpSender: sender, format: cfg.Format, fields: map[string]*Template{}}
+	switch cfg.Format {
+	case "json":
+		if s.body, err = templates.New(cfg.Body, ""); err != nil {
+			return nil, err
+		}
+	case "form":
+		for k, v := range cfg.Fields {
+			if s.fields[k], err = templates.New(v, ""); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errors.Errorf("invalid format [%v], should be json or form", cfg.Format)
+	}
+	return s, nil
+}
+
+func (s *webhookSender) Send(msg *Message) error {
+	if s.format == "form" {
+		values := url.Values{}
+		if len(s.fields) == 0 {
+			values.Set("title", msg.Title)
+			values.Set("content", msg.Content)
+			values.Set("severity", msg.Severity)
+			values.Set("status", msg.Status)
+		}
+		vars := msg.Variables()
+		for k, v := range s.fields {
+			values.Set(k, v.Render(vars))
+		}
+		return s.post("application/x-www-form-urlencoded", []byte(values.Encode()))
+	}
+	if s.body != nil {
+		return s.post("application/json", []byte(s.body.Render(msg.Variables())))
+	}
+	return s.postJSON(msg)
+}
+
+// SlackConfig posts to the slack compatible incoming webhooks
+type SlackConfig struct {
+	HTTPConfig `config:",inline"`
+	Channel    string `config:"channel"`
+	Username   string `config:"username"`
+	IconEmoji  string `config:"icon_emoji"`
+}
+
+type slackSender struct {
+	*httpSender
+	config    SlackConfig
+	templates *Templates
+}
+
+func newSlackSender(c *config.Config, templates *Templates) (Sender, error) {
+	cfg := SlackConfig{}
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, err
+	}
+	sender, err := newHTTPSender(cfg.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &slackSender{httpSender: sender, config: cfg, templates: templates}, nil
+}
+
+func (s *slackSender) Send(msg *Message) error {
+	payload := util.MapStr{
+		"text": "*" + s.templates.Title(msg) + "*",
+		"attachments": []util.MapStr{{
+			"color":  severityColor(msg),
+			"text":   s.templates.Text(msg),
+			"fields": labelFields(msg, "title", "value"),
+			"ts":     msg.Timestamp.Unix(),
+		}},
+	}
+	if s.config.Channel != "" {
+		payload["channel"] = s.config.Channel
+	}
+	if s.config.Username != "" {
+		payload["username"] = s.config.Username
+	}
+	if s.config.IconEmoji != "" {
+		payload["icon_emoji"] = s.config.IconEmoji
+	}
+	return s.postJSON(payload)
+}
+
+// teamsSender posts the message card to the teams compatible incoming webhooks
+type teamsSender struct {
+	*httpSender
+	templates *Templates
+}
+
+func newTeamsSender(c *config.Config, templates *Templates) (Sender, error) {
+	cfg := HTTPConfig{}
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, err
+	}
+	sender, err := newHTTPSender(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &teamsSender{httpSender: sender, templates: templates}, nil
+}
+
+func (s *teamsSender) Send(msg *Message) error {
+	title := s.templates.Title(msg)
+	card := util.MapStr{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    title,
+		"title":      title,
+		"text":       s.templates.Text(msg),
+		"themeColor": strings.TrimPrefix(severityColor(msg), "#"),
+	}
+	if facts := labelFields(msg, "name", "value"); len(facts) > 0 {
+		card["sections"] = []util.MapStr{{"facts": facts}}
+	}
+	return s.postJSON(card)
+}
+
+// PagerDutyConfig sends the events to the pagerduty compatible event apis, resolved messages resolve the incidents
+type PagerDutyConfig struct {
+	HTTPConfig `config:",inline"`
+	RoutingKey string `config:"routing_key"`
+	Source     string `config:"source"`
+	Component  string `config:"component"`
+	Group      string `config:"group"`
+	Class      string `config:"class"`
+}
+
+type pagerDutySender struct {
+	*httpSender
+	config    PagerDutyConfig
+	templates *Templates
+}
+
+func newPagerDutySender(c *config.Config, templates *Templates) (Sender, error) {
+	cfg := PagerDutyConfig{HTTPConfig: HTTPConfig{URL: "https://events.pagerduty.com/v2/enqueue"}}
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.RoutingKey == "" {
+		return nil, errors.New("routing_key is required")
+	}
+	sender, err := newHTTPSender(cfg.HTTPConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &pagerDutySender{httpSender: sender, config: cfg, templates: templates}, nil
+}
+
+func (s *pagerDutySender) Send(msg *Message) error {
+	event := util.MapStr{
+		"routing_key":  s.config.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    msg.DedupKey,
+	}
+	if msg.Status == StatusResolved {
+		event["event_action"] = "resolve"
+		return s.postJSON(event)
+	}
+
+	source := s.config.Source
+	if source == "" {
+		source = msg.Source
+	}
+	if source == "" {
+		source = global.Env().SystemConfig.NodeConfig.Name
+	}
+	details := util.MapStr{}
+	if msg.Content != "" {
+		details["content"] = s.templates.Text(msg)
+	}
+	for k, v := range msg.Labels {
+		details[k] = v
+	}
+	for k, v := range msg.Data {
+		details[k] = v
+	}
+	payload := util.MapStr{
+		"summary":        s.templates.Title(msg),
+		"source":         source,
+		"severity":       pagerDutySeverity(msg.Severity),
+		"timestamp":      msg.Timestamp.Format(time.RFC3339),
+		"custom_details": details,
+	}
+	if s.config.Component != "" {
+		payload["component"] = s.config.Component
+	}
+	if s.config.Group != "" {
+		payload["group"] = s.config.Group
+	}
+	if s.config.Class != "" {
+		payload["class"] = s.config.Class
+	}
+	event["payload"] = payload
+	return s.postJSON(event)
+}
+
+// EmailConfig sends the message by smtp
+type EmailConfig struct {
+	Host     string        `config:"host"`
+	Port     int           `config:"port"`
+	TLS      bool          `config:"tls"` //implicit tls, STARTTLS is used when offered otherwise
+	Username string        `config:"username"`
+	Password string        `config:"password"`
+	From     string        `config:"from"`
+	To       []string      `config:"to"`
+	Timeout  time.Duration `config:"timeout"`
+}
+
+type emailSender struct {
+	config    EmailConfig
+	templates *Templates
+}
+
+func newEmailSender(c *config.Config, templates *Templates) (Sender, error) {
+	cfg := EmailConfig{Port: 25, Timeout: 30 * time.Second}
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Host == "" || len(cfg.To) == 0 {
+		return nil, errors.New("host and to are required")
+	}
+	if cfg.From == "" {
+		cfg.From = cfg.Username
+	}
+	return &emailSender{config: cfg, templates: templates}, nil
+}
+
+func (s *emailSender) Send(msg *Message) error {
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: s.config.Timeout}
+	if s.config.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: s.config.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.config.Timeout))
+	client, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !s.config.TLS {
+		if err := client.StartTLS(&tls.Config{ServerName: s.config.Host}); err != nil {
+			return err
+		}
+	}
+	if s.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(s.config.From); err != nil {
+		return err
+	}
+	for _, to := range s.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (s *emailSender) message(msg *Message) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %v\r\n", headerValue(s.config.From))
+	fmt.Fprintf(&buf, "To: %v\r\n", headerValue(strings.Join(s.config.To, ", ")))
+	fmt.Fprintf(&buf, "Subject: %v\r\n", mime.QEncoding.Encode("UTF-8", headerValue(s.templates.Title(msg))))
+	fmt.Fprintf(&buf, "Date: %v\r\n", msg.Timestamp.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	if text := s.templates.Text(msg); text != "" {
+		buf.WriteString(text)
+		buf.WriteString("\r\n\r\n")
+	}
+	fmt.Fprintf(&buf, "severity: %v\r\nstatus: %v\r\n", msg.Severity, msg.Status)
+	for _, f := range labelFields(msg, "name", "value") {
+		fmt.Fprintf(&buf, "%v: %v\r\n", f["name"], f["value"])
+	}
+	keys := []string{}
+	for k := range msg.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%v: %v\r\n", k, msg.Data[k])
+	}
+	return buf.Bytes()
+}
+
+// headerValue strips the line breaks, so that the value can't inject the headers or the body of the mail
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", " ").Replace(v)
+}
+
+// websocketSender broadcasts the message to the clients of the websocket hub
+type websocketSender struct{}
+
+func newWebsocketSender(c *config.Config, templates *Templates) (Sender, error) {
+	return websocketSender{}, nil
+}
+
+func (websocketSender) Send(msg *Message) error {
+	websocket.BroadcastMessage(util.MustToJSON(util.MapStr{"type": "notification", "message": msg}))
+	return nil
+}
+
+func pagerDutySeverity(severity string) string {
+	switch strings.ToLower(severity) {
+	case SeverityCritical, SeverityError, SeverityWarning, SeverityInfo:
+		return strings.ToLower(severity)
+	}
+	return SeverityError
+}
+
+func severityColor(msg *Message) string {
+	if msg.Status == StatusResolved {
+		return "#2EB67D"
+	}
+	switch strings.ToLower(msg.Severity) {
+	case SeverityCritical, SeverityError:
+		return "#E01E5A"
+	case SeverityWarning:
+		return "#ECB22E"
+	}
+	return "#36C5F0"
+}
+
+func labelFields(msg *Message, keyField, valueField string) []util.MapStr {
+	keys := []string{}
+	for k := range msg.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fields := []util.MapStr{}
+	for _, k := range keys {
+		fields = append(fields, util.MapStr{keyField: k, valueField: msg.Labels[k]})
+	}
+	return fields
+}
@@ -0,0 +1,111 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package notification
+
+import (
+	"io"
+
+	"github.com/valyala/fasttemplate"
+	"infini.sh/framework/core/util"
+)
+
+// Templates renders the texts of the channel with the variables of the message
+type Templates struct {
+	startTag string
+	endTag   string
+	title    *Template
+	text     *Template
+}
+
+const defaultTitle = "$[[title]]"
+const defaultText = "$[[content]]"
+
+func newTemplates(cfg *ChannelConfig) (*Templates, error) {
+	t := &Templates{startTag: cfg.VariableStartTag, endTag: cfg.VariableEndTag}
+	var err error
+	if t.title, err = t.New(cfg.Title, defaultTitle); err != nil {
+		return nil, err
+	}
+	if t.text, err = t.New(cfg.Text, defaultText); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// New parses the template with the tags of the channel, the default is used if the text is empty
+func (t *Templates) New(text, defaultText string) (*Template, error) {
+	if text == "" {
+		text = defaultText
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return NewTemplate(text, t.startTag, t.endTag)
+}
+
+// Title renders the title of the message
+func (t *Templates) Title(msg *Message) string {
+	return t.title.Render(msg.Variables())
+}
+
+// Text renders the text of the message
+func (t *Templates) Text(msg *Message) string {
+	return t.text.Render(msg.Variables())
+}
+
+// Template of the texts of the channels, the missing variables are kept as is
+type Template struct {
+	text     string
+	startTag string
+	endTag   string
+	template *fasttemplate.Template
+}
+
+func NewTemplate(text, startTag, endTag string) (*Template, error) {
+	t := &Template{text: text, startTag: startTag, endTag: endTag}
+	if util.ContainStr(text, startTag) {
+		template, err := fasttemplate.NewTemplate(text, startTag, endTag)
+		if err != nil {
+			return nil, err
+		}
+		t.template = template
+	}
+	return t, nil
+}
+
+func (t *Template) Render(vars util.MapStr) string {
+	if t == nil {
+		return ""
+	}
+	if t.template == nil {
+		return t.text
+	}
+	return t.template.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
+		v, err := vars.GetValue(tag)
+		if err != nil || v == nil {
+			return w.Write([]byte(t.startTag + tag + t.endTag))
+		}
+		return w.Write([]byte(util.ToString(v)))
+	})
+}
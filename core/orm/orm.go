@@ -81,6 +81,24 @@ type ORM interface {
 	UpdateBy(o interface{}, query interface{}) error
 }
 
+// Version identifies the stored revision of an object, a zero version
+// stands for an object which doesn't exist yet
+type Version struct {
+	SeqNo       int64
+	PrimaryTerm int64
+}
+
+var ErrVersionConflict = errors.New("version conflict")
+
+// VersionedORM is implemented by the handlers which support conditional
+// writes, SaveIfVersion fails with ErrVersionConflict when the stored
+// object was changed since it was read
+type VersionedORM interface {
+	GetWithVersion(o interface{}) (bool, Version, error)
+
+	SaveIfVersion(ctx *Context, o interface{}, version Version) error
+}
+
 type ORMObjectBase struct {
 	ID      string     `config:"id"  json:"id,omitempty" protected:"true"   elastic_meta:"_id" elastic_mapping:"id: { type: keyword }"`
 	Created *time.Time `json:"created,omitempty" elastic_mapping:"created: { type: date }"`
@@ -444,6 +462,51 @@ func Update(ctx *Context, o interface{}) error {
 	return Save(ctx, o)
 }
 
+func getVersionedHandler() (VersionedORM, error) {
+	h, ok := getHandler().(VersionedORM)
+	if !ok {
+		return nil, errors.New("ORM handler doesn't support versioned writes")
+	}
+	return h, nil
+}
+
+func GetWithVersion(o interface{}) (bool, Version, error) {
+	rValue := reflect.ValueOf(o)
+	idExists, _ := getFieldStringValue(rValue, "ID")
+	if !idExists {
+		return false, Version{}, errors.New("id was not found")
+	}
+
+	h, err := getVersionedHandler()
+	if err != nil {
+		return false, Version{}, err
+	}
+	return h.GetWithVersion(o)
+}
+
+// SaveIfVersion saves the object only if its stored version still matches
+func SaveIfVersion(ctx *Context, o interface{}, version Version) error {
+	rValue := reflect.ValueOf(o)
+	idExists, _ := getFieldStringValue(rValue, "ID")
+	if !idExists {
+		return errors.New("id was not found")
+	}
+
+	h, err := getVersionedHandler()
+	if err != nil {
+		return err
+	}
+
+	createdExists := existsNonNullField(rValue, "Created")
+	t := time.Now()
+	setFieldValue(rValue, "Updated", &t)
+	if !createdExists {
+		setFieldValue(rValue, "Created", &t)
+	}
+
+	return h.SaveIfVersion(ctx, o, version)
+}
+
 func Delete(ctx *Context, o interface{}) error {
 	return getHandler().Delete(ctx, o)
 }
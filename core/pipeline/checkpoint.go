@@ -0,0 +1,356 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// CheckpointConfig configures where the checkpoints of a pipeline are persisted, the kv store is local
+// to the node and doesn't support failover, use the orm store for singleton pipelines, so that the
+// pipeline resumes on the other nodes after failover
+type CheckpointConfig struct {
+	Store         string `config:"store" json:"store,omitempty" enum:"kv,orm"`
+	ClearOnFinish bool   `config:"clear_on_finish" json:"clear_on_finish,omitempty"`
+}
+
+// Checkpoint is the persisted progress of a pipeline, the processors save their progress under
+// their own keys, eg: offsets or the last processed id
+type Checkpoint struct {
+	orm.ORMObjectBase
+
+	Pipeline  string      `json:"pipeline" elastic_mapping:"pipeline: { type: keyword }"`
+	NodeID    string      `json:"node_id,omitempty" elastic_mapping:"node_id: { type: keyword }"`
+	ContextID string      `json:"context_id,omitempty" elastic_mapping:"context_id: { type: keyword }"`
+	Version   int64       `json:"version" elastic_mapping:"version: { type: long }"`
+	Data      util.MapStr `json:"data,omitempty" elastic_mapping:"data: { type: object, enabled: false }"`
+}
+
+// ErrCheckpointConflict means the checkpoint was saved by others since it was loaded, eg: by the
+// pipeline running on another node, the stale progress must not overwrite the stored one
+var ErrCheckpointConflict = errors.New("checkpoint was changed by others")
+
+// CheckpointStore persists the checkpoints, Load returns nil if the checkpoint does not exist,
+// Save fails with ErrCheckpointConflict if the stored version is not the one before the saving version
+type CheckpointStore interface {
+	Load(pipeline string) (*Checkpoint, error)
+	Save(checkpoint *Checkpoint) error
+	Delete(pipeline string) error
+}
+
+const (
+	CheckpointStoreKV  = "kv"
+	CheckpointStoreORM = "orm"
+
+	checkpointBucket = "pipeline_checkpoint"
+)
+
+var checkpointStores = map[string]CheckpointStore{
+	CheckpointStoreKV:  kvCheckpointStore{},
+	CheckpointStoreORM: ormCheckpointStore{},
+}
+var checkpointStoreLocker = sync.RWMutex{}
+
+func RegisterCheckpointStore(name string, store CheckpointStore) {
+	checkpointStoreLocker.Lock()
+	defer checkpointStoreLocker.Unlock()
+	checkpointStores[name] = store
+}
+
+// GetCheckpointStore returns the store by name, kv store is used by default
+func GetCheckpointStore(name string) (CheckpointStore, error) {
+	if name == "" {
+		name = CheckpointStoreKV
+	}
+	checkpointStoreLocker.RLock()
+	defer checkpointStoreLocker.RUnlock()
+	store, ok := checkpointStores[name]
+	if !ok {
+		return nil, errors.Errorf("checkpoint store [%v] is not registered", name)
+	}
+	return store, nil
+}
+
+// GetCheckpoint loads the checkpoint of the pipeline, returns nil if there is no checkpoint
+func GetCheckpoint(cfg PipelineConfigV2) (*Checkpoint, error) {
+	store, err := GetCheckpointStore(cfg.Checkpoint.Store)
+	if err != nil {
+		return nil, err
+	}
+	return store.Load(cfg.Name)
+}
+
+// ResetCheckpoint removes the checkpoint of the pipeline, the pipeline starts from scratch on next run
+func ResetCheckpoint(cfg PipelineConfigV2) error {
+	store, err := GetCheckpointStore(cfg.Checkpoint.Store)
+	if err != nil {
+		return err
+	}
+	return store.Delete(cfg.Name)
+}
+
+// MemoryCheckpointStore keeps the checkpoints in memory, eg: for the simulations and the tests
+type MemoryCheckpointStore struct {
+	lock sync.Mutex
+	data sync.Map
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{}
+}
+
+func (store *MemoryCheckpointStore) Load(pipeline string) (*Checkpoint, error) {
+	v, ok := store.data.Load(pipeline)
+	if !ok {
+		return nil, nil
+	}
+	checkpoint := v.(Checkpoint)
+	return &checkpoint, nil
+}
+
+func (store *MemoryCheckpointStore) Save(checkpoint *Checkpoint) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	var version int64
+	if v, ok := store.data.Load(checkpoint.Pipeline); ok {
+		version = v.(Checkpoint).Version
+	}
+	if version != checkpoint.Version-1 {
+		return ErrCheckpointConflict
+	}
+	store.data.Store(checkpoint.Pipeline, *checkpoint)
+	return nil
+}
+
+func (store *MemoryCheckpointStore) Delete(pipeline string) error {
+	store.data.Delete(pipeline)
+	return nil
+}
+
+// Reset removes all the checkpoints
+func (store *MemoryCheckpointStore) Reset() {
+	store.data.Range(func(key, value interface{}) bool {
+		store.data.Delete(key)
+		return true
+	})
+}
+
+// kvCheckpointStore keeps the checkpoints in the kv store of the node, the checkpoints are not
+// visible to the other nodes, and the versions are not checked
+type kvCheckpointStore struct{}
+
+func (kvCheckpointStore) Load(pipeline string) (*Checkpoint, error) {
+	exists, err := kv.ExistsKey(checkpointBucket, []byte(pipeline))
+	if err != nil || !exists {
+		return nil, err
+	}
+	data, err := kv.GetValue(checkpointBucket, []byte(pipeline))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	checkpoint := &Checkpoint{}
+	err = util.FromJSONBytes(data, checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (kvCheckpointStore) Save(checkpoint *Checkpoint) error {
+	t := time.Now()
+	if checkpoint.Created == nil {
+		checkpoint.Created = &t
+	}
+	checkpoint.Updated = &t
+	return kv.AddValue(checkpointBucket, []byte(checkpoint.Pipeline), util.MustToJSONBytes(checkpoint))
+}
+
+func (kvCheckpointStore) Delete(pipeline string) error {
+	return kv.DeleteKey(checkpointBucket, []byte(pipeline))
+}
+
+type ormCheckpointStore struct{}
+
+func (ormCheckpointStore) Load(pipeline string) (*Checkpoint, error) {
+	//search by id, orm.Get doesn't tell missing documents from errors
+	err, result := orm.GetBy("id", pipeline, Checkpoint{})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Result) == 0 {
+		return nil, nil
+	}
+	checkpoint := &Checkpoint{}
+	err = util.FromJSONBytes(util.MustToJSONBytes(result.Result[0]), checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// Save writes the checkpoint only if the stored one is the version before it, the write is conditioned
+// on the seq_no of the stored one, so that concurrent writers are detected
+func (store ormCheckpointStore) Save(checkpoint *Checkpoint) error {
+	checkpoint.ID = checkpoint.Pipeline
+
+	stored := &Checkpoint{}
+	stored.ID = checkpoint.Pipeline
+	exists, version, err := orm.GetWithVersion(stored)
+	if !exists {
+		//tell missing checkpoints from errors
+		v, loadErr := store.Load(checkpoint.Pipeline)
+		if loadErr != nil {
+			return loadErr
+		}
+		if v != nil {
+			if err == nil {
+				err = ErrCheckpointConflict
+			}
+			return err
+		}
+		version = orm.Version{}
+	} else if err != nil {
+		return err
+	}
+	if stored.Version != checkpoint.Version-1 {
+		return ErrCheckpointConflict
+	}
+
+	err = orm.SaveIfVersion(&orm.Context{Refresh: "wait_for"}, checkpoint, version)
+	if err == orm.ErrVersionConflict {
+		return ErrCheckpointConflict
+	}
+	return err
+}
+
+func (ormCheckpointStore) Delete(pipeline string) error {
+	checkpoint := &Checkpoint{}
+	checkpoint.ID = pipeline
+	return orm.Delete(&orm.Context{Refresh: "wait_for"}, checkpoint)
+}
+
+// loadCheckpoint must be called after holding checkpointLock
+func (ctx *Context) loadCheckpoint() (*Checkpoint, error) {
+	if ctx.checkpoint != nil {
+		return ctx.checkpoint, nil
+	}
+	checkpoint, err := GetCheckpoint(ctx.Config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load checkpoint of pipeline [%v]", ctx.Config.Name)
+	}
+	if checkpoint == nil {
+		checkpoint = &Checkpoint{Pipeline: ctx.Config.Name}
+	}
+	if checkpoint.Data == nil {
+		checkpoint.Data = util.MapStr{}
+	}
+	ctx.checkpoint = checkpoint
+	return checkpoint, nil
+}
+
+// GetCheckpoint restores the value saved by SaveCheckpoint into v, returns false if there is no such key,
+// the checkpoint is loaded from the store once per run, so it is restored after restarts and failovers
+func (ctx *Context) GetCheckpoint(key string, v interface{}) (bool, error) {
+	ctx.checkpointLock.Lock()
+	defer ctx.checkpointLock.Unlock()
+
+	checkpoint, err := ctx.loadCheckpoint()
+	if err != nil {
+		return false, err
+	}
+	value, ok := checkpoint.Data[key]
+	if !ok {
+		return false, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// SaveCheckpoint persists the progress of the processor under the key, the value must be JSON serializable
+func (ctx *Context) SaveCheckpoint(key string, v interface{}) error {
+	ctx.checkpointLock.Lock()
+	defer ctx.checkpointLock.Unlock()
+
+	checkpoint, err := ctx.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+	store, err := GetCheckpointStore(ctx.Config.Checkpoint.Store)
+	if err != nil {
+		return err
+	}
+
+	//round trip, so that the cached value is the same as the one loaded from the store
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	next := *checkpoint
+	next.Data = util.MapStr{}
+	for k, v := range checkpoint.Data {
+		next.Data[k] = v
+	}
+	next.Data[key] = value
+	next.Version++
+	next.NodeID = global.Env().SystemConfig.NodeConfig.ID
+	next.ContextID = ctx.id
+
+	if err = store.Save(&next); err != nil {
+		if err == ErrCheckpointConflict {
+			//reload the latest checkpoint on next access
+			ctx.checkpoint = nil
+		}
+		return errors.Wrapf(err, "failed to save checkpoint of pipeline [%v]", ctx.Config.Name)
+	}
+	ctx.checkpoint = &next
+	return nil
+}
+
+// ResetCheckpoint removes all the checkpoints of the pipeline
+func (ctx *Context) ResetCheckpoint() error {
+	ctx.checkpointLock.Lock()
+	defer ctx.checkpointLock.Unlock()
+
+	ctx.checkpoint = nil
+	return ResetCheckpoint(ctx.Config)
+}
@@ -0,0 +1,83 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/errors"
+)
+
+func TestCheckpoint(t *testing.T) {
+	store := NewMemoryCheckpointStore()
+	RegisterCheckpointStore("memory", store)
+
+	cfg := PipelineConfigV2{Name: "backfill", Checkpoint: CheckpointConfig{Store: "memory"}}
+	assert.NoError(t, cfg.Validate())
+
+	type progress struct {
+		Offset int64  `json:"offset"`
+		LastID string `json:"last_id"`
+	}
+
+	ctx := AcquireContext(cfg)
+	v := progress{}
+	ok, err := ctx.GetCheckpoint("scroll", &v)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, ctx.SaveCheckpoint("scroll", progress{Offset: 100, LastID: "a"}))
+	assert.NoError(t, ctx.SaveCheckpoint("scroll", progress{Offset: 200, LastID: "b"}))
+	assert.NoError(t, ctx.SaveCheckpoint("count", 2))
+
+	//restarted pipeline resumes from the persisted checkpoint
+	restarted := AcquireContext(cfg)
+	ok, err = restarted.GetCheckpoint("scroll", &v)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, progress{Offset: 200, LastID: "b"}, v)
+
+	checkpoint, err := GetCheckpoint(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), checkpoint.Version)
+	assert.Equal(t, ctx.ID(), checkpoint.ContextID)
+
+	//the stale context is not allowed to overwrite the progress saved by others
+	assert.NoError(t, restarted.SaveCheckpoint("count", 3))
+	err = ctx.SaveCheckpoint("count", 4)
+	assert.Equal(t, ErrCheckpointConflict, errors.Cause(err))
+	assert.NoError(t, ctx.SaveCheckpoint("count", 4))
+	checkpoint, err = GetCheckpoint(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), checkpoint.Version)
+
+	assert.NoError(t, restarted.ResetCheckpoint())
+	checkpoint, err = GetCheckpoint(cfg)
+	assert.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	cfg.Checkpoint.Store = "unknown"
+	assert.Error(t, cfg.Validate())
+}
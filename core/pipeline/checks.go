@@ -41,8 +41,8 @@
 package pipeline
 
 import (
-	"fmt"
 	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/i18n"
 )
 
 func ProcessorConfigChecked(
@@ -53,7 +53,7 @@ func ProcessorConfigChecked(
 	return func(cfg *config.Config) (Processor, error) {
 		err := validator(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("%v in %v", err.Error(), cfg.Path())
+			return nil, i18n.NewError("pipeline.config.invalid", i18n.Params{"error": err, "path": cfg.Path()})
 		}
 
 		return constr(cfg)
@@ -68,7 +68,7 @@ func FilterConfigChecked(
 	return func(cfg *config.Config) (Filter, error) {
 		err := validator(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("%v in %v", err.Error(), cfg.Path())
+			return nil, i18n.NewError("pipeline.config.invalid", i18n.Params{"error": err, "path": cfg.Path()})
 		}
 
 		return constr(cfg)
@@ -91,7 +91,7 @@ func RequireFields(fields ...string) func(*config.Config) error {
 	return func(cfg *config.Config) error {
 		for _, field := range fields {
 			if !cfg.HasField(field) {
-				return fmt.Errorf("missing %v option", field)
+				return i18n.NewError("pipeline.config.missing_option", i18n.Params{"field": field})
 			}
 		}
 		return nil
@@ -111,7 +111,7 @@ func AllowedFields(fields ...string) func(*config.Config) error {
 			}
 
 			if !found {
-				return fmt.Errorf("unexpected %v option", field)
+				return i18n.NewError("pipeline.config.unexpected_option", i18n.Params{"field": field})
 			}
 		}
 		return nil
@@ -130,14 +130,14 @@ func MutuallyExclusiveRequiredFields(fields ...string) func(*config.Config) erro
 					if len(foundField) == 0 {
 						foundField = field
 					} else {
-						return fmt.Errorf("field %s and %s are mutually exclusive", foundField, field)
+						return i18n.NewError("pipeline.config.mutually_exclusive", i18n.Params{"field": foundField, "other": field})
 					}
 				}
 			}
 		}
 
 		if len(foundField) == 0 {
-			return fmt.Errorf("missing option, select one from %v", fields)
+			return i18n.NewError("pipeline.config.select_one", i18n.Params{"fields": fields})
 		}
 		return nil
 	}
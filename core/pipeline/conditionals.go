@@ -181,6 +181,8 @@ func NewIfElseThenProcessor(cfg *config.Config) (*IfThenElseProcessor, error) {
 	return &IfThenElseProcessor{cond, ifProcessors, elseProcessors}, nil
 }
 
+func (p IfThenElseProcessor) containsProcessors() {}
+
 // Run checks the if condition and executes the processors attached to the
 // then statement or the else statement based on the condition.
 func (p IfThenElseProcessor) Process(ctx *Context) error {
@@ -24,11 +24,43 @@
 package pipeline
 
 import (
+	"fmt"
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/util"
 )
 
+func init() {
+	config.RegisterSectionSchema("pipeline", []PipelineConfigV2{})
+	config.RegisterSectionSchema("resource_groups", []ResourceGroupConfig{})
+	config.RegisterSectionValidator("pipeline", func(cfg *config.Config) error {
+		pipelines := []PipelineConfigV2{}
+		if err := cfg.Unpack(&pipelines); err != nil {
+			return err
+		}
+		for _, v := range pipelines {
+			if err := v.Validate(); err != nil {
+				return fmt.Errorf("pipeline [%v]: %v", v.Name, err)
+			}
+			if !config.IsStrictMode() {
+				continue
+			}
+			if err := ValidateProcessorConfigs(v.Processors); err != nil {
+				return fmt.Errorf("pipeline [%v]: %v", v.Name, err)
+			}
+			if v.Graph != nil {
+				for _, node := range v.Graph.Nodes {
+					if err := ValidateProcessorConfigs(append(node.Processors, node.Race...)); err != nil {
+						return fmt.Errorf("pipeline [%v], node [%v]: %v", v.Name, node.Name, err)
+					}
+				}
+			}
+		}
+		return nil
+	})
+}
+
 type PipelineConfigV2 struct {
 	Name           string `config:"name" json:"name,omitempty"`
 	Enabled        *bool  `config:"enabled" json:"enabled,omitempty"`
@@ -41,14 +73,18 @@ type PipelineConfigV2 struct {
 		Enabled bool `config:"enabled" json:"enabled"`
 	} `config:"logging" json:"logging"`
 	Processors []*config.Config       `config:"processor" json:"-"`
+	Graph      *GraphConfig           `config:"graph" json:"-"`
+	Checkpoint CheckpointConfig       `config:"checkpoint" json:"checkpoint"`
 	Labels     map[string]interface{} `config:"labels" json:"labels"`
+	//the resource group limiting this pipeline, see ResourceGroupConfig
+	ResourceGroup string `config:"resource_group" json:"resource_group,omitempty"`
 
 	Transient bool `config:"-" json:"transient"`
 }
 
 func (this PipelineConfigV2) Equals(target PipelineConfigV2) bool {
 
-	if util.MustToJSON(this) == util.MustToJSON(target) {
+	if util.MustToJSON(this) == util.MustToJSON(target) && this.GraphEquals(target) {
 		return true
 	}
 
@@ -57,7 +93,8 @@ func (this PipelineConfigV2) Equals(target PipelineConfigV2) bool {
 		this.KeepRunning != target.KeepRunning ||
 		this.RetryDelayInMs != target.RetryDelayInMs ||
 		this.Logging.Enabled != target.Logging.Enabled ||
-		!this.ProcessorsEquals(target) {
+		!this.ProcessorsEquals(target) ||
+		!this.GraphEquals(target) {
 		return false
 	}
 
@@ -69,6 +106,27 @@ func (this PipelineConfigV2) Equals(target PipelineConfigV2) bool {
 	return true
 }
 
+// Validate checks the checkpoint store exists, the processors and the graph can't be used together, and the graph has no cycles
+func (this PipelineConfigV2) Validate() error {
+	if _, err := GetCheckpointStore(this.Checkpoint.Store); err != nil {
+		return err
+	}
+	if this.Graph == nil {
+		return nil
+	}
+	if len(this.Processors) > 0 {
+		return errors.New("processor and graph can't be used together")
+	}
+	return ValidateGraph(this.Graph)
+}
+
+func (this PipelineConfigV2) GraphEquals(target PipelineConfigV2) bool {
+	if this.Graph == nil || target.Graph == nil {
+		return this.Graph == target.Graph
+	}
+	return util.MustToJSON(this.Graph.ToMap()) == util.MustToJSON(target.Graph.ToMap())
+}
+
 func (this PipelineConfigV2) ProcessorsEquals(target PipelineConfigV2) bool {
 	if len(this.Processors) != len(target.Processors) {
 		return false
@@ -95,3 +153,31 @@ func (this PipelineConfigV2) ProcessorsEquals(target PipelineConfigV2) bool {
 	}
 	return true
 }
+
+// LoadConfigs loads the pipelines defined in the config file and the files of the config dir
+func LoadConfigs(configFile, configDir string) ([]PipelineConfigV2, error) {
+	parentCfg, err := config.LoadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file: %v, path: %s", err, configFile)
+	}
+	childCfg, err := config.LoadPath(configDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config dir: %v, path: %s", err, configDir)
+	}
+	err = parentCfg.Merge(childCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge configs: %v", err)
+	}
+
+	pipelineCfg := []PipelineConfigV2{}
+
+	if ok := parentCfg.HasField("pipeline"); ok {
+		parentCfg, err = parentCfg.Child("pipeline", -1)
+		if err != nil {
+			return nil, err
+		}
+		err = parentCfg.Unpack(&pipelineCfg)
+		return pipelineCfg, err
+	}
+	return pipelineCfg, nil
+}
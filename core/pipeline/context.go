@@ -43,15 +43,24 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/event"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/pubsub"
 	"infini.sh/framework/core/util"
 )
 
+// TopicPipelineState is the pubsub topic of pipeline state changes
+const TopicPipelineState = "pipeline.state"
+
+func init() {
+	pubsub.RegisterTopic(TopicPipelineState, "running state changes of pipelines", "")
+}
+
 type RunningState string
 
 const STARTING RunningState = "STARTING"
@@ -101,6 +110,25 @@ type Context struct {
 	stateLock    sync.Mutex
 	released     bool
 	loopReleased bool
+
+	//the context derived from on reset, see SetBaseContext
+	baseContext context.Context
+
+	//states of the graph nodes, see Graph
+	nodeOrder  []string
+	nodeStates map[string]*NodeState
+
+	//cached checkpoint, see SaveCheckpoint
+	checkpoint     *Checkpoint
+	checkpointLock sync.Mutex
+
+	resourceGroup *ResourceGroup
+	//parameters copied from the parent of a child context, see newChildContext
+	inherited util.MapStr
+	//slot of the current run, see AcquireRun
+	runSlot atomic.Pointer[runSlot]
+	//a processor is being metered, the processors it runs are not metered again, see Processors.Process
+	metering atomic.Bool
 }
 
 func AcquireContext(config PipelineConfigV2) *Context {
@@ -110,9 +138,15 @@ func AcquireContext(config PipelineConfigV2) *Context {
 	ctx.createTime = time.Now()
 	ctx.runningState = FINISHED
 	ctx.Config = config
+	ctx.resourceGroup = GetResourceGroupFor(config)
 	return &ctx
 }
 
+// ResourceGroup returns the group limiting this pipeline, nil means unlimited
+func (ctx *Context) ResourceGroup() *ResourceGroup {
+	return ctx.resourceGroup
+}
+
 // ReleaseContext could be called concurrently
 // Doesn't handle context lifecycle, only recycle the resources
 // Mark the context as released, quit the pipeline loop automatically
@@ -169,16 +203,83 @@ func (ctx *Context) GetRunningState() RunningState {
 	return ctx.runningState
 }
 
+// SetBaseContext derives the context from the base one, the values of the base context are visible to the
+// processors, eg: the queue scope of the simulations, and the context is canceled with the base one
+func (ctx *Context) SetBaseContext(base context.Context) {
+	ctx.baseContext = base
+	if ctx.cancelFunc != nil {
+		ctx.cancelFunc()
+	}
+	ctx.Context, ctx.cancelFunc = context.WithCancel(base)
+}
+
 // ResetContext only clears the context informations, doesn't modify state values
 func (ctx *Context) ResetContext() {
 	t := time.Now()
 	ctx.startTime = &t
 	ctx.endTime = nil
-	ctx.Context, ctx.cancelFunc = context.WithCancel(context.Background())
+	base := ctx.baseContext
+	if base == nil {
+		base = context.Background()
+	}
+	ctx.Context, ctx.cancelFunc = context.WithCancel(base)
 	ctx.exitErr = nil
 	ctx.processErrs = []error{}
 	ctx.processHistory = []string{}
 	ctx.ResetParameters()
+	ctx.stateLock.Lock()
+	ctx.nodeOrder = nil
+	ctx.nodeStates = nil
+	ctx.stateLock.Unlock()
+
+	//reload the checkpoint on next run, it may be modified by other nodes or reset via the API
+	ctx.checkpointLock.Lock()
+	ctx.checkpoint = nil
+	ctx.checkpointLock.Unlock()
+}
+
+func (ctx *Context) initNodeStates(nodes []string) {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+
+	ctx.nodeOrder = nodes
+	ctx.nodeStates = map[string]*NodeState{}
+	for _, name := range nodes {
+		ctx.nodeStates[name] = &NodeState{Name: name, State: NodePending}
+	}
+}
+
+func (ctx *Context) updateNodeState(name string, update func(state *NodeState)) {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+
+	if state, ok := ctx.nodeStates[name]; ok {
+		update(state)
+	}
+}
+
+func (ctx *Context) getNodeState(name string) NodeState {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+
+	if state, ok := ctx.nodeStates[name]; ok {
+		return *state
+	}
+	return NodeState{Name: name}
+}
+
+// GetNodeStates returns the node states of the last graph run, in the order of declaration
+func (ctx *Context) GetNodeStates() []NodeState {
+	ctx.stateLock.Lock()
+	defer ctx.stateLock.Unlock()
+
+	result := make([]NodeState, 0, len(ctx.nodeOrder))
+	for _, name := range ctx.nodeOrder {
+		if state, ok := ctx.nodeStates[name]; ok {
+			result = append(result, *state)
+		}
+	}
+	return result
 }
 
 func (ctx *Context) GetFlowProcess() []string {
@@ -366,6 +467,14 @@ func (ctx *Context) setRunningState(newState RunningState) {
 		if ctx.Config.Logging.Enabled {
 			ctx.pushPipelineLog()
 		}
+
+		pubsub.Publish(TopicPipelineState, "state_changed", util.MapStr{
+			"id":             ctx.Config.Name,
+			"context_id":     ctx.id,
+			"state":          newState,
+			"previous_state": oldState,
+			"steps":          ctx.steps,
+		})
 	}
 }
 
@@ -134,6 +134,26 @@ func (dag *Dag) Spawns(tasks ...Processor) *spawnsResult {
 	}
 }
 
+// First executes tasks concurrently, only waits for the first finished one
+func (dag *Dag) First(tasks ...Processor) *spawnsResult {
+
+	job := &Job{
+		tasks:      make([]Processor, len(tasks)),
+		sequential: false,
+		mode:       "first_win",
+	}
+
+	for i, task := range tasks {
+		job.tasks[i] = task
+	}
+
+	dag.jobs = append(dag.jobs, job)
+
+	return &spawnsResult{
+		dag,
+	}
+}
+
 type anyResult struct {
 	dag *Dag
 }
@@ -90,8 +90,8 @@ func NewDAGProcessor(c *config2.Config) (Processor, error) {
 
 	//log.Info("init dag processor")
 
-	if len(cfg.ParallelProcessors)==0{
-		return nil,errors.New("parallel is not set")
+	if len(cfg.ParallelProcessors)==0 && len(cfg.FirstFinishedProcessors)==0{
+		return nil,errors.New("parallel or first is not set")
 	}
 
 	processor.dag = NewDAG(cfg.Mode)
@@ -104,6 +104,15 @@ func NewDAGProcessor(c *config2.Config) (Processor, error) {
 		dsl = processor.dag.Spawns(p...)
 	}
 
+	//first finished processors are running after the parallel ones
+	p, err = getProcessors(cfg.FirstFinishedProcessors)
+	if err != nil {
+		panic(err)
+	}
+	if len(p) > 0 {
+		dsl = processor.dag.First(p...)
+	}
+
 	p, err = getProcessors(cfg.AfterJoinAllProcessors)
 	if err != nil {
 		panic(err)
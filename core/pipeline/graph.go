@@ -0,0 +1,701 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/conditions"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/util"
+)
+
+// GraphConfig declares a pipeline as a graph of named nodes, nodes without incoming edges are started first,
+// a node with multiple incoming edges waits for its upstream nodes according to the join mode
+type GraphConfig struct {
+	Nodes []GraphNodeConfig `config:"nodes" json:"nodes"`
+	Edges []GraphEdgeConfig `config:"edges" json:"edges"`
+}
+
+// GraphNodeConfig is either a sequential list of processors, or a race of processors where the first one
+// successfully finished wins
+type GraphNodeConfig struct {
+	Name       string           `config:"name" json:"name" validate:"required"`
+	Processors []*config.Config `config:"processor" json:"-"`
+	Race       []*config.Config `config:"race" json:"-"`
+
+	//all: wait for all the upstream nodes, any: run as soon as one active upstream node finished
+	Join string `config:"join" json:"join,omitempty" enum:"all,any"`
+
+	MaxRetries     int `config:"max_retries" json:"max_retries,omitempty"`
+	RetryDelayInMs int `config:"retry_delay_in_ms" json:"retry_delay_in_ms,omitempty"`
+	TimeoutInMs    int `config:"timeout_in_ms" json:"timeout_in_ms,omitempty"`
+
+	//fail: abort the whole graph, continue: treat as finished, skip: skip the downstream nodes
+	OnFailure string `config:"on_failure" json:"on_failure,omitempty" enum:"fail,continue,skip"`
+}
+
+// GraphEdgeConfig connects two nodes, the edge is only followed when the optional condition matches
+type GraphEdgeConfig struct {
+	From string         `config:"from" json:"from" validate:"required"`
+	To   string         `config:"to" json:"to" validate:"required"`
+	When *config.Config `config:"when" json:"-"`
+}
+
+const (
+	GraphJoinAll = "all"
+	GraphJoinAny = "any"
+
+	OnFailureFail     = "fail"
+	OnFailureContinue = "continue"
+	OnFailureSkip     = "skip"
+)
+
+type NodeRunningState string
+
+const (
+	NodePending   NodeRunningState = "PENDING"
+	NodeRunning   NodeRunningState = "RUNNING"
+	NodeSucceeded NodeRunningState = "SUCCEEDED"
+	NodeFailed    NodeRunningState = "FAILED"
+	NodeSkipped   NodeRunningState = "SKIPPED"
+	NodeCanceled  NodeRunningState = "CANCELED"
+)
+
+func (s NodeRunningState) IsEnded() bool {
+	return s == NodeSucceeded || s == NodeFailed || s == NodeSkipped || s == NodeCanceled
+}
+
+// NodeState is the running state of a graph node within the last run of the pipeline
+type NodeState struct {
+	Name      string           `json:"name"`
+	State     NodeRunningState `json:"state"`
+	Attempts  int              `json:"attempts,omitempty"`
+	StartTime *time.Time       `json:"start_time,omitempty"`
+	EndTime   *time.Time       `json:"end_time,omitempty"`
+	Winner    string           `json:"winner,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+type graphEdge struct {
+	from      string
+	to        string
+	condition conditions.Condition
+}
+
+type graphNode struct {
+	cfg      GraphNodeConfig
+	steps    *Processors
+	race     []*Processors
+	incoming []*graphEdge
+	outgoing []*graphEdge
+}
+
+// Graph is a processor runs the nodes of GraphConfig, independent nodes are running concurrently
+type Graph struct {
+	nodes map[string]*graphNode
+	order []string
+}
+
+// ValidateGraph checks the structure of the graph config, eg: duplicated or unknown nodes and cycles
+func ValidateGraph(cfg *GraphConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if len(cfg.Nodes) == 0 {
+		return errors.New("graph has no nodes")
+	}
+
+	nodes := map[string]GraphNodeConfig{}
+	for i, n := range cfg.Nodes {
+		if n.Name == "" {
+			return errors.Errorf("nodes[%d]: name is required", i)
+		}
+		if _, ok := nodes[n.Name]; ok {
+			return errors.Errorf("node [%v] is defined more than once", n.Name)
+		}
+		if len(n.Processors) > 0 && len(n.Race) > 0 {
+			return errors.Errorf("node [%v]: processor and race can't be used together", n.Name)
+		}
+		if len(n.Race) == 1 {
+			return errors.Errorf("node [%v]: race requires at least two processors", n.Name)
+		}
+		switch n.Join {
+		case "", GraphJoinAll, GraphJoinAny:
+		default:
+			return errors.Errorf("node [%v]: invalid join mode [%v]", n.Name, n.Join)
+		}
+		switch n.OnFailure {
+		case "", OnFailureFail, OnFailureContinue, OnFailureSkip:
+		default:
+			return errors.Errorf("node [%v]: invalid on_failure policy [%v]", n.Name, n.OnFailure)
+		}
+		if n.MaxRetries < 0 || n.RetryDelayInMs < 0 || n.TimeoutInMs < 0 {
+			return errors.Errorf("node [%v]: max_retries, retry_delay_in_ms and timeout_in_ms can't be negative", n.Name)
+		}
+		nodes[n.Name] = n
+	}
+
+	outgoing := map[string][]string{}
+	edges := map[string]bool{}
+	for i, e := range cfg.Edges {
+		if _, ok := nodes[e.From]; !ok {
+			return errors.Errorf("edges[%d]: unknown node [%v]", i, e.From)
+		}
+		if _, ok := nodes[e.To]; !ok {
+			return errors.Errorf("edges[%d]: unknown node [%v]", i, e.To)
+		}
+		key := e.From + "->" + e.To
+		if edges[key] {
+			return errors.Errorf("edges[%d]: duplicated edge %v", i, key)
+		}
+		edges[key] = true
+		outgoing[e.From] = append(outgoing[e.From], e.To)
+	}
+
+	if cycle := findCycle(cfg.Nodes, outgoing); len(cycle) > 0 {
+		return errors.Errorf("graph contains a cycle: %v", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// findCycle returns the path of the first cycle found by depth first search
+func findCycle(nodes []GraphNodeConfig, outgoing map[string][]string) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := map[string]int{}
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		marks[name] = visiting
+		path = append(path, name)
+		for _, next := range outgoing[name] {
+			switch marks[next] {
+			case visiting:
+				for i, v := range path {
+					if v == next {
+						return append(append([]string{}, path[i:]...), next)
+					}
+				}
+			case unvisited:
+				if cycle := visit(next); len(cycle) > 0 {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		marks[name] = visited
+		return nil
+	}
+
+	for _, n := range nodes {
+		if marks[n.Name] == unvisited {
+			if cycle := visit(n.Name); len(cycle) > 0 {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// NewGraph validates the graph config and creates the processors of every node
+func NewGraph(cfg *GraphConfig) (*Graph, error) {
+	if err := ValidateGraph(cfg); err != nil {
+		return nil, err
+	}
+
+	graph := &Graph{nodes: map[string]*graphNode{}}
+	for _, n := range cfg.Nodes {
+		node := &graphNode{cfg: n}
+		if len(n.Race) > 0 {
+			for _, c := range n.Race {
+				p, err := NewPipeline([]*config.Config{c})
+				if err != nil {
+					return nil, errors.Wrapf(err, "failed to create processors of node [%v]", n.Name)
+				}
+				p.SkipCatchError = true
+				node.race = append(node.race, p)
+			}
+		} else {
+			p, err := NewPipeline(n.Processors)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to create processors of node [%v]", n.Name)
+			}
+			p.SkipCatchError = true
+			node.steps = p
+		}
+		graph.nodes[n.Name] = node
+		graph.order = append(graph.order, n.Name)
+	}
+
+	for _, e := range cfg.Edges {
+		edge := &graphEdge{from: e.From, to: e.To}
+		if e.When != nil {
+			condConfig := conditions.Config{}
+			if err := e.When.Unpack(&condConfig); err != nil {
+				return nil, errors.Wrapf(err, "invalid condition of edge %v->%v", e.From, e.To)
+			}
+			cond, err := conditions.NewCondition(&condConfig)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid condition of edge %v->%v", e.From, e.To)
+			}
+			edge.condition = cond
+		}
+		graph.nodes[e.From].outgoing = append(graph.nodes[e.From].outgoing, edge)
+		graph.nodes[e.To].incoming = append(graph.nodes[e.To].incoming, edge)
+	}
+	return graph, nil
+}
+
+// ToMap returns the graph config as a plain map, it is used to display and compare graphs
+func (cfg *GraphConfig) ToMap() util.MapStr {
+	if cfg == nil {
+		return nil
+	}
+	nodes := []util.MapStr{}
+	for _, n := range cfg.Nodes {
+		node := util.MapStr{"name": n.Name}
+		if len(n.Processors) > 0 {
+			node["processor"] = configsToMaps(n.Processors)
+		}
+		if len(n.Race) > 0 {
+			node["race"] = configsToMaps(n.Race)
+		}
+		if n.Join != "" {
+			node["join"] = n.Join
+		}
+		if n.MaxRetries > 0 {
+			node["max_retries"] = n.MaxRetries
+		}
+		if n.RetryDelayInMs > 0 {
+			node["retry_delay_in_ms"] = n.RetryDelayInMs
+		}
+		if n.TimeoutInMs > 0 {
+			node["timeout_in_ms"] = n.TimeoutInMs
+		}
+		if n.OnFailure != "" {
+			node["on_failure"] = n.OnFailure
+		}
+		nodes = append(nodes, node)
+	}
+	edges := []util.MapStr{}
+	for _, e := range cfg.Edges {
+		edge := util.MapStr{"from": e.From, "to": e.To}
+		if e.When != nil {
+			edge["when"] = configToMap(e.When)
+		}
+		edges = append(edges, edge)
+	}
+	return util.MapStr{"nodes": nodes, "edges": edges}
+}
+
+func configToMap(cfg *config.Config) map[string]interface{} {
+	m := map[string]interface{}{}
+	if cfg != nil {
+		if err := cfg.Unpack(m); err != nil {
+			log.Error(err)
+		}
+	}
+	return m
+}
+
+func configsToMaps(cfgs []*config.Config) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(cfgs))
+	for _, c := range cfgs {
+		result = append(result, configToMap(c))
+	}
+	return result
+}
+
+func (graph *Graph) Name() string {
+	return "graph"
+}
+
+func (graph *Graph) containsProcessors() {}
+
+func (graph *Graph) processors() []*Processors {
+	var result []*Processors
+	for _, name := range graph.order {
+		node := graph.nodes[name]
+		if node.steps != nil {
+			result = append(result, node.steps)
+		}
+		result = append(result, node.race...)
+	}
+	return result
+}
+
+func (graph *Graph) Release() error {
+	for _, p := range graph.processors() {
+		p.Release()
+	}
+	return nil
+}
+
+func (graph *Graph) Close() error {
+	var errs errors.Errors
+	for _, p := range graph.processors() {
+		if err := p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.Err()
+}
+
+type nodeResult struct {
+	name   string
+	winner string
+	err    error
+}
+
+// graphRun tracks the scheduling state of a single run, only accessed by the Process goroutine
+type graphRun struct {
+	graph *Graph
+	ctx   *Context
+
+	//number of the incoming edges not resolved yet
+	pending map[string]int
+	//number of the incoming edges resolved as active
+	active  map[string]int
+	started map[string]bool
+
+	running int
+	failed  error
+	results chan nodeResult
+}
+
+// Process runs the graph until all the reachable nodes are finished, the first error of a node with
+// the fail policy aborts the graph, running nodes are waited but no more nodes will be started
+func (graph *Graph) Process(ctx *Context) error {
+	ctx.initNodeStates(graph.order)
+
+	run := &graphRun{
+		graph:   graph,
+		ctx:     ctx,
+		pending: map[string]int{},
+		active:  map[string]int{},
+		started: map[string]bool{},
+		results: make(chan nodeResult, len(graph.order)),
+	}
+
+	for _, name := range graph.order {
+		run.pending[name] = len(graph.nodes[name].incoming)
+	}
+	for _, name := range graph.order {
+		if len(graph.nodes[name].incoming) == 0 {
+			run.start(name)
+		}
+	}
+
+	for run.running > 0 {
+		result := <-run.results
+		run.running--
+		run.finish(result)
+	}
+
+	//nodes never reached are canceled
+	for _, name := range graph.order {
+		if !ctx.getNodeState(name).State.IsEnded() {
+			ctx.updateNodeState(name, func(state *NodeState) {
+				state.State = NodeCanceled
+			})
+		}
+	}
+	return run.failed
+}
+
+func (run *graphRun) start(name string) {
+	if run.started[name] {
+		return
+	}
+	run.started[name] = true
+
+	if run.failed != nil || run.ctx.IsCanceled() {
+		return
+	}
+
+	node := run.graph.nodes[name]
+	run.running++
+	go func() {
+		var result nodeResult
+		defer func() {
+			run.results <- result
+		}()
+		result = node.execute(run.ctx)
+	}()
+}
+
+func (run *graphRun) finish(result nodeResult) {
+	node := run.graph.nodes[result.name]
+
+	followEdges := true
+	if result.err != nil {
+		log.Errorf("pipeline [%v], node [%v] failed: %v", run.ctx.Config.Name, result.name, result.err)
+		switch node.cfg.OnFailure {
+		case OnFailureContinue:
+			run.ctx.RecordError(result.err)
+		case OnFailureSkip:
+			run.ctx.RecordError(result.err)
+			followEdges = false
+		default:
+			if run.failed == nil {
+				run.failed = errors.Errorf("node [%v] failed: %v", result.name, result.err)
+			}
+			return
+		}
+	}
+	run.resolveOutgoing(node, followEdges)
+}
+
+// skip marks a node as skipped and propagates to its downstream nodes
+func (run *graphRun) skip(name string) {
+	if run.started[name] {
+		return
+	}
+	run.started[name] = true
+	run.ctx.updateNodeState(name, func(state *NodeState) {
+		state.State = NodeSkipped
+	})
+	run.resolveOutgoing(run.graph.nodes[name], false)
+}
+
+func (run *graphRun) resolveOutgoing(node *graphNode, follow bool) {
+	for _, edge := range node.outgoing {
+		active := follow && (edge.condition == nil || edge.condition.Check(run.ctx))
+		run.pending[edge.to]--
+		if active {
+			run.active[edge.to]++
+		}
+
+		target := run.graph.nodes[edge.to]
+		if target.cfg.Join == GraphJoinAny && active {
+			run.start(edge.to)
+			continue
+		}
+		if run.pending[edge.to] == 0 {
+			if run.active[edge.to] > 0 {
+				run.start(edge.to)
+			} else {
+				run.skip(edge.to)
+			}
+		}
+	}
+}
+
+func (node *graphNode) execute(ctx *Context) nodeResult {
+	name := node.cfg.Name
+	now := time.Now()
+	ctx.updateNodeState(name, func(state *NodeState) {
+		state.State = NodeRunning
+		state.StartTime = &now
+	})
+
+	result := nodeResult{name: name}
+	for attempt := 0; attempt <= node.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if node.cfg.RetryDelayInMs > 0 {
+				time.Sleep(time.Duration(node.cfg.RetryDelayInMs) * time.Millisecond)
+			}
+			if ctx.IsCanceled() {
+				break
+			}
+			log.Debugf("pipeline [%v], retry node [%v], attempt: %v", ctx.Config.Name, name, attempt+1)
+		}
+		ctx.updateNodeState(name, func(state *NodeState) {
+			state.Attempts = attempt + 1
+		})
+		result.winner, result.err = node.runWithTimeout(ctx)
+		if result.err == nil {
+			break
+		}
+	}
+
+	end := time.Now()
+	ctx.updateNodeState(name, func(state *NodeState) {
+		state.EndTime = &end
+		state.Winner = result.winner
+		if result.err != nil {
+			state.State = NodeFailed
+			state.Error = result.err.Error()
+		} else {
+			state.State = NodeSucceeded
+			state.Error = ""
+		}
+	})
+	return result
+}
+
+// runWithTimeout runs an attempt of the node on a child context, the child is canceled after the timeout and
+// the attempt is waited to return, so that a retry never runs the processors along with the abandoned attempt,
+// the processors should check ctx.IsCanceled to quit in time
+func (node *graphNode) runWithTimeout(ctx *Context) (string, error) {
+	child := newChildContext(ctx)
+	defer child.CancelTask()
+
+	if node.cfg.TimeoutInMs <= 0 {
+		winner, err := node.run(child)
+		if err == nil {
+			mergeChildContext(ctx, child)
+		}
+		return winner, err
+	}
+
+	type ret struct {
+		winner string
+		err    error
+	}
+	ch := make(chan ret, 1)
+	go func() {
+		winner, err := node.run(child)
+		ch <- ret{winner, err}
+	}()
+
+	timer := time.NewTimer(time.Duration(node.cfg.TimeoutInMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		if r.err == nil {
+			mergeChildContext(ctx, child)
+		}
+		return r.winner, r.err
+	case <-timer.C:
+		child.CancelTask()
+		<-ch
+		return "", errors.Errorf("timeout after %vms", node.cfg.TimeoutInMs)
+	}
+}
+
+// run executes the steps of the node, or races the branches on their own child contexts, the losers are
+// canceled once a branch won, and all the branches are waited before the winner is taken over
+func (node *graphNode) run(ctx *Context) (string, error) {
+	if len(node.race) == 0 {
+		return "", runProcessors(node.steps, ctx)
+	}
+
+	type ret struct {
+		index int
+		err   error
+	}
+	children := make([]*Context, len(node.race))
+	ch := make(chan ret, len(node.race))
+	for i, p := range node.race {
+		children[i] = newChildContext(ctx)
+		go func(i int, p *Processors) {
+			ch <- ret{index: i, err: runProcessors(p, children[i])}
+		}(i, p)
+	}
+
+	winner := -1
+	errs := []string{}
+	for range node.race {
+		r := <-ch
+		if r.err == nil && winner < 0 {
+			winner = r.index
+			for i, child := range children {
+				if i != winner {
+					child.CancelTask()
+				}
+			}
+			continue
+		}
+		if r.err != nil {
+			errs = append(errs, fmt.Sprintf("%v: %v", node.race[r.index].String(), r.err))
+		}
+	}
+	for _, child := range children {
+		child.CancelTask()
+	}
+	if winner >= 0 {
+		mergeChildContext(ctx, children[winner])
+		return node.race[winner].String(), nil
+	}
+	sort.Strings(errs)
+	return "", errors.Errorf("all the race processors failed, %v", strings.Join(errs, "; "))
+}
+
+// newChildContext returns a cancellable copy of ctx for an attempt or a race branch, the changes are taken over
+// by mergeChildContext only if the child succeeded
+func newChildContext(ctx *Context) *Context {
+	child := &Context{ParentContext: ctx, Config: ctx.Config, resourceGroup: ctx.resourceGroup}
+	child.Context, child.cancelFunc = context.WithCancel(ctx.Context)
+	child.Data = ctx.CloneData()
+	child.inherited = ctx.CloneData()
+	child.processErrs = []error{}
+	child.runningState = STARTED
+	return child
+}
+
+// mergeChildContext takes over the parameters changed by the child, the flow and the errors of the child
+func mergeChildContext(ctx, child *Context) {
+	for k, v := range child.CloneData() {
+		if old, ok := child.inherited[k]; ok && reflect.DeepEqual(old, v) {
+			continue
+		}
+		ctx.Set(param.ParaKey(k), v)
+	}
+	history := child.GetFlowProcess()
+	errs := child.Errors()
+	ctx.stateLock.Lock()
+	ctx.processHistory = append(ctx.processHistory, history...)
+	ctx.processErrs = append(ctx.processErrs, errs...)
+	ctx.stateLock.Unlock()
+}
+
+// runProcessors converts panics to errors, so that they can be retried
+func runProcessors(p *Processors, ctx *Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var v string
+			switch r.(type) {
+			case error:
+				v = r.(error).Error()
+			case runtime.Error:
+				v = r.(runtime.Error).Error()
+			case string:
+				v = r.(string)
+			default:
+				v = fmt.Sprint(r)
+			}
+			if global.Env().IsDebug {
+				log.Error(v)
+			}
+			err = errors.New(v)
+		}
+	}()
+	return p.Process(ctx)
+}
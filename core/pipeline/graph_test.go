@@ -0,0 +1,240 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/param"
+)
+
+type graphTestProcessor struct {
+	Key       string `config:"key"`
+	Value     string `config:"value"`
+	FailTimes int    `config:"fail_times"`
+	SleepInMs int    `config:"sleep_in_ms"`
+}
+
+var graphTestAttempts = sync.Map{}
+
+// number of the processors still running, the abandoned attempts and race losers must have returned
+var graphTestRunning int32
+
+func (p *graphTestProcessor) Name() string {
+	return "graph_test"
+}
+
+func (p *graphTestProcessor) Process(ctx *Context) error {
+	atomic.AddInt32(&graphTestRunning, 1)
+	defer atomic.AddInt32(&graphTestRunning, -1)
+	if p.SleepInMs > 0 {
+		select {
+		case <-time.After(time.Duration(p.SleepInMs) * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	v, _ := graphTestAttempts.LoadOrStore(p.Key, new(int))
+	attempts := v.(*int)
+	*attempts++
+	if *attempts <= p.FailTimes {
+		return errors.Errorf("%v failed", p.Key)
+	}
+	ctx.Set(param.ParaKey(p.Key), p.Value)
+	return nil
+}
+
+func init() {
+	RegisterProcessorPlugin("graph_test", func(c *config.Config) (Processor, error) {
+		p := graphTestProcessor{}
+		if err := c.Unpack(&p); err != nil {
+			return nil, err
+		}
+		return &p, nil
+	})
+}
+
+func parseGraph(t *testing.T, yml string) *GraphConfig {
+	cfg, err := config.NewConfigWithYAML([]byte(yml), "test")
+	assert.NoError(t, err)
+	graph := &GraphConfig{}
+	assert.NoError(t, cfg.Unpack(graph))
+	return graph
+}
+
+func nodeStates(ctx *Context) map[string]NodeState {
+	result := map[string]NodeState{}
+	for _, v := range ctx.GetNodeStates() {
+		result[v.Name] = v
+	}
+	return result
+}
+
+func TestValidateGraph(t *testing.T) {
+	graph := parseGraph(t, `
+nodes:
+  - name: a
+  - name: b
+  - name: c
+edges:
+  - {from: a, to: b}
+  - {from: b, to: c}
+  - {from: c, to: b}
+`)
+	err := ValidateGraph(graph)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "b -> c -> b")
+
+	graph = parseGraph(t, `
+nodes:
+  - name: a
+edges:
+  - {from: a, to: x}
+`)
+	assert.Error(t, ValidateGraph(graph))
+
+	graph = parseGraph(t, `
+nodes:
+  - name: a
+  - name: a
+`)
+	assert.Error(t, ValidateGraph(graph))
+
+	graph = parseGraph(t, `
+nodes:
+  - name: a
+    on_failure: retry
+`)
+	assert.Error(t, ValidateGraph(graph))
+}
+
+func TestGraphProcess(t *testing.T) {
+	graph, err := NewGraph(parseGraph(t, `
+nodes:
+  - name: start
+    processor:
+      - graph_test: {key: route, value: left}
+  - name: left
+    processor:
+      - graph_test: {key: left, value: "1"}
+  - name: right
+    processor:
+      - graph_test: {key: right, value: "1"}
+  - name: flaky
+    max_retries: 2
+    processor:
+      - graph_test: {key: flaky, value: "1", fail_times: 2}
+  - name: race
+    race:
+      - graph_test: {key: slow, value: "slow", sleep_in_ms: 200}
+      - graph_test: {key: fast, value: "fast"}
+  - name: merge
+    processor:
+      - graph_test: {key: merge, value: "1"}
+edges:
+  - from: start
+    to: left
+    when:
+      equals:
+        route: left
+  - from: start
+    to: right
+    when:
+      equals:
+        route: right
+  - {from: start, to: flaky}
+  - {from: start, to: race}
+  - {from: left, to: merge}
+  - {from: right, to: merge}
+  - {from: flaky, to: merge}
+  - {from: race, to: merge}
+`))
+	assert.NoError(t, err)
+
+	ctx := AcquireContext(PipelineConfigV2{Name: "test"})
+	ctx.Started()
+	assert.NoError(t, graph.Process(ctx))
+
+	states := nodeStates(ctx)
+	assert.Equal(t, NodeSucceeded, states["left"].State)
+	assert.Equal(t, NodeSkipped, states["right"].State)
+	assert.Equal(t, NodeSucceeded, states["flaky"].State)
+	assert.Equal(t, 3, states["flaky"].Attempts)
+	assert.Equal(t, NodeSucceeded, states["race"].State)
+	assert.Contains(t, states["race"].Winner, "graph_test")
+	assert.Equal(t, NodeSucceeded, states["merge"].State)
+	assert.True(t, ctx.Has("merge"))
+	assert.False(t, ctx.Has("right"))
+	//the loser is canceled and its changes are dropped
+	assert.True(t, ctx.Has("fast"))
+	assert.False(t, ctx.Has("slow"))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&graphTestRunning))
+}
+
+func TestGraphFailurePolicy(t *testing.T) {
+	graph, err := NewGraph(parseGraph(t, `
+nodes:
+  - name: a
+    on_failure: skip
+    processor:
+      - graph_test: {key: policy_a, fail_times: 10}
+  - name: b
+    processor:
+      - graph_test: {key: policy_b, value: "1"}
+  - name: c
+    timeout_in_ms: 50
+    max_retries: 1
+    processor:
+      - graph_test: {key: policy_c, value: "1", sleep_in_ms: 500}
+  - name: d
+    processor:
+      - graph_test: {key: policy_d, value: "1"}
+edges:
+  - {from: a, to: b}
+  - {from: c, to: d}
+`))
+	assert.NoError(t, err)
+
+	ctx := AcquireContext(PipelineConfigV2{Name: "test"})
+	ctx.Started()
+	err = graph.Process(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "node [c] failed")
+
+	states := nodeStates(ctx)
+	assert.Equal(t, NodeFailed, states["a"].State)
+	assert.Equal(t, NodeSkipped, states["b"].State)
+	assert.Equal(t, NodeFailed, states["c"].State)
+	assert.Equal(t, 2, states["c"].Attempts)
+	assert.Equal(t, NodeCanceled, states["d"].State)
+	//the timed out attempts are canceled and waited before retrying
+	assert.False(t, ctx.Has("policy_c"))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&graphTestRunning))
+}
@@ -141,6 +141,23 @@ func NewPipeline(cfg []*config.Config) (*Processors, error) {
 	return procs, nil
 }
 
+// NewPipelineWithConfig creates the processors of the pipeline, which is declared either as a list of processors or as a graph
+func NewPipelineWithConfig(cfg PipelineConfigV2) (*Processors, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Graph == nil {
+		return NewPipeline(cfg.Processors)
+	}
+	graph, err := NewGraph(cfg.Graph)
+	if err != nil {
+		return nil, err
+	}
+	procs := NewPipelineList()
+	procs.AddProcessor(graph)
+	return procs, nil
+}
+
 func (procs *Processors) AddProcessor(p Processor) {
 	p1, ok := p.(Processor)
 	if !ok {
@@ -240,7 +257,18 @@ func (procs *Processors) Process(ctx *Context) error {
 		log.Trace("pipeline: ",ctx.Config.Name,", start processing:",ctx.processHistory,"->",p.Name())
 
 		ctx.AddFlowProcess(p.Name())
-		err := p.Process(ctx)
+		var err error
+		if _, ok := p.(processorContainer); ok || !ctx.metering.CompareAndSwap(false, true) {
+			//only the leaf processors are metered, or the processor running others as a whole
+			err = p.Process(ctx)
+		} else {
+			err = func() error {
+				defer ctx.metering.Store(false)
+				return ctx.resourceGroup.Run(ctx, func() error {
+					return p.Process(ctx)
+				})
+			}()
+		}
 		//event, err = p.Filter(filterCfg,ctx)
 		if err != nil {
 			log.Error("error on processing:", p.Name(), ",", err)
@@ -254,6 +282,13 @@ func (procs *Processors) Process(ctx *Context) error {
 	return nil
 }
 
+// processorContainer is implemented by the processors which run the other processors of the pipeline
+type processorContainer interface {
+	containsProcessors()
+}
+
+func (procs *Processors) containsProcessors() {}
+
 func (procs *Processors) Name() string {
 	return "filters"
 }
@@ -28,6 +28,7 @@ import (
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/util"
+	"infini.sh/framework/core/util/jsonschema"
 	"strings"
 	"sync"
 )
@@ -319,6 +320,77 @@ func ExtractFilterMetadata(filter interface{})map[string]FilterProperty  {
 
 func RegisterFilterConfigMetadata(name string,filter interface{})  {
 	filterMetadata[name]=  ExtractFilterMetadata(filter)
+	filterConfigs[name] = filter
+}
+
+var processorConfigs = map[string]interface{}{}
+var filterConfigs = map[string]interface{}{}
+
+// RegisterProcessorConfigMetadata registers the config struct of the processor, the field values are used as defaults
+func RegisterProcessorConfigMetadata(name string, cfg interface{}) {
+	processorConfigs[name] = cfg
+}
+
+func RegisterProcessorPluginWithConfigMetadata(name string, constructor ProcessorConstructor, cfg interface{}) {
+	RegisterProcessorPlugin(name, constructor)
+	RegisterProcessorConfigMetadata(name, cfg)
+}
+
+// GetProcessorConfigSchemas returns the json schema of the registered processor configs
+func GetProcessorConfigSchemas() map[string]*jsonschema.Schema {
+	result := map[string]*jsonschema.Schema{}
+	for name := range registry.processorReg {
+		if v, ok := processorConfigs[name]; ok {
+			result[name] = config.ReflectSchema(v)
+		} else {
+			result[name] = &jsonschema.Schema{Type: "object"}
+		}
+	}
+	return result
+}
+
+// GetFilterConfigSchemas returns the json schema of the registered filter configs
+func GetFilterConfigSchemas() map[string]*jsonschema.Schema {
+	result := map[string]*jsonschema.Schema{}
+	for name := range registry.filterReg {
+		if v, ok := filterConfigs[name]; ok {
+			result[name] = config.ReflectSchema(v)
+		} else {
+			result[name] = &jsonschema.Schema{Type: "object"}
+		}
+	}
+	return result
+}
+
+// ValidateProcessorConfigs checks the processor configs with the registered schemas, unknown processors and keys are rejected
+func ValidateProcessorConfigs(cfgs []*config.Config) error {
+	errs := []string{}
+	for i, procConfig := range cfgs {
+		if procConfig == nil || procConfig.HasField("if") {
+			continue
+		}
+		for _, name := range procConfig.GetFields() {
+			if _, ok := registry.processorReg[name]; !ok {
+				errs = append(errs, fmt.Sprintf("processor[%d]: unknown processor [%v]", i, name))
+				continue
+			}
+			schema, ok := processorConfigs[name]
+			if !ok {
+				continue
+			}
+			child, err := procConfig.Child(name, -1)
+			if err != nil {
+				continue
+			}
+			if err := config.ValidateWithSchema(schema, child); err != nil {
+				errs = append(errs, fmt.Sprintf("processor[%d].%v: %v", i, name, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 func RegisterFilterPluginWithConfigMetadata(name string, constructor FilterConstructor,filter interface{}) {
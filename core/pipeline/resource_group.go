@@ -0,0 +1,588 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/ryanuber/go-glob"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// ResourceGroupConfig configures a named budget shared by the pipelines assigned to it, a pipeline joins the
+// group named by its `resource_group` setting, otherwise the first group whose pipeline patterns or labels match
+type ResourceGroupConfig struct {
+	Name string `config:"name" json:"name"`
+	//pipeline names assigned to this group, wildcard supported, eg: bulk_indexing_*
+	Pipelines []string `config:"pipelines" json:"pipelines,omitempty"`
+	//pipelines carrying all these labels are assigned to this group
+	Labels map[string]string `config:"labels" json:"labels,omitempty"`
+	//max concurrent workers, counts the pipeline runs and the workers they submit, a run lends its slot to
+	//one of its workers, 0 means unlimited
+	MaxWorkers int `config:"max_workers" json:"max_workers,omitempty"`
+	//max bytes in flight, eg: 100mb, empty means unlimited
+	MaxInFlightBytes string `config:"max_in_flight_bytes" json:"max_in_flight_bytes,omitempty"`
+	//share of GOMAXPROCS the processors may spend processing within each period, eg: 0.5, 0 means unlimited,
+	//it is the cpu time of the processors on linux, the goroutines they start are not counted, and the
+	//wall-clock time on the other platforms, see ResourceGroup.Run
+	ProcessingShare  float64 `config:"processing_share" json:"processing_share,omitempty"`
+	ProcessingPeriod string  `config:"processing_period" json:"processing_period,omitempty"`
+	//max requests waiting for each budget, the others are rejected right away
+	MaxQueueSize int `config:"max_queue_size" json:"max_queue_size,omitempty"`
+	//max time a request waits for the budgets before it is rejected
+	QueueTimeout string `config:"queue_timeout" json:"queue_timeout,omitempty"`
+}
+
+var ErrResourceExhausted = errors.New("resource group exhausted")
+
+type resourceWaiter struct {
+	workers int
+	bytes   int64
+	ready   chan struct{}
+}
+
+// ResourceGroup admits workers and in-flight bytes within the configured budgets, requests which don't fit are
+// queued in arrival order, every budget has its own queue so that a request waiting for bytes never holds back
+// the workers and vice versa, a nil group is unlimited
+type ResourceGroup struct {
+	config           ResourceGroupConfig
+	maxBytes         int64
+	maxQueue         int
+	queueTimeout     time.Duration
+	processingPeriod time.Duration
+	processingQuota  time.Duration
+
+	lock            sync.Mutex
+	workers         int
+	bytes           int64
+	workerWaiters   []*resourceWaiter
+	byteWaiters     []*resourceWaiter
+	processingUsed  time.Duration
+	processingStart time.Time
+
+	admitted  int64
+	queued    int64
+	rejected  int64
+	throttled int64
+}
+
+func NewResourceGroup(cfg ResourceGroupConfig) (*ResourceGroup, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("resource group name can't be empty")
+	}
+	if cfg.MaxWorkers < 0 || cfg.MaxQueueSize < 0 || cfg.ProcessingShare < 0 {
+		return nil, errors.Errorf("resource group [%v]: budgets can't be negative", cfg.Name)
+	}
+	g := &ResourceGroup{
+		config:           cfg,
+		maxQueue:         1000,
+		queueTimeout:     30 * time.Second,
+		processingPeriod: 100 * time.Millisecond,
+		processingStart:  time.Now(),
+	}
+	if cfg.MaxQueueSize > 0 {
+		g.maxQueue = cfg.MaxQueueSize
+	}
+	if cfg.MaxInFlightBytes != "" {
+		v, err := util.ToBytes(cfg.MaxInFlightBytes)
+		if err != nil {
+			return nil, errors.Errorf("resource group [%v]: invalid max_in_flight_bytes: %v", cfg.Name, err)
+		}
+		g.maxBytes = int64(v)
+	}
+	if cfg.QueueTimeout != "" {
+		v, err := time.ParseDuration(cfg.QueueTimeout)
+		if err != nil {
+			return nil, errors.Errorf("resource group [%v]: invalid queue_timeout: %v", cfg.Name, err)
+		}
+		g.queueTimeout = v
+	}
+	if cfg.ProcessingPeriod != "" {
+		v, err := time.ParseDuration(cfg.ProcessingPeriod)
+		if err != nil || v <= 0 {
+			return nil, errors.Errorf("resource group [%v]: invalid processing_period [%v]", cfg.Name, cfg.ProcessingPeriod)
+		}
+		g.processingPeriod = v
+	}
+	if cfg.ProcessingShare > 0 {
+		g.processingQuota = time.Duration(cfg.ProcessingShare * float64(runtime.GOMAXPROCS(0)) * float64(g.processingPeriod))
+	}
+	return g, nil
+}
+
+func (g *ResourceGroup) Name() string {
+	if g == nil {
+		return ""
+	}
+	return g.config.Name
+}
+
+// Matches tells whether the pipeline belongs to this group by its name or labels
+func (g *ResourceGroup) Matches(cfg PipelineConfigV2) bool {
+	for _, pattern := range g.config.Pipelines {
+		if glob.Glob(pattern, cfg.Name) {
+			return true
+		}
+	}
+	if len(g.config.Labels) == 0 {
+		return false
+	}
+	for k, v := range g.config.Labels {
+		label, ok := cfg.Labels[k]
+		if !ok || fmt.Sprint(label) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// AcquireWorker takes a worker slot, waits in the queue when the group is full,
+// the returned release func must be called once the work is done, see Context.AcquireWorker for the workers
+// submitted by a pipeline
+func (g *ResourceGroup) AcquireWorker(ctx context.Context) (func(), error) {
+	return g.acquire(ctx, 1, 0)
+}
+
+// AcquireBytes reserves n bytes of the in-flight budget, a request larger than the budget is admitted
+// only when nothing else is in flight, so it doesn't wait forever
+func (g *ResourceGroup) AcquireBytes(ctx context.Context, n int) (func(), error) {
+	return g.acquire(ctx, 0, int64(n))
+}
+
+func (g *ResourceGroup) acquire(ctx context.Context, workers int, bytes int64) (func(), error) {
+	if g == nil || (g.config.MaxWorkers == 0 && g.maxBytes == 0) {
+		return func() {}, nil
+	}
+
+	g.lock.Lock()
+	waiters := g.waiters(workers)
+	if len(*waiters) == 0 && g.fits(workers, bytes) {
+		g.take(workers, bytes)
+		g.lock.Unlock()
+		return g.releaser(workers, bytes), nil
+	}
+	if len(*waiters) >= g.maxQueue {
+		g.rejected++
+		g.lock.Unlock()
+		stats.Increment("resource_group."+g.config.Name, "rejected")
+		return nil, errors.Errorf("%v: [%v], queue is full", ErrResourceExhausted, g.config.Name)
+	}
+	w := &resourceWaiter{workers: workers, bytes: bytes, ready: make(chan struct{})}
+	*waiters = append(*waiters, w)
+	g.queued++
+	g.lock.Unlock()
+	stats.Increment("resource_group."+g.config.Name, "queued")
+
+	timer := time.NewTimer(g.queueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+		return g.releaser(workers, bytes), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = errors.Errorf("%v: [%v], timeout after %v", ErrResourceExhausted, g.config.Name, g.queueTimeout)
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	select {
+	case <-w.ready:
+		//granted while giving up
+		return g.releaser(workers, bytes), nil
+	default:
+	}
+	for i, v := range *waiters {
+		if v == w {
+			*waiters = append((*waiters)[:i], (*waiters)[i+1:]...)
+			break
+		}
+	}
+	g.rejected++
+	stats.Increment("resource_group."+g.config.Name, "rejected")
+	//the head of the queue may have changed
+	g.dispatch()
+	return nil, err
+}
+
+// waiters returns the queue of the requested budget, must be called after holding the lock
+func (g *ResourceGroup) waiters(workers int) *[]*resourceWaiter {
+	if workers > 0 {
+		return &g.workerWaiters
+	}
+	return &g.byteWaiters
+}
+
+// fits must be called after holding the lock
+func (g *ResourceGroup) fits(workers int, bytes int64) bool {
+	if g.config.MaxWorkers > 0 && workers > 0 && g.workers+workers > g.config.MaxWorkers {
+		return false
+	}
+	if g.maxBytes > 0 && bytes > 0 && g.bytes > 0 && g.bytes+bytes > g.maxBytes {
+		return false
+	}
+	return true
+}
+
+// take must be called after holding the lock
+func (g *ResourceGroup) take(workers int, bytes int64) {
+	g.workers += workers
+	g.bytes += bytes
+	g.admitted++
+	stats.Increment("resource_group."+g.config.Name, "admitted")
+}
+
+// dispatch admits the waiting requests of every queue in order, must be called after holding the lock
+func (g *ResourceGroup) dispatch() {
+	for _, waiters := range []*[]*resourceWaiter{&g.workerWaiters, &g.byteWaiters} {
+		for len(*waiters) > 0 {
+			w := (*waiters)[0]
+			if !g.fits(w.workers, w.bytes) {
+				break
+			}
+			g.take(w.workers, w.bytes)
+			*waiters = (*waiters)[1:]
+			close(w.ready)
+		}
+	}
+}
+
+func (g *ResourceGroup) releaser(workers int, bytes int64) func() {
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			g.lock.Lock()
+			g.workers -= workers
+			g.bytes -= bytes
+			g.dispatch()
+			g.lock.Unlock()
+		})
+	}
+}
+
+// runSlot is the worker slot taken by a pipeline run, it is lent to one of the workers submitted by the run, so
+// that a run never waits for the slot it holds itself, the slot is released once the run and the borrower are done
+type runSlot struct {
+	lock    sync.Mutex
+	release func()
+	refs    int
+	lent    bool
+	ended   bool
+}
+
+func (slot *runSlot) borrow() (func(), bool) {
+	slot.lock.Lock()
+	defer slot.lock.Unlock()
+	if slot.lent || slot.ended {
+		return nil, false
+	}
+	slot.lent = true
+	slot.refs++
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			slot.lock.Lock()
+			slot.lent = false
+			slot.unref()
+			slot.lock.Unlock()
+		})
+	}, true
+}
+
+// unref must be called after holding the lock
+func (slot *runSlot) unref() {
+	slot.refs--
+	if slot.refs == 0 {
+		slot.release()
+	}
+}
+
+// AcquireRun takes the worker slot of a pipeline run, the returned func ends the run
+func (ctx *Context) AcquireRun() (func(), error) {
+	release, err := ctx.ResourceGroup().AcquireWorker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slot := &runSlot{release: release, refs: 1}
+	ctx.runSlot.Store(slot)
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			slot.lock.Lock()
+			slot.ended = true
+			slot.unref()
+			slot.lock.Unlock()
+		})
+	}, nil
+}
+
+// AcquireWorker takes a worker slot for a worker submitted by the pipeline, the slot of the run is borrowed
+// if not lent yet, otherwise a slot is acquired from the group
+func (ctx *Context) AcquireWorker() (func(), error) {
+	for c := ctx; c != nil; c = c.ParentContext {
+		if slot := c.runSlot.Load(); slot != nil {
+			if release, ok := slot.borrow(); ok {
+				return release, nil
+			}
+			break
+		}
+	}
+	return ctx.ResourceGroup().AcquireWorker(ctx)
+}
+
+// YieldProcessing blocks while the group of the pipeline runs out of the processing quota, the long running
+// processors, eg: the consumers, call it between the batches, so that they are throttled while running
+func (ctx *Context) YieldProcessing() error {
+	return ctx.ResourceGroup().WaitProcessing(ctx)
+}
+
+// WaitProcessing blocks until the group has processing quota left in the current period, the time spent
+// beyond the quota is carried over to the next period, see ChargeProcessing
+func (g *ResourceGroup) WaitProcessing(ctx context.Context) error {
+	if g == nil || g.processingQuota == 0 {
+		return nil
+	}
+	throttled := false
+	for {
+		g.lock.Lock()
+		now := time.Now()
+		g.refill(now)
+		if g.processingUsed < g.processingQuota {
+			g.lock.Unlock()
+			return nil
+		}
+		wait := g.processingStart.Add(g.processingPeriod).Sub(now)
+		if !throttled {
+			throttled = true
+			g.throttled++
+			stats.Increment("resource_group."+g.config.Name, "throttled")
+		}
+		g.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// ChargeProcessing accounts the time the processors spent on behalf of the group, the debt carried over
+// is capped at the quota of one period, so that a burst never stalls the group for longer than a period
+func (g *ResourceGroup) ChargeProcessing(d time.Duration) {
+	if g == nil || g.processingQuota == 0 {
+		return
+	}
+	g.lock.Lock()
+	g.refill(time.Now())
+	g.processingUsed += d
+	if g.processingUsed > 2*g.processingQuota {
+		g.processingUsed = 2 * g.processingQuota
+	}
+	g.lock.Unlock()
+}
+
+// refill must be called after holding the lock
+func (g *ResourceGroup) refill(now time.Time) {
+	elapsed := now.Sub(g.processingStart)
+	if elapsed < g.processingPeriod {
+		return
+	}
+	periods := elapsed / g.processingPeriod
+	g.processingUsed -= periods * g.processingQuota
+	if g.processingUsed < 0 {
+		g.processingUsed = 0
+	}
+	g.processingStart = g.processingStart.Add(periods * g.processingPeriod)
+}
+
+// Run executes f within the processing budget of the group, the time f spends is charged every period while
+// it runs, f runs locked to its thread, so that the cpu time of the thread is charged where available
+func (g *ResourceGroup) Run(ctx context.Context, f func() error) error {
+	if g == nil || g.processingQuota == 0 {
+		return f()
+	}
+	if err := g.WaitProcessing(ctx); err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	meter := newProcessingMeter()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(g.processingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				g.ChargeProcessing(meter.delta())
+			}
+		}
+	}()
+
+	defer func() {
+		close(done)
+		<-stopped
+		g.ChargeProcessing(meter.delta())
+	}()
+	return f()
+}
+
+// processingMeter reads the cpu time of the thread it is created on, or the wall-clock time if not available
+type processingMeter struct {
+	clock    func() (time.Duration, bool)
+	last     time.Duration
+	lastTime time.Time
+}
+
+func newProcessingMeter() *processingMeter {
+	m := &processingMeter{clock: threadCPUClock(), lastTime: time.Now()}
+	if m.clock != nil {
+		v, ok := m.clock()
+		if !ok {
+			m.clock = nil
+		}
+		m.last = v
+	}
+	return m
+}
+
+// delta returns the time spent since the last call, not safe for concurrent use
+func (m *processingMeter) delta() time.Duration {
+	now := time.Now()
+	d := now.Sub(m.lastTime)
+	m.lastTime = now
+	if m.clock != nil {
+		if v, ok := m.clock(); ok {
+			d = v - m.last
+			m.last = v
+		}
+	}
+	return d
+}
+
+func (g *ResourceGroup) Usage() util.MapStr {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.refill(time.Now())
+	return util.MapStr{
+		"workers":                g.workers,
+		"max_workers":            g.config.MaxWorkers,
+		"in_flight_bytes":        g.bytes,
+		"max_in_flight_bytes":    g.maxBytes,
+		"processing_in_ms":       g.processingUsed.Milliseconds(),
+		"processing_quota_in_ms": g.processingQuota.Milliseconds(),
+		"waiting":                len(g.workerWaiters) + len(g.byteWaiters),
+		"admitted":               g.admitted,
+		"queued":                 g.queued,
+		"rejected":               g.rejected,
+		"throttled":              g.throttled,
+	}
+}
+
+var resourceGroups = []*ResourceGroup{}
+var resourceGroupsLock = sync.RWMutex{}
+
+func init() {
+	stats.RegisterStats("resource_groups", func() interface{} {
+		return GetResourceGroupsUsage()
+	})
+}
+
+// SetupResourceGroups replaces the registered groups, the running contexts keep the groups they were created with
+func SetupResourceGroups(configs []ResourceGroupConfig) error {
+	groups := make([]*ResourceGroup, 0, len(configs))
+	names := map[string]bool{}
+	for _, cfg := range configs {
+		if names[cfg.Name] {
+			return errors.Errorf("duplicated resource group [%v]", cfg.Name)
+		}
+		names[cfg.Name] = true
+		g, err := NewResourceGroup(cfg)
+		if err != nil {
+			return err
+		}
+		groups = append(groups, g)
+	}
+	resourceGroupsLock.Lock()
+	resourceGroups = groups
+	resourceGroupsLock.Unlock()
+	return nil
+}
+
+func GetResourceGroup(name string) *ResourceGroup {
+	resourceGroupsLock.RLock()
+	defer resourceGroupsLock.RUnlock()
+	for _, g := range resourceGroups {
+		if g.config.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// GetResourceGroupFor returns the group the pipeline is assigned to, nil if it is not limited
+func GetResourceGroupFor(cfg PipelineConfigV2) *ResourceGroup {
+	if cfg.ResourceGroup != "" {
+		g := GetResourceGroup(cfg.ResourceGroup)
+		if g == nil {
+			log.Warnf("resource group [%v] of pipeline [%v] not found", cfg.ResourceGroup, cfg.Name)
+		}
+		return g
+	}
+	resourceGroupsLock.RLock()
+	defer resourceGroupsLock.RUnlock()
+	for _, g := range resourceGroups {
+		if g.Matches(cfg) {
+			return g
+		}
+	}
+	return nil
+}
+
+func GetResourceGroupsUsage() util.MapStr {
+	resourceGroupsLock.RLock()
+	groups := resourceGroups
+	resourceGroupsLock.RUnlock()
+
+	usage := util.MapStr{}
+	for _, g := range groups {
+		usage[g.config.Name] = g.Usage()
+	}
+	return usage
+}
@@ -0,0 +1,293 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceGroupWorkers(t *testing.T) {
+	g, err := NewResourceGroup(ResourceGroupConfig{Name: "workers", MaxWorkers: 2, MaxQueueSize: 1, QueueTimeout: "50ms"})
+	assert.NoError(t, err)
+
+	r1, err := g.AcquireWorker(context.Background())
+	assert.NoError(t, err)
+	r2, err := g.AcquireWorker(context.Background())
+	assert.NoError(t, err)
+
+	//queued until a slot is released
+	done := make(chan error)
+	go func() {
+		r, err := g.AcquireWorker(context.Background())
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	for g.Usage()["waiting"] != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	//the queue is full
+	_, err = g.AcquireWorker(context.Background())
+	assert.ErrorContains(t, err, "queue is full")
+
+	r1()
+	r1() //release is idempotent
+	assert.NoError(t, <-done)
+
+	//timeout in the queue
+	r3, err := g.AcquireWorker(context.Background())
+	assert.NoError(t, err)
+	_, err = g.AcquireWorker(context.Background())
+	assert.ErrorContains(t, err, "timeout")
+	r2()
+	r3()
+
+	usage := g.Usage()
+	assert.Equal(t, 0, usage["workers"])
+	assert.Equal(t, int64(4), usage["admitted"])
+	assert.Equal(t, int64(2), usage["queued"])
+	assert.Equal(t, int64(2), usage["rejected"])
+}
+
+func TestResourceGroupBytes(t *testing.T) {
+	g, err := NewResourceGroup(ResourceGroupConfig{Name: "bytes", MaxInFlightBytes: "1kb"})
+	assert.NoError(t, err)
+
+	r1, err := g.AcquireBytes(context.Background(), 800)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = g.AcquireBytes(ctx, 400)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	r1()
+
+	//oversized requests are admitted when nothing else is in flight
+	r2, err := g.AcquireBytes(context.Background(), 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4096), g.Usage()["in_flight_bytes"])
+	r2()
+
+	//a request which fits still queues behind the earlier waiters
+	r3, err := g.AcquireBytes(context.Background(), 500)
+	assert.NoError(t, err)
+	wg := sync.WaitGroup{}
+	for i, n := range []int{800, 100} {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			r, err := g.AcquireBytes(context.Background(), n)
+			if assert.NoError(t, err) {
+				r()
+			}
+		}(n)
+		for g.Usage()["waiting"] != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	assert.Equal(t, int64(500), g.Usage()["in_flight_bytes"])
+	r3()
+	wg.Wait()
+	assert.Equal(t, int64(0), g.Usage()["in_flight_bytes"])
+}
+
+func TestResourceGroupSeparateQueues(t *testing.T) {
+	g, err := NewResourceGroup(ResourceGroupConfig{Name: "separate", MaxWorkers: 1, MaxInFlightBytes: "1kb"})
+	assert.NoError(t, err)
+
+	w1, err := g.AcquireWorker(context.Background())
+	assert.NoError(t, err)
+	b1, err := g.AcquireBytes(context.Background(), 800)
+	assert.NoError(t, err)
+
+	//a request waiting for bytes doesn't hold back the workers
+	done := make(chan error)
+	go func() {
+		r, err := g.AcquireBytes(context.Background(), 400)
+		if err == nil {
+			r()
+		}
+		done <- err
+	}()
+	for g.Usage()["waiting"] != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	w1()
+	w2, err := g.AcquireWorker(context.Background())
+	assert.NoError(t, err)
+	w2()
+
+	b1()
+	assert.NoError(t, <-done)
+}
+
+func TestContextRunSlot(t *testing.T) {
+	defer SetupResourceGroups(nil)
+	assert.NoError(t, SetupResourceGroups([]ResourceGroupConfig{{Name: "slots", Pipelines: []string{"slots"}, MaxWorkers: 2, QueueTimeout: "20ms"}}))
+	ctx := AcquireContext(PipelineConfigV2{Name: "slots"})
+	ctx.ResetContext()
+	g := ctx.ResourceGroup()
+
+	run, err := ctx.AcquireRun()
+	assert.NoError(t, err)
+	//the first worker borrows the slot of the run
+	w1, err := ctx.AcquireWorker()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, g.Usage()["workers"])
+	w2, err := ctx.AcquireWorker()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, g.Usage()["workers"])
+	_, err = ctx.AcquireWorker()
+	assert.ErrorContains(t, err, "timeout")
+
+	//the slot is kept until the borrower is done
+	run()
+	assert.Equal(t, 2, g.Usage()["workers"])
+	w1()
+	w1()
+	w2()
+	assert.Equal(t, 0, g.Usage()["workers"])
+}
+
+func TestResourceGroupProcessing(t *testing.T) {
+	g, err := NewResourceGroup(ResourceGroupConfig{Name: "processing", ProcessingShare: 0.0001, ProcessingPeriod: "20ms"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, g.Run(context.Background(), func() error {
+		//the cpu time is charged
+		for start := time.Now(); time.Since(start) < 5*time.Millisecond; {
+		}
+		return nil
+	}))
+	//the quota of the period is used up
+	start := time.Now()
+	assert.NoError(t, g.WaitProcessing(context.Background()))
+	assert.True(t, time.Since(start) >= 10*time.Millisecond)
+	assert.Equal(t, int64(1), g.Usage()["throttled"])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.ChargeProcessing(time.Second)
+	assert.Equal(t, context.Canceled, g.WaitProcessing(ctx))
+	//the debt is capped at one period
+	assert.Equal(t, 2*g.processingQuota.Milliseconds(), g.Usage()["processing_in_ms"])
+
+	//a nil group is unlimited
+	var none *ResourceGroup
+	release, err := none.AcquireWorker(context.Background())
+	assert.NoError(t, err)
+	release()
+	assert.NoError(t, none.WaitProcessing(context.Background()))
+}
+
+func TestResourceGroupMetering(t *testing.T) {
+	g, err := NewResourceGroup(ResourceGroupConfig{Name: "metering", ProcessingShare: 1000, ProcessingPeriod: "10ms"})
+	assert.NoError(t, err)
+
+	//charged while running
+	charged := false
+	assert.NoError(t, g.Run(context.Background(), func() error {
+		for start := time.Now(); !charged && time.Since(start) < 5*time.Second; {
+			charged = g.Usage()["processing_in_ms"].(int64) > 0
+		}
+		return nil
+	}))
+	assert.True(t, charged)
+
+	//only the leaf processors are metered, the lists are not charged again
+	ctx := AcquireContext(PipelineConfigV2{Name: "metering"})
+	ctx.resourceGroup = g
+	ctx.Started()
+	leaf := &meteredProcessor{}
+	inner := NewPipelineList()
+	inner.AddProcessor(leaf)
+	container := &meteredContainer{procs: inner}
+	outer := NewPipelineList()
+	outer.AddProcessor(container)
+	assert.NoError(t, outer.Process(ctx))
+	assert.False(t, container.metered)
+	assert.True(t, leaf.metered)
+	assert.False(t, ctx.metering.Load())
+}
+
+type meteredProcessor struct {
+	metered bool
+}
+
+func (p *meteredProcessor) Name() string {
+	return "metered"
+}
+
+func (p *meteredProcessor) Process(ctx *Context) error {
+	p.metered = ctx.metering.Load()
+	return nil
+}
+
+type meteredContainer struct {
+	procs   *Processors
+	metered bool
+}
+
+func (p *meteredContainer) Name() string {
+	return "container"
+}
+
+func (p *meteredContainer) Process(ctx *Context) error {
+	p.metered = ctx.metering.Load()
+	return p.procs.Process(ctx)
+}
+
+func (p *meteredContainer) containsProcessors() {}
+
+func TestResourceGroupAssignment(t *testing.T) {
+	defer SetupResourceGroups(nil)
+
+	err := SetupResourceGroups([]ResourceGroupConfig{{Name: "a"}, {Name: "a"}})
+	assert.ErrorContains(t, err, "duplicated")
+	_, err = NewResourceGroup(ResourceGroupConfig{Name: "b", MaxInFlightBytes: "lots"})
+	assert.Error(t, err)
+
+	assert.NoError(t, SetupResourceGroups([]ResourceGroupConfig{
+		{Name: "bulk", Pipelines: []string{"bulk_indexing_*"}},
+		{Name: "tenant", Labels: map[string]string{"tenant": "x", "tier": "1"}},
+		{Name: "explicit"},
+	}))
+
+	assert.Equal(t, "bulk", GetResourceGroupFor(PipelineConfigV2{Name: "bulk_indexing_logs"}).Name())
+	assert.Equal(t, "tenant", GetResourceGroupFor(PipelineConfigV2{Name: "p1", Labels: map[string]interface{}{"tenant": "x", "tier": 1}}).Name())
+	assert.Nil(t, GetResourceGroupFor(PipelineConfigV2{Name: "p2", Labels: map[string]interface{}{"tenant": "x"}}))
+	assert.Equal(t, "explicit", GetResourceGroupFor(PipelineConfigV2{Name: "bulk_indexing_logs", ResourceGroup: "explicit"}).Name())
+	assert.Nil(t, GetResourceGroupFor(PipelineConfigV2{Name: "p3", ResourceGroup: "missing"}))
+
+	ctx := AcquireContext(PipelineConfigV2{Name: "bulk_indexing_metrics"})
+	assert.Equal(t, "bulk", ctx.ResourceGroup().Name())
+	assert.Contains(t, GetResourceGroupsUsage(), "tenant")
+}
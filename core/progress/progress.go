@@ -28,6 +28,7 @@ import (
 	"sync"
 
 	"gopkg.in/cheggaaa/pb.v1"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 )
 
@@ -112,11 +113,17 @@ func Start() {
 	}
 }
 
+type progressConfig struct {
+	Enabled bool `config:"enabled" json:"enabled,omitempty"`
+}
+
+func init() {
+	config.RegisterSectionSchema("progress_bar", progressConfig{})
+}
+
 func ShowProgress() bool {
 
-	cfg := struct {
-		Enabled bool `config:"enabled" json:"enabled,omitempty"`
-	}{}
+	cfg := progressConfig{}
 
 	exists, _ := env.ParseConfig("progress_bar", &cfg)
 	if exists {
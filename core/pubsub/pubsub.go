@@ -0,0 +1,384 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+// Package pubsub is a topic based publish/subscribe hub, events are delivered to the
+// subscribers through websocket or server-sent events, see package api
+package pubsub
+
+import (
+	"errors"
+	"infini.sh/framework/core/radix"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event is the typed message delivered to subscribers
+type Event struct {
+	ID        uint64      `json:"id"`
+	Topic     string      `json:"topic"`
+	Type      string      `json:"type,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// Topic is the registered topic, topics not registered are still publishable
+type Topic struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	//permission required to subscribe the topic
+	Permission string `json:"permission,omitempty"`
+}
+
+// what to do when the buffer of a subscriber is full
+const (
+	DropOldest = "drop_oldest"
+	DropNewest = "drop_newest"
+	Disconnect = "disconnect"
+)
+
+const defaultBufferSize = 256
+
+var ErrUnauthorized = errors.New("unauthorized topic")
+
+// Authorizer check if the principal has the permission, principal may be empty for anonymous clients
+type Authorizer func(principal string, permission string) bool
+
+type SubscriberOptions struct {
+	Principal  string
+	BufferSize int
+	//DropOldest, DropNewest or Disconnect
+	OverflowPolicy string
+}
+
+// Subscriber receives the events of the subscribed topics from C
+type Subscriber struct {
+	id        string
+	principal string
+	policy    string
+	c         chan *Event
+
+	lock     sync.Mutex
+	topics   []string
+	pattern  *radix.Pattern
+	allowed  map[string]bool
+	closed   bool
+	dropped  int64
+	received int64
+	done     chan struct{}
+}
+
+type hub struct {
+	lock        sync.RWMutex
+	topics      map[string]*Topic
+	subscribers map[string]*Subscriber
+	authorizer  Authorizer
+	seq         uint64
+	count       int32
+}
+
+var h = &hub{
+	topics:      map[string]*Topic{},
+	subscribers: map[string]*Subscriber{},
+}
+
+// RegisterTopic declare a topic, permission is optional
+func RegisterTopic(name, description, permission string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.topics[name] = &Topic{Name: name, Description: description, Permission: permission}
+}
+
+// GetTopics return all registered topics
+func GetTopics() []Topic {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	topics := make([]Topic, 0, len(h.topics))
+	for _, v := range h.topics {
+		topics = append(topics, *v)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Name < topics[j].Name
+	})
+	return topics
+}
+
+// SetAuthorizer set the func to authorize subscriptions, all topics are allowed by default,
+// the api wires it to security.HasPermission on start
+func SetAuthorizer(f Authorizer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.authorizer = f
+}
+
+func authorize(principal, topic string) bool {
+	h.lock.RLock()
+	t, ok := h.topics[topic]
+	f := h.authorizer
+	h.lock.RUnlock()
+	if !ok || t.Permission == "" || f == nil {
+		return true
+	}
+	return f(principal, t.Permission)
+}
+
+// Subscribe create a subscriber for the topics, wildcard is supported, eg: queue.*
+// the subscriber must be closed by the caller
+func Subscribe(options SubscriberOptions, topics ...string) (*Subscriber, error) {
+	s := &Subscriber{
+		id:        util.GetUUID(),
+		principal: options.Principal,
+		policy:    options.OverflowPolicy,
+		allowed:   map[string]bool{},
+		done:      make(chan struct{}),
+	}
+	if s.policy == "" {
+		s.policy = DropOldest
+	}
+	size := options.BufferSize
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	s.c = make(chan *Event, size)
+
+	if err := s.Subscribe(topics...); err != nil {
+		return nil, err
+	}
+
+	h.lock.Lock()
+	h.subscribers[s.id] = s
+	atomic.StoreInt32(&h.count, int32(len(h.subscribers)))
+	h.lock.Unlock()
+	stats.Increment("pubsub", "subscribed")
+	return s, nil
+}
+
+// HasSubscribers return true if anyone is listening the topic, publishers may skip preparing the event
+func HasSubscribers(topic string) bool {
+	for _, s := range getSubscribers() {
+		if s.matches(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeSubscriber(id string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.subscribers, id)
+	atomic.StoreInt32(&h.count, int32(len(h.subscribers)))
+}
+
+func getSubscribers() []*Subscriber {
+	if atomic.LoadInt32(&h.count) == 0 {
+		return nil
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	subscribers := make([]*Subscriber, 0, len(h.subscribers))
+	for _, s := range h.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	return subscribers
+}
+
+// Publish send the event to all subscribers of the topic, never blocks the publisher
+func Publish(topic, eventType string, data interface{}) {
+	targets := []*Subscriber{}
+	for _, s := range getSubscribers() {
+		if s.matches(topic) {
+			targets = append(targets, s)
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+
+	e := &Event{
+		ID:        atomic.AddUint64(&h.seq, 1),
+		Topic:     topic,
+		Type:      eventType,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+	for _, s := range targets {
+		if !s.deliver(e) {
+			//slow subscriber was disconnected
+			removeSubscriber(s.id)
+		}
+	}
+	stats.Increment("pubsub", "published")
+}
+
+func (s *Subscriber) ID() string {
+	return s.id
+}
+
+// C return the channel of events, it is closed when the subscriber is closed
+func (s *Subscriber) C() <-chan *Event {
+	return s.c
+}
+
+// Done is closed when the subscriber is closed
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscriber) Topics() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.topics...)
+}
+
+func (s *Subscriber) Stats() (received, dropped int64) {
+	return atomic.LoadInt64(&s.received), atomic.LoadInt64(&s.dropped)
+}
+
+// Subscribe add more topics to the subscriber
+func (s *Subscriber) Subscribe(topics ...string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	//validate all the topics first, the subscriber is left untouched if any of them is unauthorized
+	added := []string{}
+	for _, t := range topics {
+		t = strings.TrimSpace(t)
+		if t == "" || util.StringInArray(s.topics, t) || util.StringInArray(added, t) {
+			continue
+		}
+		//check the permission of explicit topics early
+		if !strings.Contains(t, "*") && !authorize(s.principal, t) {
+			return ErrUnauthorized
+		}
+		added = append(added, t)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	s.topics = append(s.topics, added...)
+	s.compile()
+	return nil
+}
+
+func (s *Subscriber) Unsubscribe(topics ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	left := []string{}
+	for _, t := range s.topics {
+		if !util.StringInArray(topics, t) {
+			left = append(left, t)
+		}
+	}
+	s.topics = left
+	s.compile()
+}
+
+// compile must be called after holding the lock
+func (s *Subscriber) compile() {
+	if len(s.topics) == 0 {
+		s.pattern = nil
+		return
+	}
+	s.pattern = radix.Compile(s.topics...)
+}
+
+func (s *Subscriber) matches(topic string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed || s.pattern == nil || !s.pattern.Match(topic) {
+		return false
+	}
+	allowed, ok := s.allowed[topic]
+	if !ok {
+		allowed = authorize(s.principal, topic)
+		s.allowed[topic] = allowed
+	}
+	return allowed
+}
+
+// deliver the event without blocking, return false if the subscriber is closed
+func (s *Subscriber) deliver(e *Event) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return false
+	}
+
+	select {
+	case s.c <- e:
+		atomic.AddInt64(&s.received, 1)
+		return true
+	default:
+	}
+
+	//buffer is full, the subscriber is too slow
+	atomic.AddInt64(&s.dropped, 1)
+	stats.Increment("pubsub", "dropped")
+
+	switch s.policy {
+	case DropNewest:
+	case Disconnect:
+		s.close()
+		return false
+	default:
+		select {
+		case <-s.c:
+		default:
+		}
+		select {
+		case s.c <- e:
+			atomic.AddInt64(&s.received, 1)
+		default:
+		}
+	}
+	return true
+}
+
+// Close the subscriber and release the resources
+func (s *Subscriber) Close() {
+	removeSubscriber(s.id)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.close()
+}
+
+// close must be called after holding the lock
+func (s *Subscriber) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	close(s.c)
+	stats.Increment("pubsub", "unsubscribed")
+}
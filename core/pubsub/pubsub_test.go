@@ -0,0 +1,94 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package pubsub
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	s, err := Subscribe(SubscriberOptions{}, "queue.*")
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.True(t, HasSubscribers("queue.depth"))
+	assert.False(t, HasSubscribers("pipeline.state"))
+
+	Publish("pipeline.state", "changed", "ignored")
+	Publish("queue.depth", "updated", map[string]int{"depth": 10})
+
+	e := <-s.C()
+	assert.Equal(t, "queue.depth", e.Topic)
+	assert.Equal(t, "updated", e.Type)
+	assert.Equal(t, 0, len(s.C()))
+
+	s.Unsubscribe("queue.*")
+	assert.False(t, HasSubscribers("queue.depth"))
+}
+
+func TestSubscriberBackpressure(t *testing.T) {
+	s, _ := Subscribe(SubscriberOptions{BufferSize: 2}, "test.oldest")
+	defer s.Close()
+	for i := 0; i < 5; i++ {
+		Publish("test.oldest", "", i)
+	}
+	assert.Equal(t, 3, (<-s.C()).Data)
+	assert.Equal(t, 4, (<-s.C()).Data)
+	_, dropped := s.Stats()
+	assert.Equal(t, int64(3), dropped)
+
+	s1, _ := Subscribe(SubscriberOptions{BufferSize: 1, OverflowPolicy: Disconnect}, "test.disconnect")
+	Publish("test.disconnect", "", 1)
+	Publish("test.disconnect", "", 2)
+	<-s1.Done()
+	assert.False(t, HasSubscribers("test.disconnect"))
+}
+
+func TestTopicAuthorization(t *testing.T) {
+	RegisterTopic("test.secret", "", "secret:read")
+	SetAuthorizer(func(principal string, permission string) bool {
+		return principal == "admin"
+	})
+	defer SetAuthorizer(nil)
+
+	_, err := Subscribe(SubscriberOptions{Principal: "guest"}, "test.secret")
+	assert.Equal(t, ErrUnauthorized, err)
+
+	//wildcard subscriptions only receive authorized topics
+	s, err := Subscribe(SubscriberOptions{Principal: "guest"}, "test.*")
+	assert.Nil(t, err)
+	defer s.Close()
+	Publish("test.secret", "", 1)
+	Publish("test.public", "", 2)
+	assert.Equal(t, "test.public", (<-s.C()).Topic)
+
+	//the topics are added all or none
+	assert.Equal(t, ErrUnauthorized, s.Subscribe("test.other", "test.secret"))
+	assert.Equal(t, []string{"test.*"}, s.Topics())
+}
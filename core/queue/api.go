@@ -82,6 +82,16 @@ type ConsumerAPI interface {
 	CommitOffset(offset Offset) error
 }
 
+// TransactionalConsumerAPI is implemented by the consumers which can produce messages and commit the offsets
+// of the fetched messages in one transaction, so that a consume-produce pipeline processes each message exactly once
+type TransactionalConsumerAPI interface {
+	ConsumerAPI
+	ProduceAndCommit(reqs *[]ProduceRequest) (*[]ProduceResponse, error)
+}
+
+// ErrQueueFull is returned on push when the queue hits its capacity limits, eg: the max_used_bytes of disk_queue
+var ErrQueueFull = errors.New("queue is full")
+
 var defaultHandler QueueAPI
 
 func getSimpleHandler(k *QueueConfig) SimpleQueueAPI {
@@ -107,17 +117,26 @@ func getAdvancedHandler(k *QueueConfig) AdvancedQueueAPI {
 }
 
 func GetHandlerByType(t string) QueueAPI {
-	handler, ok := adapters[t]
-	if ok && handler != nil {
-		return handler
-	}
-	if defaultHandler == nil {
+	handler := lookupHandler(t)
+	if handler == nil {
 		panic(errors.New("no queue handler was found"))
 	}
-	return defaultHandler
+	return handler
+}
+
+func lookupHandler(t string) QueueAPI {
+	handler, ok := adapters[t]
+	if !ok || handler == nil {
+		handler = defaultHandler
+	}
+	return handler
 }
 
+// getHandler returns the handler of the queue, the operations of the scoped configs are intercepted by the scope
 func getHandler(k *QueueConfig) QueueAPI {
+	if k.scope != nil && k.scope.interceptor != nil {
+		return k.scope.interceptor(k.Type, lookupHandler(k.Type))
+	}
 	return GetHandlerByType(k.Type)
 }
 
@@ -196,7 +215,7 @@ func ReleaseConsumer(k *QueueConfig, c *ConsumerConfig,consumer ConsumerAPI) err
 
 func AcquireProducer(cfg *QueueConfig) (ProducerAPI, error) {
 
-	handler := GetHandlerByType(cfg.Type)
+	handler := getHandler(cfg)
 	if handler != nil {
 		x, ok := handler.(AdvancedQueueAPI)
 		if ok {
@@ -466,11 +485,15 @@ func GetQueues() map[string][]string {
 }
 
 func GetConfigBySelector(selector *QueueSelector) []*QueueConfig {
+	return unscoped.GetConfigBySelector(selector)
+}
+
+func (scope *Scope) GetConfigBySelector(selector *QueueSelector) []*QueueConfig {
 	cfgs := []*QueueConfig{}
 	if selector != nil {
 		if len(selector.Ids) > 0 {
 			for _, id := range selector.Ids {
-				cfg, ok := GetConfigByUUID(id)
+				cfg, ok := scope.GetConfigByUUID(id)
 				if ok {
 					cfgs = append(cfgs, cfg)
 				}
@@ -479,7 +502,7 @@ func GetConfigBySelector(selector *QueueSelector) []*QueueConfig {
 
 		if len(selector.Keys) > 0 {
 			for _, key := range selector.Keys {
-				cfg, ok := GetConfigByKey(key)
+				cfg, ok := scope.GetConfigByKey(key)
 				if ok {
 					cfgs = append(cfgs, cfg)
 				}
@@ -487,7 +510,7 @@ func GetConfigBySelector(selector *QueueSelector) []*QueueConfig {
 		}
 
 		if len(selector.Labels) > 0 {
-			cfgs1 := GetConfigByLabels(selector.Labels)
+			cfgs1 := scope.GetConfigByLabels(selector.Labels)
 			if cfgs1 != nil {
 				cfgs = append(cfgs, cfgs1...)
 			}
@@ -500,11 +523,17 @@ func GetConfigBySelector(selector *QueueSelector) []*QueueConfig {
 }
 
 func GetConfigByLabels(labels map[string]interface{}) []*QueueConfig {
+	return unscoped.GetConfigByLabels(labels)
+}
+
+// GetConfigByLabels matches the configs of the scope and the global ones, the global configs are copied into the scope
+func (scope *Scope) GetConfigByLabels(labels map[string]interface{}) []*QueueConfig {
 
 	cfgs := []*QueueConfig{}
-	configs.Range(func(key, value interface{}) bool {
+	found := map[string]bool{}
+	match := func(key, value interface{}) bool {
 		v := value.(*QueueConfig)
-		if v != nil {
+		if v != nil && !found[v.ID] {
 			matched := false
 			for x, y := range labels {
 				if v.Labels != nil {
@@ -521,11 +550,16 @@ func GetConfigByLabels(labels map[string]interface{}) []*QueueConfig {
 				}
 			}
 			if matched {
-				cfgs = append(cfgs, v)
+				found[v.ID] = true
+				cfgs = append(cfgs, scope.adopt(v))
 			}
 		}
 		return true
-	})
+	}
+	if scope != nil {
+		scope.configs.Range(match)
+	}
+	configs.Range(match)
 
 	names:=[]string{}
 	for _, cfg := range cfgs {
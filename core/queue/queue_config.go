@@ -48,6 +48,9 @@ type QueueConfig struct {
 	Created string      `config:"created" json:"created,omitempty"`
 	Labels  util.MapStr `config:"label" json:"label,omitempty"`
 	sync.RWMutex
+
+	//the scope which the config belongs to, nil for the global configs
+	scope *Scope
 }
 
 var queueConfigPool = sync.Pool{
@@ -97,6 +100,11 @@ func addCfgToCache(cfg *QueueConfig) {
 }
 
 func RegisterConfig(cfg *QueueConfig) (preExists bool, err error) {
+	return unscoped.RegisterConfig(cfg)
+}
+
+// RegisterConfig registers the config to the scope, or to the global configs if the scope is nil
+func (scope *Scope) RegisterConfig(cfg *QueueConfig) (preExists bool, err error) {
 
 	if global.Env().IsDebug{
 		log.Info("register queue config:", cfg.ID, ",", cfg.Name, ",", cfg.Labels)
@@ -120,6 +128,12 @@ func RegisterConfig(cfg *QueueConfig) (preExists bool, err error) {
 
 	log.Debug("init new queue config:", cfg.ID, ",", cfg.Name)
 
+	//scoped configs are kept apart, they are neither persisted nor notified
+	if scope != nil {
+		scope.add(cfg)
+		return false, nil
+	}
+
 	addCfgToCache(cfg)
 
 	//persist to kv	store
@@ -162,22 +176,34 @@ func IsConfigExists(key string) bool {
 }
 
 func GetOrInitConfig(key string) *QueueConfig {
+	return unscoped.GetOrInitConfig(key)
+}
+
+func (scope *Scope) GetOrInitConfig(key string) *QueueConfig {
 	if key == "" {
 		panic(errors.New("queue config key can't be empty"))
 	}
-	return AdvancedGetOrInitConfig("", key, nil)
+	return scope.AdvancedGetOrInitConfig("", key, nil)
 }
 
 func SmartGetOrInitConfig(cfg *QueueConfig) *QueueConfig {
+	return unscoped.SmartGetOrInitConfig(cfg)
+}
+
+func (scope *Scope) SmartGetOrInitConfig(cfg *QueueConfig) *QueueConfig {
 	if cfg.ID!=""{
-		v,_:=GetConfigByUUID(cfg.ID)
+		v,_:=scope.GetConfigByUUID(cfg.ID)
 		return v
 	}
-	return AdvancedGetOrInitConfig(cfg.Type,cfg.Name,cfg.Labels)
+	return scope.AdvancedGetOrInitConfig(cfg.Type,cfg.Name,cfg.Labels)
 }
 
 func AdvancedGetOrInitConfig(queueType, key string, labels map[string]interface{}) *QueueConfig {
-	cfg, exists := SmartGetConfig(key)
+	return unscoped.AdvancedGetOrInitConfig(queueType, key, labels)
+}
+
+func (scope *Scope) AdvancedGetOrInitConfig(queueType, key string, labels map[string]interface{}) *QueueConfig {
+	cfg, exists := scope.SmartGetConfig(key)
 	if !exists || cfg == nil {
 		cfg = &QueueConfig{}
 		cfg.Type = queueType
@@ -187,7 +213,7 @@ func AdvancedGetOrInitConfig(queueType, key string, labels map[string]interface{
 		if labels != nil {
 			cfg.Labels = labels
 		}
-		_, err := RegisterConfig(cfg)
+		_, err := scope.RegisterConfig(cfg)
 		if err != nil {
 			panic(err)
 		}
@@ -196,9 +222,10 @@ func AdvancedGetOrInitConfig(queueType, key string, labels map[string]interface{
 	} else {
 		//TODO: check if labels changed, then replace the config
 		if  labelChanged(labels, cfg.Labels){
+			//the configs looked up from a scope are the copies owned by the scope
 			cfg.Name = key
 			cfg.Labels = labels
-			RegisterConfig(cfg)
+			scope.RegisterConfig(cfg)
 		}
 	}
 	return cfg
@@ -223,23 +250,55 @@ func labelChanged(newLabels, oldLabels map[string]interface{}) bool {
 }
 
 func SmartGetConfig(keyOrID string) (*QueueConfig, bool) {
-	q, ok := GetConfigByKey(keyOrID)
+	return unscoped.SmartGetConfig(keyOrID)
+}
+
+func (scope *Scope) SmartGetConfig(keyOrID string) (*QueueConfig, bool) {
+	q, ok := scope.GetConfigByKey(keyOrID)
 	if !ok {
-		q, ok = GetConfigByUUID(keyOrID)
+		q, ok = scope.GetConfigByUUID(keyOrID)
 	}
 	return q, ok
 }
 
 func GetConfigByKey(key string) (*QueueConfig, bool) {
+	return unscoped.GetConfigByKey(key)
+}
+
+// GetConfigByKey looks up the config of the scope first, the global config is copied into the scope
+func (scope *Scope) GetConfigByKey(key string) (*QueueConfig, bool) {
+	if scope != nil {
+		if v, ok := scope.configs.Load(key); ok {
+			return v.(*QueueConfig), true
+		}
+	}
 	v, ok := configs.Load(key)
 	if ok {
 		cfg, ok1 := v.(*QueueConfig)
-		return cfg, ok1
+		return scope.adopt(cfg), ok1
 	}
 	return nil, false
 }
 
 func GetConfigByUUID(id string) (*QueueConfig, bool) {
+	return unscoped.GetConfigByUUID(id)
+}
+
+// GetConfigByUUID looks up the config of the scope first, the global config is copied into the scope
+func (scope *Scope) GetConfigByUUID(id string) (*QueueConfig, bool) {
+	if scope != nil {
+		if v, ok := scope.idConfigs.Load(id); ok {
+			return v.(*QueueConfig), true
+		}
+	}
+	cfg, ok := getConfigByUUID(id)
+	if ok {
+		cfg = scope.adopt(cfg)
+	}
+	return cfg, ok
+}
+
+func getConfigByUUID(id string) (*QueueConfig, bool) {
 	x, ok := idConfigs.Load(id)
 	v, ok := x.(*QueueConfig)
 
@@ -0,0 +1,101 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package queue
+
+import (
+	"context"
+	"sync"
+
+	"infini.sh/framework/core/util"
+)
+
+// HandlerInterceptor wraps the handler of the queue type, the handler is nil if not registered
+type HandlerInterceptor func(queueType string, handler QueueAPI) QueueAPI
+
+// Scope keeps the queue configs registered within it apart from the global ones, and intercepts the
+// operations on these configs, eg: for pipeline simulations, the global configs looked up from the scope
+// are copied into it, the scope is carried by the context, so that the other pipelines are not affected
+type Scope struct {
+	configs     sync.Map
+	idConfigs   sync.Map
+	interceptor HandlerInterceptor
+}
+
+// unscoped is the nil scope, which looks up and registers the global configs
+var unscoped *Scope
+
+// NewScope creates a scope, the queue operations of its configs go through the interceptor if not nil
+func NewScope(interceptor HandlerInterceptor) *Scope {
+	return &Scope{interceptor: interceptor}
+}
+
+type scopeContextKey struct{}
+
+// WithScope returns a copy of the context carrying the scope
+func WithScope(ctx context.Context, scope *Scope) context.Context {
+	return context.WithValue(ctx, scopeContextKey{}, scope)
+}
+
+// ScopeFromContext returns the scope carried by the context, nil means the global configs
+func ScopeFromContext(ctx context.Context) *Scope {
+	if ctx == nil {
+		return nil
+	}
+	scope, _ := ctx.Value(scopeContextKey{}).(*Scope)
+	return scope
+}
+
+func (scope *Scope) add(cfg *QueueConfig) {
+	cfg.scope = scope
+	scope.configs.Store(cfg.Name, cfg)
+	scope.idConfigs.Store(cfg.ID, cfg)
+}
+
+// adopt returns the copy of the global config owned by the scope, the global config is never modified
+func (scope *Scope) adopt(cfg *QueueConfig) *QueueConfig {
+	if scope == nil || cfg == nil || cfg.scope == scope {
+		return cfg
+	}
+	if v, ok := scope.idConfigs.Load(cfg.ID); ok {
+		return v.(*QueueConfig)
+	}
+	cfg.RLock()
+	c := &QueueConfig{ID: cfg.ID, Name: cfg.Name, Source: cfg.Source, Codec: cfg.Codec, Type: cfg.Type, Created: cfg.Created, scope: scope}
+	if cfg.Labels != nil {
+		c.Labels = util.MapStr{}
+		for k, v := range cfg.Labels {
+			c.Labels[k] = v
+		}
+	}
+	cfg.RUnlock()
+	v, loaded := scope.idConfigs.LoadOrStore(c.ID, c)
+	if !loaded {
+		scope.configs.Store(c.Name, c)
+	}
+	return v.(*QueueConfig)
+}
@@ -0,0 +1,74 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package queue
+
+import (
+	"infini.sh/framework/core/errors"
+)
+
+// TieredStorageAPI is implemented by the queues keeping the old segments in the object storage, eg: disk
+type TieredStorageAPI interface {
+	GetTierStats(k *QueueConfig) (*TierStats, error)
+}
+
+const (
+	//the segment is only in local, not uploaded yet
+	TierLocal = "local"
+	//the segment is uploaded and cached in local
+	TierCached = "cached"
+	//the segment is evicted from local, fetched on demand
+	TierRemote = "remote"
+)
+
+type TierStats struct {
+	MaxLocalBytes uint64 `json:"max_local_bytes,omitempty"`
+	MaxLocalAge   string `json:"max_local_age,omitempty"`
+	LocalBytes    uint64 `json:"local_bytes"`
+	Prefetch      int64  `json:"prefetch"`
+	LastUploaded  int64  `json:"last_uploaded_segment"`
+	Evicted       int64  `json:"evicted"`
+	Fetched       int64  `json:"fetched"`
+	Prefetched    int64  `json:"prefetched"`
+
+	Segments []SegmentTier `json:"segments"`
+	//only the latest segments are listed
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type SegmentTier struct {
+	Segment    int64  `json:"segment"`
+	Tier       string `json:"tier"`
+	LocalBytes int64  `json:"local_bytes,omitempty"`
+}
+
+func GetTierStats(k *QueueConfig) (*TierStats, error) {
+	if k == nil || k.ID == "" {
+		return nil, errors.New("queue name can't be nil")
+	}
+	handler := getHandler(k)
+	if h, ok := handler.(TieredStorageAPI); ok {
+		return h.GetTierStats(k)
+	}
+	return nil, errors.Errorf("queue [%v][%v] doesn't support tiered storage", k.Name, k.Type)
+}
@@ -0,0 +1,73 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package queue
+
+import (
+	"infini.sh/framework/core/errors"
+)
+
+// TopicAdminAPI is implemented by the queues backed by partitioned topics, eg: kafka
+type TopicAdminAPI interface {
+	GetPartitions(k *QueueConfig) ([]PartitionInfo, error)
+	//SetPartitions increases the partitions of the topic to total
+	SetPartitions(k *QueueConfig, total int) error
+	GetRetention(k *QueueConfig) (*RetentionConfig, error)
+	UpdateRetention(k *QueueConfig, cfg RetentionConfig) error
+	//GetConsumerLag returns the lag of each partition of the consumer group
+	GetConsumerLag(k *QueueConfig, group string) ([]PartitionLag, error)
+}
+
+type PartitionInfo struct {
+	Partition   int32   `json:"partition"`
+	Leader      int32   `json:"leader"`
+	Replicas    []int32 `json:"replicas"`
+	ISR         []int32 `json:"isr"`
+	StartOffset int64   `json:"start_offset"`
+	EndOffset   int64   `json:"end_offset"`
+}
+
+// RetentionConfig of the topic, -1 means unlimited, nil fields are left unchanged on update
+type RetentionConfig struct {
+	RetentionMs    *int64 `json:"retention_ms,omitempty"`
+	RetentionBytes *int64 `json:"retention_bytes,omitempty"`
+}
+
+type PartitionLag struct {
+	Partition int32 `json:"partition"`
+	//committed offset of the group, -1 if not committed yet
+	Committed int64 `json:"committed"`
+	EndOffset int64 `json:"end_offset"`
+	Lag       int64 `json:"lag"`
+}
+
+func GetTopicAdmin(k *QueueConfig) (TopicAdminAPI, error) {
+	if k == nil || k.ID == "" {
+		return nil, errors.New("queue name can't be nil")
+	}
+	handler := getHandler(k)
+	if h, ok := handler.(TopicAdminAPI); ok {
+		return h, nil
+	}
+	return nil, errors.Errorf("queue [%v][%v] doesn't support topic admin operations", k.Name, k.Type)
+}
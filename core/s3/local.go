@@ -0,0 +1,359 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+// LocalStore keeps the objects in the local filesystem, the buckets are the folders of the root,
+// mainly used in the tests and the single node deployments, the objects are not encrypted
+type LocalStore struct {
+	root string
+	//url prefix of the presigned urls, eg: http://localhost:2900/object_store/local
+	baseURL string
+	secret  []byte
+}
+
+const localMetaFolder = ".meta"
+const localTempFolder = ".tmp"
+
+type localMeta struct {
+	ContentType       string            `json:"content_type,omitempty"`
+	Metadata          map[string]string `json:"metadata,omitempty"`
+	ETag              string            `json:"etag,omitempty"`
+	Checksum          string            `json:"checksum,omitempty"`
+	ChecksumAlgorithm string            `json:"checksum_algorithm,omitempty"`
+	Encryption        string            `json:"encryption,omitempty"`
+}
+
+func NewLocalStore(root, baseURL, secret string) (*LocalStore, error) {
+	if root == "" {
+		return nil, errors.New("path of the local store can't be empty")
+	}
+	if err := os.MkdirAll(filepath.Join(root, localTempFolder), 0755); err != nil {
+		return nil, err
+	}
+	if secret == "" {
+		secret = util.GetUUID()
+	}
+	return &LocalStore{root: root, baseURL: strings.TrimRight(baseURL, "/"), secret: []byte(secret)}, nil
+}
+
+func (store *LocalStore) bucketPath(bucket string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || strings.HasPrefix(bucket, ".") {
+		return "", errors.Errorf("invalid bucket name [%v]", bucket)
+	}
+	return filepath.Join(store.root, bucket), nil
+}
+
+func (store *LocalStore) objectPath(bucket, object string) (string, string, error) {
+	bucketPath, err := store.bucketPath(bucket)
+	if err != nil {
+		return "", "", err
+	}
+	if object == "" || strings.HasSuffix(object, "/") || path.Clean("/"+object) != "/"+object {
+		return "", "", errors.Errorf("invalid object name [%v]", object)
+	}
+	return filepath.Join(bucketPath, filepath.FromSlash(object)),
+		filepath.Join(store.root, localMetaFolder, bucket, filepath.FromSlash(object)+".json"), nil
+}
+
+func (store *LocalStore) MakeBucket(ctx context.Context, bucket, location string) error {
+	bucketPath, err := store.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(bucketPath, 0755)
+}
+
+func (store *LocalStore) BucketExists(ctx context.Context, bucket string) (bool, error) {
+	bucketPath, err := store.bucketPath(bucket)
+	if err != nil {
+		return false, err
+	}
+	return util.FileExists(bucketPath), nil
+}
+
+func (store *LocalStore) PutObject(ctx context.Context, bucket, object string, reader io.Reader, size int64, opts PutOptions) (*ObjectInfo, error) {
+	if err := opts.Encryption.Validate(); err != nil {
+		return nil, err
+	}
+	dataPath, metaPath, err := store.objectPath(bucket, object)
+	if err != nil {
+		return nil, err
+	}
+	if ok, _ := store.BucketExists(ctx, bucket); !ok {
+		return nil, ErrBucketNotFound
+	}
+
+	etag := md5.New()
+	hashes := []io.Writer{etag}
+	var checksum hash.Hash
+	if opts.Checksum != "" {
+		if checksum, err = NewChecksum(opts.Checksum); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, checksum)
+	}
+
+	//write to the temp file first, readers never see the partial objects
+	tmp, err := ioutil.TempFile(filepath.Join(store.root, localTempFolder), "object-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(io.MultiWriter(append(hashes, tmp)...), reader)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 && written != size {
+		return nil, errors.Errorf("object [%v] size mismatch, expected %v, got %v", object, size, written)
+	}
+
+	meta := localMeta{
+		ContentType: opts.ContentType,
+		Metadata:    opts.Metadata,
+		ETag:        hex.EncodeToString(etag.Sum(nil)),
+	}
+	if checksum != nil {
+		meta.Checksum = EncodeChecksum(checksum)
+		meta.ChecksumAlgorithm = opts.Checksum
+	}
+	if opts.Encryption != nil {
+		meta.Encryption = opts.Encryption.Type
+	}
+	if err := os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(metaPath, util.MustToJSONBytes(meta), 0644); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dataPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), dataPath); err != nil {
+		return nil, err
+	}
+	return store.StatObject(ctx, bucket, object)
+}
+
+func (store *LocalStore) GetObject(ctx context.Context, bucket, object string, opts GetOptions) (io.ReadCloser, *ObjectInfo, error) {
+	info, err := store.StatObject(ctx, bucket, object)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataPath, _, _ := store.objectPath(bucket, object)
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Offset > 0 {
+		if _, err := f.Seek(opts.Offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	if opts.Length > 0 {
+		return &limitedFile{Reader: io.LimitReader(f, opts.Length), Closer: f}, info, nil
+	}
+	return f, info, nil
+}
+
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+func (store *LocalStore) StatObject(ctx context.Context, bucket, object string) (*ObjectInfo, error) {
+	dataPath, metaPath, err := store.objectPath(bucket, object)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(dataPath)
+	if err != nil || stat.IsDir() {
+		if ok, _ := store.BucketExists(ctx, bucket); !ok {
+			return nil, ErrBucketNotFound
+		}
+		return nil, ErrObjectNotFound
+	}
+	info := &ObjectInfo{Bucket: bucket, Key: object, Size: stat.Size(), LastModified: stat.ModTime()}
+	if data, err := ioutil.ReadFile(metaPath); err == nil {
+		meta := localMeta{}
+		if err := util.FromJSONBytes(data, &meta); err != nil {
+			return nil, err
+		}
+		info.ContentType = meta.ContentType
+		info.Metadata = meta.Metadata
+		info.ETag = meta.ETag
+		info.Checksum = meta.Checksum
+		info.ChecksumAlgorithm = meta.ChecksumAlgorithm
+	}
+	return info, nil
+}
+
+func (store *LocalStore) ListObjects(ctx context.Context, bucket string, opts ListOptions) ([]ObjectInfo, error) {
+	bucketPath, err := store.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !util.FileExists(bucketPath) {
+		return nil, ErrBucketNotFound
+	}
+
+	keys := []string{}
+	err = filepath.Walk(bucketPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(bucketPath, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, opts.Prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	result := []ObjectInfo{}
+	prefixes := map[string]bool{}
+	for _, key := range keys {
+		if opts.MaxKeys > 0 && len(result) >= opts.MaxKeys {
+			break
+		}
+		if !opts.Recursive {
+			if i := strings.Index(key[len(opts.Prefix):], "/"); i >= 0 {
+				prefix := key[:len(opts.Prefix)+i+1]
+				if !prefixes[prefix] {
+					prefixes[prefix] = true
+					result = append(result, ObjectInfo{Bucket: bucket, Key: prefix, IsPrefix: true})
+				}
+				continue
+			}
+		}
+		info, err := store.StatObject(ctx, bucket, key)
+		if err == ErrObjectNotFound {
+			//removed while listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *info)
+	}
+	return result, nil
+}
+
+func (store *LocalStore) RemoveObject(ctx context.Context, bucket, object string) error {
+	dataPath, metaPath, err := store.objectPath(bucket, object)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(dataPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(metaPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// PresignedURL returns the url of the base url, eg: <base_url>/<bucket>?object=<object>&expires=<unix>&signature=<hmac>
+func (store *LocalStore) PresignedURL(ctx context.Context, method, bucket, object string, expires time.Duration) (*url.URL, error) {
+	if store.baseURL == "" {
+		return nil, errors.New("base url of the local store is required by the presigned urls")
+	}
+	if method != http.MethodGet && method != http.MethodPut {
+		return nil, errors.Errorf("invalid method [%v], should be GET or PUT", method)
+	}
+	if _, _, err := store.objectPath(bucket, object); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(store.baseURL + "/" + url.PathEscape(bucket))
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(expires).Unix()
+	query := url.Values{}
+	query.Set("object", object)
+	query.Set("expires", util.Int64ToString(expiresAt))
+	query.Set("signature", store.sign(method, bucket, object, expiresAt))
+	u.RawQuery = query.Encode()
+	return u, nil
+}
+
+// VerifyPresignedURL checks the signature and the expiration of the presigned url
+func (store *LocalStore) VerifyPresignedURL(method, bucket string, query url.Values) (string, error) {
+	object := query.Get("object")
+	expiresAt, err := util.ToInt64(query.Get("expires"))
+	if err != nil {
+		return "", errors.New("invalid expires")
+	}
+	expected := store.sign(method, bucket, object, expiresAt)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return "", errors.New("invalid signature")
+	}
+	if time.Now().Unix() > expiresAt {
+		return "", errors.New("url expired")
+	}
+	return object, nil
+}
+
+func (store *LocalStore) sign(method, bucket, object string, expiresAt int64) string {
+	mac := hmac.New(sha256.New, store.secret)
+	mac.Write([]byte(method + "\n" + bucket + "\n" + object + "\n" + util.Int64ToString(expiresAt)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -28,42 +28,276 @@
 package s3
 
 import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+// ObjectStore is the object storage of the buckets, backed by s3 compatible servers or the local filesystem
+type ObjectStore interface {
+	// MakeBucket creates the bucket, no error if the bucket already exists
+	MakeBucket(ctx context.Context, bucket, location string) error
+	BucketExists(ctx context.Context, bucket string) (bool, error)
+
+	// PutObject streams the object from the reader, the size is -1 if unknown,
+	// large or unknown sized objects are uploaded in parts
+	PutObject(ctx context.Context, bucket, object string, reader io.Reader, size int64, opts PutOptions) (*ObjectInfo, error)
+	// GetObject streams the object, the reader must be closed by the caller
+	GetObject(ctx context.Context, bucket, object string, opts GetOptions) (io.ReadCloser, *ObjectInfo, error)
+	StatObject(ctx context.Context, bucket, object string) (*ObjectInfo, error)
+	// ListObjects lists the objects by the prefix in lexical order, the sub folders are
+	// returned as prefixes if not recursive
+	ListObjects(ctx context.Context, bucket string, opts ListOptions) ([]ObjectInfo, error)
+	// RemoveObject removes the object, no error if the object doesn't exist
+	RemoveObject(ctx context.Context, bucket, object string) error
+
+	// PresignedURL returns the url to GET or PUT the object without credentials until expired
+	PresignedURL(ctx context.Context, method, bucket, object string, expires time.Duration) (*url.URL, error)
+}
+
+var ErrObjectNotFound = errors.New("object not found")
+var ErrBucketNotFound = errors.New("bucket not found")
+
+type ObjectInfo struct {
+	Bucket       string            `json:"bucket"`
+	Key          string            `json:"key"`
+	Size         int64             `json:"size"`
+	ETag         string            `json:"etag,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	LastModified time.Time         `json:"last_modified,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	//base64 encoded digest of the object
+	Checksum          string `json:"checksum,omitempty"`
+	ChecksumAlgorithm string `json:"checksum_algorithm,omitempty"`
+	//common prefix of the non-recursive listing
+	IsPrefix bool `json:"is_prefix,omitempty"`
+}
+
+const (
+	EncryptionS3  = "sse-s3"
+	EncryptionKMS = "sse-kms"
+	EncryptionC   = "sse-c"
+
+	ChecksumMD5    = "md5"
+	ChecksumSHA256 = "sha256"
+	ChecksumCRC32C = "crc32c"
 )
 
-type S3 interface {
-	SyncDownload(filePath,location,bucketName,objectName string)(bool,error)
-	SyncUpload(filePath,location,bucketName,objectName string)(bool,error)
-	AsyncUpload(filePath,location,bucketName,objectName string) error
+// Encryption is the server side encryption of the objects
+type Encryption struct {
+	//sse-s3, sse-kms or sse-c
+	Type     string
+	KMSKeyID string
+	//32 bytes key of sse-c, also required to read the object
+	CustomerKey []byte
+}
+
+func (e *Encryption) Validate() error {
+	if e == nil {
+		return nil
+	}
+	switch e.Type {
+	case EncryptionS3:
+	case EncryptionKMS:
+		if e.KMSKeyID == "" {
+			return errors.New("kms key id is required by sse-kms")
+		}
+	case EncryptionC:
+		if len(e.CustomerKey) != 32 {
+			return errors.New("sse-c requires 32 bytes customer key")
+		}
+	default:
+		return errors.Errorf("invalid encryption [%v], should be sse-s3, sse-kms or sse-c", e.Type)
+	}
+	return nil
 }
 
-var s3Uploader = map[string]S3{}
+type PutOptions struct {
+	ContentType string
+	Metadata    map[string]string
+	//size of the parts of multipart upload, chosen by the store if zero
+	PartSize uint64
+	//num of parts uploaded concurrently
+	Concurrency uint
+	Encryption  *Encryption
+	//md5, sha256 or crc32c, computed while uploading and verified by the server if supported
+	Checksum string
+}
+
+type GetOptions struct {
+	//range of the object, the length is -1 or 0 to the end
+	Offset int64
+	Length int64
+	//required to read the objects encrypted by sse-c
+	Encryption *Encryption
+}
+
+type ListOptions struct {
+	Prefix    string
+	Recursive bool
+	//max num of objects, no limit if zero
+	MaxKeys int
+}
+
+// NewChecksum returns the hash of the checksum algorithm
+func NewChecksum(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	}
+	return nil, errors.Errorf("invalid checksum [%v], should be md5, sha256 or crc32c", algorithm)
+}
+
+// EncodeChecksum encodes the digest the same way as the s3 checksum headers
+func EncodeChecksum(h hash.Hash) string {
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+var stores = map[string]ObjectStore{}
+
+func Register(serverID string, store ObjectStore) {
+	stores[serverID] = store
+}
+
+func GetObjectStore(serverID string) (ObjectStore, error) {
+	store, ok := stores[serverID]
+	if !ok {
+		return nil, errors.Errorf("s3 server [%v] was not found", serverID)
+	}
+	return store, nil
+}
 
-func Register(serverID string,s3 S3)  {
-	s3Uploader[serverID]=s3
+func MustGetObjectStore(serverID string) ObjectStore {
+	store, err := GetObjectStore(serverID)
+	if err != nil {
+		panic(err)
+	}
+	return store
 }
 
-func SyncUpload(filePath,serverID,location,bucketName,objectName string)(bool,error){
-	handler, ok := s3Uploader[serverID]
-	if ok {
-		return handler.SyncUpload(filePath,location,bucketName,objectName)
+// SyncUpload uploads the local file to the bucket, the bucket is created if not exists
+func SyncUpload(filePath, serverID, location, bucketName, objectName string) (bool, error) {
+	store := MustGetObjectStore(serverID)
+
+	log.Tracef("s3 uploading file:%v to: %v", filePath, objectName)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
+	defer cancel()
+
+	err := store.MakeBucket(ctx, bucketName, location)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	info, err := store.PutObject(ctx, bucketName, objectName, f, stat.Size(), PutOptions{ContentType: "application/zip"})
+	if err != nil {
+		log.Error(err)
+		return false, err
 	}
-	panic(errors.Errorf("s3 server [%v] was not found",serverID))
+
+	log.Debugf("successfully uploaded %s of size %d", objectName, info.Size)
+
+	return true, nil
+}
+
+func AsyncUpload(filePath, serverID, location, bucketName, objectName string) error {
+	MustGetObjectStore(serverID)
+	//TODO to tracking tasks, control concurrent workers
+	go SyncUpload(filePath, serverID, location, bucketName, objectName)
+	return nil
 }
 
-func AsyncUpload(filePath,serverID,location,bucketName,objectName string) error {
-	handler, ok := s3Uploader[serverID]
-	if ok {
-		return handler.AsyncUpload(filePath,location,bucketName,objectName)
+var downloadLocker = sync.Mutex{}
+
+// SyncDownload downloads the object to the local file, skipped if the local file exists
+func SyncDownload(filePath, serverID, location, bucketName, objectName string) (bool, error) {
+	store := MustGetObjectStore(serverID)
+
+	log.Tracef("try downloading s3 file:%v to: %v", objectName, filePath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*30))
+	defer cancel()
+
+	downloadLocker.Lock()
+	defer downloadLocker.Unlock()
+
+	exists, err := store.BucketExists(ctx, bucketName)
+	if err != nil || !exists {
+		log.Tracef("bucket not exists %s, %v", bucketName, err)
+		return false, err
+	}
+
+	if util.FileExists(filePath) {
+		log.Tracef("local file exists, %v, %v", objectName, filePath)
+		return true, nil
 	}
-	panic(errors.Errorf("s3 server [%v] was not found",serverID))
+
+	tempPath := filePath + ".s3_tmp"
+	if util.FileExists(tempPath) {
+		log.Warnf("s3 temp file exists, delete: %v", tempPath)
+		util.FileDelete(tempPath)
+	}
+
+	log.Debugf("s3 downloading file:%v to: %v", objectName, filePath)
+
+	err = downloadToFile(ctx, store, bucketName, objectName, tempPath)
+	if err != nil {
+		log.Debug(err)
+		util.FileDelete(tempPath)
+		return false, err
+	}
+
+	err = os.Rename(tempPath, filePath)
+	if err != nil {
+		log.Debug(err)
+		return false, err
+	}
+
+	log.Debugf("successfully downloaded %s", objectName)
+
+	return true, nil
 }
 
+func downloadToFile(ctx context.Context, store ObjectStore, bucketName, objectName, filePath string) error {
+	reader, _, err := store.GetObject(ctx, bucketName, objectName, GetOptions{})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
 
-func SyncDownload(filePath,serverID,location,bucketName,objectName string)(bool,error){
-	handler, ok := s3Uploader[serverID]
-	if ok {
-		return handler.SyncDownload(filePath,location,bucketName,objectName)
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, reader)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
 	}
-	panic(errors.Errorf("s3 server [%v] was not found",serverID))
+	return err
 }
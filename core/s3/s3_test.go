@@ -0,0 +1,169 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalStoreObjects(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "", "")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = store.PutObject(ctx, "exports", "a.json", strings.NewReader("{}"), -1, PutOptions{})
+	assert.Equal(t, ErrBucketNotFound, err)
+	assert.NoError(t, store.MakeBucket(ctx, "exports", ""))
+	assert.NoError(t, store.MakeBucket(ctx, "exports", ""))
+
+	data := []byte("hello object store")
+	info, err := store.PutObject(ctx, "exports", "2024/01/a.json", bytes.NewReader(data), int64(len(data)), PutOptions{
+		ContentType: "application/json",
+		Metadata:    map[string]string{"owner": "test"},
+		Checksum:    ChecksumSHA256,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.Equal(t, "application/json", info.ContentType)
+	assert.Equal(t, "test", info.Metadata["owner"])
+	h, _ := NewChecksum(ChecksumSHA256)
+	h.Write(data)
+	assert.Equal(t, EncodeChecksum(h), info.Checksum)
+	assert.NotEmpty(t, info.ETag)
+
+	_, err = store.PutObject(ctx, "exports", "b.json", bytes.NewReader(data), 100, PutOptions{})
+	assert.Error(t, err)
+	_, err = store.PutObject(ctx, "exports", "../escape", bytes.NewReader(data), -1, PutOptions{})
+	assert.Error(t, err)
+	_, err = store.PutObject(ctx, "exports", "c.json", bytes.NewReader(data), -1, PutOptions{Checksum: "sha1"})
+	assert.Error(t, err)
+	_, err = store.PutObject(ctx, "exports", "c.json", bytes.NewReader(data), -1, PutOptions{Encryption: &Encryption{Type: EncryptionC, CustomerKey: []byte("short")}})
+	assert.Error(t, err)
+
+	reader, info, err := store.GetObject(ctx, "exports", "2024/01/a.json", GetOptions{Offset: 6, Length: 6})
+	assert.NoError(t, err)
+	body, _ := ioutil.ReadAll(reader)
+	reader.Close()
+	assert.Equal(t, "object", string(body))
+	assert.Equal(t, ChecksumSHA256, info.ChecksumAlgorithm)
+
+	_, _, err = store.GetObject(ctx, "exports", "missing.json", GetOptions{})
+	assert.Equal(t, ErrObjectNotFound, err)
+	_, err = store.StatObject(ctx, "missing", "a.json")
+	assert.Equal(t, ErrBucketNotFound, err)
+
+	assert.NoError(t, store.RemoveObject(ctx, "exports", "2024/01/a.json"))
+	assert.NoError(t, store.RemoveObject(ctx, "exports", "2024/01/a.json"))
+	_, err = store.StatObject(ctx, "exports", "2024/01/a.json")
+	assert.Equal(t, ErrObjectNotFound, err)
+}
+
+func TestLocalStoreList(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "", "")
+	assert.NoError(t, err)
+	ctx := context.Background()
+	assert.NoError(t, store.MakeBucket(ctx, "snapshots", ""))
+	for _, key := range []string{"index-1/0001", "index-1/0002", "index-2/0001", "index-10/0001", "manifest"} {
+		_, err := store.PutObject(ctx, "snapshots", key, strings.NewReader(key), -1, PutOptions{})
+		assert.NoError(t, err)
+	}
+
+	objects, err := store.ListObjects(ctx, "snapshots", ListOptions{})
+	assert.NoError(t, err)
+	keys := []string{}
+	for _, v := range objects {
+		keys = append(keys, v.Key)
+	}
+	assert.Equal(t, []string{"index-1/", "index-10/", "index-2/", "manifest"}, keys)
+	assert.True(t, objects[0].IsPrefix)
+	assert.False(t, objects[3].IsPrefix)
+
+	objects, err = store.ListObjects(ctx, "snapshots", ListOptions{Prefix: "index-1", Recursive: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(objects))
+	assert.Equal(t, "index-1/0001", objects[0].Key)
+	assert.Equal(t, int64(12), objects[0].Size)
+
+	objects, err = store.ListObjects(ctx, "snapshots", ListOptions{Prefix: "index-1/", MaxKeys: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(objects))
+
+	_, err = store.ListObjects(ctx, "missing", ListOptions{})
+	assert.Equal(t, ErrBucketNotFound, err)
+}
+
+func TestLocalStorePresignedURL(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "http://localhost:2900/object_store/local", "secret")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	u, err := store.PresignedURL(ctx, http.MethodGet, "exports", "2024/a.json", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "/object_store/local/exports", u.Path)
+	object, err := store.VerifyPresignedURL(http.MethodGet, "exports", u.Query())
+	assert.NoError(t, err)
+	assert.Equal(t, "2024/a.json", object)
+
+	_, err = store.VerifyPresignedURL(http.MethodPut, "exports", u.Query())
+	assert.Error(t, err)
+	_, err = store.VerifyPresignedURL(http.MethodGet, "other", u.Query())
+	assert.Error(t, err)
+
+	u, err = store.PresignedURL(ctx, http.MethodPut, "exports", "2024/a.json", -time.Minute)
+	assert.NoError(t, err)
+	_, err = store.VerifyPresignedURL(http.MethodPut, "exports", u.Query())
+	assert.Error(t, err)
+
+	_, err = store.PresignedURL(ctx, http.MethodDelete, "exports", "2024/a.json", time.Minute)
+	assert.Error(t, err)
+}
+
+func TestSyncUploadAndDownload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir+"/store", "", "")
+	assert.NoError(t, err)
+	Register("test_local", store)
+
+	assert.NoError(t, ioutil.WriteFile(dir+"/segment.dat", []byte("segment"), 0644))
+	ok, err := SyncUpload(dir+"/segment.dat", "test_local", "", "queue", "q1/1.dat")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = SyncDownload(dir+"/downloaded.dat", "test_local", "", "queue", "q1/1.dat")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	data, _ := ioutil.ReadFile(dir + "/downloaded.dat")
+	assert.Equal(t, "segment", string(data))
+
+	_, err = GetObjectStore("missing")
+	assert.Error(t, err)
+}
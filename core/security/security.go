@@ -0,0 +1,103 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package security carries the authenticated user of the api requests and checks the permissions of the
+// principals, the users are set by the authentication filters once the credentials are verified
+package security
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"infini.sh/framework/lib/guardian/auth"
+)
+
+type trackerKey struct{}
+
+type tracker struct {
+	principal string
+}
+
+// Authenticated returns the request carrying the authenticated user, it must only be called by the
+// authentication filters once the credentials are verified
+func Authenticated(r *http.Request, user string) *http.Request {
+	if t, ok := r.Context().Value(trackerKey{}).(*tracker); ok {
+		t.principal = user
+	}
+	return auth.RequestWithUser(auth.NewUserInfo(user, "", nil, nil), r)
+}
+
+// TrackPrincipal returns the request which tracks the user authenticated by the inner filters, it is used by
+// the filters wrapping the authentication, eg: audit, see GetPrincipal
+func TrackPrincipal(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(trackerKey{}).(*tracker); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), trackerKey{}, &tracker{}))
+}
+
+// GetPrincipal returns the authenticated user of the request, empty for anonymous requests
+func GetPrincipal(r *http.Request) string {
+	if info := auth.User(r); info != nil {
+		return info.GetUserName()
+	}
+	if t, ok := r.Context().Value(trackerKey{}).(*tracker); ok {
+		return t.principal
+	}
+	return ""
+}
+
+// PermissionChecker checks if the principal has the permission, the principal is empty for anonymous clients
+type PermissionChecker func(principal string, permission string) bool
+
+var checker PermissionChecker
+var authenticationEnabled bool
+var lock = sync.RWMutex{}
+
+// RegisterPermissionChecker replaces the default permission check, eg: by a role based security module
+func RegisterPermissionChecker(f PermissionChecker) {
+	lock.Lock()
+	defer lock.Unlock()
+	checker = f
+}
+
+// SetAuthenticationEnabled tells whether the requests are authenticated, see HasPermission
+func SetAuthenticationEnabled(enabled bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	authenticationEnabled = enabled
+}
+
+// HasPermission checks the permission with the registered checker, without a checker, the permissions are
+// granted to the authenticated principals, and to everyone if the authentication is disabled
+func HasPermission(principal string, permission string) bool {
+	lock.RLock()
+	f := checker
+	enabled := authenticationEnabled
+	lock.RUnlock()
+	if f != nil {
+		return f(principal, permission)
+	}
+	return !enabled || principal != ""
+}
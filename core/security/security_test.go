@@ -0,0 +1,60 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package security
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrincipal(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	//credentials alone are not an authenticated user
+	r.SetBasicAuth("admin", "wrong")
+	assert.Equal(t, "", GetPrincipal(r))
+	assert.Equal(t, "admin", GetPrincipal(Authenticated(r, "admin")))
+
+	//the outer filters see the user authenticated by the inner ones
+	tracked := TrackPrincipal(r)
+	Authenticated(tracked, "admin")
+	assert.Equal(t, "admin", GetPrincipal(tracked))
+}
+
+func TestHasPermission(t *testing.T) {
+	defer SetAuthenticationEnabled(false)
+	defer RegisterPermissionChecker(nil)
+
+	assert.True(t, HasPermission("", "log:read"))
+	SetAuthenticationEnabled(true)
+	assert.False(t, HasPermission("", "log:read"))
+	assert.True(t, HasPermission("admin", "log:read"))
+
+	RegisterPermissionChecker(func(principal string, permission string) bool {
+		return principal == "admin" && permission == "log:read"
+	})
+	assert.False(t, HasPermission("guest", "log:read"))
+	assert.True(t, HasPermission("admin", "log:read"))
+}
@@ -169,6 +169,20 @@ func Gauge(category, key string, value int64) {
 	}
 }
 
+// HistogramAPI is implemented by the handlers which support the distribution of values, eg: statsd
+type HistogramAPI interface {
+	Histogram(category, key string, v int64)
+}
+
+// Histogram records the value to the handlers which support histograms, the others are skipped
+func Histogram(category, key string, value int64) {
+	for _, v := range handlers {
+		if h, ok := v.(HistogramAPI); ok {
+			h.Histogram(category, key, value)
+		}
+	}
+}
+
 func Stat(category, key string) int64 {
 	for _, v := range handlers {
 		b := v.Stat(category, key)
@@ -193,6 +193,22 @@ func (expression *MultiCronExpression) GetLocation() *time.Location {
 	}
 	return expression.cronExps[0].loc
 }
+// withDefaultLocation sets the location of the expressions without explicit timezone
+func withDefaultLocation(expression CronExpression, loc *time.Location) {
+	switch v := expression.(type) {
+	case *SimpleCronExpression:
+		if v.loc == nil {
+			v.loc = loc
+		}
+	case *MultiCronExpression:
+		for i := range v.cronExps {
+			if v.cronExps[i].loc == nil {
+				v.cronExps[i].loc = loc
+			}
+		}
+	}
+}
+
 func (expression *MultiCronExpression) NextTime(t time.Time) time.Time {
 	var nearestTime time.Time
 	for _, cronExp := range expression.cronExps {
@@ -232,8 +248,8 @@ func ParseSingleCronExpression(expression string) (*SimpleCronExpression, error)
 	if len(expression) == 0 {
 		return nil, errors.New("cron expression must not be empty")
 	}
-	// Extract timezone if present
-	var loc = time.Local
+	// Extract timezone if present, otherwise the location of the trigger is used, see CreateCronTrigger
+	var loc *time.Location
 	if strings.HasPrefix(expression, "CRON_TZ=") {
 		var err error
 		i := strings.Index(expression, " ")
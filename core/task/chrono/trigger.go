@@ -83,6 +83,7 @@ func CreateCronTrigger(expression string, location *time.Location) (*CronTrigger
 			loc = time.Local
 		}
 	}
+	withDefaultLocation(cron, loc)
 
 	trigger := &CronTrigger{
 		cron,
@@ -110,3 +111,9 @@ func (trigger *CronTrigger) NextExecutionTime(ctx TriggerContext) time.Time {
 	next := trigger.cronExpression.NextTime(now.In(trigger.location))
 	return next.In(originalLocation)
 }
+
+// NextTime returns the next execution time after t
+func (trigger *CronTrigger) NextTime(t time.Time) time.Time {
+	next := trigger.cronExpression.NextTime(t.In(trigger.location))
+	return next.In(t.Location())
+}
@@ -46,3 +46,18 @@ func TestNewCronTrigger(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, trigger)
 }
+
+func TestCronTriggerLocation(t *testing.T) {
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	trigger, err := CreateCronTrigger("0 0 9 * * *", shanghai)
+	assert.Nil(t, err)
+
+	//09:00 in Shanghai is 01:00 in UTC
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC), trigger.NextTime(now))
+
+	//explicit CRON_TZ takes precedence
+	trigger, err = CreateCronTrigger("CRON_TZ=UTC 0 0 9 * * *", shanghai)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), trigger.NextTime(now))
+}
@@ -0,0 +1,202 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/util"
+)
+
+// TaskRun is an execution of a scheduled task
+type TaskRun struct {
+	ID     string `json:"id"`
+	TaskID string `json:"task_id"`
+	Node   string `json:"node,omitempty"`
+	//the missed fire time of the task, set for the runs triggered by the misfire policy
+	ScheduledTime *time.Time `json:"scheduled_time,omitempty"`
+	Misfire       bool       `json:"misfire,omitempty"`
+	StartTime     time.Time  `json:"start_time"`
+	EndTime       *time.Time `json:"end_time,omitempty"`
+	DurationInMs  int64      `json:"duration_in_ms"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// runHistory is the persisted state of a scheduled task
+type runHistory struct {
+	//the fire time of the latest run, used to find out the missed runs after restart
+	LastFireTime *time.Time `json:"last_fire_time,omitempty"`
+	Runs         []TaskRun  `json:"runs,omitempty"`
+}
+
+const historyBucket = "task_run_history"
+
+const defaultHistorySize = 20
+
+// the histories are updated in memory, the changed ones are persisted every interval and at shutdown
+const historyFlushInterval = 10 * time.Second
+
+var histories = map[string]*runHistory{}
+var dirtyHistories = map[string]bool{}
+var historyLock = sync.Mutex{}
+
+// loadHistory returns the cached history of the task, or loads it from the kv store
+func loadHistory(taskID string) *runHistory {
+	history, ok := histories[taskID]
+	if ok {
+		return history
+	}
+	history = &runHistory{}
+	histories[taskID] = history
+	if !kv.IsRegistered() {
+		return history
+	}
+	if exists, _ := kv.ExistsKey(historyBucket, []byte(taskID)); !exists {
+		return history
+	}
+	data, err := kv.GetValue(historyBucket, []byte(taskID))
+	if err != nil {
+		log.Errorf("failed to load history of task [%v]: %v", taskID, err)
+		return history
+	}
+	if err = util.FromJSONBytes(data, history); err != nil {
+		log.Errorf("invalid history of task [%v]: %v", taskID, err)
+	}
+	return history
+}
+
+// flushHistories persists the changed histories, the failed ones are retried on the next flush
+func flushHistories() {
+	if !kv.IsRegistered() {
+		return
+	}
+	historyLock.Lock()
+	pending := map[string][]byte{}
+	for taskID := range dirtyHistories {
+		if history, ok := histories[taskID]; ok {
+			pending[taskID] = util.MustToJSONBytes(history)
+		}
+	}
+	dirtyHistories = map[string]bool{}
+	historyLock.Unlock()
+
+	for taskID, data := range pending {
+		if err := kv.AddValue(historyBucket, []byte(taskID), data); err != nil {
+			log.Errorf("failed to save history of task [%v]: %v", taskID, err)
+			historyLock.Lock()
+			dirtyHistories[taskID] = true
+			historyLock.Unlock()
+		}
+	}
+}
+
+func flushHistoriesLoop() {
+	ticker := time.NewTicker(historyFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			flushHistories()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// removeHistory drops the cached history of the deleted task, the pending changes are persisted first
+func removeHistory(taskID string) {
+	historyLock.Lock()
+	dirty := dirtyHistories[taskID]
+	historyLock.Unlock()
+	if dirty {
+		flushHistories()
+	}
+	historyLock.Lock()
+	delete(histories, taskID)
+	delete(dirtyHistories, taskID)
+	historyLock.Unlock()
+}
+
+func getLastFireTime(taskID string) *time.Time {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	return loadHistory(taskID).LastFireTime
+}
+
+// recordRun adds or updates the run, only the latest size runs are kept
+func recordRun(run TaskRun, fireTime time.Time, size int) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	history := loadHistory(run.TaskID)
+	if history.LastFireTime == nil || fireTime.After(*history.LastFireTime) {
+		history.LastFireTime = &fireTime
+	}
+	updated := false
+	for i := len(history.Runs) - 1; i >= 0; i-- {
+		if history.Runs[i].ID == run.ID {
+			history.Runs[i] = run
+			updated = true
+			break
+		}
+	}
+	if !updated {
+		history.Runs = append(history.Runs, run)
+	}
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	if len(history.Runs) > size {
+		history.Runs = append([]TaskRun{}, history.Runs[len(history.Runs)-size:]...)
+	}
+	dirtyHistories[run.TaskID] = true
+}
+
+// GetTaskRuns returns the latest runs of the task, the newest first
+func GetTaskRuns(taskID string, size int) []TaskRun {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	runs := loadHistory(taskID).Runs
+	result := []TaskRun{}
+	for i := len(runs) - 1; i >= 0; i-- {
+		if size > 0 && len(result) >= size {
+			break
+		}
+		result = append(result, runs[i])
+	}
+	return result
+}
+
+func getNodeID() string {
+	if global.Env().SystemConfig != nil {
+		return global.Env().SystemConfig.NodeConfig.ID
+	}
+	return ""
+}
@@ -0,0 +1,574 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/progress"
+	"infini.sh/framework/core/util"
+)
+
+// JobConfig configures the durable jobs, the jobs are persisted in the store and claimed by the workers with leases,
+// the jobs of a crashed worker are taken over after the lease expired
+type JobConfig struct {
+	Enabled bool   `config:"enabled" json:"enabled"`
+	Store   string `config:"store" json:"store,omitempty" enum:"orm,memory"`
+	Workers int    `config:"workers" json:"workers,omitempty"`
+	//the job types processed by the workers of this node, default to all the registered types
+	Types             []string `config:"types" json:"types,omitempty"`
+	PollIntervalInMs  int      `config:"poll_interval_in_ms" json:"poll_interval_in_ms,omitempty"`
+	LeaseTimeoutInMs  int      `config:"lease_timeout_in_ms" json:"lease_timeout_in_ms,omitempty"`
+	RetryDelayInMs    int      `config:"retry_delay_in_ms" json:"retry_delay_in_ms,omitempty"`
+	MaxRetryDelayInMs int      `config:"max_retry_delay_in_ms" json:"max_retry_delay_in_ms,omitempty"`
+	DefaultMaxRetries int      `config:"default_max_retries" json:"default_max_retries,omitempty"`
+}
+
+// DefaultJobConfig returns the defaults of the job config
+func DefaultJobConfig() JobConfig {
+	return JobConfig{
+		Store:             JobStoreORM,
+		Workers:           2,
+		PollIntervalInMs:  1000,
+		LeaseTimeoutInMs:  30000,
+		RetryDelayInMs:    1000,
+		MaxRetryDelayInMs: 300000,
+		DefaultMaxRetries: 3,
+	}
+}
+
+var jobConfig = DefaultJobConfig()
+
+// JobHandler processes the job, the job is retried with backoff if an error returned,
+// the context is canceled if the job is canceled
+type JobHandler func(ctx *JobContext) error
+
+var jobHandlers = map[string]JobHandler{}
+var jobHandlersLock = sync.RWMutex{}
+
+// RegisterJobHandler registers the handler of the job type
+func RegisterJobHandler(jobType string, handler JobHandler) {
+	jobHandlersLock.Lock()
+	defer jobHandlersLock.Unlock()
+	if _, ok := jobHandlers[jobType]; ok {
+		panic(errors.Errorf("job handler [%v] already registered", jobType))
+	}
+	jobHandlers[jobType] = handler
+}
+
+func getJobHandler(jobType string) (JobHandler, bool) {
+	jobHandlersLock.RLock()
+	defer jobHandlersLock.RUnlock()
+	handler, ok := jobHandlers[jobType]
+	return handler, ok
+}
+
+func getJobTypes() []string {
+	if len(jobConfig.Types) > 0 {
+		return jobConfig.Types
+	}
+	jobHandlersLock.RLock()
+	defer jobHandlersLock.RUnlock()
+	types := []string{}
+	for k := range jobHandlers {
+		types = append(types, k)
+	}
+	return types
+}
+
+func getJobStore() JobStore {
+	store, err := GetJobStore(jobConfig.Store)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// JobOptions are the options of the enqueued job
+type JobOptions struct {
+	ID          string
+	ParentID    string
+	Description string
+	//-1 to disable retries, 0 to use the default
+	MaxRetries int
+	Labels     map[string]interface{}
+	//delay the first run
+	Delay time.Duration
+}
+
+// EnqueueJob persists the job of the type, it is processed by the workers which registered the type,
+// the handler of the type must be registered on this node too
+func EnqueueJob(jobType string, payload interface{}, opts JobOptions) (string, error) {
+	if jobType == "" {
+		return "", errors.New("job type is required")
+	}
+	if _, ok := getJobHandler(jobType); !ok {
+		return "", errors.Errorf("job handler [%v] not found", jobType)
+	}
+	job := &Task{}
+	job.ID = opts.ID
+	if job.ID == "" {
+		job.ID = util.GetUUID()
+	}
+	now := time.Now()
+	next := now.Add(opts.Delay)
+	job.Created = &now
+	job.Updated = &now
+	job.Runnable = true
+	job.Cancellable = true
+	job.Status = StatusReady
+	job.Description = opts.Description
+	job.Metadata = Metadata{Type: jobType, Labels: opts.Labels}
+	job.NextRunTime = &next
+	job.MaxRetries = opts.MaxRetries
+	if job.MaxRetries == 0 {
+		job.MaxRetries = jobConfig.DefaultMaxRetries
+	} else if job.MaxRetries < 0 {
+		job.MaxRetries = 0
+	}
+	if opts.ParentID != "" {
+		job.ParentId = []string{opts.ParentID}
+	}
+	if payload != nil {
+		job.ConfigString = string(util.MustToJSONBytes(payload))
+	}
+	if err := getJobStore().Create(job); err != nil {
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// GetJob returns the job, nil if not found
+func GetJob(id string) (*Task, error) {
+	return getJobStore().Get(id)
+}
+
+// SearchJobs returns the jobs match the query
+func SearchJobs(query JobQuery) ([]Task, error) {
+	return getJobStore().Search(query)
+}
+
+var runningJobs = sync.Map{}
+
+// CancelJob cancels the job and its children, a running job is marked as pending_stop
+// and stopped by its worker on the next heartbeat, or right away if it runs on this node
+func CancelJob(id string) error {
+	store := getJobStore()
+	job, err := store.Get(id)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return errors.Errorf("job [%v] not found", id)
+	}
+
+	_, err = store.Modify(id, func(job *Task) bool {
+		now := time.Now()
+		switch job.Status {
+		case StatusReady:
+			job.Status = StatusStopped
+			job.CompletedTime = &now
+		case StatusRunning:
+			job.Status = StatusPendingStop
+		default:
+			return false
+		}
+		job.Updated = &now
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if cancel, ok := runningJobs.Load(id); ok {
+		cancel.(context.CancelFunc)()
+	}
+
+	return eachJob(store, JobQuery{ParentID: id}, func(v *Task) error {
+		if !IsEnded(v.Status) {
+			return CancelJob(v.ID)
+		}
+		return nil
+	})
+}
+
+const jobPageSize = 100
+
+// eachJob pages through the jobs match the query, stops on the first error
+func eachJob(store JobStore, query JobQuery, f func(job *Task) error) error {
+	query.Size = jobPageSize
+	for query.From = 0; ; query.From += jobPageSize {
+		jobs, err := store.Search(query)
+		if err != nil {
+			return err
+		}
+		for i := range jobs {
+			if err := f(&jobs[i]); err != nil {
+				return err
+			}
+		}
+		if len(jobs) < jobPageSize {
+			return nil
+		}
+	}
+}
+
+// JobContext is passed to the job handler
+type JobContext struct {
+	context.Context
+	Job *Task
+
+	lock  sync.Mutex
+	dirty bool
+}
+
+// Payload unpacks the payload of the job into v
+func (ctx *JobContext) Payload(v interface{}) error {
+	if ctx.Job.ConfigString == "" {
+		return nil
+	}
+	return util.FromJSONBytes([]byte(ctx.Job.ConfigString), v)
+}
+
+// SetProgress updates the progress of the job, it is persisted with the lease renewal
+func (ctx *JobContext) SetProgress(completed, total int64, message string) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	var previous int64
+	if ctx.Job.Progress != nil {
+		previous = ctx.Job.Progress.Completed
+	}
+	ctx.Job.Progress = &JobProgress{Total: total, Completed: completed, Message: message}
+	ctx.dirty = true
+	progress.IncreaseWithTotal("job", ctx.Job.ID, int(completed-previous), int(total))
+}
+
+func (ctx *JobContext) getProgress() (*JobProgress, bool) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	dirty := ctx.dirty
+	ctx.dirty = false
+	return ctx.Job.Progress, dirty
+}
+
+// EnqueueChild enqueues a child job, the children are canceled with the parent
+func (ctx *JobContext) EnqueueChild(jobType string, payload interface{}, opts JobOptions) (string, error) {
+	opts.ParentID = ctx.Job.ID
+	return EnqueueJob(jobType, payload, opts)
+}
+
+// WaitChildren blocks until all the children ended, returns error if any of them failed or was stopped
+func (ctx *JobContext) WaitChildren() error {
+	interval := time.Duration(jobConfig.PollIntervalInMs) * time.Millisecond
+	store := getJobStore()
+	for {
+		ended := true
+		err := eachJob(store, JobQuery{ParentID: ctx.Job.ID}, func(v *Task) error {
+			switch v.Status {
+			case StatusComplete:
+			case StatusError, StatusStopped:
+				return errors.Errorf("child job [%v] %v", v.ID, v.Status)
+			default:
+				ended = false
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if ended {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+type jobWorkers struct {
+	id     string
+	quit   chan struct{}
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+var workers *jobWorkers
+var workersLock = sync.Mutex{}
+
+// InitJobs sets the config of the jobs, called before the jobs are enqueued
+func InitJobs(cfg JobConfig) error {
+	if _, err := GetJobStore(cfg.Store); err != nil {
+		return err
+	}
+	if cfg.PollIntervalInMs <= 0 || cfg.LeaseTimeoutInMs <= 0 {
+		return errors.New("poll_interval_in_ms and lease_timeout_in_ms must be positive")
+	}
+	jobConfig = cfg
+	return nil
+}
+
+// StartJobWorkers starts the workers which claim and run the jobs
+func StartJobWorkers() {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	if workers != nil || !jobConfig.Enabled {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	workers = &jobWorkers{
+		id:     getNodeID() + "/" + util.GetUUID(),
+		quit:   make(chan struct{}),
+		cancel: cancel,
+	}
+	for i := 0; i < jobConfig.Workers; i++ {
+		workers.wg.Add(1)
+		go workers.run(ctx)
+	}
+	log.Debugf("started %v job workers, types: %v", jobConfig.Workers, getJobTypes())
+}
+
+// StopJobWorkers stops the workers, the running jobs are canceled and released, to be claimed again without counting as retries
+func StopJobWorkers() {
+	workersLock.Lock()
+	defer workersLock.Unlock()
+	if workers == nil {
+		return
+	}
+	close(workers.quit)
+	workers.cancel()
+	workers.wg.Wait()
+	workers = nil
+}
+
+func (w *jobWorkers) run(ctx context.Context) {
+	defer w.wg.Done()
+	interval := time.Duration(jobConfig.PollIntervalInMs) * time.Millisecond
+	for {
+		select {
+		case <-w.quit:
+			return
+		default:
+		}
+
+		claimed := w.runOnce(ctx)
+		if claimed {
+			continue
+		}
+		select {
+		case <-w.quit:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// runOnce claims and runs a job, returns false if there is no job to run
+func (w *jobWorkers) runOnce(ctx context.Context) bool {
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				}
+				log.Error("error on job worker: ", v)
+			}
+		}
+	}()
+
+	types := getJobTypes()
+	if len(types) == 0 {
+		return false
+	}
+	store := getJobStore()
+	lease := time.Duration(jobConfig.LeaseTimeoutInMs) * time.Millisecond
+	job, err := store.Claim(types, w.id, lease)
+	if err != nil {
+		log.Errorf("failed to claim job: %v", err)
+		return false
+	}
+	if job == nil {
+		return false
+	}
+	runJob(ctx, store, job, w.id, lease)
+	return true
+}
+
+func runJob(parent context.Context, store JobStore, job *Task, owner string, lease time.Duration) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	runningJobs.Store(job.ID, cancel)
+	defer runningJobs.Delete(job.ID)
+
+	jobCtx := &JobContext{Context: ctx, Job: job}
+	done := make(chan error, 1)
+	go func() {
+		done <- callJobHandler(jobCtx)
+	}()
+
+	//renew the lease and persist the progress, until the handler returned
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+	var err error
+	for running := true; running; {
+		select {
+		case err = <-done:
+			running = false
+		case <-ticker.C:
+			if !renewLease(store, jobCtx, owner, lease) {
+				cancel()
+			}
+		}
+	}
+
+	//canceled by StopJobWorkers, the job itself didn't fail
+	finishJob(store, jobCtx, owner, err, parent.Err() != nil)
+}
+
+func callJobHandler(ctx *JobContext) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch v := r.(type) {
+			case error:
+				err = v
+			case string:
+				err = errors.New(v)
+			default:
+				err = errors.Errorf("%v", v)
+			}
+		}
+	}()
+	handler, ok := getJobHandler(ctx.Job.Metadata.Type)
+	if !ok {
+		return errors.Errorf("job handler [%v] not found", ctx.Job.Metadata.Type)
+	}
+	return handler(ctx)
+}
+
+// renewLease extends the lease of the job, returns false if the job was canceled or taken over
+func renewLease(store JobStore, ctx *JobContext, owner string, lease time.Duration) bool {
+	p, dirty := ctx.getProgress()
+	job, err := store.Modify(ctx.Job.ID, func(job *Task) bool {
+		//a canceled job keeps its pending_stop status
+		if job.LeaseOwner != owner || job.Status != StatusRunning {
+			return false
+		}
+		now := time.Now()
+		expire := now.Add(lease)
+		job.LeaseExpire = &expire
+		if dirty {
+			job.Progress = p
+		}
+		job.Updated = &now
+		return true
+	})
+	if err != nil {
+		log.Errorf("failed to renew the lease of job [%v]: %v", ctx.Job.ID, err)
+		return true
+	}
+	return job != nil
+}
+
+// finishJob saves the result of the run, failed jobs are retried with exponential backoff,
+// the jobs interrupted by the shutdown are released and runnable right away
+func finishJob(store JobStore, ctx *JobContext, owner string, runErr error, shutdown bool) {
+	var takenBy string
+	job, err := store.Modify(ctx.Job.ID, func(job *Task) bool {
+		takenBy = job.LeaseOwner
+		if job.LeaseOwner != owner {
+			return false
+		}
+
+		now := time.Now()
+		job.Progress, _ = ctx.getProgress()
+		job.LeaseOwner = ""
+		job.LeaseExpire = nil
+		job.Updated = &now
+
+		switch {
+		case job.Status == StatusPendingStop:
+			stopJob(job, now)
+		case runErr == nil:
+			job.Status = StatusComplete
+			job.CompletedTime = &now
+			job.Result = &TaskResult{Success: true}
+		case shutdown:
+			job.Status = StatusReady
+			job.NextRunTime = &now
+		case job.RetryTimes < job.MaxRetries:
+			job.RetryTimes++
+			next := now.Add(retryDelay(job.RetryTimes))
+			job.Status = StatusReady
+			job.NextRunTime = &next
+			job.Result = &TaskResult{Success: false, Error: runErr.Error()}
+		default:
+			job.Status = StatusError
+			job.CompletedTime = &now
+			job.Result = &TaskResult{Success: false, Error: runErr.Error()}
+		}
+		return true
+	})
+	if err != nil {
+		log.Errorf("failed to finish job [%v]: %v", ctx.Job.ID, err)
+		return
+	}
+	if job == nil {
+		log.Warnf("job [%v] was taken over by [%v]", ctx.Job.ID, takenBy)
+		return
+	}
+
+	switch {
+	case shutdown && job.Status == StatusReady:
+		log.Debugf("job [%v][%v] released on shutdown", job.ID, job.Metadata.Type)
+	case job.Status == StatusReady:
+		log.Warnf("job [%v][%v] failed, retry %v/%v at %v: %v", job.ID, job.Metadata.Type, job.RetryTimes, job.MaxRetries, job.NextRunTime.Format(time.RFC3339), runErr)
+	case job.Status == StatusError:
+		log.Errorf("job [%v][%v] failed: %v", job.ID, job.Metadata.Type, runErr)
+	}
+}
+
+// retryDelay doubles the delay for each retry, up to the max delay
+func retryDelay(retries int) time.Duration {
+	delay := time.Duration(jobConfig.RetryDelayInMs) * time.Millisecond
+	max := time.Duration(jobConfig.MaxRetryDelayInMs) * time.Millisecond
+	for i := 1; i < retries && delay < max; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	return delay
+}
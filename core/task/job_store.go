@@ -0,0 +1,396 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+// JobQuery filters the jobs, empty fields match all
+type JobQuery struct {
+	Type     string
+	Status   string
+	ParentID string
+	From     int
+	Size     int
+}
+
+// JobStore persists the jobs, Get returns nil if the job does not exist,
+// Modify applies the change to the latest version of the job atomically, the change is
+// skipped if it returns false, returns the changed job, or nil if it was skipped or the job does not exist,
+// Claim takes the lease of a runnable job of the types, returns nil if there is no such job
+type JobStore interface {
+	Create(job *Task) error
+	Get(id string) (*Task, error)
+	Update(job *Task) error
+	Modify(id string, change func(job *Task) bool) (*Task, error)
+	Search(query JobQuery) ([]Task, error)
+	Claim(types []string, owner string, lease time.Duration) (*Task, error)
+}
+
+const (
+	JobStoreORM    = "orm"
+	JobStoreMemory = "memory"
+)
+
+var jobStores = map[string]JobStore{
+	JobStoreORM:    ormJobStore{},
+	JobStoreMemory: &memoryJobStore{jobs: map[string]*Task{}},
+}
+
+func RegisterJobStore(name string, store JobStore) {
+	jobStores[name] = store
+}
+
+// GetJobStore returns the store by name, orm store is used by default
+func GetJobStore(name string) (JobStore, error) {
+	if name == "" {
+		name = JobStoreORM
+	}
+	store, ok := jobStores[name]
+	if !ok {
+		return nil, errors.Errorf("job store [%v] not found", name)
+	}
+	return store, nil
+}
+
+// isClaimable returns true if the job is ready to run, or the lease of the running job expired
+func isClaimable(job *Task, types []string, now time.Time) bool {
+	if len(types) > 0 && !util.StringInArray(types, job.Metadata.Type) {
+		return false
+	}
+	switch job.Status {
+	case StatusReady:
+		return job.NextRunTime == nil || !job.NextRunTime.After(now)
+	case StatusRunning:
+		return job.LeaseExpire == nil || job.LeaseExpire.Before(now)
+	}
+	return false
+}
+
+// isStopExpired returns true if the canceled job was not stopped before its lease expired, eg: the owner crashed
+func isStopExpired(job *Task, types []string, now time.Time) bool {
+	if len(types) > 0 && !util.StringInArray(types, job.Metadata.Type) {
+		return false
+	}
+	return job.Status == StatusPendingStop && (job.LeaseExpire == nil || job.LeaseExpire.Before(now))
+}
+
+func stopJob(job *Task, now time.Time) {
+	job.Status = StatusStopped
+	job.CompletedTime = &now
+	job.LeaseOwner = ""
+	job.LeaseExpire = nil
+	job.Updated = &now
+	job.Result = &TaskResult{Success: false, Error: "canceled"}
+}
+
+func takeLease(job *Task, owner string, lease time.Duration, now time.Time) {
+	expire := now.Add(lease)
+	job.LeaseOwner = owner
+	job.LeaseExpire = &expire
+	job.Status = StatusRunning
+	if job.StartTimeInMillis == 0 {
+		job.StartTimeInMillis = now.UnixMilli()
+	}
+}
+
+type ormJobStore struct{}
+
+func (ormJobStore) Create(job *Task) error {
+	return orm.Create(&orm.Context{Refresh: "wait_for"}, job)
+}
+
+func (ormJobStore) Get(id string) (*Task, error) {
+	//search by id, orm.Get doesn't tell missing documents from errors
+	err, result := orm.GetBy("id", id, Task{})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Result) == 0 {
+		return nil, nil
+	}
+	job := &Task{}
+	err = util.FromJSONBytes(util.MustToJSONBytes(result.Result[0]), job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (ormJobStore) Update(job *Task) error {
+	return orm.Update(&orm.Context{Refresh: "wait_for"}, job)
+}
+
+// maxModifyRetries bounds the retries of a compare and swap which keeps losing to other writers
+const maxModifyRetries = 10
+
+// Modify reads the job with its version and saves it only if the version is unchanged,
+// the change is applied again on the latest version after a conflict
+func (store ormJobStore) Modify(id string, change func(job *Task) bool) (*Task, error) {
+	for i := 0; i < maxModifyRetries; i++ {
+		job := &Task{}
+		job.ID = id
+		exists, version, err := orm.GetWithVersion(job)
+		if !exists {
+			//tell missing jobs from errors
+			if v, getErr := store.Get(id); getErr != nil || v != nil {
+				if err == nil {
+					err = getErr
+				}
+				return nil, err
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !change(job) {
+			return nil, nil
+		}
+		err = orm.SaveIfVersion(&orm.Context{Refresh: "wait_for"}, job, version)
+		if err == nil {
+			return job, nil
+		}
+		if err != orm.ErrVersionConflict {
+			return nil, err
+		}
+	}
+	return nil, errors.Errorf("failed to modify job [%v]: %v", id, orm.ErrVersionConflict)
+}
+
+func (ormJobStore) Search(query JobQuery) ([]Task, error) {
+	q := &orm.Query{From: query.From, Size: query.Size}
+	if q.Size <= 0 {
+		q.Size = 100
+	}
+	conds := []*orm.Cond{orm.Eq("runnable", true)}
+	if query.Type != "" {
+		conds = append(conds, orm.Eq("metadata.type", query.Type))
+	}
+	if query.Status != "" {
+		conds = append(conds, orm.Eq("status", query.Status))
+	}
+	if query.ParentID != "" {
+		conds = append(conds, orm.Eq("parent_id", query.ParentID))
+	}
+	q.Conds = orm.And(conds...)
+	q.AddSort("created", orm.ASC)
+	return searchJobs(q)
+}
+
+func searchJobs(q *orm.Query) ([]Task, error) {
+	err, result := orm.Search(Task{}, q)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []Task{}
+	for _, v := range result.Result {
+		job := Task{}
+		if err := util.FromJSONBytes(util.MustToJSONBytes(v), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Claim takes the lease with a compare and swap, the candidate is skipped if another worker claimed it first
+func (store ormJobStore) Claim(types []string, owner string, lease time.Duration) (*Task, error) {
+	now := time.Now()
+	typeConds := []*orm.Cond{orm.Eq("runnable", true)}
+	if len(types) > 0 {
+		typeConds = append(typeConds, orm.InStringArray("metadata.type", types))
+	}
+
+	ready := &orm.Query{Size: 10}
+	ready.Conds = orm.And(append(typeConds, orm.Eq("status", StatusReady), orm.Le("next_run_time", now))...)
+	ready.AddSort("created", orm.ASC)
+	expired := &orm.Query{Size: 10}
+	expired.Conds = orm.And(append(typeConds, orm.Eq("status", StatusRunning), orm.Lt("lease_expire", now))...)
+	expired.AddSort("created", orm.ASC)
+
+	//the canceled jobs of the dead workers are stopped instead of claimed
+	stopping := &orm.Query{Size: 10}
+	stopping.Conds = orm.And(append(typeConds, orm.Eq("status", StatusPendingStop), orm.Lt("lease_expire", now))...)
+	candidates, err := searchJobs(stopping)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range candidates {
+		_, err := store.Modify(v.ID, func(job *Task) bool {
+			if !isStopExpired(job, types, now) {
+				return false
+			}
+			stopJob(job, now)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, q := range []*orm.Query{ready, expired} {
+		candidates, err := searchJobs(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range candidates {
+			job, err := store.Modify(v.ID, func(job *Task) bool {
+				if !isClaimable(job, types, now) {
+					return false
+				}
+				takeLease(job, owner, lease, now)
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+			if job != nil {
+				return job, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// memoryJobStore keeps the jobs in memory, for single node setups and tests, jobs are lost on restart
+type memoryJobStore struct {
+	lock sync.Mutex
+	jobs map[string]*Task
+}
+
+func cloneJob(job *Task) *Task {
+	v := &Task{}
+	util.MustFromJSONBytes(util.MustToJSONBytes(job), v)
+	return v
+}
+
+func (store *memoryJobStore) Create(job *Task) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if job.ID == "" {
+		job.ID = util.GetUUID()
+	}
+	if _, ok := store.jobs[job.ID]; ok {
+		return errors.Errorf("job [%v] already exists", job.ID)
+	}
+	store.jobs[job.ID] = cloneJob(job)
+	return nil
+}
+
+func (store *memoryJobStore) Get(id string) (*Task, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	job, ok := store.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneJob(job), nil
+}
+
+func (store *memoryJobStore) Update(job *Task) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.jobs[job.ID]; !ok {
+		return errors.Errorf("job [%v] not found", job.ID)
+	}
+	store.jobs[job.ID] = cloneJob(job)
+	return nil
+}
+
+func (store *memoryJobStore) Modify(id string, change func(job *Task) bool) (*Task, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	v, ok := store.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	job := cloneJob(v)
+	if !change(job) {
+		return nil, nil
+	}
+	store.jobs[id] = cloneJob(job)
+	return job, nil
+}
+
+func (store *memoryJobStore) sorted() []*Task {
+	jobs := []*Task{}
+	for _, v := range store.jobs {
+		jobs = append(jobs, v)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Created == nil || jobs[j].Created == nil {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].Created.Before(*jobs[j].Created)
+	})
+	return jobs
+}
+
+func (store *memoryJobStore) Search(query JobQuery) ([]Task, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	result := []Task{}
+	skipped := 0
+	for _, v := range store.sorted() {
+		if query.Size > 0 && len(result) >= query.Size {
+			break
+		}
+		if (query.Type != "" && v.Metadata.Type != query.Type) ||
+			(query.Status != "" && v.Status != query.Status) ||
+			(query.ParentID != "" && !util.StringInArray(v.ParentId, query.ParentID)) {
+			continue
+		}
+		if skipped < query.From {
+			skipped++
+			continue
+		}
+		result = append(result, *cloneJob(v))
+	}
+	return result, nil
+}
+
+func (store *memoryJobStore) Claim(types []string, owner string, lease time.Duration) (*Task, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	now := time.Now()
+	for _, v := range store.sorted() {
+		if isStopExpired(v, types, now) {
+			stopJob(v, now)
+			continue
+		}
+		if isClaimable(v, types, now) {
+			takeLease(v, owner, lease, now)
+			return cloneJob(v), nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,252 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+func waitJobStatus(t *testing.T, id string, status string) *Task {
+	for i := 0; i < 100; i++ {
+		job, err := GetJob(id)
+		assert.Nil(t, err)
+		if job != nil && job.Status == status {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	job, _ := GetJob(id)
+	t.Fatalf("job [%v] is not %v: %v", id, status, job)
+	return nil
+}
+
+func TestJobs(t *testing.T) {
+	cfg := DefaultJobConfig()
+	cfg.Enabled = true
+	cfg.Store = JobStoreMemory
+	cfg.Workers = 2
+	cfg.PollIntervalInMs = 10
+	cfg.LeaseTimeoutInMs = 300
+	cfg.RetryDelayInMs = 10
+	cfg.Types = []string{"test_job", "test_job_blocking"}
+	assert.Nil(t, InitJobs(cfg))
+
+	attempts := map[string]int{}
+	lock := sync.Mutex{}
+	RegisterJobHandler("test_job", func(ctx *JobContext) error {
+		payload := struct {
+			FailTimes int `json:"fail_times"`
+		}{}
+		if err := ctx.Payload(&payload); err != nil {
+			return err
+		}
+		lock.Lock()
+		attempts[ctx.Job.ID]++
+		attempt := attempts[ctx.Job.ID]
+		lock.Unlock()
+		ctx.SetProgress(int64(attempt), int64(payload.FailTimes+1), "processing")
+		if attempt <= payload.FailTimes {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	RegisterJobHandler("test_job_blocking", func(ctx *JobContext) error {
+		if len(ctx.Job.ParentId) == 0 {
+			if _, err := ctx.EnqueueChild("test_job_blocking", nil, JobOptions{MaxRetries: -1}); err != nil {
+				return err
+			}
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	StartJobWorkers()
+	defer StopJobWorkers()
+
+	//no handler for the type
+	id, err := EnqueueJob("test_job_unknown", nil, JobOptions{})
+	assert.NotNil(t, err)
+	assert.Equal(t, "", id)
+
+	//retried with backoff until succeeded
+	id, err = EnqueueJob("test_job", map[string]interface{}{"fail_times": 2}, JobOptions{})
+	assert.Nil(t, err)
+	job := waitJobStatus(t, id, StatusComplete)
+	assert.Equal(t, 2, job.RetryTimes)
+	assert.True(t, job.Result.Success)
+	assert.Equal(t, int64(3), job.Progress.Completed)
+	assert.Equal(t, "", job.LeaseOwner)
+
+	//failed after max retries
+	id, err = EnqueueJob("test_job", map[string]interface{}{"fail_times": 5}, JobOptions{MaxRetries: 1})
+	assert.Nil(t, err)
+	job = waitJobStatus(t, id, StatusError)
+	assert.Equal(t, 1, job.RetryTimes)
+	assert.Equal(t, "failed", job.Result.Error)
+
+	//canceled with the children
+	id, err = EnqueueJob("test_job_blocking", nil, JobOptions{MaxRetries: -1})
+	assert.Nil(t, err)
+	waitJobStatus(t, id, StatusRunning)
+	children := []Task{}
+	for i := 0; i < 100 && len(children) == 0; i++ {
+		children, _ = SearchJobs(JobQuery{ParentID: id})
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, len(children))
+	assert.Nil(t, CancelJob(id))
+	waitJobStatus(t, id, StatusStopped)
+	waitJobStatus(t, children[0].ID, StatusStopped)
+}
+
+func TestMemoryJobStoreClaim(t *testing.T) {
+	store := &memoryJobStore{jobs: map[string]*Task{}}
+	now := time.Now()
+	later := now.Add(time.Hour)
+	assert.Nil(t, store.Create(&Task{ORMObjectBase: orm.ORMObjectBase{ID: "delayed", Created: &now}, Status: StatusReady, NextRunTime: &later, Metadata: Metadata{Type: "a"}}))
+	assert.Nil(t, store.Create(&Task{ORMObjectBase: orm.ORMObjectBase{ID: "ready", Created: &now}, Status: StatusReady, NextRunTime: &now, Metadata: Metadata{Type: "a"}}))
+
+	job, err := store.Claim([]string{"b"}, "worker1", time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, job)
+
+	job, err = store.Claim([]string{"a"}, "worker1", 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, "ready", job.ID)
+	assert.Equal(t, StatusRunning, job.Status)
+
+	//the lease is held
+	job, err = store.Claim([]string{"a"}, "worker2", time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, job)
+
+	//taken over after the lease expired
+	time.Sleep(60 * time.Millisecond)
+	job, err = store.Claim([]string{"a"}, "worker2", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "ready", job.ID)
+	assert.Equal(t, "worker2", job.LeaseOwner)
+
+	//the canceled job of a dead worker is stopped after the lease expired
+	_, err = store.Modify("ready", func(job *Task) bool {
+		job.Status = StatusPendingStop
+		return true
+	})
+	assert.Nil(t, err)
+	job, err = store.Claim([]string{"a"}, "worker3", time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, job)
+	job, _ = store.Get("ready")
+	assert.Equal(t, StatusPendingStop, job.Status)
+	_, err = store.Modify("ready", func(job *Task) bool {
+		expired := time.Now().Add(-time.Second)
+		job.LeaseExpire = &expired
+		return true
+	})
+	assert.Nil(t, err)
+	job, err = store.Claim([]string{"a"}, "worker3", time.Second)
+	assert.Nil(t, err)
+	assert.Nil(t, job)
+	job, _ = store.Get("ready")
+	assert.Equal(t, StatusStopped, job.Status)
+	assert.Equal(t, "", job.LeaseOwner)
+}
+
+func TestFinishJobOnShutdown(t *testing.T) {
+	store := &memoryJobStore{jobs: map[string]*Task{}}
+	now := time.Now()
+	assert.Nil(t, store.Create(&Task{ORMObjectBase: orm.ORMObjectBase{ID: "job", Created: &now}, Status: StatusReady, NextRunTime: &now, MaxRetries: 3}))
+	job, err := store.Claim(nil, "worker1", time.Minute)
+	assert.Nil(t, err)
+
+	//released without counting as a retry
+	finishJob(store, &JobContext{Job: job}, "worker1", context.Canceled, true)
+	job, _ = store.Get("job")
+	assert.Equal(t, StatusReady, job.Status)
+	assert.Equal(t, 0, job.RetryTimes)
+	assert.Equal(t, "", job.LeaseOwner)
+	assert.False(t, job.NextRunTime.After(time.Now()))
+}
+
+func TestEachJob(t *testing.T) {
+	store := &memoryJobStore{jobs: map[string]*Task{}}
+	for i := 0; i < jobPageSize+10; i++ {
+		created := time.Now().Add(time.Duration(i) * time.Millisecond)
+		assert.Nil(t, store.Create(&Task{ORMObjectBase: orm.ORMObjectBase{ID: util.IntToString(i), Created: &created}, ParentId: []string{"parent"}}))
+	}
+	ids := map[string]bool{}
+	assert.Nil(t, eachJob(store, JobQuery{ParentID: "parent"}, func(job *Task) error {
+		ids[job.ID] = true
+		return nil
+	}))
+	assert.Equal(t, jobPageSize+10, len(ids))
+}
+
+func TestRenewLease(t *testing.T) {
+	store := &memoryJobStore{jobs: map[string]*Task{}}
+	now := time.Now()
+	assert.Nil(t, store.Create(&Task{ORMObjectBase: orm.ORMObjectBase{ID: "job", Created: &now}, Status: StatusReady, NextRunTime: &now}))
+	job, err := store.Claim(nil, "worker1", time.Second)
+	assert.Nil(t, err)
+	ctx := &JobContext{Job: job}
+
+	assert.True(t, renewLease(store, ctx, "worker1", time.Minute))
+	job, _ = store.Get("job")
+	assert.True(t, job.LeaseExpire.After(now.Add(30*time.Second)))
+
+	//the lease of another worker is not renewed
+	assert.False(t, renewLease(store, ctx, "worker2", time.Minute))
+
+	//canceled while running, the renewal keeps pending_stop
+	_, err = store.Modify("job", func(job *Task) bool {
+		job.Status = StatusPendingStop
+		return true
+	})
+	assert.Nil(t, err)
+	assert.False(t, renewLease(store, ctx, "worker1", time.Minute))
+	job, _ = store.Get("job")
+	assert.Equal(t, StatusPendingStop, job.Status)
+
+	finishJob(store, ctx, "worker1", nil, false)
+	job, _ = store.Get("job")
+	assert.Equal(t, StatusStopped, job.Status)
+	assert.Equal(t, "", job.LeaseOwner)
+}
+
+func TestRetryDelay(t *testing.T) {
+	defer func(cfg JobConfig) { jobConfig = cfg }(jobConfig)
+	jobConfig.RetryDelayInMs = 100
+	jobConfig.MaxRetryDelayInMs = 500
+	assert.Equal(t, 100*time.Millisecond, retryDelay(1))
+	assert.Equal(t, 400*time.Millisecond, retryDelay(3))
+	assert.Equal(t, 500*time.Millisecond, retryDelay(10))
+}
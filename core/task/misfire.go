@@ -0,0 +1,182 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/task/chrono"
+	"infini.sh/framework/core/util"
+)
+
+// misfire policies, applied to the runs missed while the task was not scheduled, eg: during downtime
+const (
+	//ignore the missed runs
+	MisfireSkip = "skip"
+	//run once for all the missed runs
+	MisfireRunOnce = "run_once"
+	//run every missed run, up to max_catch_up
+	MisfireCatchUp = "catch_up"
+)
+
+const defaultMaxCatchUp = 10
+
+var defaultTimeZone string
+
+// SetDefaultTimeZone sets the time zone of the crontab tasks without time_zone, default to the local time zone
+func SetDefaultTimeZone(name string) error {
+	if _, err := time.LoadLocation(name); err != nil {
+		return errors.Errorf("invalid time zone [%v]: %v", name, err)
+	}
+	defaultTimeZone = name
+	return nil
+}
+
+func (task *ScheduleTask) getTimeZone() string {
+	if task.TimeZone != "" {
+		return task.TimeZone
+	}
+	return defaultTimeZone
+}
+
+func (task *ScheduleTask) getLocation() *time.Location {
+	if name := task.getTimeZone(); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// Validate checks the schedule options of the task
+func (task *ScheduleTask) Validate() error {
+	if task.TimeZone != "" {
+		if _, err := time.LoadLocation(task.TimeZone); err != nil {
+			return errors.Errorf("invalid time zone [%v]: %v", task.TimeZone, err)
+		}
+	}
+	switch task.MisfirePolicy {
+	case "", MisfireSkip, MisfireRunOnce, MisfireCatchUp:
+	default:
+		return errors.Errorf("invalid misfire policy [%v], must be one of: %v, %v, %v", task.MisfirePolicy, MisfireSkip, MisfireRunOnce, MisfireCatchUp)
+	}
+	if task.Jitter != "" {
+		if _, err := time.ParseDuration(task.Jitter); err != nil {
+			return errors.Errorf("invalid jitter [%v]: %v", task.Jitter, err)
+		}
+	}
+	if task.Type == Crontab {
+		if _, err := chrono.ParseCronExpression(task.Crontab); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missedFireTimes returns the fire times between last and now, at most limit of the latest ones are returned
+func (task *ScheduleTask) missedFireTimes(last, now time.Time, limit int) []time.Time {
+	missed := []time.Time{}
+	switch task.Type {
+	case Interval:
+		interval := util.GetDurationOrDefault(task.Interval, defaultInterval)
+		if interval <= 0 {
+			return missed
+		}
+		count := int(now.Sub(last) / interval)
+		start := count - limit + 1
+		if start < 1 {
+			start = 1
+		}
+		for i := start; i <= count; i++ {
+			missed = append(missed, last.Add(time.Duration(i)*interval))
+		}
+	case Crontab:
+		trigger, err := chrono.CreateCronTrigger(task.Crontab, task.getLocation())
+		if err != nil {
+			return missed
+		}
+		for next := trigger.NextTime(last); !next.IsZero() && !next.After(now); next = trigger.NextTime(next) {
+			missed = append(missed, next)
+			if len(missed) > limit {
+				missed = missed[1:]
+			}
+		}
+	}
+	return missed
+}
+
+// runMisfired runs the missed runs of the task according to the misfire policy
+func (task *ScheduleTask) runMisfired(f func(ctx context.Context), last *time.Time) {
+	policy := task.MisfirePolicy
+	if policy == "" || policy == MisfireSkip {
+		return
+	}
+	if last == nil {
+		return
+	}
+
+	limit := task.MaxCatchUp
+	if limit <= 0 {
+		limit = defaultMaxCatchUp
+	}
+	if policy == MisfireRunOnce {
+		//interval tasks fire right after scheduled, which covers the missed runs
+		if task.Type == Interval {
+			return
+		}
+		limit = 1
+	}
+
+	missed := task.missedFireTimes(*last, time.Now(), limit)
+	if len(missed) == 0 {
+		return
+	}
+	log.Infof("task [%v][%v] missed %v run(s) since %v, misfire policy: %v", task.ID, task.Description, len(missed), last.Format(time.RFC3339), policy)
+	go func() {
+		for i := range missed {
+			if task.State == Canceled {
+				return
+			}
+			task.execute(context.Background(), f, &missed[i])
+		}
+	}()
+}
+
+// waitJitter delays the run by a random duration up to the jitter, returns false if the context is done
+func (task *ScheduleTask) waitJitter(ctx context.Context) bool {
+	jitter := util.GetDurationOrDefault(task.Jitter, 0)
+	if jitter <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package task
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/kv/kvtest"
+)
+
+func TestMissedFireTimes(t *testing.T) {
+	last := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := last.Add(35 * time.Minute)
+
+	task := &ScheduleTask{Type: Interval, Interval: "10m"}
+	assert.Equal(t, []time.Time{last.Add(10 * time.Minute), last.Add(20 * time.Minute), last.Add(30 * time.Minute)}, task.missedFireTimes(last, now, 10))
+	//only the latest runs are kept
+	assert.Equal(t, []time.Time{last.Add(30 * time.Minute)}, task.missedFireTimes(last, now, 1))
+	assert.Empty(t, task.missedFireTimes(last, last.Add(5*time.Minute), 10))
+
+	//daily at 09:00 in Shanghai, which is 01:00 in UTC
+	task = &ScheduleTask{Type: Crontab, Crontab: "0 0 9 * * *", TimeZone: "Asia/Shanghai"}
+	missed := task.missedFireTimes(last, last.Add(72*time.Hour), 10)
+	assert.Equal(t, 3, len(missed))
+	assert.True(t, missed[0].Equal(time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)))
+	assert.True(t, missed[2].Equal(time.Date(2024, 1, 3, 1, 0, 0, 0, time.UTC)))
+}
+
+func TestValidateScheduleTask(t *testing.T) {
+	assert.Nil(t, (&ScheduleTask{Type: Crontab, Crontab: "0 0 9 * * *", TimeZone: "UTC", MisfirePolicy: MisfireCatchUp, Jitter: "10s"}).Validate())
+	assert.Error(t, (&ScheduleTask{Type: Interval, TimeZone: "Mars/Base"}).Validate())
+	assert.Error(t, (&ScheduleTask{Type: Interval, MisfirePolicy: "always"}).Validate())
+	assert.Error(t, (&ScheduleTask{Type: Interval, Jitter: "soon"}).Validate())
+	assert.Error(t, (&ScheduleTask{Type: Crontab, Crontab: "0 9 * * *"}).Validate())
+}
+
+func TestRecordRun(t *testing.T) {
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		recordRun(TaskRun{ID: string(rune('a' + i)), TaskID: "test_record_run", StartTime: start, Status: StatusComplete}, start.Add(time.Duration(i)*time.Second), 3)
+	}
+	runs := GetTaskRuns("test_record_run", 0)
+	assert.Equal(t, 3, len(runs))
+	//newest first
+	assert.Equal(t, "e", runs[0].ID)
+	assert.Equal(t, 1, len(GetTaskRuns("test_record_run", 1)))
+	assert.True(t, getLastFireTime("test_record_run").Equal(start.Add(4*time.Second)))
+
+	//update the running run
+	recordRun(TaskRun{ID: "e", TaskID: "test_record_run", Status: StatusError, Error: "failed"}, start, 3)
+	runs = GetTaskRuns("test_record_run", 0)
+	assert.Equal(t, 3, len(runs))
+	assert.Equal(t, StatusError, runs[0].Status)
+}
+
+func TestRegisterScheduleTask(t *testing.T) {
+	_, err := RegisterScheduleTask(ScheduleTask{Description: "test invalid task", Interval: "1m", MisfirePolicy: "always"})
+	assert.Error(t, err)
+
+	//the id is stable across restarts, the same tasks are numbered
+	id1, err := RegisterScheduleTask(ScheduleTask{Description: "test stable id", Interval: "1m"})
+	assert.Nil(t, err)
+	defer DeleteTask(id1)
+	id2, err := RegisterScheduleTask(ScheduleTask{Description: "test stable id", Interval: "1m"})
+	assert.Nil(t, err)
+	defer DeleteTask(id2)
+	assert.Equal(t, id1+"-2", id2)
+
+	DeleteTask(id1)
+	id, err := RegisterScheduleTask(ScheduleTask{Description: "test stable id", Interval: "1m"})
+	assert.Nil(t, err)
+	assert.Equal(t, id1, id)
+}
+
+func TestExecutePanic(t *testing.T) {
+	task := &ScheduleTask{ID: "test_execute_panic"}
+	task.execute(context.Background(), func(ctx context.Context) { panic(errors.New("broken")) }, nil)
+	task.execute(context.Background(), func(ctx context.Context) { panic(1) }, nil)
+	runs := GetTaskRuns(task.ID, 0)
+	if assert.Equal(t, 2, len(runs)) {
+		assert.Equal(t, StatusError, runs[0].Status)
+		assert.Equal(t, "1", runs[0].Error)
+		assert.Equal(t, StatusError, runs[1].Status)
+	}
+	removeHistory(task.ID)
+}
+
+func TestFlushHistories(t *testing.T) {
+	kvtest.Register("task_test")
+	start := time.Now()
+	recordRun(TaskRun{ID: "a", TaskID: "test_flush_history", StartTime: start, Status: StatusRunning}, start, 3)
+	exists, _ := kv.ExistsKey(historyBucket, []byte("test_flush_history"))
+	assert.False(t, exists)
+
+	flushHistories()
+	exists, _ = kv.ExistsKey(historyBucket, []byte("test_flush_history"))
+	assert.True(t, exists)
+
+	//reloaded from the kv store after removed from the cache
+	removeHistory("test_flush_history")
+	historyLock.Lock()
+	_, cached := histories["test_flush_history"]
+	historyLock.Unlock()
+	assert.False(t, cached)
+	assert.Equal(t, 1, len(GetTaskRuns("test_flush_history", 0)))
+}
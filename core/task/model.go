@@ -46,6 +46,13 @@ type Task struct {
 	ConfigString      string     `json:"config_string" elastic_mapping:"config_string:{ type: text }"`
 	CompletedTime     *time.Time `json:"completed_time,omitempty" elastic_mapping:"completed_time: { type: date }"`
 	RetryTimes        int        `json:"retry_times,omitempty" elastic_mapping:"retry_times: { type: integer }"`
+	// used by jobs, see EnqueueJob
+	MaxRetries  int          `json:"max_retries,omitempty" elastic_mapping:"max_retries: { type: integer }"`
+	NextRunTime *time.Time   `json:"next_run_time,omitempty" elastic_mapping:"next_run_time: { type: date }"`
+	LeaseOwner  string       `json:"lease_owner,omitempty" elastic_mapping:"lease_owner: { type: keyword }"`
+	LeaseExpire *time.Time   `json:"lease_expire,omitempty" elastic_mapping:"lease_expire: { type: date }"`
+	Progress    *JobProgress `json:"progress,omitempty" elastic_mapping:"progress: { type: object }"`
+	Result      *TaskResult  `json:"result,omitempty" elastic_mapping:"result: { type: object }"`
 	// DEPRECATED: used by old tasks
 	Config_ interface{} `json:"config,omitempty" elastic_mapping:"config:{type: object,enabled:false }"`
 }
@@ -55,6 +62,12 @@ type Metadata struct {
 	Labels map[string]interface{} `json:"labels" elastic_mapping:"labels: { type: object }"`
 }
 
+type JobProgress struct {
+	Total     int64  `json:"total" elastic_mapping:"total: { type: long }"`
+	Completed int64  `json:"completed" elastic_mapping:"completed: { type: long }"`
+	Message   string `json:"message,omitempty" elastic_mapping:"message: { type: text }"`
+}
+
 type TaskResult struct {
 	Success bool   `json:"success" elastic_mapping:"success: { type: boolean }"`
 	Error   string `json:"error,omitempty" elastic_mapping:"error: { type: text }"`
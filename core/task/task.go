@@ -25,6 +25,7 @@ package task
 
 import (
 	"context"
+	"fmt"
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
@@ -116,6 +117,8 @@ func registerTransientTask(group,tag string, f func(ctx context.Context) error,
 }
 
 type ScheduleTask struct {
+	// the run history and the misfire state are kept by the id, set a fixed one to keep them across the
+	// changes of the description or the registration order, derived from the schedule and the description if not set
 	ID          string     `config:"id" json:"id,omitempty"`
 	Group       string     `config:"group" json:"group,omitempty"`
 	Description string     `config:"description" json:"description,omitempty"`
@@ -129,22 +132,113 @@ type ScheduleTask struct {
 	// Ensures the task runs as a singleton, preventing duplicate executions when previous attempt is not finished.
 	Singleton bool `config:"singleton" json:"singleton,omitempty"`
 
+	// the time zone of the crontab, eg: Asia/Shanghai, default to the time zone of the task module
+	TimeZone string `config:"time_zone" json:"time_zone,omitempty"`
+	// how to handle the runs missed during downtime, skip, run_once or catch_up
+	MisfirePolicy string `config:"misfire_policy" json:"misfire_policy,omitempty"`
+	MaxCatchUp    int    `config:"max_catch_up" json:"max_catch_up,omitempty"`
+	// delay each run by a random duration up to the jitter, eg: 30s
+	Jitter string `config:"jitter" json:"jitter,omitempty"`
+	// the number of runs kept in the history
+	HistorySize int `config:"history_size" json:"history_size,omitempty"`
+
 	Task     func(ctx context.Context) `config:"-" json:"-"`
 	taskItem chrono.ScheduledTask
 	State    State           `config:"state" json:"state,omitempty"`
 	Ctx      context.Context `config:"-" json:"-"` //for transient task
 
 	isTaskRunning  atomic.Bool
+	runFunc        func(ctx context.Context)
+	//the missed runs are exclusive to the scheduled runs, which may run concurrently if not singleton
+	runLock sync.RWMutex
+}
+
+// execute runs the task and records the run in the history, scheduledTime is set for the missed runs
+func (task *ScheduleTask) execute(ctx context.Context, f func(ctx context.Context), scheduledTime *time.Time) {
+
+	//for scheduled task, you may need to prevent task rerun
+	if task.Singleton {
+		//task should be running in single instance
+		if !task.isTaskRunning.CompareAndSwap(false, true) {
+			log.Debugf("task [%v][%v] should be running in single instance, skipping", task.ID, task.Description)
+			return
+		}
+		defer task.isTaskRunning.Store(false)
+	}
+
+	if scheduledTime != nil {
+		task.runLock.Lock()
+		defer task.runLock.Unlock()
+	} else {
+		task.runLock.RLock()
+		defer task.runLock.RUnlock()
+	}
+
+	t := time.Now()
+	task.StartTime = &t
+	task.EndTime = nil
+
+	run := TaskRun{
+		ID:            util.GetUUID(),
+		TaskID:        task.ID,
+		Node:          getNodeID(),
+		ScheduledTime: scheduledTime,
+		Misfire:       scheduledTime != nil,
+		StartTime:     t,
+		Status:        StatusRunning,
+	}
+	fireTime := t
+	if scheduledTime != nil {
+		fireTime = *scheduledTime
+	}
+	recordRun(run, fireTime, task.HistorySize)
+
+	returned := false
+	defer func() {
+		if !global.Env().IsDebug {
+			if r := recover(); r != nil {
+				var v string
+				switch r.(type) {
+				case error:
+					v = r.(error).Error()
+				case runtime.Error:
+					v = r.(runtime.Error).Error()
+				case string:
+					v = r.(string)
+				default:
+					v = fmt.Sprint(r)
+				}
+				log.Error(v)
+				run.Error = v
+			}
+		}
+		//the panic is not recovered in debug mode
+		if !returned && run.Error == "" {
+			run.Error = "task panicked"
+		}
+		t := time.Now()
+		task.EndTime = &t
+		task.State = Finished
+		run.EndTime = &t
+		run.DurationInMs = t.Sub(run.StartTime).Milliseconds()
+		run.Status = StatusComplete
+		if run.Error != "" {
+			run.Status = StatusError
+		}
+		recordRun(run, fireTime, task.HistorySize)
+	}()
+
+	f(ctx)
+	returned = true
 }
 
 const Interval = "interval"
 const Crontab = "crontab"
 const Transient = "transient"
 
-func RegisterScheduleTask(task ScheduleTask) (taskID string) {
-	if task.ID == "" {
-		task.ID = util.GetUUID()
-	}
+// RegisterScheduleTask registers and schedules the task, the id is derived from the schedule and the
+// description if not set, so that the run history and the misfire policy survive the restarts
+func RegisterScheduleTask(task ScheduleTask) (taskID string, err error) {
 	task.CreateTime = time.Now()
 	task.State = Pending
 	if task.Type == "" && task.Interval != "" {
@@ -153,45 +247,23 @@ func RegisterScheduleTask(task ScheduleTask) (taskID string) {
 		task.Type = Crontab
 	}
 
-	tempTask := task.Task
-	task.Task = func(ctx context.Context) {
+	if err := task.Validate(); err != nil {
+		return "", errors.Errorf("invalid task [%v][%v]: %v", task.ID, task.Description, err)
+	}
 
-		//for scheduled task, you may need to prevent task rerun
-		if task.Singleton{
-			//task should be running in single instance
-			if !task.isTaskRunning.CompareAndSwap(false, true) {
-				log.Debugf("task [%v][%v] should be running in single instance, skipping",task.ID,task.Description)
-				return
-			}
+	registerLock.Lock()
+	defer registerLock.Unlock()
+	if task.ID == "" {
+		task.ID = stableTaskID(&task)
+	}
 
-			defer func() {
-				if !global.Env().IsDebug {
-					if r := recover(); r != nil {
-						var v string
-						switch r.(type) {
-						case error:
-							v = r.(error).Error()
-						case runtime.Error:
-							v = r.(runtime.Error).Error()
-						case string:
-							v = r.(string)
-						}
-						log.Error(v)
-					}
-				}
-				task.isTaskRunning.Store(false)
-			}()
+	tempTask := task.Task
+	task.runFunc = tempTask
+	task.Task = func(ctx context.Context) {
+		if !task.waitJitter(ctx) {
+			return
 		}
-
-		t := time.Now()
-		task.StartTime = &t
-		task.EndTime = nil
-
-		tempTask(ctx)
-
-		t = time.Now()
-		task.EndTime = &t
-		task.State = Finished
+		task.execute(ctx, tempTask, nil)
 	}
 
 	_, ok := Tasks.Load(task.ID)
@@ -206,7 +278,22 @@ func RegisterScheduleTask(task ScheduleTask) (taskID string) {
 		runTask(&task)
 	}
 
-	return task.ID
+	return task.ID, nil
+}
+
+var registerLock sync.Mutex
+
+// stableTaskID derives the id from the schedule and the description, the tasks registered
+// with the same schedule and description are numbered in the order of registration
+func stableTaskID(task *ScheduleTask) string {
+	base := util.MD5digest(fmt.Sprintf("%v|%v|%v|%v|%v", task.Group, task.Type, task.Interval, task.Crontab, task.Description))
+	id := base
+	for i := 2; ; i++ {
+		if _, ok := Tasks.Load(id); !ok {
+			return id
+		}
+		id = fmt.Sprintf("%v-%v", base, i)
+	}
 }
 
 var quit = make(chan struct{})
@@ -216,6 +303,7 @@ var started bool
 
 func RunTasks() {
 	started = true
+	go flushHistoriesLoop()
 	Tasks.Range(func(key, value any) bool {
 		task, ok := value.(*ScheduleTask)
 		if ok {
@@ -234,6 +322,12 @@ func runTask(task *ScheduleTask) {
 		log.Debug("scheduled task:", task.ID, ",", task.Type, ",", task.Interval, ",", task.Crontab, ",", task.Description)
 	}
 
+	//read before scheduled, interval tasks fire right away
+	var lastFireTime *time.Time
+	if task.Type != Transient {
+		lastFireTime = getLastFireTime(task.ID)
+	}
+
 	switch task.Type {
 	case Interval:
 		task1, err := taskScheduler.ScheduleAtFixedRate(task.Task, util.GetDurationOrDefault(task.Interval, defaultInterval))
@@ -242,14 +336,20 @@ func runTask(task *ScheduleTask) {
 		}
 		task.State = Running
 		task.taskItem = task1
+		task.runMisfired(task.runFunc, lastFireTime)
 		break
 	case Crontab:
-		task1, err := taskScheduler.ScheduleWithCron(task.Task, task.Crontab)
+		options := []chrono.Option{}
+		if tz := task.getTimeZone(); tz != "" {
+			options = append(options, chrono.WithLocation(tz))
+		}
+		task1, err := taskScheduler.ScheduleWithCron(task.Task, task.Crontab, options...)
 		if err != nil {
-			log.Error("failed to scheduled crontab task:", task.Type, ",", task.Interval, ",", task.Description)
+			log.Error("failed to scheduled crontab task:", task.Type, ",", task.Crontab, ",", task.Description, ",", err)
 		}
 		task.State = Running
 		task.taskItem = task1
+		task.runMisfired(task.runFunc, lastFireTime)
 		break
 	case Transient:
 		//no need to schedule
@@ -317,6 +417,7 @@ func StopAllTasks() {
 func DeleteTask(id string) {
 	StopTask(id)
 	Tasks.Delete(id)
+	removeHistory(id)
 }
 
 func StopTasks() {
@@ -326,4 +427,5 @@ func StopTasks() {
 	<-shutdownChannel
 
 	close(quit)
+	flushHistories()
 }
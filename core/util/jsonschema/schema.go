@@ -0,0 +1,347 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+// Package jsonschema generates JSON Schema documents from Go types by reflection
+package jsonschema
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a subset of JSON Schema, it is also compatible with the OpenAPI 3 schema object
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+// Field describes how a struct field is mapped to a property
+type Field struct {
+	Name      string
+	OmitEmpty bool
+	Skip      bool
+	Inline    bool
+}
+
+// FieldMapper maps a struct field to the property name
+type FieldMapper func(f reflect.StructField) Field
+
+// Reflector builds schemas of go types, named structs are collected into Definitions
+// and referenced with RefPrefix, so that recursive types are supported
+type Reflector struct {
+	RefPrefix   string
+	Definitions map[string]*Schema
+	FieldMapper FieldMapper
+
+	//inline every struct instead of referencing the definitions
+	ExpandStructs bool
+
+	//set additionalProperties to false for structs
+	DisallowAdditionalProperties bool
+
+	//called after the schema of a field is generated, eg: to apply defaults or enums
+	FieldHook func(f reflect.StructField, schema *Schema)
+
+	//overrides the schema of specify types, return nil to use the default one
+	TypeMapper func(t reflect.Type) *Schema
+
+	//decides whether a field is required, by default only the fields tagged with `validate:"required"`
+	//or `jsonschema:"required"` are required, see RequiredByTag
+	IsRequired func(f reflect.StructField, field Field) bool
+
+	inProgress map[reflect.Type]bool
+	//names of the definitions, the types of different packages with the same name are suffixed, eg: api.Config_2
+	names map[reflect.Type]string
+	types map[string]reflect.Type
+}
+
+func New(refPrefix string) *Reflector {
+	return &Reflector{
+		RefPrefix:   refPrefix,
+		Definitions: map[string]*Schema{},
+		FieldMapper: TagFieldMapper("json"),
+		inProgress:  map[reflect.Type]bool{},
+		names:       map[reflect.Type]string{},
+		types:       map[string]reflect.Type{},
+	}
+}
+
+// TagFieldMapper maps the field with the specify struct tag, eg: json or config
+func TagFieldMapper(tagName string) FieldMapper {
+	return func(f reflect.StructField) Field {
+		field := Field{Name: f.Name}
+		tag, ok := f.Tag.Lookup(tagName)
+		if tag == "-" {
+			field.Skip = true
+			return field
+		}
+		if ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				field.Name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				switch opt {
+				case "omitempty":
+					field.OmitEmpty = true
+				case "inline":
+					field.Inline = true
+				}
+			}
+		}
+		if f.Anonymous && (!ok || field.Inline) {
+			field.Inline = true
+		}
+		return field
+	}
+}
+
+// Reflect return the schema of the object
+func (r *Reflector) Reflect(v interface{}) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	if t, ok := v.(reflect.Type); ok {
+		return r.ReflectType(t)
+	}
+	return r.ReflectType(reflect.TypeOf(v))
+}
+
+var (
+	timeType           = reflect.TypeOf(time.Time{})
+	durationType       = reflect.TypeOf(time.Duration(0))
+	jsonMarshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	rawMessageType     = reflect.TypeOf(json.RawMessage{})
+	emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// ReflectType return the schema of the type
+func (r *Reflector) ReflectType(t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+
+	schema := r.reflectType(t)
+	if nullable && schema.Ref == "" {
+		schema.Nullable = true
+	}
+	return schema
+}
+
+func (r *Reflector) reflectType(t reflect.Type) *Schema {
+	if r.TypeMapper != nil {
+		if s := r.TypeMapper(t); s != nil {
+			return s
+		}
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64"}
+	case rawMessageType, emptyInterfaceType:
+		return &Schema{}
+	}
+
+	//custom marshalers may produce anything
+	if t.Kind() != reflect.String && (t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType)) {
+		return &Schema{}
+	}
+	if t.Kind() == reflect.Struct && (t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType)) {
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: r.ReflectType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: r.ReflectType(t.Elem())}
+	case reflect.Struct:
+		return r.reflectStruct(t)
+	case reflect.Interface:
+		return &Schema{}
+	}
+
+	//chan, func and other unsupported types
+	return &Schema{}
+}
+
+// TypeName return the name of the type used in definitions, eg: pipeline.PipelineStatus
+func TypeName(t reflect.Type) string {
+	if t.Name() == "" {
+		return ""
+	}
+	name := t.Name()
+	//generic types contains brackets
+	name = strings.NewReplacer("[", "_", "]", "", "*", "", "/", "_", ",", "_", " ", "").Replace(name)
+	if t.PkgPath() != "" {
+		return path.Base(t.PkgPath()) + "." + name
+	}
+	return name
+}
+
+// definitionName return the unique name of the type in definitions
+func (r *Reflector) definitionName(t reflect.Type) string {
+	if name, ok := r.names[t]; ok {
+		return name
+	}
+	base := TypeName(t)
+	name := base
+	for i := 2; ; i++ {
+		if _, ok := r.types[name]; !ok {
+			break
+		}
+		name = fmt.Sprintf("%v_%v", base, i)
+	}
+	r.names[t] = name
+	r.types[name] = t
+	return name
+}
+
+func (r *Reflector) reflectStruct(t reflect.Type) *Schema {
+	if TypeName(t) == "" || r.ExpandStructs {
+		if r.inProgress[t] {
+			//recursive anonymous struct, stop here
+			return &Schema{Type: "object"}
+		}
+		r.inProgress[t] = true
+		defer delete(r.inProgress, t)
+		return r.structSchema(t)
+	}
+
+	name := r.definitionName(t)
+	ref := &Schema{Ref: r.RefPrefix + name}
+	if _, ok := r.Definitions[name]; ok || r.inProgress[t] {
+		return ref
+	}
+
+	r.inProgress[t] = true
+	schema := r.structSchema(t)
+	delete(r.inProgress, t)
+	r.Definitions[name] = schema
+	return ref
+}
+
+func (r *Reflector) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	if r.DisallowAdditionalProperties {
+		schema.AdditionalProperties = false
+	}
+	r.collectFields(t, schema)
+	return schema
+}
+
+func (r *Reflector) collectFields(t reflect.Type, schema *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		field := r.FieldMapper(f)
+		if field.Skip {
+			continue
+		}
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		//promote the fields of embedded structs
+		if field.Inline && ft.Kind() == reflect.Struct {
+			r.collectFields(ft, schema)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			//unexported
+			continue
+		}
+
+		s := r.ReflectType(f.Type)
+		if r.FieldHook != nil {
+			if s.Ref != "" {
+				//do not touch the shared definition
+				s = &Schema{Ref: s.Ref}
+			}
+			r.FieldHook(f, s)
+		}
+		schema.Properties[field.Name] = s
+
+		isRequired := r.IsRequired
+		if isRequired == nil {
+			isRequired = RequiredByTag
+		}
+		if isRequired(f, field) {
+			schema.Required = append(schema.Required, field.Name)
+		}
+	}
+}
+
+// RequiredByTag requires the fields explicitly tagged with `validate:"required"` or `jsonschema:"required"`,
+// a field without omitempty may still be absent from the input, eg: the request bodies decoded into zero values
+func RequiredByTag(f reflect.StructField, field Field) bool {
+	for _, tag := range []string{"validate", "jsonschema"} {
+		for _, opt := range strings.Split(f.Tag.Get(tag), ",") {
+			if strings.TrimSpace(opt) == "required" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package jsonschema
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type base struct {
+	ID string `json:"id" jsonschema:"required"`
+}
+
+type node struct {
+	base
+	Name     string            `json:"name,omitempty"`
+	Created  time.Time         `json:"created"`
+	Parent   *node             `json:"parent,omitempty"`
+	Children []node            `json:"children" validate:"required"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Ignored  string            `json:"-"`
+	internal int
+}
+
+func TestReflect(t *testing.T) {
+	r := New("#/definitions/")
+	schema := r.Reflect(&node{})
+	assert.Equal(t, "#/definitions/jsonschema.node", schema.Ref)
+
+	def := r.Definitions["jsonschema.node"]
+	assert.NotNil(t, def)
+	assert.Equal(t, "object", def.Type)
+	assert.Equal(t, "string", def.Properties["id"].Type)
+	assert.Equal(t, "date-time", def.Properties["created"].Format)
+	assert.Equal(t, "#/definitions/jsonschema.node", def.Properties["parent"].Ref)
+	assert.Equal(t, "array", def.Properties["children"].Type)
+	assert.Equal(t, "#/definitions/jsonschema.node", def.Properties["children"].Items.Ref)
+	assert.Equal(t, "string", def.Properties["labels"].AdditionalProperties.(*Schema).Type)
+	assert.Nil(t, def.Properties["Ignored"])
+	assert.Nil(t, def.Properties["internal"])
+	assert.Equal(t, []string{"id", "children"}, def.Required)
+}
+
+// packageBase is the base above, so that it could be referenced besides the local one with the same name
+type packageBase = base
+
+func TestReflectSameTypeName(t *testing.T) {
+	type base struct {
+		Name string `json:"name"`
+	}
+	r := New("#/definitions/")
+	assert.Equal(t, "#/definitions/jsonschema.base", r.Reflect(packageBase{}).Ref)
+	assert.Equal(t, "#/definitions/jsonschema.base_2", r.Reflect(base{}).Ref)
+	assert.Equal(t, "#/definitions/jsonschema.base", r.Reflect(&packageBase{}).Ref)
+	assert.Equal(t, "string", r.Definitions["jsonschema.base"].Properties["id"].Type)
+	assert.Equal(t, "string", r.Definitions["jsonschema.base_2"].Properties["name"].Type)
+}
+
+func TestReflectConfigTag(t *testing.T) {
+	type config struct {
+		BatchSize int    `config:"batch_size_in_kb"`
+		Queue     string `config:"queue"`
+	}
+	r := New("#/definitions/")
+	r.FieldMapper = TagFieldMapper("config")
+	r.ExpandStructs = true
+	r.DisallowAdditionalProperties = true
+	schema := r.Reflect(config{})
+	assert.Equal(t, "integer", schema.Properties["batch_size_in_kb"].Type)
+	assert.Equal(t, false, schema.AdditionalProperties)
+	assert.Equal(t, 0, len(r.Definitions))
+}
@@ -0,0 +1,176 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package jsonschema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationError describes a value which doesn't match the schema, Path is the dotted path of the value
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%v: %v", e.Path, e.Message)
+}
+
+// Validate checks the decoded value (maps, slices and scalars) against the schema, it checks the
+// unknown properties, required properties, enums and the object and array structures, references
+// are not followed, use ExpandStructs to validate nested structs
+func Validate(schema *Schema, value interface{}) []ValidationError {
+	errs := []ValidationError{}
+	validate(schema, "", value, &errs)
+	return errs
+}
+
+func validate(schema *Schema, path string, value interface{}, errs *[]ValidationError) {
+	if schema == nil || value == nil {
+		return
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("invalid value [%v], must be one of %v", value, schema.Enum)})
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			if len(schema.Properties) > 0 {
+				*errs = append(*errs, ValidationError{Path: path, Message: fmt.Sprintf("expect an object, but got [%v]", value)})
+			}
+			return
+		}
+
+		for _, k := range schema.Required {
+			if _, ok := obj[k]; !ok {
+				*errs = append(*errs, ValidationError{Path: join(path, k), Message: "missing required property"})
+			}
+		}
+
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if s, ok := schema.Properties[k]; ok {
+				validate(s, join(path, k), obj[k], errs)
+				continue
+			}
+			switch v := schema.AdditionalProperties.(type) {
+			case bool:
+				if !v {
+					*errs = append(*errs, ValidationError{Path: join(path, k), Message: "unknown property" + suggest(k, schema.Properties)})
+				}
+			case *Schema:
+				validate(v, join(path, k), obj[k], errs)
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			//a single value is allowed for arrays
+			validate(schema.Items, path, value, errs)
+			return
+		}
+		for i, v := range arr {
+			validate(schema.Items, fmt.Sprintf("%v[%d]", path, i), v, errs)
+		}
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	str := fmt.Sprint(value)
+	for _, v := range enum {
+		if fmt.Sprint(v) == str {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest returns the closest known property, for typos like batch_size_in_kbs
+func suggest(key string, properties map[string]*Schema) string {
+	best := ""
+	bestDistance := len(key)/2 + 1
+	for k := range properties {
+		d := levenshtein(key, k)
+		if d < bestDistance || (d == bestDistance && k < best) {
+			best = k
+			bestDistance = d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean [%v]?", best)
+}
+
+func levenshtein(a, b string) int {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,96 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package jsonschema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bulkConfig struct {
+	Name          string            `config:"name" validate:"required"`
+	BatchSizeInKB int               `config:"batch_size_in_kb"`
+	Mode          string            `config:"mode" enum:"sync,async"`
+	Tags          []string          `config:"tags"`
+	Labels        map[string]string `config:"labels"`
+	Output        struct {
+		Queue string `config:"queue"`
+	} `config:"output"`
+}
+
+func newStrictReflector() *Reflector {
+	r := New("")
+	r.FieldMapper = TagFieldMapper("config")
+	r.ExpandStructs = true
+	r.DisallowAdditionalProperties = true
+	r.IsRequired = func(f reflect.StructField, field Field) bool {
+		return strings.Contains(f.Tag.Get("validate"), "required")
+	}
+	r.FieldHook = func(f reflect.StructField, s *Schema) {
+		if v := f.Tag.Get("enum"); v != "" {
+			for _, x := range strings.Split(v, ",") {
+				s.Enum = append(s.Enum, x)
+			}
+		}
+	}
+	return r
+}
+
+func TestValidate(t *testing.T) {
+	schema := newStrictReflector().Reflect(bulkConfig{})
+	assert.Equal(t, []string{"name"}, schema.Required)
+
+	errs := Validate(schema, map[string]interface{}{
+		"name":             "bulk",
+		"batch_size_in_kb": 10,
+		"mode":             "async",
+		"tags":             []interface{}{"a", "b"},
+		"labels":           map[string]interface{}{"any": "value"},
+		"output":           map[string]interface{}{"queue": "q1"},
+	})
+	assert.Equal(t, 0, len(errs))
+
+	errs = Validate(schema, map[string]interface{}{
+		"batch_size_in_kbs": 10,
+		"mode":              "none",
+		"output":            map[string]interface{}{"queues": "q1"},
+	})
+	assert.Equal(t, 4, len(errs))
+
+	msgs := []string{}
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	assert.Contains(t, msgs, "name: missing required property")
+	assert.Contains(t, msgs, "batch_size_in_kbs: unknown property, did you mean [batch_size_in_kb]?")
+	assert.Contains(t, msgs, "output.queues: unknown property, did you mean [queue]?")
+	assert.Contains(t, msgs, "mode: invalid value [none], must be one of [sync async]")
+}
@@ -47,14 +47,17 @@
 package util
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
+	"io"
 	"math/big"
 	"sort"
 "strings"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v2"
 )
 
 type DiffStatus int
@@ -253,3 +256,53 @@ func adjustFormat(s string) string {
 	return s
 }
 
+// LoadYaml parses the (multi-document) yaml content into a list can be diffed by Do
+func LoadYaml(content []byte) (RawYamlList, error) {
+	var result RawYamlList
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var out interface{}
+		err := decoder.Decode(&out)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			continue
+		}
+		result = append(result, newRawYaml(normalizeYaml(out)))
+	}
+	return result, nil
+}
+
+// normalizeYaml converts the yaml maps to map[string]interface{}
+func normalizeYaml(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for k, v1 := range x {
+			m[fmt.Sprint(k)] = normalizeYaml(v1)
+		}
+		return m
+	case []interface{}:
+		for i, v1 := range x {
+			x[i] = normalizeYaml(v1)
+		}
+		return x
+	}
+	return v
+}
+
+// String returns the changed parts of the diffs
+func (d Diffs) String() string {
+	result := strings.Builder{}
+	for _, v := range d {
+		if v.Status == DiffStatusSame {
+			continue
+		}
+		result.WriteString(v.Diff)
+	}
+	return result.String()
+}
@@ -29,6 +29,7 @@ package util
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -54,3 +55,27 @@ func TestDiffYaml(t *testing.T) {
 	fmt.Println(log)
 	fmt.Println(err)
 }
+
+func TestLoadYamlDiff(t *testing.T) {
+	list1, err := LoadYaml([]byte("name: a\nport: 8080\n---\nname: b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list2, err := LoadYaml([]byte("name: a\nport: 9090\n---\nname: b\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list1) != 2 || len(list2) != 2 {
+		t.Fatalf("unexpected documents: %v, %v", len(list1), len(list2))
+	}
+
+	diffs := Do(list1, list2)
+	str := diffs.String()
+	if !strings.Contains(str, "8080") || !strings.Contains(str, "9090") {
+		t.Fatalf("unexpected diff: %v", str)
+	}
+
+	if Do(list1, list1).String() != "" {
+		t.Fatal("same yaml should have no changes")
+	}
+}
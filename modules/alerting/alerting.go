@@ -0,0 +1,165 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package alerting
+
+import (
+	"infini.sh/framework/core/alerting"
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/security"
+	"infini.sh/framework/core/util"
+	"net/http"
+)
+
+type AlertingModule struct {
+	api.Handler
+	config alerting.Config
+	engine *alerting.Engine
+}
+
+func (module *AlertingModule) Name() string {
+	return "alerting"
+}
+
+func init() {
+	config.RegisterSectionSchema("alerting", alerting.DefaultConfig())
+}
+
+func (module *AlertingModule) Setup() {
+	module.config = alerting.DefaultConfig()
+	ok, err := env.ParseConfig("alerting", &module.config)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+		panic(err)
+	}
+	if !module.config.Enabled {
+		return
+	}
+
+	module.engine, err = alerting.NewEngine(module.config)
+	if err != nil {
+		panic(err)
+	}
+
+	api.HandleAPIMethod(api.GET, "/alerting/rules", module.getRules,
+		api.WithSummary("List alerting rules with the state of their alerts"),
+		api.WithResponse([]alerting.RuleStatus{}))
+	api.HandleAPIMethod(api.POST, "/alerting/rule/:id/_evaluate", module.evaluateRule,
+		api.WithSummary("Evaluate the alerting rule immediately"),
+		api.WithPathParam("id", "rule id"),
+		api.RequirePermission("alerting:update"))
+	api.HandleAPIMethod(api.GET, "/alerting/alerts", module.getAlerts,
+		api.WithSummary("List pending and firing alerts"),
+		api.WithResponse([]alerting.Alert{}))
+	api.HandleAPIMethod(api.GET, "/alerting/history", module.getHistory,
+		api.WithSummary("Get the alert history, newest first"),
+		api.WithQueryParam("rule_id", "string", "only the history of the rule", false),
+		api.WithQueryParam("size", "integer", "max num of entries, default 100", false),
+		api.WithResponse([]alerting.HistoryEntry{}))
+	api.HandleAPIMethod(api.GET, "/alerting/silences", module.getSilences,
+		api.WithSummary("List silences"),
+		api.WithResponse([]alerting.Silence{}))
+	api.HandleAPIMethod(api.POST, "/alerting/silences", module.addSilence,
+		api.WithSummary("Mute the notifications of matched alerts within the time range"),
+		api.WithRequest(alerting.Silence{}),
+		api.RequirePermission("alerting:update"),
+		api.WithAudit("alerting:silence", ""))
+	api.HandleAPIMethod(api.DELETE, "/alerting/silence/:id", module.deleteSilence,
+		api.WithSummary("Expire the silence"),
+		api.WithPathParam("id", "silence id"),
+		api.RequirePermission("alerting:update"),
+		api.WithAudit("alerting:unsilence", "silence/{id}"))
+}
+
+func (module *AlertingModule) Start() error {
+	if module.engine == nil {
+		return nil
+	}
+	return module.engine.Start()
+}
+
+func (module *AlertingModule) Stop() error {
+	if module.engine != nil {
+		module.engine.Stop()
+	}
+	return nil
+}
+
+func (module *AlertingModule) getRules(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	module.WriteJSON(w, module.engine.GetRules(), http.StatusOK)
+}
+
+func (module *AlertingModule) evaluateRule(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if err := module.engine.Evaluate(ps.ByName("id")); err != nil {
+		module.WriteError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	module.WriteAckOKJSON(w)
+}
+
+func (module *AlertingModule) getAlerts(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	module.WriteJSON(w, module.engine.GetAlerts(), http.StatusOK)
+}
+
+func (module *AlertingModule) getHistory(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	size := module.GetIntOrDefault(req, "size", 100)
+	module.WriteJSON(w, module.engine.GetHistory(module.GetParameter(req, "rule_id"), size), http.StatusOK)
+}
+
+func (module *AlertingModule) getSilences(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	module.WriteJSON(w, module.engine.GetSilences(), http.StatusOK)
+}
+
+func (module *AlertingModule) addSilence(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	obj := alerting.Silence{}
+	if err := module.DecodeJSON(req, &obj); err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	//the authenticated user takes precedence over the claimed one
+	if user := security.GetPrincipal(req); user != "" {
+		obj.CreatedBy = user
+	}
+	silence, err := module.engine.AddSilence(obj)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	api.SetAuditTarget(req, "silence/"+silence.ID)
+	module.WriteAckJSON(w, true, http.StatusOK, util.MapStr{"id": silence.ID})
+}
+
+func (module *AlertingModule) deleteSilence(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !module.engine.DeleteSilence(ps.ByName("id")) {
+		module.WriteAckJSON(w, false, http.StatusNotFound, util.MapStr{"error": "silence not found"})
+		return
+	}
+	module.WriteAckOKJSON(w)
+}
@@ -46,6 +46,7 @@ import (
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/host"
 	"infini.sh/framework/core/model"
+	"infini.sh/framework/core/pubsub"
 	"infini.sh/framework/core/util"
 	"net/http"
 	"sort"
@@ -61,6 +62,27 @@ func init() {
 	api.HandleAPIMethod(api.GET, "/_version", versionAPIHandler)
 	api.HandleAPIMethod(api.GET, "/_info", infoAPIHandler)
 	api.HandleAPIMethod(api.GET, "/health", healthAPIHandler)
+	api.HandleAPIMethod(api.GET, "/_openapi.json", openAPIHandler, api.WithSummary("OpenAPI document of registered apis"))
+	api.HandleAPIMethod(api.GET, "/_events", api.EventStreamHandler,
+		api.WithSummary("Subscribe topics as server-sent events"),
+		api.WithQueryParam("topics", "string", "comma separated topics, wildcard supported, eg: pipeline.state,queue.*", true),
+		api.WithQueryParam("buffer_size", "integer", "max num of pending events", false),
+		api.WithQueryParam("overflow", "string", "drop_oldest, drop_newest or disconnect", false))
+	api.HandleAPIMethod(api.GET, "/_events/topics", topicsAPIHandler,
+		api.WithSummary("List available topics"),
+		api.WithResponse([]pubsub.Topic{}))
+}
+
+func topicsAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(util.MustToJSONBytes(pubsub.GetTopics()))
+	w.WriteHeader(200)
+}
+
+func openAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(util.MustToJSONBytes(api.GenerateOpenAPISpec()))
+	w.WriteHeader(200)
 }
 
 func whoisAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -167,8 +189,7 @@ func (module *APIModule) Setup() {
 }
 
 func (module *APIModule) Start() error {
-	api.StartAPI()
-	return nil
+	return api.StartAPI()
 }
 
 func (module *APIModule) Stop() error {
@@ -0,0 +1,99 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/audit"
+	"infini.sh/framework/core/i18n"
+	"net/http"
+	"time"
+)
+
+func init() {
+	api.HandleAPIMethod(api.GET, "/_audit/_search", searchAuditAPIHandler,
+		api.WithSummary("Search the audit log, newest first"),
+		api.WithQueryParam("actor", "string", "user who made the call", false),
+		api.WithQueryParam("action", "string", "action, prefix ending with * supported, eg: pipeline:*", false),
+		api.WithQueryParam("target", "string", "part of the target", false),
+		api.WithQueryParam("outcome", "string", "success or failure", false),
+		api.WithQueryParam("start", "string", "start time in RFC3339, inclusive", false),
+		api.WithQueryParam("end", "string", "end time in RFC3339, exclusive", false),
+		api.WithQueryParam("from", "integer", "offset of the records", false),
+		api.WithQueryParam("size", "integer", "max num of records, default 20", false),
+		api.WithResponse(audit.SearchResult{}),
+		api.RequirePermission("audit:read"))
+	api.HandleAPIMethod(api.GET, "/_audit/_verify", verifyAuditAPIHandler,
+		api.WithSummary("Verify the hash chain of the audit log"),
+		api.WithResponse(audit.VerifyResult{}),
+		api.RequirePermission("audit:read"))
+}
+
+func searchAuditAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !audit.IsEnabled() {
+		api.DefaultAPI.WriteError(w, "audit.api.not_enabled", http.StatusNotFound)
+		return
+	}
+	q := audit.Query{
+		Actor:   api.DefaultAPI.GetParameter(req, "actor"),
+		Action:  api.DefaultAPI.GetParameter(req, "action"),
+		Target:  api.DefaultAPI.GetParameter(req, "target"),
+		Outcome: api.DefaultAPI.GetParameter(req, "outcome"),
+		From:    api.DefaultAPI.GetIntOrDefault(req, "from", 0),
+		Size:    api.DefaultAPI.GetIntOrDefault(req, "size", 20),
+	}
+	var err error
+	for k, t := range map[string]*time.Time{"start": &q.Start, "end": &q.End} {
+		if v := api.DefaultAPI.GetParameter(req, k); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				api.DefaultAPI.WriteLocalizedError(w, i18n.NewError("api.invalid_parameter", i18n.Params{"name": k, "error": err}), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	result, err := audit.Search(q)
+	if err != nil {
+		api.DefaultAPI.Error(w, err)
+		return
+	}
+	api.DefaultAPI.WriteOKJSON(w, result)
+}
+
+func verifyAuditAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	if !audit.IsEnabled() {
+		api.DefaultAPI.WriteError(w, "audit.api.not_enabled", http.StatusNotFound)
+		return
+	}
+	result, err := audit.Verify()
+	if err != nil {
+		api.DefaultAPI.Error(w, err)
+		return
+	}
+	api.DefaultAPI.WriteOKJSON(w, result)
+}
@@ -0,0 +1,102 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/util"
+)
+
+func init() {
+	api.HandleAPIMethod(api.GET, "/_event/schemas", eventSchemasAPIHandler,
+		api.WithSummary("List the latest version of the event schemas"))
+	api.HandleAPIMethod(api.GET, "/_event/schema/:category/:name", eventSchemaAPIHandler,
+		api.WithSummary("Get all the versions of an event schema"))
+	api.HandleAPIMethod(api.GET, "/_event/_template", eventTemplateAPIHandler,
+		api.WithSummary("Generate the elasticsearch index template of the events"),
+		api.WithQueryParam("index_pattern", "string", "comma separated index patterns, default: metrics*", false),
+		api.WithQueryParam("order", "integer", "order of the template, default: 1", false))
+	api.HandleAPIMethod(api.POST, "/_event/_validate", validateEventAPIHandler,
+		api.WithSummary("Validate an event against its schema without saving it"),
+		api.WithoutAudit())
+}
+
+func eventSchemasAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	schemas := event.GetSchemas()
+	api.DefaultAPI.WriteJSON(w, util.MapStr{
+		"validation": event.GetSchemaValidation(),
+		"total":      len(schemas),
+		"schemas":    schemas,
+	}, http.StatusOK)
+}
+
+func eventSchemaAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	versions := event.GetSchemaVersions(ps.ByName("category"), ps.ByName("name"))
+	if len(versions) == 0 {
+		api.DefaultAPI.WriteError(w, "event.api.schema_not_found", http.StatusNotFound)
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, util.MapStr{
+		"category": ps.ByName("category"),
+		"name":     ps.ByName("name"),
+		"versions": versions,
+	}, http.StatusOK)
+}
+
+func eventTemplateAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	patterns := strings.Split(api.DefaultAPI.GetParameterOrDefault(req, "index_pattern", "metrics*"), ",")
+	order := api.DefaultAPI.GetIntOrDefault(req, "order", 1)
+	api.DefaultAPI.WriteJSON(w, event.GetIndexTemplate(patterns, order), http.StatusOK)
+}
+
+func validateEventAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	e := event.Event{}
+	err := api.DefaultAPI.DecodeJSON(req, &e)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result := util.MapStr{"valid": true}
+	if err := event.ValidateEvent(&e); err != nil {
+		result["valid"] = false
+		if v, ok := err.(*event.ValidationError); ok {
+			result["problems"] = v.Problems
+		} else {
+			result["error"] = err.Error()
+		}
+	}
+	if e.Metadata.SchemaVersion > 0 {
+		result["schema_version"] = e.Metadata.SchemaVersion
+	}
+	api.DefaultAPI.WriteJSON(w, result, http.StatusOK)
+}
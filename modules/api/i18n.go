@@ -0,0 +1,84 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package api
+
+import (
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/i18n"
+	"infini.sh/framework/core/util"
+	"net/http"
+)
+
+func init() {
+	api.HandleAPIMethod(api.GET, "/_i18n/locales", localesAPIHandler,
+		api.WithSummary("List available locales and the negotiated locale of the request"))
+	api.HandleAPIMethod(api.GET, "/_i18n/messages", messagesAPIHandler,
+		api.WithSummary("Get the message catalog of a locale"),
+		api.WithQueryParam("locale", "string", "locale of the catalog, negotiated from the request by default", false))
+	api.HandleAPIMethod(api.PUT, "/_i18n/locale", setLocaleAPIHandler,
+		api.WithSummary("Save the preferred locale to session"),
+		api.WithoutAudit())
+}
+
+func localesAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	api.DefaultAPI.WriteJSON(w, util.MapStr{
+		"default": i18n.GetDefaultLocale(),
+		"current": api.GetLocale(req),
+		"locales": i18n.GetLocales(),
+	}, http.StatusOK)
+}
+
+func messagesAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	locale := api.GetLocale(req)
+	api.DefaultAPI.WriteJSON(w, util.MapStr{
+		"locale":   locale,
+		"messages": i18n.GetMessages(locale),
+	}, http.StatusOK)
+}
+
+func setLocaleAPIHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	obj := struct {
+		Locale string `json:"locale"`
+	}{}
+	err := api.DefaultAPI.DecodeJSON(req, &obj)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	locale := i18n.MatchLocale(obj.Locale)
+	if locale == "" {
+		api.DefaultAPI.WriteLocalizedError(w, i18n.NewError("i18n.api.unsupported_locale", i18n.Params{"locale": obj.Locale}), http.StatusBadRequest)
+		return
+	}
+	if !api.SetLocale(w, req, locale) {
+		api.DefaultAPI.Error500(w, "i18n.api.save_failed")
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, util.MapStr{"acknowledged": true, "locale": locale}, http.StatusOK)
+}
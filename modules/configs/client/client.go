@@ -255,7 +255,8 @@ func ListenConfigChanges() error {
 				})
 			} else {
 				log.Debug("register schedule task for checking configs changes")
-				task.RegisterScheduleTask(task.ScheduleTask{
+				_, err := task.RegisterScheduleTask(task.ScheduleTask{
+					ID:          "configs_sync",
 					Description: fmt.Sprintf("sync configs from manager"),
 					Type:        "interval",
 					Interval:    global.Env().SystemConfig.Configs.Interval,
@@ -263,6 +264,9 @@ func ListenConfigChanges() error {
 						syncFunc()
 					},
 				})
+				if err != nil {
+					log.Errorf("failed to register task for checking configs changes: %v", err)
+				}
 			}
 		}
 
@@ -29,6 +29,7 @@ package common
 
 import (
 	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 )
 
@@ -45,13 +46,21 @@ type SetupConfig struct {
 	Port                      string      `config:"port"`
 }
 
-func GetAgentConfig() *AgentConfig {
-	agentCfg := &AgentConfig{
+func defaultAgentConfig() *AgentConfig {
+	return &AgentConfig{
 		Enabled: true,
 		Setup: &SetupConfig{
 			DownloadURL: "https://release.infinilabs.com/agent/stable",
 		},
 	}
+}
+
+func init() {
+	config.RegisterSectionSchema("agent", defaultAgentConfig())
+}
+
+func GetAgentConfig() *AgentConfig {
+	agentCfg := defaultAgentConfig()
 	_, err := env.ParseConfig("agent", agentCfg )
 	if err != nil {
 		log.Debug("agent config not found: %v", err)
@@ -27,7 +27,10 @@
 
 package common
 
-import "infini.sh/framework/core/model"
+import (
+	"infini.sh/framework/core/model"
+	"time"
+)
 
 const REGISTER_API = "/instance/_register"
 const SYNC_API = "/configs/_sync"
@@ -51,10 +54,28 @@ type ConfigList struct {
 
 type ConfigDeleteRequest struct {
 	Configs []string `json:"configs"`
+	Message string   `json:"message,omitempty"`
 }
 
 type ConfigUpdateRequest struct {
 	Configs map[string]string `json:"configs"`
+	Message string            `json:"message,omitempty"`
+}
+
+// ConfigVersion is a numbered snapshot of the files within the config folder
+type ConfigVersion struct {
+	Version int64             `json:"version"`
+	Created time.Time         `json:"created"`
+	Action  string            `json:"action,omitempty"`
+	Message string            `json:"message,omitempty"`
+	Changes []ConfigChange    `json:"changes,omitempty"`
+	Files   map[string]string `json:"files,omitempty"`
+}
+
+type ConfigChange struct {
+	Name   string `json:"name"`
+	Action string `json:"action"` //created, updated or deleted
+	Diff   string `json:"diff,omitempty"`
 }
 
 type ConfigSyncRequest struct {
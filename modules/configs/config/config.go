@@ -19,17 +19,57 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
 func init() {
-	api.HandleAPIMethod(api.GET, "/config/", listConfigAction)
-	api.HandleAPIMethod(api.PUT, "/config/", saveConfigAction)
-	api.HandleAPIMethod(api.DELETE, "/config/", deleteConfigAction)
-	api.HandleAPIMethod(api.POST, "/config/_reload", reloadConfigAction)
-	api.HandleAPIMethod(api.GET, "/config/runtime", getConfigAction)
-	api.HandleAPIMethod(api.GET, "/environments", getEnvAction)
+	api.HandleAPIMethod(api.GET, "/config/", listConfigAction,
+		api.WithSummary("List config files"),
+		api.WithResponse(common.ConfigList{}))
+	api.HandleAPIMethod(api.PUT, "/config/", saveConfigAction,
+		api.WithSummary("Save config files"),
+		api.WithRequest(common.ConfigUpdateRequest{}),
+		api.RequirePermission("config:update"),
+		api.WithAudit("config:save", ""))
+	api.HandleAPIMethod(api.DELETE, "/config/", deleteConfigAction,
+		api.WithSummary("Delete config files"),
+		api.WithRequest(common.ConfigDeleteRequest{}),
+		api.RequirePermission("config:update"),
+		api.WithAudit("config:delete", ""))
+	api.HandleAPIMethod(api.POST, "/config/_reload", reloadConfigAction,
+		api.WithSummary("Reload configs from disk"),
+		api.WithDescription("Validate the configs on disk and reload, roll back to the latest version on failure"),
+		api.RequirePermission("config:update"),
+		api.WithAudit("config:reload", ""))
+	api.HandleAPIMethod(api.GET, "/config/_versions", listVersionsAction,
+		api.WithSummary("List config versions"),
+		api.WithResponse([]common.ConfigVersion{}),
+		api.RequirePermission("config:read"))
+	api.HandleAPIMethod(api.GET, "/config/_versions/:version", getVersionAction,
+		api.WithSummary("Get config version"),
+		api.WithResponse(common.ConfigVersion{}),
+		api.RequirePermission("config:read"))
+	api.HandleAPIMethod(api.GET, "/config/_versions/:version/_diff", diffVersionAction,
+		api.WithSummary("Diff config versions"),
+		api.WithQueryParam("from", "integer", "version to compare with, defaults to the previous version", false),
+		api.RequirePermission("config:read"))
+	api.HandleAPIMethod(api.POST, "/config/_versions/:version/_rollback", rollbackVersionAction,
+		api.WithSummary("Roll back to config version"),
+		api.RequirePermission("config:update"),
+		api.WithAudit("config:rollback", "config/_versions/{version}"))
+	api.HandleAPIMethod(api.GET, "/config/_schema", getSchemaAction,
+		api.WithSummary("Get the json schema of configs"),
+		api.WithDescription("Json schema of the config file, pipeline processors and filters"))
+	api.HandleAPIMethod(api.GET, "/config/_schema/:section", getSectionSchemaAction,
+		api.WithSummary("Get the json schema of config section"))
+	api.HandleAPIMethod(api.GET, "/config/runtime", getConfigAction,
+		api.WithSummary("Get the effective runtime config"))
+	api.HandleAPIMethod(api.GET, "/environments", getEnvAction,
+		api.WithSummary("Get environment variables"),
+		api.WithResponse([]string{}),
+		api.RequirePermission("config:read"))
 
 }
 
@@ -131,19 +171,14 @@ func SaveConfigStr(name, content string) error {
 
 	if util.FileExists(fileToSave) {
 		if global.Env().SystemConfig.Configs.SoftDelete {
-			err := util.Rename(fileToSave, fmt.Sprintf("%v.%v.bak", fileToSave, time.Now().UnixMilli()))
+			_, err := util.CopyFile(fileToSave, fmt.Sprintf("%v.%v.bak", fileToSave, time.Now().UnixMilli()))
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	_, err = util.FilePutContent(fileToSave, content)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return writeFileAtomic(fileToSave, content)
 }
 
 func  deleteConfigAction(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
@@ -153,13 +188,13 @@ func  deleteConfigAction(w http.ResponseWriter, req *http.Request, params httpro
 		panic(err)
 	}
 
+	api.SetAuditTarget(req, "config/"+strings.Join(reqBody.Configs, ","))
+	tx := NewTransaction(ActionDelete, reqBody.Message)
 	for _, name := range reqBody.Configs {
-		err := DeleteConfig(name)
-		if err != nil {
-			panic(err)
-		}
+		tx.Delete(name)
 	}
-	api.DefaultAPI.WriteAckOKJSON(w)
+	v, err := tx.Commit()
+	writeCommitResult(w, v, err)
 }
 
 var versionRegexp = regexp.MustCompile(`#MANAGED_CONFIG_VERSION:\s*(?P<version>\d+)`)
@@ -313,20 +348,20 @@ func  saveConfigAction(w http.ResponseWriter, req *http.Request, params httprout
 		panic(err)
 	}
 
+	names := make([]string, 0, len(reqBody.Configs))
+	tx := NewTransaction(ActionUpdate, reqBody.Message)
 	for name, content := range reqBody.Configs {
-		err := SaveConfigStr(name, content)
-		if err != nil {
-			panic(err)
-		}
+		names = append(names, name)
+		tx.Save(name, content)
 	}
-	api.DefaultAPI.WriteAckOKJSON(w)
+	sort.Strings(names)
+	api.SetAuditTarget(req, "config/"+strings.Join(names, ","))
+	v, err := tx.Commit()
+	writeCommitResult(w, v, err)
 }
 
 func  reloadConfigAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	log.Infof("refresh config")
-	err := global.Env().RefreshConfig()
-	if err != nil {
-		panic(err)
-	}
-	api.DefaultAPI.WriteAckOKJSON(w)
+	v, err := NewTransaction(ActionReload, "reload configs from disk").Commit()
+	writeCommitResult(w, v, err)
 }
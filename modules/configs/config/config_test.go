@@ -7,6 +7,7 @@ package config
 import (
 	"fmt"
 	"github.com/magiconair/properties/assert"
+	"strings"
 	"testing"
 )
 
@@ -41,3 +42,28 @@ func TestVersion(t *testing.T) {
 	ver=parseConfigVersion("what's the version, i think is 1234")
 	assert.Equal(t, ver, int64(-1))
 }
+
+func TestDiffFiles(t *testing.T) {
+	from := map[string]string{
+		"a.yml": "name: a\n",
+		"b.yml": "name: b\n",
+		"c.yml": "name: c\n",
+	}
+	to := map[string]string{
+		"a.yml": "name: a\n",
+		"b.yml": "name: b1\n",
+		"d.yml": "name: d\n",
+	}
+
+	changes := diffFiles(from, to)
+	assert.Equal(t, 3, len(changes))
+	assert.Equal(t, "b.yml", changes[0].Name)
+	assert.Equal(t, "updated", changes[0].Action)
+	assert.Equal(t, true, strings.Contains(changes[0].Diff, "b1"))
+	assert.Equal(t, "c.yml", changes[1].Name)
+	assert.Equal(t, "deleted", changes[1].Action)
+	assert.Equal(t, "d.yml", changes[2].Name)
+	assert.Equal(t, "created", changes[2].Action)
+
+	assert.Equal(t, 0, len(diffFiles(from, from)))
+}
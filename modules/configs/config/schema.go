@@ -0,0 +1,58 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"net/http"
+
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/i18n"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+)
+
+func getSchemaAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	api.DefaultAPI.WriteJSON(w, util.MapStr{
+		"config":     config.GetSchema(),
+		"processors": pipeline.GetProcessorConfigSchemas(),
+		"filters":    pipeline.GetFilterConfigSchemas(),
+		"strict":     config.IsStrictMode(),
+	}, http.StatusOK)
+}
+
+func getSectionSchemaAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	section := ps.MustGetParameter("section")
+	v, ok := config.GetSectionSchema(section)
+	if !ok {
+		api.DefaultAPI.WriteLocalizedError(w, i18n.NewError("config.api.schema_not_found", i18n.Params{"section": section}), http.StatusNotFound)
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, config.ReflectSchema(v), http.StatusOK)
+}
@@ -0,0 +1,402 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/modules/configs/common"
+)
+
+const (
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionReload   = "reload"
+	ActionRollback = "rollback"
+	ActionInitial  = "initial"
+)
+
+var txLock = sync.Mutex{}
+
+// Transaction applies a batch of config file changes as a whole, the candidate configs are validated
+// against the registered section schemas before touching the disk, and all the files are restored
+// if any section failed to reload
+type Transaction struct {
+	action  string
+	message string
+	updates map[string]string
+	deletes map[string]bool
+}
+
+func NewTransaction(action, message string) *Transaction {
+	return &Transaction{
+		action:  action,
+		message: message,
+		updates: map[string]string{},
+		deletes: map[string]bool{},
+	}
+}
+
+func (tx *Transaction) Save(name, content string) *Transaction {
+	delete(tx.deletes, name)
+	tx.updates[name] = content
+	return tx
+}
+
+func (tx *Transaction) Delete(name string) *Transaction {
+	delete(tx.updates, name)
+	tx.deletes[name] = true
+	return tx
+}
+
+// Commit applies the changes, returns the new version, or nil if nothing changed
+func (tx *Transaction) Commit() (*common.ConfigVersion, error) {
+	txLock.Lock()
+	defer txLock.Unlock()
+	return tx.commit()
+}
+
+// commit must be called after holding txLock
+func (tx *Transaction) commit() (*common.ConfigVersion, error) {
+	cfgDir, err := filepath.Abs(global.Env().GetConfigDir())
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := readConfigFiles(cfgDir)
+	if err != nil {
+		return nil, err
+	}
+
+	target := copyFiles(current)
+	for name := range tx.deletes {
+		if err := validateFile(cfgDir, path.Join(cfgDir, name)); err != nil {
+			return nil, err
+		}
+		if _, ok := target[name]; !ok {
+			return nil, errors.Errorf("file not exists: %s", path.Join(cfgDir, name))
+		}
+		delete(target, name)
+	}
+	for name, content := range tx.updates {
+		if err := validateFile(cfgDir, path.Join(cfgDir, name)); err != nil {
+			return nil, err
+		}
+		target[name] = content
+	}
+
+	//the known good state to restore
+	base := current
+	if tx.action == ActionReload {
+		if v, _ := getLatestVersion(); v != nil {
+			base = v.Files
+		}
+	}
+
+	return tx.apply(cfgDir, base, target)
+}
+
+func (tx *Transaction) apply(cfgDir string, base, target map[string]string) (*common.ConfigVersion, error) {
+
+	previousCfg, err := loadCandidateConfig(base)
+	if err != nil {
+		log.Warnf("failed to load previous configs: %v", err)
+	}
+
+	candidateCfg, err := loadCandidateConfig(target)
+	if err != nil {
+		return nil, errors.Errorf("invalid config: %v", err)
+	}
+
+	if err := validateCandidateConfig(candidateCfg); err != nil {
+		return nil, err
+	}
+
+	if err := writeConfigFiles(cfgDir, target, tx.action != ActionReload); err != nil {
+		tx.restore(cfgDir, base, nil, nil)
+		return nil, err
+	}
+
+	if err := refreshConfig(); err != nil {
+		tx.restore(cfgDir, base, nil, nil)
+		return nil, errors.Errorf("failed to refresh config, rolled back: %v", err)
+	}
+
+	applied := []string{}
+	for _, k := range config.GetReloadableSections() {
+		previous := getSection(previousCfg, k)
+		current := getSection(candidateCfg, k)
+		if config.SectionDigest(previous) == config.SectionDigest(current) {
+			continue
+		}
+		applied = append(applied, k)
+		log.Infof("reloading config section [%v]", k)
+		if err := config.ReloadSection(k, previous, current); err != nil {
+			tx.restore(cfgDir, base, previousCfg, candidateCfg, applied...)
+			return nil, errors.Errorf("%v, rolled back", err)
+		}
+	}
+
+	v, err := saveVersion(tx.action, tx.message, base, target)
+	if err != nil {
+		return nil, err
+	}
+
+	//the legacy callbacks are only notified after the transaction succeeded
+	config.NotifyConfigCommitted(previousCfg, candidateCfg)
+	return v, nil
+}
+
+// restore writes back the known good files, and reverts the sections reloaded with the candidate config,
+// the files on disk are backed up first, as they may be edited by hand, eg: on reload
+func (tx *Transaction) restore(cfgDir string, base map[string]string, previousCfg, candidateCfg *config.Config, sections ...string) {
+	log.Warnf("rolling back config transaction [%v]", tx.action)
+
+	if backupDir, err := backupConfigFiles(cfgDir); err != nil {
+		log.Error("failed to backup config files, leave the files on disk untouched: ", err)
+	} else {
+		if backupDir != "" {
+			log.Warnf("config files are backed up to [%v]", backupDir)
+		}
+		if err := writeConfigFiles(cfgDir, base, false); err != nil {
+			log.Error("failed to restore config files: ", err)
+		}
+		if err := refreshConfig(); err != nil {
+			log.Error("failed to refresh config: ", err)
+		}
+	}
+
+	for i := len(sections) - 1; i >= 0; i-- {
+		k := sections[i]
+		if err := config.ReloadSection(k, getSection(candidateCfg, k), getSection(previousCfg, k)); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func validateCandidateConfig(cfg *config.Config) error {
+	sysCfg := env.GetDefaultSystemConfig()
+	if err := cfg.Unpack(&sysCfg); err != nil {
+		return errors.Errorf("invalid config: %v", err)
+	}
+	return config.Validate(cfg)
+}
+
+func getSection(cfg *config.Config, key string) *config.Config {
+	if cfg == nil || !cfg.HasField(key) {
+		return nil
+	}
+	child, err := cfg.Child(key, -1)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return child
+}
+
+// loadCandidateConfig merges the main config file with the candidate files
+func loadCandidateConfig(files map[string]string) (*config.Config, error) {
+	stagingDir := path.Join(global.Env().GetDataDir(), "config", "staging", util.GetUUID())
+	defer os.RemoveAll(stagingDir)
+
+	for name, content := range files {
+		file := path.Join(stagingDir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return nil, err
+		}
+		if _, err := util.FilePutContent(file, content); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := config.LoadFile(global.Env().GetConfigFile())
+	if err != nil {
+		return nil, err
+	}
+
+	if util.FileExists(stagingDir) {
+		child, err := config.LoadPath(stagingDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := cfg.Merge(child); err != nil {
+			return nil, err
+		}
+	}
+
+	obj := map[string]interface{}{}
+	if err := cfg.Unpack(obj); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readConfigFiles returns the content of the config files, keyed by the relative path
+func readConfigFiles(cfgDir string) (map[string]string, error) {
+	files := map[string]string{}
+	if !util.FileExists(cfgDir) {
+		return files, nil
+	}
+	err := filepath.Walk(cfgDir, func(file string, f os.FileInfo, err error) error {
+		if err != nil || f == nil || f.IsDir() {
+			return nil
+		}
+		if validateFile(cfgDir, file) != nil {
+			return nil
+		}
+		name, err := filepath.Rel(cfgDir, file)
+		if err != nil {
+			return err
+		}
+		c, err := util.FileGetContent(file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(name)] = string(c)
+		return nil
+	})
+	return files, err
+}
+
+// backupConfigFiles copies the config files on disk to the data folder, returns the backup folder,
+// or empty if there is nothing to backup
+func backupConfigFiles(cfgDir string) (string, error) {
+	current, err := readConfigFiles(cfgDir)
+	if err != nil || len(current) == 0 {
+		return "", err
+	}
+	backupDir := path.Join(global.Env().GetDataDir(), "config", "backup", time.Now().Format("20060102150405.000"))
+	for name, content := range current {
+		if err := writeFileAtomic(path.Join(backupDir, name), content); err != nil {
+			return "", err
+		}
+	}
+	return backupDir, nil
+}
+
+// writeConfigFiles makes the config folder the same as the files, the changes are marked as written
+// by the process itself, so that the watcher won't reload them again
+func writeConfigFiles(cfgDir string, files map[string]string, backup bool) error {
+	current, err := readConfigFiles(cfgDir)
+	if err != nil {
+		return err
+	}
+
+	for name := range current {
+		if _, ok := files[name]; ok {
+			continue
+		}
+		config.MarkFileDeleted(path.Join(cfgDir, name))
+		if backup {
+			if err := DeleteConfig(name); err != nil {
+				return err
+			}
+		} else if err := util.FileDelete(path.Join(cfgDir, name)); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		content := files[name]
+		if v, ok := current[name]; ok && v == content {
+			continue
+		}
+		config.MarkFileWritten(path.Join(cfgDir, name), content)
+		if backup {
+			if err := SaveConfigStr(name, content); err != nil {
+				return err
+			}
+		} else if err := writeFileAtomic(path.Join(cfgDir, name), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temp file and renames it, the watcher skips files with suffix `~`
+func writeFileAtomic(file, content string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%v.%v~", file, time.Now().UnixNano())
+	if _, err := util.FilePutContent(tmp, content); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		util.FileDelete(tmp)
+		return err
+	}
+	return nil
+}
+
+func copyFiles(files map[string]string) map[string]string {
+	result := make(map[string]string, len(files))
+	for k, v := range files {
+		result[k] = v
+	}
+	return result
+}
+
+func refreshConfig() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var v string
+			switch r.(type) {
+			case error:
+				v = r.(error).Error()
+			case runtime.Error:
+				v = r.(runtime.Error).Error()
+			case string:
+				v = r.(string)
+			default:
+				v = fmt.Sprint(r)
+			}
+			err = errors.New(v)
+		}
+	}()
+	return global.Env().RefreshConfig()
+}
@@ -0,0 +1,334 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/modules/configs/common"
+)
+
+func getVersionsDir() string {
+	return path.Join(global.Env().GetDataDir(), "config", "versions")
+}
+
+func getVersionFile(version int64) string {
+	return path.Join(getVersionsDir(), fmt.Sprintf("%d.json", version))
+}
+
+// ListVersions returns the version numbers in ascending order
+func ListVersions() ([]int64, error) {
+	versions := []int64{}
+	entries, err := os.ReadDir(getVersionsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return versions, nil
+		}
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		v, err := util.ToInt64(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+	return versions, nil
+}
+
+func GetVersion(version int64) (*common.ConfigVersion, error) {
+	file := getVersionFile(version)
+	if !util.FileExists(file) {
+		return nil, errors.Errorf("config version [%v] not found", version)
+	}
+	data, err := util.FileGetContent(file)
+	if err != nil {
+		return nil, err
+	}
+	v := common.ConfigVersion{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func getLatestVersion() (*common.ConfigVersion, error) {
+	versions, err := ListVersions()
+	if err != nil || len(versions) == 0 {
+		return nil, err
+	}
+	return GetVersion(versions[len(versions)-1])
+}
+
+// saveVersion records the applied files as a new version, the known good files are recorded as
+// the initial version if there is no version yet, so that we can always roll back to the beginning
+func saveVersion(action, message string, base, target map[string]string) (*common.ConfigVersion, error) {
+	latest, err := getLatestVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if latest == nil {
+		latest = &common.ConfigVersion{
+			Version: 1,
+			Created: time.Now(),
+			Action:  ActionInitial,
+			Files:   base,
+		}
+		if err := writeVersion(latest); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := diffFiles(latest.Files, target)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	v := &common.ConfigVersion{
+		Version: latest.Version + 1,
+		Created: time.Now(),
+		Action:  action,
+		Message: message,
+		Changes: changes,
+		Files:   target,
+	}
+	if err := writeVersion(v); err != nil {
+		return nil, err
+	}
+
+	log.Infof("config version [%v] saved, %v files changed", v.Version, len(changes))
+
+	pruneVersions(global.Env().SystemConfig.Configs.MaxVersions)
+	return v, nil
+}
+
+func writeVersion(v *common.ConfigVersion) error {
+	if err := os.MkdirAll(getVersionsDir(), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(getVersionFile(v.Version), util.MustToJSON(v))
+}
+
+func pruneVersions(max int) {
+	if max <= 0 {
+		return
+	}
+	versions, err := ListVersions()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if len(versions) <= max {
+		return
+	}
+	for _, v := range versions[:len(versions)-max] {
+		log.Debugf("delete config version: %v", v)
+		if err := util.FileDelete(getVersionFile(v)); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// diffFiles compares two sets of config files, changes are sorted by file name
+func diffFiles(from, to map[string]string) []common.ConfigChange {
+	changes := []common.ConfigChange{}
+	for name, content := range to {
+		previous, ok := from[name]
+		if !ok {
+			changes = append(changes, common.ConfigChange{Name: name, Action: "created", Diff: diffContent("", content)})
+		} else if previous != content {
+			changes = append(changes, common.ConfigChange{Name: name, Action: "updated", Diff: diffContent(previous, content)})
+		}
+	}
+	for name, content := range from {
+		if _, ok := to[name]; !ok {
+			changes = append(changes, common.ConfigChange{Name: name, Action: "deleted", Diff: diffContent(content, "")})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+func diffContent(from, to string) string {
+	list1, err := util.LoadYaml([]byte(from))
+	if err != nil {
+		return ""
+	}
+	list2, err := util.LoadYaml([]byte(to))
+	if err != nil {
+		return ""
+	}
+	return util.Do(list1, list2).String()
+}
+
+// RollbackToVersion applies the files of the specified version as a new version
+func RollbackToVersion(version int64) (*common.ConfigVersion, error) {
+	//the files must not be changed by other transactions between reading and committing
+	txLock.Lock()
+	defer txLock.Unlock()
+
+	v, err := GetVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	cfgDir, err := filepath.Abs(global.Env().GetConfigDir())
+	if err != nil {
+		return nil, err
+	}
+	current, err := readConfigFiles(cfgDir)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := NewTransaction(ActionRollback, fmt.Sprintf("rollback to version %v", version))
+	for name := range current {
+		if _, ok := v.Files[name]; !ok {
+			tx.Delete(name)
+		}
+	}
+	for name, content := range v.Files {
+		tx.Save(name, content)
+	}
+	return tx.commit()
+}
+
+func parseVersion(ps httprouter.Params) (int64, error) {
+	v, err := util.ToInt64(ps.MustGetParameter("version"))
+	if err != nil {
+		return 0, errors.Errorf("invalid version: %v", ps.MustGetParameter("version"))
+	}
+	return v, nil
+}
+
+func writeCommitResult(w http.ResponseWriter, v *common.ConfigVersion, err error) {
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v == nil {
+		api.DefaultAPI.WriteJSON(w, util.MapStr{"acknowledged": true, "changed": false}, http.StatusOK)
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, util.MapStr{"acknowledged": true, "changed": true, "version": v.Version}, http.StatusOK)
+}
+
+func listVersionsAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	versions, err := ListVersions()
+	if err != nil {
+		panic(err)
+	}
+	result := []common.ConfigVersion{}
+	for i := len(versions) - 1; i >= 0; i-- {
+		v, err := GetVersion(versions[i])
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		v.Files = nil
+		result = append(result, *v)
+	}
+	api.DefaultAPI.WriteJSON(w, result, http.StatusOK)
+}
+
+func getVersionAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	version, err := parseVersion(ps)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v, err := GetVersion(version)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	api.DefaultAPI.WriteJSON(w, v, http.StatusOK)
+}
+
+func diffVersionAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	version, err := parseVersion(ps)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := GetVersion(version)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var fromFiles map[string]string
+	fromVersion := api.DefaultAPI.GetIntOrDefault(req, "from", int(version-1))
+	if fromVersion > 0 {
+		from, err := GetVersion(int64(fromVersion))
+		if err != nil {
+			api.DefaultAPI.WriteError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		fromFiles = from.Files
+	}
+
+	api.DefaultAPI.WriteJSON(w, util.MapStr{
+		"from":    fromVersion,
+		"to":      version,
+		"changes": diffFiles(fromFiles, to.Files),
+	}, http.StatusOK)
+}
+
+func rollbackVersionAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	version, err := parseVersion(ps)
+	if err != nil {
+		api.DefaultAPI.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v, err := RollbackToVersion(version)
+	writeCommitResult(w, v, err)
+}
@@ -0,0 +1,124 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package elasticsearch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/segmentio/encoding/json"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/util"
+)
+
+// pointInTimeAPI returns the path and the id field of the point in time apis,
+// elasticsearch supports since 7.10 and opensearch supports since 2.4
+func (c *ESAPIV0) pointInTimeAPI() (string, string, error) {
+	v := c.GetVersion()
+	var path, idField, minVersion string
+	switch v.Distribution {
+	case elastic.Opensearch:
+		path, idField, minVersion = "/_search/point_in_time", "pit_id", "2.4.0"
+	case elastic.Easysearch:
+		return "", "", elastic.ErrPointInTimeNotSupported
+	default:
+		path, idField, minVersion = "/_pit", "id", "7.10.0"
+	}
+	if cmp, err := util.VersionCompare(v.Number, minVersion); err != nil || cmp < 0 {
+		return "", "", elastic.ErrPointInTimeNotSupported
+	}
+	return path, idField, nil
+}
+
+func (c *ESAPIV0) OpenPointInTime(indexNames string, keepAlive string) (string, error) {
+	path, idField, err := c.pointInTimeAPI()
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s/%s%s?keep_alive=%s", c.GetEndpoint(), util.UrlEncode(indexNames), path, keepAlive)
+	resp, err := c.Request(context.Background(), util.Verb_POST, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(string(resp.Body))
+	}
+	obj := map[string]interface{}{}
+	if err := json.Unmarshal(resp.Body, &obj); err != nil {
+		return "", err
+	}
+	id, ok := obj[idField].(string)
+	if !ok || id == "" {
+		return "", errors.Errorf("invalid point in time response: %v", string(resp.Body))
+	}
+	return id, nil
+}
+
+func (c *ESAPIV0) ClosePointInTime(pitID string) error {
+	path, idField, err := c.pointInTimeAPI()
+	if err != nil {
+		return err
+	}
+	body := util.MapStr{idField: pitID}
+	if idField == "pit_id" {
+		body[idField] = []string{pitID}
+	}
+	resp, err := c.Request(context.Background(), util.Verb_DELETE, c.GetEndpoint()+path, util.MustToJSONBytes(body))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return errors.New(string(resp.Body))
+	}
+	return nil
+}
+
+func (c *ESAPIV0) SearchWithPointInTime(ctx context.Context, queryDSL []byte) (*elastic.SearchResponse, error) {
+	url := c.GetEndpoint() + "/_search"
+	if global.Env().IsDebug {
+		log.Trace("search with point in time: ", url, ",", string(queryDSL))
+	}
+
+	resp, err := c.Request(ctx, util.Verb_POST, url, queryDSL)
+	if err != nil {
+		return nil, err
+	}
+	esResp := &elastic.SearchResponse{}
+	esResp.StatusCode = resp.StatusCode
+	esResp.RawResult = resp
+	if resp.StatusCode != http.StatusOK {
+		return esResp, errors.New(string(resp.Body))
+	}
+	err = json.Unmarshal(resp.Body, esResp)
+	return esResp, err
+}
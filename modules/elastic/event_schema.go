@@ -0,0 +1,105 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+ * web: https://infinilabs.com
+ * mail: hello#infini.ltd */
+
+package elastic
+
+import (
+	"fmt"
+
+	"github.com/buger/jsonparser"
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/orm"
+	"infini.sh/framework/core/util"
+)
+
+const metricsIndexName = "metrics"
+
+// initEventTemplate put the index template generated from the event schemas, so that the metrics
+// indices are created with the typed mappings, the template is updated when a schema version is added
+func initEventTemplate(client elastic.API) {
+	if len(event.GetSchemas()) == 0 {
+		return
+	}
+	templateName := moduleConfig.ORMConfig.TemplateName
+	if templateName == "" {
+		templateName = global.Env().GetAppLowercaseName()
+	}
+	templateName = templateName + "_" + metricsIndexName
+
+	pattern := fmt.Sprintf("%s%s*", moduleConfig.ORMConfig.IndexPrefix, metricsIndexName)
+	template := event.GetIndexTemplate([]string{pattern}, 1)
+	version := template["version"].(int)
+	if client.GetMajorVersion() < 7 {
+		template["mappings"] = util.MapStr{"doc": template["mappings"]}
+	}
+
+	if !moduleConfig.ORMConfig.OverrideExistsTemplate {
+		exists, err := client.TemplateExists(templateName)
+		if err != nil {
+			panic(err)
+		}
+		if exists {
+			templates, err := client.GetTemplate(templateName)
+			if err != nil {
+				panic(err)
+			}
+			v, _ := util.GetMapValueByKeys([]string{templateName, "version"}, templates)
+			if current, ok := v.(float64); ok && int(current) >= version {
+				return
+			}
+		}
+	}
+
+	res, err := client.PutTemplate(templateName, util.MustToJSONBytes(template))
+	if err != nil {
+		if res != nil {
+			log.Error(string(res))
+		}
+		panic(err)
+	}
+	log.Debugf("event template %v updated to version %v", templateName, version)
+}
+
+// initEventMappings put the typed fields of the event schemas to the existing metrics index
+func initEventMappings() {
+	if len(event.GetSchemas()) == 0 {
+		return
+	}
+	client := elastic.GetClient(global.MustLookupString(elastic.GlobalSystemElasticsearchID))
+	indexName := orm.GetIndexName(event.Event{})
+	data, err := client.UpdateMapping(indexName, "", util.MustToJSONBytes(event.GetIndexMappings()))
+	if err != nil {
+		log.Errorf("failed to update the event mappings of %v: %v", indexName, err)
+		return
+	}
+	if x, _, _, _ := jsonparser.Get(data, "error"); x != nil {
+		log.Errorf("failed to update the event mappings of %v: %v", indexName, string(x))
+	}
+}
@@ -32,7 +32,6 @@ import (
 	"fmt"
 	"infini.sh/framework/core/model"
 	"math"
-	"runtime"
 	"sync"
 	"time"
 
@@ -50,6 +49,11 @@ import (
 	"infini.sh/framework/modules/elastic/common"
 )
 
+func init() {
+	config.RegisterSectionSchema("elasticsearch", []elastic.ElasticsearchConfig{})
+	config.RegisterSectionSchema("elastic", defaultConfig)
+}
+
 func (module *ElasticModule) Name() string {
 	return "elasticsearch"
 }
@@ -284,7 +288,9 @@ func nodeAvailabilityCheck() {
 			})
 		},
 	}
-	task.RegisterScheduleTask(task2)
+	if _, err := task.RegisterScheduleTask(task2); err != nil {
+		log.Errorf("failed to register task [%v]: %v", task2.Description, err)
+	}
 }
 
 func (module *ElasticModule) registerClusterStateRefreshTask() {
@@ -342,7 +348,9 @@ func (module *ElasticModule) registerClusterStateRefreshTask() {
 			})
 		},
 	}
-	task.RegisterScheduleTask(task2)
+	if _, err := task.RegisterScheduleTask(task2); err != nil {
+		log.Errorf("failed to register task [%v]: %v", task2.Description, err)
+	}
 }
 
 var schemaInited bool
@@ -368,7 +376,7 @@ func InitSchema() {
 	if err != nil {
 		panic(err)
 	}
-	err = orm.RegisterSchemaWithIndexName(event.Event{}, "metrics")
+	err = orm.RegisterSchemaWithIndexName(event.Event{}, metricsIndexName)
 	if err != nil {
 		panic(err)
 	}
@@ -382,6 +390,7 @@ func InitSchema() {
 	if err!=nil{
 		panic(err)
 	}
+	initEventMappings()
 
 	schemaInited = true
 }
@@ -504,7 +513,9 @@ func (module *ElasticModule) Start() error {
 			},
 		}
 
-		task.RegisterScheduleTask(t)
+		if _, err := task.RegisterScheduleTask(t); err != nil {
+			log.Errorf("failed to register task [%v]: %v", t.Description, err)
+		}
 	}
 
 	if moduleConfig.NodeAvailabilityCheckConfig.Enabled {
@@ -526,7 +537,9 @@ func (module *ElasticModule) Start() error {
 				module.refreshAllClusterMetadata()
 			},
 		}
-		task.RegisterScheduleTask(task2)
+		if _, err := task.RegisterScheduleTask(task2); err != nil {
+			log.Errorf("failed to register task [%v]: %v", task2.Description, err)
+		}
 
 		////refresh indices
 		//task2 = task.ScheduleTask{
@@ -557,7 +570,9 @@ func (module *ElasticModule) Start() error {
 				module.refreshAllClusterAlias(false)
 			},
 		}
-		task.RegisterScheduleTask(task2)
+		if _, err := task.RegisterScheduleTask(task2); err != nil {
+			log.Errorf("failed to register task [%v]: %v", task2.Description, err)
+		}
 
 		////refresh primary_shards
 		//task2 = task.ScheduleTask{
@@ -584,35 +599,17 @@ func (module *ElasticModule) Start() error {
 		module.registerClusterSettingsRefreshTask()
 	}
 
-	config.NotifyOnConfigSectionChange("elasticsearch", func(pCfg, cCfg *config.Config) {
-
-		defer func() {
-			if !global.Env().IsDebug {
-				if r := recover(); r != nil {
-					var v string
-					switch r.(type) {
-					case error:
-						v = r.(error).Error()
-					case runtime.Error:
-						v = r.(runtime.Error).Error()
-					case string:
-						v = r.(string)
-					}
-					log.Error("error on apply config change,", v)
-				}
-			}
-		}()
-
+	config.RegisterSectionReloader("elasticsearch", func(pCfg, cCfg *config.Config) error {
 		if cCfg != nil {
 			//TODO diff previous and current config
 			var newConfig []elastic.ElasticsearchConfig
 			err := cCfg.Unpack(&newConfig)
 			if err != nil {
-				log.Error(err)
-				return
+				return err
 			}
 			initElasticInstances(newConfig, elastic.ElasticsearchConfigSourceFile)
 		}
+		return nil
 	})
 
 	return nil
@@ -669,7 +666,9 @@ func (module *ElasticModule) registerClusterSettingsRefreshTask() {
 			})
 		},
 	}
-	task.RegisterScheduleTask(task2)
+	if _, err := task.RegisterScheduleTask(task2); err != nil {
+		log.Errorf("failed to register task [%v]: %v", task2.Description, err)
+	}
 
 }
 
@@ -24,10 +24,12 @@
 package elastic
 
 import (
+	"bytes"
 	"fmt"
 	"net/http"
 
 	log "github.com/cihub/seelog"
+	"github.com/buger/jsonparser"
 	"infini.sh/framework/core/elastic"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
@@ -81,6 +83,9 @@ func InitTemplate(force bool) {
 			}
 		}
 
+		//typed mappings of the events
+		initEventTemplate(client)
+
 		//search templates
 		if moduleConfig.ORMConfig.SearchTemplates!=nil&&len(moduleConfig.ORMConfig.SearchTemplates)>0{
 			for k,v:= range moduleConfig.ORMConfig.SearchTemplates{
@@ -153,6 +158,37 @@ func (handler *ElasticORM) Get(o interface{}) (bool, error) {
 	return true, err
 }
 
+func (handler *ElasticORM) GetWithVersion(o interface{}) (bool, api.Version, error) {
+
+	id := getIndexID(o)
+	if id == "" {
+		return false, api.Version{}, errors.Errorf("id was not found in object: %v", o)
+	}
+
+	response, err := handler.Client.Get(handler.GetIndexName(o), "", id)
+	if err != nil {
+		return false, api.Version{}, err
+	}
+	if response.RawResult.StatusCode == http.StatusNotFound {
+		return false, api.Version{}, ErrNotFound
+	}
+	str, err := response.GetBytesByJsonPath("_source")
+	if err != nil {
+		return false, api.Version{}, err
+	}
+	if str == nil {
+		return false, api.Version{}, nil
+	}
+
+	version, err := getVersion(response)
+	if err != nil {
+		return false, api.Version{}, err
+	}
+
+	err = util.FromJSONBytes(str, o)
+	return true, version, err
+}
+
 func (handler *ElasticORM) GetBy(field string, value interface{}, t interface{}) (error, api.Result) {
 	query := api.Query{}
 	query.Conds = api.And(api.Eq(field, value))
@@ -168,6 +204,71 @@ func (handler *ElasticORM) Save(ctx *api.Context, o interface{}) error {
 	return err
 }
 
+func (handler *ElasticORM) SaveIfVersion(ctx *api.Context, o interface{}, version api.Version) error {
+	id := getIndexID(o)
+	if id == "" {
+		return errors.Errorf("id was not found in object: %v", o)
+	}
+
+	indexName := handler.GetIndexName(o)
+	if err := indexIfVersion(handler.Client, indexName, "doc", id, o, version); err != nil {
+		return err
+	}
+
+	if ctx != nil && ctx.Refresh != "" {
+		return handler.Client.Refresh(indexName)
+	}
+	return nil
+}
+
+//indexIfVersion writes the document through a single bulk action, as it's the only request of the
+//client taking if_seq_no/if_primary_term, a zero version creates the document
+func indexIfVersion(client elastic.API, indexName, docType, id string, doc interface{}, version api.Version) error {
+	metadata := util.MapStr{"_index": indexName, "_id": id}
+	if client.GetMajorVersion() < 7 {
+		metadata["_type"] = docType
+	}
+	actionName := "create"
+	if version.PrimaryTerm > 0 {
+		actionName = "index"
+		metadata["if_seq_no"] = version.SeqNo
+		metadata["if_primary_term"] = version.PrimaryTerm
+	}
+	action := util.MapStr{actionName: metadata}
+
+	buffer := bytes.Buffer{}
+	buffer.Write(util.MustToJSONBytes(action))
+	buffer.WriteByte('\n')
+	buffer.Write(util.MustToJSONBytes(doc))
+	buffer.WriteByte('\n')
+
+	result, err := client.Bulk(buffer.Bytes())
+	if result != nil {
+		errorType, _ := jsonparser.GetString(result.Body, "items", "[0]", actionName, "error", "type")
+		switch errorType {
+		case "":
+		case "version_conflict_engine_exception":
+			return api.ErrVersionConflict
+		default:
+			return errors.Errorf("failed to save [%v]: %v", id, string(result.Body))
+		}
+	}
+	return err
+}
+
+//getVersion returns the version of the document read by the get request
+func getVersion(response *elastic.GetResponse) (api.Version, error) {
+	seqNo, err := jsonparser.GetInt(response.RawResult.Body, "_seq_no")
+	if err != nil {
+		return api.Version{}, errors.New("_seq_no was not found, conditional writes need elasticsearch 6.7+")
+	}
+	primaryTerm, err := jsonparser.GetInt(response.RawResult.Body, "_primary_term")
+	if err != nil {
+		return api.Version{}, err
+	}
+	return api.Version{SeqNo: seqNo, PrimaryTerm: primaryTerm}, nil
+}
+
 //update operation will merge the new data into the old data
 func (handler *ElasticORM) Update(ctx *api.Context, o interface{}) error {
 	var refresh string
@@ -28,6 +28,7 @@
 package elastic
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
 	"github.com/bkaradzic/go-lz4"
@@ -142,6 +143,40 @@ func (store *ElasticStore) AddValue(bucket string, key []byte, value []byte) err
 	return err
 }
 
+// CompareAndSwap replaces the value only if the stored one is still the old value, on the seq_no of the document
+func (store *ElasticStore) CompareAndSwap(bucket string, key []byte, old []byte, value []byte) (bool, error) {
+	id := getKey(bucket, string(key))
+	response, err := store.Client.Get(store.Config.IndexName, "_doc", id)
+	if err != nil {
+		return false, err
+	}
+	version := orm.Version{}
+	var current []byte
+	if response.Found {
+		if version, err = getVersion(response); err != nil {
+			return false, err
+		}
+		if content, ok := response.Source["content"].(string); ok {
+			if current, err = base64.URLEncoding.DecodeString(content); err != nil {
+				return false, err
+			}
+		}
+	} else if response.StatusCode != http.StatusNotFound {
+		return false, fmt.Errorf("get value error: %v", util.MustToJSON(response.ESError))
+	}
+	if (current == nil) != (old == nil) || !bytes.Equal(current, old) {
+		return false, nil
+	}
+
+	file := Blob{}
+	file.Content = base64.URLEncoding.EncodeToString(value)
+	err = indexIfVersion(store.Client, store.Config.IndexName, "_doc", id, file, version)
+	if err == orm.ErrVersionConflict {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (store *ElasticStore) DeleteKey(bucket string, key []byte) error {
 	_, err := store.Client.Delete(store.Config.IndexName, "_doc", getKey(bucket, string(key)))
 	return err
@@ -52,9 +52,10 @@ func (h *APIHandler) setKeystoreValue(w http.ResponseWriter, req *http.Request,
 		return
 	}
 	if reqBody.Key == "" {
-		h.WriteError(w, "key cannot be empty", http.StatusInternalServerError)
+		h.WriteError(w, "keystore.api.empty_key", http.StatusInternalServerError)
 		return
 	}
+	api.SetAuditTarget(req, "keystore/"+reqBody.Key)
 	ks, err := keystore.GetWriteableKeystore()
 	if err != nil {
 		log.Error(err)
@@ -31,5 +31,6 @@ import "infini.sh/framework/core/api"
 
 func Init() {
 	handler := APIHandler{}
-	api.HandleAPIMethod(api.POST, "/keystore", handler.setKeystoreValue)
+	api.HandleAPIMethod(api.POST, "/keystore", handler.setKeystoreValue,
+		api.WithAudit("keystore:set", ""))
 }
@@ -28,6 +28,7 @@
 package keystore
 
 import (
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/modules/keystore/api"
@@ -37,6 +38,10 @@ func (module *KeystoreModule) Name() string {
 	return "keystore"
 }
 
+func init() {
+	config.RegisterSectionSchema("keystore", &KeystoreModule{Enabled: true})
+}
+
 func (module *KeystoreModule) Setup() {
 	module.Enabled = true
 	exists, err := env.ParseConfig("keystore", &module)
@@ -58,6 +58,15 @@ type MetricConfig struct {
 
 	Tags   []string          `config:"tags"`
 	Labels map[string]string `config:"labels"`
+
+	//off, warn or reject, invalid events are logged and saved by default
+	SchemaValidation string `config:"schema_validation"`
+	//schemas of the custom events, the built-in metrics have their schemas registered
+	Schemas []event.Schema `config:"schemas"`
+}
+
+func init() {
+	RegisterSectionSchema("metrics", MetricConfig{Enabled: true})
 }
 
 type MetricsModule struct {
@@ -77,6 +86,14 @@ func (module *MetricsModule) loadConfig(cfg *MetricConfig) {
 	event.RegisterMeta(&meta)
 	module.agent=&meta
 
+	if err := event.SetSchemaValidation(cfg.SchemaValidation); err != nil {
+		panic(err)
+	}
+	event.MustRegisterSchema(instanceSchema())
+	for _, v := range cfg.Schemas {
+		event.MustRegisterSchema(v)
+	}
+
 	tail := fmt.Sprintf("ip: %v,host: %v", meta.MajorIP, meta.Hostname)
 	if len(meta.Labels) > 0 {
 		tail = tail + ",labels: " + util.JoinMapString(meta.Labels, "->")
@@ -129,7 +146,9 @@ func (module *MetricsModule) CollectESMetric() {
 					es.Collect()
 				},
 			}
-			task.RegisterScheduleTask(task1)
+			if _, err := task.RegisterScheduleTask(task1); err != nil {
+				log.Errorf("failed to register task [%v]: %v", task1.Description, err)
+			}
 		}
 	}
 }
@@ -153,7 +172,9 @@ func (module *MetricsModule) CollectAgentMetric() {
 					agentM.Collect()
 				},
 			}
-			task.RegisterScheduleTask(task1)
+			if _, err := task.RegisterScheduleTask(task1); err != nil {
+				log.Errorf("failed to register task [%v]: %v", task1.Description, err)
+			}
 		}
 	}
 }
@@ -184,7 +205,9 @@ func (module *MetricsModule) CollectHostMetric() {
 				netM.Collect()
 			},
 		}
-		task.RegisterScheduleTask(netTask)
+		if _, err := task.RegisterScheduleTask(netTask); err != nil {
+			log.Errorf("failed to register task [%v]: %v", netTask.Description, err)
+		}
 	}
 
 	if module.config.DiskConfig != nil {
@@ -206,7 +229,9 @@ func (module *MetricsModule) CollectHostMetric() {
 				diskM.Collect()
 			},
 		}
-		task.RegisterScheduleTask(diskTask)
+		if _, err := task.RegisterScheduleTask(diskTask); err != nil {
+			log.Errorf("failed to register task [%v]: %v", diskTask.Description, err)
+		}
 	}
 
 	if module.config.CPUConfig != nil {
@@ -228,7 +253,9 @@ func (module *MetricsModule) CollectHostMetric() {
 				cpuM.Collect()
 			},
 		}
-		task.RegisterScheduleTask(cpuTask)
+		if _, err := task.RegisterScheduleTask(cpuTask); err != nil {
+			log.Errorf("failed to register task [%v]: %v", cpuTask.Description, err)
+		}
 	}
 
 	if module.config.MemoryConfig != nil {
@@ -250,23 +277,24 @@ func (module *MetricsModule) CollectHostMetric() {
 				memoryM.Collect()
 			},
 		}
-		task.RegisterScheduleTask(memTask)
+		if _, err := task.RegisterScheduleTask(memTask); err != nil {
+			log.Errorf("failed to register task [%v]: %v", memTask.Description, err)
+		}
 	}
 }
 
 func (module *MetricsModule) Start() error {
 
-	NotifyOnConfigSectionChange("metrics", func(pCfg, cCfg *Config) {
+	RegisterSectionReloader("metrics", func(pCfg, cCfg *Config) error {
 
 		if cCfg == nil {
-			return
+			return nil
 		}
 
 		newCfg := &MetricConfig{}
 		err := cCfg.Unpack(newCfg)
-		if err != nil || newCfg == nil {
-			log.Error(err)
-			return
+		if err != nil {
+			return err
 		}
 
 		for _, taskId := range module.taskIDs {
@@ -279,6 +307,7 @@ func (module *MetricsModule) Start() error {
 
 		module.loadConfig(module.config)
 
+		return nil
 	})
 
 	return nil
@@ -0,0 +1,172 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"infini.sh/framework/core/event"
+	"infini.sh/framework/core/global"
+)
+
+// typed return the fields with the same type under the prefix
+func typed(prefix, fieldType string, names ...string) []event.Field {
+	fields := make([]event.Field, 0, len(names))
+	for _, name := range names {
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		fields = append(fields, event.Field{Name: name, Type: fieldType})
+	}
+	return fields
+}
+
+func join(fields ...[]event.Field) []event.Field {
+	var out []event.Field
+	for _, v := range fields {
+		out = append(out, v...)
+	}
+	return out
+}
+
+var (
+	ipLabels      = typed("", event.TypeKeyword, "ip")
+	clusterLabels = []event.Field{
+		{Name: "cluster_id", Type: event.TypeKeyword, Required: true},
+		{Name: "cluster_uuid", Type: event.TypeKeyword},
+	}
+	usage = []string{"total.bytes", "free.bytes", "used.bytes"}
+)
+
+func init() {
+	schemas := []event.Schema{
+		{
+			Category: "host", Name: "cpu", Description: "cpu usage in percent",
+			Fields: join(
+				typed("host.cpu", event.TypeDouble, "used_percent", "idle", "system", "user", "iowait"),
+				typed("host.cpu.load", event.TypeDouble, "load1", "load5", "load15"),
+			),
+		},
+		{
+			Category: "host", Name: "memory",
+			Fields: join(
+				typed("host.memory", event.TypeLong, append(usage, "cached.bytes", "available.bytes")...),
+				typed("host.memory", event.TypeDouble, "used.percent"),
+			),
+		},
+		{
+			Category: "host", Name: "swap",
+			Fields: join(
+				typed("host.swap", event.TypeLong, append(usage, "page_in", "page_out", "swap_in", "swap_out", "page_fault", "major_page_fault")...),
+				typed("host.swap", event.TypeDouble, "used.percent"),
+			),
+		},
+		{
+			Category: "host", Name: "filesystem",
+			Fields: join(
+				typed("host.filesystem", event.TypeKeyword, "mount_point"),
+				typed("host.filesystem", event.TypeLong, usage...),
+				typed("host.filesystem", event.TypeDouble, "used.percent"),
+			),
+		},
+		{
+			Category: "host", Name: "filesystem_summary",
+			Fields: join(
+				typed("host.filesystem_summary", event.TypeKeyword, "mount_point"),
+				typed("host.filesystem_summary", event.TypeLong, usage...),
+				typed("host.filesystem_summary", event.TypeDouble, "used.percent"),
+			),
+		},
+		{
+			Category: "host", Name: "diskio", Labels: ipLabels,
+			Fields: typed("host.diskio", event.TypeLong, "read.bytes", "read.time_in_ms", "write.bytes", "write.time_in_ms"),
+		},
+		{
+			Category: "host", Name: "diskio_summary",
+			Fields: typed("host.diskio_summary", event.TypeLong, "read.bytes", "read.time_in_ms", "write.bytes", "write.time_in_ms"),
+		},
+		{
+			Category: "host", Name: "network", Labels: ipLabels,
+			Fields: join(
+				typed("host.network", event.TypeKeyword, "name"),
+				typed("host.network", event.TypeLong, "in.bytes", "in.packets", "in.errors", "in.dropped",
+					"out.bytes", "out.packets", "out.errors", "out.dropped"),
+			),
+		},
+		{
+			Category: "host", Name: "network_summary", Labels: ipLabels,
+			Fields: typed("host.network_summary", event.TypeLong, "in.bytes", "in.packets", "in.errors", "in.dropped",
+				"out.bytes", "out.packets", "out.errors", "out.dropped"),
+		},
+		{
+			Category: "host", Name: "network_throughput", Labels: ipLabels,
+			Fields: typed("host.network_throughput", event.TypeLong, "in.bytes", "in.packets", "out.bytes", "out.packets"),
+		},
+		{
+			Category: "host", Name: "network_sockets", Labels: ipLabels,
+			Fields: join(
+				//per ip connection stats are kept under tcp
+				typed("host", event.TypeObject, "network_sockets", "network_sockets.tcp"),
+				typed("host.network_sockets", event.TypeLong, "all.connections", "all.established", "all.listening",
+					"all.udp", "all.orphan", "memory.tcp", "memory.udp"),
+			),
+		},
+		{
+			Category: "elasticsearch", Name: "cluster_health", Labels: clusterLabels,
+			Fields: join(
+				typed("elasticsearch", event.TypeObject, "cluster_health"),
+				typed("elasticsearch.cluster_health", event.TypeKeyword, "cluster_name", "status"),
+				typed("elasticsearch.cluster_health", event.TypeBoolean, "timed_out"),
+				typed("elasticsearch.cluster_health", event.TypeLong, "number_of_nodes", "number_of_data_nodes",
+					"active_primary_shards", "active_shards", "relocating_shards", "initializing_shards",
+					"unassigned_shards", "delayed_unassigned_shards", "number_of_pending_tasks", "number_of_in_flight_fetch"),
+				typed("elasticsearch.cluster_health", event.TypeDouble, "task_max_waiting_in_queue_millis", "active_shards_percent_as_number"),
+			),
+		},
+		{
+			Category: "elasticsearch", Name: "cluster_stats", Labels: clusterLabels,
+			Fields: typed("elasticsearch", event.TypeObject, "cluster_stats"),
+		},
+		{
+			Category: "elasticsearch", Name: "node_stats",
+			Labels: join(clusterLabels, typed("", event.TypeKeyword, "node_id", "node_name", "ip", "transport_address")),
+			Fields: typed("elasticsearch", event.TypeObject, "node_stats"),
+		},
+		{
+			Category: "elasticsearch", Name: "index_stats",
+			Labels: join(clusterLabels, typed("", event.TypeKeyword, "index_id", "index_uuid", "index_name")),
+			Fields: typed("elasticsearch", event.TypeObject, "index_stats"),
+		},
+	}
+	for _, s := range schemas {
+		event.MustRegisterSchema(s)
+	}
+}
+
+// instanceSchema is named after the application
+func instanceSchema() event.Schema {
+	return event.Schema{
+		Category: "instance", Name: global.Env().GetAppLowercaseName(),
+		Labels: typed("", event.TypeKeyword, "id", "name", "ip"),
+		Fields: typed("", event.TypeObject, "instance"),
+	}
+}
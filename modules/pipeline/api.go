@@ -27,11 +27,13 @@ import (
 	"net/http"
 
 	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/api"
 	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/util"
 	"infini.sh/framework/lib/go-ucfg"
+	"infini.sh/framework/modules/pipeline/simulate"
 )
 
 func (module *PipeModule) getPipelinesHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -87,6 +89,7 @@ func (module *PipeModule) getPipelineStatus(id string, config string, processor
 		StartTime:  c1.GetStartTime(),
 		EndTime:    c1.GetEndTime(),
 		Context:    c1.CloneData(),
+		Nodes:      c1.GetNodeStates(),
 	}
 	if config != "false" {
 		v1, ok := module.configs.Load(id)
@@ -104,6 +107,7 @@ func (module *PipeModule) getPipelineStatus(id string, config string, processor
 				cfg.Processors[i].Unpack(processorMap)
 				ret.Processors = append(ret.Processors, processorMap)
 			}
+			ret.Graph = cfg.Graph.ToMap()
 		}
 	}
 	return ret
@@ -115,7 +119,7 @@ func (module *PipeModule) getPipelineHandler(w http.ResponseWriter, req *http.Re
 	processor := module.Get(req, "processor", "false")
 	status := module.getPipelineStatus(id, config, processor)
 	if status == nil {
-		module.WriteError(w, "pipeline not found", http.StatusNotFound)
+		module.WriteError(w, "pipeline.api.not_found", http.StatusNotFound)
 		return
 	}
 	module.WriteJSON(w, status, 200)
@@ -141,9 +145,28 @@ func (module *PipeModule) createPipelineHandler(w http.ResponseWriter, req *http
 	}
 	pipelineConfig := obj.PipelineConfigV2
 	pipelineConfig.Processors = processors
+	api.SetAuditTarget(req, "pipeline/"+pipelineConfig.Name)
+	if obj.Graph != nil {
+		graph, err := ucfg.NewFrom(obj.Graph)
+		if err != nil {
+			module.WriteError(w, err.Error(), http.StatusBadRequest)
+			log.Error("failed to parse graph config: ", err)
+			return
+		}
+		pipelineConfig.Graph = &pipeline.GraphConfig{}
+		if err = config.FromConfig(graph).Unpack(pipelineConfig.Graph); err != nil {
+			module.WriteError(w, err.Error(), http.StatusBadRequest)
+			log.Error("failed to parse graph config: ", err)
+			return
+		}
+	}
+	if err = pipelineConfig.Validate(); err != nil {
+		module.WriteLocalizedError(w, err, http.StatusBadRequest)
+		return
+	}
 	err = module.createPipeline(pipelineConfig, true)
 	if err != nil {
-		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		module.WriteLocalizedError(w, err, http.StatusBadRequest)
 		log.Error("failed to start pipeline: ", err)
 		return
 	}
@@ -186,3 +209,96 @@ func (module *PipeModule) stopTaskHandler(w http.ResponseWriter, req *http.Reque
 		})
 	}
 }
+
+func (module *PipeModule) getResourceGroupsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	module.WriteJSON(w, pipeline.GetResourceGroupsUsage(), 200)
+}
+
+func (module *PipeModule) getCheckpointHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	v, ok := module.configs.Load(id)
+	if !ok {
+		module.WriteAckJSON(w, false, 404, util.MapStr{
+			"error": "task not found",
+		})
+		return
+	}
+	checkpoint, err := pipeline.GetCheckpoint(v.(pipeline.PipelineConfigV2))
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if checkpoint == nil {
+		module.WriteAckJSON(w, false, 404, util.MapStr{
+			"error": "checkpoint not found",
+		})
+		return
+	}
+	module.WriteJSON(w, checkpoint, 200)
+}
+
+func (module *PipeModule) resetCheckpointHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	id := ps.ByName("id")
+	v, ok := module.contexts.Load(id)
+	if !ok {
+		module.WriteAckJSON(w, false, 404, util.MapStr{
+			"error": "task not found",
+		})
+		return
+	}
+	ctx := v.(*pipeline.Context)
+	state := ctx.GetRunningState()
+	if state == pipeline.STARTING || state == pipeline.STARTED || state == pipeline.STOPPING {
+		module.WriteError(w, "pipeline.api.running", http.StatusConflict)
+		return
+	}
+	if err := ctx.ResetCheckpoint(); err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteAckOKJSON(w)
+}
+
+func (module *PipeModule) simulatePipelineHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var obj = map[string]interface{}{}
+	err := module.DecodeJSON(req, &obj)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg, err := ucfg.NewFrom(obj)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pipelines := []pipeline.PipelineConfigV2{}
+	module.configs.Range(func(key, value any) bool {
+		pipelines = append(pipelines, value.(pipeline.PipelineConfigV2))
+		return true
+	})
+	fixture, pipelineConfig, err := simulate.ParseFixture(config.FromConfig(cfg), pipelines)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, c := range fixture.Cases {
+		if c.Expected != "" {
+			module.WriteError(w, "pipeline.api.golden_unsupported", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := simulate.Run(pipelineConfig, fixture, simulate.Options{Isolated: true, Strict: true})
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := SimulatePipelineResponse{Passed: true, Results: results}
+	for _, v := range results {
+		if !v.Passed {
+			response.Passed = false
+		}
+	}
+	module.WriteJSON(w, response, 200)
+}
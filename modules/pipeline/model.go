@@ -38,4 +38,6 @@ type PipelineStatus struct {
 	Context    util.MapStr                `json:"context"`
 	Config     *pipeline.PipelineConfigV2 `json:"config"`
 	Processors []map[string]interface{}   `json:"processor"`
+	Graph      util.MapStr                `json:"graph,omitempty"`
+	Nodes      []pipeline.NodeState       `json:"nodes,omitempty"`
 }
@@ -25,7 +25,6 @@ package pipeline
 
 import (
 	"context"
-	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"infini.sh/framework/core/locker"
 	"infini.sh/framework/core/task"
@@ -40,6 +39,7 @@ import (
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/orm"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/rate"
 	"infini.sh/framework/core/util"
@@ -62,6 +62,13 @@ var moduleCfg = struct {
 	PipelineEnabledByDefault bool `config:"pipeline_enabled_by_default"`
 }{PipelineEnabledByDefault: true}
 
+//registered on init, so that the processors are available to the pipeline test command
+func init() {
+	pipeline.RegisterProcessorPluginWithConfigMetadata("dag", pipeline.NewDAGProcessor, pipeline.DAGConfig{})
+	pipeline.RegisterProcessorPluginWithConfigMetadata("echo", NewEchoProcessor, EchoConfig{})
+	config.RegisterSectionSchema("preference", moduleCfg)
+}
+
 func (module *PipeModule) Setup() {
 	if global.Env().IsDebug {
 		log.Debug("pipeline framework config: ", moduleCfg)
@@ -76,16 +83,75 @@ func (module *PipeModule) Setup() {
 	module.contexts = sync.Map{}
 	module.configs = sync.Map{}
 
-	pipeline.RegisterProcessorPlugin("dag", pipeline.NewDAGProcessor)
-	pipeline.RegisterProcessorPlugin("echo", NewEchoProcessor)
+	resourceGroups := []pipeline.ResourceGroupConfig{}
+	ok, err = env.ParseConfig("resource_groups", &resourceGroups)
+	if ok && err == nil {
+		err = pipeline.SetupResourceGroups(resourceGroups)
+	}
+	if err != nil {
+		log.Errorf("invalid resource groups, %v", err)
+		if global.Env().SystemConfig.Configs.PanicOnConfigError {
+			panic(err)
+		}
+	}
 
-	api.HandleAPIMethod(api.GET, "/pipeline/tasks/", module.getPipelinesHandler)
-	api.HandleAPIMethod(api.POST, "/pipeline/tasks/_search", module.searchPipelinesHandler)
-	api.HandleAPIMethod(api.POST, "/pipeline/tasks/", module.createPipelineHandler)
-	api.HandleAPIMethod(api.GET, "/pipeline/task/:id", module.getPipelineHandler)
-	api.HandleAPIMethod(api.DELETE, "/pipeline/task/:id", module.deletePipelineHandler)
-	api.HandleAPIMethod(api.POST, "/pipeline/task/:id/_start", module.startTaskHandler)
-	api.HandleAPIMethod(api.POST, "/pipeline/task/:id/_stop", module.stopTaskHandler)
+	//used by pipelines with the orm checkpoint store
+	orm.MustRegisterSchemaWithIndexName(pipeline.Checkpoint{}, "pipeline_checkpoint")
+
+	api.HandleAPIMethod(api.GET, "/pipeline/tasks/", module.getPipelinesHandler,
+		api.WithSummary("List all pipelines"),
+		api.WithQueryParam("config", "boolean", "include pipeline config", false),
+		api.WithQueryParam("processor", "boolean", "include processor configs", false),
+		api.WithResponse(GetPipelinesResponse{}))
+	api.HandleAPIMethod(api.POST, "/pipeline/tasks/_search", module.searchPipelinesHandler,
+		api.WithSummary("Get pipelines by ids"),
+		api.WithQueryParam("config", "boolean", "include pipeline config", false),
+		api.WithQueryParam("processor", "boolean", "include processor configs", false),
+		api.WithRequest(SearchPipelinesRequest{}),
+		api.WithResponse(GetPipelinesResponse{}),
+		api.WithoutAudit())
+	api.HandleAPIMethod(api.POST, "/pipeline/tasks/", module.createPipelineHandler,
+		api.WithSummary("Create and start a pipeline"),
+		api.WithRequest(CreatePipelineRequest{}),
+		api.RequirePermission("pipeline:create"),
+		api.WithAudit("pipeline:create", ""))
+	api.HandleAPIMethod(api.GET, "/pipeline/task/:id", module.getPipelineHandler,
+		api.WithSummary("Get the status of a pipeline"),
+		api.WithPathParam("id", "pipeline name"),
+		api.WithQueryParam("config", "boolean", "include pipeline config", false),
+		api.WithQueryParam("processor", "boolean", "include processor configs", false),
+		api.WithResponse(PipelineStatus{}))
+	api.HandleAPIMethod(api.DELETE, "/pipeline/task/:id", module.deletePipelineHandler,
+		api.WithSummary("Stop and delete a pipeline"),
+		api.WithPathParam("id", "pipeline name"),
+		api.RequirePermission("pipeline:delete"),
+		api.WithAudit("pipeline:delete", "pipeline/{id}"))
+	api.HandleAPIMethod(api.POST, "/pipeline/task/:id/_start", module.startTaskHandler,
+		api.WithSummary("Start a pipeline"),
+		api.WithPathParam("id", "pipeline name"),
+		api.RequirePermission("pipeline:update"),
+		api.WithAudit("pipeline:start", "pipeline/{id}"))
+	api.HandleAPIMethod(api.POST, "/pipeline/task/:id/_stop", module.stopTaskHandler,
+		api.WithSummary("Stop a pipeline"),
+		api.WithPathParam("id", "pipeline name"),
+		api.RequirePermission("pipeline:update"),
+		api.WithAudit("pipeline:stop", "pipeline/{id}"))
+	api.HandleAPIMethod(api.GET, "/pipeline/task/:id/_checkpoint", module.getCheckpointHandler,
+		api.WithSummary("Get the checkpoint of a pipeline"),
+		api.WithPathParam("id", "pipeline name"),
+		api.WithResponse(pipeline.Checkpoint{}))
+	api.HandleAPIMethod(api.DELETE, "/pipeline/task/:id/_checkpoint", module.resetCheckpointHandler,
+		api.WithSummary("Reset the checkpoint of a stopped pipeline"),
+		api.WithPathParam("id", "pipeline name"),
+		api.RequirePermission("pipeline:update"),
+		api.WithAudit("pipeline:reset_checkpoint", "pipeline/{id}"))
+	api.HandleAPIMethod(api.GET, "/pipeline/resource_groups", module.getResourceGroupsHandler,
+		api.WithSummary("Get the usage and throttling counts of the resource groups"))
+	api.HandleAPIMethod(api.POST, "/pipeline/tasks/_simulate", module.simulatePipelineHandler,
+		api.WithSummary("Run a pipeline against fixtures"),
+		api.WithDescription("Runs the cases of the fixture with in-memory queues and fake elasticsearch clusters, and compares the captured outputs with expected_output. The queues and clusters used by the pipeline must be stubbed by the fixture."),
+		api.WithResponse(SimulatePipelineResponse{}),
+		api.RequirePermission("pipeline:create"))
 
 }
 
@@ -172,32 +238,7 @@ func (module *PipeModule) releaseContext(taskID string) {
 }
 
 func getPipelineConfig() ([]pipeline.PipelineConfigV2, error) {
-	configFile := global.Env().GetConfigFile()
-	configDir := global.Env().GetConfigDir()
-	parentCfg, err := config.LoadFile(configFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load config file: %v, path: %s", err, configFile)
-	}
-	childCfg, err := config.LoadPath(configDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load config dir: %v, path: %s", err, configDir)
-	}
-	err = parentCfg.Merge(childCfg)
-	if err != nil {
-		return nil, fmt.Errorf("failed to merge configs: %v", err)
-	}
-
-	pipelineCfg := []pipeline.PipelineConfigV2{}
-
-	if ok := parentCfg.HasField("pipeline"); ok {
-		parentCfg, err = parentCfg.Child("pipeline", -1)
-		if err != nil {
-			return nil, err
-		}
-		err = parentCfg.Unpack(&pipelineCfg)
-		return pipelineCfg, err
-	}
-	return pipelineCfg, nil
+	return pipeline.LoadConfigs(global.Env().GetConfigFile(), global.Env().GetConfigDir())
 }
 
 func (module *PipeModule) Start() error {
@@ -429,7 +470,7 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 			return errors.New("invalid pipeline config")
 		}
 
-		processor, err := pipeline.NewPipeline(v.Processors)
+		processor, err := pipeline.NewPipelineWithConfig(v)
 		if err != nil {
 			return err
 		}
@@ -520,7 +561,12 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 				ctx.Started()
 				ctx.ResetContext()
 
-				err = processor.Process(ctx)
+				//admission control, a rejected run fails and is retried on next round
+				release, err := ctx.AcquireRun()
+				if err == nil {
+					err = processor.Process(ctx)
+					release()
+				}
 
 				if err != nil {
 					log.Errorf("error on pipeline:%v, %v", cfg.Name, err)
@@ -529,6 +575,11 @@ func (module *PipeModule) createPipeline(v pipeline.PipelineConfigV2, transient
 					if global.Env().IsDebug {
 						log.Debugf("pipeline [%v] end running", cfg.Name)
 					}
+					if cfg.Checkpoint.ClearOnFinish {
+						if err := ctx.ResetCheckpoint(); err != nil {
+							log.Errorf("failed to reset checkpoint of pipeline [%v], %v", cfg.Name, err)
+						}
+					}
 					ctx.Finished()
 				}
 				started = false
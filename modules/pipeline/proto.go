@@ -23,15 +23,24 @@
 
 package pipeline
 
-import "infini.sh/framework/core/pipeline"
+import (
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/modules/pipeline/simulate"
+)
 
 type GetPipelinesResponse map[string]*PipelineStatus
 
 type CreatePipelineRequest struct {
 	pipeline.PipelineConfigV2
 	Processors []map[string]interface{} `json:"processor"`
+	Graph      map[string]interface{}   `json:"graph"`
 }
 
 type SearchPipelinesRequest struct {
 	Ids []string `json:"ids"`
 }
+
+type SimulatePipelineResponse struct {
+	Passed  bool              `json:"passed"`
+	Results []simulate.Result `json:"results"`
+}
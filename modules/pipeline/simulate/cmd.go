@@ -0,0 +1,149 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package simulate
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/kv/kvtest"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+)
+
+// RunCmd handles the pipeline subcommands, eg: pipeline test -update fixtures/*.yml
+func RunCmd(defaultConfigFile string, args []string) {
+	fs := flag.NewFlagSet("pipeline", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Printf("usage : pipeline <command> [<args>]\n")
+		fmt.Printf("These are common pipeline commands used in various situations:\n")
+		fmt.Printf("test\tRun pipelines against fixtures and golden files, eg: pipeline test -update fixtures/*.yml\n")
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var err error
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "test":
+		err = testCmd(defaultConfigFile, args)
+	default:
+		err = fmt.Errorf("unrecognized command %q, command must be one of: test", cmd)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func testCmd(defaultConfigFile string, args []string) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	update := fs.Bool("update", false, "write the outputs to the golden files")
+	configFile := fs.String("config", defaultConfigFile, "the config file to look up pipeline_name from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no fixture files specified")
+	}
+
+	//nothing leaves the process, the kv store and all the queues are kept in memory
+	kv.Register("simulate", kvtest.NewMemoryStore())
+
+	var pipelines []pipeline.PipelineConfigV2
+	failed := 0
+	for _, file := range fs.Args() {
+		cfg, err := config.LoadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to load fixture [%v]: %v", file, err)
+		}
+		if cfg.HasField("pipeline_name") && pipelines == nil {
+			if pipelines, err = loadPipelines(*configFile); err != nil {
+				return err
+			}
+		}
+		fixture, pipelineCfg, err := ParseFixture(cfg, pipelines)
+		if err != nil {
+			return fmt.Errorf("invalid fixture [%v]: %v", file, err)
+		}
+
+		results, err := Run(pipelineCfg, fixture, Options{
+			Isolated: true,
+			BaseDir:  filepath.Dir(file),
+			Update:   *update,
+		})
+		if err != nil {
+			return fmt.Errorf("fixture [%v]: %v", file, err)
+		}
+		for _, v := range results {
+			switch {
+			case v.Updated:
+				fmt.Printf("UPDATED\t%v [%v]\t%v\n", file, v.Case, v.Elapsed)
+			case v.Passed:
+				fmt.Printf("PASS\t%v [%v]\t%v\n", file, v.Case, v.Elapsed)
+			default:
+				failed++
+				fmt.Printf("FAIL\t%v [%v]\t%v\n", file, v.Case, v.Elapsed)
+				if v.Diff != "" {
+					fmt.Println(v.Diff)
+				} else if v.Output.Error != "" {
+					fmt.Printf("\terror: %v\n", v.Output.Error)
+				}
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v case(s) failed", failed)
+	}
+	return nil
+}
+
+// loadPipelines loads the pipelines from the config file and its config folder
+func loadPipelines(file string) ([]pipeline.PipelineConfigV2, error) {
+	if !util.FileExists(file) {
+		return nil, fmt.Errorf("config file [%v] not exists", file)
+	}
+	cfg, err := config.LoadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file [%v]: %v", file, err)
+	}
+	pathCfg := struct {
+		Path config.PathConfig `config:"path"`
+	}{}
+	if err := cfg.Unpack(&pathCfg); err != nil {
+		return nil, err
+	}
+	dir := pathCfg.Path.Config
+	if dir != "" && !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(file), dir)
+	}
+	return pipeline.LoadConfigs(file, dir)
+}
@@ -0,0 +1,172 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package simulate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/modules/elastic/common"
+)
+
+// fakeElasticsearch starts a http server per cluster to stand in for elasticsearch, it acknowledges all the
+// bulk requests and captures the bulk bodies, other requests are answered with empty objects
+type fakeElasticsearch struct {
+	lock     sync.Mutex
+	servers  map[string]*httptest.Server
+	bulks    map[string][]string
+	requests map[string][]string
+}
+
+func newFakeElasticsearch() *fakeElasticsearch {
+	return &fakeElasticsearch{
+		servers:  map[string]*httptest.Server{},
+		bulks:    map[string][]string{},
+		requests: map[string][]string{},
+	}
+}
+
+// register points the cluster to the fake server, existing clusters are not replaced
+func (es *fakeElasticsearch) register(id string) error {
+	if elastic.GetConfigNoPanic(id) != nil {
+		return errors.Errorf("elasticsearch [%v] already exists, use a dedicated cluster id for the simulation", id)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		es.serveHTTP(id, w, req)
+	}))
+	es.lock.Lock()
+	es.servers[id] = server
+	es.lock.Unlock()
+
+	cfg := elastic.ElasticsearchConfig{
+		Enabled: true,
+		Name:    id,
+		Version: "7.10.2",
+	}
+	cfg.ID = id
+	cfg.Endpoint = server.URL
+	_, err := common.InitElasticInstance(cfg)
+	return err
+}
+
+func (es *fakeElasticsearch) close() {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	for id, server := range es.servers {
+		elastic.RemoveInstance(id)
+		server.Close()
+	}
+}
+
+func (es *fakeElasticsearch) outputs() (map[string][]string, map[string][]string) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+	return es.bulks, es.requests
+}
+
+func (es *fakeElasticsearch) serveHTTP(cluster string, w http.ResponseWriter, req *http.Request) {
+	body, err := readBody(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	switch {
+	case req.URL.Path == "/" && req.Method == http.MethodGet:
+		w.Write(util.MustToJSONBytes(util.MapStr{
+			"name":         "simulate",
+			"cluster_name": cluster,
+			"version":      util.MapStr{"number": "7.10.2"},
+		}))
+	case strings.HasSuffix(req.URL.Path, "/_bulk"):
+		es.bulks[cluster] = append(es.bulks[cluster], string(body))
+		w.Write(bulkResponse(body))
+	default:
+		es.requests[cluster] = append(es.requests[cluster], fmt.Sprintf("%v %v", req.Method, req.URL.RequestURI()))
+		w.Write([]byte("{}"))
+	}
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	var reader io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	return io.ReadAll(reader)
+}
+
+// bulkResponse acknowledges every action of the bulk request
+func bulkResponse(body []byte) []byte {
+	items := []util.MapStr{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), 100*1024*1024)
+	expectSource := false
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if expectSource {
+			expectSource = false
+			continue
+		}
+		action := map[string]map[string]interface{}{}
+		if err := json.Unmarshal(line, &action); err != nil {
+			continue
+		}
+		for name, meta := range action {
+			status := 200
+			if name == "index" || name == "create" {
+				status = 201
+			}
+			expectSource = name != "delete"
+			items = append(items, util.MapStr{name: util.MapStr{
+				"_index": meta["_index"],
+				"_id":    meta["_id"],
+				"status": status,
+			}})
+		}
+	}
+	return util.MustToJSONBytes(util.MapStr{"took": 1, "errors": false, "items": items})
+}
@@ -0,0 +1,406 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package simulate
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/queue"
+)
+
+// memoryQueue keeps the messages of the simulated queues in memory, the messages pushed during the
+// simulation are captured as outputs, the preloaded fixture messages are not
+type memoryQueue struct {
+	//capture all the queues, otherwise only the queues in captures
+	isolated bool
+	//fail on the queues not in captures
+	strict   bool
+	captures map[string]bool
+	rejected map[string]bool
+
+	//the queue configs of the simulation, their operations are intercepted
+	scope *queue.Scope
+
+	lock     sync.Mutex
+	messages map[string][][]byte
+	pushed   map[string][][]byte
+	offsets  map[string]queue.Offset
+}
+
+func newMemoryQueue(isolated, strict bool) *memoryQueue {
+	q := &memoryQueue{
+		isolated: isolated || strict,
+		strict:   strict,
+		captures: map[string]bool{},
+		rejected: map[string]bool{},
+		messages: map[string][][]byte{},
+		pushed:   map[string][][]byte{},
+		offsets:  map[string]queue.Offset{},
+	}
+	q.scope = queue.NewScope(q.interceptor)
+	return q
+}
+
+func (q *memoryQueue) handles(id string) bool {
+	if q.isolated {
+		return true
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.captures[id]
+}
+
+// check rejects the queues not stubbed by the fixture in strict mode
+func (q *memoryQueue) check(id string) error {
+	if !q.strict {
+		return nil
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.captures[id] {
+		return nil
+	}
+	q.rejected[id] = true
+	return errors.Errorf("queue [%v] is not stubbed by the fixture", id)
+}
+
+// unstubbed returns the names of the rejected queues
+func (q *memoryQueue) unstubbed() []string {
+	q.lock.Lock()
+	ids := []string{}
+	for id := range q.rejected {
+		ids = append(ids, id)
+	}
+	q.lock.Unlock()
+
+	result := []string{}
+	for _, id := range ids {
+		result = append(result, q.queueName(id))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (q *memoryQueue) queueName(id string) string {
+	if cfg, ok := q.scope.GetConfigByUUID(id); ok && cfg != nil {
+		return cfg.Name
+	}
+	return id
+}
+
+// capture routes the queue to the memory queue
+func (q *memoryQueue) capture(name string) *queue.QueueConfig {
+	cfg := q.scope.GetOrInitConfig(name)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.captures[cfg.ID] = true
+	return cfg
+}
+
+// preload appends the fixture messages without capturing them
+func (q *memoryQueue) preload(name string, messages []string) {
+	cfg := q.capture(name)
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, v := range messages {
+		q.messages[cfg.ID] = append(q.messages[cfg.ID], []byte(v))
+	}
+}
+
+func (q *memoryQueue) push(id string, data []byte) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.messages[id] = append(q.messages[id], data)
+	q.pushed[id] = append(q.pushed[id], data)
+}
+
+// outputs returns the captured messages by queue name
+func (q *memoryQueue) outputs() map[string][]string {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	result := map[string][]string{}
+	for id, messages := range q.pushed {
+		name := q.queueName(id)
+		for _, v := range messages {
+			result[name] = append(result[name], string(v))
+		}
+	}
+	return result
+}
+
+// interceptor wraps the registered handler, queues not handled by the simulation go to the real handler
+func (q *memoryQueue) interceptor(queueType string, handler queue.QueueAPI) queue.QueueAPI {
+	return &interceptedQueue{memory: q, fallback: handler}
+}
+
+type interceptedQueue struct {
+	memory   *memoryQueue
+	fallback queue.QueueAPI
+}
+
+func (h *interceptedQueue) simple() queue.SimpleQueueAPI {
+	if v, ok := h.fallback.(queue.SimpleQueueAPI); ok {
+		return v
+	}
+	panic(errors.New("no simple queue handler was found"))
+}
+
+func (h *interceptedQueue) advanced() queue.AdvancedQueueAPI {
+	if v, ok := h.fallback.(queue.AdvancedQueueAPI); ok {
+		return v
+	}
+	panic(errors.New("no advanced queue handler was found"))
+}
+
+func (h *interceptedQueue) Name() string {
+	return "simulate"
+}
+
+func (h *interceptedQueue) Init(id string) error {
+	if h.memory.handles(id) {
+		return h.memory.check(id)
+	}
+	return h.simple().Init(id)
+}
+
+func (h *interceptedQueue) Close(id string) error {
+	if h.memory.handles(id) {
+		return nil
+	}
+	return h.simple().Close(id)
+}
+
+func (h *interceptedQueue) GetStorageSize(id string) uint64 {
+	if h.memory.handles(id) {
+		return 0
+	}
+	return h.simple().GetStorageSize(id)
+}
+
+func (h *interceptedQueue) Destroy(id string) error {
+	if h.memory.handles(id) {
+		return nil
+	}
+	return h.simple().Destroy(id)
+}
+
+func (h *interceptedQueue) GetQueues() []string {
+	if h.fallback == nil {
+		return nil
+	}
+	return h.fallback.GetQueues()
+}
+
+func (h *interceptedQueue) Push(id string, data []byte) error {
+	if h.memory.handles(id) {
+		if err := h.memory.check(id); err != nil {
+			return err
+		}
+		h.memory.push(id, data)
+		return nil
+	}
+	return h.simple().Push(id, data)
+}
+
+func (h *interceptedQueue) Pop(id string, timeout time.Duration) ([]byte, bool) {
+	if !h.memory.handles(id) {
+		return h.simple().Pop(id, timeout)
+	}
+	if h.memory.check(id) != nil {
+		return nil, true
+	}
+	q := h.memory
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	offset := q.offsets[id]
+	if int(offset.Position) >= len(q.messages[id]) {
+		return nil, true
+	}
+	q.offsets[id] = queue.NewOffset(0, offset.Position+1)
+	return q.messages[id][offset.Position], false
+}
+
+func (h *interceptedQueue) Depth(id string) int64 {
+	if !h.memory.handles(id) {
+		return h.simple().Depth(id)
+	}
+	q := h.memory
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return int64(len(q.messages[id])) - q.offsets[id].Position
+}
+
+func (h *interceptedQueue) LatestOffset(k *queue.QueueConfig) queue.Offset {
+	if !h.memory.handles(k.ID) {
+		return h.advanced().LatestOffset(k)
+	}
+	q := h.memory
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return queue.NewOffset(0, int64(len(q.messages[k.ID])))
+}
+
+func (h *interceptedQueue) GetOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.Offset, error) {
+	if !h.memory.handles(k.ID) {
+		return h.advanced().GetOffset(k, consumer)
+	}
+	if err := h.memory.check(k.ID); err != nil {
+		return queue.Offset{}, err
+	}
+	q := h.memory
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.offsets[k.ID+consumer.Key()], nil
+}
+
+func (h *interceptedQueue) DeleteOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) error {
+	if !h.memory.handles(k.ID) {
+		return h.advanced().DeleteOffset(k, consumer)
+	}
+	q := h.memory
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	delete(q.offsets, k.ID+consumer.Key())
+	return nil
+}
+
+func (h *interceptedQueue) CommitOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig, offset queue.Offset) (bool, error) {
+	if !h.memory.handles(k.ID) {
+		return h.advanced().CommitOffset(k, consumer, offset)
+	}
+	if err := h.memory.check(k.ID); err != nil {
+		return false, err
+	}
+	q := h.memory
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.offsets[k.ID+consumer.Key()] = offset
+	return true, nil
+}
+
+func (h *interceptedQueue) AcquireConsumer(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.ConsumerAPI, error) {
+	if !h.memory.handles(k.ID) {
+		return h.advanced().AcquireConsumer(k, consumer)
+	}
+	offset, err := h.GetOffset(k, consumer)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryConsumer{queue: h.memory, id: k.ID, key: k.ID + consumer.Key(), position: offset.Position}, nil
+}
+
+func (h *interceptedQueue) ReleaseConsumer(k *queue.QueueConfig, c *queue.ConsumerConfig, consumer queue.ConsumerAPI) error {
+	if !h.memory.handles(k.ID) {
+		return h.advanced().ReleaseConsumer(k, c, consumer)
+	}
+	return consumer.Close()
+}
+
+func (h *interceptedQueue) AcquireProducer(cfg *queue.QueueConfig) (queue.ProducerAPI, error) {
+	if !h.memory.handles(cfg.ID) {
+		return h.advanced().AcquireProducer(cfg)
+	}
+	if err := h.memory.check(cfg.ID); err != nil {
+		return nil, err
+	}
+	return &memoryProducer{handler: h}, nil
+}
+
+func (h *interceptedQueue) ReleaseProducer(k *queue.QueueConfig, producer queue.ProducerAPI) error {
+	if !h.memory.handles(k.ID) {
+		return h.advanced().ReleaseProducer(k, producer)
+	}
+	return producer.Close()
+}
+
+type memoryProducer struct {
+	handler *interceptedQueue
+}
+
+func (p *memoryProducer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceResponse, error) {
+	result := make([]queue.ProduceResponse, 0, len(*reqs))
+	for _, req := range *reqs {
+		if err := p.handler.Push(req.Topic, req.Data); err != nil {
+			return nil, err
+		}
+		result = append(result, queue.ProduceResponse{Topic: req.Topic, Timestamp: time.Now().Unix()})
+	}
+	return &result, nil
+}
+
+func (p *memoryProducer) Close() error {
+	return nil
+}
+
+type memoryConsumer struct {
+	queue    *memoryQueue
+	id       string
+	key      string
+	position int64
+}
+
+func (c *memoryConsumer) Close() error {
+	return nil
+}
+
+func (c *memoryConsumer) ResetOffset(segment, readPos int64) error {
+	c.position = readPos
+	return nil
+}
+
+func (c *memoryConsumer) FetchMessages(ctx *queue.Context, numOfMessages int) ([]queue.Message, bool, error) {
+	c.queue.lock.Lock()
+	defer c.queue.lock.Unlock()
+
+	ctx.MessageCount = 0
+	ctx.UpdateInitOffset(0, c.position, 0)
+	ctx.NextOffset = ctx.InitOffset
+
+	messages := []queue.Message{}
+	data := c.queue.messages[c.id]
+	for int(c.position) < len(data) && (numOfMessages <= 0 || len(messages) < numOfMessages) {
+		v := data[c.position]
+		messages = append(messages, queue.Message{
+			Timestamp:  time.Now().Unix(),
+			Offset:     queue.NewOffset(0, c.position),
+			NextOffset: queue.NewOffset(0, c.position+1),
+			Size:       len(v),
+			Data:       v,
+		})
+		c.position++
+		ctx.MessageCount++
+		ctx.UpdateNextOffset(0, c.position)
+	}
+	return messages, len(messages) == 0, nil
+}
+
+func (c *memoryConsumer) CommitOffset(offset queue.Offset) error {
+	c.queue.lock.Lock()
+	defer c.queue.lock.Unlock()
+	c.queue.offsets[c.key] = offset
+	return nil
+}
@@ -0,0 +1,383 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package simulate runs pipelines against fixtures, with in-memory stand-ins for queues, elasticsearch and
+// checkpoints, the captured outputs are compared with golden files
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+// Fixture describes the pipeline under test and its cases
+type Fixture struct {
+	//inline pipeline config, or the name of a pipeline defined in the config files
+	Pipeline     *config.Config `config:"pipeline"`
+	PipelineName string         `config:"pipeline_name"`
+
+	//clusters served by fake elasticsearch servers
+	Elasticsearch []string `config:"elasticsearch"`
+	//queues captured in memory, all the queues are captured in isolated mode
+	Queues []string `config:"queues"`
+
+	Cases []Case `config:"cases"`
+}
+
+type Case struct {
+	Name       string                 `config:"name" json:"name"`
+	Parameters map[string]interface{} `config:"parameters" json:"parameters,omitempty"`
+	//messages preloaded into the queues, by queue name
+	Messages    map[string][]string `config:"messages" json:"messages,omitempty"`
+	TimeoutInMs int                 `config:"timeout_in_ms" json:"timeout_in_ms,omitempty"`
+
+	//golden file relative to the fixture file, or the inline expected output
+	Expected       string                 `config:"expected" json:"expected,omitempty"`
+	ExpectedOutput map[string]interface{} `config:"expected_output" json:"expected_output,omitempty"`
+}
+
+// Output is the captured result and side effects of a case
+type Output struct {
+	State         pipeline.RunningState                `json:"state"`
+	Error         string                               `json:"error,omitempty"`
+	Errors        []string                             `json:"errors,omitempty"`
+	Flow          []string                             `json:"flow,omitempty"`
+	Nodes         map[string]pipeline.NodeRunningState `json:"nodes,omitempty"`
+	Parameters    util.MapStr                          `json:"parameters,omitempty"`
+	QueueMessages map[string][]string                  `json:"queue_messages,omitempty"`
+	BulkRequests  map[string][]string                  `json:"bulk_requests,omitempty"`
+	Requests      map[string][]string                  `json:"requests,omitempty"`
+}
+
+type Result struct {
+	Case    string  `json:"case"`
+	Output  *Output `json:"output"`
+	Passed  bool    `json:"passed"`
+	Diff    string  `json:"diff,omitempty"`
+	Updated bool    `json:"updated,omitempty"`
+	Elapsed string  `json:"elapsed"`
+}
+
+type Options struct {
+	//isolated simulations capture all the queues instead of passing the unstubbed ones to the real handlers
+	Isolated bool
+	//fail on the queues and clusters not stubbed by the fixture, used by the api which shares the process
+	//with the real queues and clusters
+	Strict bool
+	//the folder of golden files
+	BaseDir string
+	//write the outputs to the golden files instead of comparing
+	Update bool
+}
+
+const defaultTimeoutInMs = 10000
+
+const checkpointStoreName = "simulate"
+
+// the checkpoints of the simulated pipelines, it is reset for every case
+var checkpoints = pipeline.NewMemoryCheckpointStore()
+
+func init() {
+	pipeline.RegisterCheckpointStore(checkpointStoreName, checkpoints)
+}
+
+// the checkpoint store and the fake clusters are shared, so the simulations are executed one by one, the
+// queue stand-ins are scoped to the context of the simulated pipeline, the other pipelines are not affected
+var simulationLock = sync.Mutex{}
+
+// ParseFixture unpacks the fixture, the pipeline is either inline or looked up from the pipelines by name
+func ParseFixture(cfg *config.Config, pipelines []pipeline.PipelineConfigV2) (*Fixture, pipeline.PipelineConfigV2, error) {
+	fixture := &Fixture{}
+	pipelineCfg := pipeline.PipelineConfigV2{}
+	if err := cfg.Unpack(fixture); err != nil {
+		return nil, pipelineCfg, err
+	}
+
+	if fixture.Pipeline != nil {
+		if err := fixture.Pipeline.Unpack(&pipelineCfg); err != nil {
+			return nil, pipelineCfg, err
+		}
+		if pipelineCfg.Name == "" {
+			pipelineCfg.Name = "simulate"
+		}
+	} else if fixture.PipelineName != "" {
+		found := false
+		for _, v := range pipelines {
+			if v.Name == fixture.PipelineName {
+				pipelineCfg = v
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, pipelineCfg, errors.Errorf("pipeline [%v] not found", fixture.PipelineName)
+		}
+	} else {
+		return nil, pipelineCfg, errors.New("either pipeline or pipeline_name is required")
+	}
+
+	if len(fixture.Cases) == 0 {
+		return nil, pipelineCfg, errors.New("no cases defined")
+	}
+	return fixture, pipelineCfg, nil
+}
+
+// Run simulates all the cases of the fixture, every case starts with empty stand-ins
+func Run(cfg pipeline.PipelineConfigV2, fixture *Fixture, opts Options) ([]Result, error) {
+	simulationLock.Lock()
+	defer simulationLock.Unlock()
+
+	if opts.Strict {
+		if err := checkClusters(cfg, fixture); err != nil {
+			return nil, err
+		}
+	}
+
+	results := []Result{}
+	for i, c := range fixture.Cases {
+		if c.Name == "" {
+			c.Name = util.IntToString(i)
+		}
+		result, err := runCase(cfg, fixture, c, opts)
+		if err != nil {
+			return results, errors.Wrapf(err, "case [%v]", c.Name)
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+func runCase(cfg pipeline.PipelineConfigV2, fixture *Fixture, c Case, opts Options) (*Result, error) {
+	start := time.Now()
+
+	//the queue configs created by the simulation never reach the real queue configs
+	memory := newMemoryQueue(opts.Isolated, opts.Strict)
+	for _, name := range fixture.Queues {
+		memory.capture(name)
+	}
+	for name, messages := range c.Messages {
+		memory.preload(name, messages)
+	}
+
+	es := newFakeElasticsearch()
+	defer es.close()
+	for _, id := range fixture.Elasticsearch {
+		if err := es.register(id); err != nil {
+			return nil, err
+		}
+	}
+
+	checkpoints.Reset()
+	cfg.Checkpoint.Store = checkpointStoreName
+
+	procs, err := pipeline.NewPipelineWithConfig(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create processors")
+	}
+	defer procs.Release()
+
+	ctx := pipeline.AcquireContext(cfg)
+	defer pipeline.ReleaseContext(ctx)
+	ctx.SetBaseContext(queue.WithScope(context.Background(), memory.scope))
+	for k, v := range c.Parameters {
+		ctx.Set(param.ParaKey(k), v)
+	}
+
+	timeout := c.TimeoutInMs
+	if timeout <= 0 {
+		timeout = defaultTimeoutInMs
+	}
+	ctx.Started()
+	done := make(chan error, 1)
+	go func() {
+		done <- procs.Process(ctx)
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(time.Duration(timeout) * time.Millisecond):
+		ctx.CancelTask()
+		err = errors.Errorf("timeout after %vms", timeout)
+		//give the processors a chance to quit before the stand-ins are removed
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+	}
+	if unstubbed := memory.unstubbed(); len(unstubbed) > 0 && err == nil {
+		err = errors.Errorf("queues %v are not stubbed by the fixture", unstubbed)
+	}
+	if err != nil {
+		ctx.Failed(err)
+	} else if !ctx.GetRunningState().IsEnded() {
+		ctx.Finished()
+	}
+
+	output := &Output{
+		State:         ctx.GetRunningState(),
+		Flow:          ctx.GetFlowProcess(),
+		Parameters:    ctx.CloneData(),
+		QueueMessages: memory.outputs(),
+	}
+	output.BulkRequests, output.Requests = es.outputs()
+	if err != nil {
+		output.Error = err.Error()
+	}
+	for _, v := range ctx.Errors() {
+		output.Errors = append(output.Errors, v.Error())
+	}
+	if nodes := ctx.GetNodeStates(); len(nodes) > 0 {
+		output.Nodes = map[string]pipeline.NodeRunningState{}
+		for _, v := range nodes {
+			output.Nodes[v.Name] = v.State
+		}
+	}
+
+	result := &Result{Case: c.Name, Output: output}
+	if err := compare(result, c, opts); err != nil {
+		return nil, err
+	}
+	result.Elapsed = time.Since(start).String()
+	return result, nil
+}
+
+// checkClusters rejects the pipelines referring to the clusters not stubbed by the fixture
+func checkClusters(cfg pipeline.PipelineConfigV2, fixture *Fixture) error {
+	processors := append([]*config.Config{}, cfg.Processors...)
+	if cfg.Graph != nil {
+		for _, v := range cfg.Graph.Nodes {
+			processors = append(processors, v.Processors...)
+			processors = append(processors, v.Race...)
+		}
+	}
+	stubbed := map[string]bool{}
+	for _, v := range fixture.Elasticsearch {
+		stubbed[v] = true
+	}
+	for _, v := range processors {
+		if v == nil {
+			continue
+		}
+		m := map[string]interface{}{}
+		if err := v.Unpack(&m); err != nil {
+			return err
+		}
+		for _, id := range referredClusters(m) {
+			if !stubbed[id] {
+				return errors.Errorf("elasticsearch [%v] is not stubbed by the fixture", id)
+			}
+		}
+	}
+	return nil
+}
+
+// referredClusters collects the values of the elasticsearch settings, the nested processors included
+func referredClusters(v interface{}) []string {
+	result := []string{}
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, item := range x {
+			if id, ok := item.(string); ok && k == "elasticsearch" {
+				result = append(result, id)
+				continue
+			}
+			result = append(result, referredClusters(item)...)
+		}
+	case []interface{}:
+		for _, item := range x {
+			result = append(result, referredClusters(item)...)
+		}
+	}
+	return result
+}
+
+// compare checks the output with the expected one, a case without expectation passes if not failed
+func compare(result *Result, c Case, opts Options) error {
+	actual, err := normalize(result.Output)
+	if err != nil {
+		return err
+	}
+
+	var expected interface{}
+	switch {
+	case c.Expected != "":
+		file := c.Expected
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(opts.BaseDir, file)
+		}
+		if opts.Update {
+			data, err := json.MarshalIndent(actual, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err = os.WriteFile(file, append(data, '\n'), 0644); err != nil {
+				return err
+			}
+			result.Passed = true
+			result.Updated = true
+			return nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read golden file")
+		}
+		if err = json.Unmarshal(data, &expected); err != nil {
+			return errors.Wrapf(err, "invalid golden file [%v]", file)
+		}
+	case c.ExpectedOutput != nil:
+		if expected, err = normalize(c.ExpectedOutput); err != nil {
+			return err
+		}
+	default:
+		result.Passed = result.Output.State != pipeline.FAILED
+		return nil
+	}
+
+	result.Passed = reflect.DeepEqual(expected, actual)
+	if !result.Passed {
+		result.Diff = diffJSON(expected, actual)
+	}
+	return nil
+}
+
+// normalize converts the object to the generic json types, so that the objects are comparable
+func normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
@@ -0,0 +1,139 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package simulate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/kv/kvtest"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+func TestBulkResponse(t *testing.T) {
+	body := "{\"index\":{\"_index\":\"test\",\"_id\":\"1\"}}\n{\"name\":\"a\"}\n" +
+		"{\"delete\":{\"_index\":\"test\",\"_id\":\"2\"}}\n" +
+		"{\"update\":{\"_index\":\"test\",\"_id\":\"3\"}}\n{\"doc\":{\"name\":\"c\"}}\n"
+	response := util.MapStr{}
+	util.MustFromJSONBytes(bulkResponse([]byte(body)), &response)
+	assert.Equal(t, false, response["errors"])
+
+	items := response["items"].([]interface{})
+	assert.Equal(t, 3, len(items))
+	assert.Equal(t, float64(201), items[0].(map[string]interface{})["index"].(map[string]interface{})["status"])
+	assert.Equal(t, "2", items[1].(map[string]interface{})["delete"].(map[string]interface{})["_id"])
+	assert.Equal(t, float64(200), items[2].(map[string]interface{})["update"].(map[string]interface{})["status"])
+}
+
+func TestMemoryQueue(t *testing.T) {
+	kvtest.Register("simulate")
+	memory := newMemoryQueue(false, false)
+
+	memory.preload("simulate_input", []string{"a", "b"})
+	output := memory.capture("simulate_output")
+	assert.True(t, memory.handles(output.ID))
+	assert.False(t, memory.handles("simulate_other"))
+
+	input := memory.scope.GetOrInitConfig("simulate_input")
+	assert.Equal(t, int64(2), queue.Depth(input))
+	data, timeout, err := queue.PopTimeout(input, 0)
+	assert.Nil(t, err)
+	assert.False(t, timeout)
+	assert.Equal(t, "a", string(data))
+
+	assert.Nil(t, queue.Push(output, []byte("c")))
+	//preloaded messages are not outputs
+	assert.Equal(t, map[string][]string{"simulate_output": {"c"}}, memory.outputs())
+}
+
+func TestStrictMemoryQueue(t *testing.T) {
+	kvtest.Register("simulate")
+	memory := newMemoryQueue(true, true)
+
+	input := memory.capture("simulate_strict_input")
+	assert.Nil(t, queue.Push(input, []byte("a")))
+	other := memory.scope.GetOrInitConfig("simulate_strict_other")
+	assert.NotNil(t, queue.Push(other, []byte("b")))
+	assert.Equal(t, []string{"simulate_strict_other"}, memory.unstubbed())
+
+	//the queue configs are kept in the scope
+	assert.False(t, queue.IsConfigExists("simulate_strict_input"))
+	_, ok := queue.GetConfigByUUID(other.ID)
+	assert.False(t, ok)
+}
+
+func TestCheckClusters(t *testing.T) {
+	processor, err := config.NewConfigFrom(map[string]interface{}{
+		"bulk_indexing": map[string]interface{}{
+			"queues":        map[string]interface{}{"type": "bulk_reshuffle"},
+			"elasticsearch": "simulate_es",
+		},
+	})
+	assert.Nil(t, err)
+	cfg := pipeline.PipelineConfigV2{Processors: []*config.Config{processor}}
+
+	assert.Nil(t, checkClusters(cfg, &Fixture{Elasticsearch: []string{"simulate_es"}}))
+	assert.NotNil(t, checkClusters(cfg, &Fixture{}))
+}
+
+func TestCompare(t *testing.T) {
+	output := &Output{
+		State:         pipeline.FINISHED,
+		QueueMessages: map[string][]string{"output": {"a"}},
+	}
+
+	result := &Result{Output: output}
+	assert.Nil(t, compare(result, Case{}, Options{}))
+	assert.True(t, result.Passed)
+
+	result = &Result{Output: output}
+	expected := map[string]interface{}{"state": "FINISHED", "queue_messages": map[string]interface{}{"output": []interface{}{"a"}}}
+	assert.Nil(t, compare(result, Case{ExpectedOutput: expected}, Options{}))
+	assert.True(t, result.Passed)
+	assert.Equal(t, "", result.Diff)
+
+	result = &Result{Output: output}
+	expected["state"] = "FAILED"
+	assert.Nil(t, compare(result, Case{ExpectedOutput: expected}, Options{}))
+	assert.False(t, result.Passed)
+	assert.Contains(t, result.Diff, "-   \"state\": \"FAILED\"")
+	assert.Contains(t, result.Diff, "+   \"state\": \"FINISHED\"")
+
+	//golden files are written in update mode and compared afterwards
+	dir := t.TempDir()
+	result = &Result{Output: output}
+	assert.Nil(t, compare(result, Case{Expected: "golden.json"}, Options{BaseDir: dir, Update: true}))
+	assert.True(t, result.Updated)
+	result = &Result{Output: output}
+	assert.Nil(t, compare(result, Case{Expected: "golden.json"}, Options{BaseDir: dir}))
+	assert.True(t, result.Passed)
+}
+
+func TestDiffLines(t *testing.T) {
+	diff := diffLines([]string{"a", "b", "c"}, []string{"a", "c", "d"})
+	assert.Equal(t, "  a\n- b\n  c\n+ d\n", diff)
+}
@@ -48,25 +48,84 @@ type API struct {
 
 func init() {
 	module := API{}
-	api.HandleAPIMethod(api.GET, "/queue/stats", module.QueueStatsAction)
-	api.HandleAPIMethod(api.GET, "/queue/:id/stats", module.SingleQueueStatsAction)
-	api.HandleAPIMethod(api.GET, "/queue/:id/_scroll", module.QueueExplore)
-
-	api.HandleAPIMethod(api.DELETE, "/queue/:id", module.DeleteQueue)
-	api.HandleAPIMethod(api.DELETE, "/queue/_search", module.DeleteQueuesByQuery)
+	api.HandleAPIMethod(api.GET, "/queue/stats", module.QueueStatsAction,
+		api.WithSummary("Get stats of all queues"),
+		api.WithQueryParam("metadata", "boolean", "include queue metadata", false),
+		api.WithQueryParam("consumers", "boolean", "include consumer offsets", false),
+		api.WithQueryParam("use_key", "boolean", "use queue id as key instead of name", false))
+	api.HandleAPIMethod(api.GET, "/queue/:id/stats", module.SingleQueueStatsAction,
+		api.WithSummary("Get stats of the queue"),
+		api.WithPathParam("id", "queue id or name"),
+		api.WithQueryParam("metadata", "boolean", "include queue metadata", false),
+		api.WithQueryParam("consumers", "boolean", "include consumer offsets", false),
+		api.WithQueryParam("use_key", "boolean", "use queue id as key instead of name", false))
+	api.HandleAPIMethod(api.GET, "/queue/:id/_scroll", module.QueueExplore,
+		api.WithSummary("Peek messages of the queue"),
+		api.WithPathParam("id", "queue id or name"),
+		api.WithQueryParam("offset", "string", "start offset, eg: 0,0", false),
+		api.WithQueryParam("size", "integer", "max num of messages", false),
+		api.WithQueryParam("group", "string", "consumer group", false),
+		api.WithQueryParam("name", "string", "consumer name", false))
+
+	api.HandleAPIMethod(api.DELETE, "/queue/:id", module.DeleteQueue,
+		api.WithSummary("Delete the queue and its consumers"),
+		api.WithPathParam("id", "queue id or name"),
+		api.RequirePermission("queue:delete"),
+		api.WithAudit("queue:delete", "queue/{id}"))
+	api.HandleAPIMethod(api.DELETE, "/queue/_search", module.DeleteQueuesByQuery,
+		api.WithSummary("Delete queues matched the selector"),
+		api.WithRequest(DeleteQueuesByQueryRequest{}),
+		api.RequirePermission("queue:delete"),
+		api.WithAudit("queue:delete_by_query", ""))
 
 	//create consumer
 	//api.HandleAPIMethod(api.POST,"/queue/:id/consumer/:consumer_id", module.QueueResetConsumerOffset)
 
 	//reset consumer offset
-	api.HandleAPIMethod(api.PUT, "/queue/:id/consumer/:consumer_id/offset", module.QueueResetConsumerOffset)
+	api.HandleAPIMethod(api.PUT, "/queue/:id/consumer/:consumer_id/offset", module.QueueResetConsumerOffset,
+		api.WithSummary("Reset the offset of the consumer"),
+		api.WithQueryParam("offset", "string", "new offset, eg: 0,0", true),
+		api.RequirePermission("queue:update"),
+		api.WithAudit("queue:reset_consumer_offset", "queue/{id}/consumer/{consumer_id}"))
 	//get consumer offset
-	api.HandleAPIMethod(api.GET, "/queue/:id/consumer/:consumer_id/offset", module.QueueGetConsumerOffset)
+	api.HandleAPIMethod(api.GET, "/queue/:id/consumer/:consumer_id/offset", module.QueueGetConsumerOffset,
+		api.WithSummary("Get the offset of the consumer"))
 
 	// delete consumer and it's offset
-	api.HandleAPIMethod(api.DELETE, "/queue/:id/consumer/:consumer_id", module.QueueDeleteConsumerByID)
+	api.HandleAPIMethod(api.DELETE, "/queue/:id/consumer/:consumer_id", module.QueueDeleteConsumerByID,
+		api.WithSummary("Delete the consumer and its offset"),
+		api.RequirePermission("queue:update"),
+		api.WithAudit("queue:delete_consumer", "queue/{id}/consumer/{consumer_id}"))
 	// delete all consumers of queues specified by query
-	api.HandleAPIMethod(api.DELETE, "/queue/consumer/_search", module.DeleteConsumersByQuery)
+	api.HandleAPIMethod(api.DELETE, "/queue/consumer/_search", module.DeleteConsumersByQuery,
+		api.WithSummary("Delete consumers of queues matched the selector"),
+		api.WithRequest(DeleteConsumersByQueryRequest{}),
+		api.RequirePermission("queue:update"),
+		api.WithAudit("queue:delete_consumers_by_query", ""))
+
+	//topic admin, only for the queues backed by partitioned topics, eg: kafka
+	api.HandleAPIMethod(api.GET, "/queue/:id/_partitions", module.GetQueuePartitions,
+		api.WithSummary("Get partitions of the queue"),
+		api.WithPathParam("id", "queue id or name"))
+	api.HandleAPIMethod(api.PUT, "/queue/:id/_partitions", module.UpdateQueuePartitions,
+		api.WithSummary("Increase partitions of the queue"),
+		api.WithPathParam("id", "queue id or name"),
+		api.WithQueryParam("count", "integer", "total num of partitions", true),
+		api.RequirePermission("queue:update"),
+		api.WithAudit("queue:update_partitions", "queue/{id}"))
+	api.HandleAPIMethod(api.GET, "/queue/:id/_retention", module.GetQueueRetention,
+		api.WithSummary("Get retention configs of the queue"),
+		api.WithPathParam("id", "queue id or name"))
+	api.HandleAPIMethod(api.PUT, "/queue/:id/_retention", module.UpdateQueueRetention,
+		api.WithSummary("Update retention configs of the queue"),
+		api.WithPathParam("id", "queue id or name"),
+		api.WithRequest(queue1.RetentionConfig{}),
+		api.RequirePermission("queue:update"),
+		api.WithAudit("queue:update_retention", "queue/{id}"))
+	api.HandleAPIMethod(api.GET, "/queue/:id/_lag", module.GetQueueLag,
+		api.WithSummary("Get lag of the consumer groups per partition"),
+		api.WithPathParam("id", "queue id or name"),
+		api.WithQueryParam("group", "string", "consumer group, all the groups of the queue by default", false))
 }
 
 func (module *API) SingleQueueStatsAction(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
@@ -94,7 +153,7 @@ func (module *API) DeleteQueuesByQuery(w http.ResponseWriter, req *http.Request,
 	}
 
 	if obj.Selector == nil {
-		module.WriteError(w, "no selector specified", http.StatusBadRequest)
+		module.WriteError(w, "queue.api.no_selector", http.StatusBadRequest)
 		return
 	}
 
@@ -182,10 +241,15 @@ func (module *API) getQueueStats(t, q string, metadata string, consumer string,
 	qd := util.MapStr{}
 	if cfg.Type == "disk" || cfg.Type == "" {
 		storeSize := queue1.GetStorageSize(q)
-		qd["storage"] = util.MapStr{
+		storage := util.MapStr{
 			"local_usage":          util.ByteSize(storeSize),
 			"local_usage_in_bytes": storeSize,
 		}
+		//local cache limits and the tier of each segment
+		if tier, err := queue1.GetTierStats(cfg); err == nil {
+			storage["tiering"] = tier
+		}
+		qd["storage"] = storage
 	}
 
 	if metadata != "false" {
@@ -410,7 +474,7 @@ func (module *API) DeleteConsumersByQuery(w http.ResponseWriter, req *http.Reque
 		return
 	}
 	if obj.Selector == nil {
-		module.WriteError(w, "no selector specified", http.StatusBadRequest)
+		module.WriteError(w, "queue.api.no_selector", http.StatusBadRequest)
 		return
 	}
 
@@ -28,11 +28,16 @@
 package common
 
 import (
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/queue"
 )
 
+func init() {
+	config.RegisterSectionSchema("queue", []queue.QueueConfig{})
+}
+
 //Init queue metadata
 func InitQueueMetadata() {
 
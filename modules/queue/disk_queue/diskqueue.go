@@ -217,7 +217,7 @@ func (d *DiskBasedQueue) Put(data []byte) WriteResponse {
 				log.Errorf("queue [%v] is readonly, %v", d.name, err)
 			}
 		}
-		res.Error= errors.New("readonly")
+		res.Error = queue.ErrQueueFull
 		return res
 	}
 
@@ -55,6 +55,8 @@ type DiskQueue struct {
 	queues     sync.Map
 	messages   chan Event
 	cfgs       map[string]*queue.QueueConfig
+	//quit channels of the evictions by queue
+	evictors sync.Map
 }
 
 func (module *DiskQueue) Name() string {
@@ -108,6 +110,8 @@ type DiskQueueConfig struct {
 	Retention RetentionConfig `config:"retention"`
 
 	S3 config.S3BucketConfig `config:"s3"`
+
+	Tiering TieringConfig `config:"tiering"`
 }
 
 type DiskCompress struct {
@@ -195,6 +199,7 @@ func (module *DiskQueue) Init(name string) error {
 	tempQueue := NewDiskQueueByConfig(name, dataPath, module.cfg)
 
 	module.queues.Store(name, tempQueue)
+	module.startEviction(name)
 
 	if module.cfg.CompressAndCleanupDuringInit {
 		module.compressFiles(name, tempQueue.ReadContext().WriteFileNum)
@@ -212,8 +217,8 @@ func GetFileName(queueID string, segmentID int64) string {
 	return path.Join(GetDataPath(queueID), fmt.Sprintf("%09d.dat", segmentID))
 }
 
-func (module *DiskQueue) Setup() {
-	module.cfg = &DiskQueueConfig{
+func defaultDiskQueueConfig() *DiskQueueConfig {
+	return &DiskQueueConfig{
 		Enabled:             true,
 		Default:             true,
 		AutoSkipCorruptFile: true,
@@ -243,13 +248,28 @@ func (module *DiskQueue) Setup() {
 				Enabled: true,
 				Level:   11,
 			}},
+		Tiering: TieringConfig{
+			NumOfSegmentsPrefetch: 2,
+		},
 	}
+}
+
+func init() {
+	config.RegisterSectionSchema("disk_queue", defaultDiskQueueConfig())
+}
+
+func (module *DiskQueue) Setup() {
+	module.cfg = defaultDiskQueueConfig()
 
 	ok, err := env.ParseConfig("disk_queue", module.cfg)
 	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
 		panic(err)
 	}
 
+	if module.cfg.Tiering.Enabled && (!module.cfg.UploadToS3 || module.cfg.S3.Server == "" || module.cfg.S3.Bucket == "") {
+		panic(errors.New("tiered storage requires upload_to_s3 and the s3 server and bucket"))
+	}
+
 	if !module.cfg.Enabled {
 		return
 	}
@@ -297,6 +317,7 @@ func (module *DiskQueue) Destroy(k string) error {
 	if !ok {
 		return nil
 	}
+	module.stopEviction(k)
 	err := (q.(*DiskBasedQueue)).Destroy()
 	if err != nil {
 		return err
@@ -602,6 +623,9 @@ func (module *DiskQueue) onReadComplete(evt Event, lastFilePrepared int64) int64
 		lastFilePrepared = module.prepareFilesToRead(evt.Queue, evt.FileNum)
 	}
 
+	//fetch the remote files ahead of the consumers
+	prefetchSegments(module.cfg, evt.Queue, evt.FileNum)
+
 	//delete old unused files
 	module.deleteUnusedFiles(evt.Queue, evt.FileNum)
 	return -1
@@ -618,6 +642,10 @@ func (module *DiskQueue) Stop() error {
 	}
 
 	close(module.messages)
+	module.evictors.Range(func(key, value interface{}) bool {
+		module.stopEviction(key.(string))
+		return true
+	})
 	module.queues.Range(func(key, value interface{}) bool {
 		q, ok := module.queues.Load(key)
 		if ok {
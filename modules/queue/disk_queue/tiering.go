@@ -0,0 +1,386 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package queue
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/s3"
+	"infini.sh/framework/core/util"
+)
+
+// TieringConfig keeps the uploaded segments in the object storage, the local files are the cache of the segments,
+// evicted under the size or age policy and fetched on demand when the consumers reach them
+type TieringConfig struct {
+	Enabled bool `config:"enabled"`
+	//max bytes of the local segments of each queue, the oldest uploaded segments are evicted first
+	MaxLocalBytes uint64 `config:"max_local_bytes"`
+	//the uploaded segments not modified within the age are evicted
+	MaxLocalAge time.Duration `config:"max_local_age"`
+	//num of the segments fetched ahead of the consumers
+	NumOfSegmentsPrefetch int64 `config:"num_of_segments_prefetch"`
+	//interval of the eviction of each queue, default: 30s
+	EvictInterval time.Duration `config:"evict_interval"`
+}
+
+// maxTierStatsSegments bounds the segments listed by the tier stats, the latest segments are listed
+const maxTierStatsSegments = 1000
+
+// remoteSegments caches the segments confirmed in the remote tier, by queue and segment,
+// the entry is dropped once the segment is evicted
+var remoteSegments sync.Map
+
+func remoteSegmentKey(queueID string, segment int64) string {
+	return fmt.Sprintf("%v/%v", queueID, segment)
+}
+
+func markSegmentUploaded(queueID string, segment int64) {
+	remoteSegments.Store(remoteSegmentKey(queueID, segment), true)
+}
+
+type tierCounters struct {
+	evicted    int64
+	fetched    int64
+	prefetched int64
+}
+
+var tierCountersMap sync.Map
+
+func getTierCounters(queueID string) *tierCounters {
+	v, _ := tierCountersMap.LoadOrStore(queueID, &tierCounters{})
+	return v.(*tierCounters)
+}
+
+type localSegment struct {
+	id      int64
+	size    int64
+	modTime time.Time
+}
+
+// listLocalSegments returns the local segments in order, the flat and the compressed files are counted together
+func listLocalSegments(queueID string) ([]*localSegment, error) {
+	files, err := ioutil.ReadDir(GetDataPath(queueID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	segments := map[int64]*localSegment{}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), compressFileSuffix)
+		if f.IsDir() || !strings.HasSuffix(name, ".dat") {
+			continue
+		}
+		id, err := util.ToInt64(strings.TrimSuffix(name, ".dat"))
+		if err != nil {
+			continue
+		}
+		seg, ok := segments[id]
+		if !ok {
+			seg = &localSegment{id: id}
+			segments[id] = seg
+		}
+		seg.size += f.Size()
+		if f.ModTime().After(seg.modTime) {
+			seg.modTime = f.ModTime()
+		}
+	}
+	result := make([]*localSegment, 0, len(segments))
+	for _, v := range segments {
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id < result[j].id
+	})
+	return result, nil
+}
+
+// segmentInUse returns true if the segment is in reading or to be prefetched by the consumers
+func segmentInUse(cfg *DiskQueueConfig, segment int64, inReading []int64) bool {
+	for _, v := range inReading {
+		if segment >= v && segment <= v+cfg.Tiering.NumOfSegmentsPrefetch {
+			return true
+		}
+	}
+	return false
+}
+
+func (module *DiskQueue) getSegmentsInReading(queueID string) (int64, []int64, bool) {
+	q, ok := module.queues.Load(queueID)
+	if !ok {
+		return 0, nil, false
+	}
+	d := q.(*DiskBasedQueue)
+	inReading := []int64{}
+	d.consumersInReading.Range(func(key, value any) bool {
+		inReading = append(inReading, value.(int64))
+		return true
+	})
+	return d.ReadContext().WriteFileNum, inReading, true
+}
+
+// startEviction evicts the segments of the queue on a ticker, in background of the writes and the reads
+func (module *DiskQueue) startEviction(queueID string) {
+	if !module.cfg.Tiering.Enabled {
+		return
+	}
+	quit := make(chan struct{})
+	if _, loaded := module.evictors.LoadOrStore(queueID, quit); loaded {
+		return
+	}
+	interval := module.cfg.Tiering.EvictInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+				module.evictSegments(queueID)
+			}
+		}
+	}()
+}
+
+func (module *DiskQueue) stopEviction(queueID string) {
+	if v, ok := module.evictors.LoadAndDelete(queueID); ok {
+		close(v.(chan struct{}))
+	}
+}
+
+func (module *DiskQueue) evictSegments(queueID string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("failed to evict queue [%v]: %v", queueID, r)
+		}
+	}()
+	if !module.cfg.Tiering.Enabled {
+		return
+	}
+	writeSegment, inReading, ok := module.getSegmentsInReading(queueID)
+	if !ok {
+		return
+	}
+	evictSegments(module.cfg, queueID, writeSegment, inReading)
+}
+
+// evictSegments removes the local files of the uploaded segments under the size or age policy,
+// the segments in writing, in reading or to be prefetched are kept, the remote tier is checked once per segment
+func evictSegments(cfg *DiskQueueConfig, queueID string, writeSegment int64, inReading []int64) int {
+	lastUploaded := GetLastS3UploadFileNum(queueID)
+	if lastUploaded < 0 {
+		return 0
+	}
+	segments, err := listLocalSegments(queueID)
+	if err != nil {
+		log.Error(err)
+		return 0
+	}
+	var localBytes uint64
+	for _, v := range segments {
+		localBytes += uint64(v.size)
+	}
+
+	store, err := s3.GetObjectStore(cfg.S3.Server)
+	if err != nil {
+		log.Error(err)
+		return 0
+	}
+
+	evicted := 0
+	for _, seg := range segments {
+		overSize := cfg.Tiering.MaxLocalBytes > 0 && localBytes > cfg.Tiering.MaxLocalBytes
+		overAge := cfg.Tiering.MaxLocalAge > 0 && time.Since(seg.modTime) > cfg.Tiering.MaxLocalAge
+		if !overSize && !overAge {
+			continue
+		}
+		if seg.id > lastUploaded || seg.id >= writeSegment || segmentInUse(cfg, seg.id, inReading) {
+			continue
+		}
+
+		//make sure the segment is in the remote tier before removing the local files
+		key := remoteSegmentKey(queueID, seg.id)
+		if _, ok := remoteSegments.Load(key); !ok {
+			fileName := GetFileName(queueID, seg.id)
+			if cfg.Compress.Segment.Enabled {
+				fileName = fileName + compressFileSuffix
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			_, err := store.StatObject(ctx, cfg.S3.Bucket, getS3FileLocation(fileName))
+			cancel()
+			if err != nil {
+				log.Debugf("queue [%v][%v] is not in the remote tier, skip evict: %v", queueID, seg.id, err)
+				continue
+			}
+			markSegmentUploaded(queueID, seg.id)
+		}
+
+		if err := removeSegmentFiles(queueID, seg.id); err != nil {
+			log.Error(err)
+			break
+		}
+		remoteSegments.Delete(key)
+		log.Debugf("queue [%v][%v] evicted from local, size: %v", queueID, seg.id, util.ByteSize(uint64(seg.size)))
+		localBytes -= uint64(seg.size)
+		evicted++
+	}
+	if evicted > 0 {
+		atomic.AddInt64(&getTierCounters(queueID).evicted, int64(evicted))
+	}
+	return evicted
+}
+
+func removeSegmentFiles(queueID string, segment int64) error {
+	file := GetFileName(queueID, segment)
+	for _, v := range []string{file, file + compressFileSuffix} {
+		if err := os.Remove(v); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+var prefetching sync.Map
+
+// prefetchSegments fetches the remote segments after the segment in background
+func prefetchSegments(cfg *DiskQueueConfig, queueID string, segment int64) {
+	if !cfg.Tiering.Enabled || cfg.Tiering.NumOfSegmentsPrefetch <= 0 {
+		return
+	}
+	lastUploaded := GetLastS3UploadFileNum(queueID)
+	for i := segment + 1; i <= segment+cfg.Tiering.NumOfSegmentsPrefetch && i <= lastUploaded; i++ {
+		file := GetFileName(queueID, i)
+		if util.FileExists(file) {
+			continue
+		}
+		key := file
+		if _, loaded := prefetching.LoadOrStore(key, true); loaded {
+			continue
+		}
+		go func(segment int64) {
+			defer prefetching.Delete(key)
+			defer func() {
+				if r := recover(); r != nil {
+					log.Warnf("failed to prefetch queue [%v][%v]: %v", queueID, segment, r)
+				}
+			}()
+			_, exists, _ := smartGetFileName(cfg, queueID, segment, false)
+			if exists {
+				atomic.AddInt64(&getTierCounters(queueID).prefetched, 1)
+				if global.Env().IsDebug {
+					log.Debugf("queue [%v][%v] prefetched", queueID, segment)
+				}
+			}
+		}(i)
+	}
+}
+
+func (module *DiskQueue) GetTierStats(k *queue.QueueConfig) (*queue.TierStats, error) {
+	if !module.cfg.Tiering.Enabled {
+		return nil, errors.New("tiered storage is not enabled")
+	}
+	writeSegment, inReading, ok := module.getSegmentsInReading(k.ID)
+	if !ok {
+		return nil, errors.Errorf("queue [%v] not found", k.ID)
+	}
+	segments, err := listLocalSegments(k.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := getTierCounters(k.ID)
+	stats := &queue.TierStats{
+		MaxLocalBytes: module.cfg.Tiering.MaxLocalBytes,
+		Prefetch:      module.cfg.Tiering.NumOfSegmentsPrefetch,
+		LastUploaded:  GetLastS3UploadFileNum(k.ID),
+		Evicted:       atomic.LoadInt64(&counters.evicted),
+		Fetched:       atomic.LoadInt64(&counters.fetched),
+		Prefetched:    atomic.LoadInt64(&counters.prefetched),
+		Segments:      []queue.SegmentTier{},
+	}
+	if module.cfg.Tiering.MaxLocalAge > 0 {
+		stats.MaxLocalAge = module.cfg.Tiering.MaxLocalAge.String()
+	}
+
+	//from the earliest segment of the local files or the consumers to the segment in writing
+	start := writeSegment
+	local := map[int64]*localSegment{}
+	for _, v := range segments {
+		local[v.id] = v
+		stats.LocalBytes += uint64(v.size)
+		if v.id < start {
+			start = v.id
+		}
+	}
+	for _, v := range inReading {
+		if v < start {
+			start = v
+		}
+	}
+	if _, earliest := module.GetEarlierOffsetByQueueID(k.ID); earliest >= 0 && earliest < start {
+		start = earliest
+	}
+	if writeSegment-start >= maxTierStatsSegments {
+		start = writeSegment - maxTierStatsSegments + 1
+		stats.Truncated = true
+	}
+
+	for i := start; i <= writeSegment; i++ {
+		seg := queue.SegmentTier{Segment: i}
+		v, ok := local[i]
+		switch {
+		case ok && i <= stats.LastUploaded:
+			seg.Tier = queue.TierCached
+		case ok:
+			seg.Tier = queue.TierLocal
+		case i <= stats.LastUploaded:
+			seg.Tier = queue.TierRemote
+		default:
+			//missing segment
+			continue
+		}
+		if ok {
+			seg.LocalBytes = v.size
+		}
+		stats.Segments = append(stats.Segments, seg)
+	}
+	return stats, nil
+}
@@ -0,0 +1,163 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package queue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/env"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/kv"
+	"infini.sh/framework/core/kv/kvtest"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/s3"
+	"infini.sh/framework/core/util"
+)
+
+const testSegmentSize = 100
+
+var tieringEnvOnce sync.Once
+
+// setupTiering writes the segments 0 to 5 of the queue, the segments 0 to 4 are uploaded to the local store
+func setupTiering(t *testing.T, queueID string) *DiskQueue {
+	//the env is shared by the tests, the fetches of the previous test may still read it
+	tieringEnvOnce.Do(func() {
+		e := env.EmptyEnv()
+		e.SystemConfig.PathConfig.Data, _ = ioutil.TempDir("", "tiering_test")
+		global.RegisterEnv(e)
+	})
+	kvtest.Register("tiering_test")
+	//start over when the tests are repeated
+	assert.NoError(t, os.RemoveAll(GetDataPath(queueID)))
+	assert.NoError(t, kv.DeleteKey(queueS3LastFileNum, []byte(queueID)))
+	tierCountersMap.Delete(queueID)
+	store, err := s3.NewLocalStore(t.TempDir(), "", "")
+	assert.NoError(t, err)
+	s3.Register("tiering_test", store)
+	queue.RegisterConfig(&queue.QueueConfig{ID: queueID, Name: queueID})
+
+	module := &DiskQueue{cfg: &DiskQueueConfig{
+		UploadToS3: true,
+		S3:         config.S3BucketConfig{Server: "tiering_test", Bucket: "queue"},
+		Tiering: TieringConfig{
+			Enabled:               true,
+			MaxLocalBytes:         2 * testSegmentSize,
+			NumOfSegmentsPrefetch: 2,
+		},
+	}}
+	module.queues.Store(queueID, &DiskBasedQueue{name: queueID, dataPath: GetDataPath(queueID), writeSegmentNum: 5})
+
+	assert.NoError(t, os.MkdirAll(GetDataPath(queueID), 0755))
+	for i := int64(0); i <= 5; i++ {
+		data := []byte(util.GenerateRandomString(testSegmentSize))
+		assert.NoError(t, ioutil.WriteFile(GetFileName(queueID, i), data, 0644))
+	}
+	module.uploadToS3(queueID, 4)
+	assert.Equal(t, int64(4), GetLastS3UploadFileNum(queueID))
+	return module
+}
+
+func TestTieringEvictAndFetch(t *testing.T) {
+	queueID := "tiering_test"
+	module := setupTiering(t, queueID)
+	cfg := module.cfg
+
+	//the consumer is reading segment 3, segments 3 to 5 are kept
+	q, _ := module.queues.Load(queueID)
+	q.(*DiskBasedQueue).UpdateSegmentConsumerInReading("c1", 3)
+	module.evictSegments(queueID)
+	for i := int64(0); i <= 5; i++ {
+		assert.Equal(t, i >= 3, util.FileExists(GetFileName(queueID, i)), "segment %v", i)
+	}
+
+	stats, err := module.GetTierStats(&queue.QueueConfig{ID: queueID})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Evicted)
+	assert.Equal(t, uint64(3*testSegmentSize), stats.LocalBytes)
+	tiers := map[int64]string{}
+	for _, v := range stats.Segments {
+		tiers[v.Segment] = v.Tier
+	}
+	assert.Equal(t, map[int64]string{0: queue.TierRemote, 1: queue.TierRemote, 2: queue.TierRemote,
+		3: queue.TierCached, 4: queue.TierCached, 5: queue.TierLocal}, tiers)
+
+	//the consumer reaches the evicted segment, fetched on demand and the next segment is prefetched
+	file, exists, _ := SmartGetFileName(cfg, queueID, 1)
+	assert.True(t, exists)
+	assert.Equal(t, GetFileName(queueID, 1), file)
+	assert.Eventually(t, func() bool {
+		return util.FileExists(GetFileName(queueID, 2))
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, util.FileExists(GetFileName(queueID, 0)))
+
+	counters := getTierCounters(queueID)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&counters.fetched))
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&counters.prefetched) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTieringEvictByAge(t *testing.T) {
+	queueID := "tiering_age_test"
+	module := setupTiering(t, queueID)
+	cfg := module.cfg
+	cfg.Tiering.MaxLocalBytes = 0
+	cfg.Tiering.MaxLocalAge = time.Hour
+
+	assert.Equal(t, 0, evictSegments(cfg, queueID, 5, nil))
+
+	cfg.Tiering.MaxLocalAge = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	//the segment not uploaded is never evicted
+	assert.Equal(t, 5, evictSegments(cfg, queueID, 5, nil))
+	assert.True(t, util.FileExists(GetFileName(queueID, 5)))
+
+	//the segments not in the remote tier are kept
+	assert.NoError(t, ioutil.WriteFile(GetFileName(queueID, 2), []byte("local"), 0644))
+	assert.NoError(t, s3.MustGetObjectStore("tiering_test").RemoveObject(context.Background(), "queue", getS3FileLocation(GetFileName(queueID, 2))))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, evictSegments(cfg, queueID, 5, nil))
+	assert.True(t, util.FileExists(GetFileName(queueID, 2)))
+}
+
+func TestTieringEvictInBackground(t *testing.T) {
+	queueID := "tiering_background_test"
+	module := setupTiering(t, queueID)
+	module.cfg.Tiering.EvictInterval = 10 * time.Millisecond
+
+	module.startEviction(queueID)
+	defer module.stopEviction(queueID)
+	assert.Eventually(t, func() bool {
+		return !util.FileExists(GetFileName(queueID, 2))
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, util.FileExists(GetFileName(queueID, 5)))
+}
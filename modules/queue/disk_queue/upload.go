@@ -116,6 +116,8 @@ func (module *DiskQueue)uploadToS3(queueID string,fileNum  int64){
 						log.Error(err)
 					}else if ok{
 						success=true
+						//the segment is known to be in the remote tier, no need to check it before evicted
+						markSegmentUploaded(queueID,i)
 					}
 				}
 				//update last mark
@@ -27,6 +27,8 @@
 package queue
 
 import (
+	"sync/atomic"
+
 	log "github.com/cihub/seelog"
 	"infini.sh/framework/core/s3"
 	"infini.sh/framework/core/util"
@@ -35,6 +37,11 @@ import (
 
 // if local file not found, try to download from s3
 func SmartGetFileName(cfg *DiskQueueConfig, queueID string, segmentID int64) (string, bool, bool) {
+	return smartGetFileName(cfg, queueID, segmentID, true)
+}
+
+// smartGetFileName fetches the remote segment on demand, the following segments are prefetched if the segment was fetched
+func smartGetFileName(cfg *DiskQueueConfig, queueID string, segmentID int64, onDemand bool) (string, bool, bool) {
 	filePath := GetFileName(queueID, segmentID)
 	nextFilePath := GetFileName(queueID, segmentID+1)
 	exists := util.FileExists(filePath)
@@ -66,6 +73,7 @@ func SmartGetFileName(cfg *DiskQueueConfig, queueID string, segmentID int64) (st
 				s3Object := getS3FileLocation(fileToDownload)
 
 				// download remote file
+				fetched := !util.FileExists(fileToDownload)
 				_, err := s3.SyncDownload(fileToDownload, cfg.S3.Server, cfg.S3.Location, cfg.S3.Bucket, s3Object)
 				if err != nil {
 					if util.ContainStr(err.Error(), "exist") && cfg.AlwaysDownload {
@@ -82,9 +90,16 @@ func SmartGetFileName(cfg *DiskQueueConfig, queueID string, segmentID int64) (st
 						panic(err)
 					}
 				}
+
+				if fetched && onDemand && cfg.Tiering.Enabled && util.FileExists(filePath) {
+					atomic.AddInt64(&getTierCounters(queueID).fetched, 1)
+					prefetchSegments(cfg, queueID, segmentID)
+				}
 			}
 		}
 
+		//the segment is available after decompressed or downloaded
+		exists = util.FileExists(filePath)
 	}
 	return filePath, exists, next_file_exists
 }
@@ -29,6 +29,7 @@ package mem_queue
 
 import (
 	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
@@ -52,6 +53,10 @@ type MemoryQueue struct {
 	locker     sync.RWMutex
 }
 
+func init() {
+	config.RegisterSectionSchema("memory_queue", &MemoryQueue{Enabled: true, MemorySize: 2 * 1024 * 1024, Capacity: 10000})
+}
+
 func (this *MemoryQueue) Setup() {
 
 	this.q=sync.Map{}
@@ -28,17 +28,58 @@
 package queue
 
 import (
+	"context"
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/pubsub"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/task"
+	"infini.sh/framework/core/util"
 	"infini.sh/framework/modules/queue/common"
 )
 
+// TopicQueueDepth is the pubsub topic of queue depth updates
+const TopicQueueDepth = "queue.depth"
+
 type Module struct {
 }
 
 func (this *Module) Setup() {
+	pubsub.RegisterTopic(TopicQueueDepth, "depth of queues, updated every 5 seconds", "")
 }
 func (this *Module) Start() error {
 	common.InitQueueMetadata()
-	return nil
+
+	_, err := task.RegisterScheduleTask(task.ScheduleTask{
+		ID:          "queue_depth_publisher",
+		Description: "publish queue depth to subscribers",
+		Type:        "interval",
+		Interval:    "5s",
+		Singleton:   true,
+		Task: func(ctx context.Context) {
+			if pubsub.HasSubscribers(TopicQueueDepth) {
+				publishQueueDepth()
+			}
+		},
+	})
+	return err
+}
+
+func publishQueueDepth() {
+	for _, cfg := range queue.GetAllConfigs() {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Debugf("failed to get depth of queue [%v], %v", cfg.Name, r)
+				}
+			}()
+			pubsub.Publish(TopicQueueDepth, "depth_updated", util.MapStr{
+				"id":    cfg.ID,
+				"name":  cfg.Name,
+				"type":  cfg.Type,
+				"depth": queue.Depth(cfg),
+			})
+		}()
+	}
 }
 func (this *Module) Stop() error {
 	common.PersistQueueMetadata()
@@ -0,0 +1,158 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package queue
+
+import (
+	"fmt"
+	"net/http"
+
+	httprouter "infini.sh/framework/core/api/router"
+	queue1 "infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+func (module *API) getTopicAdmin(w http.ResponseWriter, ps httprouter.Params) (*queue1.QueueConfig, queue1.TopicAdminAPI, bool) {
+	queueID := ps.MustGetParameter("id")
+	cfg, ok := queue1.SmartGetConfig(queueID)
+	if !ok {
+		module.WriteError(w, fmt.Sprintf("queue [%v] not exists", queueID), http.StatusNotFound)
+		return nil, nil, false
+	}
+	admin, err := queue1.GetTopicAdmin(cfg)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	return cfg, admin, true
+}
+
+func (module *API) GetQueuePartitions(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	cfg, admin, ok := module.getTopicAdmin(w, ps)
+	if !ok {
+		return
+	}
+	partitions, err := admin.GetPartitions(cfg)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteJSON(w, util.MapStr{
+		"queue":      cfg.Name,
+		"partitions": partitions,
+	}, http.StatusOK)
+}
+
+func (module *API) UpdateQueuePartitions(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	cfg, admin, ok := module.getTopicAdmin(w, ps)
+	if !ok {
+		return
+	}
+	count := module.GetIntOrDefault(req, "count", 0)
+	if count <= 0 {
+		module.WriteError(w, "queue.api.count_required", http.StatusBadRequest)
+		return
+	}
+	err := admin.SetPartitions(cfg, count)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteAckOKJSON(w)
+}
+
+func (module *API) GetQueueRetention(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	cfg, admin, ok := module.getTopicAdmin(w, ps)
+	if !ok {
+		return
+	}
+	retention, err := admin.GetRetention(cfg)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteJSON(w, retention, http.StatusOK)
+}
+
+func (module *API) UpdateQueueRetention(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	cfg, admin, ok := module.getTopicAdmin(w, ps)
+	if !ok {
+		return
+	}
+	retention := queue1.RetentionConfig{}
+	err := module.DecodeJSON(req, &retention)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = admin.UpdateRetention(cfg, retention)
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteAckOKJSON(w)
+}
+
+func (module *API) GetQueueLag(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	cfg, admin, ok := module.getTopicAdmin(w, ps)
+	if !ok {
+		return
+	}
+
+	groups := []string{}
+	if group := module.GetParameterOrDefault(req, "group", ""); group != "" {
+		groups = append(groups, group)
+	} else if consumers, ok := queue1.GetConsumerConfigsByQueueID(cfg.ID); ok {
+		hash := map[string]bool{}
+		for _, v := range consumers {
+			if !hash[v.Group] {
+				hash[v.Group] = true
+				groups = append(groups, v.Group)
+			}
+		}
+	}
+
+	result := util.MapStr{}
+	for _, group := range groups {
+		lags, err := admin.GetConsumerLag(cfg, group)
+		if err != nil {
+			module.WriteError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var total int64
+		for _, v := range lags {
+			total += v.Lag
+		}
+		result[group] = util.MapStr{
+			"lag":        total,
+			"partitions": lags,
+		}
+	}
+	module.WriteJSON(w, util.MapStr{
+		"queue":  cfg.Name,
+		"groups": result,
+	}, http.StatusOK)
+}
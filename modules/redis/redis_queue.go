@@ -34,6 +34,7 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/queue"
 )
@@ -51,17 +52,31 @@ type RedisConfig struct {
 	Password string `config:"password"`
 	PoolSize int    `config:"pool_size"`
 	Db       int    `config:"db"`
+
+	Stream StreamConfig `config:"stream"`
 }
 
 func (module *RedisModule) Name() string {
 	return "redis"
 }
 
-func (module *RedisModule) Setup() {
-	module.config = RedisConfig{
+func defaultRedisConfig() RedisConfig {
+	return RedisConfig{
 		Db:       0,
 		PoolSize: 1000,
+		Stream: StreamConfig{
+			ApproximateMaxLen: true,
+			ClaimMinIdle:      "5m",
+		},
 	}
+}
+
+func init() {
+	config.RegisterSectionSchema("redis", defaultRedisConfig())
+}
+
+func (module *RedisModule) Setup() {
+	module.config = defaultRedisConfig()
 	ok, err := env.ParseConfig("redis", &module.config)
 	if ok && err != nil  &&global.Env().SystemConfig.Configs.PanicOnConfigError{
 		panic(err)
@@ -171,6 +186,17 @@ func (module *RedisModule) Start() error {
 	//handler:=&RedisQueue{client: module.client,pubsub: map[string]int{}}
 	//queue.Register("redis",handler)
 
+	if module.config.Stream.Enabled {
+		handler, err := NewStreamQueue(module.client, module.config.Stream)
+		if err != nil {
+			panic(err)
+		}
+		queue.Register("redis_stream", handler)
+		if module.config.Stream.Default {
+			queue.RegisterDefaultHandler(handler)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,603 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package redis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/go-redis/redis/v8"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/stats"
+)
+
+// StreamConfig configures the redis streams queue, each queue is a stream, each consumer group of the
+// framework is a consumer group of the stream
+type StreamConfig struct {
+	Enabled bool `config:"enabled"`
+	Default bool `config:"default"`
+	//prefix of the stream keys
+	Prefix string `config:"prefix"`
+	//max messages kept in each stream, trimmed on every XADD, 0 keeps all the messages
+	MaxLen int64 `config:"max_len"`
+	//trim with `MAXLEN ~`, much cheaper, but a few more messages may be kept
+	ApproximateMaxLen bool `config:"approximate_max_len"`
+	//pending messages idle for this long are reclaimed from the crashed consumers of the same group, empty to disable
+	ClaimMinIdle string `config:"claim_min_idle"`
+}
+
+const streamDataField = "data"
+
+// StreamQueue implements the queue.AdvancedQueueAPI on top of redis streams, messages are appended via XADD,
+// consumed via XREADGROUP and acknowledged via XACK on commit, the offset of a message is its stream entry id,
+// `<ms>-<seq>` is mapped to segment `ms` and position `seq`
+type StreamQueue struct {
+	client       *redis.Client
+	cfg          StreamConfig
+	claimMinIdle time.Duration
+
+	queues    sync.Map
+	consumers sync.Map //queue+consumer=instance
+}
+
+func NewStreamQueue(client *redis.Client, cfg StreamConfig) (*StreamQueue, error) {
+	q := &StreamQueue{client: client, cfg: cfg}
+	if cfg.ClaimMinIdle != "" {
+		v, err := time.ParseDuration(cfg.ClaimMinIdle)
+		if err != nil {
+			return nil, errors.Errorf("invalid claim_min_idle: %v", err)
+		}
+		q.claimMinIdle = v
+	}
+	return q, nil
+}
+
+func (q *StreamQueue) Name() string {
+	return "redis_stream"
+}
+
+func (q *StreamQueue) key(k string) string {
+	return q.cfg.Prefix + k
+}
+
+// offsetsKey is the hash keeping the committed offsets of the consumer groups
+func (q *StreamQueue) offsetsKey(k string) string {
+	return q.cfg.Prefix + k + ":offsets"
+}
+
+func (q *StreamQueue) Init(k string) error {
+	q.queues.Store(k, true)
+	return nil
+}
+
+func (q *StreamQueue) Close(string) error {
+	return nil
+}
+
+func (q *StreamQueue) GetStorageSize(k string) uint64 {
+	size, err := q.client.MemoryUsage(ctx, q.key(k)).Result()
+	if err != nil {
+		if err != redis.Nil {
+			log.Debugf("get storage size for %v, error:%v", k, err)
+		}
+		return 0
+	}
+	return uint64(size)
+}
+
+func (q *StreamQueue) Destroy(k string) error {
+	q.queues.Delete(k)
+	return q.client.Del(ctx, q.key(k), q.offsetsKey(k)).Err()
+}
+
+func (q *StreamQueue) GetQueues() []string {
+	result := []string{}
+	known := map[string]bool{}
+	q.queues.Range(func(key, value interface{}) bool {
+		k := key.(string)
+		known[k] = true
+		result = append(result, k)
+		return true
+	})
+
+	var cursor uint64
+	for {
+		keys, next, err := q.client.ScanType(ctx, cursor, q.cfg.Prefix+"*", 1000, "stream").Result()
+		if err != nil {
+			log.Debugf("failed to scan redis streams, %v", err)
+			break
+		}
+		for _, v := range keys {
+			k := strings.TrimPrefix(v, q.cfg.Prefix)
+			if !known[k] {
+				known[k] = true
+				result = append(result, k)
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	return result
+}
+
+func (q *StreamQueue) Push(k string, data []byte) error {
+	_, err := q.add(k, data)
+	return err
+}
+
+func (q *StreamQueue) add(k string, data []byte) (queue.Offset, error) {
+	if len(data) == 0 {
+		return queue.Offset{}, errors.New("invalid data")
+	}
+	q.Init(k)
+	id, err := q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.key(k),
+		MaxLen: q.cfg.MaxLen,
+		Approx: q.cfg.ApproximateMaxLen,
+		Values: map[string]interface{}{streamDataField: data},
+	}).Result()
+	if err != nil {
+		return queue.Offset{}, err
+	}
+	return parseStreamID(id)
+}
+
+func (q *StreamQueue) Depth(k string) int64 {
+	c, err := q.client.XLen(ctx, q.key(k)).Result()
+	if err != nil {
+		return -1
+	}
+	return c
+}
+
+// LatestOffset returns the offset next to the last message, it equals to the committed offset of a consumer
+// group which has consumed all the messages
+func (q *StreamQueue) LatestOffset(k *queue.QueueConfig) queue.Offset {
+	msgs, err := q.client.XRevRangeN(ctx, q.key(k.ID), "+", "-", 1).Result()
+	if err != nil {
+		panic(err)
+	}
+	if len(msgs) == 0 {
+		return queue.NewOffset(0, 0)
+	}
+	offset, err := parseStreamID(msgs[0].ID)
+	if err != nil {
+		panic(err)
+	}
+	offset.Position++
+	return offset
+}
+
+func (q *StreamQueue) GetOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.Offset, error) {
+	v, err := q.client.HGet(ctx, q.offsetsKey(k.ID), consumer.Group).Result()
+	if err == redis.Nil {
+		return queue.NewOffset(0, 0), nil
+	}
+	if err != nil {
+		return queue.Offset{}, err
+	}
+	return queue.DecodeFromString(v), nil
+}
+
+func (q *StreamQueue) DeleteOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) error {
+	if err := q.client.HDel(ctx, q.offsetsKey(k.ID), consumer.Group).Err(); err != nil {
+		return err
+	}
+	err := q.client.XGroupDestroy(ctx, q.key(k.ID), consumer.Group).Err()
+	if err != nil && !isNoStreamError(err) {
+		return err
+	}
+	return nil
+}
+
+// CommitOffset acknowledges the pending messages before the offset, and records the offset of the group
+func (q *StreamQueue) CommitOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig, offset queue.Offset) (bool, error) {
+	name := ""
+	if v, ok := q.consumers.Load(k.ID + consumer.Key()); ok {
+		name = v.(*StreamConsumer).name
+	}
+	if err := q.commit(k.ID, consumer.Group, name, offset); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// commit acknowledges the messages before the offset pending on the consumer, or on the whole group if
+// the consumer is empty
+func (q *StreamQueue) commit(k, group, consumer string, offset queue.Offset) error {
+	if end := streamIDBefore(offset); end != "" {
+		for {
+			pending, err := q.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream:   q.key(k),
+				Group:    group,
+				Start:    "-",
+				End:      end,
+				Count:    1000,
+				Consumer: consumer,
+			}).Result()
+			if err != nil && !isNoStreamError(err) {
+				return err
+			}
+			if len(pending) == 0 {
+				break
+			}
+			ids := make([]string, 0, len(pending))
+			for _, v := range pending {
+				ids = append(ids, v.ID)
+			}
+			if err := q.client.XAck(ctx, q.key(k), group, ids...).Err(); err != nil {
+				return err
+			}
+			if len(pending) < 1000 {
+				break
+			}
+		}
+	}
+	return q.client.HSet(ctx, q.offsetsKey(k), group, offset.EncodeToString()).Err()
+}
+
+// createGroup creates the consumer group of the stream if not exists, a new group starts after the
+// committed offset, otherwise follows the auto_reset_offset of the consumer
+func (q *StreamQueue) createGroup(k *queue.QueueConfig, consumer *queue.ConsumerConfig) error {
+	start := "0"
+	offset, err := q.GetOffset(k, consumer)
+	if err != nil {
+		return err
+	}
+	if id := streamIDBefore(offset); id != "" {
+		start = id
+	} else if consumer.AutoResetOffset == "latest" {
+		start = "$"
+	}
+	err = q.client.XGroupCreateMkStream(ctx, q.key(k.ID), consumer.Group, start).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+func (q *StreamQueue) AcquireConsumer(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.ConsumerAPI, error) {
+	q.Init(k.ID)
+	if err := q.createGroup(k, consumer); err != nil {
+		return nil, err
+	}
+	instance := &StreamConsumer{
+		queue:         q,
+		qCfg:          k,
+		cCfg:          consumer,
+		key:           q.key(k.ID),
+		name:          consumer.Key(),
+		pendingCursor: "0",
+	}
+	q.consumers.Store(k.ID+consumer.Key(), instance)
+	if global.Env().IsDebug {
+		log.Infof("acquired consumer:%v, %v, %v", k.Name, consumer.Key(), consumer.ID)
+	}
+	return instance, nil
+}
+
+func (q *StreamQueue) ReleaseConsumer(k *queue.QueueConfig, consumer *queue.ConsumerConfig, instance queue.ConsumerAPI) error {
+	q.consumers.Delete(k.ID + consumer.Key())
+	if instance != nil {
+		return instance.Close()
+	}
+	return nil
+}
+
+func (q *StreamQueue) AcquireProducer(cfg *queue.QueueConfig) (queue.ProducerAPI, error) {
+	return &StreamProducer{queue: q, cfg: cfg}, nil
+}
+
+func (q *StreamQueue) ReleaseProducer(k *queue.QueueConfig, producer queue.ProducerAPI) error {
+	return nil
+}
+
+// StreamConsumer reads the messages via XREADGROUP, the messages left pending by the previous run of this
+// consumer are delivered first, then the ones reclaimed from the idle consumers, then the new ones
+type StreamConsumer struct {
+	queue *StreamQueue
+	qCfg  *queue.QueueConfig
+	cCfg  *queue.ConsumerConfig
+	key   string
+	name  string
+
+	//position in the pending entries of this consumer, empty once all of them are redelivered
+	pendingCursor string
+}
+
+func (c *StreamConsumer) Close() error {
+	return nil
+}
+
+// ResetOffset recreates the consumer group at the offset, the pending messages of the group are dropped
+func (c *StreamConsumer) ResetOffset(segment, readPos int64) error {
+	offset := queue.NewOffset(segment, readPos)
+	start := streamIDBefore(offset)
+	if start == "" {
+		start = "0"
+	}
+	if err := c.queue.client.XGroupDestroy(ctx, c.key, c.cCfg.Group).Err(); err != nil && !isNoStreamError(err) {
+		return err
+	}
+	if err := c.queue.client.XGroupCreateMkStream(ctx, c.key, c.cCfg.Group, start).Err(); err != nil {
+		return err
+	}
+	c.pendingCursor = ""
+	if global.Env().IsDebug {
+		log.Debugf("reset %v offset to %v", c.qCfg.ID, offset)
+	}
+	return c.queue.client.HSet(ctx, c.queue.offsetsKey(c.qCfg.ID), c.cCfg.Group, offset.EncodeToString()).Err()
+}
+
+func (c *StreamConsumer) CommitOffset(offset queue.Offset) error {
+	err := c.queue.commit(c.qCfg.ID, c.cCfg.Group, c.name, offset)
+	if global.Env().IsDebug {
+		log.Infof("commit %v[%v] offset: %v, %v", c.qCfg.Name, c.qCfg.ID, offset.String(), err)
+	}
+	return err
+}
+
+func (c *StreamConsumer) FetchMessages(ctx1 *queue.Context, numOfMessages int) (messages []queue.Message, isTimeout bool, err error) {
+	count := int64(numOfMessages)
+	if count <= 0 {
+		count = int64(c.cCfg.FetchMaxMessages)
+	}
+	if count <= 0 {
+		count = 100
+	}
+
+	ctx1.MessageCount = 0
+
+	entries, err := c.read(count)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, v := range entries {
+		offset, err := parseStreamID(v.ID)
+		if err != nil {
+			return nil, false, err
+		}
+		data := toBytes(v.Values[streamDataField])
+		next := offset
+		next.Position++
+		messages = append(messages, queue.Message{
+			Offset:     offset,
+			NextOffset: next,
+			Data:       data,
+			Size:       len(data),
+			Timestamp:  offset.Segment / 1000,
+		})
+	}
+
+	if len(messages) == 0 {
+		return nil, true, nil
+	}
+
+	ctx1.MessageCount = len(messages)
+	ctx1.InitOffset = messages[0].Offset
+	ctx1.NextOffset = messages[len(messages)-1].NextOffset
+	return messages, false, nil
+}
+
+func (c *StreamConsumer) read(count int64) ([]redis.XMessage, error) {
+	for c.pendingCursor != "" {
+		entries, err := c.readGroup(c.pendingCursor, count, -1)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			c.pendingCursor = ""
+			break
+		}
+		c.pendingCursor = entries[len(entries)-1].ID
+		if entries, err = c.dropTrimmed(entries); err != nil || len(entries) > 0 {
+			return entries, err
+		}
+	}
+
+	entries, err := c.claim(count)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err = c.dropTrimmed(entries); err != nil || len(entries) > 0 {
+		return entries, err
+	}
+
+	block := time.Duration(-1)
+	if c.cCfg.FetchMaxWaitMs > 0 {
+		block = c.cCfg.GetFetchMaxWaitMs()
+	}
+	return c.readGroup(">", count, block)
+}
+
+// dropTrimmed acknowledges the pending entries already trimmed by MAXLEN, they can't be delivered anymore
+func (c *StreamConsumer) dropTrimmed(entries []redis.XMessage) ([]redis.XMessage, error) {
+	result := entries[:0]
+	trimmed := []string{}
+	for _, v := range entries {
+		if v.Values == nil {
+			trimmed = append(trimmed, v.ID)
+			continue
+		}
+		result = append(result, v)
+	}
+	if len(trimmed) > 0 {
+		stats.IncrementBy("queue", c.qCfg.ID+".trimmed_pending", int64(len(trimmed)))
+		if err := c.queue.client.XAck(ctx, c.key, c.cCfg.Group, trimmed...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (c *StreamConsumer) readGroup(id string, count int64, block time.Duration) ([]redis.XMessage, error) {
+	streams, err := c.queue.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.cCfg.Group,
+		Consumer: c.name,
+		Streams:  []string{c.key, id},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	return streams[0].Messages, nil
+}
+
+// claim takes over the messages pending on the other consumers of the group for longer than claim_min_idle
+func (c *StreamConsumer) claim(count int64) ([]redis.XMessage, error) {
+	if c.queue.claimMinIdle <= 0 {
+		return nil, nil
+	}
+	pending, err := c.queue.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.key,
+		Group:  c.cCfg.Group,
+		Idle:   c.queue.claimMinIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  count,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, v := range pending {
+		if v.Consumer != c.name {
+			ids = append(ids, v.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	msgs, err := c.queue.client.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   c.key,
+		Group:    c.cCfg.Group,
+		Consumer: c.name,
+		MinIdle:  c.queue.claimMinIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) > 0 {
+		log.Debugf("consumer [%v] reclaimed %v pending messages of queue [%v]", c.name, len(msgs), c.qCfg.Name)
+		stats.IncrementBy("queue", c.qCfg.ID+".reclaimed", int64(len(msgs)))
+	}
+	return msgs, nil
+}
+
+type StreamProducer struct {
+	queue *StreamQueue
+	cfg   *queue.QueueConfig
+}
+
+func (p *StreamProducer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceResponse, error) {
+	if reqs == nil {
+		return nil, errors.New("invalid request")
+	}
+	results := []queue.ProduceResponse{}
+	for _, req := range *reqs {
+		topic := req.Topic
+		if topic == "" {
+			topic = p.cfg.ID
+		}
+		offset, err := p.queue.add(topic, req.Data)
+		if err != nil {
+			return &results, err
+		}
+		results = append(results, queue.ProduceResponse{
+			Topic:     topic,
+			Offset:    offset,
+			Timestamp: offset.Segment / 1000,
+		})
+	}
+	return &results, nil
+}
+
+func (p *StreamProducer) Close() error {
+	return nil
+}
+
+func parseStreamID(id string) (queue.Offset, error) {
+	i := strings.IndexByte(id, '-')
+	if i <= 0 {
+		return queue.Offset{}, errors.Errorf("invalid stream id: %v", id)
+	}
+	ms, err := strconv.ParseInt(id[:i], 10, 64)
+	if err != nil {
+		return queue.Offset{}, errors.Errorf("invalid stream id: %v", id)
+	}
+	seq, err := strconv.ParseInt(id[i+1:], 10, 64)
+	if err != nil {
+		return queue.Offset{}, errors.Errorf("invalid stream id: %v", id)
+	}
+	return queue.NewOffset(ms, seq), nil
+}
+
+// streamIDBefore returns the largest stream id before the offset, empty if the offset is the beginning
+func streamIDBefore(offset queue.Offset) string {
+	if offset.Position > 0 {
+		return fmt.Sprintf("%d-%d", offset.Segment, offset.Position-1)
+	}
+	if offset.Segment > 0 {
+		return fmt.Sprintf("%d-%d", offset.Segment-1, uint64(math.MaxUint64))
+	}
+	return ""
+}
+
+func isNoStreamError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "no such key") || strings.Contains(msg, "NOGROUP")
+}
+
+func toBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	case nil:
+		return nil
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
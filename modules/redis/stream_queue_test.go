@@ -0,0 +1,197 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/queue"
+)
+
+func newTestStreamQueue(t *testing.T, cfg StreamConfig) (*miniredis.Miniredis, *StreamQueue) {
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { client.Close() })
+	q, err := NewStreamQueue(client, cfg)
+	assert.NoError(t, err)
+	return m, q
+}
+
+func produce(t *testing.T, q *StreamQueue, cfg *queue.QueueConfig, messages ...string) []queue.ProduceResponse {
+	producer, err := q.AcquireProducer(cfg)
+	assert.NoError(t, err)
+	reqs := []queue.ProduceRequest{}
+	for _, v := range messages {
+		reqs = append(reqs, queue.ProduceRequest{Data: []byte(v)})
+	}
+	res, err := producer.Produce(&reqs)
+	assert.NoError(t, err)
+	return *res
+}
+
+func fetch(t *testing.T, consumer queue.ConsumerAPI, n int) ([]string, *queue.Context) {
+	ctx := &queue.Context{}
+	messages, timeout, err := consumer.FetchMessages(ctx, n)
+	assert.NoError(t, err)
+	assert.Equal(t, len(messages) == 0, timeout)
+	data := []string{}
+	for _, v := range messages {
+		data = append(data, string(v.Data))
+	}
+	return data, ctx
+}
+
+func TestStreamQueueConsume(t *testing.T) {
+	m, q := newTestStreamQueue(t, StreamConfig{Prefix: "test-"})
+	qCfg := &queue.QueueConfig{ID: "q1", Name: "q1"}
+	cCfg := &queue.ConsumerConfig{Group: "g1", Name: "c1"}
+
+	res := produce(t, q, qCfg, "a", "b", "c")
+	assert.Equal(t, 3, len(res))
+	assert.True(t, res[1].Offset.LatestThan(res[0].Offset))
+	assert.True(t, m.Exists("test-q1"))
+	assert.Equal(t, int64(3), q.Depth("q1"))
+	assert.Equal(t, []string{"q1"}, q.GetQueues())
+
+	latest := q.LatestOffset(qCfg)
+	assert.Equal(t, queue.NewOffset(res[2].Offset.Segment, res[2].Offset.Position+1), latest)
+	offset, err := q.GetOffset(qCfg, cCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, queue.NewOffset(0, 0), offset)
+
+	consumer, err := q.AcquireConsumer(qCfg, cCfg)
+	assert.NoError(t, err)
+	data, ctx := fetch(t, consumer, 2)
+	assert.Equal(t, []string{"a", "b"}, data)
+	assert.Equal(t, res[0].Offset, ctx.InitOffset)
+
+	assert.NoError(t, consumer.CommitOffset(ctx.NextOffset))
+	offset, _ = q.GetOffset(qCfg, cCfg)
+	assert.Equal(t, ctx.NextOffset, offset)
+
+	//not committed, redelivered to the consumer after restart
+	data, _ = fetch(t, consumer, 2)
+	assert.Equal(t, []string{"c"}, data)
+	assert.NoError(t, q.ReleaseConsumer(qCfg, cCfg, consumer))
+
+	consumer, err = q.AcquireConsumer(qCfg, cCfg)
+	assert.NoError(t, err)
+	data, ctx = fetch(t, consumer, 2)
+	assert.Equal(t, []string{"c"}, data)
+	ok, err := q.CommitOffset(qCfg, cCfg, ctx.NextOffset)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	offset, _ = q.GetOffset(qCfg, cCfg)
+	assert.Equal(t, latest, offset)
+	data, _ = fetch(t, consumer, 2)
+	assert.Equal(t, 0, len(data))
+
+	pending, err := q.client.XPending(context.Background(), "test-q1", "g1").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+
+	//a new group starts from the committed offset
+	assert.NoError(t, q.DeleteOffset(qCfg, cCfg))
+	ok, err = q.CommitOffset(qCfg, cCfg, res[2].Offset)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	consumer, err = q.AcquireConsumer(qCfg, cCfg)
+	assert.NoError(t, err)
+	data, _ = fetch(t, consumer, 10)
+	assert.Equal(t, []string{"c"}, data)
+
+	//reset the offset to the beginning
+	assert.NoError(t, consumer.ResetOffset(0, 0))
+	data, _ = fetch(t, consumer, 10)
+	assert.Equal(t, []string{"a", "b", "c"}, data)
+
+	assert.NoError(t, q.Destroy("q1"))
+	assert.False(t, m.Exists("test-q1"))
+}
+
+func TestStreamQueueReclaim(t *testing.T) {
+	m, q := newTestStreamQueue(t, StreamConfig{ClaimMinIdle: "1m"})
+	qCfg := &queue.QueueConfig{ID: "q1", Name: "q1"}
+
+	now := time.Now()
+	m.SetTime(now)
+	produce(t, q, qCfg, "a", "b")
+
+	crashed, err := q.AcquireConsumer(qCfg, &queue.ConsumerConfig{Group: "g1", Name: "c1"})
+	assert.NoError(t, err)
+	data, _ := fetch(t, crashed, 10)
+	assert.Equal(t, []string{"a", "b"}, data)
+
+	cCfg := &queue.ConsumerConfig{Group: "g1", Name: "c2"}
+	consumer, err := q.AcquireConsumer(qCfg, cCfg)
+	assert.NoError(t, err)
+	data, _ = fetch(t, consumer, 10)
+	assert.Equal(t, 0, len(data))
+
+	//idle for longer than claim_min_idle
+	m.SetTime(now.Add(2 * time.Minute))
+	data, ctx := fetch(t, consumer, 10)
+	assert.Equal(t, []string{"a", "b"}, data)
+	assert.NoError(t, consumer.CommitOffset(ctx.NextOffset))
+
+	pending, err := q.client.XPending(context.Background(), "q1", "g1").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+}
+
+func TestStreamQueueRetention(t *testing.T) {
+	_, q := newTestStreamQueue(t, StreamConfig{MaxLen: 2})
+	qCfg := &queue.QueueConfig{ID: "q1", Name: "q1"}
+
+	for _, v := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, q.Push("q1", []byte(v)))
+	}
+	assert.Equal(t, int64(2), q.Depth("q1"))
+
+	consumer, err := q.AcquireConsumer(qCfg, &queue.ConsumerConfig{Group: "g1", Name: "c1"})
+	assert.NoError(t, err)
+	data, _ := fetch(t, consumer, 10)
+	assert.Equal(t, []string{"c", "d"}, data)
+
+	_, err = NewStreamQueue(q.client, StreamConfig{ClaimMinIdle: "soon"})
+	assert.Error(t, err)
+}
+
+func TestStreamID(t *testing.T) {
+	offset, err := parseStreamID("1700000000000-5")
+	assert.NoError(t, err)
+	assert.Equal(t, queue.NewOffset(1700000000000, 5), offset)
+	_, err = parseStreamID("invalid")
+	assert.Error(t, err)
+
+	assert.Equal(t, "1700000000000-4", streamIDBefore(offset))
+	assert.Equal(t, "1699999999999-18446744073709551615", streamIDBefore(queue.NewOffset(1700000000000, 0)))
+	assert.Equal(t, "", streamIDBefore(queue.NewOffset(0, 0)))
+}
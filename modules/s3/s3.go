@@ -30,37 +30,44 @@ package s3
 import (
 	"context"
 	"crypto/tls"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
 	log "github.com/cihub/seelog"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/s3"
 	"infini.sh/framework/core/util"
-	"net"
-	"net/http"
-	"os"
-	"sync"
-	"time"
 )
 
-
-
 type S3Module struct {
 
 	//LatestFile map[string]int64 `config:"latest" json:"latest,omitempty"`
 
-	S3Configs map[string] config.S3Config
+	S3Configs map[string]config.S3Config
+
+	localStores map[string]*s3.LocalStore
 }
 
-type S3Uploader struct {
-	S3Config *config.S3Config
+// S3Store is the object store of the s3 compatible servers, eg: aws s3 or minio
+type S3Store struct {
+	S3Config    *config.S3Config
 	minioClient *minio.Client
 }
 
-func NewS3Uploader(cfg *config.S3Config)(*S3Uploader,error)  {
+func NewS3Store(cfg *config.S3Config) (*S3Store, error) {
 
 	// Keep TLS config.
 	tlsConfig := &tls.Config{}
@@ -89,142 +96,315 @@ func NewS3Uploader(cfg *config.S3Config)(*S3Uploader,error)  {
 	}
 
 	var err error
-	uploader:=&S3Uploader{S3Config: cfg}
-	uploader.minioClient, err = minio.New(uploader.S3Config.Endpoint, &minio.Options{
-		Transport:transport,
-		Creds:  credentials.NewStaticV4(uploader.S3Config.AccessKey, uploader.S3Config.AccessSecret, uploader.S3Config.Token),
-		Secure: uploader.S3Config.SSL,
-
+	store := &S3Store{S3Config: cfg}
+	store.minioClient, err = minio.New(store.S3Config.Endpoint, &minio.Options{
+		Transport: transport,
+		Creds:     credentials.NewStaticV4(store.S3Config.AccessKey, store.S3Config.AccessSecret, store.S3Config.Token),
+		Secure:    store.S3Config.SSL,
+		//required by the sha256 and crc32c checksums
+		TrailingHeaders: true,
 	})
 	if err != nil {
-		return nil,err
+		return nil, err
 	}
-	return uploader,nil
+	return store, nil
 }
 
-func (uploader *S3Uploader) AsyncUpload(filePath,location,bucketName,objectName string) (error){
-	//TODO to tracking tasks, control concurrent workers
-	go uploader.SyncUpload(filePath,location,bucketName,objectName)
+func (store *S3Store) MakeBucket(ctx context.Context, bucket, location string) error {
+	err := store.minioClient.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: location})
+	if err != nil {
+		exists, errBucketExists := store.minioClient.BucketExists(ctx, bucket)
+		if errBucketExists == nil && exists {
+			log.Tracef("we already own %s", bucket)
+			return nil
+		}
+		return err
+	}
+	log.Tracef("successfully created %s", bucket)
 	return nil
 }
 
-func (uploader *S3Uploader) SyncUpload(filePath,location,bucketName,objectName string) (bool,error){
-
-	log.Tracef("s3 uploading file:%v to: %v",filePath,objectName)
-
-	log.Tracef("s3 server [%v] is online:%v\n", uploader.minioClient.EndpointURL(),uploader.minioClient.IsOnline())
+func (store *S3Store) BucketExists(ctx context.Context, bucket string) (bool, error) {
+	return store.minioClient.BucketExists(ctx, bucket)
+}
 
+func (store *S3Store) PutObject(ctx context.Context, bucket, object string, reader io.Reader, size int64, opts s3.PutOptions) (*s3.ObjectInfo, error) {
+	putOpts := minio.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: opts.Metadata,
+		PartSize:     opts.PartSize,
+		NumThreads:   opts.Concurrency,
+	}
 	var err error
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
-	defer cancel()
+	if putOpts.ServerSideEncryption, err = serverSide(opts.Encryption); err != nil {
+		return nil, err
+	}
 
-	err = uploader.minioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: location})
-	if err != nil {
-		exists, errBucketExists := uploader.minioClient.BucketExists(ctx, bucketName)
-		if errBucketExists == nil && exists {
-			log.Tracef("we already own %s", bucketName)
-		} else {
-			return false,err
+	//the checksum is computed by the client as well, the same with the other stores
+	var checksum hash.Hash
+	if opts.Checksum != "" {
+		if checksum, err = s3.NewChecksum(opts.Checksum); err != nil {
+			return nil, err
 		}
-	} else {
-		log.Tracef("successfully created %s", bucketName)
+		switch opts.Checksum {
+		case s3.ChecksumMD5:
+			putOpts.SendContentMd5 = true
+		case s3.ChecksumSHA256:
+			putOpts.Checksum = minio.ChecksumSHA256
+		case s3.ChecksumCRC32C:
+			putOpts.Checksum = minio.ChecksumCRC32C
+		}
+		reader = io.TeeReader(reader, checksum)
 	}
 
-	contentType := "application/zip"
-
-	info, err := uploader.minioClient.FPutObject(ctx, bucketName, objectName, filePath, minio.PutObjectOptions{ContentType: contentType})
+	uploaded, err := store.minioClient.PutObject(ctx, bucket, object, reader, size, putOpts)
 	if err != nil {
-		log.Error(info,err)
-		return false,err
+		return nil, toObjectError(err)
 	}
-
-	log.Debugf("successfully uploaded %s of size %d", objectName, info.Size)
-
-	return true, nil
+	info := &s3.ObjectInfo{
+		Bucket:       bucket,
+		Key:          object,
+		Size:         uploaded.Size,
+		ETag:         uploaded.ETag,
+		ContentType:  opts.ContentType,
+		LastModified: uploaded.LastModified,
+		Metadata:     opts.Metadata,
+	}
+	if checksum != nil {
+		info.Checksum = s3.EncodeChecksum(checksum)
+		info.ChecksumAlgorithm = opts.Checksum
+	}
+	return info, nil
 }
-var locker= sync.Mutex{}
-func (uploader *S3Uploader) SyncDownload(filePath,location,bucketName,objectName string) (bool,error){
-
-	log.Tracef("try downloading s3 file:%v to: %v",objectName,filePath)
 
-	if !uploader.minioClient.IsOnline(){
-		log.Tracef("s3 server [%v] is online:%v\n", uploader.minioClient.EndpointURL(),uploader.minioClient.IsOnline())
-		return false,errors.New("s3 server is offline")
+func (store *S3Store) GetObject(ctx context.Context, bucket, object string, opts s3.GetOptions) (io.ReadCloser, *s3.ObjectInfo, error) {
+	getOpts := minio.GetObjectOptions{}
+	if opts.Encryption != nil {
+		if opts.Encryption.Type != s3.EncryptionC {
+			return nil, nil, errors.New("only the key of sse-c is required to read the objects")
+		}
+		var err error
+		if getOpts.ServerSideEncryption, err = serverSide(opts.Encryption); err != nil {
+			return nil, nil, err
+		}
+	}
+	if opts.Offset > 0 || opts.Length > 0 {
+		end := int64(0)
+		if opts.Length > 0 {
+			end = opts.Offset + opts.Length - 1
+		}
+		if err := getOpts.SetRange(opts.Offset, end); err != nil {
+			return nil, nil, err
+		}
 	}
+	obj, err := store.minioClient.GetObject(ctx, bucket, object, getOpts)
+	if err != nil {
+		return nil, nil, toObjectError(err)
+	}
+	//the request is sent by stat, errors of the missing objects are returned here
+	stat, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, toObjectError(err)
+	}
+	return obj, toObjectInfo(bucket, stat), nil
+}
 
-	var err error
+func (store *S3Store) StatObject(ctx context.Context, bucket, object string) (*s3.ObjectInfo, error) {
+	opts := minio.StatObjectOptions{}
+	opts.Checksum = true
+	stat, err := store.minioClient.StatObject(ctx, bucket, object, opts)
+	if err != nil {
+		return nil, toObjectError(err)
+	}
+	return toObjectInfo(bucket, stat), nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*30))
+func (store *S3Store) ListObjects(ctx context.Context, bucket string, opts s3.ListOptions) ([]s3.ObjectInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	locker.Lock()
-	defer locker.Unlock()
-
-	exists, errBucketExists := uploader.minioClient.BucketExists(ctx, bucketName)
-	if errBucketExists != nil || !exists {
-		log.Tracef("bucket not exists %s, %v", bucketName,errBucketExists)
-		return false,err
+	result := []s3.ObjectInfo{}
+	for obj := range store.minioClient.ListObjects(ctx, bucket, minio.ListObjectsOptions{
+		Prefix:       opts.Prefix,
+		Recursive:    opts.Recursive,
+		WithMetadata: true,
+	}) {
+		if obj.Err != nil {
+			return nil, toObjectError(obj.Err)
+		}
+		if opts.MaxKeys > 0 && len(result) >= opts.MaxKeys {
+			break
+		}
+		info := toObjectInfo(bucket, obj)
+		//common prefixes are returned with the trailing slash and without etag
+		info.IsPrefix = obj.ETag == "" && strings.HasSuffix(obj.Key, "/")
+		result = append(result, *info)
 	}
+	return result, nil
+}
 
-	if util.FileExists(filePath){
-		log.Tracef("local file exists, %v, %v",objectName,filePath)
-		return true,nil
-	}
+func (store *S3Store) RemoveObject(ctx context.Context, bucket, object string) error {
+	return toObjectError(store.minioClient.RemoveObject(ctx, bucket, object, minio.RemoveObjectOptions{}))
+}
 
-	tempPath:=filePath+".s3_tmp"
-	if util.FileExists(tempPath){
-		log.Warnf("s3 temp file exists, delete: ",tempPath)
-		util.FileDelete(tempPath)
+func (store *S3Store) PresignedURL(ctx context.Context, method, bucket, object string, expires time.Duration) (*url.URL, error) {
+	switch method {
+	case http.MethodGet:
+		return store.minioClient.PresignedGetObject(ctx, bucket, object, expires, nil)
+	case http.MethodPut:
+		return store.minioClient.PresignedPutObject(ctx, bucket, object, expires)
 	}
+	return nil, errors.Errorf("invalid method [%v], should be GET or PUT", method)
+}
 
-	log.Debugf("s3 downloading file:%v to: %v",objectName,filePath)
-
-	err = uploader.minioClient.FGetObject(ctx, bucketName, objectName, tempPath, minio.GetObjectOptions{})
-	if err != nil {
-		if global.Env().IsDebug{
-			log.Error(err)
-		}
-		return false,err
+func serverSide(enc *s3.Encryption) (encrypt.ServerSide, error) {
+	if enc == nil {
+		return nil, nil
+	}
+	if err := enc.Validate(); err != nil {
+		return nil, err
 	}
-	if !util.FileExists(tempPath){
-		return false,errors.New("download failed")
+	switch enc.Type {
+	case s3.EncryptionKMS:
+		return encrypt.NewSSEKMS(enc.KMSKeyID, nil)
+	case s3.EncryptionC:
+		return encrypt.NewSSEC(enc.CustomerKey)
 	}
+	return encrypt.NewSSE(), nil
+}
 
-	err=os.Rename(tempPath,filePath)
-	if err != nil {
-		if global.Env().IsDebug{
-			log.Error(err)
+func toObjectInfo(bucket string, obj minio.ObjectInfo) *s3.ObjectInfo {
+	info := &s3.ObjectInfo{
+		Bucket:       bucket,
+		Key:          obj.Key,
+		Size:         obj.Size,
+		ETag:         obj.ETag,
+		ContentType:  obj.ContentType,
+		LastModified: obj.LastModified,
+	}
+	if len(obj.UserMetadata) > 0 {
+		info.Metadata = map[string]string{}
+		for k, v := range obj.UserMetadata {
+			info.Metadata[k] = v
 		}
-		return false,err
 	}
+	switch {
+	case obj.ChecksumSHA256 != "":
+		info.Checksum, info.ChecksumAlgorithm = obj.ChecksumSHA256, s3.ChecksumSHA256
+	case obj.ChecksumCRC32C != "":
+		info.Checksum, info.ChecksumAlgorithm = obj.ChecksumCRC32C, s3.ChecksumCRC32C
+	}
+	return info
+}
 
-	log.Debugf("successfully downloaded %s", objectName)
-
-	return true, nil
+func toObjectError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey":
+		return s3.ErrObjectNotFound
+	case "NoSuchBucket":
+		return s3.ErrBucketNotFound
+	}
+	return err
 }
 
 func (module *S3Module) Name() string {
 	return "s3"
 }
 
+func init() {
+	config.RegisterSectionSchema("s3", map[string]config.S3Config{})
+}
+
 func (module *S3Module) Setup() {
 	var err error
-	module.S3Configs=map[string]config.S3Config{}
-	ok,err:=env.ParseConfig("s3", &module.S3Configs)
-	if ok&&err!=nil &&global.Env().SystemConfig.Configs.PanicOnConfigError{
+	module.S3Configs = map[string]config.S3Config{}
+	module.localStores = map[string]*s3.LocalStore{}
+	ok, err := env.ParseConfig("s3", &module.S3Configs)
+	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
 		panic(err)
 	}
-	if ok{
-		for k,v:=range module.S3Configs{
-			handler,err:=NewS3Uploader(&v)
-			if err!=nil{
+	if ok {
+		for k, v := range module.S3Configs {
+			cfg := v
+			var store s3.ObjectStore
+			switch cfg.Type {
+			case "", "s3":
+				store, err = NewS3Store(&cfg)
+			case "local":
+				var local *s3.LocalStore
+				baseURL := ""
+				if cfg.Endpoint != "" {
+					baseURL = strings.TrimRight(cfg.Endpoint, "/") + "/object_store/" + k
+				}
+				local, err = s3.NewLocalStore(cfg.Path, baseURL, cfg.AccessSecret)
+				if err == nil {
+					module.localStores[k] = local
+					store = local
+				}
+			default:
+				err = errors.Errorf("invalid type [%v] of s3 server [%v], should be s3 or local", cfg.Type, k)
+			}
+			if err != nil {
 				log.Error(err)
 				continue
 			}
-			s3.Register(k,handler)
+			s3.Register(k, store)
+		}
+	}
+
+	if len(module.localStores) > 0 {
+		api.HandleAPIMethod(api.GET, "/object_store/:id/:bucket", module.handlePresigned)
+		api.HandleAPIMethod(api.PUT, "/object_store/:id/:bucket", module.handlePresigned)
+	}
+}
+
+// handlePresigned serves the presigned urls of the local stores
+func (module *S3Module) handlePresigned(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	handler := api.Handler{}
+	store, ok := module.localStores[ps.ByName("id")]
+	if !ok {
+		handler.Error404(w)
+		return
+	}
+	bucket := ps.ByName("bucket")
+	object, err := store.VerifyPresignedURL(req.Method, bucket, req.URL.Query())
+	if err != nil {
+		handler.WriteError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if req.Method == http.MethodPut {
+		info, err := store.PutObject(req.Context(), bucket, object, req.Body, req.ContentLength, s3.PutOptions{ContentType: req.Header.Get("Content-Type")})
+		if err != nil {
+			handler.WriteError(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("ETag", info.ETag)
+		w.WriteHeader(http.StatusOK)
+		return
 	}
 
+	reader, info, err := store.GetObject(req.Context(), bucket, object, s3.GetOptions{})
+	if err != nil {
+		if err == s3.ErrObjectNotFound || err == s3.ErrBucketNotFound {
+			handler.Error404(w)
+			return
+		}
+		handler.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	w.Header().Set("ETag", info.ETag)
+	w.Header().Set("Content-Length", util.Int64ToString(info.Size))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, reader)
 }
 
 func (module *S3Module) Start() error {
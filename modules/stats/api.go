@@ -54,7 +54,7 @@ func (handler SimpleStatsModule) StatsAction(w http.ResponseWriter, req *http.Re
 
 	metrics, err := stats.StatsMap()
 	if err != nil {
-		handler.WriteError(w, "stats is nil", 500)
+		handler.WriteError(w, "stats.api.not_enabled", 500)
 		return
 	}
 
@@ -85,7 +85,7 @@ func (handler SimpleStatsModule) PrometheusStatsAction(w http.ResponseWriter, re
 	var err error
 	metrics, err := stats.StatsMap()
 	if err != nil {
-		handler.WriteError(w, "stats is nil", 500)
+		handler.WriteError(w, "stats.api.not_enabled", 500)
 		return
 	}
 
@@ -43,6 +43,7 @@ import (
 	"github.com/shirou/gopsutil/v3/process"
 	"infini.sh/framework/core/api"
 	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/stats"
@@ -64,9 +65,8 @@ type SimpleStatsConfig struct {
 	FlushIntervalInMs        int  `config:"flush_interval_ms"`
 }
 
-func (module *SimpleStatsModule) Setup() {
-
-	module.config = &SimpleStatsConfig{
+func defaultSimpleStatsConfig() *SimpleStatsConfig {
+	return &SimpleStatsConfig{
 		Enabled:                  true,
 		Persist:                  true,
 		NoBuffer:                 true,
@@ -74,6 +74,15 @@ func (module *SimpleStatsModule) Setup() {
 		IncludeStorageStatsInAPI: true,
 		FlushIntervalInMs:        1000,
 	}
+}
+
+func init() {
+	config.RegisterSectionSchema("stats", defaultSimpleStatsConfig())
+}
+
+func (module *SimpleStatsModule) Setup() {
+
+	module.config = defaultSimpleStatsConfig()
 	env.ParseConfig("stats", module.config)
 
 	if !module.config.Enabled {
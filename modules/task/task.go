@@ -26,13 +26,14 @@ package task
 import (
 	"infini.sh/framework/core/api"
 	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/orm"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/task"
 	"infini.sh/framework/core/util"
 	"net/http"
-	"time"
 )
 
 type TaskModule struct {
@@ -40,24 +41,40 @@ type TaskModule struct {
 	pool                    *pipeline.Pool
 	TimeZone                string `config:"time_zone" json:"time_zone,omitempty"`
 	MaxConcurrentNumOfTasks int    `config:"max_concurrent_tasks" json:"max_concurrent_tasks,omitempty"`
+	//set workers to 0 to enqueue jobs only
+	Jobs task.JobConfig `config:"jobs" json:"jobs,omitempty"`
 }
 
 func (module *TaskModule) Name() string {
 	return "task"
 }
 
+func init() {
+	config.RegisterSectionSchema("task", &TaskModule{MaxConcurrentNumOfTasks: 100, Jobs: task.DefaultJobConfig()})
+}
+
 func (module *TaskModule) Setup() {
 
-	module.TimeZone = "UTC"
+	//crontab tasks run in the local time zone if not set
 	module.MaxConcurrentNumOfTasks = 100
+	module.Jobs = task.DefaultJobConfig()
 	ok, err := env.ParseConfig("task", &module)
 	if ok && err != nil  &&global.Env().SystemConfig.Configs.PanicOnConfigError{
 		panic(err)
 	}
 
-	tz, err := time.LoadLocation(module.TimeZone)
-	if tz == nil {
-		tz = time.UTC
+	if module.TimeZone != "" {
+		if err := task.SetDefaultTimeZone(module.TimeZone); err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
+			panic(err)
+		}
+	}
+	if module.Jobs.Enabled {
+		if err := task.InitJobs(module.Jobs); err != nil {
+			panic(err)
+		}
+		if module.Jobs.Store == task.JobStoreORM {
+			orm.MustRegisterSchemaWithIndexName(task.Task{}, "task")
+		}
 	}
 	module.pool, _ = pipeline.NewPoolWithTag("tasks",module.MaxConcurrentNumOfTasks)
 	global.RegisterShutdownCallback(func() {
@@ -68,14 +85,40 @@ func (module *TaskModule) Setup() {
 	api.HandleAPIMethod(api.POST, "/task/:id/_start", module.StartTask)
 	api.HandleAPIMethod(api.POST, "/task/:id/_stop", module.StopTask)
 	api.HandleAPIMethod(api.DELETE, "/task/:id", module.DeleteTask)
+	api.HandleAPIMethod(api.GET, "/task/:id/_history", module.GetTaskHistory,
+		api.WithSummary("Get the run history of a scheduled task"),
+		api.WithPathParam("id", "task id"),
+		api.WithQueryParam("size", "integer", "the number of the latest runs to return", false),
+		api.WithResponse([]task.TaskRun{}))
 
+	if module.Jobs.Enabled {
+		api.HandleAPIMethod(api.POST, "/jobs/", module.EnqueueJob,
+			api.WithSummary("Enqueue a durable job"),
+			api.WithRequest(EnqueueJobRequest{}))
+		api.HandleAPIMethod(api.GET, "/jobs/", module.SearchJobs,
+			api.WithSummary("List jobs"),
+			api.WithQueryParam("type", "string", "job type", false),
+			api.WithQueryParam("status", "string", "job status", false),
+			api.WithQueryParam("parent_id", "string", "list the children of the job", false),
+			api.WithQueryParam("size", "integer", "the max number of jobs", false),
+			api.WithResponse([]task.Task{}))
+		api.HandleAPIMethod(api.GET, "/job/:id", module.GetJob,
+			api.WithSummary("Get a job with its progress"),
+			api.WithPathParam("id", "job id"),
+			api.WithResponse(task.Task{}))
+		api.HandleAPIMethod(api.POST, "/job/:id/_cancel", module.CancelJob,
+			api.WithSummary("Cancel a job and its children"),
+			api.WithPathParam("id", "job id"))
+	}
 }
 
 func (module *TaskModule) Start() error {
 	task.RunTasks()
+	task.StartJobWorkers()
 	return nil
 }
 func (module *TaskModule) Stop() error {
+	task.StopJobWorkers()
 	task.StopTasks()
 	return nil
 }
@@ -103,3 +146,86 @@ func (module *TaskModule) DeleteTask(w http.ResponseWriter, req *http.Request, p
 	task.DeleteTask(ps.ByName("id"))
 	module.WriteAckOKJSON(w)
 }
+
+func (module *TaskModule) GetTaskHistory(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	size := module.GetIntOrDefault(req, "size", 0)
+	module.WriteJSON(w, task.GetTaskRuns(ps.ByName("id"), size), 200)
+}
+
+type EnqueueJobRequest struct {
+	ID          string                 `json:"id,omitempty"`
+	Type        string                 `json:"type"`
+	Payload     map[string]interface{} `json:"payload,omitempty"`
+	ParentID    string                 `json:"parent_id,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	MaxRetries  int                    `json:"max_retries,omitempty"`
+	Labels      map[string]interface{} `json:"labels,omitempty"`
+}
+
+func (module *TaskModule) EnqueueJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	obj := EnqueueJobRequest{}
+	if err := module.DecodeJSON(req, &obj); err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := task.EnqueueJob(obj.Type, obj.Payload, task.JobOptions{
+		ID:          obj.ID,
+		ParentID:    obj.ParentID,
+		Description: obj.Description,
+		MaxRetries:  obj.MaxRetries,
+		Labels:      obj.Labels,
+	})
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	module.WriteAckJSON(w, true, 200, util.MapStr{"id": id})
+}
+
+func (module *TaskModule) SearchJobs(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	jobs, err := task.SearchJobs(task.JobQuery{
+		Type:     module.GetParameter(req, "type"),
+		Status:   module.GetParameter(req, "status"),
+		ParentID: module.GetParameter(req, "parent_id"),
+		Size:     module.GetIntOrDefault(req, "size", 100),
+	})
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteJSON(w, jobs, 200)
+}
+
+func (module *TaskModule) GetJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	job, err := task.GetJob(ps.ByName("id"))
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		module.WriteAckJSON(w, false, 404, util.MapStr{
+			"error": "job not found",
+		})
+		return
+	}
+	module.WriteJSON(w, job, 200)
+}
+
+func (module *TaskModule) CancelJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	job, err := task.GetJob(ps.ByName("id"))
+	if err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if job == nil {
+		module.WriteAckJSON(w, false, 404, util.MapStr{
+			"error": "job not found",
+		})
+		return
+	}
+	if err = task.CancelJob(job.ID); err != nil {
+		module.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	module.WriteAckOKJSON(w)
+}
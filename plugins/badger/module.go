@@ -29,6 +29,7 @@ package badger
 
 import (
 	"github.com/dgraph-io/badger/v4"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/filter"
 	"infini.sh/framework/core/global"
@@ -68,8 +69,8 @@ func (module *Module) Name() string {
 	return "badger"
 }
 
-func (module *Module) Setup() {
-	module.cfg = &Config{
+func defaultConfig() *Config {
+	return &Config{
 		Enabled:                     true,
 		MemTableSize:                10 * 1024 * 1024,
 		ValueLogFileSize:            1<<30 - 1, //1g
@@ -84,6 +85,10 @@ func (module *Module) Setup() {
 		NumLevelZeroTablesStall: 2,
 		SingleBucketMode:        true,
 	}
+}
+
+func (module *Module) Setup() {
+	module.cfg = defaultConfig()
 	ok, err := env.ParseConfig("badger", module.cfg)
 	if ok && err != nil && global.Env().SystemConfig.Configs.PanicOnConfigError {
 		panic(err)
@@ -129,4 +134,5 @@ func (module *Module) Stop() error {
 
 func init() {
 	module.RegisterModuleWithPriority(&Module{}, -100)
+	config.RegisterSectionSchema("badger", defaultConfig())
 }
@@ -116,11 +116,11 @@ type Config struct {
 }
 
 func init() {
-	pipeline.RegisterProcessorPlugin("bulk_indexing", New)
+	pipeline.RegisterProcessorPluginWithConfigMetadata("bulk_indexing", New, defaultConfig())
 }
 
-func New(c *config.Config) (pipeline.Processor, error) {
-	cfg := Config{
+func defaultConfig() Config {
+	return Config{
 		NumOfSlices:          1,
 		MaxWorkers:           10,
 		MaxConnectionPerHost: 1,
@@ -153,6 +153,10 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		BulkConfig:             elastic.DefaultBulkProcessorConfig,
 		RetryDelayIntervalInMs: 5000,
 	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
 
 	if err := c.Unpack(&cfg); err != nil {
 		log.Error(err)
@@ -287,7 +291,7 @@ func (processor *BulkIndexingProcessor) Process(c *pipeline.Context) error {
 						})
 					}
 
-					cfgs := queue.GetConfigBySelector(&processor.config.Selector)
+					cfgs := queue.ScopeFromContext(c).GetConfigBySelector(&processor.config.Selector)
 					if global.Env().IsDebug {
 						log.Tracef("get %v queues", len(cfgs))
 					}
@@ -317,7 +321,7 @@ func (processor *BulkIndexingProcessor) Process(c *pipeline.Context) error {
 			}(c)
 		}
 	} else {
-		cfgs := queue.GetConfigBySelector(&processor.config.Selector)
+		cfgs := queue.ScopeFromContext(c).GetConfigBySelector(&processor.config.Selector)
 		log.Debugf("filter queue by:%v, num of queues:%v", processor.config.Selector.ToString(), len(cfgs))
 		for _, v := range cfgs {
 			if global.Env().IsDebug {
@@ -579,6 +583,14 @@ func (processor *BulkIndexingProcessor) NewSlicedBulkWorker(ctx *pipeline.Contex
 		log.Debugf("new slice_worker: %v, %v, %v, %v, %v", key, workerID, sliceID, tag, qConfig.ID)
 	}
 
+	//the worker is started again on next detection if the resource group is exhausted
+	release, err := ctx.AcquireWorker()
+	if err != nil {
+		log.Warnf("slice_worker not started, queue:%v, slice_id:%v, %v", qConfig.ID, sliceID, err)
+		return
+	}
+	defer release()
+
 	mainBuf := processor.bulkBufferPool.AcquireBulkBuffer()
 	mainBuf.Queue = qConfig.ID
 	defer processor.bulkBufferPool.ReturnBulkBuffer(mainBuf)
@@ -594,7 +606,6 @@ func (processor *BulkIndexingProcessor) NewSlicedBulkWorker(ctx *pipeline.Contex
 	var consumerConfig = processor.getConsumerConfig(qConfig.ID, processor.config.Consumer.Name, sliceID, maxSlices)
 
 	//try to get consumer instance
-	var err error
 	var consumerInstance queue.ConsumerAPI
 	consumerInstance, err = queue.AcquireConsumer(qConfig, consumerConfig, workerID)
 	if err != nil || consumerInstance == nil {
@@ -769,7 +780,7 @@ READ_DOCS:
 		}
 		if len(processor.config.WaitingAfter) > 0 {
 			for _, v := range processor.config.WaitingAfter {
-				qCfg := queue.GetOrInitConfig(v)
+				qCfg := queue.ScopeFromContext(ctx).GetOrInitConfig(v)
 				hasLag := queue.HasLag(qCfg)
 
 				if global.Env().IsDebug {
@@ -1073,6 +1084,13 @@ func (processor *BulkIndexingProcessor) submitBulkRequest(ctx *pipeline.Context,
 			log.Infof("submit bulk request, count: %v, size:%v", count, util.ByteSize(uint64(size)))
 		}
 
+		//wait for the in-flight budget of the resource group, the buffered messages are kept meanwhile
+		release, err := processor.acquireInFlightBytes(ctx, size)
+		if err != nil {
+			return false, err
+		}
+		defer release()
+
 		start := time.Now()
 		continueRequest, statsMap, bulkResult, err := bulkProcessor.Bulk(ctx.Context, tag, meta, host, mainBuf)
 		if global.Env().IsDebug {
@@ -1109,6 +1127,17 @@ func (processor *BulkIndexingProcessor) submitBulkRequest(ctx *pipeline.Context,
 	return true, nil
 }
 
+func (processor *BulkIndexingProcessor) acquireInFlightBytes(ctx *pipeline.Context, size int) (func(), error) {
+	for {
+		release, err := ctx.ResourceGroup().AcquireBytes(ctx, size)
+		if err == nil || ctx.IsCanceled() {
+			return release, err
+		}
+		log.Debugf("resource group [%v] is exhausted, retry in [%v]ms, %v", ctx.ResourceGroup().Name(), processor.config.RetryDelayIntervalInMs, err)
+		time.Sleep(time.Duration(processor.config.RetryDelayIntervalInMs) * time.Millisecond)
+	}
+}
+
 func (processor *BulkIndexingProcessor) updateContext(ctx *pipeline.Context, bulkResult *elastic.BulkResult) {
 	if bulkResult == nil {
 		return
@@ -0,0 +1,417 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* ©INFINI, All Rights Reserved.
+ * mail: contact#infini.ltd */
+
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// CDCProcessor tails the documents changed in the index by a monotonic field, eg: updated,
+// and pushes the change events to the queue, the position is checkpointed after the events are pushed
+type CDCProcessor struct {
+	config        *Config
+	api           searchAPI
+	producer      queue.ProducerAPI
+	checkpointKey string
+	labels        util.MapStr
+	lag           time.Duration
+}
+
+// searchAPI is the part of the elastic api used by the processor
+type searchAPI interface {
+	elastic.PointInTimeAPI
+	QueryDSL(ctx context.Context, indexName string, queryArgs *[]util.KV, queryDSL []byte) (*elastic.SearchResponse, error)
+}
+
+type Config struct {
+	Elasticsearch string `config:"elasticsearch"`
+	Index         string `config:"index"`
+	//monotonic field of the changes across the index, eg: updated, _seq_no is not supported as it is per shard
+	Field string `config:"field"`
+	//keyword or numeric field with unique values to break the ties of the monotonic field, it is checkpointed
+	//with the position, _shard_doc of the point in time is used if not set, which requires the lag
+	TiebreakerField string `config:"tiebreaker_field"`
+	//the documents changed within the lag are not tailed yet, so that the ones indexed late or not refreshed yet
+	//are not skipped, the field must be a date, set to 0 to tail the other fields
+	Lag string `config:"lag"`
+	//extra query to filter the documents
+	Query     map[string]interface{} `config:"query"`
+	BatchSize int                    `config:"batch_size"`
+	KeepAlive string                 `config:"keep_alive"`
+	//the documents marked as deleted are pushed as delete events
+	Tombstone TombstoneConfig `config:"tombstone"`
+	//key of the checkpoint, default to the cluster, index and field
+	CheckpointID string `config:"checkpoint_id"`
+
+	Queue struct {
+		Name   string                 `config:"name"`
+		Labels map[string]interface{} `config:"label" json:"label,omitempty"`
+	} `config:"queue"`
+}
+
+type TombstoneConfig struct {
+	Field string      `config:"field"`
+	Value interface{} `config:"value"`
+}
+
+const (
+	OpIndex  = "index"
+	OpDelete = "delete"
+)
+
+// ChangeEvent is pushed to the queue, the headers have the index, id and op of the document
+type ChangeEvent struct {
+	Headers map[string]string `json:"headers"`
+	//value of the monotonic field
+	Position    interface{}            `json:"position"`
+	SeqNo       int64                  `json:"seq_no,omitempty"`
+	PrimaryTerm int64                  `json:"primary_term,omitempty"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Source      map[string]interface{} `json:"source,omitempty"`
+}
+
+// Checkpoint is the position of the processor, the documents sorted up to the value and the tiebreaker are already pushed
+type Checkpoint struct {
+	Value      interface{} `json:"value,omitempty"`
+	Tiebreaker interface{} `json:"tiebreaker,omitempty"`
+	//the field of the tiebreaker, the _shard_doc of a point in time is not valid in the others
+	TiebreakerField string    `json:"tiebreaker_field,omitempty"`
+	Updated         time.Time `json:"updated"`
+}
+
+const shardDoc = "_shard_doc"
+
+func init() {
+	pipeline.RegisterProcessorPluginWithConfigMetadata("elastic_cdc", New, defaultConfig())
+}
+
+func defaultConfig() Config {
+	return Config{
+		BatchSize: 1000,
+		KeepAlive: "1m",
+		Lag:       "30s",
+	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of elastic_cdc processor: %s", err)
+	}
+
+	if cfg.Elasticsearch == "" || cfg.Index == "" || cfg.Field == "" {
+		return nil, errors.New("elasticsearch, index and field are required")
+	}
+	if cfg.Queue.Name == "" {
+		return nil, errors.New("name of queue can't be nil")
+	}
+	if cfg.Field == "_seq_no" {
+		return nil, errors.New("_seq_no is per shard and not monotonic across the index, use a date field, eg: updated")
+	}
+	if cfg.TiebreakerField == "_id" {
+		return nil, errors.New("_id can't be sorted on elasticsearch 8, use a keyword field with unique values")
+	}
+	lag, err := parseLag(cfg.Lag)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TiebreakerField == "" && lag == 0 {
+		return nil, errors.New("tiebreaker_field is required without the lag")
+	}
+
+	labels := util.MapStr{}
+	labels["type"] = "elastic_cdc"
+	labels["elasticsearch"] = cfg.Elasticsearch
+	labels["_index"] = cfg.Index
+	for k, v := range cfg.Queue.Labels {
+		labels[k] = v
+	}
+	//the producer is acquired on the first run, within the queue scope of the context
+	processor := newProcessor(&cfg, elastic.GetClient(cfg.Elasticsearch), nil)
+	processor.labels = labels
+	return processor, nil
+}
+
+func newProcessor(cfg *Config, api searchAPI, producer queue.ProducerAPI) *CDCProcessor {
+	if cfg.CheckpointID == "" {
+		cfg.CheckpointID = fmt.Sprintf("%v/%v/%v", cfg.Elasticsearch, cfg.Index, cfg.Field)
+	}
+	lag, _ := parseLag(cfg.Lag)
+	return &CDCProcessor{config: cfg, api: api, producer: producer, checkpointKey: cfg.CheckpointID, lag: lag}
+}
+
+func parseLag(lag string) (time.Duration, error) {
+	if lag == "" {
+		return 0, nil
+	}
+	v, err := time.ParseDuration(lag)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("invalid lag [%v]", lag)
+	}
+	return v, nil
+}
+
+func (processor *CDCProcessor) Name() string {
+	return "elastic_cdc"
+}
+
+func (processor *CDCProcessor) Process(ctx *pipeline.Context) error {
+	if processor.producer == nil {
+		queueConfig := queue.ScopeFromContext(ctx).AdvancedGetOrInitConfig("", processor.config.Queue.Name, processor.labels)
+		queueConfig.ReplaceLabels(processor.labels)
+		producer, err := queue.AcquireProducer(queueConfig)
+		if err != nil {
+			return err
+		}
+		processor.producer = producer
+	}
+
+	checkpoint, err := processor.loadCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	//the point in time keeps the view of the index while paging, tails by search_after only if not supported
+	pitID, err := processor.api.OpenPointInTime(processor.config.Index, processor.config.KeepAlive)
+	if err != nil && err != elastic.ErrPointInTimeNotSupported {
+		return err
+	}
+	if pitID != "" {
+		defer func() {
+			if err := processor.api.ClosePointInTime(pitID); err != nil {
+				log.Warnf("failed to close point in time of index [%v]: %v", processor.config.Index, err)
+			}
+		}()
+	}
+	tiebreakerField := processor.config.TiebreakerField
+	if tiebreakerField == "" {
+		if pitID == "" {
+			return errors.New("tiebreaker_field is required if point in time is not supported")
+		}
+		tiebreakerField = shardDoc
+	}
+
+	//the upper bound is fixed for the run, so that the pages are consistent
+	var upperBound interface{}
+	if processor.lag > 0 {
+		upperBound = float64(time.Now().Add(-processor.lag).UnixMilli())
+	}
+
+	var searchAfter []interface{}
+	for !ctx.IsCanceled() {
+		query := processor.buildQuery(checkpoint, tiebreakerField, upperBound, pitID, searchAfter)
+		var resp *elastic.SearchResponse
+		if pitID != "" {
+			resp, err = processor.api.SearchWithPointInTime(ctx.Context, util.MustToJSONBytes(query))
+		} else {
+			resp, err = processor.api.QueryDSL(ctx.Context, processor.config.Index, nil, util.MustToJSONBytes(query))
+		}
+		if err != nil {
+			return err
+		}
+		if resp.PitID != "" {
+			pitID = resp.PitID
+		}
+		hits := resp.Hits.Hits
+		if len(hits) == 0 {
+			return processor.advanceToBound(ctx, checkpoint, upperBound)
+		}
+
+		//the tiebreaker of the checkpoint is comparable only if it is of the same field and not of a point in time,
+		//otherwise the documents at the position are pushed again
+		skipTies := checkpoint.Value != nil && checkpoint.TiebreakerField == tiebreakerField && tiebreakerField != shardDoc
+		reqs := []queue.ProduceRequest{}
+		next := Checkpoint{Value: checkpoint.Value, Tiebreaker: checkpoint.Tiebreaker, TiebreakerField: checkpoint.TiebreakerField}
+		for _, hit := range hits {
+			if len(hit.Sort) < 2 {
+				return errors.Errorf("sort values of document [%v] not found", hit.ID)
+			}
+			position, tiebreaker := hit.Sort[0], hit.Sort[1]
+			if skipTies && samePosition(position, checkpoint.Value) && compareValues(tiebreaker, checkpoint.Tiebreaker) <= 0 {
+				continue
+			}
+			reqs = append(reqs, queue.ProduceRequest{
+				Key:  []byte(hit.ID),
+				Data: util.MustToJSONBytes(processor.toEvent(hit, position)),
+			})
+			next.Value = position
+			next.Tiebreaker = tiebreaker
+			next.TiebreakerField = tiebreakerField
+		}
+
+		if len(reqs) > 0 {
+			if _, err := processor.producer.Produce(&reqs); err != nil {
+				return err
+			}
+			stats.IncrementBy("elastic_cdc", processor.checkpointKey+".events", int64(len(reqs)))
+		}
+
+		next.Updated = time.Now()
+		if err := processor.saveCheckpoint(ctx, &next); err != nil {
+			return err
+		}
+		checkpoint = &next
+		searchAfter = hits[len(hits)-1].Sort
+
+		if global.Env().IsDebug {
+			log.Debugf("elastic_cdc [%v] pushed %v events, position: %v", processor.checkpointKey, len(reqs), next.Value)
+		}
+
+		if len(hits) < processor.config.BatchSize {
+			return processor.advanceToBound(ctx, checkpoint, upperBound)
+		}
+	}
+	return nil
+}
+
+// advanceToBound moves the checkpoint to the upper bound once the run tailed all the documents below it,
+// so that the next run starts from the bound without the ties of the tiebreaker
+func (processor *CDCProcessor) advanceToBound(ctx *pipeline.Context, checkpoint *Checkpoint, upperBound interface{}) error {
+	if upperBound == nil || (checkpoint.Value != nil && compareValues(upperBound, checkpoint.Value) <= 0) {
+		return nil
+	}
+	return processor.saveCheckpoint(ctx, &Checkpoint{Value: upperBound, Updated: time.Now()})
+}
+
+func (processor *CDCProcessor) buildQuery(checkpoint *Checkpoint, tiebreakerField string, upperBound interface{}, pitID string, searchAfter []interface{}) util.MapStr {
+	cfg := processor.config
+	sort := []util.MapStr{{cfg.Field: "asc"}, {tiebreakerField: "asc"}}
+
+	filters := []util.MapStr{}
+	position := util.MapStr{}
+	if checkpoint.Value != nil {
+		//the documents at the value of the checkpoint may be not pushed yet, the pushed ones are skipped by the tiebreaker
+		position["gte"] = checkpoint.Value
+	}
+	if upperBound != nil {
+		//the bounds of the date field are in epoch millis
+		position["lt"] = upperBound
+		position["format"] = "epoch_millis"
+	}
+	if len(position) > 0 {
+		filters = append(filters, util.MapStr{"range": util.MapStr{cfg.Field: position}})
+	}
+	if len(cfg.Query) > 0 {
+		filters = append(filters, cfg.Query)
+	}
+
+	query := util.MapStr{
+		"size":                cfg.BatchSize,
+		"sort":                sort,
+		"seq_no_primary_term": true,
+		"query":               util.MapStr{"bool": util.MapStr{"filter": filters}},
+	}
+	if pitID != "" {
+		query["pit"] = util.MapStr{"id": pitID, "keep_alive": cfg.KeepAlive}
+	}
+	if len(searchAfter) > 0 {
+		query["search_after"] = searchAfter
+	}
+	return query
+}
+
+func (processor *CDCProcessor) toEvent(hit elastic.IndexDocument, position interface{}) *ChangeEvent {
+	op := OpIndex
+	tombstone := processor.config.Tombstone
+	if tombstone.Field != "" {
+		if v, ok := hit.Source[tombstone.Field]; ok && util.ToString(v) == util.ToString(tombstone.Value) {
+			op = OpDelete
+		}
+	}
+	return &ChangeEvent{
+		Headers: map[string]string{
+			"index": hit.Index,
+			"id":    hit.ID,
+			"op":    op,
+		},
+		Position:    position,
+		SeqNo:       hit.SeqNo,
+		PrimaryTerm: hit.PrimaryTerm,
+		Timestamp:   time.Now(),
+		Source:      hit.Source,
+	}
+}
+
+func samePosition(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return util.ToString(a) == util.ToString(b)
+}
+
+// compareValues compares the sort values, numerically if both are numbers
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == b:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	x, ok1 := a.(float64)
+	y, ok2 := b.(float64)
+	if !ok1 || !ok2 {
+		return strings.Compare(util.ToString(a), util.ToString(b))
+	}
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// loadCheckpoint restores the position from the checkpoint of the pipeline, so that it is cleared by resetting the pipeline
+func (processor *CDCProcessor) loadCheckpoint(ctx *pipeline.Context) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{}
+	if _, err := ctx.GetCheckpoint(processor.checkpointKey, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (processor *CDCProcessor) saveCheckpoint(ctx *pipeline.Context, checkpoint *Checkpoint) error {
+	return ctx.SaveCheckpoint(processor.checkpointKey, checkpoint)
+}
@@ -0,0 +1,318 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package cdc
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+type testDoc struct {
+	id      string
+	updated float64
+	source  map[string]interface{}
+}
+
+// testIndex serves the searches sorted by the updated field and the id, as the point in time and search_after do
+type testIndex struct {
+	docs        []testDoc
+	pitDisabled bool
+	opened      int
+	closed      int
+	queries     []util.MapStr
+}
+
+func (index *testIndex) add(id string, updated float64, source map[string]interface{}) {
+	index.docs = append(index.docs, testDoc{id: id, updated: updated, source: source})
+}
+
+func (index *testIndex) OpenPointInTime(indexNames string, keepAlive string) (string, error) {
+	if index.pitDisabled {
+		return "", elastic.ErrPointInTimeNotSupported
+	}
+	index.opened++
+	return "pit-1", nil
+}
+
+func (index *testIndex) ClosePointInTime(pitID string) error {
+	index.closed++
+	return nil
+}
+
+func (index *testIndex) SearchWithPointInTime(ctx context.Context, queryDSL []byte) (*elastic.SearchResponse, error) {
+	return index.search(queryDSL)
+}
+
+func (index *testIndex) QueryDSL(ctx context.Context, indexName string, queryArgs *[]util.KV, queryDSL []byte) (*elastic.SearchResponse, error) {
+	return index.search(queryDSL)
+}
+
+func (index *testIndex) search(queryDSL []byte) (*elastic.SearchResponse, error) {
+	query := util.MapStr{}
+	util.MustFromJSONBytes(queryDSL, &query)
+	index.queries = append(index.queries, query)
+
+	var gte float64 = -1
+	var lt float64 = -1
+	if v, err := query.GetValue("query.bool.filter"); err == nil {
+		for _, f := range v.([]interface{}) {
+			if r, err := util.MapStr(f.(map[string]interface{})).GetValue("range.updated.gte"); err == nil {
+				gte = r.(float64)
+			}
+			if r, err := util.MapStr(f.(map[string]interface{})).GetValue("range.updated.lt"); err == nil {
+				lt = r.(float64)
+			}
+		}
+	}
+	var after []interface{}
+	if v, ok := query["search_after"]; ok {
+		after = v.([]interface{})
+	}
+
+	docs := append([]testDoc{}, index.docs...)
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].updated != docs[j].updated {
+			return docs[i].updated < docs[j].updated
+		}
+		return docs[i].id < docs[j].id
+	})
+	resp := &elastic.SearchResponse{}
+	for _, doc := range docs {
+		if doc.updated < gte || lt >= 0 && doc.updated >= lt {
+			continue
+		}
+		if after != nil {
+			afterUpdated, afterID := after[0].(float64), after[1].(string)
+			if doc.updated < afterUpdated || doc.updated == afterUpdated && doc.id <= afterID {
+				continue
+			}
+		}
+		if len(resp.Hits.Hits) >= int(query["size"].(float64)) {
+			break
+		}
+		resp.Hits.Hits = append(resp.Hits.Hits, elastic.IndexDocument{
+			Index:  "orders",
+			ID:     doc.id,
+			Source: doc.source,
+			Sort:   []interface{}{doc.updated, doc.id},
+		})
+	}
+	return resp, nil
+}
+
+type testProducer struct {
+	events []ChangeEvent
+	keys   []string
+	err    error
+}
+
+func (p *testProducer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceResponse, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	for _, v := range *reqs {
+		event := ChangeEvent{}
+		util.MustFromJSONBytes(v.Data, &event)
+		p.events = append(p.events, event)
+		p.keys = append(p.keys, string(v.Key))
+	}
+	return &[]queue.ProduceResponse{}, nil
+}
+
+func (p *testProducer) Close() error { return nil }
+
+func (p *testProducer) ids() []string {
+	ids := []string{}
+	for _, v := range p.events {
+		ids = append(ids, v.Headers["id"])
+	}
+	return ids
+}
+
+var checkpointStore = pipeline.NewMemoryCheckpointStore()
+
+func newTestContext() *pipeline.Context {
+	return pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "cdc", Checkpoint: pipeline.CheckpointConfig{Store: "cdc_test"}})
+}
+
+func newTestProcessor(checkpointID string, index *testIndex, producer *testProducer) *CDCProcessor {
+	pipeline.RegisterCheckpointStore("cdc_test", checkpointStore)
+	cfg := defaultConfig()
+	cfg.Index = "orders"
+	cfg.Field = "updated"
+	cfg.BatchSize = 2
+	cfg.TiebreakerField = "id"
+	cfg.Lag = ""
+	cfg.CheckpointID = checkpointID
+	cfg.Tombstone = TombstoneConfig{Field: "deleted", Value: true}
+	return newProcessor(&cfg, index, producer)
+}
+
+func TestCDCTailsChanges(t *testing.T) {
+	index := &testIndex{}
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		index.add(id, float64(100+i), map[string]interface{}{"name": id})
+	}
+	producer := &testProducer{}
+	processor := newTestProcessor("tails", index, producer)
+	ctx := newTestContext()
+
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, producer.ids())
+	assert.Equal(t, producer.ids(), producer.keys)
+	assert.Equal(t, map[string]string{"index": "orders", "id": "a", "op": OpIndex}, producer.events[0].Headers)
+	assert.Equal(t, float64(100), producer.events[0].Position)
+	assert.Equal(t, 1, index.opened)
+	assert.Equal(t, 1, index.closed)
+	//paging within the point in time
+	assert.Equal(t, "pit-1", index.queries[1]["pit"].(map[string]interface{})["id"])
+	assert.NotNil(t, index.queries[1]["search_after"])
+	assert.Equal(t, map[string]interface{}{"id": "asc"}, index.queries[0]["sort"].([]interface{})[1])
+
+	checkpoint, err := processor.loadCheckpoint(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(104), checkpoint.Value)
+	assert.Equal(t, "e", checkpoint.Tiebreaker)
+
+	//nothing changed
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, 5, len(producer.events))
+
+	//changes at the same position of the checkpoint and the deletes are captured
+	index.add("f", 104, map[string]interface{}{"name": "f"})
+	index.docs[0] = testDoc{id: "a", updated: 105, source: map[string]interface{}{"name": "a", "deleted": true}}
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "a"}, producer.ids())
+	assert.Equal(t, OpIndex, producer.events[5].Headers["op"])
+	assert.Equal(t, OpDelete, producer.events[6].Headers["op"])
+
+	//the checkpoint is shared by the new processor
+	producer2 := &testProducer{}
+	assert.NoError(t, newTestProcessor("tails", index, producer2).Process(ctx))
+	assert.Equal(t, 0, len(producer2.events))
+
+	//tails from scratch after the checkpoint of the pipeline is reset
+	assert.NoError(t, ctx.ResetCheckpoint())
+	assert.NoError(t, newTestProcessor("tails", index, producer2).Process(ctx))
+	assert.Equal(t, []string{"b", "c", "d", "e", "f", "a"}, producer2.ids())
+}
+
+func TestCDCWithoutPointInTime(t *testing.T) {
+	index := &testIndex{pitDisabled: true}
+	index.add("a", 100, nil)
+	index.add("b", 100, nil)
+	index.add("c", 100, nil)
+	producer := &testProducer{}
+	processor := newTestProcessor("without_pit", index, producer)
+	ctx := newTestContext()
+
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, []string{"a", "b", "c"}, producer.ids())
+	assert.Nil(t, index.queries[0]["pit"])
+	assert.Equal(t, map[string]interface{}{"id": "asc"}, index.queries[0]["sort"].([]interface{})[1])
+	assert.Equal(t, 0, index.closed)
+
+	checkpoint, _ := processor.loadCheckpoint(ctx)
+	assert.Equal(t, float64(100), checkpoint.Value)
+	assert.Equal(t, "c", checkpoint.Tiebreaker)
+
+	//the documents at the same position are skipped by the tiebreaker after restarts
+	index.add("d", 100, nil)
+	assert.NoError(t, newTestProcessor("without_pit", index, producer).Process(ctx))
+	assert.Equal(t, []string{"a", "b", "c", "d"}, producer.ids())
+}
+
+func TestCDCCheckpointOnFailure(t *testing.T) {
+	index := &testIndex{}
+	index.add("a", 100, nil)
+	producer := &testProducer{err: errors.New("queue is full")}
+	processor := newTestProcessor("failure", index, producer)
+	ctx := newTestContext()
+
+	assert.Error(t, processor.Process(ctx))
+	checkpoint, _ := processor.loadCheckpoint(ctx)
+	assert.Nil(t, checkpoint.Value)
+	assert.Equal(t, 1, index.closed)
+
+	//retried after the queue recovered
+	producer.err = nil
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, []string{"a"}, producer.ids())
+}
+
+func TestCDCLag(t *testing.T) {
+	index := &testIndex{}
+	now := float64(time.Now().UnixMilli())
+	index.add("a", now-60000, nil)
+	index.add("b", now-60000, nil)
+	index.add("c", now, nil)
+	producer := &testProducer{}
+	processor := newTestProcessor("lag", index, producer)
+	processor.config.TiebreakerField = ""
+	processor.lag = 100 * time.Millisecond
+	ctx := newTestContext()
+
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, []string{"a", "b"}, producer.ids())
+	assert.Equal(t, map[string]interface{}{"_shard_doc": "asc"}, index.queries[0]["sort"].([]interface{})[1])
+	//advanced to the upper bound once the documents below are tailed
+	checkpoint, _ := processor.loadCheckpoint(ctx)
+	assert.True(t, checkpoint.Value.(float64) > now-60000 && checkpoint.Value.(float64) < now)
+	assert.Nil(t, checkpoint.Tiebreaker)
+
+	//the changes within the lag are tailed by the next runs
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, []string{"a", "b", "c"}, producer.ids())
+
+	//the tiebreaker of the point in time is not available
+	index.pitDisabled = true
+	assert.Error(t, processor.Process(ctx))
+}
+
+func TestCDCConfig(t *testing.T) {
+	newCDC := func(cfg map[string]interface{}) error {
+		cfg["elasticsearch"] = "default"
+		cfg["index"] = "orders"
+		cfg["queue"] = map[string]interface{}{"name": "cdc"}
+		c, err := config.NewConfigFrom(cfg)
+		assert.NoError(t, err)
+		_, err = New(c)
+		return err
+	}
+	assert.ErrorContains(t, newCDC(map[string]interface{}{"field": "_seq_no"}), "_seq_no")
+	assert.ErrorContains(t, newCDC(map[string]interface{}{"field": "updated", "tiebreaker_field": "_id"}), "_id")
+	assert.ErrorContains(t, newCDC(map[string]interface{}{"field": "updated", "lag": "0"}), "tiebreaker_field")
+	assert.ErrorContains(t, newCDC(map[string]interface{}{"field": "updated", "lag": "soon"}), "lag")
+}
@@ -63,6 +63,7 @@ type IndexingMergeProcessor struct {
 	initLocker        sync.RWMutex
 	config            Config
 	outputQueueConfig *queue.QueueConfig
+	labels            util.MapStr
 }
 
 // 处理纯 json 格式的消息索引
@@ -95,15 +96,19 @@ type Config struct {
 }
 
 func init() {
-	pipeline.RegisterProcessorPlugin("indexing_merge", New)
+	pipeline.RegisterProcessorPluginWithConfigMetadata("indexing_merge", New, defaultConfig())
 }
 
-func New(c *config.Config) (pipeline.Processor, error) {
-	cfg := Config{
+func defaultConfig() Config {
+	return Config{
 		BulkSizeInMB:         10,
 		NumOfWorkers:         1,
 		IdleTimeoutInSeconds: 5,
 	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
 
 	if err := c.Unpack(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unpack the configuration of index_diff processor: %s", err)
@@ -132,7 +137,6 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		config: cfg,
 	}
 
-	queueConfig := queue.GetOrInitConfig(cfg.OutputQueue.Name)
 	labels := util.MapStr{}
 	labels["type"] = "indexing_merge"
 
@@ -152,16 +156,29 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		labels["elasticsearch"] = cfg.Elasticsearch
 	}
 
-	queueConfig.ReplaceLabels(labels)
-	//update queue config
-	queue.RegisterConfig(queueConfig)
-
-	diff.outputQueueConfig = queueConfig
+	diff.labels = labels
 
 	return diff, nil
 
 }
 
+// initOutputQueue registers the output queue on the first run, within the queue scope of the context
+func (processor *IndexingMergeProcessor) initOutputQueue(ctx *pipeline.Context) {
+	processor.initLocker.Lock()
+	defer processor.initLocker.Unlock()
+	if processor.outputQueueConfig != nil {
+		return
+	}
+
+	scope := queue.ScopeFromContext(ctx)
+	queueConfig := scope.GetOrInitConfig(processor.config.OutputQueue.Name)
+	queueConfig.ReplaceLabels(processor.labels)
+	//update queue config
+	scope.RegisterConfig(queueConfig)
+
+	processor.outputQueueConfig = queueConfig
+}
+
 // 合并批量处理的操作，这里只用来合并请求和构造 bulk 请求。
 // TODO 重启子进程，当子进程挂了之后
 func (processor *IndexingMergeProcessor) Process(ctx *pipeline.Context) error {
@@ -182,6 +199,8 @@ func (processor *IndexingMergeProcessor) Process(ctx *pipeline.Context) error {
 		}
 	}()
 
+	processor.initOutputQueue(ctx)
+
 	bulkSizeInByte := 1048576 * processor.config.BulkSizeInMB
 	if processor.config.BulkSizeInKB > 0 {
 		bulkSizeInByte = 1024 * processor.config.BulkSizeInKB
@@ -246,7 +265,7 @@ READ_DOCS:
 			goto CLEAN_BUFFER
 		}
 
-		pop, _, err := queue.PopTimeout(queue.GetOrInitConfig(processor.config.InputQueue), idleDuration)
+		pop, _, err := queue.PopTimeout(queue.ScopeFromContext(ctx).GetOrInitConfig(processor.config.InputQueue), idleDuration)
 		if err != nil {
 			log.Error(err)
 			panic(err)
@@ -324,7 +343,7 @@ CLEAN_BUFFER:
 		//result, err := client.Bulk(mainBuf.Bytes())
 		if err != nil {
 			stats.Increment("json_indexing", "error")
-			queue.Push(queue.GetOrInitConfig(processor.config.FailureQueue), mainBuf.Bytes())
+			queue.Push(queue.ScopeFromContext(ctx).GetOrInitConfig(processor.config.FailureQueue), mainBuf.Bytes())
 		}
 
 		mainBuf.Reset()
@@ -85,15 +85,19 @@ type Config struct {
 }
 
 func init() {
-	pipeline.RegisterProcessorPlugin("json_indexing", New)
+	pipeline.RegisterProcessorPluginWithConfigMetadata("json_indexing", New, defaultConfig())
 }
 
-func New(c *config.Config) (pipeline.Processor, error) {
-	cfg := Config{
+func defaultConfig() Config {
+	return Config{
 		BulkSizeInMB:         10,
 		NumOfWorkers:         1,
 		IdleTimeoutInSeconds: 5,
 	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
 
 	if err := c.Unpack(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unpack the configuration of index_diff processor: %s", err)
@@ -221,7 +225,7 @@ READ_DOCS:
 			goto CLEAN_BUFFER
 		}
 
-		pop, _, err := queue.PopTimeout(queue.GetOrInitConfig(processor.config.InputQueue), idleDuration)
+		pop, _, err := queue.PopTimeout(queue.ScopeFromContext(ctx).GetOrInitConfig(processor.config.InputQueue), idleDuration)
 		if err != nil {
 			log.Error(err)
 			panic(err)
@@ -283,7 +287,7 @@ CLEAN_BUFFER:
 		if err != nil {
 			log.Error(err, util.SubString(util.UnsafeBytesToString(result.Body), 0, 200))
 			stats.Increment("json_indexing", "error")
-			queue.Push(queue.GetOrInitConfig(processor.config.FailureQueue), mainBuf.Bytes())
+			queue.Push(queue.ScopeFromContext(ctx).GetOrInitConfig(processor.config.FailureQueue), mainBuf.Bytes())
 		}
 
 		mainBuf.Reset()
@@ -49,6 +49,7 @@ type IndexingMergeToBulkProcessor struct {
 	metadata          *elastic.ElasticsearchMetadata
 	bulkSizeInByte    int
 	producer          queue.ProducerAPI
+	labels            util.MapStr
 }
 
 // 处理纯 json 格式的消息索引
@@ -72,14 +73,18 @@ type Config struct {
 }
 
 func init() {
-	pipeline.RegisterProcessorPlugin("merge_to_bulk", New)
+	pipeline.RegisterProcessorPluginWithConfigMetadata("merge_to_bulk", New, defaultConfig())
 }
 
-func New(c *config.Config) (pipeline.Processor, error) {
-	cfg := Config{
+func defaultConfig() Config {
+	return Config{
 		MessageField: "messages",
 		BulkSizeInMB: 10,
 	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
 
 	if err := c.Unpack(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unpack the configuration of index_diff processor: %s", err)
@@ -132,23 +137,43 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		diff.bulkSizeInByte = 1024 * cfg.BulkSizeInKB
 	}
 
-	queueConfig := queue.AdvancedGetOrInitConfig("",cfg.OutputQueue.Name,labels)
-	queueConfig.ReplaceLabels(labels)
-	diff.outputQueueConfig = queueConfig
+	diff.labels = labels
 
-	producer,err:=queue.AcquireProducer(queueConfig)
-	if err!=nil{
-		panic(err)
-	}
+	return diff, nil
+
+}
 
-	diff.producer=producer
+// initOutputQueue registers the output queue on the first run, within the queue scope of the context
+func (processor *IndexingMergeToBulkProcessor) initOutputQueue(ctx *pipeline.Context) error {
+	processor.initLocker.RLock()
+	ready := processor.producer != nil
+	processor.initLocker.RUnlock()
+	if ready {
+		return nil
+	}
 
-	return diff, nil
+	processor.initLocker.Lock()
+	defer processor.initLocker.Unlock()
+	if processor.producer != nil {
+		return nil
+	}
 
+	queueConfig := queue.ScopeFromContext(ctx).AdvancedGetOrInitConfig("", processor.config.OutputQueue.Name, processor.labels)
+	queueConfig.ReplaceLabels(processor.labels)
+	producer, err := queue.AcquireProducer(queueConfig)
+	if err != nil {
+		return err
+	}
+	processor.outputQueueConfig = queueConfig
+	processor.producer = producer
+	return nil
 }
 
 // 合并批量处理的操作，这里只用来合并请求和构造 bulk 请求。
 func (processor *IndexingMergeToBulkProcessor) Process(ctx *pipeline.Context) error {
+	if err := processor.initOutputQueue(ctx); err != nil {
+		return err
+	}
 
 	//get message from queue
 	obj := ctx.Get(processor.config.MessageField)
@@ -0,0 +1,708 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/rate"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+// MigrationProcessor copies the index to another cluster, the source is split into partitions which are scrolled
+// by slices in parallel, the finished partitions are checkpointed, and verified by counts and checksums at last
+type MigrationProcessor struct {
+	config      *Config
+	source      clusterAPI
+	target      clusterAPI
+	processors  *pipeline.Processors
+	partitioner func(q *elastic.PartitionQuery) ([]elastic.PartitionInfo, error)
+	limiter     waiter
+	stateLock   sync.Mutex
+	httpPool    *fasthttp.RequestResponsePool
+}
+
+// clusterAPI is the part of the elastic api used by the processor
+type clusterAPI interface {
+	NewScroll(indexNames string, scrollTime string, docBufferCount int, query *elastic.SearchRequest, slicedId, maxSlicedCount int) ([]byte, error)
+	NextScroll(ctx *elastic.APIContext, scrollTime string, scrollId string) ([]byte, error)
+	ClearScroll(scrollId string) error
+	Count(ctx context.Context, indexName string, body []byte) (*elastic.CountResponse, error)
+	Bulk(data []byte) (*util.Result, error)
+	Refresh(name string) (err error)
+}
+
+type waiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+type Config struct {
+	Source SourceConfig `config:"source"`
+	Target TargetConfig `config:"target"`
+
+	//partitions migrated concurrently
+	PartitionConcurrency int `config:"partition_concurrency"`
+	//limit the documents written to the target per second, 0 means unlimited
+	DocsPerSecond int `config:"docs_per_second"`
+
+	//processors to transform the documents, the documents are put to the context under the document_field,
+	//as []elastic.IndexDocument, the documents removed from the list are not migrated
+	Processors    []*config.Config `config:"processor"`
+	DocumentField string           `config:"document_field"`
+
+	Verify bool `config:"verify"`
+	//key of the checkpoint, default to the source cluster and index
+	CheckpointID string `config:"checkpoint_id"`
+}
+
+type SourceConfig struct {
+	Elasticsearch string                 `config:"elasticsearch"`
+	Index         string                 `config:"index"`
+	Query         map[string]interface{} `config:"query"`
+	Partition     PartitionConfig        `config:"partition"`
+	//slices of the scroll per partition
+	SliceSize  int    `config:"slice_size"`
+	BatchSize  int    `config:"batch_size"`
+	ScrollTime string `config:"scroll_time"`
+}
+
+// PartitionConfig splits the source by the field, the whole index is one partition if the field is empty
+type PartitionConfig struct {
+	Field string `config:"field"`
+	//date or number
+	Type string `config:"type"`
+	//duration for the date field, eg: 1d, or number for the number field
+	Step interface{} `config:"step"`
+}
+
+type TargetConfig struct {
+	Elasticsearch string `config:"elasticsearch"`
+	//default to the source index
+	Index string `config:"index"`
+}
+
+// State is the checkpoint of the migration
+type State struct {
+	Partitions []PartitionState `json:"partitions"`
+	//the partitions mismatched in the last verification, they are migrated again on next run
+	Mismatched []PartitionReport `json:"mismatched,omitempty"`
+	Finished   bool              `json:"finished"`
+}
+
+// PartitionState is the progress of the partition, the checksum is summed from the written documents,
+// so that it is independent of the order of the documents
+type PartitionState struct {
+	Key    string                 `json:"key"`
+	Filter map[string]interface{} `json:"filter"`
+	//estimated documents of the partition
+	Docs     int64  `json:"docs"`
+	Written  int64  `json:"written"`
+	Dropped  int64  `json:"dropped"`
+	Checksum string `json:"checksum"`
+	Done     bool   `json:"done"`
+}
+
+// PartitionReport is the result of the verification of the partition
+type PartitionReport struct {
+	Key              string `json:"key"`
+	SourceDocs       int64  `json:"source_docs"`
+	TargetDocs       int64  `json:"target_docs"`
+	Written          int64  `json:"written"`
+	Dropped          int64  `json:"dropped"`
+	Checksum         string `json:"checksum"`
+	ExpectedChecksum string `json:"expected_checksum"`
+}
+
+func (report *PartitionReport) Matched() bool {
+	return report.SourceDocs == report.Written+report.Dropped && report.TargetDocs == report.Written &&
+		report.Checksum == report.ExpectedChecksum
+}
+
+const name = "elastic_migration"
+
+func init() {
+	pipeline.RegisterProcessorPluginWithConfigMetadata(name, New, defaultConfig())
+}
+
+func defaultConfig() Config {
+	return Config{
+		Source: SourceConfig{
+			SliceSize:  1,
+			BatchSize:  1000,
+			ScrollTime: "5m",
+		},
+		PartitionConcurrency: 1,
+		DocumentField:        "documents",
+		Verify:               true,
+	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of elastic_migration processor: %s", err)
+	}
+
+	if cfg.Source.Elasticsearch == "" || cfg.Source.Index == "" || cfg.Target.Elasticsearch == "" {
+		return nil, errors.New("elasticsearch and index of source, and elasticsearch of target are required")
+	}
+
+	processors, err := pipeline.NewPipeline(cfg.Processors)
+	if err != nil {
+		return nil, err
+	}
+
+	processor := newProcessor(&cfg, elastic.GetClient(cfg.Source.Elasticsearch), elastic.GetClient(cfg.Target.Elasticsearch))
+	processor.processors = processors
+	processor.partitioner = func(q *elastic.PartitionQuery) ([]elastic.PartitionInfo, error) {
+		return elastic.GetPartitions(q, elastic.GetClient(cfg.Source.Elasticsearch))
+	}
+	if cfg.DocsPerSecond > 0 {
+		//a whole batch must fit in the burst
+		burst := cfg.DocsPerSecond
+		if cfg.Source.BatchSize > burst {
+			burst = cfg.Source.BatchSize
+		}
+		processor.limiter = rate.GetRateLimiter(name, cfg.CheckpointID, cfg.DocsPerSecond, burst, time.Second)
+	}
+	return processor, nil
+}
+
+func newProcessor(cfg *Config, source, target clusterAPI) *MigrationProcessor {
+	if cfg.Target.Index == "" {
+		cfg.Target.Index = cfg.Source.Index
+	}
+	if cfg.Source.SliceSize < 1 {
+		cfg.Source.SliceSize = 1
+	}
+	if cfg.PartitionConcurrency < 1 {
+		cfg.PartitionConcurrency = 1
+	}
+	if cfg.CheckpointID == "" {
+		cfg.CheckpointID = fmt.Sprintf("%v/%v", cfg.Source.Elasticsearch, cfg.Source.Index)
+	}
+	return &MigrationProcessor{
+		config:   cfg,
+		source:   source,
+		target:   target,
+		httpPool: fasthttp.NewRequestResponsePool(name + "_" + util.GetUUID()),
+	}
+}
+
+func (processor *MigrationProcessor) Name() string {
+	return name
+}
+
+func (processor *MigrationProcessor) Process(ctx *pipeline.Context) error {
+	state, err := processor.loadState(ctx)
+	if err != nil {
+		return err
+	}
+	if state.Finished {
+		log.Infof("migration of [%v] was finished, reset the checkpoint of the pipeline to migrate again", processor.config.CheckpointID)
+		return nil
+	}
+
+	if err := processor.migrate(ctx, state); err != nil {
+		return err
+	}
+
+	if processor.config.Verify {
+		return processor.verify(ctx, state)
+	}
+	state.Finished = true
+	return processor.saveState(ctx, state)
+}
+
+// loadState restores the partitions from the checkpoint, the partitions are computed once,
+// so that the checkpointed partitions stay the same after restarts
+func (processor *MigrationProcessor) loadState(ctx *pipeline.Context) (*State, error) {
+	state := &State{}
+	ok, err := ctx.GetCheckpoint(processor.config.CheckpointID, state)
+	if err != nil {
+		return nil, err
+	}
+	if ok && len(state.Partitions) > 0 {
+		return state, nil
+	}
+
+	state.Partitions, err = processor.getPartitions()
+	if err != nil {
+		return nil, err
+	}
+	return state, processor.saveState(ctx, state)
+}
+
+func (processor *MigrationProcessor) getPartitions() ([]PartitionState, error) {
+	cfg := processor.config.Source
+	var filter map[string]interface{}
+	if len(cfg.Query) > 0 {
+		filter = cfg.Query
+	}
+	if cfg.Partition.Field == "" {
+		if filter == nil {
+			filter = util.MapStr{"match_all": util.MapStr{}}
+		}
+		return []PartitionState{{Key: "all", Filter: filter}}, nil
+	}
+
+	q := &elastic.PartitionQuery{
+		IndexName: cfg.Index,
+		FieldName: cfg.Partition.Field,
+		FieldType: cfg.Partition.Type,
+		Step:      cfg.Partition.Step,
+	}
+	if filter != nil {
+		q.Filter = filter
+	}
+	partitions, err := processor.partitioner(q)
+	if err != nil {
+		return nil, err
+	}
+	states := []PartitionState{}
+	for _, p := range partitions {
+		key := "other"
+		if !p.Other {
+			key = strconv.FormatFloat(p.Start, 'f', -1, 64) + "-" + strconv.FormatFloat(p.End, 'f', -1, 64)
+		}
+		states = append(states, PartitionState{Key: key, Filter: p.Filter, Docs: p.Docs})
+	}
+	return states, nil
+}
+
+func (processor *MigrationProcessor) saveState(ctx *pipeline.Context, state *State) error {
+	return ctx.SaveCheckpoint(processor.config.CheckpointID, state)
+}
+
+// migrate copies the pending partitions, the partition is checkpointed after all its slices are finished,
+// the unfinished partitions are migrated from scratch on next run, which is safe as the documents are indexed by ids
+func (processor *MigrationProcessor) migrate(ctx *pipeline.Context, state *State) error {
+	pending := make(chan int, len(state.Partitions))
+	for i, p := range state.Partitions {
+		if !p.Done {
+			pending <- i
+		}
+	}
+	close(pending)
+
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < processor.config.PartitionConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range pending {
+				if ctx.IsCanceled() {
+					return
+				}
+				processor.stateLock.Lock()
+				partition := state.Partitions[idx]
+				processor.stateLock.Unlock()
+
+				err := processor.migratePartition(ctx, &partition)
+				if err == nil {
+					processor.stateLock.Lock()
+					state.Partitions[idx] = partition
+					err = processor.saveState(ctx, state)
+					processor.stateLock.Unlock()
+				}
+				if err != nil {
+					log.Errorf("failed to migrate partition [%v] of [%v]: %v", partition.Key, processor.config.CheckpointID, err)
+					errLock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errLock.Unlock()
+					continue
+				}
+				log.Infof("partition [%v] of [%v] migrated, written: %v, dropped: %v",
+					partition.Key, processor.config.CheckpointID, partition.Written, partition.Dropped)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if ctx.IsCanceled() {
+		return errors.Errorf("migration of [%v] was canceled", processor.config.CheckpointID)
+	}
+	return nil
+}
+
+type sliceResult struct {
+	written  int64
+	dropped  int64
+	checksum uint64
+}
+
+func (processor *MigrationProcessor) migratePartition(ctx *pipeline.Context, partition *PartitionState) error {
+	cfg := processor.config
+	results := make([]sliceResult, cfg.Source.SliceSize)
+	errs := make([]error, cfg.Source.SliceSize)
+	wg := sync.WaitGroup{}
+	for i := 0; i < cfg.Source.SliceSize; i++ {
+		wg.Add(1)
+		go func(sliceID int) {
+			defer wg.Done()
+			result := &results[sliceID]
+			errs[sliceID] = processor.scroll(ctx, processor.source, cfg.Source.Elasticsearch, cfg.Source.Index, partition.Filter, sliceID, cfg.Source.SliceSize, func(docs []scrollHit) error {
+				transformed, err := processor.transform(ctx, docs)
+				if err != nil {
+					return err
+				}
+				result.dropped += int64(len(docs) - len(transformed))
+				if len(transformed) == 0 {
+					return nil
+				}
+				checksum, err := processor.bulk(ctx, transformed)
+				if err != nil {
+					return err
+				}
+				result.written += int64(len(transformed))
+				result.checksum += checksum
+				stats.IncrementBy(name, cfg.CheckpointID+".written", int64(len(transformed)))
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	var checksum uint64
+	partition.Written, partition.Dropped = 0, 0
+	for i, result := range results {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		partition.Written += result.written
+		partition.Dropped += result.dropped
+		checksum += result.checksum
+	}
+	partition.Checksum = formatChecksum(checksum)
+	partition.Done = true
+	return nil
+}
+
+// scroll walks through the documents matching the filter, by the slice of the sliced scroll if slices is greater than 1
+func (processor *MigrationProcessor) scroll(ctx *pipeline.Context, api clusterAPI, cluster, index string, filter map[string]interface{}, sliceID, slices int, fn func(docs []scrollHit) error) error {
+	cfg := processor.config.Source
+	query := &elastic.SearchRequest{Size: cfg.BatchSize}
+	if err := query.Set("query", filter); err != nil {
+		return err
+	}
+	data, err := api.NewScroll(index, cfg.ScrollTime, cfg.BatchSize, query, sliceID, slices)
+	if err != nil {
+		return err
+	}
+
+	apiCtx := processor.acquireAPIContext(ctx.Context, cluster)
+	defer processor.releaseAPIContext(apiCtx)
+
+	var scrollID string
+	defer func() {
+		if scrollID == "" {
+			return
+		}
+		if err := api.ClearScroll(scrollID); err != nil {
+			log.Warnf("failed to clear scroll of [%v]: %v", index, err)
+		}
+	}()
+
+	for {
+		resp := &scrollResponse{}
+		if err := json.Unmarshal(data, resp); err != nil {
+			return err
+		}
+		if resp.ScrollID != "" {
+			scrollID = resp.ScrollID
+		}
+		if len(resp.Hits.Hits) == 0 {
+			return nil
+		}
+		if err := fn(resp.Hits.Hits); err != nil {
+			return err
+		}
+		if ctx.IsCanceled() {
+			return errors.Errorf("scroll of [%v] was canceled", index)
+		}
+
+		apiCtx.Request.Reset()
+		apiCtx.Response.Reset()
+		data, err = api.NextScroll(apiCtx, cfg.ScrollTime, scrollID)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// scrollResponse only parses the hits, as the total of the hits differs between versions
+type scrollResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []scrollHit `json:"hits"`
+	} `json:"hits"`
+}
+
+// scrollHit keeps the source as it is, it is decoded only if the processors are configured, so that the numbers
+// keep their precision, and the checksum is of the original bytes
+type scrollHit struct {
+	Index   string          `json:"_index,omitempty"`
+	ID      string          `json:"_id"`
+	Routing string          `json:"_routing,omitempty"`
+	Source  json.RawMessage `json:"_source"`
+}
+
+func (processor *MigrationProcessor) acquireAPIContext(ctx context.Context, cluster string) *elastic.APIContext {
+	apiCtx := &elastic.APIContext{
+		Context:  ctx,
+		Request:  processor.httpPool.AcquireRequestWithTag(name),
+		Response: processor.httpPool.AcquireResponseWithTag(name),
+	}
+	if meta := elastic.GetMetadata(cluster); meta != nil {
+		apiCtx.Client = meta.GetHttpClient(meta.GetActivePreferredHost(""))
+	}
+	return apiCtx
+}
+
+func (processor *MigrationProcessor) releaseAPIContext(apiCtx *elastic.APIContext) {
+	processor.httpPool.ReleaseRequest(apiCtx.Request)
+	processor.httpPool.ReleaseResponse(apiCtx.Response)
+}
+
+// transform passes the documents through the processors, returns the documents left in the context,
+// the numbers of the sources are decoded as json.Number, so that they are encoded as they were
+func (processor *MigrationProcessor) transform(ctx *pipeline.Context, hits []scrollHit) ([]scrollHit, error) {
+	if processor.processors == nil || len(processor.processors.List) == 0 {
+		return hits, nil
+	}
+
+	docs := make([]elastic.IndexDocument, 0, len(hits))
+	for _, hit := range hits {
+		source := map[string]interface{}{}
+		decoder := json.NewDecoder(bytes.NewReader(hit.Source))
+		decoder.UseNumber()
+		if err := decoder.Decode(&source); err != nil {
+			return nil, errors.Errorf("invalid source of document [%v]: %v", hit.ID, err)
+		}
+		docs = append(docs, elastic.IndexDocument{Index: hit.Index, ID: hit.ID, Routing: hit.Routing, Source: source})
+	}
+
+	newCtx := pipeline.Context{}
+	newCtx.ParentContext = ctx
+	newCtx.Context = ctx.Context
+	newCtx.Data = ctx.CloneData()
+	if _, err := newCtx.PutValue(processor.config.DocumentField, docs); err != nil {
+		return nil, err
+	}
+	if err := processor.processors.Process(&newCtx); err != nil {
+		return nil, err
+	}
+
+	v, err := newCtx.GetValue(processor.config.DocumentField)
+	if err != nil || v == nil {
+		return nil, nil
+	}
+	transformed, ok := v.([]elastic.IndexDocument)
+	if !ok {
+		return nil, errors.Errorf("invalid documents of type [%T] in field [%v]", v, processor.config.DocumentField)
+	}
+	result := make([]scrollHit, 0, len(transformed))
+	for _, doc := range transformed {
+		source, err := json.Marshal(doc.Source)
+		if err != nil {
+			return nil, errors.Errorf("invalid source of document [%v]: %v", doc.ID, err)
+		}
+		result = append(result, scrollHit{Index: doc.Index, ID: doc.ID, Routing: doc.Routing, Source: source})
+	}
+	return result, nil
+}
+
+// bulk indexes the documents to the target, returns the checksum of the written documents
+func (processor *MigrationProcessor) bulk(ctx *pipeline.Context, docs []scrollHit) (uint64, error) {
+	if processor.limiter != nil {
+		if err := processor.limiter.WaitN(ctx.Context, len(docs)); err != nil {
+			return 0, err
+		}
+	}
+
+	var checksum uint64
+	buffer := bytes.Buffer{}
+	for _, doc := range docs {
+		meta := elastic.BulkActionMetadata{Index: &elastic.BulkIndexMetadata{Index: processor.config.Target.Index, ID: doc.ID}}
+		if doc.Routing != "" {
+			meta.Index.Routing1 = doc.Routing
+		}
+		source, err := compactSource(doc.Source)
+		if err != nil {
+			return 0, errors.Errorf("invalid source of document [%v]: %v", doc.ID, err)
+		}
+		buffer.Write(util.MustToJSONBytes(meta))
+		buffer.WriteByte('\n')
+		buffer.Write(source)
+		buffer.WriteByte('\n')
+		checksum += documentChecksum(doc.ID, source)
+	}
+
+	result, err := processor.target.Bulk(buffer.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	if result != nil && len(result.Body) > 0 {
+		resp := elastic.BulkResponse{}
+		if util.FromJSONBytes(result.Body, &resp) == nil {
+			for _, item := range resp.Items {
+				if item.Index != nil && item.Index.Error != nil {
+					return 0, errors.Errorf("failed to index document [%v]: %v", item.Index.ID, item.Index.Error.Reason)
+				}
+			}
+		}
+	}
+	return checksum, nil
+}
+
+// verify compares the documents of the partitions, the mismatched partitions are reported and reset,
+// so that they are migrated again on next run
+func (processor *MigrationProcessor) verify(ctx *pipeline.Context, state *State) error {
+	if err := processor.target.Refresh(processor.config.Target.Index); err != nil {
+		return err
+	}
+
+	state.Mismatched = nil
+	for i := range state.Partitions {
+		partition := &state.Partitions[i]
+		report, err := processor.verifyPartition(ctx, partition)
+		if err != nil {
+			return err
+		}
+		if report.Matched() {
+			continue
+		}
+		log.Errorf("partition [%v] of [%v] mismatched, source docs: %v, target docs: %v, written: %v, dropped: %v, checksum: %v, expected: %v",
+			report.Key, processor.config.CheckpointID, report.SourceDocs, report.TargetDocs, report.Written, report.Dropped, report.Checksum, report.ExpectedChecksum)
+		state.Mismatched = append(state.Mismatched, *report)
+		partition.Done = false
+	}
+
+	state.Finished = len(state.Mismatched) == 0
+	if err := processor.saveState(ctx, state); err != nil {
+		return err
+	}
+	if !state.Finished {
+		keys := []string{}
+		for _, v := range state.Mismatched {
+			keys = append(keys, v.Key)
+		}
+		return errors.Errorf("partitions [%v] of [%v] mismatched", strings.Join(keys, ","), processor.config.CheckpointID)
+	}
+	if global.Env().IsDebug {
+		log.Debugf("migration of [%v] verified, %v partitions", processor.config.CheckpointID, len(state.Partitions))
+	}
+	return nil
+}
+
+// verifyPartition counts the documents on both sides by the filter of the partition, and sums the checksum of
+// the target documents, it assumes the transform doesn't change the field of the partition
+func (processor *MigrationProcessor) verifyPartition(ctx *pipeline.Context, partition *PartitionState) (*PartitionReport, error) {
+	cfg := processor.config
+	body := util.MustToJSONBytes(util.MapStr{"query": partition.Filter})
+	sourceCount, err := processor.source.Count(ctx.Context, cfg.Source.Index, body)
+	if err != nil {
+		return nil, err
+	}
+	targetCount, err := processor.target.Count(ctx.Context, cfg.Target.Index, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var checksum uint64
+	err = processor.scroll(ctx, processor.target, cfg.Target.Elasticsearch, cfg.Target.Index, partition.Filter, 0, 1, func(docs []scrollHit) error {
+		for _, doc := range docs {
+			source, err := compactSource(doc.Source)
+			if err != nil {
+				return errors.Errorf("invalid source of document [%v]: %v", doc.ID, err)
+			}
+			checksum += documentChecksum(doc.ID, source)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PartitionReport{
+		Key:              partition.Key,
+		SourceDocs:       sourceCount.Count,
+		TargetDocs:       targetCount.Count,
+		Written:          partition.Written,
+		Dropped:          partition.Dropped,
+		Checksum:         formatChecksum(checksum),
+		ExpectedChecksum: partition.Checksum,
+	}, nil
+}
+
+// compactSource removes the whitespaces of the source, so that it fits in a line of the bulk request,
+// the values are kept as they are
+func compactSource(source json.RawMessage) ([]byte, error) {
+	if len(source) == 0 {
+		return []byte("null"), nil
+	}
+	buffer := bytes.Buffer{}
+	if err := json.Compact(&buffer, source); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func documentChecksum(id string, source []byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	h.Write([]byte{0})
+	h.Write(source)
+	return h.Sum64()
+}
+
+func formatChecksum(v uint64) string {
+	return fmt.Sprintf("%016x", v)
+}
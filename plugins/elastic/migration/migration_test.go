@@ -0,0 +1,360 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/elastic"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/util"
+)
+
+// testCluster serves the scrolls, counts and bulks from memory, the filters support match_all, term and bool.must
+type testCluster struct {
+	lock    sync.Mutex
+	docs    map[string]map[string]interface{}
+	scrolls map[string][]elastic.IndexDocument
+	seq     int
+	size    int
+	bulked  []string
+	raw     map[string]string
+	cleared int
+}
+
+func newTestCluster() *testCluster {
+	return &testCluster{docs: map[string]map[string]interface{}{}, scrolls: map[string][]elastic.IndexDocument{}, raw: map[string]string{}}
+}
+
+func (cluster *testCluster) add(id, group string) {
+	cluster.docs[id] = map[string]interface{}{"group": group, "name": id}
+}
+
+func (cluster *testCluster) match(filter map[string]interface{}, source map[string]interface{}) bool {
+	for k, v := range filter {
+		switch k {
+		case "match_all":
+		case "term":
+			for field, value := range v.(map[string]interface{}) {
+				if util.ToString(source[field]) != util.ToString(value) {
+					return false
+				}
+			}
+		case "bool":
+			for _, f := range v.(map[string]interface{})["must"].([]interface{}) {
+				if !cluster.match(f.(map[string]interface{}), source) {
+					return false
+				}
+			}
+		default:
+			panic(fmt.Errorf("unsupported filter: %v", k))
+		}
+	}
+	return true
+}
+
+func (cluster *testCluster) search(filter map[string]interface{}) []elastic.IndexDocument {
+	ids := []string{}
+	for id, source := range cluster.docs {
+		if cluster.match(filter, source) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	docs := []elastic.IndexDocument{}
+	for _, id := range ids {
+		docs = append(docs, elastic.IndexDocument{Index: "orders", ID: id, Source: decodeSource(util.MustToJSONBytes(cluster.docs[id]))})
+	}
+	return docs
+}
+
+// decodeSource keeps the numbers as they are, as elasticsearch does
+func decodeSource(data []byte) map[string]interface{} {
+	source := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&source); err != nil {
+		panic(err)
+	}
+	return source
+}
+
+func (cluster *testCluster) page(scrollID string) []byte {
+	docs := cluster.scrolls[scrollID]
+	n := cluster.size
+	if n > len(docs) {
+		n = len(docs)
+	}
+	cluster.scrolls[scrollID] = docs[n:]
+	resp := scrollResponse{ScrollID: scrollID}
+	for _, doc := range docs[:n] {
+		resp.Hits.Hits = append(resp.Hits.Hits, scrollHit{Index: doc.Index, ID: doc.ID, Source: util.MustToJSONBytes(doc.Source)})
+	}
+	return util.MustToJSONBytes(resp)
+}
+
+func (cluster *testCluster) NewScroll(indexNames string, scrollTime string, docBufferCount int, query *elastic.SearchRequest, slicedId, maxSlicedCount int) ([]byte, error) {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+
+	body := map[string]interface{}{}
+	util.MustFromJSONBytes([]byte(query.ToJSONString()), &body)
+	docs := []elastic.IndexDocument{}
+	for i, doc := range cluster.search(body["query"].(map[string]interface{})) {
+		if i%maxSlicedCount == slicedId {
+			docs = append(docs, doc)
+		}
+	}
+	cluster.seq++
+	scrollID := fmt.Sprintf("scroll-%v", cluster.seq)
+	cluster.size = docBufferCount
+	cluster.scrolls[scrollID] = docs
+	return cluster.page(scrollID), nil
+}
+
+func (cluster *testCluster) NextScroll(ctx *elastic.APIContext, scrollTime string, scrollId string) ([]byte, error) {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+	return cluster.page(scrollId), nil
+}
+
+func (cluster *testCluster) ClearScroll(scrollId string) error {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+	delete(cluster.scrolls, scrollId)
+	cluster.cleared++
+	return nil
+}
+
+func (cluster *testCluster) Count(ctx context.Context, indexName string, body []byte) (*elastic.CountResponse, error) {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+	query := map[string]interface{}{}
+	util.MustFromJSONBytes(body, &query)
+	return &elastic.CountResponse{Count: int64(len(cluster.search(query["query"].(map[string]interface{}))))}, nil
+}
+
+func (cluster *testCluster) Bulk(data []byte) (*util.Result, error) {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	for i := 0; i < len(lines); i += 2 {
+		meta := elastic.BulkActionMetadata{}
+		util.MustFromJSONBytes(lines[i], &meta)
+		cluster.docs[meta.Index.ID] = decodeSource(lines[i+1])
+		cluster.raw[meta.Index.ID] = string(lines[i+1])
+		cluster.bulked = append(cluster.bulked, meta.Index.ID)
+	}
+	return &util.Result{Body: []byte("{}"), StatusCode: 200}, nil
+}
+
+func (cluster *testCluster) Refresh(name string) error {
+	return nil
+}
+
+// testTransform drops the document named drop, and marks the others
+type testTransform struct{}
+
+func (p *testTransform) Name() string { return "test_transform" }
+
+func (p *testTransform) Process(ctx *pipeline.Context) error {
+	v, err := ctx.GetValue("documents")
+	if err != nil {
+		return err
+	}
+	docs := []elastic.IndexDocument{}
+	for _, doc := range v.([]elastic.IndexDocument) {
+		if doc.ID == "drop" {
+			continue
+		}
+		doc.Source["migrated"] = true
+		docs = append(docs, doc)
+	}
+	_, err = ctx.PutValue("documents", docs)
+	return err
+}
+
+type testWaiter struct {
+	lock sync.Mutex
+	docs int
+}
+
+func (w *testWaiter) WaitN(ctx context.Context, n int) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.docs += n
+	return nil
+}
+
+var checkpointStore = pipeline.NewMemoryCheckpointStore()
+
+func newTestProcessor(source, target *testCluster) (*MigrationProcessor, *int) {
+	pipeline.RegisterCheckpointStore("migration_test", checkpointStore)
+	cfg := defaultConfig()
+	cfg.Source.Elasticsearch = "source"
+	cfg.Source.Index = "orders"
+	cfg.Source.BatchSize = 2
+	cfg.Source.SliceSize = 2
+	cfg.Source.Partition = PartitionConfig{Field: "group", Type: elastic.PartitionByNumber, Step: float64(1)}
+	cfg.Target.Elasticsearch = "target"
+	cfg.PartitionConcurrency = 2
+
+	processor := newProcessor(&cfg, source, target)
+	processor.processors = pipeline.NewPipelineList()
+	processor.processors.AddProcessor(&testTransform{})
+	calls := 0
+	processor.partitioner = func(q *elastic.PartitionQuery) ([]elastic.PartitionInfo, error) {
+		calls++
+		return []elastic.PartitionInfo{
+			{Start: 1, End: 1, Docs: 3, Filter: util.MapStr{"term": util.MapStr{"group": "a"}}},
+			{Start: 2, End: 2, Docs: 3, Filter: util.MapStr{"term": util.MapStr{"group": "b"}}},
+		}, nil
+	}
+	return processor, &calls
+}
+
+func newTestSource() *testCluster {
+	source := newTestCluster()
+	for _, id := range []string{"a1", "a2", "a3"} {
+		source.add(id, "a")
+	}
+	for _, id := range []string{"b1", "b2", "drop"} {
+		source.add(id, "b")
+	}
+	return source
+}
+
+func newTestContext(name string) *pipeline.Context {
+	return pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: name, Checkpoint: pipeline.CheckpointConfig{Store: "migration_test"}})
+}
+
+func getState(t *testing.T, ctx *pipeline.Context, processor *MigrationProcessor) *State {
+	state := &State{}
+	ok, err := ctx.GetCheckpoint(processor.config.CheckpointID, state)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	return state
+}
+
+func TestMigrateAndVerify(t *testing.T) {
+	source, target := newTestSource(), newTestCluster()
+	processor, calls := newTestProcessor(source, target)
+	waiter := &testWaiter{}
+	processor.limiter = waiter
+	ctx := newTestContext("migrate")
+
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, 5, len(target.docs))
+	assert.Equal(t, 5, waiter.docs)
+	assert.Equal(t, true, target.docs["a1"]["migrated"])
+	assert.Nil(t, target.docs["drop"])
+	assert.Empty(t, source.scrolls)
+	assert.Empty(t, target.scrolls)
+
+	state := getState(t, ctx, processor)
+	assert.True(t, state.Finished)
+	assert.Empty(t, state.Mismatched)
+	assert.Equal(t, "1-1", state.Partitions[0].Key)
+	assert.Equal(t, int64(3), state.Partitions[0].Written)
+	assert.Equal(t, int64(2), state.Partitions[1].Written)
+	assert.Equal(t, int64(1), state.Partitions[1].Dropped)
+	assert.True(t, state.Partitions[0].Done && state.Partitions[1].Done)
+
+	//finished migration is not repeated
+	bulked := len(target.bulked)
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, bulked, len(target.bulked))
+
+	//the modified document is found by the checksum
+	target.docs["b1"]["name"] = "changed"
+	state.Finished = false
+	assert.NoError(t, ctx.SaveCheckpoint(processor.config.CheckpointID, state))
+	err := processor.Process(ctx)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "2-2")
+	state = getState(t, ctx, processor)
+	assert.Equal(t, 1, len(state.Mismatched))
+	assert.Equal(t, int64(2), state.Mismatched[0].TargetDocs)
+	assert.NotEqual(t, state.Mismatched[0].ExpectedChecksum, state.Mismatched[0].Checksum)
+	assert.False(t, state.Partitions[1].Done)
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	source, target := newTestSource(), newTestCluster()
+	processor, calls := newTestProcessor(source, target)
+	ctx := newTestContext("resume")
+
+	//the first partition was marked as done, but missing in the target
+	state := &State{Partitions: []PartitionState{
+		{Key: "1-1", Filter: util.MapStr{"term": util.MapStr{"group": "a"}}, Written: 3, Done: true},
+		{Key: "2-2", Filter: util.MapStr{"term": util.MapStr{"group": "b"}}},
+	}}
+	assert.NoError(t, ctx.SaveCheckpoint(processor.config.CheckpointID, state))
+
+	err := processor.Process(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 0, *calls)
+	sort.Strings(target.bulked)
+	assert.Equal(t, []string{"b1", "b2"}, target.bulked)
+
+	state = getState(t, ctx, processor)
+	assert.False(t, state.Finished)
+	assert.Equal(t, 1, len(state.Mismatched))
+	assert.Equal(t, PartitionReport{Key: "1-1", SourceDocs: 3, TargetDocs: 0, Written: 3, Checksum: formatChecksum(0)}, state.Mismatched[0])
+	assert.False(t, state.Partitions[0].Done)
+	assert.True(t, state.Partitions[1].Done)
+
+	//the mismatched partition is migrated again
+	restarted := newTestContext("resume")
+	assert.NoError(t, processor.Process(restarted))
+	assert.Equal(t, 5, len(target.docs))
+	assert.Equal(t, 5, len(target.bulked))
+	assert.True(t, getState(t, restarted, processor).Finished)
+}
+
+func TestMigrateSourceAsIs(t *testing.T) {
+	source, target := newTestCluster(), newTestCluster()
+	source.docs["a1"] = map[string]interface{}{"group": "a", "id": json.Number("12345678901234567890"), "price": json.Number("1.50")}
+	processor, _ := newTestProcessor(source, target)
+	ctx := newTestContext("source_as_is")
+
+	//the numbers are kept by the processors
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, `{"group":"a","id":12345678901234567890,"migrated":true,"price":1.50}`, target.raw["a1"])
+
+	//the source is copied as it is without the processors
+	target = newTestCluster()
+	processor, _ = newTestProcessor(source, target)
+	processor.processors = nil
+	assert.NoError(t, processor.Process(newTestContext("source_without_processors")))
+	assert.Equal(t, `{"group":"a","id":12345678901234567890,"price":1.50}`, target.raw["a1"])
+}
@@ -94,11 +94,11 @@ type Config struct {
 }
 
 func init() {
-	pipeline.RegisterProcessorPlugin("http", New)
+	pipeline.RegisterProcessorPluginWithConfigMetadata("http", New, defaultConfig())
 }
 
-func New(c *config.Config) (pipeline.Processor, error) {
-	cfg := Config{
+func defaultConfig() Config {
+	return Config{
 		MessageField:        "messages",
 		ValidatedStatusCode: []int{200, 201},
 		Timeout: 10 * time.Second,
@@ -107,6 +107,10 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		MaxIdleConnDuration: 10 * time.Second,
 		MaxConnWaitTimeout: 10 * time.Second,
 	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
 
 	if err := c.Unpack(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unpack the configuration of http_replicator processor: %s", err)
@@ -0,0 +1,400 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/model"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/core/util"
+)
+
+// HTTPIngestProcessor listens on the binding address and pushes the received requests to the queue, eg: webhooks
+type HTTPIngestProcessor struct {
+	config      *IngestConfig
+	maxBodySize int64
+	queueConfig *queue.QueueConfig
+}
+
+type IngestConfig struct {
+	Binding   string            `config:"binding"`
+	Path      string            `config:"path"`
+	Methods   []string          `config:"methods"`
+	TLSConfig *config.TLSConfig `config:"tls"`
+
+	MaxBodySize string `config:"max_body_size"`
+
+	Auth IngestAuthConfig `config:"auth"`
+
+	Queue struct {
+		Name   string                 `config:"name"`
+		Labels map[string]interface{} `config:"label" json:"label,omitempty"`
+	} `config:"queue"`
+
+	ReadTimeout  time.Duration `config:"read_timeout"`
+	WriteTimeout time.Duration `config:"write_timeout"`
+	//seconds for the Retry-After header when the queue is full
+	RetryAfterInSeconds int `config:"retry_after_in_seconds"`
+}
+
+// IngestAuthConfig all the configured methods must pass
+type IngestAuthConfig struct {
+	BasicAuth *model.BasicAuth `config:"basic_auth"`
+
+	Token string `config:"token"`
+	//header of the token, default Authorization with the Bearer scheme
+	TokenHeader string `config:"token_header"`
+
+	HMAC *HMACConfig `config:"hmac"`
+}
+
+// HMACConfig verifies the signature of the request body, eg: X-Hub-Signature-256: sha256=<hex>
+type HMACConfig struct {
+	Secret    string `config:"secret"`
+	Header    string `config:"header"`
+	Algorithm string `config:"algorithm"` //sha1, sha256 or sha512
+	Prefix    string `config:"prefix"`
+}
+
+// IngestMessage is the message pushed to the queue, body is the raw json if the payload is valid json, or a string
+type IngestMessage struct {
+	ID         string            `json:"id"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Query      string            `json:"query,omitempty"`
+	RemoteAddr string            `json:"remote_addr"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       interface{}       `json:"body"`
+}
+
+func init() {
+	pipeline.RegisterProcessorPluginWithConfigMetadata("http_ingest", NewIngest, defaultIngestConfig())
+}
+
+const defaultHMACHeader = "X-Hub-Signature-256"
+
+func defaultIngestConfig() IngestConfig {
+	return IngestConfig{
+		Binding:             "127.0.0.1:8090",
+		Path:                "/",
+		Methods:             []string{http.MethodPost, http.MethodPut},
+		MaxBodySize:         "10mb",
+		ReadTimeout:         30 * time.Second,
+		WriteTimeout:        30 * time.Second,
+		RetryAfterInSeconds: 10,
+	}
+}
+
+func NewIngest(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultIngestConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of http_ingest processor: %s", err)
+	}
+
+	if cfg.Queue.Name == "" {
+		return nil, errors.New("name of queue can't be nil")
+	}
+
+	maxBodySize, err := util.ToBytes(cfg.MaxBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max_body_size [%v]: %v", cfg.MaxBodySize, err)
+	}
+
+	if cfg.Auth.HMAC != nil {
+		if cfg.Auth.HMAC.Secret == "" {
+			return nil, errors.New("secret of hmac can't be nil")
+		}
+		if cfg.Auth.HMAC.Header == "" {
+			cfg.Auth.HMAC.Header = defaultHMACHeader
+		}
+		//the signature of the default header is sent as sha256=<hex>
+		if cfg.Auth.HMAC.Prefix == "" && strings.EqualFold(cfg.Auth.HMAC.Header, defaultHMACHeader) {
+			cfg.Auth.HMAC.Prefix = "sha256="
+		}
+		if cfg.Auth.HMAC.Algorithm == "" {
+			cfg.Auth.HMAC.Algorithm = "sha256"
+		}
+		if newHMACHash(cfg.Auth.HMAC.Algorithm) == nil {
+			return nil, errors.Errorf("invalid hmac algorithm [%v]", cfg.Auth.HMAC.Algorithm)
+		}
+	}
+
+	//the endpoint is exposed to the network only when authenticated
+	if !isLoopback(cfg.Binding) && cfg.Auth.Token == "" && cfg.Auth.HMAC == nil && cfg.Auth.BasicAuth == nil {
+		return nil, errors.Errorf("binding [%v] is not a loopback address, auth token, hmac or basic_auth is required", cfg.Binding)
+	}
+
+	for i, v := range cfg.Methods {
+		cfg.Methods[i] = strings.ToUpper(v)
+	}
+
+	return &HTTPIngestProcessor{
+		config:      &cfg,
+		maxBodySize: int64(maxBodySize),
+	}, nil
+}
+
+func (processor *HTTPIngestProcessor) Name() string {
+	return "http_ingest"
+}
+
+// Process serves the ingest endpoint until the pipeline is stopped
+func (processor *HTTPIngestProcessor) Process(ctx *pipeline.Context) error {
+	processor.queueConfig = queue.ScopeFromContext(ctx).AdvancedGetOrInitConfig("", processor.config.Queue.Name, processor.config.Queue.Labels)
+
+	listener, err := net.Listen("tcp", processor.config.Binding)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(processor.config.Path, processor)
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  processor.config.ReadTimeout,
+		WriteTimeout: processor.config.WriteTimeout,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if processor.config.TLSConfig != nil && processor.config.TLSConfig.TLSEnabled {
+			errs <- server.ServeTLS(listener, processor.config.TLSConfig.TLSCertFile, processor.config.TLSConfig.TLSKeyFile)
+		} else {
+			errs <- server.Serve(listener)
+		}
+	}()
+
+	log.Infof("http_ingest is listening on [%v%v], queue: %v", processor.config.Binding, processor.config.Path, processor.queueConfig.Name)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errs:
+			if err == http.ErrServerClosed {
+				return nil
+			}
+			return err
+		case <-ticker.C:
+			if !global.ShuttingDown() && !ctx.IsCanceled() {
+				continue
+			}
+		case <-ctx.Done():
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = server.Shutdown(shutdownCtx)
+		cancel()
+		log.Debugf("http_ingest on [%v] stopped", processor.config.Binding)
+		return err
+	}
+}
+
+func (processor *HTTPIngestProcessor) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !util.StringInArray(processor.config.Methods, req.Method) {
+		processor.reject(w, "method_not_allowed", http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if !processor.authenticate(req) {
+		processor.reject(w, "unauthorized", http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if req.ContentLength > processor.maxBodySize {
+		processor.reject(w, "too_large", http.StatusRequestEntityTooLarge, "request body is too large")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, processor.maxBodySize+1))
+	if err != nil {
+		processor.reject(w, "error", http.StatusBadRequest, err.Error())
+		return
+	}
+	if int64(len(body)) > processor.maxBodySize {
+		processor.reject(w, "too_large", http.StatusRequestEntityTooLarge, "request body is too large")
+		return
+	}
+
+	if !processor.verifySignature(req, body) {
+		processor.reject(w, "unauthorized", http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	msg := processor.newMessage(req, body)
+	err = queue.Push(processor.queueConfig, util.MustToJSONBytes(msg))
+	if err != nil {
+		if err == queue.ErrQueueFull {
+			w.Header().Set("Retry-After", util.IntToString(processor.config.RetryAfterInSeconds))
+			processor.reject(w, "throttled", http.StatusTooManyRequests, err.Error())
+			return
+		}
+		log.Errorf("failed to push message to queue [%v]: %v", processor.queueConfig.Name, err)
+		processor.reject(w, "error", http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	stats.Increment("http_ingest", processor.queueConfig.Name+".accepted")
+	processor.writeJSON(w, util.MapStr{"acknowledged": true, "id": msg.ID}, http.StatusAccepted)
+}
+
+func (processor *HTTPIngestProcessor) newMessage(req *http.Request, body []byte) *IngestMessage {
+	msg := &IngestMessage{
+		ID:         util.GetUUID(),
+		Timestamp:  time.Now(),
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Query:      req.URL.RawQuery,
+		RemoteAddr: req.RemoteAddr,
+		Headers:    map[string]string{},
+	}
+	for k := range req.Header {
+		if processor.isSensitiveHeader(k) {
+			continue
+		}
+		msg.Headers[k] = req.Header.Get(k)
+	}
+	if json.Valid(body) {
+		msg.Body = json.RawMessage(body)
+	} else {
+		msg.Body = string(body)
+	}
+	return msg
+}
+
+func (processor *HTTPIngestProcessor) isSensitiveHeader(k string) bool {
+	if strings.EqualFold(k, "Authorization") || strings.EqualFold(k, "Cookie") {
+		return true
+	}
+	return processor.config.Auth.TokenHeader != "" && strings.EqualFold(k, processor.config.Auth.TokenHeader)
+}
+
+func (processor *HTTPIngestProcessor) authenticate(req *http.Request) bool {
+	auth := processor.config.Auth
+	if auth.BasicAuth != nil {
+		user, pass, ok := req.BasicAuth()
+		if !ok || !secureEqual(user, auth.BasicAuth.Username) || !secureEqual(pass, auth.BasicAuth.Password.Get()) {
+			return false
+		}
+	}
+	if auth.Token != "" {
+		var token string
+		if auth.TokenHeader == "" {
+			token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		} else {
+			token = req.Header.Get(auth.TokenHeader)
+		}
+		if !secureEqual(token, auth.Token) {
+			return false
+		}
+	}
+	return true
+}
+
+func (processor *HTTPIngestProcessor) verifySignature(req *http.Request, body []byte) bool {
+	cfg := processor.config.Auth.HMAC
+	if cfg == nil {
+		return true
+	}
+	signature := req.Header.Get(cfg.Header)
+	if cfg.Prefix != "" {
+		if !strings.HasPrefix(signature, cfg.Prefix) {
+			return false
+		}
+		signature = strings.TrimPrefix(signature, cfg.Prefix)
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(func() hash.Hash { return newHMACHash(cfg.Algorithm) }, []byte(cfg.Secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func (processor *HTTPIngestProcessor) reject(w http.ResponseWriter, reason string, status int, msg string) {
+	stats.Increment("http_ingest", processor.queueConfig.Name+"."+reason)
+	processor.writeJSON(w, util.MapStr{"acknowledged": false, "error": msg}, status)
+}
+
+func (processor *HTTPIngestProcessor) writeJSON(w http.ResponseWriter, obj interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(util.MustToJSONBytes(obj))
+}
+
+// isLoopback tells whether the binding only listens on the loopback interface
+func isLoopback(binding string) bool {
+	host, _, err := net.SplitHostPort(binding)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func newHMACHash(algorithm string) hash.Hash {
+	switch strings.ToLower(algorithm) {
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,187 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI LTD. All rights reserved.
+ * Web: https://infinilabs.com
+ * Email: hello#infini.ltd */
+
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/kv/kvtest"
+	"infini.sh/framework/core/queue"
+)
+
+// testQueue keeps the pushed messages in memory, rejects all the messages if full
+type testQueue struct {
+	lock     sync.Mutex
+	messages map[string][][]byte
+	full     bool
+}
+
+func (q *testQueue) Name() string                   { return "test" }
+func (q *testQueue) Init(string) error              { return nil }
+func (q *testQueue) Close(string) error             { return nil }
+func (q *testQueue) GetStorageSize(k string) uint64 { return 0 }
+func (q *testQueue) Destroy(string) error           { return nil }
+func (q *testQueue) GetQueues() []string            { return nil }
+func (q *testQueue) Push(k string, data []byte) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.full {
+		return queue.ErrQueueFull
+	}
+	q.messages[k] = append(q.messages[k], data)
+	return nil
+}
+
+func newTestIngest(t *testing.T, cfg map[string]interface{}) (*HTTPIngestProcessor, *testQueue) {
+	kvtest.Register("http_test")
+	q := &testQueue{messages: map[string][][]byte{}}
+	queue.RegisterDefaultHandler(q)
+
+	c, err := config.NewConfigFrom(cfg)
+	assert.NoError(t, err)
+	processor, err := NewIngest(c)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ingest := processor.(*HTTPIngestProcessor)
+	//resolved by Process, which is not run here
+	ingest.queueConfig = queue.AdvancedGetOrInitConfig("", ingest.config.Queue.Name, ingest.config.Queue.Labels)
+	return ingest, q
+}
+
+func send(processor *HTTPIngestProcessor, method, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/webhook?source=test", strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	processor.ServeHTTP(w, req)
+	return w
+}
+
+func TestHTTPIngestAccept(t *testing.T) {
+	processor, q := newTestIngest(t, map[string]interface{}{
+		"path":          "/webhook",
+		"max_body_size": "64b",
+		"queue":         map[string]interface{}{"name": "ingest_accept"},
+		"auth":          map[string]interface{}{"token": "secret"},
+	})
+
+	w := send(processor, http.MethodPost, `{"event":"push"}`, map[string]string{"Authorization": "Bearer secret", "X-Event": "push"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	messages := q.messages[processor.queueConfig.ID]
+	if assert.Equal(t, 1, len(messages)) {
+		msg := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(messages[0], &msg))
+		assert.Equal(t, map[string]interface{}{"event": "push"}, msg["body"])
+		assert.Equal(t, "source=test", msg["query"])
+		headers := msg["headers"].(map[string]interface{})
+		assert.Equal(t, "push", headers["X-Event"])
+		assert.NotContains(t, headers, "Authorization")
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send(processor, http.MethodPost, "{}", map[string]string{"Authorization": "Bearer wrong"}).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, send(processor, http.MethodGet, "", map[string]string{"Authorization": "Bearer secret"}).Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send(processor, http.MethodPost, strings.Repeat("a", 65), map[string]string{"Authorization": "Bearer secret"}).Code)
+	assert.Equal(t, 1, len(q.messages[processor.queueConfig.ID]))
+
+	//backpressure on full queue
+	q.full = true
+	w = send(processor, http.MethodPost, "{}", map[string]string{"Authorization": "Bearer secret"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+}
+
+func TestHTTPIngestAuth(t *testing.T) {
+	processor, q := newTestIngest(t, map[string]interface{}{
+		"queue": map[string]interface{}{"name": "ingest_auth"},
+		"auth": map[string]interface{}{
+			"basic_auth": map[string]interface{}{"username": "user", "password": "pass"},
+			"hmac":       map[string]interface{}{"secret": "key"},
+		},
+	})
+
+	body := "plain text"
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	req := func(user, signature string) int {
+		headers := map[string]string{"X-Hub-Signature-256": signature}
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.SetBasicAuth(user, "pass")
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		processor.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusAccepted, req("user", signature))
+	assert.Equal(t, http.StatusUnauthorized, req("other", signature))
+	assert.Equal(t, http.StatusUnauthorized, req("user", "sha256=00"))
+	assert.Equal(t, http.StatusUnauthorized, req("user", strings.TrimPrefix(signature, "sha256=")))
+
+	messages := q.messages[processor.queueConfig.ID]
+	if assert.Equal(t, 1, len(messages)) {
+		msg := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(messages[0], &msg))
+		assert.Equal(t, body, msg["body"])
+	}
+}
+
+func TestHTTPIngestBinding(t *testing.T) {
+	newIngest := func(cfg map[string]interface{}) error {
+		cfg["queue"] = map[string]interface{}{"name": "ingest_binding"}
+		c, err := config.NewConfigFrom(cfg)
+		assert.NoError(t, err)
+		_, err = NewIngest(c)
+		return err
+	}
+
+	assert.NoError(t, newIngest(map[string]interface{}{}))
+	assert.NoError(t, newIngest(map[string]interface{}{"binding": "localhost:8090"}))
+	assert.NoError(t, newIngest(map[string]interface{}{"binding": "[::1]:8090"}))
+	//not exposed without auth
+	assert.Error(t, newIngest(map[string]interface{}{"binding": "0.0.0.0:8090"}))
+	assert.Error(t, newIngest(map[string]interface{}{"binding": ":8090"}))
+	assert.NoError(t, newIngest(map[string]interface{}{"binding": "0.0.0.0:8090", "auth": map[string]interface{}{"token": "secret"}}))
+	assert.NoError(t, newIngest(map[string]interface{}{"binding": "0.0.0.0:8090", "auth": map[string]interface{}{"hmac": map[string]interface{}{"secret": "key"}}}))
+}
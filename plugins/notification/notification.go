@@ -0,0 +1,158 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package notification
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/api"
+	httprouter "infini.sh/framework/core/api/router"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/notification"
+	"infini.sh/framework/core/param"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+// NotificationProcessor sends the messages of the queue to the notification channels
+type NotificationProcessor struct {
+	config   *Config
+	channels map[string]*notification.Channel
+}
+
+type Config struct {
+	MessageField param.ParaKey `config:"message_field"`
+	//channels by name, the type is set by `type`, eg: webhook, slack, teams or pagerduty
+	Channels map[string]*config.Config `config:"channels"`
+	//channels of the messages which don't specify the channels
+	DefaultChannels []string `config:"default_channels"`
+}
+
+// message of the queue, the channels could be specified by the message
+type message struct {
+	notification.Message
+	Channels []string `json:"channels,omitempty"`
+}
+
+func init() {
+	pipeline.RegisterProcessorPluginWithConfigMetadata("notification", New, defaultConfig())
+
+	handler := api.Handler{}
+	api.HandleAPIMethod(api.GET, "/notification/deliveries", func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		size := handler.GetIntOrDefault(req, "size", 100)
+		handler.WriteJSON(w, notification.GetDeliveries(handler.GetParameter(req, "channel"), size), http.StatusOK)
+	},
+		api.WithSummary("Get the deliveries of the notifications, newest first"),
+		api.WithQueryParam("channel", "string", "only the deliveries of the channel", false),
+		api.WithQueryParam("size", "integer", "max num of deliveries, default 100", false),
+		api.WithResponse([]notification.DeliveryStatus{}))
+}
+
+func defaultConfig() Config {
+	return Config{
+		MessageField: "messages",
+	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
+
+	if err := c.Unpack(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unpack the configuration of notification processor: %s", err)
+	}
+
+	if len(cfg.Channels) == 0 {
+		return nil, errors.New("channels can't be empty")
+	}
+
+	processor := &NotificationProcessor{config: &cfg, channels: map[string]*notification.Channel{}}
+	for name, v := range cfg.Channels {
+		channel, err := notification.NewChannel(name, v)
+		if err != nil {
+			return nil, err
+		}
+		processor.channels[name] = channel
+	}
+	for _, name := range cfg.DefaultChannels {
+		if _, ok := processor.channels[name]; !ok {
+			return nil, errors.Errorf("default channel [%v] not found", name)
+		}
+	}
+	return processor, nil
+}
+
+// Release waits until the queued messages are delivered
+func (processor *NotificationProcessor) Release() error {
+	for _, channel := range processor.channels {
+		channel.Close()
+	}
+	return nil
+}
+
+func (processor *NotificationProcessor) Name() string {
+	return "notification"
+}
+
+func (processor *NotificationProcessor) Process(ctx *pipeline.Context) error {
+	obj := ctx.Get(processor.config.MessageField)
+	if obj == nil {
+		return nil
+	}
+	messages := obj.([]queue.Message)
+	log.Tracef("get %v messages from context", len(messages))
+
+	var failures []string
+	for _, v := range messages {
+		msg := message{}
+		if err := util.FromJSONBytes(v.Data, &msg); err != nil {
+			return err
+		}
+		channels := msg.Channels
+		if len(channels) == 0 {
+			channels = processor.config.DefaultChannels
+		}
+		if len(channels) == 0 {
+			return errors.Errorf("no channel for message [%v]", msg.ID)
+		}
+		for _, name := range channels {
+			channel, ok := processor.channels[name]
+			if !ok {
+				return errors.Errorf("channel [%v] not found", name)
+			}
+			//delivered in the background, only the messages dropped by the full queues fail here
+			if err := channel.Send(&msg.Message); err != nil {
+				log.Errorf("failed to send message [%v] to channel [%v]: %v", msg.ID, name, err)
+				failures = append(failures, name+": "+err.Error())
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Errorf("failed to deliver notifications: %v", failures)
+	}
+	return nil
+}
@@ -90,11 +90,11 @@ type Config struct {
 const name = "consumer"
 
 func init() {
-	pipeline.RegisterProcessorPlugin(name, New)
+	pipeline.RegisterProcessorPluginWithConfigMetadata(name, New, defaultConfig())
 }
 
-func New(c *config.Config) (pipeline.Processor, error) {
-	cfg := Config{
+func defaultConfig() Config {
+	return Config{
 		NumOfSlices:             1,
 		MaxWorkers:              10,
 		MaxConnectionPerHost:    1,
@@ -126,6 +126,10 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		QuitOnEOFQueue:         true,
 		RetryDelayIntervalInMs: 5000,
 	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
 
 	if err := c.Unpack(&cfg); err != nil {
 		log.Error(err)
@@ -254,7 +258,7 @@ func (processor *QueueConsumerProcessor) Process(c *pipeline.Context) error {
 						})
 					}
 
-					cfgs := queue.GetConfigBySelector(&processor.config.Selector)
+					cfgs := queue.ScopeFromContext(c).GetConfigBySelector(&processor.config.Selector)
 
 					//log.Errorf("filter queue by:%v, num of queues:%v", processor.config.Selector.ToString(), len(cfgs))
 
@@ -293,7 +297,7 @@ func (processor *QueueConsumerProcessor) Process(c *pipeline.Context) error {
 			}(c)
 		}
 	} else {
-		cfgs := queue.GetConfigBySelector(&processor.config.Selector)
+		cfgs := queue.ScopeFromContext(c).GetConfigBySelector(&processor.config.Selector)
 		log.Debugf("filter queue by:%v, num of queues:%v", processor.config.Selector.ToString(), len(cfgs))
 		for _, v := range cfgs {
 			log.Tracef("checking queue: %v", v)
@@ -429,6 +433,14 @@ func (processor *QueueConsumerProcessor) NewSlicedWorker(ctx *pipeline.Context,
 		log.Tracef("exit slice_worker, queue:%v, slice_id:%v, key:%v", qConfig.ID, sliceID, key)
 	}()
 
+	//the worker is started again on next detection if the resource group is exhausted
+	release, err := parentContext.AcquireWorker()
+	if err != nil {
+		log.Warnf("slice_worker not started, queue:%v, slice_id:%v, %v", qConfig.ID, sliceID, err)
+		return
+	}
+	defer release()
+
 	var initOffset queue.Offset
 	var offset queue.Offset
 	var groupName = processor.config.Consumer.Group
@@ -563,7 +575,7 @@ READ_DOCS:
 
 		if len(processor.config.WaitingAfter) > 0 {
 			for _, v := range processor.config.WaitingAfter {
-				qCfg := queue.GetOrInitConfig(v)
+				qCfg := queue.ScopeFromContext(ctx).GetOrInitConfig(v)
 				hasLag := queue.HasLag(qCfg)
 
 				if global.Env().IsDebug {
@@ -581,6 +593,10 @@ READ_DOCS:
 		if global.Env().IsDebug {
 			log.Tracef("slice_worker, worker:[%v] start consume queue:[%v][%v] offset:%v", workerID, qConfig.ID, sliceID, offset)
 		}
+		//throttled between the batches while the group runs out of the processing quota
+		if ctx.YieldProcessing() != nil {
+			goto CLEAN_BUFFER
+		}
 		consumerConfig.KeepActive()
 		messages, timeout, err := consumerInstance.FetchMessages(ctx1, consumerConfig.FetchMaxMessages)
 		if global.Env().IsDebug {
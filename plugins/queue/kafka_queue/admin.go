@@ -0,0 +1,245 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+* web: https://infinilabs.com
+* mail: hello#infini.ltd */
+
+package kafka_queue
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/queue"
+	"infini.sh/framework/core/util"
+)
+
+const retentionMsKey = "retention.ms"
+const retentionBytesKey = "retention.bytes"
+
+// getGroupOffset returns the sum of the committed offsets of all the partitions, comparable with LatestOffset
+func (this *KafkaQueue) getGroupOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.Offset, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*10))
+	defer cancel()
+
+	os, err := this.adminClient.FetchOffsetsForTopics(ctx, getGroupForKafka(consumer.Group, k.ID), k.ID)
+	if err == nil {
+		err = os.Error()
+	}
+	if err != nil {
+		if util.ContainsAnyInArray(err.Error(), ignoredError) {
+			if global.Env().IsDebug {
+				log.Debugf("err on get offset %v %v", k.ID, err)
+			}
+			return queue.NewOffset(0, 0), nil
+		}
+		return queue.Offset{}, err
+	}
+
+	var total int64
+	os.Each(func(o kadm.OffsetResponse) {
+		if o.Topic == k.ID && o.At > 0 {
+			total += o.At
+		}
+	})
+	return queue.NewOffset(0, total), nil
+}
+
+func (this *KafkaQueue) GetPartitions(k *queue.QueueConfig) ([]queue.PartitionInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
+	defer cancel()
+
+	topics, err := this.adminClient.ListTopics(ctx, k.ID)
+	if err != nil {
+		return nil, err
+	}
+	topic, ok := topics[k.ID]
+	if !ok {
+		return nil, errors.Errorf("topic [%v] not found", k.ID)
+	}
+	if topic.Err != nil {
+		return nil, topic.Err
+	}
+
+	start, err := this.adminClient.ListStartOffsets(ctx, k.ID)
+	if err != nil {
+		return nil, err
+	}
+	end, err := this.adminClient.ListEndOffsets(ctx, k.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := []queue.PartitionInfo{}
+	for _, p := range topic.Partitions {
+		info := queue.PartitionInfo{
+			Partition:   p.Partition,
+			Leader:      p.Leader,
+			Replicas:    p.Replicas,
+			ISR:         p.ISR,
+			StartOffset: -1,
+			EndOffset:   -1,
+		}
+		if o, ok := start.Lookup(k.ID, p.Partition); ok && o.Err == nil {
+			info.StartOffset = o.Offset
+		}
+		if o, ok := end.Lookup(k.ID, p.Partition); ok && o.Err == nil {
+			info.EndOffset = o.Offset
+		}
+		partitions = append(partitions, info)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].Partition < partitions[j].Partition
+	})
+	return partitions, nil
+}
+
+func (this *KafkaQueue) SetPartitions(k *queue.QueueConfig, total int) error {
+	if total <= 0 {
+		return errors.Errorf("invalid number of partitions: %v", total)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
+	defer cancel()
+
+	res, err := this.adminClient.UpdatePartitions(ctx, total, k.ID)
+	if err != nil {
+		return err
+	}
+	return res.Error()
+}
+
+func (this *KafkaQueue) GetRetention(k *queue.QueueConfig) (*queue.RetentionConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
+	defer cancel()
+
+	res, err := this.adminClient.DescribeTopicConfigs(ctx, k.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &queue.RetentionConfig{}
+	for _, r := range res {
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		for _, c := range r.Configs {
+			if c.Value == nil {
+				continue
+			}
+			v, err := strconv.ParseInt(*c.Value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch c.Key {
+			case retentionMsKey:
+				cfg.RetentionMs = &v
+			case retentionBytesKey:
+				cfg.RetentionBytes = &v
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func (this *KafkaQueue) UpdateRetention(k *queue.QueueConfig, cfg queue.RetentionConfig) error {
+	configs := []kadm.AlterConfig{}
+	if cfg.RetentionMs != nil {
+		v := strconv.FormatInt(*cfg.RetentionMs, 10)
+		configs = append(configs, kadm.AlterConfig{Op: kadm.SetConfig, Name: retentionMsKey, Value: &v})
+	}
+	if cfg.RetentionBytes != nil {
+		v := strconv.FormatInt(*cfg.RetentionBytes, 10)
+		configs = append(configs, kadm.AlterConfig{Op: kadm.SetConfig, Name: retentionBytesKey, Value: &v})
+	}
+	if len(configs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
+	defer cancel()
+
+	res, err := this.adminClient.AlterTopicConfigs(ctx, configs, k.ID)
+	if err != nil {
+		return err
+	}
+	for _, r := range res {
+		if r.Err != nil {
+			return errors.Errorf("failed to update retention of [%v]: %v %v", r.Name, r.Err, r.ErrMessage)
+		}
+	}
+	return nil
+}
+
+func (this *KafkaQueue) GetConsumerLag(k *queue.QueueConfig, group string) ([]queue.PartitionLag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
+	defer cancel()
+
+	committed, err := this.adminClient.FetchOffsetsForTopics(ctx, getGroupForKafka(group, k.ID), k.ID)
+	if err != nil && !util.ContainStr(err.Error(), "GROUP_ID_NOT_FOUND") {
+		return nil, err
+	}
+	start, err := this.adminClient.ListStartOffsets(ctx, k.ID)
+	if err != nil {
+		return nil, err
+	}
+	end, err := this.adminClient.ListEndOffsets(ctx, k.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err = end.Error(); err != nil {
+		return nil, err
+	}
+
+	lags := []queue.PartitionLag{}
+	end.Each(func(o kadm.ListedOffset) {
+		if o.Topic != k.ID {
+			return
+		}
+		lag := queue.PartitionLag{Partition: o.Partition, Committed: -1, EndOffset: o.Offset}
+		if c, ok := committed.Lookup(k.ID, o.Partition); ok && c.Err == nil && c.At >= 0 {
+			lag.Committed = c.At
+			lag.Lag = o.Offset - c.At
+		} else if s, ok := start.Lookup(k.ID, o.Partition); ok && s.Err == nil {
+			//nothing committed yet, all the retained messages are pending
+			lag.Lag = o.Offset - s.Offset
+		} else {
+			lag.Lag = o.Offset
+		}
+		if lag.Lag < 0 {
+			lag.Lag = 0
+		}
+		lags = append(lags, lag)
+	})
+	sort.Slice(lags, func(i, j int) bool {
+		return lags[i].Partition < lags[j].Partition
+	})
+	return lags, nil
+}
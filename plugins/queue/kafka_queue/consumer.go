@@ -32,10 +32,12 @@ import (
 	log "github.com/cihub/seelog"
 	"github.com/twmb/franz-go/pkg/kgo"
 	"github.com/twmb/franz-go/pkg/kmsg"
+	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/locker"
 	"infini.sh/framework/core/queue"
 	"infini.sh/framework/core/util"
+	"sync"
 	"time"
 )
 
@@ -44,9 +46,21 @@ type Consumer struct {
 	cCfg *queue.ConsumerConfig
 
 	client *kgo.Client
+
+	//commit offsets of the delivered records to the kafka group coordinator
+	groupCommit bool
+	//records fetched but not committed yet, in the order of delivery
+	delivered []*kgo.Record
+	//not nil in transactional mode, offsets are committed along with the produced messages
+	session *kgo.GroupTransactSession
+	txnLock sync.Mutex
 }
 
 func (this *Consumer) Close() error {
+	if this.session != nil {
+		this.session.Close()
+		return nil
+	}
 	this.client.Close()
 	return nil
 }
@@ -59,6 +73,7 @@ func (this *Consumer) ResetOffset(part, readPos int64) (err error) {
 	req[this.qCfg.ID] = map[int32]kgo.EpochOffset{}
 	req[this.qCfg.ID][int32(part)] = kgo.EpochOffset{Offset: readPos, Epoch: 0}
 	this.client.SetOffsets(req)
+	this.delivered = nil
 	//this.client.CommitOffsetsSync(context.Background(),req, func(client *kgo.Client, request *kmsg.OffsetCommitRequest, response *kmsg.OffsetCommitResponse, err error) {
 	//	if err!=nil{
 	//		panic(err)
@@ -69,9 +84,26 @@ func (this *Consumer) ResetOffset(part, readPos int64) (err error) {
 
 func (this *Consumer) CommitOffset(off queue.Offset) error {
 
+	if this.session != nil {
+		//the transaction commits the offsets of all the polled records, partial commits are rejected
+		n := len(this.delivered)
+		if n == 0 {
+			return nil
+		}
+		if !isRecordAt(this.delivered[n-1], off) {
+			return errors.Errorf("offset %v of [%v] is not the last fetched message, partial commits are not supported in transactional mode, use ProduceAndCommit", off.String(), this.qCfg.Name)
+		}
+		_, err := this.ProduceAndCommit(&[]queue.ProduceRequest{})
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*10))
 	defer cancel()
 
+	if this.groupCommit {
+		return this.commitDelivered(ctx, off)
+	}
+
 	var ret error
 	offset := map[string]map[int32]kgo.EpochOffset{}
 	offset[this.qCfg.ID] = map[int32]kgo.EpochOffset{}
@@ -89,6 +121,89 @@ func (this *Consumer) CommitOffset(off queue.Offset) error {
 	return ret
 }
 
+// commitDelivered commits the delivered records up to the offset of every partition, offset is the next offset
+// of the last processed message, all the delivered records are committed if it is not found
+func (this *Consumer) commitDelivered(ctx context.Context, off queue.Offset) error {
+	n := len(this.delivered)
+	for i, r := range this.delivered {
+		if isRecordAt(r, off) {
+			n = i + 1
+			break
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+
+	err := this.client.CommitRecords(ctx, this.delivered[:n]...)
+	if err != nil {
+		return err
+	}
+	this.delivered = this.delivered[n:]
+
+	if global.Env().IsDebug {
+		log.Infof("commit %v[%v] offset: %v, %v records to group", this.qCfg.Name, this.qCfg.ID, off.String(), n)
+	}
+	return nil
+}
+
+// isRecordAt returns true if offset is the next offset of the record
+func isRecordAt(r *kgo.Record, off queue.Offset) bool {
+	return int64(r.Partition) == off.Segment && r.Offset == off.Position-1
+}
+
+// ProduceAndCommit produces the messages and commits the offsets of all the fetched messages in one transaction,
+// the transaction is aborted and the messages will be fetched again if anything failed
+func (this *Consumer) ProduceAndCommit(reqs *[]queue.ProduceRequest) (*[]queue.ProduceResponse, error) {
+	if this.session == nil {
+		return nil, errors.New("consumer is not transactional")
+	}
+
+	this.txnLock.Lock()
+	defer this.txnLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
+	defer cancel()
+
+	if err := this.session.Begin(); err != nil {
+		return nil, err
+	}
+
+	results := []queue.ProduceResponse{}
+	var produceErr error
+	if reqs != nil && len(*reqs) > 0 {
+		response := this.session.ProduceSync(ctx, toRecords(reqs, this.qCfg.ID)...)
+		results = toProduceResponses(response)
+		produceErr = response.FirstErr()
+	}
+
+	committed, err := this.session.End(ctx, kgo.TransactionEndTry(produceErr == nil))
+	if produceErr != nil {
+		return nil, produceErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !committed {
+		return nil, errors.Errorf("transaction of [%v] was aborted", this.qCfg.Name)
+	}
+	this.delivered = this.delivered[:0]
+	return &results, nil
+}
+
+func (this *Consumer) poll(ctx context.Context, numOfMessages int) kgo.Fetches {
+	if this.session != nil {
+		if numOfMessages > 0 {
+			return this.session.PollRecords(ctx, numOfMessages)
+		}
+		return this.session.PollFetches(ctx)
+	}
+	if numOfMessages > 0 {
+		return this.client.PollRecords(ctx, numOfMessages)
+	}
+	return this.client.PollFetches(ctx)
+}
+
 func (this *Consumer) FetchMessages(ctx *queue.Context, numOfMessages int) (messages []queue.Message, isTimeout bool, err error) {
 
 	timeoutDuration := time.Duration(this.cCfg.FetchMaxWaitMs) * time.Millisecond
@@ -114,12 +229,7 @@ func (this *Consumer) FetchMessages(ctx *queue.Context, numOfMessages int) (mess
 	var nextOffset queue.Offset
 	var firstOffset queue.Offset
 	for {
-		var fetches kgo.Fetches
-		if numOfMessages > 0 {
-			fetches = this.client.PollRecords(ctx1, numOfMessages)
-		} else {
-			fetches = this.client.PollFetches(ctx1)
-		}
+		fetches := this.poll(ctx1, numOfMessages)
 		if fetches.IsClientClosed() {
 			return
 		}
@@ -143,6 +253,9 @@ func (this *Consumer) FetchMessages(ctx *queue.Context, numOfMessages int) (mess
 			size := len(r.Value)
 			m := queue.Message{Offset: offsetStr, NextOffset: nextOffsetStr, Data: r.Value, Size: size, Timestamp: r.Timestamp.Unix()}
 			msgs = append(msgs, m)
+			if this.groupCommit {
+				this.delivered = append(this.delivered, r)
+			}
 			ctx.MessageCount++
 			byteSize += size
 
@@ -35,6 +35,7 @@ import (
 	"github.com/twmb/franz-go/pkg/kadm"
 	"github.com/twmb/franz-go/pkg/kgo"
 	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
@@ -50,6 +51,7 @@ import (
 
 func init() {
 	module.RegisterSystemModule(&KafkaQueue{})
+	config.RegisterSectionSchema("kafka_queue", defaultConfig())
 }
 
 type Config struct {
@@ -69,8 +71,19 @@ type Config struct {
 	TLS      bool     `config:"tls"`
 
 	Mechanism string `config:"mechanism"`
+
+	//framework: offsets are managed by the framework, group: offsets are committed to the kafka group coordinator
+	OffsetCommit string `config:"offset_commit"`
+
+	//produce and commit the offsets of the consumers in kafka transactions, implies the group offset commit
+	Transactional               bool   `config:"transactional"`
+	TransactionalIDPrefix       string `config:"transactional_id_prefix"`
+	TransactionTimeoutInSeconds int    `config:"transaction_timeout_in_seconds"`
 }
 
+const OffsetCommitFramework = "framework"
+const OffsetCommitGroup = "group"
+
 //const PLAIN_MECHANISM = "PLAIN"//
 const SCRAM_SHA_256_Mechanism = "SCRAM-SHA-256"
 const SCRAM_SHA_512_Mechanism = "SCRAM-SHA-512"
@@ -84,6 +97,14 @@ type KafkaQueue struct {
 }
 
 func (this *KafkaQueue) newClient(opt []kgo.Opt) *kgo.Client {
+	client, err := kgo.NewClient(this.newClientOpts(opt)...)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (this *KafkaQueue) newClientOpts(opt []kgo.Opt) []kgo.Opt {
 	opts := []kgo.Opt{
 		kgo.SeedBrokers(this.cfg.Brokers...),
 	}
@@ -128,12 +149,19 @@ func (this *KafkaQueue) newClient(opt []kgo.Opt) *kgo.Client {
 	if opt != nil {
 		opts = append(opts, opt...)
 	}
+	return opts
+}
 
-	client, err := kgo.NewClient(opts...)
-	if err != nil {
-		panic(err)
+// groupCommit returns true if the offsets of the consumers are committed to the kafka group coordinator
+func (this *KafkaQueue) groupCommit() bool {
+	return this.cfg.OffsetCommit == OffsetCommitGroup || this.cfg.Transactional
+}
+
+func (this *KafkaQueue) transactionTimeout() time.Duration {
+	if this.cfg.TransactionTimeoutInSeconds <= 0 {
+		return 60 * time.Second
 	}
-	return client
+	return time.Duration(this.cfg.TransactionTimeoutInSeconds) * time.Second
 }
 
 func getGroupForKafka(group, topic string) string {
@@ -204,16 +232,30 @@ func (this *KafkaQueue) AcquireConsumer(qconfig *queue.QueueConfig, consumer *qu
 			opts = append(opts, kgo.ConsumeResetOffset(kgo.NewOffset().AtEnd()))
 		}
 
-		if !consumer.AutoCommitOffset {
+		if !consumer.AutoCommitOffset || this.groupCommit() {
 			opts = append(opts, kgo.DisableAutoCommit())
 		}
 
-		client := this.newClient(opts)
-
 		output := Consumer{
-			qCfg:   qconfig,
-			cCfg:   consumer,
-			client: client,
+			qCfg:        qconfig,
+			cCfg:        consumer,
+			groupCommit: this.groupCommit(),
+		}
+
+		if this.cfg.Transactional {
+			opts = append(opts,
+				kgo.TransactionalID(this.cfg.TransactionalIDPrefix+k),
+				kgo.TransactionTimeout(this.transactionTimeout()),
+				kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+				kgo.RequireStableFetchOffsets(),
+			)
+			output.session, err = kgo.NewGroupTransactSession(this.newClientOpts(opts)...)
+			if err != nil {
+				panic(err)
+			}
+			output.client = output.session.Client()
+		} else {
+			output.client = this.newClient(opts)
 		}
 
 		this.consumers.Store(getGroupForKafka(consumer.Group, qconfig.ID), &output)
@@ -238,11 +280,8 @@ func (this *KafkaQueue) Destroy(k string) error {
 	return nil
 }
 
-func (this *KafkaQueue) Setup() {
-	this.q = sync.Map{}
-	this.consumers = sync.Map{}
-	this.producers = sync.Map{}
-	this.cfg = &Config{
+func defaultConfig() *Config {
+	return &Config{
 		Enabled:               false,
 		Compression:           false,
 		TLS:                   true,
@@ -251,6 +290,13 @@ func (this *KafkaQueue) Setup() {
 		MaxBufferedRecords:    10000,
 		NumOfReplica:          1,
 	}
+}
+
+func (this *KafkaQueue) Setup() {
+	this.q = sync.Map{}
+	this.consumers = sync.Map{}
+	this.producers = sync.Map{}
+	this.cfg = defaultConfig()
 
 	ok, err := env.ParseConfig("kafka_queue", this.cfg)
 	if ok && err != nil  &&global.Env().SystemConfig.Configs.PanicOnConfigError{
@@ -427,6 +473,23 @@ func (this *KafkaQueue) LatestOffset(k *queue.QueueConfig) queue.Offset {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*60))
 	defer cancel()
 
+	if this.groupCommit() {
+		//sum of the end offsets of all the partitions, comparable with the committed offsets of the group
+		offsets, err := this.adminClient.ListEndOffsets(ctx, k.ID)
+		if err == nil {
+			err = offsets.Error()
+		}
+		if err != nil {
+			log.Error(k.Name, ", error on get offset:", err)
+			panic(err)
+		}
+		var total int64
+		offsets.Each(func(o kadm.ListedOffset) {
+			total += o.Offset
+		})
+		return queue.NewOffset(0, total)
+	}
+
 	offset1, err := this.adminClient.ListEndOffsets(ctx, k.ID)
 	if err != nil {
 		log.Error(k.Name, ", error on get offset:", offset1)
@@ -462,9 +525,13 @@ func (this *KafkaQueue) GetQueues() []string {
 	return q
 }
 
-var ignoredError = []string{"NOT_COORDINATOR", "UNKNOWN_TOPIC_OR_PARTITION"}
+var ignoredError = []string{"NOT_COORDINATOR", "UNKNOWN_TOPIC_OR_PARTITION", "GROUP_ID_NOT_FOUND"}
 
 func (this *KafkaQueue) GetOffset(k *queue.QueueConfig, consumer *queue.ConsumerConfig) (queue.Offset, error) {
+	if this.groupCommit() {
+		return this.getGroupOffset(k, consumer)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*1))
 	defer cancel()
 
@@ -576,6 +643,7 @@ func (this *KafkaQueue) AcquireProducer(cfg *queue.QueueConfig) (queue.ProducerA
 	}
 
 	producerID := util.GetUUID()
+	transactional := this.cfg.Transactional
 	opts := []kgo.Opt{
 		kgo.AllowAutoTopicCreation(),
 		kgo.ProducerBatchMaxBytes(this.cfg.ProducerBatchMaxBytes),
@@ -594,7 +662,14 @@ func (this *KafkaQueue) AcquireProducer(cfg *queue.QueueConfig) (queue.ProducerA
 		opts = append(opts, kgo.ManualFlushing())
 	}
 
-	producer := &Producer{ID: producerID, client: this.newClient(opts), cfg: cfg}
+	if transactional {
+		opts = append(opts,
+			kgo.TransactionalID(this.cfg.TransactionalIDPrefix+cfg.ID+"-"+global.Env().SystemConfig.NodeConfig.ID),
+			kgo.TransactionTimeout(this.transactionTimeout()),
+		)
+	}
+
+	producer := &Producer{ID: producerID, client: this.newClient(opts), cfg: cfg, transactional: transactional}
 	this.producers.Store(cfg.ID, producer)
 	if global.Env().IsDebug {
 		log.Infof("acquired producer:%v, %v, %v", cfg.Name, cfg.ID, producer.ID)
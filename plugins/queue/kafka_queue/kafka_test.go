@@ -0,0 +1,213 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+/* Copyright © INFINI Ltd. All rights reserved.
+* web: https://infinilabs.com
+* mail: hello#infini.ltd */
+
+package kafka_queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"infini.sh/framework/core/kv/kvtest"
+	"infini.sh/framework/core/queue"
+)
+
+func newTestKafkaQueue(t *testing.T, cfg Config, topics ...string) *KafkaQueue {
+	kvtest.Register("kafka_test")
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(int32(cfg.NumOfPartition), topics...))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(cluster.Close)
+
+	cfg.Brokers = cluster.ListenAddrs()
+	cfg.NumOfReplica = 1
+	cfg.ProducerBatchMaxBytes = 1024 * 1024
+	cfg.MaxBufferedRecords = 1000
+	q := &KafkaQueue{cfg: &cfg}
+	q.adminClient = kadm.NewClient(q.newClient(nil))
+	t.Cleanup(q.adminClient.Close)
+	return q
+}
+
+func newTestConsumerConfig(q, group string) *queue.ConsumerConfig {
+	cfg := queue.NewConsumerConfig(q, group, "c1")
+	cfg.FetchMaxWaitMs = 2000
+	cfg.AutoResetOffset = "earliest"
+	return cfg
+}
+
+func produce(t *testing.T, q *KafkaQueue, cfg *queue.QueueConfig, topic string, messages ...string) {
+	producer, err := q.AcquireProducer(cfg)
+	assert.NoError(t, err)
+	reqs := []queue.ProduceRequest{}
+	for _, v := range messages {
+		reqs = append(reqs, queue.ProduceRequest{Topic: topic, Data: []byte(v)})
+	}
+	res, err := producer.Produce(&reqs)
+	assert.NoError(t, err)
+	assert.Equal(t, len(messages), len(*res))
+}
+
+func fetch(t *testing.T, consumer queue.ConsumerAPI, n int) ([]queue.Message, *queue.Context) {
+	ctx := &queue.Context{}
+	messages, _, err := consumer.FetchMessages(ctx, n)
+	assert.NoError(t, err)
+	return messages, ctx
+}
+
+func totalLag(t *testing.T, q *KafkaQueue, cfg *queue.QueueConfig, group string) int64 {
+	lags, err := q.GetConsumerLag(cfg, group)
+	assert.NoError(t, err)
+	var total int64
+	for _, v := range lags {
+		total += v.Lag
+	}
+	return total
+}
+
+func TestKafkaGroupOffsetCommit(t *testing.T) {
+	q := newTestKafkaQueue(t, Config{NumOfPartition: 2, OffsetCommit: OffsetCommitGroup}, "q1")
+	qCfg := &queue.QueueConfig{ID: "q1", Name: "q1"}
+	cCfg := newTestConsumerConfig("q1", "g1")
+
+	produce(t, q, qCfg, "", "a", "b", "c", "d")
+	assert.Equal(t, int64(4), q.LatestOffset(qCfg).Position)
+	assert.Equal(t, int64(4), totalLag(t, q, qCfg, "g1"))
+
+	consumer, err := q.AcquireConsumer(qCfg, cCfg)
+	assert.NoError(t, err)
+	defer q.ReleaseConsumer(qCfg, cCfg, consumer)
+
+	messages, _ := fetch(t, consumer, 4)
+	assert.Equal(t, 4, len(messages))
+
+	//only the first message was processed
+	assert.NoError(t, consumer.CommitOffset(messages[0].NextOffset))
+	assert.Equal(t, int64(3), totalLag(t, q, qCfg, "g1"))
+
+	assert.NoError(t, consumer.CommitOffset(messages[3].NextOffset))
+	lags, err := q.GetConsumerLag(qCfg, "g1")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(lags))
+	for _, v := range lags {
+		assert.Equal(t, int64(0), v.Lag)
+	}
+
+	offset, err := q.GetOffset(qCfg, cCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, q.LatestOffset(qCfg), offset)
+}
+
+func TestKafkaTransactionalProduceAndCommit(t *testing.T) {
+	q := newTestKafkaQueue(t, Config{NumOfPartition: 1, Transactional: true, TransactionalIDPrefix: "test-"}, "in", "out")
+	inCfg := &queue.QueueConfig{ID: "in", Name: "in"}
+	outCfg := &queue.QueueConfig{ID: "out", Name: "out"}
+	cCfg := newTestConsumerConfig("in", "g1")
+
+	produce(t, q, inCfg, "", "a", "b", "c")
+
+	consumer, err := q.AcquireConsumer(inCfg, cCfg)
+	assert.NoError(t, err)
+	defer q.ReleaseConsumer(inCfg, cCfg, consumer)
+
+	txn, ok := consumer.(queue.TransactionalConsumerAPI)
+	assert.True(t, ok)
+
+	messages, _ := fetch(t, txn, 3)
+	assert.Equal(t, 3, len(messages))
+
+	//only the offset of the last message can be committed without producing
+	assert.Error(t, consumer.CommitOffset(messages[0].NextOffset))
+
+	reqs := []queue.ProduceRequest{}
+	for _, v := range messages {
+		reqs = append(reqs, queue.ProduceRequest{Topic: "out", Data: append([]byte("processed-"), v.Data...)})
+	}
+	res, err := txn.ProduceAndCommit(&reqs)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(*res))
+	lags, err := q.GetConsumerLag(inCfg, "g1")
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(lags)) {
+		//the offset after the commit marker of the producer is not counted
+		assert.Equal(t, int64(3), lags[0].Committed)
+	}
+
+	//committed messages are visible to the read committed consumers
+	outConsumerCfg := newTestConsumerConfig("out", "g2")
+	outConsumer, err := q.AcquireConsumer(outCfg, outConsumerCfg)
+	assert.NoError(t, err)
+	defer q.ReleaseConsumer(outCfg, outConsumerCfg, outConsumer)
+	messages, _ = fetch(t, outConsumer, 3)
+	data := []string{}
+	for _, v := range messages {
+		data = append(data, string(v.Data))
+	}
+	assert.Equal(t, []string{"processed-a", "processed-b", "processed-c"}, data)
+}
+
+func TestKafkaTopicAdmin(t *testing.T) {
+	q := newTestKafkaQueue(t, Config{NumOfPartition: 1}, "q1")
+	qCfg := &queue.QueueConfig{ID: "q1", Name: "q1"}
+
+	admin, ok := interface{}(q).(queue.TopicAdminAPI)
+	assert.True(t, ok)
+
+	produce(t, q, qCfg, "", "a", "b")
+	partitions, err := admin.GetPartitions(qCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(partitions))
+	assert.Equal(t, int64(0), partitions[0].StartOffset)
+	assert.Equal(t, int64(2), partitions[0].EndOffset)
+
+	assert.NoError(t, admin.SetPartitions(qCfg, 3))
+	assert.Eventually(t, func() bool {
+		partitions, err = admin.GetPartitions(qCfg)
+		return err == nil && len(partitions) == 3
+	}, 5*time.Second, 100*time.Millisecond)
+	assert.Equal(t, int32(2), partitions[2].Partition)
+
+	retentionMs := int64(3600000)
+	retentionBytes := int64(1024 * 1024)
+	assert.NoError(t, admin.UpdateRetention(qCfg, queue.RetentionConfig{RetentionMs: &retentionMs, RetentionBytes: &retentionBytes}))
+	retention, err := admin.GetRetention(qCfg)
+	assert.NoError(t, err)
+	if assert.NotNil(t, retention.RetentionMs) && assert.NotNil(t, retention.RetentionBytes) {
+		assert.Equal(t, retentionMs, *retention.RetentionMs)
+		assert.Equal(t, retentionBytes, *retention.RetentionBytes)
+	}
+
+	lags, err := admin.GetConsumerLag(qCfg, "unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(lags))
+	assert.Equal(t, int64(-1), lags[0].Committed)
+	assert.Equal(t, int64(2), lags[0].Lag)
+}
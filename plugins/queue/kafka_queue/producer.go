@@ -33,6 +33,7 @@ import (
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/queue"
 	"infini.sh/framework/core/util"
+	"sync"
 	"time"
 )
 
@@ -40,6 +41,10 @@ type Producer struct {
 	ID string
 	client *kgo.Client
 	cfg    *queue.QueueConfig
+
+	//every produce request is committed in a kafka transaction
+	transactional bool
+	lock          sync.Mutex
 }
 
 func (p *Producer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceResponse, error) {
@@ -48,28 +53,64 @@ func (p *Producer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceRespon
 		panic(errors.New("invalid request"))
 	}
 
+	messages := toRecords(reqs, p.cfg.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*10))
+	defer cancel()
+
+	if p.transactional {
+		return p.produceInTransaction(ctx, messages)
+	}
+
+	response := p.client.ProduceSync(ctx, messages...)
+	results := toProduceResponses(response)
+	return &results, response.FirstErr()
+}
+
+func (p *Producer) produceInTransaction(ctx context.Context, messages []*kgo.Record) (*[]queue.ProduceResponse, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.client.BeginTransaction(); err != nil {
+		return nil, err
+	}
+
+	response := p.client.ProduceSync(ctx, messages...)
+	err := response.FirstErr()
+	if err != nil {
+		if abortErr := p.client.AbortBufferedRecords(ctx); abortErr != nil {
+			return nil, abortErr
+		}
+	}
+	if endErr := p.client.EndTransaction(ctx, kgo.TransactionEndTry(err == nil)); endErr != nil && err == nil {
+		err = endErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	results := toProduceResponses(response)
+	return &results, nil
+}
+
+func toRecords(reqs *[]queue.ProduceRequest, defaultTopic string) []*kgo.Record {
 	messages := []*kgo.Record{}
 	for _, req := range *reqs {
 		msg := &kgo.Record{}
-		if req.Topic!=""{
-			msg.Topic=req.Topic
-		}else{
-			msg.Topic=p.cfg.ID
+		if req.Topic != "" {
+			msg.Topic = req.Topic
+		} else {
+			msg.Topic = defaultTopic
 		}
 		msg.Timestamp = time.Now()
 		msg.Key = util.UnsafeStringToBytes(util.GetUUID())
 		msg.Value = req.Data
 		messages = append(messages, msg)
 	}
+	return messages
+}
 
+func toProduceResponses(response kgo.ProduceResults) []queue.ProduceResponse {
 	results := []queue.ProduceResponse{}
-
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Second*10))
-	defer cancel()
-
-	response := p.client.ProduceSync(ctx, messages...)
-
 	for _, r := range response {
 		if r.Err == nil {
 			if r.Record != nil {
@@ -82,7 +123,7 @@ func (p *Producer) Produce(reqs *[]queue.ProduceRequest) (*[]queue.ProduceRespon
 			}
 		}
 	}
-	return &results, response.FirstErr()
+	return results
 }
 
 func (p *Producer) Close() error {
@@ -0,0 +1,186 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/util"
+)
+
+// CaptureRecord is one request of the capture file, the capture file is json lines of records
+type CaptureRecord struct {
+	Timestamp time.Time         `json:"timestamp,omitempty"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	//the recorded response, compared with the live response on replay
+	Response *CapturedResponse `json:"response,omitempty"`
+}
+
+type CapturedResponse struct {
+	Host    string            `json:"host,omitempty"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	TookMs  int64             `json:"took_ms"`
+}
+
+// ReadCaptureFile reads the records of the capture file
+func ReadCaptureFile(filename string) ([]CaptureRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []CaptureRecord{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 100*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" || util.PrefixAnyInArray(data, commentMarks) {
+			continue
+		}
+		record := CaptureRecord{}
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, errors.Errorf("invalid capture record at line %v: %v", line, err)
+		}
+		if record.Method == "" || record.Path == "" {
+			return nil, errors.Errorf("invalid capture record at line %v: method and path are required", line)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// isCaptureFile returns true if the first request of the file is a json record
+func isCaptureFile(lines []string) bool {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || util.PrefixAnyInArray(line, commentMarks) {
+			continue
+		}
+		return strings.HasPrefix(line, "{")
+	}
+	return false
+}
+
+// parseTextLines parses the flat text format, `METHOD path` followed by the body lines
+func parseTextLines(lines []string) ([]CaptureRecord, error) {
+	records := []CaptureRecord{}
+	var body []string
+	var current *CaptureRecord
+	flush := func() {
+		if current != nil {
+			current.Body = strings.Join(body, newline)
+			if current.Body != "" && util.ContainStr(current.Path, "_bulk") {
+				current.Body += newline
+			}
+			records = append(records, *current)
+		}
+		body = body[:0]
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || util.PrefixAnyInArray(line, commentMarks) {
+			continue
+		}
+		if util.PrefixAnyInArray(line, validVerbs) {
+			flush()
+			arr := strings.Fields(line)
+			if len(arr) < 2 {
+				return nil, errors.Errorf("request meta is not valid : %v", line)
+			}
+			current = &CaptureRecord{Method: arr[0], Path: arr[1]}
+			continue
+		}
+		if current == nil {
+			return nil, errors.Errorf("request meta is not set, but found body: %v", line)
+		}
+		body = append(body, line)
+	}
+	flush()
+	return records, nil
+}
+
+// CaptureWriter appends the records to the capture file
+type CaptureWriter struct {
+	*jsonLinesWriter
+}
+
+func NewCaptureWriter(filename string) (*CaptureWriter, error) {
+	w, err := newJSONLinesWriter(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w}, nil
+}
+
+func (w *CaptureWriter) Write(record *CaptureRecord) error {
+	return w.write(record)
+}
+
+type jsonLinesWriter struct {
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func newJSONLinesWriter(filename string) (*jsonLinesWriter, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLinesWriter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (w *jsonLinesWriter) write(obj interface{}) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, err := w.writer.Write(util.MustToJSONBytes(obj)); err != nil {
+		return err
+	}
+	return w.writer.WriteByte('\n')
+}
+
+func (w *jsonLinesWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,129 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/buger/jsonparser"
+)
+
+type DiffConfig struct {
+	Enabled bool `config:"enabled"`
+	//compare the status code of the responses
+	Status bool `config:"status"`
+	//json paths of the response body to compare, eg: hits.total.value, hits.hits[0]._id
+	JSONPaths []string `config:"json_paths"`
+	//json lines file of the differences, relative to the data dir
+	Output string `config:"output"`
+}
+
+type ResponseDiff struct {
+	Method      string       `json:"method"`
+	Path        string       `json:"path"`
+	Host        string       `json:"host"`
+	Differences []Difference `json:"differences"`
+}
+
+type Difference struct {
+	Field    string      `json:"field"`
+	Recorded interface{} `json:"recorded"`
+	Live     interface{} `json:"live"`
+}
+
+// compareResponses returns the differences between the recorded and the live response
+func compareResponses(cfg *DiffConfig, recorded, live *CapturedResponse) []Difference {
+	diffs := []Difference{}
+	if cfg.Status && recorded.Status != live.Status {
+		diffs = append(diffs, Difference{Field: "status", Recorded: recorded.Status, Live: live.Status})
+	}
+	for _, path := range cfg.JSONPaths {
+		v1, ok1 := getJSONPath([]byte(recorded.Body), path)
+		v2, ok2 := getJSONPath([]byte(live.Body), path)
+		if ok1 != ok2 || !reflect.DeepEqual(v1, v2) {
+			diffs = append(diffs, Difference{Field: path, Recorded: v1, Live: v2})
+		}
+	}
+	return diffs
+}
+
+// getJSONPath returns the decoded value of the dotted path, the strings are kept apart from the other types,
+// so that "1" doesn't equal to 1, the numbers are compared as written
+func getJSONPath(data []byte, path string) (interface{}, bool) {
+	value, dataType, _, err := jsonparser.Get(data, splitJSONPath(path)...)
+	if err != nil {
+		return nil, false
+	}
+	switch dataType {
+	case jsonparser.String:
+		//strings are returned without quotes
+		v, err := jsonparser.ParseString(value)
+		if err != nil {
+			return string(value), true
+		}
+		return v, true
+	case jsonparser.Number:
+		return json.Number(value), true
+	}
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return string(value), true
+	}
+	return v, true
+}
+
+// splitJSONPath converts `hits.hits[0]._id` to the keys of jsonparser: hits, hits, [0], _id
+func splitJSONPath(path string) []string {
+	keys := []string{}
+	for _, part := range strings.Split(path, ".") {
+		for {
+			i := strings.Index(part, "[")
+			if i <= 0 {
+				break
+			}
+			keys = append(keys, part[:i])
+			part = part[i:]
+			j := strings.Index(part, "]")
+			if j < 0 {
+				break
+			}
+			keys = append(keys, part[:j+1])
+			part = part[j+1:]
+		}
+		if part != "" {
+			keys = append(keys, part)
+		}
+	}
+	return keys
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%v: %v -> %v", d.Field, d.Recorded, d.Live)
+}
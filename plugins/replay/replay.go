@@ -46,13 +46,31 @@ import (
 type Config struct {
 	Schema string `config:"schema"`
 	Host   string `config:"host"`
+	//retarget the requests to the hosts in round robin, override the host
+	Hosts []string `config:"hosts"`
 
 	Filename   string `config:"filename"`
 	InputQueue string `config:"input_queue"`
 	Username   string `config:"username"`
 	Password   string `config:"password"`
+
+	//text or capture, detected by the content of the file if not set
+	Format string `config:"format"`
+	//0 to replay as fast as possible, 1 to replay with the original timing, N to replay N times faster
+	Speed   float64 `config:"speed"`
+	Workers int     `config:"workers"`
+
+	//record the live requests and responses to the capture file
+	OutputCapture string `config:"output_capture"`
+
+	Diff DiffConfig `config:"diff"`
 }
 
+const (
+	FormatText    = "text"
+	FormatCapture = "capture"
+)
+
 type ReplayProcessor struct {
 	config   *Config
 	HTTPPool *fasthttp.RequestResponsePool
@@ -61,14 +79,22 @@ type ReplayProcessor struct {
 var signalChannel = make(chan bool, 1)
 
 func init() {
-	pipeline.RegisterProcessorPlugin("replay", New)
+	pipeline.RegisterProcessorPluginWithConfigMetadata("replay", New, defaultConfig())
 }
 
-func New(c *config.Config) (pipeline.Processor, error) {
-	cfg := Config{
-		Schema: "http",
-		Host:   "localhost:9200",
+func defaultConfig() Config {
+	return Config{
+		Schema:  "http",
+		Host:    "localhost:9200",
+		Workers: 1,
+		Diff: DiffConfig{
+			Status: true,
+		},
 	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
 
 	if err := c.Unpack(&cfg); err != nil {
 		log.Error(err)
@@ -131,44 +157,79 @@ func (processor *ReplayProcessor) Process(ctx *pipeline.Context) error {
 			}
 		}
 	}()
-	var count int
 	time := time2.Now()
 
 	if processor.config.Filename != "" {
 
-		filename := processor.config.Filename
-		if !util.FileExists(filename) && !util.PrefixStr(filename, "/") {
-			filename = path.Join(global.Env().GetDataDir(), filename)
+		filename := getDataPath(processor.config.Filename)
+		records, err := processor.loadRecords(filename)
+		if err != nil {
+			return err
 		}
 
-		lines := util.FileGetLines(filename)
-
-		log.Debugf("get %v lines prepare to replay", len(lines))
-
-		var err error
-		var done bool
+		log.Debugf("get %v requests prepare to replay", len(records))
 
-		req := processor.HTTPPool.AcquireRequest()
-		res := processor.HTTPPool.AcquireResponse()
-
-		defer processor.HTTPPool.ReleaseRequest(req)
-		defer processor.HTTPPool.ReleaseResponse(res)
+		r := &replayer{config: processor.config, hosts: processor.config.Hosts, httpPool: processor.HTTPPool}
+		if len(r.hosts) == 0 {
+			r.hosts = []string{processor.config.Host}
+		}
+		if processor.config.OutputCapture != "" {
+			r.capture, err = NewCaptureWriter(getDataPath(processor.config.OutputCapture))
+			if err != nil {
+				return err
+			}
+			defer r.capture.Close()
+		}
+		if processor.config.Diff.Enabled && processor.config.Diff.Output != "" {
+			r.diffs, err = newJSONLinesWriter(getDataPath(processor.config.Diff.Output))
+			if err != nil {
+				return err
+			}
+			defer r.diffs.Close()
+		}
 
-		count, err, done = ReplayLines(req,res,ctx, lines, processor.config.Schema, processor.config.Host, processor.config.Username, processor.config.Password)
-		if done {
+		report, err := r.run(ctx, records)
+		if err != nil {
 			return err
 		}
+		if ctx.IsCanceled() {
+			return nil
+		}
 
 		progress.Stop()
-	}
 
-	if count > 0 {
-		log.Infof("finished replay [%v] requests, elapsed: %v", count, time2.Since(time).String())
+		if report.Executed > 0 {
+			log.Infof("finished replay [%v] requests, elapsed: %v", report.Executed, time2.Since(time).String())
+		}
+		if processor.config.Diff.Enabled {
+			log.Infof("replay diff of [%v], matched: %v, mismatched: %v", processor.config.Filename, report.Matched, report.Mismatched)
+		}
+		ctx.Set("replay_report", report)
 	}
 
 	return nil
 }
 
+func getDataPath(filename string) string {
+	if !util.FileExists(filename) && !util.PrefixStr(filename, "/") {
+		return path.Join(global.Env().GetDataDir(), filename)
+	}
+	return filename
+}
+
+func (processor *ReplayProcessor) loadRecords(filename string) ([]CaptureRecord, error) {
+	format := processor.config.Format
+	if format == FormatCapture {
+		return ReadCaptureFile(filename)
+	}
+
+	lines := util.FileGetLines(filename)
+	if format == "" && isCaptureFile(lines) {
+		return ReadCaptureFile(filename)
+	}
+	return parseTextLines(lines)
+}
+
 func ReplayLines(req *fasthttp.Request,res *fasthttp.Response,ctx *pipeline.Context, lines []string, schema, host, username, password string) (int, error, bool) {
 
 	var buffer = bytebufferpool.Get("replay")
@@ -0,0 +1,229 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"infini.sh/framework/core/config"
+	"infini.sh/framework/core/pipeline"
+)
+
+func newTestReplay(t *testing.T, cfg map[string]interface{}) *ReplayProcessor {
+	c, err := config.NewConfigFrom(cfg)
+	assert.NoError(t, err)
+	processor, err := New(c)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return processor.(*ReplayProcessor)
+}
+
+func writeCapture(t *testing.T, filename string, records ...CaptureRecord) {
+	w, err := NewCaptureWriter(filename)
+	assert.NoError(t, err)
+	for i := range records {
+		assert.NoError(t, w.Write(&records[i]))
+	}
+	assert.NoError(t, w.Close())
+}
+
+func readDiffs(t *testing.T, filename string) []ResponseDiff {
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	diffs := []ResponseDiff{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		diff := ResponseDiff{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &diff))
+		diffs = append(diffs, diff)
+	}
+	return diffs
+}
+
+func TestReplayCaptureWithDiff(t *testing.T) {
+	var hits [2]int64
+	newServer := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&hits[i], 1)
+			assert.Equal(t, "replay", r.Header.Get("X-Source"))
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(404)
+				w.Write([]byte(`{"found":false}`))
+				return
+			}
+			//the live body is decompressed before compared
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(`{"hits":{"total":{"value":10},"hits":[{"_id":"a"}]}}`))
+			gz.Close()
+		}))
+	}
+	s1, s2 := newServer(0), newServer(1)
+	defer s1.Close()
+	defer s2.Close()
+
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour)
+	headers := map[string]string{"X-Source": "replay"}
+	writeCapture(t, path.Join(dir, "capture.jsonl"),
+		CaptureRecord{Timestamp: base, Method: "GET", Path: "/idx/_search", Headers: headers,
+			Response: &CapturedResponse{Status: 200, Body: `{"hits":{"total":{"value":10},"hits":[{"_id":"a"}]}}`}},
+		CaptureRecord{Timestamp: base.Add(200 * time.Millisecond), Method: "GET", Path: "/idx/_search", Headers: headers,
+			Response: &CapturedResponse{Status: 200, Body: `{"hits":{"total":{"value":9},"hits":[{"_id":"b"}]}}`}},
+		CaptureRecord{Timestamp: base.Add(400 * time.Millisecond), Method: "GET", Path: "/missing", Headers: headers,
+			Response: &CapturedResponse{Status: 200, Body: `{"found":true}`}},
+		CaptureRecord{Timestamp: base.Add(400 * time.Millisecond), Method: "GET", Path: "/missing", Headers: headers,
+			Response: &CapturedResponse{Status: 404, Body: `{"found":false}`}},
+	)
+
+	processor := newTestReplay(t, map[string]interface{}{
+		"filename": path.Join(dir, "capture.jsonl"),
+		"hosts":    []string{strings.TrimPrefix(s1.URL, "http://"), strings.TrimPrefix(s2.URL, "http://")},
+		"speed":    2,
+		"workers":  2,
+		"diff": map[string]interface{}{
+			"enabled":    true,
+			"json_paths": []string{"hits.total.value", "hits.hits[0]._id", "found"},
+			"output":     path.Join(dir, "diff.jsonl"),
+		},
+	})
+
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "replay"})
+	start := time.Now()
+	assert.NoError(t, processor.Process(ctx))
+	//the original timing is 400ms, 2x faster
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	assert.Equal(t, int64(2), hits[0])
+	assert.Equal(t, int64(2), hits[1])
+
+	report, ok := ctx.Get("replay_report").(*ReplayReport)
+	if assert.True(t, ok) {
+		assert.Equal(t, ReplayReport{Executed: 4, Matched: 2, Mismatched: 2}, *report)
+	}
+
+	diffs := readDiffs(t, path.Join(dir, "diff.jsonl"))
+	if assert.Equal(t, 2, len(diffs)) {
+		fields := map[string][]string{}
+		for _, diff := range diffs {
+			for _, v := range diff.Differences {
+				fields[diff.Path] = append(fields[diff.Path], v.Field)
+			}
+		}
+		assert.Equal(t, []string{"hits.total.value", "hits.hits[0]._id"}, fields["/idx/_search"])
+		assert.Equal(t, []string{"status", "found"}, fields["/missing"])
+	}
+}
+
+func TestReplayTextToCapture(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		fmt.Fprintf(w, `{"path":%q}`, r.URL.Path)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	text := "# comment\nGET /_cluster/health\nPOST /_bulk\n{\"index\":{}}\n{\"a\":1}\n"
+	assert.NoError(t, os.WriteFile(path.Join(dir, "requests.txt"), []byte(text), 0644))
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	processor := newTestReplay(t, map[string]interface{}{
+		"filename":       path.Join(dir, "requests.txt"),
+		"host":           host,
+		"output_capture": path.Join(dir, "capture.jsonl"),
+	})
+	assert.NoError(t, processor.Process(pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "replay"})))
+	assert.Equal(t, []string{"", "{\"index\":{}}\n{\"a\":1}\n"}, bodies)
+
+	records, err := ReadCaptureFile(path.Join(dir, "capture.jsonl"))
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(records)) {
+		assert.Equal(t, "/_bulk", records[1].Path)
+		assert.Equal(t, 200, records[1].Response.Status)
+		assert.Equal(t, `{"path":"/_bulk"}`, records[1].Response.Body)
+		assert.False(t, records[1].Timestamp.IsZero())
+	}
+
+	//replay the capture with the format detected
+	processor = newTestReplay(t, map[string]interface{}{
+		"filename": path.Join(dir, "capture.jsonl"),
+		"host":     host,
+		"diff":     map[string]interface{}{"enabled": true, "json_paths": []string{"path"}},
+	})
+	ctx := pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "replay"})
+	assert.NoError(t, processor.Process(ctx))
+	assert.Equal(t, &ReplayReport{Executed: 2, Matched: 2}, ctx.Get("replay_report"))
+}
+
+func TestReplayFailedRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(dir, "requests.txt"), []byte("GET /a\nGET /b\n"), 0644))
+	processor := newTestReplay(t, map[string]interface{}{
+		"filename": path.Join(dir, "requests.txt"),
+		"host":     strings.TrimPrefix(server.URL, "http://"),
+	})
+	err := processor.Process(pipeline.AcquireContext(pipeline.PipelineConfigV2{Name: "replay"}))
+	assert.Error(t, err)
+}
+
+func TestSplitJSONPath(t *testing.T) {
+	assert.Equal(t, []string{"hits", "hits", "[0]", "_id"}, splitJSONPath("hits.hits[0]._id"))
+	assert.Equal(t, []string{"a", "[1]", "[2]"}, splitJSONPath("a[1][2]"))
+	assert.Equal(t, []string{"[0]", "a"}, splitJSONPath("[0].a"))
+}
+
+func TestCompareResponses(t *testing.T) {
+	cfg := &DiffConfig{JSONPaths: []string{"a", "b", "c", "d"}}
+	recorded := &CapturedResponse{Body: `{"a":"123","b":12345678901234567890,"c":{"x":[1,"2"]},"d":"x\u0041"}`}
+
+	assert.Equal(t, 0, len(compareResponses(cfg, recorded, recorded)))
+
+	live := &CapturedResponse{Body: `{"a":123,"b":12345678901234567891,"c":{"x":[1,2]},"d":"xA"}`}
+	fields := []string{}
+	for _, v := range compareResponses(cfg, recorded, live) {
+		fields = append(fields, v.Field)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, fields)
+}
@@ -0,0 +1,252 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package replay
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/pipeline"
+	"infini.sh/framework/core/stats"
+	"infini.sh/framework/lib/fasthttp"
+)
+
+// replayer fires the records to the hosts, with the original timing if speed is set
+type replayer struct {
+	config   *Config
+	hosts    []string
+	next     uint64
+	httpPool *fasthttp.RequestResponsePool
+
+	capture *CaptureWriter
+	diffs   *jsonLinesWriter
+
+	executed   int64
+	matched    int64
+	mismatched int64
+}
+
+// ReplayReport is the summary of the replay
+type ReplayReport struct {
+	Executed   int64 `json:"executed"`
+	Matched    int64 `json:"matched"`
+	Mismatched int64 `json:"mismatched"`
+}
+
+func (r *replayer) nextHost() string {
+	i := atomic.AddUint64(&r.next, 1) - 1
+	return r.hosts[i%uint64(len(r.hosts))]
+}
+
+func (r *replayer) run(ctx *pipeline.Context, records []CaptureRecord) (*ReplayReport, error) {
+	workers := r.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	tasks := make(chan *CaptureRecord, workers)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		stopOnce.Do(func() {
+			firstErr = err
+			close(stop)
+		})
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for record := range tasks {
+				if err := r.replay(record); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	var base time.Time
+dispatch:
+	for i := range records {
+		record := &records[i]
+		if r.config.Speed > 0 && !record.Timestamp.IsZero() {
+			if base.IsZero() {
+				base = record.Timestamp
+			}
+			//keep the original inter-arrival time, scaled by the speed
+			due := start.Add(time.Duration(float64(record.Timestamp.Sub(base)) / r.config.Speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-stop:
+					break dispatch
+				case <-ctx.Done():
+					break dispatch
+				}
+			}
+		}
+
+		if global.ShuttingDown() {
+			break
+		}
+
+		select {
+		case tasks <- record:
+		case <-stop:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(tasks)
+	wg.Wait()
+
+	report := &ReplayReport{
+		Executed:   atomic.LoadInt64(&r.executed),
+		Matched:    atomic.LoadInt64(&r.matched),
+		Mismatched: atomic.LoadInt64(&r.mismatched),
+	}
+	return report, firstErr
+}
+
+func (r *replayer) replay(record *CaptureRecord) error {
+	live, err := r.execute(record)
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&r.executed, 1)
+
+	if r.capture != nil {
+		captured := *record
+		captured.Timestamp = time.Now()
+		captured.Response = live
+		if err := r.capture.Write(&captured); err != nil {
+			return err
+		}
+	}
+
+	if record.Response == nil {
+		//no recorded response, only the failed requests are reported
+		if live.Status > 210 {
+			method := strings.ToUpper(record.Method)
+			if live.Status != 404 || method != http.MethodDelete {
+				return fmt.Errorf("%s %s \n%s", method, record.Path, live.Body)
+			}
+		}
+		return nil
+	}
+
+	if !r.config.Diff.Enabled {
+		return nil
+	}
+
+	diffs := compareResponses(&r.config.Diff, record.Response, live)
+	if len(diffs) == 0 {
+		atomic.AddInt64(&r.matched, 1)
+		stats.Increment("replay", "matched")
+		return nil
+	}
+
+	atomic.AddInt64(&r.mismatched, 1)
+	stats.Increment("replay", "mismatched")
+	if global.Env().IsDebug {
+		log.Debugf("response of [%v %v] on [%v] mismatched: %v", record.Method, record.Path, live.Host, diffs)
+	}
+	if r.diffs != nil {
+		return r.diffs.write(ResponseDiff{Method: record.Method, Path: record.Path, Host: live.Host, Differences: diffs})
+	}
+	return nil
+}
+
+func (r *replayer) execute(record *CaptureRecord) (*CapturedResponse, error) {
+	req := r.httpPool.AcquireRequest()
+	res := r.httpPool.AcquireResponse()
+	defer r.httpPool.ReleaseRequest(req)
+	defer r.httpPool.ReleaseResponse(res)
+
+	host := r.nextHost()
+	req.SetRequestURI(record.Path)
+	clonedURI := req.CloneURI()
+	clonedURI.SetScheme(r.config.Schema)
+	clonedURI.SetHost(host)
+	req.SetURI(clonedURI)
+	fasthttp.ReleaseURI(clonedURI)
+	req.Header.SetMethod(record.Method)
+	req.SetHost(host)
+
+	for k, v := range record.Headers {
+		if strings.EqualFold(k, "Host") || strings.EqualFold(k, "Content-Length") {
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	if r.config.Username != "" && r.config.Password != "" {
+		req.SetBasicAuth(r.config.Username, r.config.Password)
+	}
+	if record.Body != "" {
+		req.SetBodyString(record.Body)
+	}
+
+	if global.Env().IsDebug {
+		log.Trace(req.String())
+	}
+
+	start := time.Now()
+	err := fastHttpClient.Do(req, res)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s on [%v]: %v", record.Method, record.Path, host, err)
+	}
+
+	took := time.Since(start)
+	//compared with the recorded body, which is not compressed
+	body, err := res.BodyUncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s on [%v], failed to decode the body: %v", record.Method, record.Path, host, err)
+	}
+	live := &CapturedResponse{
+		Host:    host,
+		Status:  res.StatusCode(),
+		Headers: map[string]string{},
+		Body:    string(body),
+		TookMs:  took.Milliseconds(),
+	}
+	res.Header.VisitAll(func(key, value []byte) {
+		live.Headers[string(key)] = string(value)
+	})
+
+	if global.Env().IsDebug {
+		log.Trace(live.Body)
+	}
+	return live, nil
+}
@@ -28,6 +28,7 @@
 package simple_kv
 
 import (
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/filter"
 	"infini.sh/framework/core/global"
@@ -53,6 +54,10 @@ func (module *SimpleKV) Name() string {
 	return "simple_kv"
 }
 
+func init() {
+	config.RegisterSectionSchema("simple_kv", &Config{Enabled: true})
+}
+
 func (module *SimpleKV) Setup() {
 	module.cfg = &Config{
 		Enabled:                 true,
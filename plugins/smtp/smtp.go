@@ -29,15 +29,14 @@ import (
 	"fmt"
 	log "github.com/cihub/seelog"
 	"github.com/gopkg.in/gomail.v2"
-	"github.com/valyala/fasttemplate"
 	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/errors"
 	"infini.sh/framework/core/global"
+	"infini.sh/framework/core/notification"
 	"infini.sh/framework/core/param"
 	"infini.sh/framework/core/pipeline"
 	"infini.sh/framework/core/queue"
 	"infini.sh/framework/core/util"
-	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -53,15 +52,14 @@ func (processor *SMTPProcessor) Name() string {
 }
 
 type Template struct {
-	ContentType       string       `config:"content_type"` //text or html
-	Subject           string       `config:"subject"`
-	Body              string       `config:"body"`
-	BodyFile          string       `config:"body_file"` //use file to store template
-	Attachments       []Attachment `config:"attachments"`
-	variableInSubject bool         //this template contains variable
-	variableInBody    bool         //this template contains variable
-	bodyTemplate      *fasttemplate.Template
-	subjectTemplate   *fasttemplate.Template
+	ContentType string       `config:"content_type"` //text or html
+	Subject     string       `config:"subject"`
+	Body        string       `config:"body"`
+	BodyFile    string       `config:"body_file"` //use file to store template
+	Attachments []Attachment `config:"attachments"`
+	//rendered by the templates shared with the notification channels, the missing variables are kept as is
+	bodyTemplate    *notification.Template
+	subjectTemplate *notification.Template
 }
 
 type Config struct {
@@ -105,16 +103,20 @@ type Attachment struct {
 }
 
 func init() {
-	pipeline.RegisterProcessorPlugin("smtp", New)
+	pipeline.RegisterProcessorPluginWithConfigMetadata("smtp", New, defaultConfig())
 }
 
-func New(c *config.Config) (pipeline.Processor, error) {
-	cfg := Config{
+func defaultConfig() Config {
+	return Config{
 		DialTimeoutInSeconds: 30,
 		VariableStartTag:     "$[[",
 		VariableEndTag:       "]]",
 		MessageField:         "messages",
 	}
+}
+
+func New(c *config.Config) (pipeline.Processor, error) {
+	cfg := defaultConfig()
 
 	if err := c.Unpack(&cfg); err != nil {
 		return nil, fmt.Errorf("failed to unpack the configuration of index_diff processor: %s", err)
@@ -145,22 +147,13 @@ func New(c *config.Config) (pipeline.Processor, error) {
 		}
 	}
 
-	for _, v := range processor.config.Templates {
-		if util.ContainStr(v.Body, processor.config.VariableStartTag) {
-			v.variableInBody = true
-			template, err := fasttemplate.NewTemplate(v.Body, processor.config.VariableStartTag, processor.config.VariableEndTag)
-			if err != nil {
-				panic(err)
-			}
-			v.bodyTemplate = template
+	for name, v := range processor.config.Templates {
+		var err error
+		if v.bodyTemplate, err = notification.NewTemplate(v.Body, processor.config.VariableStartTag, processor.config.VariableEndTag); err != nil {
+			return nil, errors.Errorf("invalid body of template [%v]: %v", name, err)
 		}
-		if util.ContainStr(v.Subject, processor.config.VariableStartTag) {
-			v.variableInSubject = true
-			template, err := fasttemplate.NewTemplate(v.Subject, processor.config.VariableStartTag, processor.config.VariableEndTag)
-			if err != nil {
-				panic(err)
-			}
-			v.subjectTemplate = template
+		if v.subjectTemplate, err = notification.NewTemplate(v.Subject, processor.config.VariableStartTag, processor.config.VariableEndTag); err != nil {
+			return nil, errors.Errorf("invalid subject of template [%v]: %v", name, err)
 		}
 	}
 
@@ -243,7 +236,6 @@ func (processor *SMTPProcessor) Process(ctx *pipeline.Context) error {
 			if !ok {
 				panic(errors.Errorf("template [%v] not found", tpName))
 			}
-			subj := tmplate.Subject
 			ctype := tmplate.ContentType
 			if contentType, ok := vars["content_type"].(string); ok && contentType != "" {
 				ctype = contentType
@@ -261,37 +253,13 @@ func (processor *SMTPProcessor) Process(ctx *pipeline.Context) error {
 				}
 			}
 
-			cBody := tmplate.Body
-
 			myctx := util.MapStr{}
 			myctx.Merge(processor.config.Variables)
 			myctx.Merge(vars)
 
 			//render template
-			if tmplate.variableInSubject && tmplate.subjectTemplate != nil {
-				subj = tmplate.subjectTemplate.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
-					variable, err := myctx.GetValue(tag)
-					x, ok := variable.(string)
-					if ok {
-						if x != "" {
-							return w.Write([]byte(x))
-						}
-					}
-					return -1, err
-				})
-			}
-			if tmplate.variableInBody && tmplate.bodyTemplate != nil {
-				cBody = tmplate.bodyTemplate.ExecuteFuncString(func(w io.Writer, tag string) (int, error) {
-					variable, err := myctx.GetValue(tag)
-					x, ok := variable.(string)
-					if ok {
-						if x != "" {
-							return w.Write([]byte(x))
-						}
-					}
-					return -1, err
-				})
-			}
+			subj := tmplate.subjectTemplate.Render(myctx)
+			cBody := tmplate.bodyTemplate.Render(myctx)
 
 			//send email
 			err = processor.send(srvCfg, sendTo, cc, subj, ctype, cBody, tmplate.Attachments)
@@ -0,0 +1,342 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package statsd
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"infini.sh/framework/core/rate"
+)
+
+const (
+	counterType   = "c"
+	gaugeType     = "g"
+	timerType     = "ms"
+	histogramType = "h"
+)
+
+type metricKey struct {
+	name  string
+	tags  string
+	mType string
+}
+
+// metric is aggregated within the flush interval, the counters are summed, the last value of the gauges is kept,
+// and the values of the timers and histograms are sent in one packet, at most max samples are kept
+type metric struct {
+	value  int64
+	values []int64
+	//num of the values recorded, the sample rate is scaled by the kept values
+	seen int64
+}
+
+// client aggregates the metrics and flushes them to the statsd agent periodically, or once the buffer is full
+type client struct {
+	config    *StatsDConfig
+	transport *transport
+	tagger    *tagger
+
+	lock    sync.Mutex
+	metrics map[metricKey]*metric
+	random  func() float64
+
+	flushC chan struct{}
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newClient(config *StatsDConfig, transport *transport, labels map[string]string) *client {
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &client{
+		config:    config,
+		transport: transport,
+		tagger:    newTagger(config, labels),
+		metrics:   map[metricKey]*metric{},
+		random:    random.Float64,
+		flushC:    make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+	}
+}
+
+func (c *client) start() {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(time.Second * time.Duration(c.config.IntervalInSeconds))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.flushC:
+			case <-c.quit:
+				c.flush()
+				return
+			}
+			c.flush()
+		}
+	}()
+}
+
+func (c *client) close() error {
+	close(c.quit)
+	c.wg.Wait()
+	return c.transport.Close()
+}
+
+func (c *client) record(mType, category, key string, v int64) {
+	sampled := mType == timerType || mType == histogramType
+	name, tags := c.tagger.resolve(category, key)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	//the counters and gauges are aggregated, so only the timers and histograms are sampled
+	if sampled && c.config.SampleRate < 1 && c.random() >= c.config.SampleRate {
+		return
+	}
+
+	k := metricKey{name: name, tags: tags, mType: mType}
+	m, ok := c.metrics[k]
+	if !ok {
+		m = &metric{}
+		c.metrics[k] = m
+	}
+	switch mType {
+	case counterType:
+		m.value += v
+	case gaugeType:
+		m.value = v
+	default:
+		m.seen++
+		if len(m.values) < c.config.MaxSamples {
+			m.values = append(m.values, v)
+		} else if i := int64(c.random() * float64(m.seen)); i < int64(len(m.values)) {
+			m.values[i] = v
+		}
+	}
+
+	if len(c.metrics) >= c.config.BufferSize || len(m.values) >= c.config.MaxSamples {
+		select {
+		case c.flushC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *client) flush() {
+	c.lock.Lock()
+	if len(c.metrics) == 0 {
+		c.lock.Unlock()
+		return
+	}
+	metrics := c.metrics
+	c.metrics = map[metricKey]*metric{}
+	c.lock.Unlock()
+
+	keys := make([]metricKey, 0, len(metrics))
+	for k := range metrics {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		if keys[i].mType != keys[j].mType {
+			return keys[i].mType < keys[j].mType
+		}
+		return keys[i].tags < keys[j].tags
+	})
+
+	packet := bytes.Buffer{}
+	for _, k := range keys {
+		m := metrics[k]
+		if m.values == nil {
+			//a signed gauge is a delta to the current value, so the negative gauge is reset to 0 first
+			if k.mType == gaugeType && m.value < 0 {
+				c.write(&packet, c.format(k, 0, 1))
+			}
+			c.write(&packet, c.format(k, m.value, 1))
+			continue
+		}
+		sampleRate := c.config.SampleRate * float64(len(m.values)) / float64(m.seen)
+		for _, v := range m.values {
+			c.write(&packet, c.format(k, v, sampleRate))
+		}
+	}
+	c.send(&packet)
+}
+
+// format returns the line of the metric, eg: `app.queue.push:3|c|@0.5|#env:prod,queue:orders`
+func (c *client) format(k metricKey, v int64, sampleRate float64) []byte {
+	line := make([]byte, 0, len(k.name)+len(k.tags)+24)
+	line = append(line, k.name...)
+	line = append(line, ':')
+	line = strconv.AppendInt(line, v, 10)
+	line = append(line, '|')
+	line = append(line, k.mType...)
+	if sampleRate < 1 {
+		line = append(line, "|@"...)
+		line = strconv.AppendFloat(line, math.Round(sampleRate*1e6)/1e6, 'f', -1, 64)
+	}
+	if k.tags != "" {
+		line = append(line, "|#"...)
+		line = append(line, k.tags...)
+	}
+	return line
+}
+
+// write packs the lines into the packet, the packet is sent before it exceeds the max packet size
+func (c *client) write(packet *bytes.Buffer, line []byte) {
+	if packet.Len() > 0 && packet.Len()+1+len(line) > c.config.MaxPacketSize {
+		c.send(packet)
+	}
+	if packet.Len() > 0 {
+		packet.WriteByte('\n')
+	}
+	packet.Write(line)
+}
+
+// send drops the packet if it failed to send, so that the metrics don't pile up while the agent is unavailable
+func (c *client) send(packet *bytes.Buffer) {
+	if packet.Len() == 0 {
+		return
+	}
+	if err := c.transport.Write(packet.Bytes()); err != nil {
+		if rate.GetRateLimiterPerSecond("statsd", "send_error", 1).Allow() {
+			log.Warnf("failed to send metrics to statsd, dropped: %v", err)
+		}
+	}
+	packet.Reset()
+}
+
+// KeyTagRule extracts the segments of the keys to tags, eg: the rule of category `queue` with segments `[queue, ""]`,
+// sends the key `orders.push` as the metric `queue.push` with the tag `queue:orders`,
+// the segment is kept in the name if the tag is empty, the rule is skipped if the key has less segments
+type KeyTagRule struct {
+	Category string   `config:"category"`
+	Segments []string `config:"segments"`
+}
+
+// tagger resolves the name and tags of the metrics, the tags are sent in the DogStatsD format
+type tagger struct {
+	namespace string
+	dogstatsd bool
+	tags      []string
+	rules     []KeyTagRule
+	cache     sync.Map
+	cached    int64
+}
+
+// maxCachedKeys bounds the cache of the tagger, which is cleared once full, as the keys may have unbounded
+// segments, eg: the ids
+const maxCachedKeys = 10000
+
+type resolved struct {
+	name string
+	tags string
+}
+
+func newTagger(config *StatsDConfig, labels map[string]string) *tagger {
+	t := &tagger{namespace: config.Namespace, dogstatsd: config.DogStatsD, rules: config.KeyTags}
+	tags := map[string]string{}
+	for k, v := range labels {
+		tags[k] = v
+	}
+	//the static tags override the node labels
+	for k, v := range config.Tags {
+		tags[k] = v
+	}
+	for k, v := range tags {
+		t.tags = append(t.tags, sanitizeTag(k)+":"+sanitizeTag(v))
+	}
+	sort.Strings(t.tags)
+	return t
+}
+
+func (t *tagger) resolve(category, key string) (string, string) {
+	cacheKey := category + "\x00" + key
+	if v, ok := t.cache.Load(cacheKey); ok {
+		r := v.(resolved)
+		return r.name, r.tags
+	}
+
+	segments := strings.Split(key, ".")
+	tags := t.tags
+	if t.dogstatsd {
+		for _, rule := range t.rules {
+			if rule.Category != "" && rule.Category != category || len(segments) < len(rule.Segments) {
+				continue
+			}
+			tags = append([]string{}, t.tags...)
+			kept := []string{}
+			for i, segment := range segments {
+				if i < len(rule.Segments) && rule.Segments[i] != "" {
+					tags = append(tags, sanitizeTag(rule.Segments[i])+":"+sanitizeTag(segment))
+					continue
+				}
+				kept = append(kept, segment)
+			}
+			segments = kept
+			sort.Strings(tags)
+			break
+		}
+	}
+
+	r := resolved{name: sanitizeName(t.namespace + strings.Join(append([]string{category}, segments...), "."))}
+	if t.dogstatsd {
+		r.tags = strings.Join(tags, ",")
+	}
+	if _, loaded := t.cache.LoadOrStore(cacheKey, r); !loaded && atomic.AddInt64(&t.cached, 1) > maxCachedKeys {
+		t.clear()
+	}
+	return r.name, r.tags
+}
+
+func (t *tagger) clear() {
+	t.cache.Range(func(k, v interface{}) bool {
+		t.cache.Delete(k)
+		return true
+	})
+	atomic.StoreInt64(&t.cached, 0)
+}
+
+var nameReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", " ", "_", "\n", "_")
+var tagReplacer = strings.NewReplacer(":", "_", "|", "_", ",", "_", "#", "_", " ", "_", "\n", "_")
+
+func sanitizeName(name string) string {
+	return nameReplacer.Replace(name)
+}
+
+func sanitizeTag(tag string) string {
+	return tagReplacer.Replace(tag)
+}
@@ -25,36 +25,53 @@ package statsd
 
 import (
 	"fmt"
+	"sync"
+	"time"
+
 	log "github.com/cihub/seelog"
-	"github.com/quipo/statsd"
+	"infini.sh/framework/core/config"
 	"infini.sh/framework/core/env"
 	"infini.sh/framework/core/errors"
+	"infini.sh/framework/core/global"
 	"infini.sh/framework/core/stats"
-	"sync"
-	"time"
 )
 
 type StatsDConfig struct {
-	Enabled           bool          `config:"enabled"`
-	Host              string        `config:"host"`
-	Port              int           `config:"port"`
-	Namespace         string        `config:"namespace"`
-	Protocol          string        `config:"protocol"`
+	Enabled   bool   `config:"enabled"`
+	Host      string `config:"host"`
+	Port      int    `config:"port"`
+	Namespace string `config:"namespace"`
+	//udp, tcp, unix or unixgram
+	Protocol string `config:"protocol"`
+	//path of the unix domain socket
+	Path string `config:"path"`
+	//interval to flush the aggregated metrics
 	IntervalInSeconds int `config:"interval_in_seconds"`
-	BufferSize        int           `config:"buffer_size"`
+	//flush before the interval once the distinct metrics reach the buffer size
+	BufferSize int `config:"buffer_size"`
+	//max bytes of the packet, default to 1432 for udp, and 8192 for the others
+	MaxPacketSize int `config:"max_packet_size"`
+	//sample rate of the timers and histograms
+	SampleRate float64 `config:"sample_rate"`
+	//max samples of each timer or histogram within the flush interval, the samples are kept by reservoir sampling beyond it
+	MaxSamples int `config:"max_samples"`
+
+	//send the tags in DogStatsD format, the tags are made of the node labels, the static tags and the segments of keys
+	DogStatsD  bool              `config:"dogstatsd"`
+	NodeLabels bool              `config:"node_labels"`
+	Tags       map[string]string `config:"tags"`
+	KeyTags    []KeyTagRule      `config:"key_tags"`
 }
+
 type StatsDModule struct {
-	 statsdInited bool
-	 statsdclient *statsd.StatsdClient
-	 buffer *statsd.StatsdBuffer
-	 l1 sync.RWMutex
+	statsdInited bool
+	client       *client
+	l1           sync.RWMutex
 }
 
-
 func (module *StatsDModule) Setup() {
 }
 
-
 var defaultStatsdConfig = StatsDConfig{
 	Enabled:           false,
 	Host:              "localhost",
@@ -62,6 +79,14 @@ var defaultStatsdConfig = StatsDConfig{
 	Namespace:         "app.",
 	Protocol:          "udp",
 	IntervalInSeconds: 1,
+	BufferSize:        1000,
+	SampleRate:        1,
+	MaxSamples:        1000,
+	NodeLabels:        true,
+}
+
+func init() {
+	config.RegisterSectionSchema("statsd", defaultStatsdConfig)
 }
 
 func (module *StatsDModule) Name() string {
@@ -74,36 +99,50 @@ func (module *StatsDModule) Start() error {
 	}
 
 	config := defaultStatsdConfig
-	//cfg.Unpack(&config)
 	env.ParseConfig("statsd", &config)
 	if !config.Enabled {
 		return nil
 	}
 
 	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	module.l1.Lock()
-	defer module.l1.Unlock()
-	module.statsdclient = statsd.NewStatsdClient(addr, config.Namespace)
-
-	log.Debug("statsd connec to, ", addr, ",prefix:", config.Namespace)
-
-	var err error
-	if config.Protocol == "tcp" {
-		err = module.statsdclient.CreateTCPSocket()
-	} else {
-		err = module.statsdclient.CreateSocket()
+	if config.Protocol == "unix" || config.Protocol == "unixgram" {
+		addr = config.Path
+	}
+	if config.IntervalInSeconds <= 0 {
+		config.IntervalInSeconds = 1
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1000
+	}
+	if config.MaxPacketSize <= 0 {
+		config.MaxPacketSize = 8192
+		if config.Protocol == "udp" {
+			config.MaxPacketSize = 1432
+		}
+	}
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		config.SampleRate = 1
 	}
-	if nil != err {
-		log.Warn(err)
+	if config.MaxSamples <= 0 {
+		config.MaxSamples = 1000
+	}
+
+	transport, err := newTransport(config.Protocol, addr)
+	if err != nil {
 		return err
 	}
 
-	interval := time.Second * time.Duration(config.IntervalInSeconds) // aggregate stats and flush every 2 seconds
-	if config.BufferSize<=0{
-		config.BufferSize=100
+	var labels map[string]string
+	if config.NodeLabels && global.Env().SystemConfig != nil {
+		labels = global.Env().SystemConfig.NodeConfig.Labels
 	}
-	module.buffer = statsd.NewStatsdBuffer(interval,config.BufferSize, module.statsdclient)
 
+	log.Debug("statsd connect to, ", config.Protocol, "://", addr, ",prefix:", config.Namespace)
+
+	module.l1.Lock()
+	defer module.l1.Unlock()
+	module.client = newClient(&config, transport, labels)
+	module.client.start()
 	module.statsdInited = true
 
 	stats.Register(module)
@@ -111,30 +150,36 @@ func (module *StatsDModule) Start() error {
 }
 
 func (module *StatsDModule) Stop() error {
-	if module.statsdclient != nil {
-		module.statsdclient.Close()
+	module.l1.Lock()
+	defer module.l1.Unlock()
+	if module.client != nil {
+		module.statsdInited = false
+		err := module.client.close()
+		module.client = nil
+		return err
 	}
 	return nil
 }
 
-func (module *StatsDModule) Absolute(category, key string, value int64) {
-
+func (module *StatsDModule) record(mType, category, key string, value int64) {
+	module.l1.RLock()
+	defer module.l1.RUnlock()
 	if !module.statsdInited {
 		return
 	}
-	module.buffer.Absolute(category+"."+key, value)
+	module.client.record(mType, category, key, value)
 }
 
-func (module *StatsDModule) Increment(category, key string) {
+func (module *StatsDModule) Absolute(category, key string, value int64) {
+	module.record(gaugeType, category, key, value)
+}
 
+func (module *StatsDModule) Increment(category, key string) {
 	module.IncrementBy(category, key, 1)
 }
 
 func (module *StatsDModule) IncrementBy(category, key string, value int64) {
-	if !module.statsdInited {
-		return
-	}
-	module.buffer.Incr(category+"."+key, value)
+	module.record(counterType, category, key, value)
 }
 
 func (module *StatsDModule) Decrement(category, key string) {
@@ -142,21 +187,18 @@ func (module *StatsDModule) Decrement(category, key string) {
 }
 
 func (module *StatsDModule) DecrementBy(category, key string, value int64) {
-	if !module.statsdInited {
-		return
-	}
-	module.buffer.Decr(category+"."+key, value)
+	module.record(counterType, category, key, -value)
 }
 
 func (module *StatsDModule) Timing(category, key string, v int64) {
-	if !module.statsdInited {
-		return
-	}
-	module.buffer.Timing(category+"."+key, v)
+	module.record(timerType, category, key, v)
+}
 
+func (module *StatsDModule) Histogram(category, key string, v int64) {
+	module.record(histogramType, category, key, v)
 }
 
-func (module *StatsDModule) GetTimestamp(category, key string)(time.Time, error) {
+func (module *StatsDModule) GetTimestamp(category, key string) (time.Time, error) {
 	return time.Now(), errors.New("not support")
 }
 
@@ -165,10 +207,7 @@ func (module *StatsDModule) RecordTimestamp(category, key string, value time.Tim
 }
 
 func (module *StatsDModule) Gauge(category, key string, v int64) {
-	if !module.statsdInited {
-		return
-	}
-	module.buffer.Gauge(category+"."+key, v)
+	module.record(gaugeType, category, key, v)
 }
 
 func (module *StatsDModule) Stat(category, key string) int64 {
@@ -0,0 +1,199 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package statsd
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestConfig(protocol string) *StatsDConfig {
+	config := defaultStatsdConfig
+	config.Protocol = protocol
+	config.IntervalInSeconds = 3600
+	config.MaxPacketSize = 1432
+	return &config
+}
+
+func readPacket(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, 65536)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err)
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestAggregateWithTags(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	config := newTestConfig("udp")
+	config.DogStatsD = true
+	config.Tags = map[string]string{"env": "test"}
+	config.KeyTags = []KeyTagRule{{Category: "queue", Segments: []string{"queue", ""}}}
+	transport, err := newTransport("udp", conn.LocalAddr().String())
+	assert.NoError(t, err)
+	c := newClient(config, transport, map[string]string{"region": "us", "env": "node"})
+
+	for i := 0; i < 3; i++ {
+		c.record(counterType, "queue", "orders.push", 2)
+	}
+	c.record(counterType, "queue", "orders.push", -1)
+	c.record(counterType, "queue", "users.push", 1)
+	c.record(gaugeType, "queue", "orders.depth", 10)
+	c.record(gaugeType, "queue", "orders.depth", 7)
+	c.record(gaugeType, "queue", "orders.lag", -3)
+	c.record(timerType, "http", "latency", 12)
+	c.record(timerType, "http", "latency", 15)
+	c.record(histogramType, "http", "size", 100)
+	c.flush()
+
+	assert.Equal(t, []string{
+		"app.http.latency:12|ms|#env:test,region:us",
+		"app.http.latency:15|ms|#env:test,region:us",
+		"app.http.size:100|h|#env:test,region:us",
+		"app.queue.depth:7|g|#env:test,queue:orders,region:us",
+		"app.queue.lag:0|g|#env:test,queue:orders,region:us",
+		"app.queue.lag:-3|g|#env:test,queue:orders,region:us",
+		"app.queue.push:5|c|#env:test,queue:orders,region:us",
+		"app.queue.push:1|c|#env:test,queue:users,region:us",
+	}, readPacket(t, conn))
+
+	//the lines are split to packets by the max packet size
+	config.MaxPacketSize = 64
+	c.record(counterType, "queue", "orders.push", 1)
+	c.record(counterType, "queue", "users.push", 1)
+	c.flush()
+	assert.Equal(t, []string{"app.queue.push:1|c|#env:test,queue:orders,region:us"}, readPacket(t, conn))
+	assert.Equal(t, []string{"app.queue.push:1|c|#env:test,queue:users,region:us"}, readPacket(t, conn))
+	assert.NoError(t, c.close())
+}
+
+func TestStreamReconnectAndSampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "statsd.sock")
+	config := newTestConfig("unix")
+	config.SampleRate = 0.5
+	transport, err := newTransport("unix", path)
+	assert.NoError(t, err)
+	c := newClient(config, transport, nil)
+	samples := []float64{0.1, 0.9, 0.3}
+	c.random = func() float64 {
+		v := samples[0]
+		samples = samples[1:]
+		return v
+	}
+
+	//the agent is not listening, the metrics are dropped
+	c.record(counterType, "http", "requests", 1)
+	c.flush()
+	assert.Nil(t, transport.conn)
+	assert.True(t, transport.nextDial.After(time.Now()))
+
+	listener, err := net.Listen("unix", path)
+	assert.NoError(t, err)
+	defer listener.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	//redial after the backoff
+	transport.nextDial = time.Time{}
+	c.record(counterType, "http", "requests", 2)
+	for _, v := range []int64{10, 20, 30} {
+		c.record(timerType, "http", "latency", v)
+	}
+	c.flush()
+
+	received := []string{}
+	for i := 0; i < 3; i++ {
+		select {
+		case line := <-lines:
+			received = append(received, line)
+		case <-time.After(5 * time.Second):
+			t.Fatal("metrics not received")
+		}
+	}
+	sort.Strings(received)
+	assert.Equal(t, []string{"app.http.latency:10|ms|@0.5", "app.http.latency:30|ms|@0.5", "app.http.requests:2|c"}, received)
+	assert.NoError(t, c.close())
+}
+
+func TestBoundedSamples(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	config := newTestConfig("udp")
+	config.MaxSamples = 2
+	transport, err := newTransport("udp", conn.LocalAddr().String())
+	assert.NoError(t, err)
+	c := newClient(config, transport, nil)
+	samples := []float64{0.1, 0.9}
+	c.random = func() float64 {
+		v := samples[0]
+		samples = samples[1:]
+		return v
+	}
+
+	//the samples beyond the max samples replace the kept ones randomly, and the sample rate is scaled
+	for _, v := range []int64{10, 20, 30, 40} {
+		c.record(timerType, "http", "latency", v)
+	}
+	assert.Equal(t, 1, len(c.flushC))
+	c.flush()
+	assert.Equal(t, []string{"app.http.latency:30|ms|@0.5", "app.http.latency:20|ms|@0.5"}, readPacket(t, conn))
+	assert.NoError(t, c.close())
+}
+
+func TestBoundedTaggerCache(t *testing.T) {
+	tagger := newTagger(newTestConfig("udp"), nil)
+	for i := 0; i < maxCachedKeys; i++ {
+		tagger.resolve("queue", fmt.Sprintf("%v.push", i))
+	}
+	assert.Equal(t, int64(maxCachedKeys), tagger.cached)
+	//cleared once full
+	name, _ := tagger.resolve("queue", "orders.push")
+	assert.Equal(t, "app.queue.orders.push", name)
+	assert.Equal(t, int64(0), tagger.cached)
+	_, ok := tagger.cache.Load("queue\x00orders.push")
+	assert.False(t, ok)
+}
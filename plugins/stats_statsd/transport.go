@@ -0,0 +1,111 @@
+// Copyright (C) INFINI Labs & INFINI LIMITED.
+//
+// The INFINI Framework is offered under the GNU Affero General Public License v3.0
+// and as commercial software.
+//
+// For commercial licensing, contact us at:
+//   - Website: infinilabs.com
+//   - Email: hello@infini.ltd
+//
+// Open Source licensed under AGPL V3:
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package statsd
+
+import (
+	"net"
+	"time"
+
+	"infini.sh/framework/core/errors"
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 5 * time.Second
+	minBackoff   = 100 * time.Millisecond
+	maxBackoff   = 30 * time.Second
+)
+
+// transport writes the packets to the statsd agent by udp, tcp, unix or unixgram,
+// the connection is dialed on demand, and redialed with backoff after failures,
+// it is used by the flushing goroutine only
+type transport struct {
+	network  string
+	address  string
+	stream   bool
+	conn     net.Conn
+	backoff  time.Duration
+	nextDial time.Time
+}
+
+func newTransport(network, address string) (*transport, error) {
+	t := &transport{network: network, address: address}
+	switch network {
+	case "udp", "unixgram":
+	case "tcp", "unix":
+		t.stream = true
+	default:
+		return nil, errors.Errorf("unsupported protocol [%v] of statsd", network)
+	}
+	return t, nil
+}
+
+// Write sends the packet, the lines of the stream protocols must be terminated by a newline
+func (t *transport) Write(p []byte) error {
+	if t.conn == nil {
+		if err := t.dial(); err != nil {
+			return err
+		}
+	}
+	if t.stream {
+		p = append(p, '\n')
+	}
+	_ = t.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := t.conn.Write(p); err != nil {
+		//reconnect on next write
+		t.conn.Close()
+		t.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (t *transport) dial() error {
+	now := time.Now()
+	if now.Before(t.nextDial) {
+		return errors.Errorf("statsd [%v://%v] is not connected, retry after %v", t.network, t.address, t.nextDial.Sub(now))
+	}
+	conn, err := net.DialTimeout(t.network, t.address, dialTimeout)
+	if err != nil {
+		if t.backoff < minBackoff {
+			t.backoff = minBackoff
+		} else if t.backoff *= 2; t.backoff > maxBackoff {
+			t.backoff = maxBackoff
+		}
+		t.nextDial = now.Add(t.backoff)
+		return err
+	}
+	t.conn = conn
+	t.backoff = 0
+	return nil
+}
+
+func (t *transport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}